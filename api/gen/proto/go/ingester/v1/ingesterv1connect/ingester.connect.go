@@ -59,6 +59,12 @@ const (
 	// IngesterServiceMergeProfilesPprofProcedure is the fully-qualified name of the IngesterService's
 	// MergeProfilesPprof RPC.
 	IngesterServiceMergeProfilesPprofProcedure = "/ingester.v1.IngesterService/MergeProfilesPprof"
+	// IngesterServiceMergeSpanProfileProcedure is the fully-qualified name of the IngesterService's
+	// MergeSpanProfile RPC.
+	IngesterServiceMergeSpanProfileProcedure = "/ingester.v1.IngesterService/MergeSpanProfile"
+	// IngesterServiceMergeBlocksStacktracesProcedure is the fully-qualified name of the
+	// IngesterService's MergeBlocksStacktraces RPC.
+	IngesterServiceMergeBlocksStacktracesProcedure = "/ingester.v1.IngesterService/MergeBlocksStacktraces"
 )
 
 // IngesterServiceClient is a client for the ingester.v1.IngesterService service.
@@ -72,6 +78,10 @@ type IngesterServiceClient interface {
 	MergeProfilesStacktraces(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 	MergeProfilesLabels(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]
 	MergeProfilesPprof(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesPprofRequest, v12.MergeProfilesPprofResponse]
+	MergeSpanProfile(context.Context, *connect_go.Request[v12.MergeSpanProfileRequest]) (*connect_go.Response[v12.MergeSpanProfileResponse], error)
+	// MergeBlocksStacktraces merges the stacktraces of the blocks cut by the
+	// ingester that are not yet uploaded to the object storage.
+	MergeBlocksStacktraces(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 }
 
 // NewIngesterServiceClient constructs a client for the ingester.v1.IngesterService service. By
@@ -129,6 +139,16 @@ func NewIngesterServiceClient(httpClient connect_go.HTTPClient, baseURL string,
 			baseURL+IngesterServiceMergeProfilesPprofProcedure,
 			opts...,
 		),
+		mergeSpanProfile: connect_go.NewClient[v12.MergeSpanProfileRequest, v12.MergeSpanProfileResponse](
+			httpClient,
+			baseURL+IngesterServiceMergeSpanProfileProcedure,
+			opts...,
+		),
+		mergeBlocksStacktraces: connect_go.NewClient[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse](
+			httpClient,
+			baseURL+IngesterServiceMergeBlocksStacktracesProcedure,
+			opts...,
+		),
 	}
 }
 
@@ -143,6 +163,8 @@ type ingesterServiceClient struct {
 	mergeProfilesStacktraces *connect_go.Client[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 	mergeProfilesLabels      *connect_go.Client[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]
 	mergeProfilesPprof       *connect_go.Client[v12.MergeProfilesPprofRequest, v12.MergeProfilesPprofResponse]
+	mergeSpanProfile         *connect_go.Client[v12.MergeSpanProfileRequest, v12.MergeSpanProfileResponse]
+	mergeBlocksStacktraces   *connect_go.Client[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 }
 
 // Push calls ingester.v1.IngesterService.Push.
@@ -190,6 +212,16 @@ func (c *ingesterServiceClient) MergeProfilesPprof(ctx context.Context) *connect
 	return c.mergeProfilesPprof.CallBidiStream(ctx)
 }
 
+// MergeSpanProfile calls ingester.v1.IngesterService.MergeSpanProfile.
+func (c *ingesterServiceClient) MergeSpanProfile(ctx context.Context, req *connect_go.Request[v12.MergeSpanProfileRequest]) (*connect_go.Response[v12.MergeSpanProfileResponse], error) {
+	return c.mergeSpanProfile.CallUnary(ctx, req)
+}
+
+// MergeBlocksStacktraces calls ingester.v1.IngesterService.MergeBlocksStacktraces.
+func (c *ingesterServiceClient) MergeBlocksStacktraces(ctx context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse] {
+	return c.mergeBlocksStacktraces.CallBidiStream(ctx)
+}
+
 // IngesterServiceHandler is an implementation of the ingester.v1.IngesterService service.
 type IngesterServiceHandler interface {
 	Push(context.Context, *connect_go.Request[v1.PushRequest]) (*connect_go.Response[v1.PushResponse], error)
@@ -201,6 +233,10 @@ type IngesterServiceHandler interface {
 	MergeProfilesStacktraces(context.Context, *connect_go.BidiStream[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]) error
 	MergeProfilesLabels(context.Context, *connect_go.BidiStream[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]) error
 	MergeProfilesPprof(context.Context, *connect_go.BidiStream[v12.MergeProfilesPprofRequest, v12.MergeProfilesPprofResponse]) error
+	MergeSpanProfile(context.Context, *connect_go.Request[v12.MergeSpanProfileRequest]) (*connect_go.Response[v12.MergeSpanProfileResponse], error)
+	// MergeBlocksStacktraces merges the stacktraces of the blocks cut by the
+	// ingester that are not yet uploaded to the object storage.
+	MergeBlocksStacktraces(context.Context, *connect_go.BidiStream[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]) error
 }
 
 // NewIngesterServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -254,6 +290,16 @@ func NewIngesterServiceHandler(svc IngesterServiceHandler, opts ...connect_go.Ha
 		svc.MergeProfilesPprof,
 		opts...,
 	)
+	ingesterServiceMergeSpanProfileHandler := connect_go.NewUnaryHandler(
+		IngesterServiceMergeSpanProfileProcedure,
+		svc.MergeSpanProfile,
+		opts...,
+	)
+	ingesterServiceMergeBlocksStacktracesHandler := connect_go.NewBidiStreamHandler(
+		IngesterServiceMergeBlocksStacktracesProcedure,
+		svc.MergeBlocksStacktraces,
+		opts...,
+	)
 	return "/ingester.v1.IngesterService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case IngesterServicePushProcedure:
@@ -274,6 +320,10 @@ func NewIngesterServiceHandler(svc IngesterServiceHandler, opts ...connect_go.Ha
 			ingesterServiceMergeProfilesLabelsHandler.ServeHTTP(w, r)
 		case IngesterServiceMergeProfilesPprofProcedure:
 			ingesterServiceMergeProfilesPprofHandler.ServeHTTP(w, r)
+		case IngesterServiceMergeSpanProfileProcedure:
+			ingesterServiceMergeSpanProfileHandler.ServeHTTP(w, r)
+		case IngesterServiceMergeBlocksStacktracesProcedure:
+			ingesterServiceMergeBlocksStacktracesHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -318,3 +368,11 @@ func (UnimplementedIngesterServiceHandler) MergeProfilesLabels(context.Context,
 func (UnimplementedIngesterServiceHandler) MergeProfilesPprof(context.Context, *connect_go.BidiStream[v12.MergeProfilesPprofRequest, v12.MergeProfilesPprofResponse]) error {
 	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.MergeProfilesPprof is not implemented"))
 }
+
+func (UnimplementedIngesterServiceHandler) MergeSpanProfile(context.Context, *connect_go.Request[v12.MergeSpanProfileRequest]) (*connect_go.Response[v12.MergeSpanProfileResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.MergeSpanProfile is not implemented"))
+}
+
+func (UnimplementedIngesterServiceHandler) MergeBlocksStacktraces(context.Context, *connect_go.BidiStream[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.MergeBlocksStacktraces is not implemented"))
+}
@@ -0,0 +1,27 @@
+// Code generated by protoc-gen-connect-go-mux. DO NOT EDIT.
+//
+// Source: opentelemetry/proto/collector/profiles/v1experimental/profiles_service.proto
+
+package v1experimentalconnect
+
+import (
+	connect_go "github.com/bufbuild/connect-go"
+	mux "github.com/gorilla/mux"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect_go.IsAtLeastVersion0_1_0
+
+// RegisterProfilesServiceHandler register an HTTP handler to a mux.Router from the service
+// implementation.
+func RegisterProfilesServiceHandler(mux *mux.Router, svc ProfilesServiceHandler, opts ...connect_go.HandlerOption) {
+	mux.Handle("/opentelemetry.proto.collector.profiles.v1experimental.ProfilesService/Export", connect_go.NewUnaryHandler(
+		"/opentelemetry.proto.collector.profiles.v1experimental.ProfilesService/Export",
+		svc.Export,
+		opts...,
+	))
+}
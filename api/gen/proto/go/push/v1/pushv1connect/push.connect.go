@@ -35,11 +35,17 @@ const (
 const (
 	// PusherServicePushProcedure is the fully-qualified name of the PusherService's Push RPC.
 	PusherServicePushProcedure = "/push.v1.PusherService/Push"
+	// PusherServicePushBatchProcedure is the fully-qualified name of the PusherService's PushBatch RPC.
+	PusherServicePushBatchProcedure = "/push.v1.PusherService/PushBatch"
 )
 
 // PusherServiceClient is a client for the push.v1.PusherService service.
 type PusherServiceClient interface {
 	Push(context.Context, *connect_go.Request[v1.PushRequest]) (*connect_go.Response[v1.PushResponse], error)
+	// PushBatch pushes multiple profiles sharing the same labels in one
+	// request, for example the profiles of all types collected by an agent
+	// in an interval.
+	PushBatch(context.Context, *connect_go.Request[v1.PushBatchRequest]) (*connect_go.Response[v1.PushResponse], error)
 }
 
 // NewPusherServiceClient constructs a client for the push.v1.PusherService service. By default, it
@@ -57,12 +63,18 @@ func NewPusherServiceClient(httpClient connect_go.HTTPClient, baseURL string, op
 			baseURL+PusherServicePushProcedure,
 			opts...,
 		),
+		pushBatch: connect_go.NewClient[v1.PushBatchRequest, v1.PushResponse](
+			httpClient,
+			baseURL+PusherServicePushBatchProcedure,
+			opts...,
+		),
 	}
 }
 
 // pusherServiceClient implements PusherServiceClient.
 type pusherServiceClient struct {
-	push *connect_go.Client[v1.PushRequest, v1.PushResponse]
+	push      *connect_go.Client[v1.PushRequest, v1.PushResponse]
+	pushBatch *connect_go.Client[v1.PushBatchRequest, v1.PushResponse]
 }
 
 // Push calls push.v1.PusherService.Push.
@@ -70,9 +82,18 @@ func (c *pusherServiceClient) Push(ctx context.Context, req *connect_go.Request[
 	return c.push.CallUnary(ctx, req)
 }
 
+// PushBatch calls push.v1.PusherService.PushBatch.
+func (c *pusherServiceClient) PushBatch(ctx context.Context, req *connect_go.Request[v1.PushBatchRequest]) (*connect_go.Response[v1.PushResponse], error) {
+	return c.pushBatch.CallUnary(ctx, req)
+}
+
 // PusherServiceHandler is an implementation of the push.v1.PusherService service.
 type PusherServiceHandler interface {
 	Push(context.Context, *connect_go.Request[v1.PushRequest]) (*connect_go.Response[v1.PushResponse], error)
+	// PushBatch pushes multiple profiles sharing the same labels in one
+	// request, for example the profiles of all types collected by an agent
+	// in an interval.
+	PushBatch(context.Context, *connect_go.Request[v1.PushBatchRequest]) (*connect_go.Response[v1.PushResponse], error)
 }
 
 // NewPusherServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -86,10 +107,17 @@ func NewPusherServiceHandler(svc PusherServiceHandler, opts ...connect_go.Handle
 		svc.Push,
 		opts...,
 	)
+	pusherServicePushBatchHandler := connect_go.NewUnaryHandler(
+		PusherServicePushBatchProcedure,
+		svc.PushBatch,
+		opts...,
+	)
 	return "/push.v1.PusherService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case PusherServicePushProcedure:
 			pusherServicePushHandler.ServeHTTP(w, r)
+		case PusherServicePushBatchProcedure:
+			pusherServicePushBatchHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -102,3 +130,7 @@ type UnimplementedPusherServiceHandler struct{}
 func (UnimplementedPusherServiceHandler) Push(context.Context, *connect_go.Request[v1.PushRequest]) (*connect_go.Response[v1.PushResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("push.v1.PusherService.Push is not implemented"))
 }
+
+func (UnimplementedPusherServiceHandler) PushBatch(context.Context, *connect_go.Request[v1.PushBatchRequest]) (*connect_go.Response[v1.PushResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("push.v1.PusherService.PushBatch is not implemented"))
+}
@@ -49,6 +49,9 @@ const (
 	// QuerierServiceSelectMergeStacktracesProcedure is the fully-qualified name of the QuerierService's
 	// SelectMergeStacktraces RPC.
 	QuerierServiceSelectMergeStacktracesProcedure = "/querier.v1.QuerierService/SelectMergeStacktraces"
+	// QuerierServiceSelectMergeStacktracesStreamProcedure is the fully-qualified name of the
+	// QuerierService's SelectMergeStacktracesStream RPC.
+	QuerierServiceSelectMergeStacktracesStreamProcedure = "/querier.v1.QuerierService/SelectMergeStacktracesStream"
 	// QuerierServiceSelectMergeProfileProcedure is the fully-qualified name of the QuerierService's
 	// SelectMergeProfile RPC.
 	QuerierServiceSelectMergeProfileProcedure = "/querier.v1.QuerierService/SelectMergeProfile"
@@ -57,6 +60,21 @@ const (
 	QuerierServiceSelectSeriesProcedure = "/querier.v1.QuerierService/SelectSeries"
 	// QuerierServiceDiffProcedure is the fully-qualified name of the QuerierService's Diff RPC.
 	QuerierServiceDiffProcedure = "/querier.v1.QuerierService/Diff"
+	// QuerierServiceSelectMergeSpanProfileProcedure is the fully-qualified name of the QuerierService's
+	// SelectMergeSpanProfile RPC.
+	QuerierServiceSelectMergeSpanProfileProcedure = "/querier.v1.QuerierService/SelectMergeSpanProfile"
+	// QuerierServiceSelectTopFunctionsProcedure is the fully-qualified name of the QuerierService's
+	// SelectTopFunctions RPC.
+	QuerierServiceSelectTopFunctionsProcedure = "/querier.v1.QuerierService/SelectTopFunctions"
+	// QuerierServiceSelectCallersCalleesProcedure is the fully-qualified name of the QuerierService's
+	// SelectCallersCallees RPC.
+	QuerierServiceSelectCallersCalleesProcedure = "/querier.v1.QuerierService/SelectCallersCallees"
+	// QuerierServiceSelectFunctionSeriesProcedure is the fully-qualified name of the QuerierService's
+	// SelectFunctionSeries RPC.
+	QuerierServiceSelectFunctionSeriesProcedure = "/querier.v1.QuerierService/SelectFunctionSeries"
+	// QuerierServiceQueryRangeProcedure is the fully-qualified name of the QuerierService's QueryRange
+	// RPC.
+	QuerierServiceQueryRangeProcedure = "/querier.v1.QuerierService/QueryRange"
 )
 
 // QuerierServiceClient is a client for the querier.v1.QuerierService service.
@@ -71,11 +89,23 @@ type QuerierServiceClient interface {
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
 	// SelectMergeStacktraces returns matching profiles aggregated in a flamegraph format. It will combine samples from within the same callstack, with each element being grouped by its function name.
 	SelectMergeStacktraces(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error)
+	// SelectMergeStacktracesStream is SelectMergeStacktraces, with the flamegraph streamed in chunks of levels. The flamegraph is restored by appending the names and the levels of the chunks in order.
+	SelectMergeStacktracesStream(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.ServerStreamForClient[v1.SelectMergeStacktracesResponse], error)
 	// SelectMergeProfile returns matching profiles aggregated in pprof format. It will contain all information stored (so including filenames and line number, if ingested).
 	SelectMergeProfile(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error)
 	// SelectSeries returns a time series for the total sum of the requested profiles.
 	SelectSeries(context.Context, *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error)
 	Diff(context.Context, *connect_go.Request[v1.DiffRequest]) (*connect_go.Response[v1.DiffResponse], error)
+	// SelectMergeSpanProfile returns the samples of matching profiles attributed to the given spans or trace, aggregated in a flamegraph format.
+	SelectMergeSpanProfile(context.Context, *connect_go.Request[v1.SelectMergeSpanProfileRequest]) (*connect_go.Response[v1.SelectMergeSpanProfileResponse], error)
+	// SelectTopFunctions returns the functions of matching profiles with the largest self or total value.
+	SelectTopFunctions(context.Context, *connect_go.Request[v1.SelectTopFunctionsRequest]) (*connect_go.Response[v1.SelectTopFunctionsResponse], error)
+	// SelectCallersCallees returns the direct callers and callees of a function in matching profiles.
+	SelectCallersCallees(context.Context, *connect_go.Request[v1.SelectCallersCalleesRequest]) (*connect_go.Response[v1.SelectCallersCalleesResponse], error)
+	// SelectFunctionSeries returns a time series of the self and total value of a function in matching profiles.
+	SelectFunctionSeries(context.Context, *connect_go.Request[v1.SelectFunctionSeriesRequest]) (*connect_go.Response[v1.SelectFunctionSeriesResponse], error)
+	// QueryRange evaluates a ProfileQL expression over the profile sample totals, like a PromQL range query.
+	QueryRange(context.Context, *connect_go.Request[v1.QueryRangeRequest]) (*connect_go.Response[v1.QueryRangeResponse], error)
 }
 
 // NewQuerierServiceClient constructs a client for the querier.v1.QuerierService service. By
@@ -113,6 +143,11 @@ func NewQuerierServiceClient(httpClient connect_go.HTTPClient, baseURL string, o
 			baseURL+QuerierServiceSelectMergeStacktracesProcedure,
 			opts...,
 		),
+		selectMergeStacktracesStream: connect_go.NewClient[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectMergeStacktracesStreamProcedure,
+			opts...,
+		),
 		selectMergeProfile: connect_go.NewClient[v1.SelectMergeProfileRequest, v12.Profile](
 			httpClient,
 			baseURL+QuerierServiceSelectMergeProfileProcedure,
@@ -128,19 +163,50 @@ func NewQuerierServiceClient(httpClient connect_go.HTTPClient, baseURL string, o
 			baseURL+QuerierServiceDiffProcedure,
 			opts...,
 		),
+		selectMergeSpanProfile: connect_go.NewClient[v1.SelectMergeSpanProfileRequest, v1.SelectMergeSpanProfileResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectMergeSpanProfileProcedure,
+			opts...,
+		),
+		selectTopFunctions: connect_go.NewClient[v1.SelectTopFunctionsRequest, v1.SelectTopFunctionsResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectTopFunctionsProcedure,
+			opts...,
+		),
+		selectCallersCallees: connect_go.NewClient[v1.SelectCallersCalleesRequest, v1.SelectCallersCalleesResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectCallersCalleesProcedure,
+			opts...,
+		),
+		selectFunctionSeries: connect_go.NewClient[v1.SelectFunctionSeriesRequest, v1.SelectFunctionSeriesResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectFunctionSeriesProcedure,
+			opts...,
+		),
+		queryRange: connect_go.NewClient[v1.QueryRangeRequest, v1.QueryRangeResponse](
+			httpClient,
+			baseURL+QuerierServiceQueryRangeProcedure,
+			opts...,
+		),
 	}
 }
 
 // querierServiceClient implements QuerierServiceClient.
 type querierServiceClient struct {
-	profileTypes           *connect_go.Client[v1.ProfileTypesRequest, v1.ProfileTypesResponse]
-	labelValues            *connect_go.Client[v11.LabelValuesRequest, v11.LabelValuesResponse]
-	labelNames             *connect_go.Client[v11.LabelNamesRequest, v11.LabelNamesResponse]
-	series                 *connect_go.Client[v1.SeriesRequest, v1.SeriesResponse]
-	selectMergeStacktraces *connect_go.Client[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse]
-	selectMergeProfile     *connect_go.Client[v1.SelectMergeProfileRequest, v12.Profile]
-	selectSeries           *connect_go.Client[v1.SelectSeriesRequest, v1.SelectSeriesResponse]
-	diff                   *connect_go.Client[v1.DiffRequest, v1.DiffResponse]
+	profileTypes                 *connect_go.Client[v1.ProfileTypesRequest, v1.ProfileTypesResponse]
+	labelValues                  *connect_go.Client[v11.LabelValuesRequest, v11.LabelValuesResponse]
+	labelNames                   *connect_go.Client[v11.LabelNamesRequest, v11.LabelNamesResponse]
+	series                       *connect_go.Client[v1.SeriesRequest, v1.SeriesResponse]
+	selectMergeStacktraces       *connect_go.Client[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse]
+	selectMergeStacktracesStream *connect_go.Client[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse]
+	selectMergeProfile           *connect_go.Client[v1.SelectMergeProfileRequest, v12.Profile]
+	selectSeries                 *connect_go.Client[v1.SelectSeriesRequest, v1.SelectSeriesResponse]
+	diff                         *connect_go.Client[v1.DiffRequest, v1.DiffResponse]
+	selectMergeSpanProfile       *connect_go.Client[v1.SelectMergeSpanProfileRequest, v1.SelectMergeSpanProfileResponse]
+	selectTopFunctions           *connect_go.Client[v1.SelectTopFunctionsRequest, v1.SelectTopFunctionsResponse]
+	selectCallersCallees         *connect_go.Client[v1.SelectCallersCalleesRequest, v1.SelectCallersCalleesResponse]
+	selectFunctionSeries         *connect_go.Client[v1.SelectFunctionSeriesRequest, v1.SelectFunctionSeriesResponse]
+	queryRange                   *connect_go.Client[v1.QueryRangeRequest, v1.QueryRangeResponse]
 }
 
 // ProfileTypes calls querier.v1.QuerierService.ProfileTypes.
@@ -168,6 +234,11 @@ func (c *querierServiceClient) SelectMergeStacktraces(ctx context.Context, req *
 	return c.selectMergeStacktraces.CallUnary(ctx, req)
 }
 
+// SelectMergeStacktracesStream calls querier.v1.QuerierService.SelectMergeStacktracesStream.
+func (c *querierServiceClient) SelectMergeStacktracesStream(ctx context.Context, req *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.ServerStreamForClient[v1.SelectMergeStacktracesResponse], error) {
+	return c.selectMergeStacktracesStream.CallServerStream(ctx, req)
+}
+
 // SelectMergeProfile calls querier.v1.QuerierService.SelectMergeProfile.
 func (c *querierServiceClient) SelectMergeProfile(ctx context.Context, req *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error) {
 	return c.selectMergeProfile.CallUnary(ctx, req)
@@ -183,6 +254,31 @@ func (c *querierServiceClient) Diff(ctx context.Context, req *connect_go.Request
 	return c.diff.CallUnary(ctx, req)
 }
 
+// SelectMergeSpanProfile calls querier.v1.QuerierService.SelectMergeSpanProfile.
+func (c *querierServiceClient) SelectMergeSpanProfile(ctx context.Context, req *connect_go.Request[v1.SelectMergeSpanProfileRequest]) (*connect_go.Response[v1.SelectMergeSpanProfileResponse], error) {
+	return c.selectMergeSpanProfile.CallUnary(ctx, req)
+}
+
+// SelectTopFunctions calls querier.v1.QuerierService.SelectTopFunctions.
+func (c *querierServiceClient) SelectTopFunctions(ctx context.Context, req *connect_go.Request[v1.SelectTopFunctionsRequest]) (*connect_go.Response[v1.SelectTopFunctionsResponse], error) {
+	return c.selectTopFunctions.CallUnary(ctx, req)
+}
+
+// SelectCallersCallees calls querier.v1.QuerierService.SelectCallersCallees.
+func (c *querierServiceClient) SelectCallersCallees(ctx context.Context, req *connect_go.Request[v1.SelectCallersCalleesRequest]) (*connect_go.Response[v1.SelectCallersCalleesResponse], error) {
+	return c.selectCallersCallees.CallUnary(ctx, req)
+}
+
+// SelectFunctionSeries calls querier.v1.QuerierService.SelectFunctionSeries.
+func (c *querierServiceClient) SelectFunctionSeries(ctx context.Context, req *connect_go.Request[v1.SelectFunctionSeriesRequest]) (*connect_go.Response[v1.SelectFunctionSeriesResponse], error) {
+	return c.selectFunctionSeries.CallUnary(ctx, req)
+}
+
+// QueryRange calls querier.v1.QuerierService.QueryRange.
+func (c *querierServiceClient) QueryRange(ctx context.Context, req *connect_go.Request[v1.QueryRangeRequest]) (*connect_go.Response[v1.QueryRangeResponse], error) {
+	return c.queryRange.CallUnary(ctx, req)
+}
+
 // QuerierServiceHandler is an implementation of the querier.v1.QuerierService service.
 type QuerierServiceHandler interface {
 	// ProfileType returns a list of the existing profile types.
@@ -195,11 +291,23 @@ type QuerierServiceHandler interface {
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
 	// SelectMergeStacktraces returns matching profiles aggregated in a flamegraph format. It will combine samples from within the same callstack, with each element being grouped by its function name.
 	SelectMergeStacktraces(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error)
+	// SelectMergeStacktracesStream is SelectMergeStacktraces, with the flamegraph streamed in chunks of levels. The flamegraph is restored by appending the names and the levels of the chunks in order.
+	SelectMergeStacktracesStream(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest], *connect_go.ServerStream[v1.SelectMergeStacktracesResponse]) error
 	// SelectMergeProfile returns matching profiles aggregated in pprof format. It will contain all information stored (so including filenames and line number, if ingested).
 	SelectMergeProfile(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error)
 	// SelectSeries returns a time series for the total sum of the requested profiles.
 	SelectSeries(context.Context, *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error)
 	Diff(context.Context, *connect_go.Request[v1.DiffRequest]) (*connect_go.Response[v1.DiffResponse], error)
+	// SelectMergeSpanProfile returns the samples of matching profiles attributed to the given spans or trace, aggregated in a flamegraph format.
+	SelectMergeSpanProfile(context.Context, *connect_go.Request[v1.SelectMergeSpanProfileRequest]) (*connect_go.Response[v1.SelectMergeSpanProfileResponse], error)
+	// SelectTopFunctions returns the functions of matching profiles with the largest self or total value.
+	SelectTopFunctions(context.Context, *connect_go.Request[v1.SelectTopFunctionsRequest]) (*connect_go.Response[v1.SelectTopFunctionsResponse], error)
+	// SelectCallersCallees returns the direct callers and callees of a function in matching profiles.
+	SelectCallersCallees(context.Context, *connect_go.Request[v1.SelectCallersCalleesRequest]) (*connect_go.Response[v1.SelectCallersCalleesResponse], error)
+	// SelectFunctionSeries returns a time series of the self and total value of a function in matching profiles.
+	SelectFunctionSeries(context.Context, *connect_go.Request[v1.SelectFunctionSeriesRequest]) (*connect_go.Response[v1.SelectFunctionSeriesResponse], error)
+	// QueryRange evaluates a ProfileQL expression over the profile sample totals, like a PromQL range query.
+	QueryRange(context.Context, *connect_go.Request[v1.QueryRangeRequest]) (*connect_go.Response[v1.QueryRangeResponse], error)
 }
 
 // NewQuerierServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -233,6 +341,11 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 		svc.SelectMergeStacktraces,
 		opts...,
 	)
+	querierServiceSelectMergeStacktracesStreamHandler := connect_go.NewServerStreamHandler(
+		QuerierServiceSelectMergeStacktracesStreamProcedure,
+		svc.SelectMergeStacktracesStream,
+		opts...,
+	)
 	querierServiceSelectMergeProfileHandler := connect_go.NewUnaryHandler(
 		QuerierServiceSelectMergeProfileProcedure,
 		svc.SelectMergeProfile,
@@ -248,6 +361,31 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 		svc.Diff,
 		opts...,
 	)
+	querierServiceSelectMergeSpanProfileHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectMergeSpanProfileProcedure,
+		svc.SelectMergeSpanProfile,
+		opts...,
+	)
+	querierServiceSelectTopFunctionsHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectTopFunctionsProcedure,
+		svc.SelectTopFunctions,
+		opts...,
+	)
+	querierServiceSelectCallersCalleesHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectCallersCalleesProcedure,
+		svc.SelectCallersCallees,
+		opts...,
+	)
+	querierServiceSelectFunctionSeriesHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectFunctionSeriesProcedure,
+		svc.SelectFunctionSeries,
+		opts...,
+	)
+	querierServiceQueryRangeHandler := connect_go.NewUnaryHandler(
+		QuerierServiceQueryRangeProcedure,
+		svc.QueryRange,
+		opts...,
+	)
 	return "/querier.v1.QuerierService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case QuerierServiceProfileTypesProcedure:
@@ -260,12 +398,24 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 			querierServiceSeriesHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectMergeStacktracesProcedure:
 			querierServiceSelectMergeStacktracesHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectMergeStacktracesStreamProcedure:
+			querierServiceSelectMergeStacktracesStreamHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectMergeProfileProcedure:
 			querierServiceSelectMergeProfileHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectSeriesProcedure:
 			querierServiceSelectSeriesHandler.ServeHTTP(w, r)
 		case QuerierServiceDiffProcedure:
 			querierServiceDiffHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectMergeSpanProfileProcedure:
+			querierServiceSelectMergeSpanProfileHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectTopFunctionsProcedure:
+			querierServiceSelectTopFunctionsHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectCallersCalleesProcedure:
+			querierServiceSelectCallersCalleesHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectFunctionSeriesProcedure:
+			querierServiceSelectFunctionSeriesHandler.ServeHTTP(w, r)
+		case QuerierServiceQueryRangeProcedure:
+			querierServiceQueryRangeHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -295,6 +445,10 @@ func (UnimplementedQuerierServiceHandler) SelectMergeStacktraces(context.Context
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeStacktraces is not implemented"))
 }
 
+func (UnimplementedQuerierServiceHandler) SelectMergeStacktracesStream(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest], *connect_go.ServerStream[v1.SelectMergeStacktracesResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeStacktracesStream is not implemented"))
+}
+
 func (UnimplementedQuerierServiceHandler) SelectMergeProfile(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeProfile is not implemented"))
 }
@@ -306,3 +460,23 @@ func (UnimplementedQuerierServiceHandler) SelectSeries(context.Context, *connect
 func (UnimplementedQuerierServiceHandler) Diff(context.Context, *connect_go.Request[v1.DiffRequest]) (*connect_go.Response[v1.DiffResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.Diff is not implemented"))
 }
+
+func (UnimplementedQuerierServiceHandler) SelectMergeSpanProfile(context.Context, *connect_go.Request[v1.SelectMergeSpanProfileRequest]) (*connect_go.Response[v1.SelectMergeSpanProfileResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeSpanProfile is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) SelectTopFunctions(context.Context, *connect_go.Request[v1.SelectTopFunctionsRequest]) (*connect_go.Response[v1.SelectTopFunctionsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectTopFunctions is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) SelectCallersCallees(context.Context, *connect_go.Request[v1.SelectCallersCalleesRequest]) (*connect_go.Response[v1.SelectCallersCalleesResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectCallersCallees is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) SelectFunctionSeries(context.Context, *connect_go.Request[v1.SelectFunctionSeriesRequest]) (*connect_go.Response[v1.SelectFunctionSeriesResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectFunctionSeries is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) QueryRange(context.Context, *connect_go.Request[v1.QueryRangeRequest]) (*connect_go.Response[v1.QueryRangeResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.QueryRange is not implemented"))
+}
@@ -44,6 +44,11 @@ func RegisterQuerierServiceHandler(mux *mux.Router, svc QuerierServiceHandler, o
 		svc.SelectMergeStacktraces,
 		opts...,
 	))
+	mux.Handle("/querier.v1.QuerierService/SelectMergeStacktracesStream", connect_go.NewServerStreamHandler(
+		"/querier.v1.QuerierService/SelectMergeStacktracesStream",
+		svc.SelectMergeStacktracesStream,
+		opts...,
+	))
 	mux.Handle("/querier.v1.QuerierService/SelectMergeProfile", connect_go.NewUnaryHandler(
 		"/querier.v1.QuerierService/SelectMergeProfile",
 		svc.SelectMergeProfile,
@@ -59,4 +64,29 @@ func RegisterQuerierServiceHandler(mux *mux.Router, svc QuerierServiceHandler, o
 		svc.Diff,
 		opts...,
 	))
+	mux.Handle("/querier.v1.QuerierService/SelectMergeSpanProfile", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectMergeSpanProfile",
+		svc.SelectMergeSpanProfile,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/SelectTopFunctions", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectTopFunctions",
+		svc.SelectTopFunctions,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/SelectCallersCallees", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectCallersCallees",
+		svc.SelectCallersCallees,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/SelectFunctionSeries", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectFunctionSeries",
+		svc.SelectFunctionSeries,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/QueryRange", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/QueryRange",
+		svc.QueryRange,
+		opts...,
+	))
 }
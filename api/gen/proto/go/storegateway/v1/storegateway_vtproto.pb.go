@@ -33,6 +33,7 @@ type StoreGatewayServiceClient interface {
 	MergeProfilesLabels(ctx context.Context, opts ...grpc.CallOption) (StoreGatewayService_MergeProfilesLabelsClient, error)
 	MergeProfilesPprof(ctx context.Context, opts ...grpc.CallOption) (StoreGatewayService_MergeProfilesPprofClient, error)
 	Series(ctx context.Context, in *v1.SeriesRequest, opts ...grpc.CallOption) (*v1.SeriesResponse, error)
+	MergeSpanProfile(ctx context.Context, in *v1.MergeSpanProfileRequest, opts ...grpc.CallOption) (*v1.MergeSpanProfileResponse, error)
 }
 
 type storeGatewayServiceClient struct {
@@ -145,6 +146,15 @@ func (c *storeGatewayServiceClient) Series(ctx context.Context, in *v1.SeriesReq
 	return out, nil
 }
 
+func (c *storeGatewayServiceClient) MergeSpanProfile(ctx context.Context, in *v1.MergeSpanProfileRequest, opts ...grpc.CallOption) (*v1.MergeSpanProfileResponse, error) {
+	out := new(v1.MergeSpanProfileResponse)
+	err := c.cc.Invoke(ctx, "/storegateway.v1.StoreGatewayService/MergeSpanProfile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StoreGatewayServiceServer is the server API for StoreGatewayService service.
 // All implementations must embed UnimplementedStoreGatewayServiceServer
 // for forward compatibility
@@ -153,6 +163,7 @@ type StoreGatewayServiceServer interface {
 	MergeProfilesLabels(StoreGatewayService_MergeProfilesLabelsServer) error
 	MergeProfilesPprof(StoreGatewayService_MergeProfilesPprofServer) error
 	Series(context.Context, *v1.SeriesRequest) (*v1.SeriesResponse, error)
+	MergeSpanProfile(context.Context, *v1.MergeSpanProfileRequest) (*v1.MergeSpanProfileResponse, error)
 	mustEmbedUnimplementedStoreGatewayServiceServer()
 }
 
@@ -172,6 +183,9 @@ func (UnimplementedStoreGatewayServiceServer) MergeProfilesPprof(StoreGatewaySer
 func (UnimplementedStoreGatewayServiceServer) Series(context.Context, *v1.SeriesRequest) (*v1.SeriesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Series not implemented")
 }
+func (UnimplementedStoreGatewayServiceServer) MergeSpanProfile(context.Context, *v1.MergeSpanProfileRequest) (*v1.MergeSpanProfileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeSpanProfile not implemented")
+}
 func (UnimplementedStoreGatewayServiceServer) mustEmbedUnimplementedStoreGatewayServiceServer() {}
 
 // UnsafeStoreGatewayServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -281,6 +295,24 @@ func _StoreGatewayService_Series_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StoreGatewayService_MergeSpanProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(v1.MergeSpanProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreGatewayServiceServer).MergeSpanProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storegateway.v1.StoreGatewayService/MergeSpanProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreGatewayServiceServer).MergeSpanProfile(ctx, req.(*v1.MergeSpanProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StoreGatewayService_ServiceDesc is the grpc.ServiceDesc for StoreGatewayService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -292,6 +324,10 @@ var StoreGatewayService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Series",
 			Handler:    _StoreGatewayService_Series_Handler,
 		},
+		{
+			MethodName: "MergeSpanProfile",
+			Handler:    _StoreGatewayService_MergeSpanProfile_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -46,6 +46,9 @@ const (
 	// StoreGatewayServiceSeriesProcedure is the fully-qualified name of the StoreGatewayService's
 	// Series RPC.
 	StoreGatewayServiceSeriesProcedure = "/storegateway.v1.StoreGatewayService/Series"
+	// StoreGatewayServiceMergeSpanProfileProcedure is the fully-qualified name of the
+	// StoreGatewayService's MergeSpanProfile RPC.
+	StoreGatewayServiceMergeSpanProfileProcedure = "/storegateway.v1.StoreGatewayService/MergeSpanProfile"
 )
 
 // StoreGatewayServiceClient is a client for the storegateway.v1.StoreGatewayService service.
@@ -54,6 +57,7 @@ type StoreGatewayServiceClient interface {
 	MergeProfilesLabels(context.Context) *connect_go.BidiStreamForClient[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]
 	MergeProfilesPprof(context.Context) *connect_go.BidiStreamForClient[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
+	MergeSpanProfile(context.Context, *connect_go.Request[v1.MergeSpanProfileRequest]) (*connect_go.Response[v1.MergeSpanProfileResponse], error)
 }
 
 // NewStoreGatewayServiceClient constructs a client for the storegateway.v1.StoreGatewayService
@@ -86,6 +90,11 @@ func NewStoreGatewayServiceClient(httpClient connect_go.HTTPClient, baseURL stri
 			baseURL+StoreGatewayServiceSeriesProcedure,
 			opts...,
 		),
+		mergeSpanProfile: connect_go.NewClient[v1.MergeSpanProfileRequest, v1.MergeSpanProfileResponse](
+			httpClient,
+			baseURL+StoreGatewayServiceMergeSpanProfileProcedure,
+			opts...,
+		),
 	}
 }
 
@@ -95,6 +104,7 @@ type storeGatewayServiceClient struct {
 	mergeProfilesLabels      *connect_go.Client[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]
 	mergeProfilesPprof       *connect_go.Client[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]
 	series                   *connect_go.Client[v1.SeriesRequest, v1.SeriesResponse]
+	mergeSpanProfile         *connect_go.Client[v1.MergeSpanProfileRequest, v1.MergeSpanProfileResponse]
 }
 
 // MergeProfilesStacktraces calls storegateway.v1.StoreGatewayService.MergeProfilesStacktraces.
@@ -117,6 +127,11 @@ func (c *storeGatewayServiceClient) Series(ctx context.Context, req *connect_go.
 	return c.series.CallUnary(ctx, req)
 }
 
+// MergeSpanProfile calls storegateway.v1.StoreGatewayService.MergeSpanProfile.
+func (c *storeGatewayServiceClient) MergeSpanProfile(ctx context.Context, req *connect_go.Request[v1.MergeSpanProfileRequest]) (*connect_go.Response[v1.MergeSpanProfileResponse], error) {
+	return c.mergeSpanProfile.CallUnary(ctx, req)
+}
+
 // StoreGatewayServiceHandler is an implementation of the storegateway.v1.StoreGatewayService
 // service.
 type StoreGatewayServiceHandler interface {
@@ -124,6 +139,7 @@ type StoreGatewayServiceHandler interface {
 	MergeProfilesLabels(context.Context, *connect_go.BidiStream[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]) error
 	MergeProfilesPprof(context.Context, *connect_go.BidiStream[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]) error
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
+	MergeSpanProfile(context.Context, *connect_go.Request[v1.MergeSpanProfileRequest]) (*connect_go.Response[v1.MergeSpanProfileResponse], error)
 }
 
 // NewStoreGatewayServiceHandler builds an HTTP handler from the service implementation. It returns
@@ -152,6 +168,11 @@ func NewStoreGatewayServiceHandler(svc StoreGatewayServiceHandler, opts ...conne
 		svc.Series,
 		opts...,
 	)
+	storeGatewayServiceMergeSpanProfileHandler := connect_go.NewUnaryHandler(
+		StoreGatewayServiceMergeSpanProfileProcedure,
+		svc.MergeSpanProfile,
+		opts...,
+	)
 	return "/storegateway.v1.StoreGatewayService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case StoreGatewayServiceMergeProfilesStacktracesProcedure:
@@ -162,6 +183,8 @@ func NewStoreGatewayServiceHandler(svc StoreGatewayServiceHandler, opts ...conne
 			storeGatewayServiceMergeProfilesPprofHandler.ServeHTTP(w, r)
 		case StoreGatewayServiceSeriesProcedure:
 			storeGatewayServiceSeriesHandler.ServeHTTP(w, r)
+		case StoreGatewayServiceMergeSpanProfileProcedure:
+			storeGatewayServiceMergeSpanProfileHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -186,3 +209,7 @@ func (UnimplementedStoreGatewayServiceHandler) MergeProfilesPprof(context.Contex
 func (UnimplementedStoreGatewayServiceHandler) Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.Series is not implemented"))
 }
+
+func (UnimplementedStoreGatewayServiceHandler) MergeSpanProfile(context.Context, *connect_go.Request[v1.MergeSpanProfileRequest]) (*connect_go.Response[v1.MergeSpanProfileResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.MergeSpanProfile is not implemented"))
+}
@@ -39,4 +39,9 @@ func RegisterStoreGatewayServiceHandler(mux *mux.Router, svc StoreGatewayService
 		svc.Series,
 		opts...,
 	))
+	mux.Handle("/storegateway.v1.StoreGatewayService/MergeSpanProfile", connect_go.NewUnaryHandler(
+		"/storegateway.v1.StoreGatewayService/MergeSpanProfile",
+		svc.MergeSpanProfile,
+		opts...,
+	))
 }
@@ -51,7 +51,13 @@ func main() {
 	queryCmd := app.Command("query", "Query profile store.")
 	queryMergeCmd := queryCmd.Command("merge", "Request merged profile.")
 	queryMergeOutput := queryMergeCmd.Flag("output", "How to output the result, examples: console, raw, pprof=./my.pprof").Default("console").String()
+	queryMergeClosestTo := queryMergeCmd.Flag("closest-to", "Request the single profile closest to this time, as it was ingested, instead of the merge of the profiles in the time range.").String()
 	queryMergeParams := addQueryMergeParams(queryMergeCmd)
+	queryDiffCmd := queryCmd.Command("diff", "Request diff flamegraph of two queries.")
+	queryDiffOutput := queryDiffCmd.Flag("output", "How to output the result, examples: console, raw").Default("console").String()
+	queryDiffParams := addQueryDiffParams(queryDiffCmd)
+	queryTopCmd := queryCmd.Command("top", "Request the functions with the largest self or total value.")
+	queryTopParams := addQueryTopParams(queryTopCmd)
 	querySeriesCmd := queryCmd.Command("series", "Request series labels.")
 	querySeriesParams := addQuerySeriesParams(querySeriesCmd)
 
@@ -79,7 +85,15 @@ func main() {
 			}
 		}
 	case queryMergeCmd.FullCommand():
-		if err := queryMerge(ctx, queryMergeParams, *queryMergeOutput); err != nil {
+		if err := queryMerge(ctx, queryMergeParams, *queryMergeOutput, *queryMergeClosestTo); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryDiffCmd.FullCommand():
+		if err := queryDiff(ctx, queryDiffParams, *queryDiffOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryTopCmd.FullCommand():
+		if err := queryTop(ctx, queryTopParams); err != nil {
 			os.Exit(checkError(err))
 		}
 	case querySeriesCmd.FullCommand():
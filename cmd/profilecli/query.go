@@ -157,22 +157,32 @@ func addQueryMergeParams(queryCmd commander) *queryMergeParams {
 	return params
 }
 
-func queryMerge(ctx context.Context, params *queryMergeParams, outputFlag string) (err error) {
+func queryMerge(ctx context.Context, params *queryMergeParams, outputFlag string, closestToFlag string) (err error) {
 	from, to, err := params.parseFromTo()
 	if err != nil {
 		return err
 	}
-
-	level.Info(logger).Log("msg", "query aggregated profile from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
-
-	qc := params.phlareClient.queryClient()
-
-	resp, err := qc.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+	req := &querierv1.SelectMergeProfileRequest{
 		ProfileTypeID: params.ProfileType,
 		Start:         from.UnixMilli(),
 		End:           to.UnixMilli(),
 		LabelSelector: params.Query,
-	}))
+	}
+
+	if closestToFlag != "" {
+		closestTo, err := parseTime(closestToFlag)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse closest-to")
+		}
+		req.ClosestTo = closestTo.UnixMilli()
+		level.Info(logger).Log("msg", "query closest profile from profile store", "url", params.URL, "from", from, "to", to, "closest_to", closestTo, "query", params.Query, "type", params.ProfileType)
+	} else {
+		level.Info(logger).Log("msg", "query aggregated profile from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
+	}
+
+	qc := params.phlareClient.queryClient()
+
+	resp, err := qc.SelectMergeProfile(ctx, connect.NewRequest(req))
 
 	if err != nil {
 		return errors.Wrap(err, "failed to query")
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/k0kubun/pp/v3"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+type queryDiffParams struct {
+	*phlareClient
+	Left  *queryParams
+	Right *queryParams
+	// RightProfile is the path of a pprof file compared against the
+	// baseline query, instead of the comparison query.
+	RightProfile string
+	ProfileType  string
+	MaxNodes     int64
+	Top          int
+}
+
+func addQueryDiffParams(queryCmd commander) *queryDiffParams {
+	params := new(queryDiffParams)
+	params.phlareClient = addPhlareClient(queryCmd)
+	params.Left = &queryParams{phlareClient: params.phlareClient}
+	params.Right = &queryParams{phlareClient: params.phlareClient}
+
+	queryCmd.Flag("left-from", "Beginning of the baseline query.").Default("now-2h").StringVar(&params.Left.From)
+	queryCmd.Flag("left-to", "End of the baseline query.").Default("now-1h").StringVar(&params.Left.To)
+	queryCmd.Flag("left-query", "Label selector of the baseline query.").Default("{}").StringVar(&params.Left.Query)
+	queryCmd.Flag("right-from", "Beginning of the comparison query.").Default("now-1h").StringVar(&params.Right.From)
+	queryCmd.Flag("right-to", "End of the comparison query.").Default("now").StringVar(&params.Right.To)
+	queryCmd.Flag("right-query", "Label selector of the comparison query.").Default("{}").StringVar(&params.Right.Query)
+	queryCmd.Flag("right-profile", "Path of a pprof file compared against the baseline query, instead of the comparison query. For example, the profile of a benchmark.").StringVar(&params.RightProfile)
+	queryCmd.Flag("profile-type", "Profile type to query.").Default("process_cpu:cpu:nanoseconds:cpu:nanoseconds").StringVar(&params.ProfileType)
+	queryCmd.Flag("max-nodes", "Maximum number of nodes of the diff flamegraph, 0 uses the server default.").Default("0").Int64Var(&params.MaxNodes)
+	queryCmd.Flag("top", "Number of functions printed to the console, sorted by the absolute self difference.").Default("20").IntVar(&params.Top)
+	return params
+}
+
+func (p *queryDiffParams) request(q *queryParams) (*querierv1.SelectMergeStacktracesRequest, error) {
+	from, to, err := q.parseFromTo()
+	if err != nil {
+		return nil, err
+	}
+	req := &querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: p.ProfileType,
+		LabelSelector: q.Query,
+		Start:         from.UnixMilli(),
+		End:           to.UnixMilli(),
+	}
+	if p.MaxNodes > 0 {
+		req.MaxNodes = &p.MaxNodes
+	}
+	return req, nil
+}
+
+// diffRequest builds the request comparing the baseline query with either
+// the comparison query or the right profile.
+func (p *queryDiffParams) diffRequest() (*querierv1.DiffRequest, error) {
+	left, err := p.request(p.Left)
+	if err != nil {
+		return nil, errors.Wrap(err, "left")
+	}
+	req := &querierv1.DiffRequest{Left: left}
+	if p.RightProfile != "" {
+		if req.RightProfile, err = os.ReadFile(p.RightProfile); err != nil {
+			return nil, errors.Wrap(err, "right profile")
+		}
+		return req, nil
+	}
+	if req.Right, err = p.request(p.Right); err != nil {
+		return nil, errors.Wrap(err, "right")
+	}
+	return req, nil
+}
+
+func queryDiff(ctx context.Context, params *queryDiffParams, outputFlag string) (err error) {
+	req, err := params.diffRequest()
+	if err != nil {
+		return err
+	}
+	if req.Right == nil {
+		level.Info(logger).Log("msg", "query diff flamegraph of a profile from profile store", "url", params.URL, "type", params.ProfileType,
+			"left_query", req.Left.LabelSelector, "right_profile", params.RightProfile)
+	} else {
+		level.Info(logger).Log("msg", "query diff flamegraph from profile store", "url", params.URL, "type", params.ProfileType,
+			"left_query", req.Left.LabelSelector, "right_query", req.Right.LabelSelector)
+	}
+
+	qc := params.phlareClient.queryClient()
+	resp, err := qc.Diff(ctx, connect.NewRequest(req))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	switch outputFlag {
+	case outputConsole:
+		return printDiff(output(ctx), resp.Msg.Flamegraph, params.Top)
+	case outputRaw:
+		mypp := pp.New()
+		mypp.SetColoringEnabled(isatty.IsTerminal(os.Stdout.Fd()))
+		mypp.SetExportedOnly(true)
+		mypp.Print(resp.Msg)
+		return nil
+	}
+	return errors.Errorf("unknown output %s", outputFlag)
+}
+
+type functionDiff struct {
+	name        string
+	left, right int64
+}
+
+func (d functionDiff) delta() int64 { return d.right - d.left }
+
+// diffFunctions sums up the self values of the flamegraph diff functions.
+func diffFunctions(fg *querierv1.FlameGraphDiff) []functionDiff {
+	byName := make(map[int64]*functionDiff)
+	for _, l := range fg.Levels {
+		// See NewFlamegraphDiff for the layout of the level values.
+		for i := 0; i+6 < len(l.Values); i += 7 {
+			idx := l.Values[i+6]
+			f, ok := byName[idx]
+			if !ok {
+				f = &functionDiff{name: fg.Names[idx]}
+				byName[idx] = f
+			}
+			f.left += l.Values[i+2]
+			f.right += l.Values[i+5]
+		}
+	}
+	res := make([]functionDiff, 0, len(byName))
+	for _, f := range byName {
+		res = append(res, *f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		di, dj := abs(res[i].delta()), abs(res[j].delta())
+		if di != dj {
+			return di > dj
+		}
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+func printDiff(w io.Writer, fg *querierv1.FlameGraphDiff, top int) error {
+	fmt.Fprintf(w, "left total: %d, right total: %d\n\n", fg.LeftTicks, fg.RightTicks)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tLEFT SELF\tRIGHT SELF\tDIFF")
+	functions := diffFunctions(fg)
+	if top > 0 && len(functions) > top {
+		functions = functions[:top]
+	}
+	for _, f := range functions {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\n", f.name, f.left, f.right, f.delta())
+	}
+	return tw.Flush()
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
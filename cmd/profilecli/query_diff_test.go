@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func parseQueryDiffParams(t *testing.T, args ...string) *queryDiffParams {
+	t.Helper()
+	app := kingpin.New("profilecli", "")
+	params := addQueryDiffParams(app.Command("diff", ""))
+	_, err := app.Parse(append([]string{"diff"}, args...))
+	require.NoError(t, err)
+	return params
+}
+
+func Test_queryDiffParams_diffRequest(t *testing.T) {
+	leftFrom := time.Date(2023, 7, 14, 10, 0, 0, 0, time.UTC)
+	rightFrom := leftFrom.Add(time.Hour)
+	params := parseQueryDiffParams(t,
+		"--left-from", leftFrom.Format(time.RFC3339),
+		"--left-to", rightFrom.Format(time.RFC3339),
+		"--left-query", `{service_name="foo",version="1"}`,
+		"--right-from", rightFrom.Format(time.RFC3339),
+		"--right-to", rightFrom.Add(time.Hour).Format(time.RFC3339),
+		"--right-query", `{service_name="foo",version="2"}`,
+		"--profile-type", "memory:inuse_space:bytes:space:bytes",
+		"--max-nodes", "512",
+	)
+
+	req, err := params.diffRequest()
+	require.NoError(t, err)
+	require.Equal(t, `{service_name="foo",version="1"}`, req.Left.LabelSelector)
+	require.Equal(t, "memory:inuse_space:bytes:space:bytes", req.Left.ProfileTypeID)
+	require.Equal(t, leftFrom.UnixMilli(), req.Left.Start)
+	require.Equal(t, rightFrom.UnixMilli(), req.Left.End)
+	require.Equal(t, int64(512), req.Left.GetMaxNodes())
+	require.Equal(t, `{service_name="foo",version="2"}`, req.Right.LabelSelector)
+	require.Equal(t, "memory:inuse_space:bytes:space:bytes", req.Right.ProfileTypeID)
+	require.Equal(t, rightFrom.UnixMilli(), req.Right.Start)
+	require.Equal(t, rightFrom.Add(time.Hour).UnixMilli(), req.Right.End)
+	require.Equal(t, int64(512), req.Right.GetMaxNodes())
+	require.Nil(t, req.RightProfile)
+}
+
+func Test_queryDiffParams_diffRequest_Defaults(t *testing.T) {
+	params := parseQueryDiffParams(t)
+
+	req, err := params.diffRequest()
+	require.NoError(t, err)
+	require.Equal(t, "{}", req.Left.LabelSelector)
+	require.Equal(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds", req.Left.ProfileTypeID)
+	require.Equal(t, req.Left.End, req.Right.Start)
+	require.Less(t, req.Left.Start, req.Left.End)
+	require.Less(t, req.Right.Start, req.Right.End)
+	// The server default applies.
+	require.Nil(t, req.Left.MaxNodes)
+	require.Nil(t, req.Right.MaxNodes)
+}
+
+func Test_queryDiffParams_diffRequest_RightProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	require.NoError(t, os.WriteFile(path, []byte("profile"), 0o644))
+	params := parseQueryDiffParams(t, "--right-profile", path, "--right-query", `{service_name="bar"}`)
+
+	req, err := params.diffRequest()
+	require.NoError(t, err)
+	require.Equal(t, []byte("profile"), req.RightProfile)
+	require.Nil(t, req.Right)
+
+	params = parseQueryDiffParams(t, "--right-profile", filepath.Join(t.TempDir(), "missing.pprof"))
+	_, err = params.diffRequest()
+	require.ErrorContains(t, err, "right profile")
+}
+
+func Test_queryDiffParams_diffRequest_InvalidTime(t *testing.T) {
+	params := parseQueryDiffParams(t, "--right-from", "yesterday")
+	_, err := params.diffRequest()
+	require.ErrorContains(t, err, "right")
+}
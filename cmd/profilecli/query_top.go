@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+type queryTopParams struct {
+	*queryMergeParams
+	Limit   int64
+	OrderBy string
+}
+
+func addQueryTopParams(queryCmd commander) *queryTopParams {
+	params := new(queryTopParams)
+	params.queryMergeParams = addQueryMergeParams(queryCmd)
+	queryCmd.Flag("limit", "Number of functions returned.").Default("20").Int64Var(&params.Limit)
+	queryCmd.Flag("order-by", "Order the functions by their self or total value.").Default("self").EnumVar(&params.OrderBy, "self", "total")
+	return params
+}
+
+func queryTop(ctx context.Context, params *queryTopParams) (err error) {
+	from, to, err := params.parseFromTo()
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "query top functions from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
+
+	orderBy := querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_SELF
+	if params.OrderBy == "total" {
+		orderBy = querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_TOTAL
+	}
+	qc := params.phlareClient.queryClient()
+	resp, err := qc.SelectTopFunctions(ctx, connect.NewRequest(&querierv1.SelectTopFunctionsRequest{
+		ProfileTypeID: params.ProfileType,
+		Start:         from.UnixMilli(),
+		End:           to.UnixMilli(),
+		LabelSelector: params.Query,
+		Limit:         &params.Limit,
+		OrderBy:       orderBy,
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	tw := tabwriter.NewWriter(output(ctx), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SELF\tSELF%\tTOTAL\tTOTAL%\tFUNCTION\tLOCATION")
+	total := float64(resp.Msg.Total)
+	if total == 0 {
+		total = 1
+	}
+	for _, f := range resp.Msg.Functions {
+		location := f.Filename
+		if location != "" && f.StartLine > 0 {
+			location = fmt.Sprintf("%s:%d", location, f.StartLine)
+		}
+		fmt.Fprintf(tw, "%d\t%.2f%%\t%d\t%.2f%%\t%s\t%s\n",
+			f.Self, 100*float64(f.Self)/total, f.Total, 100*float64(f.Total)/total, f.Name, location)
+	}
+	return tw.Flush()
+}
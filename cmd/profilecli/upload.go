@@ -12,12 +12,14 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
 	"github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/util/compression"
 )
 
-func (c *phlareClient) pusherClient() pushv1connect.PusherServiceClient {
+func (c *phlareClient) pusherClient(opts ...connect.ClientOption) pushv1connect.PusherServiceClient {
 	return pushv1connect.NewPusherServiceClient(
 		c.httpClient(),
 		c.URL,
+		opts...,
 	)
 }
 
@@ -25,6 +27,7 @@ type uploadParams struct {
 	*phlareClient
 	paths       []string
 	extraLabels map[string]string
+	compression string
 }
 
 func addUploadParams(cmd commander) *uploadParams {
@@ -37,11 +40,16 @@ func addUploadParams(cmd commander) *uploadParams {
 
 	cmd.Arg("path", "Path(s) to profile(s) to upload").Required().ExistingFilesVar(&params.paths)
 	cmd.Flag("extra-labels", "Add additional labels to the profile(s)").Default("job=profilecli-upload").StringMapVar(&params.extraLabels)
+	cmd.Flag("compression", "Compression of the upload request: none, gzip, zstd or lz4.").Default(compression.None).EnumVar(&params.compression, compression.None, compression.Gzip, compression.Zstd, compression.LZ4)
 	return params
 }
 
 func upload(ctx context.Context, params *uploadParams) (err error) {
-	pc := params.phlareClient.pusherClient()
+	compressionOpt, err := compression.ClientOption(params.compression)
+	if err != nil {
+		return err
+	}
+	pc := params.phlareClient.pusherClient(compressionOpt)
 
 	lblStrings := make([]string, 0, len(params.extraLabels)*2)
 	for key, value := range params.extraLabels {
@@ -25,22 +25,30 @@ import (
 	"github.com/grafana/pyroscope/public"
 
 	"github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1/ingesterv1connect"
+	profilesv1experimentalconnect "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/collector/profiles/v1experimental/v1experimentalconnect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	statusv1 "github.com/grafana/pyroscope/api/gen/proto/go/status/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/storegateway/v1/storegatewayv1connect"
 	"github.com/grafana/pyroscope/api/openapiv2"
+	"github.com/grafana/pyroscope/pkg/audit"
+	"github.com/grafana/pyroscope/pkg/billing"
 	"github.com/grafana/pyroscope/pkg/distributor"
 	"github.com/grafana/pyroscope/pkg/frontend"
 	"github.com/grafana/pyroscope/pkg/frontend/frontendpb/frontendpbconnect"
 	"github.com/grafana/pyroscope/pkg/ingester"
+	"github.com/grafana/pyroscope/pkg/ingester/otlp"
 	"github.com/grafana/pyroscope/pkg/ingester/pyroscope"
 	"github.com/grafana/pyroscope/pkg/querier"
+	"github.com/grafana/pyroscope/pkg/regression"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb/schedulerpbconnect"
 	"github.com/grafana/pyroscope/pkg/storegateway"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/compression"
 	"github.com/grafana/pyroscope/pkg/util/gziphandler"
+	"github.com/grafana/pyroscope/pkg/validation"
 	"github.com/grafana/pyroscope/pkg/validation/exporter"
 )
 
@@ -48,31 +56,48 @@ type Config struct {
 	// The following configs are injected by the upstream caller.
 	HTTPAuthMiddleware middleware.Interface `yaml:"-"`
 	GrpcAuthMiddleware connect.Option       `yaml:"-"`
-	BaseURL            string               `yaml:"base-url"`
+	// GrpcPublicAuthMiddleware wraps GrpcAuthMiddleware for the services
+	// receiving the requests of the clients, if set.
+	GrpcPublicAuthMiddleware connect.Option `yaml:"-"`
+	// AuditLogger records the query and admin operations, if set.
+	AuditLogger *audit.Logger `yaml:"-"`
+	BaseURL     string        `yaml:"base-url"`
+
+	Ingest GuardConfig `yaml:"ingest"`
+	Query  GuardConfig `yaml:"query"`
+	Admin  GuardConfig `yaml:"admin"`
 }
 
 type API struct {
-	server             *server.Server
-	httpAuthMiddleware middleware.Interface
-	grpcGatewayMux     *grpcgw.ServeMux
-	grpcAuthMiddleware connect.Option
-	grpcLogMiddleware  connect.Option
+	server                   *server.Server
+	httpAuthMiddleware       middleware.Interface
+	grpcGatewayMux           *grpcgw.ServeMux
+	grpcAuthMiddleware       connect.Option
+	grpcLogMiddleware        connect.Option
+	grpcPublicAuthMiddleware connect.Option
+	auditLogger              *audit.Logger
+	guards                   map[tenant.Scope]*guard
 
 	cfg       Config
 	logger    log.Logger
 	indexPage *IndexPageContent
+	// The ring status pages of the components, by ring name.
+	rings map[string]http.Handler
 }
 
 func New(cfg Config, s *server.Server, grpcGatewayMux *grpcgw.ServeMux, logger log.Logger) (*API, error) {
 	api := &API{
-		cfg:                cfg,
-		httpAuthMiddleware: cfg.HTTPAuthMiddleware,
-		server:             s,
-		logger:             logger,
-		indexPage:          NewIndexPageContent(),
-		grpcGatewayMux:     grpcGatewayMux,
-		grpcAuthMiddleware: cfg.GrpcAuthMiddleware,
-		grpcLogMiddleware:  connect.WithInterceptors(util.NewLogInterceptor(logger)),
+		cfg:                      cfg,
+		httpAuthMiddleware:       cfg.HTTPAuthMiddleware,
+		server:                   s,
+		logger:                   logger,
+		indexPage:                NewIndexPageContent(),
+		rings:                    make(map[string]http.Handler),
+		grpcGatewayMux:           grpcGatewayMux,
+		grpcAuthMiddleware:       cfg.GrpcAuthMiddleware,
+		grpcPublicAuthMiddleware: cfg.GrpcPublicAuthMiddleware,
+		auditLogger:              cfg.AuditLogger,
+		grpcLogMiddleware:        connect.WithInterceptors(util.NewLogInterceptor(logger)),
 	}
 
 	// If no authentication middleware is present in the config, use the default authentication middleware.
@@ -80,6 +105,19 @@ func New(cfg Config, s *server.Server, grpcGatewayMux *grpcgw.ServeMux, logger l
 		api.httpAuthMiddleware = middleware.AuthenticateUser
 	}
 
+	api.guards = make(map[tenant.Scope]*guard, 3)
+	for scope, guardCfg := range map[tenant.Scope]GuardConfig{
+		tenant.ScopeIngest: cfg.Ingest,
+		tenant.ScopeQuery:  cfg.Query,
+		tenant.ScopeAdmin:  cfg.Admin,
+	} {
+		g, err := newGuard(guardCfg)
+		if err != nil {
+			return nil, err
+		}
+		api.guards[scope] = g
+	}
+
 	return api, nil
 }
 
@@ -91,6 +129,62 @@ func (a *API) RegisterRoute(path string, handler http.Handler, auth, gzipEnabled
 	a.newRoute(path, handler, false, auth, gzipEnabled, methods...)
 }
 
+// publicHandlerOptions returns the options of the services receiving the
+// requests of the clients, which require the scope.
+func (a *API) publicHandlerOptions(scope tenant.Scope, opts ...connect.HandlerOption) []connect.HandlerOption {
+	auth := []connect.HandlerOption{a.grpcAuthMiddleware}
+	if a.grpcPublicAuthMiddleware != nil {
+		// It runs first, as it sets the tenant ID of the request.
+		auth = []connect.HandlerOption{a.grpcPublicAuthMiddleware, a.grpcAuthMiddleware}
+	}
+	if op, ok := auditOperation(scope); ok && a.auditLogger != nil {
+		// The requests rejected for their scope are recorded too.
+		auth = append(auth, connect.WithInterceptors(a.auditLogger.Interceptor(op)))
+	}
+	auth = append(auth, a.guards[scope].handlerOptions()...)
+	auth = append(auth, connect.WithInterceptors(tenant.NewScopeInterceptor(scope)))
+	return append(auth, opts...)
+}
+
+// scoped rejects the requests to the handler not allowed the scope.
+func (a *API) scoped(scope tenant.Scope, h http.Handler) http.Handler {
+	h = a.guarded(scope, tenant.NewScopeMiddleware(scope).Wrap(h))
+	if op, ok := auditOperation(scope); ok {
+		h = a.audited(op, h)
+	}
+	return h
+}
+
+// guarded enforces the limits of the kind of endpoints on the handler.
+func (a *API) guarded(scope tenant.Scope, h http.Handler) http.Handler {
+	return a.guards[scope].Wrap(h)
+}
+
+// admin enforces the limits of the admin endpoints on the handler.
+func (a *API) admin(h http.Handler) http.Handler {
+	return a.guarded(tenant.ScopeAdmin, h)
+}
+
+// audited records the requests to the handler in the audit log, if enabled.
+func (a *API) audited(op audit.Operation, h http.Handler) http.Handler {
+	if a.auditLogger == nil {
+		return h
+	}
+	return a.auditLogger.HTTPMiddleware(op).Wrap(h)
+}
+
+// auditOperation returns the operation the requests of the scope are
+// recorded as. The ingestion requests are not recorded.
+func auditOperation(scope tenant.Scope) (audit.Operation, bool) {
+	switch scope {
+	case tenant.ScopeQuery:
+		return audit.OperationQuery, true
+	case tenant.ScopeAdmin:
+		return audit.OperationAdmin, true
+	}
+	return "", false
+}
+
 func (a *API) RegisterRoutesWithPrefix(prefix string, handler http.Handler, auth, gzipEnabled bool, methods ...string) {
 	level.Debug(a.logger).Log("msg", "api: registering route", "methods", strings.Join(methods, ","), "prefix", prefix, "auth", auth, "gzip", gzipEnabled)
 	a.newRoute(prefix, handler, true, auth, gzipEnabled, methods...)
@@ -120,7 +214,8 @@ func (a *API) newRoute(path string, handler http.Handler, isPrefix, auth, gzip b
 // RegisterAPI registers the standard endpoints associated with a running Pyroscope.
 func (a *API) RegisterAPI(statusService statusv1.StatusServiceServer) error {
 	// register admin page
-	a.RegisterRoute("/admin", indexHandler("", a.indexPage), false, true, "GET")
+	a.RegisterRoute("/admin", a.admin(indexHandler("", a.indexPage)), false, true, "GET")
+	a.registerRingsAPI()
 	// expose openapiv2 definition
 	openapiv2Handler, err := openapiv2.Handler()
 	if err != nil {
@@ -130,10 +225,10 @@ func (a *API) RegisterAPI(statusService statusv1.StatusServiceServer) error {
 	a.indexPage.AddLinks(openAPIDefinitionWeight, "OpenAPI definition", []IndexPageLink{
 		{Desc: "Swagger JSON", Path: "/api/swagger.json"},
 	})
-	// register grpc-gateway api
-	a.RegisterRoutesWithPrefix("/api", a.grpcGatewayMux, false, true, "GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS")
+	// register the usage of the tenants, reported by every component
+	a.RegisterRoute("/api/v1/tenant_usage", a.scoped(tenant.ScopeAdmin, validation.TenantUsageHandler()), true, true, "GET")
 	// register fgprof
-	a.RegisterRoute("/debug/fgprof", fgprof.Handler(), false, true, "GET")
+	a.RegisterRoute("/debug/fgprof", a.admin(fgprof.Handler()), false, true, "GET")
 	// register static assets
 	a.RegisterRoutesWithPrefix("/static/", http.FileServer(http.FS(staticFiles)), false, true, "GET")
 	// register ui
@@ -162,11 +257,17 @@ func (a *API) RegisterAPI(statusService statusv1.StatusServiceServer) error {
 	return nil
 }
 
+// RegisterCatchAll registers the endpoints matching the requests by prefix.
+// It must be called after the endpoints of the modules are registered, as
+// the routes are matched in the order they are registered.
 func (a *API) RegisterCatchAll() error {
 	uiIndexHandler, err := public.NewIndexHandler(a.cfg.BaseURL)
 	if err != nil {
 		return fmt.Errorf("unable to initialize the ui: %w", err)
 	}
+	// register grpc-gateway api, which would shadow the endpoints of the
+	// modules under /api
+	a.RegisterRoutesWithPrefix("/api", a.admin(a.grpcGatewayMux), false, true, "GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS")
 	// Serve index to all other pages
 	a.RegisterRoutesWithPrefix("/", uiIndexHandler, false, true, "GET")
 
@@ -178,9 +279,11 @@ func (a *API) RegisterCatchAll() error {
 }
 
 // RegisterRuntimeConfig registers the endpoints associates with the runtime configuration
-func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc, userLimitsHandler http.HandlerFunc) {
-	a.RegisterRoute("/runtime_config", runtimeConfigHandler, false, true, "GET")
-	a.RegisterRoute("/api/v1/tenant_limits", userLimitsHandler, true, true, "GET")
+func (a *API) RegisterRuntimeConfig(runtimeConfigHandler, validateHandler, userLimitsHandler, limitsInEffectHandler http.HandlerFunc) {
+	a.RegisterRoute("/runtime_config", a.admin(runtimeConfigHandler), false, true, "GET")
+	a.RegisterRoute("/runtime_config/validate", a.scoped(tenant.ScopeAdmin, validateHandler), true, true, "POST")
+	a.RegisterRoute("/api/v1/tenant_limits", a.scoped(tenant.ScopeAdmin, userLimitsHandler), true, true, "GET")
+	a.RegisterRoute(adminAPIPrefix+"/tenants/{tenant}/limits", a.scoped(tenant.ScopeAdmin, limitsInEffectHandler), true, true, "GET")
 	a.indexPage.AddLinks(runtimeConfigWeight, "Current runtime config", []IndexPageLink{
 		{Desc: "Entire runtime config (including overrides)", Path: "/runtime_config"},
 		{Desc: "Only values that differ from the defaults", Path: "/runtime_config?mode=diff"},
@@ -189,7 +292,8 @@ func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc, userL
 
 // RegisterOverridesExporter registers the endpoints associated with the overrides exporter.
 func (a *API) RegisterOverridesExporter(oe *exporter.OverridesExporter) {
-	a.RegisterRoute("/overrides-exporter/ring", http.HandlerFunc(oe.RingHandler), false, true, "GET", "POST")
+	a.RegisterRoute("/overrides-exporter/ring", a.admin(http.HandlerFunc(oe.RingHandler)), false, true, "GET", "POST")
+	a.addRing("overrides-exporter", http.HandlerFunc(oe.RingHandler))
 	a.indexPage.AddLinks(defaultWeight, "Overrides-exporter", []IndexPageLink{
 		{Desc: "Ring status", Path: "/overrides-exporter/ring"},
 	})
@@ -197,11 +301,19 @@ func (a *API) RegisterOverridesExporter(oe *exporter.OverridesExporter) {
 
 // RegisterDistributor registers the endpoints associated with the distributor.
 func (a *API) RegisterDistributor(d *distributor.Distributor) {
-	pyroscopeHandler := pyroscope.NewPyroscopeIngestHandler(d, a.logger)
-	a.RegisterRoute("/ingest", pyroscopeHandler, true, true, "POST")
-	a.RegisterRoute("/pyroscope/ingest", pyroscopeHandler, true, true, "POST")
-	pushv1connect.RegisterPusherServiceHandler(a.server.HTTP, d, a.grpcAuthMiddleware)
-	a.RegisterRoute("/distributor/ring", d, false, true, "GET", "POST")
+	// The guard limits the size of the compressed body, the decompressed one
+	// is limited the same.
+	maxBodySize := a.cfg.Ingest.MaxRequestBodySize
+	pyroscopeHandler := compression.DecodeRequestBody(pyroscope.NewPyroscopeIngestHandler(d, a.logger), maxBodySize)
+	a.RegisterRoute("/ingest", a.scoped(tenant.ScopeIngest, pyroscopeHandler), true, true, "POST")
+	a.RegisterRoute("/pyroscope/ingest", a.scoped(tenant.ScopeIngest, pyroscopeHandler), true, true, "POST")
+	pushv1connect.RegisterPusherServiceHandler(a.server.HTTP, d, a.publicHandlerOptions(tenant.ScopeIngest, compression.HandlerOption())...)
+	otlpHandler := otlp.NewOTLPIngestHandler(d, a.logger)
+	a.RegisterRoute("/otlp/v1/profiles", a.scoped(tenant.ScopeIngest, compression.DecodeRequestBody(otlpHandler, maxBodySize)), true, true, "POST")
+	profilesv1experimentalconnect.RegisterProfilesServiceHandler(a.server.HTTP, otlpHandler, a.publicHandlerOptions(tenant.ScopeIngest, compression.HandlerOption())...)
+	a.RegisterRoute("/distributor/ring", a.admin(d), false, true, "GET", "POST")
+	a.addRing("distributor", d)
+	a.RegisterRoute("/api/v1/tenant/{tenant}/ingestion-status", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(d.IngestionStatusHandler)), true, true, "GET")
 	a.indexPage.AddLinks(defaultWeight, "Distributor", []IndexPageLink{
 		{Desc: "Ring status", Path: "/distributor/ring"},
 	})
@@ -209,7 +321,7 @@ func (a *API) RegisterDistributor(d *distributor.Distributor) {
 
 // RegisterMemberlistKV registers the endpoints associated with the memberlist KV store.
 func (a *API) RegisterMemberlistKV(pathPrefix string, kvs *memberlist.KVInitService) {
-	a.RegisterRoute("/memberlist", MemberlistStatusHandler(pathPrefix, kvs), false, true, "GET")
+	a.RegisterRoute("/memberlist", a.admin(MemberlistStatusHandler(pathPrefix, kvs)), false, true, "GET")
 	a.indexPage.AddLinks(memberlistWeight, "Memberlist", []IndexPageLink{
 		{Desc: "Status", Path: "/memberlist"},
 	})
@@ -217,27 +329,32 @@ func (a *API) RegisterMemberlistKV(pathPrefix string, kvs *memberlist.KVInitServ
 
 // RegisterRing registers the ring UI page associated with the distributor for writes.
 func (a *API) RegisterRing(r http.Handler) {
-	a.RegisterRoute("/ring", r, false, true, "GET", "POST")
+	a.RegisterRoute("/ring", a.admin(r), false, true, "GET", "POST")
+	a.addRing("ingester", r)
 	a.indexPage.AddLinks(defaultWeight, "Ingester", []IndexPageLink{
 		{Desc: "Ring status", Path: "/ring"},
 	})
 }
 
 // RegisterQuerier registers the endpoints associated with the querier.
-func (a *API) RegisterQuerier(svc querierv1connect.QuerierServiceHandler) {
-	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, a.grpcAuthMiddleware, a.grpcLogMiddleware)
+func (a *API) RegisterQuerier(svc querierv1connect.QuerierServiceHandler, opts ...connect.HandlerOption) {
+	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, a.publicHandlerOptions(tenant.ScopeQuery, append([]connect.HandlerOption{a.grpcLogMiddleware}, opts...)...)...)
 }
 
-func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceClient) {
+func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceHandler) {
 	handlers := querier.NewHTTPHandlers(client)
-	a.RegisterRoute("/pyroscope/render", http.HandlerFunc(handlers.Render), true, true, "GET")
-	a.RegisterRoute("/pyroscope/render-diff", http.HandlerFunc(handlers.RenderDiff), true, true, "GET")
-	a.RegisterRoute("/pyroscope/label-values", http.HandlerFunc(handlers.LabelValues), true, true, "GET")
+	a.RegisterRoute("/pyroscope/render", a.scoped(tenant.ScopeQuery, http.HandlerFunc(handlers.Render)), true, true, "GET")
+	a.RegisterRoute("/pyroscope/render-diff", a.scoped(tenant.ScopeQuery, http.HandlerFunc(handlers.RenderDiff)), true, true, "GET")
+	a.RegisterRoute("/pyroscope/label-values", a.scoped(tenant.ScopeQuery, http.HandlerFunc(handlers.LabelValues)), true, true, "GET")
 }
 
 // RegisterIngester registers the endpoints associated with the ingester.
 func (a *API) RegisterIngester(svc *ingester.Ingester) {
 	ingesterv1connect.RegisterIngesterServiceHandler(a.server.HTTP, svc, a.grpcAuthMiddleware)
+
+	a.RegisterRoute("/ingester/head/snapshot", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.SnapshotHandler)), true, true, "POST")
+	a.RegisterRoute("/ingester/head/restore", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.RestoreHandler)), true, true, "POST")
+	a.RegisterRoute("/ingester/prepare-shutdown", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.PrepareShutdownHandler)), true, true, "GET", "POST", "DELETE")
 }
 
 func (a *API) RegisterStoreGateway(svc *storegateway.StoreGateway) {
@@ -247,14 +364,38 @@ func (a *API) RegisterStoreGateway(svc *storegateway.StoreGateway) {
 		{Desc: "Ring status", Path: "/store-gateway/ring"},
 		{Desc: "Tenants & Blocks", Path: "/store-gateway/tenants"},
 	})
-	a.RegisterRoute("/store-gateway/ring", http.HandlerFunc(svc.RingHandler), false, true, "GET", "POST")
-	a.RegisterRoute("/store-gateway/tenants", http.HandlerFunc(svc.TenantsHandler), false, true, "GET")
-	a.RegisterRoute("/store-gateway/tenant/{tenant}/blocks", http.HandlerFunc(svc.BlocksHandler), false, true, "GET")
+	a.RegisterRoute("/store-gateway/ring", a.admin(http.HandlerFunc(svc.RingHandler)), false, true, "GET", "POST")
+	a.addRing("store-gateway", http.HandlerFunc(svc.RingHandler))
+	a.RegisterRoute("/store-gateway/tenants", a.admin(http.HandlerFunc(svc.TenantsHandler)), false, true, "GET")
+	a.RegisterRoute("/store-gateway/tenant/{tenant}/blocks", a.admin(http.HandlerFunc(svc.BlocksHandler)), false, true, "GET")
+	a.RegisterRoute(adminAPIPrefix+"/tenants/{tenant}/blocks", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.LoadedBlocksHandler)), true, true, "GET")
+	a.RegisterRoute("/store-gateway/tenant/{tenant}/delete", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.DeleteTenantHandler)), true, true, "POST")
+	a.RegisterRoute("/store-gateway/tenant/{tenant}/delete", a.admin(http.HandlerFunc(svc.DeleteTenantStatusHandler)), false, true, "GET")
+	a.RegisterRoute("/api/v1/tenant_storage_usage", a.scoped(tenant.ScopeAdmin, http.HandlerFunc(svc.TenantStorageUsageHandler)), true, true, "GET")
+}
+
+// RegisterRegressionDetector registers the endpoints associated with the regression detector.
+func (a *API) RegisterRegressionDetector(d *regression.Detector) {
+	a.RegisterRoute("/regression-detector/findings", a.scoped(tenant.ScopeQuery, http.HandlerFunc(d.FindingsHandler)), true, true, "GET")
+}
+
+// RegisterBillingExporter registers the endpoints associated with the billing exporter.
+func (a *API) RegisterBillingExporter(e *billing.Exporter) {
+	a.indexPage.AddLinks(defaultWeight, "Billing exporter", []IndexPageLink{
+		{Desc: "Usage records", Path: "/billing-exporter/records"},
+	})
+	a.RegisterRoute("/billing-exporter/records", a.admin(http.HandlerFunc(e.RecordsHandler)), false, true, "GET")
 }
 
 // RegisterQueryFrontend registers the endpoints associated with the query frontend.
 func (a *API) RegisterQueryFrontend(frontendSvc *frontend.Frontend) {
 	frontendpbconnect.RegisterFrontendForQuerierHandler(a.server.HTTP, frontendSvc, a.grpcAuthMiddleware)
+	if l := frontendSvc.SlowQueryLog(); l != nil {
+		a.indexPage.AddLinks(defaultWeight, "Query-frontend", []IndexPageLink{
+			{Desc: "Slow queries", Path: "/query-frontend/slow-queries"},
+		})
+		a.RegisterRoute("/query-frontend/slow-queries", a.admin(http.HandlerFunc(l.Handler)), false, true, "GET")
+	}
 }
 
 // RegisterQueryScheduler registers the endpoints associated with the query scheduler.
@@ -266,4 +407,16 @@ func (a *API) RegisterQueryScheduler(s *scheduler.Scheduler) {
 // RegisterFlags registers api-related flags.
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	fs.StringVar(&cfg.BaseURL, "api.base-url", "", "base URL for when the server is behind a reverse proxy with a different path")
+	cfg.Ingest.RegisterFlagsWithPrefix("api.ingest.", "ingestion", fs)
+	cfg.Query.RegisterFlagsWithPrefix("api.query.", "query", fs)
+	cfg.Admin.RegisterFlagsWithPrefix("api.admin.", "admin", fs)
+}
+
+func (cfg *Config) Validate() error {
+	for _, g := range []GuardConfig{cfg.Ingest, cfg.Query, cfg.Admin} {
+		if err := g.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
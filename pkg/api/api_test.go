@@ -6,19 +6,35 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
+	"github.com/grafana/dskit/kv"
 	"github.com/grafana/dskit/server"
 	grpcgw "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
 	"google.golang.org/protobuf/encoding/protojson"
 
+	"github.com/grafana/pyroscope/pkg/distributor"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+	"github.com/grafana/pyroscope/pkg/storegateway"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/gziphandler"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 func getHostnameAndRandomPort(t *testing.T) (string, int) {
@@ -175,3 +191,113 @@ func TestApiGzip(t *testing.T) {
 	t.Run("compressed with gzip", func(t *testing.T) {
 	})
 }
+
+// newTestAPI returns an API with the standard endpoints registered. The
+// catch-all endpoints must be registered after the ones of the modules,
+// like the server does.
+func newTestAPI(t *testing.T) *API {
+	cfg := getServerConfig(t)
+	cfg.Registerer = prometheus.NewRegistry()
+	srv, err := server.New(cfg)
+	require.NoError(t, err)
+	go func() { _ = srv.Run() }()
+	t.Cleanup(srv.Stop)
+
+	a, err := New(Config{
+		GrpcAuthMiddleware: connect.WithInterceptors(tenant.NewAuthInterceptor(true)),
+	}, srv, grpcgw.NewServeMux(), log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, a.RegisterAPI(nil))
+	return a
+}
+
+// serveTenantRequest serves the request made by the tenant with the router
+// of the server.
+func serveTenantRequest(a *API, method, path, tenantID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Scope-OrgID", tenantID)
+	rec := httptest.NewRecorder()
+	a.server.HTTP.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApiDistributorRoutes(t *testing.T) {
+	a := newTestAPI(t)
+	d, err := distributor.New(distributor.Config{DistributorRing: util.CommonRingConfig{
+		KVStore:      kv.Config{Store: "inmemory"},
+		InstanceID:   "foo",
+		InstancePort: 8080,
+		InstanceAddr: "127.0.0.1",
+		ListenPort:   8080,
+	}}, testhelper.NewMockRing(nil, 1), nil, validation.MockDefaultOverrides(), nil, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	a.RegisterDistributor(d)
+	require.NoError(t, a.RegisterCatchAll())
+
+	rec := serveTenantRequest(a, http.MethodGet, "/api/v1/tenant/team-a/ingestion-status", "team-a")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var status distributor.IngestionStatusResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	require.Equal(t, "team-a", status.Tenant)
+
+	rec = serveTenantRequest(a, http.MethodGet, "/api/v1/tenant/team-b/ingestion-status", "team-a")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestApiTenantUsageRoute(t *testing.T) {
+	a := newTestAPI(t)
+	require.NoError(t, a.RegisterCatchAll())
+
+	rec := serveTenantRequest(a, http.MethodGet, "/api/v1/tenant_usage", "team-a")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var usage validation.TenantUsageResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&usage))
+}
+
+func TestApiStoreGatewayRoutes(t *testing.T) {
+	a := newTestAPI(t)
+	var cfg storegateway.Config
+	fs := flag.NewFlagSet("", flag.PanicOnError)
+	cfg.RegisterFlags(fs, log.NewNopLogger())
+	require.NoError(t, fs.Parse(nil))
+	cfg.ShardingRing.Ring.KVStore.Store = "inmemory"
+	cfg.BucketStoreConfig.SyncDir = t.TempDir()
+	bkt := phlareobj.NewBucket(objstore.NewInMemBucket())
+	g, err := storegateway.NewStoreGateway(cfg, bkt, nil, validation.MockDefaultOverrides(), log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	a.RegisterStoreGateway(g)
+	require.NoError(t, a.RegisterCatchAll())
+
+	require.NoError(t, bucketindex.WriteIndex(context.Background(), phlareobj.NewPrefixedBucket(bkt, "team-a/phlaredb"), "", nil, &bucketindex.Index{
+		Version:   bucketindex.IndexVersion3,
+		UpdatedAt: 1000,
+	}))
+	rec := serveTenantRequest(a, http.MethodGet, "/api/v1/tenant_storage_usage", "team-a")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var usage bucketindex.Usage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&usage))
+	require.Equal(t, int64(1000), usage.UpdatedAt)
+
+	rec = serveTenantRequest(a, http.MethodGet, "/admin/api/v1/tenants/team-a/blocks", "team-a")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serveTenantRequest(a, http.MethodGet, "/admin/api/v1/tenants/team-b/blocks", "team-a")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestApiRuntimeConfigRoutes(t *testing.T) {
+	a := newTestAPI(t)
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	defaults := validation.MockDefaultLimits()
+	a.RegisterRuntimeConfig(h, h, h, validation.TenantLimitsInEffectHandler(*defaults, validation.NewMockTenantLimits(nil)))
+	require.NoError(t, a.RegisterCatchAll())
+
+	rec := serveTenantRequest(a, http.MethodGet, "/admin/api/v1/tenants/team-a/limits", "team-a")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var limits validation.TenantLimitsInEffectResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&limits))
+	require.Equal(t, "team-a", limits.TenantID)
+	require.False(t, limits.Overridden)
+
+	rec = serveTenantRequest(a, http.MethodGet, "/admin/api/v1/tenants/team-b/limits", "team-a")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/flagext"
+)
+
+var (
+	errAddressNotAllowed   = errors.New("the client address is not allowed")
+	errTooManyRequests     = errors.New("too many concurrent requests")
+	errRequestBodyTooLarge = errors.New("the request body is too large")
+)
+
+// GuardConfig limits the requests to a kind of endpoints, so they can be
+// exposed without a reverse proxy in front of Pyroscope.
+type GuardConfig struct {
+	AllowedCIDRs          flagext.StringSliceCSV `yaml:"allowed_cidrs" category:"advanced"`
+	MaxRequestBodySize    int64                  `yaml:"max_request_body_size" category:"advanced"`
+	MaxConcurrentRequests int                    `yaml:"max_concurrent_requests" category:"advanced"`
+}
+
+func (cfg *GuardConfig) RegisterFlagsWithPrefix(prefix, kind string, f *flag.FlagSet) {
+	f.Var(&cfg.AllowedCIDRs, prefix+"allowed-cidrs", fmt.Sprintf("Comma-separated list of the CIDRs of the clients allowed to make %s requests. All the clients are allowed if empty.", kind))
+	f.Int64Var(&cfg.MaxRequestBodySize, prefix+"max-request-body-size", 0, fmt.Sprintf("Maximum size in bytes of the body of the %s requests. 0 to disable.", kind))
+	f.IntVar(&cfg.MaxConcurrentRequests, prefix+"max-concurrent-requests", 0, fmt.Sprintf("Maximum number of %s requests, including streams, handled concurrently. Requests above it are rejected. 0 to disable.", kind))
+}
+
+func (cfg *GuardConfig) Validate() error {
+	_, err := parseCIDRs(cfg.AllowedCIDRs)
+	return err
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// guard enforces a GuardConfig.
+type guard struct {
+	allowed     []*net.IPNet
+	maxBodySize int64
+	// inflight is nil if the concurrent requests are not limited.
+	inflight chan struct{}
+}
+
+func newGuard(cfg GuardConfig) (*guard, error) {
+	allowed, err := parseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	g := &guard{allowed: allowed, maxBodySize: cfg.MaxRequestBodySize}
+	if cfg.MaxConcurrentRequests > 0 {
+		g.inflight = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	return g, nil
+}
+
+func (g *guard) allow(remoteAddr string) bool {
+	if len(g.allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range g.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *guard) acquire() bool {
+	if g.inflight == nil {
+		return true
+	}
+	select {
+	case g.inflight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (g *guard) release() {
+	if g.inflight != nil {
+		<-g.inflight
+	}
+}
+
+func (g *guard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.allow(r.RemoteAddr) {
+			http.Error(w, errAddressNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+		if g.maxBodySize > 0 {
+			if r.ContentLength > g.maxBodySize {
+				http.Error(w, errRequestBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, g.maxBodySize)
+		}
+		if !g.acquire() {
+			http.Error(w, errTooManyRequests.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer g.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlerOptions returns the options enforcing the guard on the services.
+func (g *guard) handlerOptions() []connect.HandlerOption {
+	opts := []connect.HandlerOption{connect.WithInterceptors(g)}
+	if g.maxBodySize > 0 {
+		opts = append(opts, connect.WithReadMaxBytes(int(g.maxBodySize)))
+	}
+	return opts
+}
+
+func (g *guard) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		if !g.allow(req.Peer().Addr) {
+			return nil, connect.NewError(connect.CodePermissionDenied, errAddressNotAllowed)
+		}
+		if !g.acquire() {
+			return nil, connect.NewError(connect.CodeResourceExhausted, errTooManyRequests)
+		}
+		defer g.release()
+		return next(ctx, req)
+	}
+}
+
+func (g *guard) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (g *guard) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if !g.allow(conn.Peer().Addr) {
+			return connect.NewError(connect.CodePermissionDenied, errAddressNotAllowed)
+		}
+		if !g.acquire() {
+			return connect.NewError(connect.CodeResourceExhausted, errTooManyRequests)
+		}
+		defer g.release()
+		return next(ctx, conn)
+	}
+}
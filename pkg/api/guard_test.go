@@ -0,0 +1,57 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Guard(t *testing.T) {
+	g, err := newGuard(GuardConfig{
+		AllowedCIDRs:          []string{"10.0.0.0/8", "::1/128"},
+		MaxRequestBodySize:    8,
+		MaxConcurrentRequests: 1,
+	})
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	handler := g.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.URL.Path == "/block" {
+			<-block
+		}
+	}))
+	serve := func(remoteAddr, path, body string) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve("10.1.2.3:1234", "/", "small"))
+	require.Equal(t, http.StatusOK, serve("[::1]:1234", "/", "small"))
+	require.Equal(t, http.StatusForbidden, serve("192.168.1.1:1234", "/", "small"))
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve("10.1.2.3:1234", "/", "too large body"))
+
+	done := make(chan int)
+	go func() { done <- serve("10.1.2.3:1234", "/block", "") }()
+	require.Eventually(t, func() bool { return len(g.inflight) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusTooManyRequests, serve("10.1.2.3:1234", "/", ""))
+	close(block)
+	require.Equal(t, http.StatusOK, <-done)
+	require.Equal(t, http.StatusOK, serve("10.1.2.3:1234", "/", ""))
+}
+
+func Test_GuardConfig_Validate(t *testing.T) {
+	require.NoError(t, (&GuardConfig{AllowedCIDRs: []string{"10.0.0.0/8"}}).Validate())
+	require.Error(t, (&GuardConfig{AllowedCIDRs: []string{"10.0.0.1"}}).Validate())
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// adminAPIPrefix is the prefix of the JSON admin API. It's not under /api,
+// which is routed to the gRPC gateway.
+const adminAPIPrefix = "/admin/api/v1"
+
+// addRing registers the ring status page of a component, so the members of
+// the ring are served as JSON by the admin API.
+func (a *API) addRing(name string, h http.Handler) {
+	a.rings[name] = h
+}
+
+// registerRingsAPI registers the endpoints of the admin API listing the
+// rings, and their members.
+func (a *API) registerRingsAPI() {
+	a.RegisterRoute(adminAPIPrefix+"/rings", a.admin(http.HandlerFunc(a.ringsHandler)), false, true, "GET")
+	a.RegisterRoute(adminAPIPrefix+"/rings/{ring}", a.admin(http.HandlerFunc(a.ringMembersHandler)), false, true, "GET")
+	a.indexPage.AddLinks(defaultWeight, "Admin API", []IndexPageLink{
+		{Desc: "Rings", Path: adminAPIPrefix + "/rings"},
+	})
+}
+
+// ringsHandler returns the names of the rings of the components the process
+// runs.
+func (a *API) ringsHandler(w http.ResponseWriter, _ *http.Request) {
+	names := make([]string, 0, len(a.rings))
+	for name := range a.rings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	util.WriteJSONResponse(w, struct {
+		Rings []string `json:"rings"`
+	}{Rings: names})
+}
+
+// ringMembersHandler returns the members of the ring, with their state,
+// address, zone, and heartbeat timestamp. The tokens are included with
+// tokens=true.
+func (a *API) ringMembersHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["ring"]
+	h, ok := a.rings[name]
+	if !ok {
+		httputil.ErrorWithStatus(w, fmt.Errorf("unknown ring %q", name), http.StatusNotFound)
+		return
+	}
+	// The ring status pages are rendered as JSON when it's accepted. They
+	// are only rendered as HTML when the ring can't be read, for example
+	// when the component is not running yet, or its ring is disabled.
+	req := r.Clone(r.Context())
+	req.Header.Set("Accept", "application/json")
+	resp := newBufferedResponse()
+	h.ServeHTTP(resp, req)
+	if !strings.HasPrefix(resp.header.Get("Content-Type"), "application/json") {
+		httputil.ErrorWithStatus(w, fmt.Errorf("ring %q is not available", name), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body.Bytes())
+}
+
+// bufferedResponse keeps the response of a handler in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (r *bufferedResponse) Header() http.Header { return r.header }
+
+func (r *bufferedResponse) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+func (r *bufferedResponse) WriteHeader(status int) { r.status = status }
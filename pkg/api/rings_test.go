@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+func TestRingsAPI(t *testing.T) {
+	a := &API{rings: make(map[string]http.Handler)}
+	a.addRing("ingester", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.RenderHTTPResponse(w, struct {
+			Shards []string `json:"shards"`
+		}{Shards: []string{"ingester-1"}}, nil, r)
+	}))
+	a.addRing("store-gateway", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.WriteHTMLResponse(w, "Store gateway is not running yet.")
+	}))
+
+	w := httptest.NewRecorder()
+	a.ringsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/api/v1/rings", nil))
+	require.JSONEq(t, `{"rings":["ingester","store-gateway"]}`, w.Body.String())
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/admin/api/v1/rings/"+name, nil)
+		a.ringMembersHandler(w, mux.SetURLVars(r, map[string]string{"ring": name}))
+		return w
+	}
+	w = get("ingester")
+	require.Equal(t, http.StatusOK, w.Code)
+	var members struct {
+		Shards []string `json:"shards"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&members))
+	require.Equal(t, []string{"ingester-1"}, members.Shards)
+
+	require.Equal(t, http.StatusServiceUnavailable, get("store-gateway").Code)
+	require.Equal(t, http.StatusNotFound, get("distributor").Code)
+}
@@ -0,0 +1,242 @@
+// Package audit records who ran the query and admin operations, and for
+// which tenant.
+package audit
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/middleware"
+	"google.golang.org/protobuf/proto"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+type Config struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "audit-log.enabled", false, "If enabled, the query and admin operations are recorded to the audit log: who ran them, for which tenant, the selector and time range of the queries, and the size of the results.")
+	f.StringVar(&cfg.File, "audit-log.file", "", "Path of the file the audit log is appended to, as JSON lines. If empty, it's written to the standard output.")
+}
+
+// Operation is the kind of the operations recorded.
+type Operation string
+
+const (
+	OperationQuery Operation = "query"
+	OperationAdmin Operation = "admin"
+)
+
+// Logger writes the audit log, separately from the logs of the server.
+type Logger struct {
+	logger log.Logger
+	closer io.Closer
+}
+
+// New opens the audit log of the config.
+func New(cfg Config) (*Logger, error) {
+	if cfg.File == "" {
+		return NewLogger(os.Stdout), nil
+	}
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLogger(f)
+	l.closer = f
+	return l, nil
+}
+
+// NewLogger returns a Logger writing the audit log to w.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{
+		logger: log.With(log.NewJSONLogger(log.NewSyncWriter(w)), "ts", log.DefaultTimestampUTC),
+	}
+}
+
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// log records the operation, with the identity of the client and the tenant
+// of the request.
+func (l *Logger) log(ctx context.Context, op Operation, keyvals ...interface{}) {
+	subject := "anonymous"
+	if id, ok := tenant.IdentityFromContext(ctx); ok {
+		subject = id.Subject
+	}
+	tenantID, _ := tenant.ExtractTenantIDFromContext(ctx)
+	_ = l.logger.Log(append([]interface{}{"operation", op, "subject", subject, "tenant", tenantID}, keyvals...)...)
+}
+
+// HTTPMiddleware records the requests to the handler. It must be wrapped by
+// the authentication middleware, as the requests are recorded with their
+// tenant.
+func (l *Logger) HTTPMiddleware(op Operation) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			l.log(r.Context(), op,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"params", r.URL.RawQuery,
+				"remote_addr", r.RemoteAddr,
+				"status", rw.status,
+				"response_bytes", rw.bytes,
+				"duration", time.Since(begin),
+			)
+		})
+	})
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Interceptor records the requests handled by the server. It must run after
+// the tenant authentication interceptor, as the requests are recorded with
+// their tenant.
+func (l *Logger) Interceptor(op Operation) connect.Interceptor {
+	return &interceptor{logger: l, op: op}
+}
+
+type interceptor struct {
+	logger *Logger
+	op     Operation
+}
+
+func (i *interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		begin := time.Now()
+		resp, err := next(ctx, req)
+		var size int
+		if err == nil {
+			size = messageSize(resp.Any())
+		}
+		i.record(ctx, req.Spec().Procedure, req.Peer().Addr, req.Any(), size, err, time.Since(begin))
+		return resp, err
+	}
+}
+
+func (i *interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		begin := time.Now()
+		c := &streamingConn{StreamingHandlerConn: conn}
+		err := next(ctx, c)
+		i.record(ctx, conn.Spec().Procedure, conn.Peer().Addr, c.request, c.bytes, err, time.Since(begin))
+		return err
+	}
+}
+
+func (i *interceptor) record(ctx context.Context, procedure, addr string, req interface{}, size int, err error, d time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = connect.CodeOf(err).String()
+	}
+	keyvals := append([]interface{}{"procedure", procedure, "remote_addr", addr}, queryParams("", req)...)
+	i.logger.log(ctx, i.op, append(keyvals,
+		"status", status,
+		"response_bytes", size,
+		"duration", d,
+	)...)
+}
+
+// streamingConn records the first request received, and the size of the
+// responses sent.
+type streamingConn struct {
+	connect.StreamingHandlerConn
+	request interface{}
+	bytes   int
+}
+
+func (c *streamingConn) Receive(msg interface{}) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil && c.request == nil {
+		c.request = msg
+	}
+	return err
+}
+
+func (c *streamingConn) Send(msg interface{}) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if err == nil {
+		c.bytes += messageSize(msg)
+	}
+	return err
+}
+
+func messageSize(msg interface{}) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// queryParams returns the profile type, the selector and the time range of
+// the query request, if it has them.
+func queryParams(prefix string, req interface{}) []interface{} {
+	if diff, ok := req.(*querierv1.DiffRequest); ok {
+		return append(queryParams("left_", diff.GetLeft()), queryParams("right_", diff.GetRight())...)
+	}
+	var keyvals []interface{}
+	if r, ok := req.(interface{ GetProfileTypeID() string }); ok {
+		keyvals = append(keyvals, prefix+"profile_type", r.GetProfileTypeID())
+	}
+	if r, ok := req.(interface{ GetLabelSelector() string }); ok {
+		keyvals = append(keyvals, prefix+"selector", r.GetLabelSelector())
+	} else if r, ok := req.(interface{ GetMatchers() []string }); ok {
+		keyvals = append(keyvals, prefix+"selector", strings.Join(r.GetMatchers(), ","))
+	}
+	if r, ok := req.(interface {
+		GetStart() int64
+		GetEnd() int64
+	}); ok {
+		keyvals = append(keyvals,
+			prefix+"start", time.UnixMilli(r.GetStart()).UTC(),
+			prefix+"end", time.UnixMilli(r.GetEnd()).UTC(),
+		)
+	}
+	return keyvals
+}
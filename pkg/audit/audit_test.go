@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(context.Context, http.Header) (tenant.Identity, error) {
+	return tenant.Identity{TenantID: "foo", Subject: "api-key:dashboards"}, nil
+}
+
+func readEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e map[string]interface{}
+		require.NoError(t, dec.Decode(&e))
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func Test_HTTPMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf)
+	handler := tenant.NewAuthMiddleware(fakeAuthenticator{}).Wrap(
+		l.HTTPMiddleware(OperationQuery).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("flamegraph"))
+		})),
+	)
+	r := httptest.NewRequest(http.MethodGet, "/pyroscope/render?query=cpu%7Bservice_name%3D%22app%22%7D&from=now-1h", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := readEntries(t, &buf)
+	require.Len(t, entries, 1)
+	e := entries[0]
+	require.Equal(t, "query", e["operation"])
+	require.Equal(t, "api-key:dashboards", e["subject"])
+	require.Equal(t, "/pyroscope/render", e["path"])
+	require.Equal(t, `query=cpu%7Bservice_name%3D%22app%22%7D&from=now-1h`, e["params"])
+	require.Equal(t, float64(http.StatusOK), e["status"])
+	require.Equal(t, float64(len("flamegraph")), e["response_bytes"])
+}
+
+func Test_Interceptor(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf)
+	resp := &querierv1.SelectMergeStacktracesResponse{Flamegraph: &querierv1.FlameGraph{Names: []string{"total", "main"}}}
+	unary := l.Interceptor(OperationQuery).WrapUnary(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(resp), nil
+	})
+	ctx := tenant.InjectTenantID(context.Background(), "foo")
+	_, err := unary(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{service_name="app"}`,
+		Start:         1696000000000,
+		End:           1696003600000,
+	}))
+	require.NoError(t, err)
+
+	unary = l.Interceptor(OperationQuery).WrapUnary(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		return nil, connect.NewError(connect.CodePermissionDenied, nil)
+	})
+	_, err = unary(ctx, connect.NewRequest(&typesv1.LabelNamesRequest{Matchers: []string{`{service_name="app"}`}}))
+	require.Error(t, err)
+
+	entries := readEntries(t, &buf)
+	require.Len(t, entries, 2)
+	require.Equal(t, "anonymous", entries[0]["subject"])
+	require.Equal(t, "foo", entries[0]["tenant"])
+	require.Equal(t, `{service_name="app"}`, entries[0]["selector"])
+	require.Equal(t, "2023-09-29T15:06:40Z", entries[0]["start"])
+	require.Equal(t, "2023-09-29T16:06:40Z", entries[0]["end"])
+	require.Equal(t, "ok", entries[0]["status"])
+	require.Equal(t, float64(messageSize(resp)), entries[0]["response_bytes"])
+
+	require.Equal(t, `{service_name="app"}`, entries[1]["selector"])
+	require.Equal(t, "permission_denied", entries[1]["status"])
+	require.Equal(t, float64(0), entries[1]["response_bytes"])
+}
@@ -0,0 +1,301 @@
+// Package billing exports the usage of the tenants to the storage bucket,
+// for chargeback.
+package billing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	exportTimeout = time.Minute
+	// maxRecordsRange is the longest time range of the records API.
+	maxRecordsRange = 366 * 24 * time.Hour
+)
+
+type Config struct {
+	Interval   time.Duration `yaml:"interval"`
+	Prefix     string        `yaml:"prefix" category:"advanced"`
+	InstanceID string        `yaml:"instance_id" doc:"default=<hostname>" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	hostname, _ := os.Hostname()
+	f.DurationVar(&cfg.Interval, "billing-exporter.interval", time.Hour, "How often the usage records of the tenants are written to the storage bucket. Each record covers the usage since the previous one.")
+	f.StringVar(&cfg.Prefix, "billing-exporter.prefix", "usage-records", "Prefix of the storage bucket objects the usage records are written to. Objects are named <prefix>/<date>/<instance-id>-<timestamp>.csv.")
+	f.StringVar(&cfg.InstanceID, "billing-exporter.instance-id", hostname, "Instance ID recorded in the usage records, and in the name of the objects they are written to.")
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Interval <= 0 {
+		return errors.New("the billing exporter interval must be positive")
+	}
+	if cfg.Prefix == "" || cfg.InstanceID == "" {
+		return errors.New("the billing exporter requires a prefix and an instance ID")
+	}
+	return nil
+}
+
+// Exporter periodically writes the usage of the tenants over the last
+// period to the storage bucket. The ingested and queried bytes, and the
+// active series, are the ones counted by the components of the process;
+// the stored bytes are read from the bucket index of the tenants.
+type Exporter struct {
+	services.Service
+
+	cfg    Config
+	bucket phlareobj.Bucket
+	logger log.Logger
+
+	periodStart time.Time
+	// Values of the counters at the start of the period, by tenant.
+	ingested map[string]float64
+	queried  map[string]float64
+
+	records  prometheus.Counter
+	failures prometheus.Counter
+}
+
+func New(cfg Config, bkt phlareobj.Bucket, logger log.Logger, reg prometheus.Registerer) *Exporter {
+	e := &Exporter{
+		cfg:    cfg,
+		bucket: bkt,
+		logger: logger,
+		records: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_billing_exporter_records_total",
+			Help: "The total number of usage records written to the storage bucket.",
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_billing_exporter_failures_total",
+			Help: "The total number of usage exports that failed.",
+		}),
+	}
+	e.Service = services.NewTimerService(cfg.Interval, e.starting, e.iteration, e.stopping).WithName("billing exporter")
+	return e
+}
+
+func (e *Exporter) starting(context.Context) error {
+	e.periodStart = time.Now()
+	e.ingested = valuesByTenant(IngestedBytes)
+	e.queried = valuesByTenant(QueriedBytes)
+	return nil
+}
+
+func (e *Exporter) iteration(ctx context.Context) error {
+	if err := e.export(ctx, time.Now()); err != nil {
+		// The period is extended until the next export succeeds.
+		level.Warn(e.logger).Log("msg", "failed to export the usage records", "err", err)
+		e.failures.Inc()
+	}
+	return nil
+}
+
+// stopping exports the usage since the last export, so it is not lost.
+func (e *Exporter) stopping(_ error) error {
+	if err := e.export(context.Background(), time.Now()); err != nil {
+		level.Warn(e.logger).Log("msg", "failed to export the usage records", "err", err)
+		e.failures.Inc()
+	}
+	return nil
+}
+
+func (e *Exporter) export(ctx context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	ingested := valuesByTenant(IngestedBytes)
+	queried := valuesByTenant(QueriedBytes)
+	activeSeries := valuesByTenant(ActiveSeries)
+	stored, err := e.storedBytes(ctx)
+	if err != nil {
+		return err
+	}
+
+	tenants := make(map[string]struct{})
+	for _, m := range []map[string]float64{ingested, queried, activeSeries, stored} {
+		for tenantID := range m {
+			tenants[tenantID] = struct{}{}
+		}
+	}
+	records := make([]Record, 0, len(tenants))
+	for tenantID := range tenants {
+		r := Record{
+			PeriodStart:   e.periodStart,
+			PeriodEnd:     now,
+			TenantID:      tenantID,
+			Instance:      e.cfg.InstanceID,
+			IngestedBytes: delta(ingested[tenantID], e.ingested[tenantID]),
+			StoredBytes:   uint64(stored[tenantID]),
+			QueriedBytes:  delta(queried[tenantID], e.queried[tenantID]),
+			ActiveSeries:  uint64(activeSeries[tenantID]),
+		}
+		if r.IngestedBytes == 0 && r.StoredBytes == 0 && r.QueriedBytes == 0 && r.ActiveSeries == 0 {
+			continue
+		}
+		records = append(records, r)
+	}
+	if len(records) > 0 {
+		sort.Slice(records, func(i, j int) bool { return records[i].TenantID < records[j].TenantID })
+		var buf bytes.Buffer
+		if err = writeCSV(&buf, records); err != nil {
+			return err
+		}
+		if err = e.bucket.Upload(ctx, e.objectName(now), &buf); err != nil {
+			return err
+		}
+		e.records.Add(float64(len(records)))
+	}
+	e.periodStart, e.ingested, e.queried = now, ingested, queried
+	return nil
+}
+
+// delta returns the increase of a counter of the process since the start
+// of the period.
+func delta(current, previous float64) uint64 {
+	if current < previous {
+		return uint64(current)
+	}
+	return uint64(current - previous)
+}
+
+// storedBytes returns the compressed bytes of the blocks of the tenants,
+// read from their bucket index. The tenants without bucket index are
+// skipped.
+func (e *Exporter) storedBytes(ctx context.Context) (map[string]float64, error) {
+	tenants, err := bucket.ListUsers(ctx, e.bucket)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]float64, len(tenants))
+	for _, tenantID := range tenants {
+		if tenantID == e.cfg.Prefix {
+			continue
+		}
+		bkt := phlareobj.NewPrefixedBucket(e.bucket, tenantID+"/phlaredb")
+		idx, err := bucketindex.ReadIndex(ctx, bkt, "", nil, e.logger)
+		if errors.Is(err, bucketindex.ErrIndexNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading the bucket index of tenant %s: %w", tenantID, err)
+		}
+		stored[tenantID] = float64(idx.Usage().CompressedBytes)
+	}
+	return stored, nil
+}
+
+func (e *Exporter) objectName(t time.Time) string {
+	return path.Join(e.cfg.Prefix, dayDir(t), fmt.Sprintf("%s-%d.csv", e.cfg.InstanceID, t.UnixMilli()))
+}
+
+func dayDir(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// RecordsHandler returns the usage records whose period ended within the
+// start and end parameters (RFC 3339 timestamps, the last 24 hours by
+// default), written by all the instances. The records can be filtered by
+// tenant with the tenant parameter, and returned as CSV with format=csv.
+func (e *Exporter) RecordsHandler(w http.ResponseWriter, r *http.Request) {
+	end, err := parseTime(r.URL.Query().Get("end"), time.Now())
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	start, err := parseTime(r.URL.Query().Get("start"), end.Add(-24*time.Hour))
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) || end.Sub(start) > maxRecordsRange {
+		httputil.ErrorWithStatus(w, fmt.Errorf("the time range must be positive and at most %s", maxRecordsRange), http.StatusBadRequest)
+		return
+	}
+	records, err := e.listRecords(r.Context(), r.URL.Query().Get("tenant"), start, end)
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		_ = writeCSV(w, records)
+		return
+	}
+	util.WriteJSONResponse(w, struct {
+		Records []Record `json:"records"`
+	}{Records: records})
+}
+
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// listRecords reads the records whose period ended in (start, end], of
+// the tenant if not empty, sorted by period end.
+func (e *Exporter) listRecords(ctx context.Context, tenantID string, start, end time.Time) ([]Record, error) {
+	records := make([]Record, 0)
+	for day := start.UTC().Truncate(24 * time.Hour); !day.After(end); day = day.Add(24 * time.Hour) {
+		err := e.bucket.Iter(ctx, path.Join(e.cfg.Prefix, dayDir(day))+"/", func(name string) error {
+			if !strings.HasSuffix(name, ".csv") {
+				return nil
+			}
+			rc, err := e.bucket.Get(ctx, name)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			objectRecords, err := readCSV(rc)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", name, err)
+			}
+			for _, r := range objectRecords {
+				if (tenantID != "" && r.TenantID != tenantID) || !r.PeriodEnd.After(start) || r.PeriodEnd.After(end) {
+					continue
+				}
+				records = append(records, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.PeriodEnd.Equal(b.PeriodEnd) {
+			return a.PeriodEnd.Before(b.PeriodEnd)
+		}
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		return a.Instance < b.Instance
+	})
+	return records, nil
+}
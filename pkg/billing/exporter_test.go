@@ -0,0 +1,73 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+)
+
+func Test_Exporter(t *testing.T) {
+	ctx := context.Background()
+	bkt := phlareobj.NewBucket(objstore.NewInMemBucket())
+	require.NoError(t, bucketindex.WriteIndex(ctx, phlareobj.NewPrefixedBucket(bkt, "exporter-a/phlaredb"), "", nil, &bucketindex.Index{
+		Version: bucketindex.IndexVersion3,
+		Blocks:  bucketindex.Blocks{{ID: ulid.MustNew(1, nil), SizeBytes: 100}},
+	}))
+
+	e := New(Config{Interval: time.Hour, Prefix: "usage-records", InstanceID: "instance-1"}, bkt, log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, e.starting(ctx))
+	IngestedBytes.WithLabelValues("exporter-a").Add(10)
+	QueriedBytes.WithLabelValues("exporter-b").Add(20)
+	ActiveSeries.WithLabelValues("exporter-b").Set(3)
+	now := time.Now()
+	require.NoError(t, e.export(ctx, now))
+	// Each record only covers the usage since the previous one.
+	IngestedBytes.WithLabelValues("exporter-a").Add(5)
+	require.NoError(t, e.export(ctx, now.Add(time.Hour)))
+
+	records := func(query string) []Record {
+		w := httptest.NewRecorder()
+		e.RecordsHandler(w, httptest.NewRequest(http.MethodGet, "/billing-exporter/records?end="+now.Add(2*time.Hour).UTC().Format(time.RFC3339)+query, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Records []Record `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Records
+	}
+	a := records("&tenant=exporter-a")
+	require.Len(t, a, 2)
+	require.Equal(t, uint64(10), a[0].IngestedBytes)
+	require.Equal(t, uint64(5), a[1].IngestedBytes)
+	require.Equal(t, uint64(100), a[1].StoredBytes)
+	require.Equal(t, "instance-1", a[1].Instance)
+	require.Equal(t, a[0].PeriodEnd, a[1].PeriodStart)
+
+	b := records("&tenant=exporter-b")
+	require.Len(t, b, 2)
+	require.Equal(t, uint64(20), b[0].QueriedBytes)
+	require.Equal(t, uint64(0), b[1].QueriedBytes)
+	require.Equal(t, uint64(3), b[1].ActiveSeries)
+
+	// The records outside of the time range are not returned.
+	w := httptest.NewRecorder()
+	e.RecordsHandler(w, httptest.NewRequest(http.MethodGet, "/billing-exporter/records?format=csv&tenant=exporter-a&start="+now.Add(30*time.Minute).UTC().Format(time.RFC3339)+"&end="+now.Add(2*time.Hour).UTC().Format(time.RFC3339), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	rows, err := readCSV(strings.NewReader(w.Body.String()))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, uint64(5), rows[0].IngestedBytes)
+}
@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Record is the usage of a tenant over a period, as counted by an instance.
+type Record struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	TenantID    string    `json:"tenant_id"`
+	Instance    string    `json:"instance"`
+	// Decompressed bytes of the profiles ingested over the period.
+	IngestedBytes uint64 `json:"ingested_bytes"`
+	// Compressed bytes of the blocks of the tenant in the bucket at the end
+	// of the period.
+	StoredBytes uint64 `json:"stored_bytes"`
+	// Bytes read by the queries over the period.
+	QueriedBytes uint64 `json:"queried_bytes"`
+	// Active series at the end of the period.
+	ActiveSeries uint64 `json:"active_series"`
+}
+
+// csvHeader is the header of the CSV files of the records. The columns
+// are documented, new ones are only added at the end.
+var csvHeader = []string{
+	"period_start",
+	"period_end",
+	"tenant_id",
+	"instance",
+	"ingested_bytes",
+	"stored_bytes",
+	"queried_bytes",
+	"active_series",
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write([]string{
+			r.PeriodStart.UTC().Format(time.RFC3339),
+			r.PeriodEnd.UTC().Format(time.RFC3339),
+			r.TenantID,
+			r.Instance,
+			strconv.FormatUint(r.IngestedBytes, 10),
+			strconv.FormatUint(r.StoredBytes, 10),
+			strconv.FormatUint(r.QueriedBytes, 10),
+			strconv.FormatUint(r.ActiveSeries, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) ([]Record, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	records := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) < len(csvHeader) {
+			return nil, fmt.Errorf("expected %d columns, got %d", len(csvHeader), len(row))
+		}
+		var rec Record
+		if rec.PeriodStart, err = time.Parse(time.RFC3339, row[0]); err != nil {
+			return nil, err
+		}
+		if rec.PeriodEnd, err = time.Parse(time.RFC3339, row[1]); err != nil {
+			return nil, err
+		}
+		rec.TenantID, rec.Instance = row[2], row[3]
+		for i, v := range []*uint64{&rec.IngestedBytes, &rec.StoredBytes, &rec.QueriedBytes, &rec.ActiveSeries} {
+			if *v, err = strconv.ParseUint(row[4+i], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
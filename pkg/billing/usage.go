@@ -0,0 +1,86 @@
+package billing
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+var (
+	// IngestedBytes is the total decompressed bytes of the profiles of the
+	// tenants accepted by the distributors of the process.
+	IngestedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "usage_ingested_bytes_total",
+			Help:      "The total number of decompressed bytes of the profiles accepted by the distributor.",
+		},
+		[]string{"tenant"},
+	)
+	// QueriedBytes is the total bytes read by the stacktraces queries of
+	// the tenants executed by the queriers of the process.
+	QueriedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "usage_queried_bytes_total",
+			Help:      "The total number of symbol bytes fetched by the stacktraces queries executed by the querier.",
+		},
+		[]string{"tenant"},
+	)
+	// ActiveSeries is the number of active series of the tenants received
+	// by the distributors of the process.
+	ActiveSeries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pyroscope",
+			Name:      "usage_active_series",
+			Help:      "The number of active series received by the distributor.",
+		},
+		[]string{"tenant"},
+	)
+)
+
+// AddQueriedBytes counts the bytes read by a query of the tenant of the
+// context.
+func AddQueriedBytes(ctx context.Context, n uint64) {
+	if n == 0 {
+		return
+	}
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return
+	}
+	QueriedBytes.WithLabelValues(tenantID).Add(float64(n))
+}
+
+// valuesByTenant returns the values of the metric of the process, by
+// tenant.
+func valuesByTenant(c prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	res := make(map[string]float64)
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			continue
+		}
+		for _, l := range metric.GetLabel() {
+			if l.GetName() != "tenant" {
+				continue
+			}
+			switch {
+			case metric.Counter != nil:
+				res[l.GetValue()] += metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				res[l.GetValue()] += metric.GetGauge().GetValue()
+			}
+		}
+	}
+	return res
+}
@@ -83,3 +83,7 @@ func (c *ingesterPoolClient) MergeProfilesLabels(ctx context.Context) BidiClient
 func (c *ingesterPoolClient) MergeProfilesPprof(ctx context.Context) BidiClientMergeProfilesPprof {
 	return c.IngesterServiceClient.MergeProfilesPprof(ctx)
 }
+
+func (c *ingesterPoolClient) MergeBlocksStacktraces(ctx context.Context) BidiClientMergeProfilesStacktraces {
+	return c.IngesterServiceClient.MergeBlocksStacktraces(ctx)
+}
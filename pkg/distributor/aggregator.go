@@ -0,0 +1,201 @@
+package distributor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/google/pprof/profile"
+
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+)
+
+// aggregator merges profiles of the same series pushed within the
+// aggregation window. The push that opens the window for a series waits
+// for the window to close and forwards the merged profile; pushes that
+// arrive while the window is open add their profiles to it, and wait for
+// the merged profile to be forwarded.
+type aggregator struct {
+	mu     sync.Mutex
+	series map[uint64]*aggregate
+}
+
+type aggregate struct {
+	key      uint64
+	series   *distributormodel.ProfileSeries
+	profiles []*profile.Profile
+	// done is closed once the merged profile is forwarded, with err set to
+	// the result.
+	done chan struct{}
+	err  error
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{series: make(map[uint64]*aggregate)}
+}
+
+// add adds the profile to the open window of the series, and returns the
+// window. It reports whether the caller opened the window and is
+// responsible for flushing it.
+func (a *aggregator) add(key uint64, series *distributormodel.ProfileSeries, p *profile.Profile) (*aggregate, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if x, ok := a.series[key]; ok {
+		x.profiles = append(x.profiles, p)
+		return x, false
+	}
+	x := &aggregate{key: key, series: series, profiles: []*profile.Profile{p}, done: make(chan struct{})}
+	a.series[key] = x
+	return x, true
+}
+
+// flush closes the window and returns the merged profile. If the profiles
+// can not be merged, e.g., because their sample types differ, they are
+// returned individually.
+func (a *aggregator) flush(x *aggregate) ([]*distributormodel.ProfileSeries, error) {
+	a.mu.Lock()
+	delete(a.series, x.key)
+	a.mu.Unlock()
+
+	profiles := x.profiles
+	if merged, err := profile.Merge(profiles); err == nil {
+		profiles = []*profile.Profile{merged}
+	}
+	series := make([]*distributormodel.ProfileSeries, 0, len(profiles))
+	for _, p := range profiles {
+		pp, err := pprof.FromProfile(p)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, &distributormodel.ProfileSeries{
+			Labels:  x.series.Labels,
+			Samples: []*distributormodel.ProfileSample{{Profile: pprof.RawFromProto(pp)}},
+		})
+	}
+	return series, nil
+}
+
+// aggregation is the windows a push opened, and the ones it joined.
+type aggregation struct {
+	owned  []*aggregate
+	joined []*aggregate
+}
+
+// done passes the result of forwarding the merged profiles of the windows
+// the push opened to the pushes that joined them, then waits for the
+// windows the push joined to be forwarded. It returns the first error.
+func (a *aggregation) done(ctx context.Context, err error) error {
+	for _, x := range a.owned {
+		x.err = err
+		close(x.done)
+	}
+	for _, x := range a.joined {
+		select {
+		case <-x.done:
+			if err == nil {
+				err = x.err
+			}
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		}
+	}
+	return err
+}
+
+// isDeltaSeries returns true if the values of the profiles of the series
+// are deltas, which can be summed. The ingesters compute the deltas of the
+// profiles marked with __delta__="true", and of the allocations of the
+// memory profiles unless they are marked with __delta__="false": these are
+// cumulative.
+func isDeltaSeries(ls phlaremodel.Labels) bool {
+	switch ls.Get(phlaremodel.LabelNameDelta) {
+	case "false":
+		return true
+	case "true":
+		return false
+	}
+	return ls.Get(phlaremodel.LabelNameProfileName) != "memory"
+}
+
+// aggregateSeries adds the delta profiles to the aggregation windows of
+// their series. It blocks until the windows opened by the call are closed
+// and returns the series that must be forwarded to ingesters: the merged
+// profiles of these windows, and the profiles that can not be aggregated.
+// The caller must pass the result of forwarding them to the done method of
+// the aggregation returned.
+// The returned profiles that are created by the aggregation are appended
+// to newProfiles and must be closed by the caller.
+func (d *Distributor) aggregateSeries(ctx context.Context, tenantID string, window time.Duration, series []*distributormodel.ProfileSeries, newProfiles *[]*pprof.Profile) ([]*distributormodel.ProfileSeries, *aggregation, error) {
+	type candidate struct {
+		series  *distributormodel.ProfileSeries
+		profile *profile.Profile
+	}
+	result := make([]*distributormodel.ProfileSeries, 0, len(series))
+	candidates := make([]candidate, 0, len(series))
+	for _, s := range series {
+		// A series with multiple samples is forwarded as is:
+		// aggregation aims at profiles pushed individually.
+		// Cumulative profiles can not be merged.
+		if len(s.Samples) != 1 || !isDeltaSeries(s.Labels) {
+			result = append(result, s)
+			continue
+		}
+		b, err := s.Samples[0].Profile.MarshalVT()
+		if err != nil {
+			return nil, nil, err
+		}
+		p, err := profile.ParseUncompressed(b)
+		if err != nil {
+			result = append(result, s)
+			continue
+		}
+		candidates = append(candidates, candidate{series: s, profile: p})
+	}
+
+	agg := new(aggregation)
+	for _, c := range candidates {
+		key := xxhash.Sum64String(tenantID + phlaremodel.LabelPairsString(c.series.Labels))
+		if x, owned := d.aggregator.add(key, c.series, c.profile); owned {
+			agg.owned = append(agg.owned, x)
+		} else {
+			agg.joined = append(agg.joined, x)
+			d.metrics.aggregatedProfiles.WithLabelValues(tenantID).Inc()
+		}
+	}
+	if len(agg.owned) == 0 {
+		return result, agg, nil
+	}
+
+	t := time.NewTimer(window)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		// The windows are flushed anyway: they may
+		// hold profiles of other pushes.
+		t.Stop()
+	}
+	var err error
+	for _, x := range agg.owned {
+		flushed, flushErr := d.aggregator.flush(x)
+		if flushErr != nil {
+			if err == nil {
+				err = flushErr
+			}
+			continue
+		}
+		for _, s := range flushed {
+			*newProfiles = append(*newProfiles, s.Samples[0].Profile)
+		}
+		result = append(result, flushed...)
+	}
+	if err != nil {
+		return nil, nil, agg.done(ctx, err)
+	}
+	return result, agg, nil
+}
@@ -0,0 +1,95 @@
+package distributor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	pproftesthelper "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func Test_AggregateSeries(t *testing.T) {
+	d := &Distributor{
+		metrics:    newMetrics(nil),
+		aggregator: newAggregator(),
+	}
+	newSeries := func(service string, value int64, labels ...*typesv1.LabelPair) []*distributormodel.ProfileSeries {
+		p := pproftesthelper.NewProfileBuilder(time.Second.Nanoseconds()).CPUProfile()
+		p.ForStacktraceString("foo", "bar").AddSamples(value)
+		return []*distributormodel.ProfileSeries{{
+			Labels: append([]*typesv1.LabelPair{
+				{Name: "__name__", Value: "process_cpu"},
+				{Name: "service_name", Value: service},
+			}, labels...),
+			Samples: []*distributormodel.ProfileSample{{Profile: pprof.RawFromProto(p.Profile)}},
+		}}
+	}
+
+	var newProfiles []*pprof.Profile
+	defer func() {
+		for _, p := range newProfiles {
+			p.Close()
+		}
+	}()
+
+	type result struct {
+		series []*distributormodel.ProfileSeries
+		agg    *aggregation
+		err    error
+	}
+	owner := make(chan result)
+	go func() {
+		s, agg, err := d.aggregateSeries(context.Background(), "user-1", time.Second, newSeries("svc", 1), &newProfiles)
+		owner <- result{series: s, agg: agg, err: err}
+	}()
+	require.Eventually(t, func() bool {
+		d.aggregator.mu.Lock()
+		defer d.aggregator.mu.Unlock()
+		return len(d.aggregator.series) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Profiles of the same series are merged into the open window.
+	var pushed []*pprof.Profile
+	s, joined, err := d.aggregateSeries(context.Background(), "user-1", time.Second, newSeries("svc", 2), &pushed)
+	require.NoError(t, err)
+	assert.Empty(t, s)
+	assert.Empty(t, pushed)
+	// The push waits for the merged profile to be forwarded.
+	joinedErr := make(chan error)
+	go func() { joinedErr <- joined.done(context.Background(), nil) }()
+
+	// Cumulative profiles are not merged.
+	cumulative := newSeries("svc", 3, &typesv1.LabelPair{Name: "__delta__", Value: "true"})
+	s, agg, err := d.aggregateSeries(context.Background(), "user-1", time.Second, cumulative, &pushed)
+	require.NoError(t, err)
+	assert.Equal(t, cumulative, s)
+	assert.Empty(t, agg.owned)
+	assert.Empty(t, agg.joined)
+
+	r := <-owner
+	require.NoError(t, r.err)
+	require.Len(t, r.series, 1)
+	require.Len(t, r.series[0].Samples, 1)
+	merged := r.series[0].Samples[0].Profile
+	require.Len(t, merged.Sample, 1)
+	assert.Equal(t, []int64{3}, merged.Sample[0].Value)
+	assert.Equal(t, float64(1), testutil.ToFloat64(d.metrics.aggregatedProfiles.WithLabelValues("user-1")))
+	assert.Empty(t, d.aggregator.series)
+
+	select {
+	case <-joinedErr:
+		t.Fatal("the push returned before the merged profile was forwarded")
+	default:
+	}
+	forwardErr := errors.New("forwarding failed")
+	assert.Equal(t, forwardErr, r.agg.done(context.Background(), forwardErr))
+	assert.Equal(t, forwardErr, <-joinedErr)
+}
@@ -0,0 +1,173 @@
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+const (
+	deadLetterUploadTimeout    = time.Minute
+	deadLetterMaxInflight      = 16
+	deadLetterQueueDefaultPath = "dead-letter"
+)
+
+// DeadLetterQueueConfig configures where the distributor writes the
+// profiles it rejects.
+type DeadLetterQueueConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Prefix  string `yaml:"prefix"`
+}
+
+func (cfg *DeadLetterQueueConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cfg.Enabled, "distributor.dead-letter-queue.enabled", false, "Write profiles rejected by the distributor (malformed profiles, validation failures, limit violations) to the storage bucket, together with the rejection reason.")
+	fs.StringVar(&cfg.Prefix, "distributor.dead-letter-queue.prefix", deadLetterQueueDefaultPath, "Prefix of the storage bucket objects the rejected profiles are written to. Objects are named <prefix>/<tenant>/<ulid>.json.")
+}
+
+// deadLetter is the object written for each rejected request.
+type deadLetter struct {
+	Tenant         string             `json:"tenant"`
+	Time           time.Time          `json:"time"`
+	Reason         validation.Reason  `json:"reason"`
+	Error          string             `json:"error"`
+	RawProfileType string             `json:"raw_profile_type,omitempty"`
+	Series         []deadLetterSeries `json:"series"`
+}
+
+type deadLetterSeries struct {
+	Labels string `json:"labels"`
+	// Profiles are encoded as pprof.
+	Profiles [][]byte `json:"profiles"`
+}
+
+type deadLetterQueue struct {
+	bucket objstore.Bucket
+	prefix string
+	logger log.Logger
+
+	inflight chan struct{}
+	wg       sync.WaitGroup
+
+	written *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+}
+
+func newDeadLetterQueue(cfg DeadLetterQueueConfig, bucket objstore.Bucket, reg prometheus.Registerer, logger log.Logger) *deadLetterQueue {
+	q := &deadLetterQueue{
+		bucket:   bucket,
+		prefix:   cfg.Prefix,
+		logger:   logger,
+		inflight: make(chan struct{}, deadLetterMaxInflight),
+		written: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "distributor_dead_letter_requests_written_total",
+			Help:      "The number of rejected requests written to the dead-letter queue.",
+		}, []string{"reason", "tenant"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "distributor_dead_letter_requests_dropped_total",
+			Help:      "The number of rejected requests that could not be written to the dead-letter queue.",
+		}, []string{"reason", "tenant"}),
+	}
+	if reg != nil {
+		reg.MustRegister(q.written, q.dropped)
+	}
+	return q
+}
+
+// rejectionReason returns the reason the request was rejected for, and
+// false if the error is not a rejection, e.g., an ingester failure.
+func rejectionReason(err error) (validation.Reason, bool) {
+	switch connect.CodeOf(err) {
+	case connect.CodeInvalidArgument:
+		return validation.ReasonOf(err), true
+	case connect.CodeResourceExhausted:
+		return validation.RateLimited, true
+	default:
+		return "", false
+	}
+}
+
+// write writes the request to the queue in the background. The request
+// is encoded before write returns: the profiles are not referenced after.
+func (q *deadLetterQueue) write(tenantID string, reason validation.Reason, rejectErr error, req *distributormodel.PushRequest) {
+	entry := deadLetter{
+		Tenant:         tenantID,
+		Time:           time.Now().UTC(),
+		Reason:         reason,
+		Error:          rejectErr.Error(),
+		RawProfileType: string(req.RawProfileType),
+		Series:         make([]deadLetterSeries, 0, len(req.Series)),
+	}
+	for _, s := range req.Series {
+		series := deadLetterSeries{
+			Labels:   phlaremodel.LabelPairsString(s.Labels),
+			Profiles: make([][]byte, 0, len(s.Samples)),
+		}
+		for _, x := range s.Samples {
+			if len(x.RawProfile) > 0 {
+				series.Profiles = append(series.Profiles, x.RawProfile)
+				continue
+			}
+			if x.Profile == nil || x.Profile.Profile == nil {
+				continue
+			}
+			b, err := x.Profile.MarshalVT()
+			if err != nil {
+				continue
+			}
+			series.Profiles = append(series.Profiles, b)
+		}
+		entry.Series = append(entry.Series, series)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		level.Warn(q.logger).Log("msg", "failed to encode dead letter", "err", err)
+		q.dropped.WithLabelValues(string(reason), tenantID).Inc()
+		return
+	}
+
+	select {
+	case q.inflight <- struct{}{}:
+	default:
+		q.dropped.WithLabelValues(string(reason), tenantID).Inc()
+		return
+	}
+	name := path.Join(q.prefix, tenantID, ulid.MustNew(ulid.Timestamp(entry.Time), rand.Reader).String()+".json")
+	q.wg.Add(1)
+	go func() {
+		defer func() {
+			<-q.inflight
+			q.wg.Done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterUploadTimeout)
+		defer cancel()
+		if err := q.bucket.Upload(ctx, name, bytes.NewReader(b)); err != nil {
+			level.Warn(q.logger).Log("msg", "failed to write dead letter", "name", name, "err", err)
+			q.dropped.WithLabelValues(string(reason), tenantID).Inc()
+			return
+		}
+		q.written.WithLabelValues(string(reason), tenantID).Inc()
+	}()
+}
+
+// stop waits for the pending writes to complete.
+func (q *deadLetterQueue) stop() {
+	q.wg.Wait()
+}
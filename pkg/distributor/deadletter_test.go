@@ -0,0 +1,97 @@
+package distributor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/ring/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_DeadLetterQueue(t *testing.T) {
+	overrides := validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+		l := validation.MockDefaultLimits()
+		l.MaxLabelNameLength = 12
+		tenantLimits["user-1"] = l
+	})
+	bucket := objstore.NewInMemBucket()
+	d, err := New(Config{
+		DistributorRing: ringConfig,
+		DeadLetterQueue: DeadLetterQueueConfig{Enabled: true, Prefix: "dlq"},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "foo"}}, 3), &poolFactory{f: func(addr string) (client.PoolClient, error) {
+		return newFakeIngester(t, false), nil
+	}}, overrides, bucket, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	ctx := tenant.InjectTenantID(context.Background(), "user-1")
+	profile := testProfile(t)
+	_, err = d.Push(ctx, connect.NewRequest(&pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{{
+			Labels: []*typesv1.LabelPair{
+				{Name: "clusterdddwqdqdqdqdqdqw", Value: "us-central1"},
+				{Name: "__name__", Value: "cpu"},
+			},
+			Samples: []*pushv1.RawSample{{RawProfile: profile}},
+		}},
+	}))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = d.Push(ctx, connect.NewRequest(&pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{{
+			Labels:  []*typesv1.LabelPair{{Name: "__name__", Value: "cpu"}},
+			Samples: []*pushv1.RawSample{{RawProfile: []byte("not a profile")}},
+		}},
+	}))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	d.deadLetterQueue.stop()
+	entries := make(map[validation.Reason]deadLetter)
+	require.NoError(t, bucket.Iter(context.Background(), "dlq/user-1/", func(name string) error {
+		r, err := bucket.Get(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		var entry deadLetter
+		if err = json.Unmarshal(b, &entry); err != nil {
+			return err
+		}
+		entries[entry.Reason] = entry
+		return nil
+	}))
+	require.Len(t, entries, 2)
+
+	invalid := entries[validation.LabelNameTooLong]
+	assert.Equal(t, "user-1", invalid.Tenant)
+	assert.Contains(t, invalid.Error, "clusterdddwqdqdqdqdqdqw")
+	require.Len(t, invalid.Series, 1)
+	assert.Equal(t, phlaremodel.LabelPairsString([]*typesv1.LabelPair{
+		{Name: "__name__", Value: "cpu"},
+		{Name: "clusterdddwqdqdqdqdqdqw", Value: "us-central1"},
+		{Name: "service_name", Value: "unspecified"},
+	}), invalid.Series[0].Labels)
+	assert.Equal(t, [][]byte{profile}, invalid.Series[0].Profiles)
+
+	malformed := entries[validation.MalformedProfile]
+	require.Len(t, malformed.Series, 1)
+	assert.Equal(t, [][]byte{[]byte("not a profile")}, malformed.Series[0].Profiles)
+}
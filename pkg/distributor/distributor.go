@@ -3,15 +3,18 @@ package distributor
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"expvar"
 	"flag"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"net/http"
 	"sort"
 	"time"
 
 	"github.com/bufbuild/connect-go"
+	"github.com/cespare/xxhash/v2"
 	"github.com/dustin/go-humanize"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -26,13 +29,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/thanos-io/objstore"
 	"go.uber.org/atomic"
 
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/billing"
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	"github.com/grafana/pyroscope/pkg/ingeststorage"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/slices"
@@ -71,6 +79,11 @@ type Config struct {
 
 	// Distributors ring
 	DistributorRing util.CommonRingConfig `yaml:"ring" doc:"hidden"`
+
+	DeadLetterQueue DeadLetterQueueConfig `yaml:"dead_letter_queue"`
+
+	// IngestStorage is set from the top-level ingest_storage block.
+	IngestStorage ingeststorage.Config `yaml:"-"`
 }
 
 // RegisterFlags registers distributor-related flags.
@@ -78,6 +91,7 @@ func (cfg *Config) RegisterFlags(fs *flag.FlagSet, logger log.Logger) {
 	cfg.PoolConfig.RegisterFlagsWithPrefix("distributor", fs)
 	fs.DurationVar(&cfg.PushTimeout, "distributor.push.timeout", 5*time.Second, "Timeout when pushing data to ingester.")
 	cfg.DistributorRing.RegisterFlags("distributor.ring.", "collectors/", "distributors", fs, logger)
+	cfg.DeadLetterQueue.RegisterFlags(fs)
 }
 
 // Distributor coordinates replicates and distribution of log streams.
@@ -96,6 +110,12 @@ type Distributor struct {
 	distributorsRing       *ring.Ring
 	healthyInstancesCount  *atomic.Uint32
 	ingestionRateLimiter   *limiter.RateLimiter
+	bytesQuota             *quotaLimiter
+	profilesQuota          *quotaLimiter
+	aggregator             *aggregator
+	ingestionStatus        *ingestionStatus
+	deadLetterQueue        *deadLetterQueue
+	ingestWriter           *ingeststorage.Writer
 
 	subservices        *services.Manager
 	subservicesWatcher *services.FailureWatcher
@@ -111,6 +131,9 @@ type Distributor struct {
 type Limits interface {
 	IngestionRateBytes(tenantID string) float64
 	IngestionBurstSizeBytes(tenantID string) int
+	IngestionQuotaBytesPerMinute(tenantID string) float64
+	IngestionQuotaProfilesPerMinute(tenantID string) float64
+	DistributorAggregationWindow(tenantID string) time.Duration
 	IngestionTenantShardSize(tenantID string) int
 	MaxLabelNameLength(tenantID string) int
 	MaxLabelValueLength(tenantID string) int
@@ -121,10 +144,12 @@ type Limits interface {
 	MaxProfileStacktraceDepth(tenantID string) int
 	MaxProfileSymbolValueLength(tenantID string) int
 	MaxSessionsPerSeries(tenantID string) int
+	IngestionRelabelingRules(tenantID string) []*relabel.Config
+	IngestionSampleRatio(tenantID string) map[string]float64
 	validation.ProfileValidationLimits
 }
 
-func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, limits Limits, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Distributor, error) {
+func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, limits Limits, bucket objstore.Bucket, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Distributor, error) {
 	clients := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
 		Namespace: "pyroscope",
 		Name:      "distributor_ingester_clients",
@@ -156,6 +181,20 @@ func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactor
 	subservices = append(subservices, distributorsLifecycler, distributorsRing)
 
 	d.ingestionRateLimiter = limiter.NewRateLimiter(newGlobalRateStrategy(newIngestionRateStrategy(limits), d), 10*time.Second)
+	d.bytesQuota = newQuotaLimiter(quotaResourceBytes, limits.IngestionQuotaBytesPerMinute, d)
+	d.profilesQuota = newQuotaLimiter(quotaResourceProfiles, limits.IngestionQuotaProfilesPerMinute, d)
+	d.aggregator = newAggregator()
+	d.ingestionStatus = newIngestionStatus()
+	if cfg.DeadLetterQueue.Enabled {
+		if bucket == nil {
+			return nil, errors.New("dead letter queue requires a storage bucket")
+		}
+		d.deadLetterQueue = newDeadLetterQueue(cfg.DeadLetterQueue, bucket, reg, logger)
+	}
+	if cfg.IngestStorage.Enabled {
+		d.ingestWriter = ingeststorage.NewWriter(cfg.IngestStorage.Kafka, logger, reg)
+		subservices = append(subservices, d.ingestWriter)
+	}
 	d.distributorsLifecycler = distributorsLifecycler
 	d.distributorsRing = distributorsRing
 
@@ -177,15 +216,24 @@ func (d *Distributor) starting(ctx context.Context) error {
 }
 
 func (d *Distributor) running(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return nil
-	case err := <-d.subservicesWatcher.Chan():
-		return errors.Wrap(err, "distributor subservice failed")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-d.subservicesWatcher.Chan():
+			return errors.Wrap(err, "distributor subservice failed")
+		case now := <-ticker.C:
+			d.ingestionStatus.cleanup(now)
+		}
 	}
 }
 
 func (d *Distributor) stopping(_ error) error {
+	if d.deadLetterQueue != nil {
+		d.deadLetterQueue.stop()
+	}
 	return services.StopManagerAndAwaitStopped(context.Background(), d.subservices)
 }
 
@@ -216,6 +264,7 @@ func (d *Distributor) Push(ctx context.Context, grpcReq *connect.Request[pushv1.
 		for _, grpcSample := range grpcSeries.Samples {
 			profile, err := pprof.RawFromBytes(grpcSample.RawProfile)
 			if err != nil {
+				d.writeMalformedToDeadLetterQueue(ctx, grpcReq.Msg, err)
 				return nil, connect.NewError(connect.CodeInvalidArgument, err)
 			}
 			profiles = append(profiles, profile)
@@ -233,12 +282,42 @@ func (d *Distributor) Push(ctx context.Context, grpcReq *connect.Request[pushv1.
 	return d.PushParsed(ctx, req)
 }
 
-func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.PushRequest) (*connect.Response[pushv1.PushResponse], error) {
+// PushBatch pushes the series of the batch, with the batch labels added to
+// the labels of each series.
+func (d *Distributor) PushBatch(ctx context.Context, grpcReq *connect.Request[pushv1.PushBatchRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	req := &pushv1.PushRequest{Series: grpcReq.Msg.Series}
+	if len(grpcReq.Msg.Labels) > 0 {
+		for _, series := range req.Series {
+			series.Labels = withBatchLabels(series.Labels, grpcReq.Msg.Labels)
+		}
+	}
+	return d.Push(ctx, connect.NewRequest(req))
+}
+
+func withBatchLabels(labels, batchLabels []*typesv1.LabelPair) []*typesv1.LabelPair {
+	ls := make(phlaremodel.Labels, 0, len(labels)+len(batchLabels))
+	ls = append(ls, labels...)
+	for _, l := range batchLabels {
+		if phlaremodel.Labels(labels).Get(l.Name) == "" {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.PushRequest) (resp *connect.Response[pushv1.PushResponse], err error) {
 	now := model.Now()
 	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeUnauthenticated, err)
 	}
+	if d.deadLetterQueue != nil {
+		defer func() {
+			if reason, rejected := rejectionReason(err); rejected {
+				d.deadLetterQueue.write(tenantID, reason, err, req)
+			}
+		}()
+	}
 	var (
 		totalPushUncompressedBytes int64
 		totalProfiles              int64
@@ -252,6 +331,12 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 		sort.Sort(phlaremodel.Labels(series.Labels))
 	}
 
+	// Sampled out profiles are still owned by the caller.
+	seriesSampled, sampledOut := d.sampleSeries(tenantID, req.Series)
+	if len(seriesSampled) == 0 && sampledOut > 0 {
+		return connect.NewResponse(&pushv1.PushResponse{}), nil
+	}
+
 	haveRawPprof := req.RawProfileType == distributormodel.RawProfileTypePPROF
 	d.bytesReceivedTotalStats.Inc(int64(req.RawProfileSize))
 	d.bytesReceivedStats.Record(float64(req.RawProfileSize))
@@ -263,7 +348,7 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 		d.metrics.receivedCompressedBytes.WithLabelValues(string(profName), tenantID).Observe(float64(req.RawProfileSize))
 	}
 
-	for _, series := range req.Series {
+	for _, series := range seriesSampled {
 		// include the labels in the size calculation
 		for _, lbs := range series.Labels {
 			totalPushUncompressedBytes += int64(len(lbs.Name))
@@ -308,6 +393,11 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 	}
 
 	// rate limit the request
+	if err = d.checkQuotas(now.Time(), tenantID, totalPushUncompressedBytes, totalProfiles); err != nil {
+		validation.DiscardedProfiles.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(totalProfiles))
+		validation.DiscardedBytes.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(totalPushUncompressedBytes))
+		return nil, err
+	}
 	if !d.ingestionRateLimiter.AllowN(time.Now(), tenantID, int(totalPushUncompressedBytes)) {
 		validation.DiscardedProfiles.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(totalProfiles))
 		validation.DiscardedBytes.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(totalPushUncompressedBytes))
@@ -317,15 +407,15 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 	}
 
 	// Next we split profiles by labels. New profiles should be closed after use.
-	profileSeries := make([]*distributormodel.ProfileSeries, 0, len(req.Series))
-	newProfiles := make([]*pprof.Profile, 0, 2*len(req.Series))
+	profileSeries := make([]*distributormodel.ProfileSeries, 0, len(seriesSampled))
+	newProfiles := make([]*pprof.Profile, 0, 2*len(seriesSampled))
 	defer func() {
 		for _, p := range newProfiles {
 			p.Close()
 		}
 	}()
 
-	for _, series := range req.Series {
+	for _, series := range seriesSampled {
 		s := &distributormodel.ProfileSeries{
 			Labels:  series.Labels,
 			Samples: make([]*distributormodel.ProfileSample, 0, len(series.Samples)),
@@ -359,6 +449,25 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 		}
 	}
 
+	profileSeries = d.relabelSeries(tenantID, profileSeries)
+	if window := d.limits.DistributorAggregationWindow(tenantID); window > 0 {
+		var agg *aggregation
+		if profileSeries, agg, err = d.aggregateSeries(ctx, tenantID, window, profileSeries, &newProfiles); err != nil {
+			return nil, err
+		}
+		// The pushes that joined the windows opened by this one get the
+		// result of forwarding the merged profiles, and this one returns
+		// once the windows it joined are forwarded.
+		defer func() {
+			if err = agg.done(ctx, err); err != nil {
+				resp = nil
+			}
+		}()
+	}
+	if len(profileSeries) == 0 {
+		return connect.NewResponse(&pushv1.PushResponse{}), nil
+	}
+
 	// Validate the labels again and generate tokens for shuffle sharding.
 	keys := make([]uint32, len(profileSeries))
 	for i, series := range profileSeries {
@@ -369,6 +478,7 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 		}
 		keys[i] = TokenFor(tenantID, phlaremodel.LabelPairsString(series.Labels))
 	}
+	d.ingestionStatus.observe(tenantID, profileSeries, now.Time())
 
 	profiles := make([]*profileTracker, 0, len(profileSeries))
 	for _, series := range profileSeries {
@@ -385,6 +495,16 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 		profiles = append(profiles, &profileTracker{profile: series})
 	}
 
+	if d.ingestWriter != nil {
+		// The ingesters consume the profiles from the ingest storage:
+		// the replication is handled by Kafka.
+		if err = d.ingestWriter.WriteSync(ctx, tenantID, newPushRequest(profiles)); err != nil {
+			return nil, connect.NewError(connect.CodeUnavailable, err)
+		}
+		billing.IngestedBytes.WithLabelValues(tenantID).Add(float64(totalPushUncompressedBytes))
+		return connect.NewResponse(&pushv1.PushResponse{}), nil
+	}
+
 	const maxExpectedReplicationSet = 5 // typical replication factor 3 plus one for inactive plus one for luck
 	var descs [maxExpectedReplicationSet]ring.InstanceDesc
 
@@ -426,6 +546,7 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 	case err := <-tracker.err:
 		return nil, err
 	case <-tracker.done:
+		billing.IngestedBytes.WithLabelValues(tenantID).Add(float64(totalPushUncompressedBytes))
 		return connect.NewResponse(&pushv1.PushResponse{}), nil
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -457,6 +578,27 @@ func profileSizeBytes(p *googlev1.Profile) (symbols, samples int64) {
 	return
 }
 
+// writeMalformedToDeadLetterQueue writes the push request that could
+// not be parsed to the dead-letter queue, as is.
+func (d *Distributor) writeMalformedToDeadLetterQueue(ctx context.Context, req *pushv1.PushRequest, err error) {
+	if d.deadLetterQueue == nil {
+		return
+	}
+	tenantID, tenantErr := tenant.ExtractTenantIDFromContext(ctx)
+	if tenantErr != nil {
+		return
+	}
+	raw := &distributormodel.PushRequest{Series: make([]*distributormodel.ProfileSeries, 0, len(req.Series))}
+	for _, s := range req.Series {
+		series := &distributormodel.ProfileSeries{Labels: s.Labels}
+		for _, x := range s.Samples {
+			series.Samples = append(series.Samples, &distributormodel.ProfileSample{RawProfile: x.RawProfile})
+		}
+		raw.Series = append(raw.Series, series)
+	}
+	d.deadLetterQueue.write(tenantID, validation.MalformedProfile, err, raw)
+}
+
 func (d *Distributor) sendProfiles(ctx context.Context, ingester ring.InstanceDesc, profileTrackers []*profileTracker, pushTracker *pushTracker) {
 	err := d.sendProfilesErr(ctx, ingester, profileTrackers)
 	// If we succeed, decrement each sample's pending count by one.  If we reach
@@ -493,10 +635,14 @@ func (d *Distributor) sendProfilesErr(ctx context.Context, ingester ring.Instanc
 		return err
 	}
 
-	req := connect.NewRequest(&pushv1.PushRequest{
-		Series: make([]*pushv1.RawProfileSeries, 0, len(profileTrackers)),
-	})
+	_, err = c.(PushClient).Push(ctx, connect.NewRequest(newPushRequest(profileTrackers)))
+	return err
+}
 
+func newPushRequest(profileTrackers []*profileTracker) *pushv1.PushRequest {
+	req := &pushv1.PushRequest{
+		Series: make([]*pushv1.RawProfileSeries, 0, len(profileTrackers)),
+	}
 	for _, p := range profileTrackers {
 		series := &pushv1.RawProfileSeries{
 			Labels:  p.profile.Labels,
@@ -508,11 +654,9 @@ func (d *Distributor) sendProfilesErr(ctx context.Context, ingester ring.Instanc
 				ID:         sample.ID,
 			})
 		}
-		req.Msg.Series = append(req.Msg.Series, series)
+		req.Series = append(req.Series, series)
 	}
-
-	_, err = c.(PushClient).Push(ctx, req)
-	return err
+	return req
 }
 
 func (d *Distributor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -562,6 +706,84 @@ func (d *Distributor) limitMaxSessionsPerSeries(tenantID string, labels phlaremo
 	return labels
 }
 
+// sampleSeries returns the series with the profiles kept by the tenant
+// sampling ratio configured for the profile type, and the number of
+// profiles sampled out. The decision is made based on the series labels
+// and the profile timestamp, therefore all distributors agree on it.
+// Sample values of the kept profiles are scaled by the inverse ratio. The
+// cumulative profiles are kept as is.
+func (d *Distributor) sampleSeries(tenantID string, series []*distributormodel.ProfileSeries) ([]*distributormodel.ProfileSeries, int) {
+	ratios := d.limits.IngestionSampleRatio(tenantID)
+	if len(ratios) == 0 {
+		return series, 0
+	}
+	var sampledOut int
+	kept := make([]*distributormodel.ProfileSeries, 0, len(series))
+	for _, s := range series {
+		profName := phlaremodel.Labels(s.Labels).Get(ProfileName)
+		ratio, ok := ratios[profName]
+		// The values of the cumulative profiles can not be scaled, and
+		// the deltas between them depend on all of them.
+		if !ok || ratio >= 1 || !isDeltaSeries(s.Labels) {
+			kept = append(kept, s)
+			continue
+		}
+		labelsHash := phlaremodel.Labels(s.Labels).Hash()
+		samples := make([]*distributormodel.ProfileSample, 0, len(s.Samples))
+		for _, x := range s.Samples {
+			if !keepSample(labelsHash, x.Profile.TimeNanos, ratio) {
+				sampledOut++
+				d.metrics.sampledOutProfiles.WithLabelValues(profName, tenantID).Inc()
+				continue
+			}
+			for _, sample := range x.Profile.Sample {
+				for i, v := range sample.Value {
+					sample.Value[i] = int64(float64(v) / ratio)
+				}
+			}
+			samples = append(samples, x)
+		}
+		if len(samples) > 0 {
+			kept = append(kept, &distributormodel.ProfileSeries{
+				Labels:  s.Labels,
+				Samples: samples,
+			})
+		}
+	}
+	return kept, sampledOut
+}
+
+func keepSample(labelsHash uint64, timeNanos int64, ratio float64) bool {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], labelsHash)
+	binary.LittleEndian.PutUint64(b[8:], uint64(timeNanos))
+	h := xxhash.Sum64(b[:])
+	return float64(h) < ratio*math.MaxUint64
+}
+
+// relabelSeries applies the tenant relabeling rules to the series labels.
+// Series dropped by the rules are removed.
+func (d *Distributor) relabelSeries(tenantID string, series []*distributormodel.ProfileSeries) []*distributormodel.ProfileSeries {
+	rules := d.limits.IngestionRelabelingRules(tenantID)
+	if len(rules) == 0 {
+		return series
+	}
+	kept := series[:0]
+	for _, s := range series {
+		ls, keep := relabel.Process(phlaremodel.Labels(s.Labels).ToPrometheusLabels(), rules...)
+		if !keep {
+			validation.DiscardedProfiles.WithLabelValues(string(validation.DroppedByRelabelRules), tenantID).Add(float64(len(s.Samples)))
+			continue
+		}
+		s.Labels = make([]*typesv1.LabelPair, 0, ls.Len())
+		ls.Range(func(l labels.Label) {
+			s.Labels = append(s.Labels, &typesv1.LabelPair{Name: l.Name, Value: l.Value})
+		})
+		kept = append(kept, s)
+	}
+	return kept
+}
+
 // mergeSeriesAndSampleLabels merges sample labels with
 // series labels. Series labels take precedence.
 func mergeSeriesAndSampleLabels(p *googlev1.Profile, sl []*typesv1.LabelPair, pl []*googlev1.Label) []*typesv1.LabelPair {
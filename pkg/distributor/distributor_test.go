@@ -22,16 +22,21 @@ import (
 	"github.com/grafana/dskit/ring/client"
 	"github.com/grafana/dskit/services"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlarepprof "github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/util"
 
+	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/clientpool"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
 	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/testhelper"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -62,7 +67,7 @@ func Test_ConnectPush(t *testing.T) {
 		{Addr: "foo"},
 	}, 3), &poolFactory{func(addr string) (client.PoolClient, error) {
 		return ing, nil
-	}}, newOverrides(t), nil, log.NewLogfmtLogger(os.Stdout))
+	}}, newOverrides(t), nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	mux.Handle(pushv1connect.NewPusherServiceHandler(d, connect.WithInterceptors(tenant.NewAuthInterceptor(true))))
@@ -92,6 +97,53 @@ func Test_ConnectPush(t *testing.T) {
 	require.Equal(t, 3, len(ing.requests[0].Series))
 }
 
+func Test_ConnectPushBatch(t *testing.T) {
+	mux := http.NewServeMux()
+	ing := newFakeIngester(t, false)
+	d, err := New(Config{
+		DistributorRing: ringConfig,
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "foo"},
+	}, 3), &poolFactory{func(addr string) (client.PoolClient, error) {
+		return ing, nil
+	}}, newOverrides(t), nil, nil, log.NewLogfmtLogger(os.Stdout))
+
+	require.NoError(t, err)
+	mux.Handle(pushv1connect.NewPusherServiceHandler(d, connect.WithInterceptors(tenant.NewAuthInterceptor(true))))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	client := pushv1connect.NewPusherServiceClient(http.DefaultClient, s.URL, connect.WithInterceptors(tenant.NewAuthInterceptor(true)))
+
+	resp, err := client.PushBatch(tenant.InjectTenantID(context.Background(), "foo"), connect.NewRequest(&pushv1.PushBatchRequest{
+		Labels: []*typesv1.LabelPair{
+			{Name: "cluster", Value: "us-central1"},
+			{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+			{Name: "__name__", Value: "batch"},
+		},
+		Series: []*pushv1.RawProfileSeries{
+			{
+				Labels:  []*typesv1.LabelPair{{Name: "__name__", Value: "cpu"}},
+				Samples: []*pushv1.RawSample{{RawProfile: testProfile(t)}},
+			},
+			{
+				Labels:  []*typesv1.LabelPair{{Name: "__name__", Value: "cpu"}, {Name: "pod", Value: "a"}},
+				Samples: []*pushv1.RawSample{{RawProfile: testProfile(t)}},
+			},
+		},
+	}))
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Len(t, ing.requests, 1)
+	require.Equal(t, 6, len(ing.requests[0].Series))
+	for _, series := range ing.requests[0].Series {
+		ls := phlaremodel.Labels(series.Labels)
+		assert.Equal(t, "us-central1", ls.Get("cluster"))
+		assert.Equal(t, "svc", ls.Get(phlaremodel.LabelNameServiceName))
+		assert.Equal(t, "cpu", ls.Get("__name__"))
+	}
+}
+
 func Test_Replication(t *testing.T) {
 	ingesters := map[string]*fakeIngester{
 		"1": newFakeIngester(t, false),
@@ -121,7 +173,7 @@ func Test_Replication(t *testing.T) {
 		{Addr: "3"},
 	}, 3), &poolFactory{f: func(addr string) (client.PoolClient, error) {
 		return ingesters[addr], nil
-	}}, newOverrides(t), nil, log.NewLogfmtLogger(os.Stdout))
+	}}, newOverrides(t), nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	// only 1 ingester failing should be fine.
 	resp, err := d.Push(ctx, req)
@@ -143,7 +195,7 @@ func Test_Subservices(t *testing.T) {
 		{Addr: "foo"},
 	}, 1), &poolFactory{f: func(addr string) (client.PoolClient, error) {
 		return ing, nil
-	}}, newOverrides(t), nil, log.NewLogfmtLogger(os.Stdout))
+	}}, newOverrides(t), nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	require.NoError(t, d.StartAsync(context.Background()))
@@ -158,6 +210,110 @@ func Test_Subservices(t *testing.T) {
 	}, 5*time.Second, 100*time.Millisecond)
 }
 
+func Test_RelabelSeries(t *testing.T) {
+	d, err := New(
+		Config{DistributorRing: ringConfig},
+		testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "foo"}}, 3),
+		&poolFactory{f: func(addr string) (client.PoolClient, error) { return newFakeIngester(t, false), nil }},
+		validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+			l := validation.MockDefaultLimits()
+			l.IngestionRelabelingRules = []*relabel.Config{
+				{Action: relabel.LabelDrop, Regex: relabel.MustNewRegexp("pod_uid")},
+				{Action: relabel.Drop, SourceLabels: []model.LabelName{"env"}, Regex: relabel.MustNewRegexp("dev")},
+				{
+					Action:       relabel.Replace,
+					SourceLabels: []model.LabelName{phlaremodel.LabelNameServiceName},
+					Regex:        relabel.MustNewRegexp("old-(.*)"),
+					TargetLabel:  phlaremodel.LabelNameServiceName,
+					Replacement:  "new-$1",
+				},
+			}
+			tenantLimits["user-1"] = l
+		}), nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	series := []*distributormodel.ProfileSeries{
+		{Labels: []*typesv1.LabelPair{
+			{Name: "__name__", Value: "cpu"},
+			{Name: "env", Value: "prod"},
+			{Name: "pod_uid", Value: "123"},
+			{Name: phlaremodel.LabelNameServiceName, Value: "old-svc"},
+		}},
+		{Labels: []*typesv1.LabelPair{
+			{Name: "__name__", Value: "cpu"},
+			{Name: "env", Value: "dev"},
+			{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+		}},
+	}
+	original := series[0].Labels
+
+	assert.Equal(t, series, d.relabelSeries("user-2", series))
+	assert.Equal(t, []*distributormodel.ProfileSeries{
+		{Labels: []*typesv1.LabelPair{
+			{Name: "__name__", Value: "cpu"},
+			{Name: "env", Value: "prod"},
+			{Name: phlaremodel.LabelNameServiceName, Value: "new-svc"},
+		}},
+	}, d.relabelSeries("user-1", series))
+	assert.Equal(t, "old-svc", phlaremodel.Labels(original).Get(phlaremodel.LabelNameServiceName))
+}
+
+func Test_SampleSeries(t *testing.T) {
+	d, err := New(
+		Config{DistributorRing: ringConfig},
+		testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "foo"}}, 3),
+		&poolFactory{f: func(addr string) (client.PoolClient, error) { return newFakeIngester(t, false), nil }},
+		validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+			l := validation.MockDefaultLimits()
+			l.IngestionSampleRatio = map[string]float64{"wall": 0.25}
+			tenantLimits["user-1"] = l
+		}), nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	newSeries := func(name string, labels ...*typesv1.LabelPair) *distributormodel.ProfileSeries {
+		s := &distributormodel.ProfileSeries{
+			Labels: append([]*typesv1.LabelPair{
+				{Name: "__name__", Value: name},
+				{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+			}, labels...),
+		}
+		for i := 0; i < 1000; i++ {
+			s.Samples = append(s.Samples, &distributormodel.ProfileSample{
+				Profile: phlarepprof.RawFromProto(&googlev1.Profile{
+					TimeNanos: int64(i) * 1e9,
+					Sample:    []*googlev1.Sample{{Value: []int64{10}}},
+				}),
+			})
+		}
+		return s
+	}
+
+	series := []*distributormodel.ProfileSeries{newSeries("wall"), newSeries("process_cpu")}
+	kept, sampledOut := d.sampleSeries("user-1", series)
+	require.Len(t, kept, 2)
+	assert.Equal(t, 1000, len(kept[0].Samples)+sampledOut)
+	assert.InDelta(t, 250, len(kept[0].Samples), 50)
+	for _, x := range kept[0].Samples {
+		assert.Equal(t, []int64{40}, x.Profile.Sample[0].Value)
+		// The decision does not depend on the distributor.
+		assert.True(t, keepSample(phlaremodel.Labels(series[0].Labels).Hash(), x.Profile.TimeNanos, 0.25))
+	}
+	assert.Equal(t, series[1], kept[1])
+	assert.Equal(t, []int64{10}, kept[1].Samples[0].Profile.Sample[0].Value)
+
+	kept, sampledOut = d.sampleSeries("user-2", series)
+	assert.Equal(t, series, kept)
+	assert.Zero(t, sampledOut)
+
+	// Cumulative profiles are neither sampled nor scaled.
+	series = []*distributormodel.ProfileSeries{newSeries("wall", &typesv1.LabelPair{Name: phlaremodel.LabelNameDelta, Value: "true"})}
+	kept, sampledOut = d.sampleSeries("user-1", series)
+	assert.Equal(t, series, kept)
+	assert.Zero(t, sampledOut)
+	assert.Len(t, kept[0].Samples, 1000)
+	assert.Equal(t, []int64{10}, kept[0].Samples[0].Profile.Sample[0].Value)
+}
+
 func testProfile(t *testing.T) []byte {
 	t.Helper()
 
@@ -268,7 +424,7 @@ func Test_Limits(t *testing.T) {
 				{Addr: "foo"},
 			}, 3), &poolFactory{f: func(addr string) (client.PoolClient, error) {
 				return ing, nil
-			}}, tc.overrides, nil, log.NewLogfmtLogger(os.Stdout))
+			}}, tc.overrides, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 			require.NoError(t, err)
 			mux.Handle(pushv1connect.NewPusherServiceHandler(d, connect.WithInterceptors(tenant.NewAuthInterceptor(true))))
@@ -352,7 +508,7 @@ func Test_Sessions_Limit(t *testing.T) {
 					l := validation.MockDefaultLimits()
 					l.MaxSessionsPerSeries = tc.maxSessions
 					tenantLimits["user-1"] = l
-				}), nil, log.NewLogfmtLogger(os.Stdout))
+				}), nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 			require.NoError(t, err)
 			assert.Equal(t, tc.expectedLabels, d.limitMaxSessionsPerSeries("user-1", tc.seriesLabels))
@@ -369,7 +525,7 @@ func TestBadPushRequest(t *testing.T) {
 		{Addr: "foo"},
 	}, 3), &poolFactory{f: func(addr string) (client.PoolClient, error) {
 		return ing, nil
-	}}, newOverrides(t), nil, log.NewLogfmtLogger(os.Stdout))
+	}}, newOverrides(t), nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	mux.Handle(pushv1connect.NewPusherServiceHandler(d, connect.WithInterceptors(tenant.NewAuthInterceptor(true))))
@@ -453,6 +609,7 @@ func TestPush_ShuffleSharding(t *testing.T) {
 		}},
 		overrides,
 		nil,
+		nil,
 		log.NewLogfmtLogger(os.Stdout),
 	)
 	require.NoError(t, err)
@@ -0,0 +1,174 @@
+package distributor
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/grafana/pyroscope/pkg/billing"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+var (
+	// Series not received for activeSeriesTimeout are not active anymore.
+	activeSeriesTimeout = 10 * time.Minute
+	// Services not received for inactiveServiceTimeout are forgotten.
+	inactiveServiceTimeout = 24 * time.Hour
+)
+
+// ingestionStatus tracks the series received by the distributor, by
+// service and profile type, to report the ingestion status of tenants.
+type ingestionStatus struct {
+	mu      sync.Mutex
+	tenants map[string]*tenantIngestionStatus
+}
+
+type tenantIngestionStatus struct {
+	// Last received time of the services, by service and profile type.
+	services map[serviceProfileType]int64
+	series   map[uint64]activeSeries
+}
+
+type serviceProfileType struct {
+	serviceName string
+	profileType string
+}
+
+type activeSeries struct {
+	serviceProfileType
+	lastReceived int64
+}
+
+func newIngestionStatus() *ingestionStatus {
+	return &ingestionStatus{tenants: make(map[string]*tenantIngestionStatus)}
+}
+
+func (s *ingestionStatus) observe(tenantID string, series []*distributormodel.ProfileSeries, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[tenantID]
+	if !ok {
+		t = &tenantIngestionStatus{
+			services: make(map[serviceProfileType]int64),
+			series:   make(map[uint64]activeSeries),
+		}
+		s.tenants[tenantID] = t
+	}
+	ts := now.UnixNano()
+	for _, x := range series {
+		ls := phlaremodel.Labels(x.Labels)
+		k := serviceProfileType{
+			serviceName: ls.Get(phlaremodel.LabelNameServiceName),
+			profileType: ls.Get(phlaremodel.LabelNameProfileName),
+		}
+		t.services[k] = ts
+		t.series[ls.Hash()] = activeSeries{serviceProfileType: k, lastReceived: ts}
+	}
+}
+
+// cleanup removes the series that are not active anymore, and the
+// services not received for a while. The active series of the tenants are
+// reported for their usage records.
+func (s *ingestionStatus) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := now.UnixNano()
+	for tenantID, t := range s.tenants {
+		for h, x := range t.series {
+			if ts-x.lastReceived > int64(activeSeriesTimeout) {
+				delete(t.series, h)
+			}
+		}
+		for k, lastReceived := range t.services {
+			if ts-lastReceived > int64(inactiveServiceTimeout) {
+				delete(t.services, k)
+			}
+		}
+		if len(t.services) == 0 {
+			delete(s.tenants, tenantID)
+			billing.ActiveSeries.DeleteLabelValues(tenantID)
+			continue
+		}
+		billing.ActiveSeries.WithLabelValues(tenantID).Set(float64(len(t.series)))
+	}
+}
+
+type IngestionStatusResponse struct {
+	Tenant       string                   `json:"tenant"`
+	ActiveSeries int                      `json:"active_series"`
+	Services     []ServiceIngestionStatus `json:"services"`
+	// Discarded profiles and bytes by reason, since the process started.
+	DiscardedSamples map[string]float64 `json:"discarded_samples"`
+	DiscardedBytes   map[string]float64 `json:"discarded_bytes"`
+}
+
+type ServiceIngestionStatus struct {
+	ServiceName  string    `json:"service_name"`
+	ProfileType  string    `json:"profile_type"`
+	LastReceived time.Time `json:"last_received"`
+	ActiveSeries int       `json:"active_series"`
+}
+
+func (s *ingestionStatus) status(tenantID string, now time.Time) IngestionStatusResponse {
+	resp := IngestionStatusResponse{
+		Tenant:           tenantID,
+		Services:         []ServiceIngestionStatus{},
+		DiscardedSamples: validation.DiscardedProfilesByReason(tenantID),
+		DiscardedBytes:   validation.DiscardedBytesByReason(tenantID),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[tenantID]
+	if !ok {
+		return resp
+	}
+	ts := now.UnixNano()
+	active := make(map[serviceProfileType]int)
+	for _, x := range t.series {
+		if ts-x.lastReceived <= int64(activeSeriesTimeout) {
+			active[x.serviceProfileType]++
+			resp.ActiveSeries++
+		}
+	}
+	for k, lastReceived := range t.services {
+		resp.Services = append(resp.Services, ServiceIngestionStatus{
+			ServiceName:  k.serviceName,
+			ProfileType:  k.profileType,
+			LastReceived: time.Unix(0, lastReceived).UTC(),
+			ActiveSeries: active[k],
+		})
+	}
+	sort.Slice(resp.Services, func(i, j int) bool {
+		a, b := resp.Services[i], resp.Services[j]
+		if a.ServiceName != b.ServiceName {
+			return a.ServiceName < b.ServiceName
+		}
+		return a.ProfileType < b.ProfileType
+	})
+	return resp
+}
+
+// IngestionStatusHandler reports the last time the services of the tenant
+// were received, by profile type, the active series, and the number of
+// discarded profiles by reason. Each distributor reports the profiles it
+// received. A tenant can only request its own status.
+func (d *Distributor) IngestionStatusHandler(w http.ResponseWriter, req *http.Request) {
+	tenantID := mux.Vars(req)["tenant"]
+	if tenantID == "" {
+		http.Error(w, "Tenant ID can't be empty", http.StatusBadRequest)
+		return
+	}
+	if err := tenant.CheckTenant(req.Context(), tenantID); err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusForbidden)
+		return
+	}
+	util.WriteJSONResponse(w, d.ingestionStatus.status(tenantID, time.Now()))
+}
@@ -0,0 +1,95 @@
+package distributor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func statusSeries(labels ...string) *distributormodel.ProfileSeries {
+	ls := make([]*typesv1.LabelPair, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		ls = append(ls, &typesv1.LabelPair{Name: labels[i], Value: labels[i+1]})
+	}
+	return &distributormodel.ProfileSeries{Labels: ls}
+}
+
+func Test_IngestionStatus(t *testing.T) {
+	s := newIngestionStatus()
+	t0 := time.Unix(1000, 0).UTC()
+	s.observe("tenant-a", []*distributormodel.ProfileSeries{
+		statusSeries("__name__", "process_cpu", "service_name", "api", "pod", "a"),
+		statusSeries("__name__", "process_cpu", "service_name", "api", "pod", "b"),
+		statusSeries("__name__", "memory", "service_name", "api", "pod", "a"),
+	}, t0)
+	s.observe("tenant-a", []*distributormodel.ProfileSeries{
+		statusSeries("__name__", "process_cpu", "service_name", "worker"),
+	}, t0.Add(5*time.Minute))
+	s.observe("tenant-b", []*distributormodel.ProfileSeries{
+		statusSeries("__name__", "process_cpu", "service_name", "other"),
+	}, t0)
+	validation.DiscardedProfiles.WithLabelValues(string(validation.RateLimited), "tenant-a").Add(2)
+
+	status := s.status("tenant-a", t0.Add(6*time.Minute))
+	require.Equal(t, "tenant-a", status.Tenant)
+	require.Equal(t, 4, status.ActiveSeries)
+	require.Equal(t, []ServiceIngestionStatus{
+		{ServiceName: "api", ProfileType: "memory", LastReceived: t0, ActiveSeries: 1},
+		{ServiceName: "api", ProfileType: "process_cpu", LastReceived: t0, ActiveSeries: 2},
+		{ServiceName: "worker", ProfileType: "process_cpu", LastReceived: t0.Add(5 * time.Minute), ActiveSeries: 1},
+	}, status.Services)
+	require.Equal(t, map[string]float64{string(validation.RateLimited): 2}, status.DiscardedSamples)
+
+	// The api series are not active anymore, but the service is still reported.
+	s.cleanup(t0.Add(11 * time.Minute))
+	status = s.status("tenant-a", t0.Add(11*time.Minute))
+	require.Equal(t, 1, status.ActiveSeries)
+	require.Len(t, status.Services, 3)
+	require.Equal(t, 0, status.Services[0].ActiveSeries)
+
+	s.cleanup(t0.Add(25 * time.Hour))
+	require.Empty(t, s.status("tenant-a", t0.Add(25*time.Hour)).Services)
+	require.Empty(t, s.tenants)
+}
+
+func Test_IngestionStatusHandler(t *testing.T) {
+	d := &Distributor{ingestionStatus: newIngestionStatus()}
+	d.ingestionStatus.observe("my-tenant", []*distributormodel.ProfileSeries{
+		statusSeries(phlaremodel.LabelNameProfileName, "process_cpu", phlaremodel.LabelNameServiceName, "api"),
+	}, time.Now())
+
+	request := func(tenantID string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/v1/tenant/"+tenantID+"/ingestion-status", nil)
+		req = req.WithContext(tenant.InjectTenantID(req.Context(), "my-tenant"))
+		return mux.SetURLVars(req, map[string]string{"tenant": tenantID})
+	}
+	rec := httptest.NewRecorder()
+	d.IngestionStatusHandler(rec, request("my-tenant"))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp IngestionStatusResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Equal(t, "my-tenant", resp.Tenant)
+	require.Equal(t, 1, resp.ActiveSeries)
+	require.Len(t, resp.Services, 1)
+	require.Equal(t, "api", resp.Services[0].ServiceName)
+
+	rec = httptest.NewRecorder()
+	d.IngestionStatusHandler(rec, httptest.NewRequest("GET", "/api/v1/tenant//ingestion-status", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	d.IngestionStatusHandler(rec, request("other-tenant"))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -17,6 +17,9 @@ type metrics struct {
 	receivedSamplesBytes      *prometheus.HistogramVec
 	receivedSymbolsBytes      *prometheus.HistogramVec
 	replicationFactor         prometheus.Gauge
+	sampledOutProfiles        *prometheus.CounterVec
+	quotaRemaining            *prometheus.GaugeVec
+	aggregatedProfiles        *prometheus.CounterVec
 }
 
 func newMetrics(reg prometheus.Registerer) *metrics {
@@ -71,6 +74,30 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 			},
 			[]string{"type", "tenant"},
 		),
+		sampledOutProfiles: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_sampled_out_profiles_total",
+				Help:      "The number of profiles dropped by the ingestion sampling.",
+			},
+			[]string{"type", "tenant"},
+		),
+		quotaRemaining: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_ingestion_quota_remaining",
+				Help:      "The ingestion quota remaining in the local token bucket of the distributor, by resource (bytes or profiles).",
+			},
+			[]string{"resource", "tenant"},
+		),
+		aggregatedProfiles: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_aggregated_profiles_total",
+				Help:      "The number of profiles merged into a profile of the same series pushed within the aggregation window.",
+			},
+			[]string{"tenant"},
+		),
 	}
 	if reg != nil {
 		reg.MustRegister(
@@ -80,6 +107,9 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 			m.receivedSamplesBytes,
 			m.receivedSymbolsBytes,
 			m.replicationFactor,
+			m.sampledOutProfiles,
+			m.quotaRemaining,
+			m.aggregatedProfiles,
 		)
 	}
 	return m
@@ -9,6 +9,9 @@ type RawProfileType string
 
 const RawProfileTypePPROF = RawProfileType("pprof")
 const RawProfileTypeJFR = RawProfileType("jfr")
+const RawProfileTypeOTLP = RawProfileType("otlp")
+const RawProfileTypePerf = RawProfileType("perf")
+const RawProfileTypeNettrace = RawProfileType("nettrace")
 
 type PushRequest struct {
 	RawProfileSize int
@@ -0,0 +1,138 @@
+package distributor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"golang.org/x/time/rate"
+)
+
+const (
+	quotaResourceBytes    = "bytes"
+	quotaResourceProfiles = "profiles"
+)
+
+// quotaLimiter enforces a per-tenant usage quota expressed per minute.
+// The quota is shared by the healthy distributors: each of them keeps
+// a local token bucket that refills at quota/N per minute and holds at
+// most one minute worth of tokens.
+type quotaLimiter struct {
+	resource string
+	quota    func(tenantID string) float64
+	ring     ReadLifecycler
+
+	mu      sync.Mutex
+	tenants map[string]*rate.Limiter
+}
+
+func newQuotaLimiter(resource string, quota func(tenantID string) float64, ring ReadLifecycler) *quotaLimiter {
+	return &quotaLimiter{
+		resource: resource,
+		quota:    quota,
+		ring:     ring,
+		tenants:  make(map[string]*rate.Limiter),
+	}
+}
+
+// reserve takes n tokens from the tenant bucket. The caller must check
+// the reservation delay and cancel it if the request is not admitted.
+// It returns nil if the tenant has no quota configured.
+func (q *quotaLimiter) reserve(now time.Time, tenantID string, n int) *rate.Reservation {
+	perMinute := q.quota(tenantID)
+	if perMinute <= 0 {
+		return nil
+	}
+	if numDistributors := q.ring.HealthyInstancesCount(); numDistributors > 0 {
+		perMinute /= float64(numDistributors)
+	}
+	limit := rate.Limit(perMinute / 60)
+	burst := int(math.Max(1, perMinute))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lim, ok := q.tenants[tenantID]
+	if !ok {
+		lim = rate.NewLimiter(limit, burst)
+		q.tenants[tenantID] = lim
+	} else if lim.Limit() != limit || lim.Burst() != burst {
+		lim.SetLimitAt(now, limit)
+		lim.SetBurstAt(now, burst)
+	}
+	return lim.ReserveN(now, n)
+}
+
+// remaining returns the number of tokens left in the tenant bucket.
+func (q *quotaLimiter) remaining(now time.Time, tenantID string) (float64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lim, ok := q.tenants[tenantID]
+	if !ok {
+		return 0, false
+	}
+	return lim.TokensAt(now), true
+}
+
+// checkQuotas admits the request if it fits both the bytes and the
+// profiles quotas of the tenant. Otherwise, no quota is consumed and
+// the returned error carries a Retry-After hint: the time it takes the
+// token buckets to refill enough to admit the request.
+func (d *Distributor) checkQuotas(now time.Time, tenantID string, totalBytes, totalProfiles int64) error {
+	requests := []struct {
+		limiter *quotaLimiter
+		n       int64
+	}{
+		{limiter: d.bytesQuota, n: totalBytes},
+		{limiter: d.profilesQuota, n: totalProfiles},
+	}
+	var (
+		reservations = make([]*rate.Reservation, 0, len(requests))
+		retryAfter   time.Duration
+		err          error
+	)
+	for _, r := range requests {
+		res := r.limiter.reserve(now, tenantID, int(r.n))
+		if res == nil {
+			continue
+		}
+		reservations = append(reservations, res)
+		if !res.OK() {
+			// The request does not fit the bucket at all:
+			// retrying it will not help.
+			err = fmt.Errorf("request of %d %s exceeds the ingestion quota (%v %s per minute)",
+				r.n, r.limiter.resource, r.limiter.quota(tenantID), r.limiter.resource)
+			retryAfter = 0
+			break
+		}
+		if delay := res.DelayFrom(now); delay > 0 {
+			if err == nil {
+				err = fmt.Errorf("ingestion quota (%v %s per minute) exceeded while adding %d %s",
+					r.limiter.quota(tenantID), r.limiter.resource, r.n, r.limiter.resource)
+			}
+			if delay > retryAfter {
+				retryAfter = delay
+			}
+		}
+	}
+	if err != nil {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	for _, q := range []*quotaLimiter{d.bytesQuota, d.profilesQuota} {
+		if remaining, ok := q.remaining(now, tenantID); ok {
+			d.metrics.quotaRemaining.WithLabelValues(q.resource, tenantID).Set(math.Max(0, remaining))
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	connectErr := connect.NewError(connect.CodeResourceExhausted, err)
+	if retryAfter > 0 {
+		connectErr.Meta().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return connectErr
+}
@@ -0,0 +1,60 @@
+package distributor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_CheckQuotas(t *testing.T) {
+	overrides := validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+		l := validation.MockDefaultLimits()
+		l.IngestionQuotaBytesPerMinute = 1200
+		l.IngestionQuotaProfilesPerMinute = 120
+		tenantLimits["user-1"] = l
+	})
+	mockRing := newReadLifecyclerMock()
+	mockRing.On("HealthyInstancesCount").Return(2)
+	d := &Distributor{
+		metrics:       newMetrics(nil),
+		bytesQuota:    newQuotaLimiter(quotaResourceBytes, overrides.IngestionQuotaBytesPerMinute, mockRing),
+		profilesQuota: newQuotaLimiter(quotaResourceProfiles, overrides.IngestionQuotaProfilesPerMinute, mockRing),
+	}
+
+	// Each of the two distributors holds 600 bytes and 60 profiles per minute.
+	now := time.Unix(0, 0)
+	require.NoError(t, d.checkQuotas(now, "user-1", 500, 10))
+	assert.Equal(t, float64(100), testutil.ToFloat64(d.metrics.quotaRemaining.WithLabelValues(quotaResourceBytes, "user-1")))
+	assert.Equal(t, float64(50), testutil.ToFloat64(d.metrics.quotaRemaining.WithLabelValues(quotaResourceProfiles, "user-1")))
+
+	// 300 bytes need 200 more tokens: 20s at 10 bytes per second.
+	err := d.checkQuotas(now, "user-1", 300, 1)
+	require.Error(t, err)
+	var connectErr *connect.Error
+	require.ErrorAs(t, err, &connectErr)
+	assert.Equal(t, connect.CodeResourceExhausted, connectErr.Code())
+	assert.Equal(t, "20", connectErr.Meta().Get("Retry-After"))
+	// The rejected request does not consume the profiles quota.
+	assert.Equal(t, float64(50), testutil.ToFloat64(d.metrics.quotaRemaining.WithLabelValues(quotaResourceProfiles, "user-1")))
+
+	// 51 profiles need 1 more token: 1s at 1 profile per second.
+	err = d.checkQuotas(now, "user-1", 1, 51)
+	require.ErrorAs(t, err, &connectErr)
+	assert.Equal(t, "1", connectErr.Meta().Get("Retry-After"))
+	require.NoError(t, d.checkQuotas(now.Add(20*time.Second), "user-1", 300, 51))
+
+	// A request larger than the bucket can never be admitted.
+	err = d.checkQuotas(now, "user-1", 1000, 1)
+	require.ErrorAs(t, err, &connectErr)
+	assert.Equal(t, connect.CodeResourceExhausted, connectErr.Code())
+	assert.Empty(t, connectErr.Meta().Get("Retry-After"))
+
+	// Tenants without quotas are not limited.
+	require.NoError(t, d.checkQuotas(now, "user-2", 1<<30, 1<<20))
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/cache"
 	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/grpcclient"
 	"github.com/grafana/dskit/netutil"
@@ -30,6 +31,7 @@ import (
 	"github.com/grafana/dskit/tenant"
 
 	"github.com/grafana/pyroscope/pkg/frontend/frontendpb"
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
 	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerdiscovery"
 	"github.com/grafana/pyroscope/pkg/util/httpgrpc"
@@ -43,6 +45,9 @@ type Config struct {
 	WorkerConcurrency int               `yaml:"scheduler_worker_concurrency" category:"advanced"`
 	GRPCClientConfig  grpcclient.Config `yaml:"grpc_client_config" doc:"description=Configures the gRPC client used to communicate between the query-frontends and the query-schedulers."`
 
+	ResultsCache ResultsCacheConfig `yaml:"results_cache"`
+	SlowQueryLog SlowQueryLogConfig `yaml:"slow_query_log"`
+
 	// Used to find local IP address, that is sent to scheduler and querier-worker.
 	InfNames []string `yaml:"instance_interface_names" category:"advanced" doc:"default=[<private network interfaces>]"`
 
@@ -63,6 +68,8 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	f.StringVar(&cfg.Addr, "query-frontend.instance-addr", "", "IP address to advertise to the querier (via scheduler) (default is auto-detected from network interfaces).")
 
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-frontend.grpc-client-config", f)
+	cfg.ResultsCache.RegisterFlagsWithPrefix(f, "query-frontend.results-cache.")
+	cfg.SlowQueryLog.RegisterFlagsWithPrefix(f, "query-frontend.slow-query-log.")
 }
 
 func (cfg *Config) Validate() error {
@@ -70,6 +77,12 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("scheduler address cannot be specified when query-scheduler service discovery mode is set to '%s'", cfg.QuerySchedulerDiscovery.Mode)
 	}
 
+	if err := cfg.ResultsCache.Validate(); err != nil {
+		return err
+	}
+	if cfg.SlowQueryLog.Enabled && cfg.SlowQueryLog.MaxFingerprints <= 0 {
+		return fmt.Errorf("the slow query log requires a positive maximum number of fingerprints")
+	}
 	return cfg.GRPCClientConfig.Validate()
 }
 
@@ -90,14 +103,24 @@ type Frontend struct {
 	schedulerWorkers        *frontendSchedulerWorkers
 	schedulerWorkersWatcher *services.FailureWatcher
 	requests                *requestsInProgress
+
+	resultsCache       cache.Cache
+	resultsCacheHits   prometheus.Counter
+	resultsCacheMisses prometheus.Counter
+
+	slowQueryLog *SlowQueryLog
+
 	frontendpb.UnimplementedFrontendForQuerierServer
 }
 
 type Limits interface {
 	QuerySplitDuration(string) time.Duration
+	QueryShards(string) int
 	MaxQueryParallelism(string) int
 	MaxQueryLength(tenantID string) time.Duration
 	MaxQueryLookback(tenantID string) time.Duration
+	MaxCacheFreshness(tenantID string) time.Duration
+	QueryTimeout(tenantID string) time.Duration
 }
 
 type frontendRequest struct {
@@ -137,6 +160,11 @@ func NewFrontend(cfg Config, limits Limits, log log.Logger, reg prometheus.Regis
 		return nil, err
 	}
 
+	resultsCache, err := newResultsCache(cfg.ResultsCache, log, reg)
+	if err != nil {
+		return nil, err
+	}
+
 	f := &Frontend{
 		cfg:                     cfg,
 		log:                     log,
@@ -145,6 +173,10 @@ func NewFrontend(cfg Config, limits Limits, log log.Logger, reg prometheus.Regis
 		schedulerWorkers:        schedulerWorkers,
 		schedulerWorkersWatcher: services.NewFailureWatcher(),
 		requests:                newRequestsInProgress(),
+		resultsCache:            resultsCache,
+	}
+	if cfg.SlowQueryLog.Enabled {
+		f.slowQueryLog = NewSlowQueryLog(cfg.SlowQueryLog, log, reg)
 	}
 	// Randomize to avoid getting responses from queries sent before restart, which could lead to mixing results
 	// between different queries. Note that frontend verifies the user, so it cannot leak results between tenants.
@@ -165,10 +197,24 @@ func NewFrontend(cfg Config, limits Limits, log log.Logger, reg prometheus.Regis
 		return float64(f.schedulerWorkers.getWorkersCount())
 	})
 
+	f.resultsCacheHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "pyroscope_query_frontend_results_cache_hits_total",
+		Help: "Total number of sub-queries served from the results cache.",
+	})
+	f.resultsCacheMisses = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "pyroscope_query_frontend_results_cache_misses_total",
+		Help: "Total number of cacheable sub-queries not found in the results cache.",
+	})
+
 	f.Service = services.NewBasicService(f.starting, f.running, f.stopping)
 	return f, nil
 }
 
+// SlowQueryLog returns the log of the slow queries, nil if disabled.
+func (f *Frontend) SlowQueryLog() *SlowQueryLog {
+	return f.slowQueryLog
+}
+
 func (f *Frontend) starting(ctx context.Context) error {
 	f.schedulerWorkersWatcher.WatchService(f.schedulerWorkers)
 
@@ -209,6 +255,18 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest)
 		}
 	}
 
+	// The hint of the sub-query replaces the one of the original request, if
+	// any.
+	if hint, ok := prefetch.FromContext(ctx); ok {
+		headers := req.Headers[:0]
+		for _, h := range req.Headers {
+			if http.CanonicalHeaderKey(h.Key) != prefetch.HeaderName {
+				headers = append(headers, h)
+			}
+		}
+		req.Headers = append(headers, &httpgrpc.Header{Key: prefetch.HeaderName, Values: []string{hint.String()}})
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
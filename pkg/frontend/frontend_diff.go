@@ -18,6 +18,11 @@ func (f *Frontend) Diff(ctx context.Context,
 	*connect.Response[querierv1.DiffResponse], error,
 ) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceDiffProcedure)
+	if len(c.Msg.RightProfile) > 0 {
+		// The uploaded profile is compared by a querier: the left selection
+		// is not split.
+		return connectgrpc.RoundTripUnary[querierv1.DiffRequest, querierv1.DiffResponse](ctx, f, c)
+	}
 	g, ctx := errgroup.WithContext(ctx)
 
 	var left, right *phlaremodel.Tree
@@ -0,0 +1,20 @@
+package frontend
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/profileql"
+)
+
+// QueryRange evaluates the expression in the frontend: the series
+// selections are split and scheduled as SelectSeries requests.
+func (f *Frontend) QueryRange(ctx context.Context, c *connect.Request[querierv1.QueryRangeRequest]) (*connect.Response[querierv1.QueryRangeResponse], error) {
+	res, err := profileql.QueryRange(ctx, f, c.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
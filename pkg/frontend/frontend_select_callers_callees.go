@@ -0,0 +1,32 @@
+package frontend
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Frontend) SelectCallersCallees(ctx context.Context, c *connect.Request[querierv1.SelectCallersCalleesRequest]) (*connect.Response[querierv1.SelectCallersCalleesResponse], error) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectCallersCalleesProcedure)
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&querierv1.SelectCallersCalleesResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+	return connectgrpc.RoundTripUnary[querierv1.SelectCallersCalleesRequest, querierv1.SelectCallersCalleesResponse](ctx, f, c)
+}
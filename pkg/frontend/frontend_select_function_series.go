@@ -0,0 +1,32 @@
+package frontend
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Frontend) SelectFunctionSeries(ctx context.Context, c *connect.Request[querierv1.SelectFunctionSeriesRequest]) (*connect.Response[querierv1.SelectFunctionSeriesResponse], error) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectFunctionSeriesProcedure)
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&querierv1.SelectFunctionSeriesResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+	return connectgrpc.RoundTripUnary[querierv1.SelectFunctionSeriesRequest, querierv1.SelectFunctionSeriesResponse](ctx, f, c)
+}
@@ -0,0 +1,41 @@
+package frontend
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Frontend) SelectMergeSpanProfile(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeSpanProfileRequest]) (
+	*connect.Response[querierv1.SelectMergeSpanProfileResponse], error,
+) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectMergeSpanProfileProcedure)
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	interval := model.Interval{
+		Start: model.Time(c.Msg.Start),
+		End:   model.Time(c.Msg.End),
+	}
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, interval, model.Now())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&querierv1.SelectMergeSpanProfileResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+
+	return connectgrpc.RoundTripUnary[querierv1.SelectMergeSpanProfileRequest, querierv1.SelectMergeSpanProfileResponse](ctx, f, c)
+}
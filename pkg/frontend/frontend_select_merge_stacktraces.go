@@ -2,17 +2,23 @@ package frontend
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/grafana/dskit/tenant"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/common/model"
+	"go.uber.org/atomic"
 	"golang.org/x/sync/errgroup"
 
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -42,43 +48,171 @@ func (f *Frontend) SelectMergeStacktraces(ctx context.Context,
 		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{}), nil
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
-	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
-		g.SetLimit(maxConcurrent)
+	// Each time interval is further split into query shards: every shard
+	// selects a disjoint subset of the series, therefore the partial trees
+	// can be merged as is.
+	selectors, err := SplitSelectorByShard(c.Msg.LabelSelector, validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.QueryShards))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	m := phlaremodel.NewFlameGraphMerger()
+	var reqs []*connect.Request[querierv1.SelectMergeStacktracesRequest]
 	interval := validationutil.MaxDurationOrZeroPerTenant(tenantIDs, f.limits.QuerySplitDuration)
-	intervals := NewTimeIntervalIterator(time.UnixMilli(int64(validated.Start)), time.UnixMilli(int64(validated.End)), interval)
-
-	for intervals.Next() {
-		r := intervals.At()
-		g.Go(func() error {
-			req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeStacktracesRequest{
+	intervals, _ := iter.Slice[TimeInterval](NewTimeIntervalIterator(time.UnixMilli(int64(validated.Start)), time.UnixMilli(int64(validated.End)), interval))
+	for _, r := range intervals {
+		for _, selector := range selectors {
+			reqs = append(reqs, connectgrpc.CloneRequest(c, &querierv1.SelectMergeStacktracesRequest{
 				ProfileTypeID: c.Msg.ProfileTypeID,
-				LabelSelector: c.Msg.LabelSelector,
+				LabelSelector: selector,
 				Start:         r.Start.UnixMilli(),
 				End:           r.End.UnixMilli(),
 				MaxNodes:      c.Msg.MaxNodes,
-			})
-			resp, err := connectgrpc.RoundTripUnary[
+				FocusFunction: c.Msg.FocusFunction,
+				HideFunctions: c.Msg.HideFunctions,
+				MaxDepth:      c.Msg.MaxDepth,
+
+				AllowPartialResults: c.Msg.AllowPartialResults,
+				MergeProfileTypes:   c.Msg.MergeProfileTypes,
+				ExcludeStacks:       c.Msg.ExcludeStacks,
+			}))
+		}
+	}
+
+	if c.Msg.Explain {
+		return f.explainSelectMergeStacktraces(ctx, tenantIDs, reqs, interval, len(selectors))
+	}
+
+	// The statistics of the query are merged into the enclosing ones, if
+	// any, e.g. when the query is one of a cross-tenant query.
+	parent := stats.FromContext(ctx)
+	st, ctx := stats.ContextWithEmptyStats(ctx)
+	defer parent.Merge(st)
+	if interval > 0 {
+		st.AddSplitQueries(uint32(len(reqs) / len(selectors)))
+	}
+	if len(selectors) > 1 {
+		st.AddShardedQueries(uint32(len(reqs)))
+	}
+
+	if timeout := validationutil.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, f.limits.QueryTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// If partial results are allowed, the sub-queries that did not complete
+	// in time are skipped, and the response is marked as partial.
+	partial := atomic.NewBool(false)
+	g, gCtx := errgroup.WithContext(ctx)
+	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
+		g.SetLimit(maxConcurrent)
+	}
+
+	m := phlaremodel.NewFlameGraphMerger()
+	for i, req := range reqs {
+		req := req
+		// The requests of a time interval are followed by the ones of the
+		// next interval.
+		reqCtx := withNextIntervalHint(gCtx, intervals, i/len(selectors))
+		g.Go(func() error {
+			resp, err := roundTripCached[
 				querierv1.SelectMergeStacktracesRequest,
-				querierv1.SelectMergeStacktracesResponse](ctx, f, req)
+				querierv1.SelectMergeStacktracesResponse](reqCtx, f, req, time.UnixMilli(req.Msg.End))
 			if err != nil {
+				if c.Msg.AllowPartialResults && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					partial.Store(true)
+					return nil
+				}
 				return err
 			}
-			m.MergeFlameGraph(resp.Msg.Flamegraph)
+			if resp.Partial {
+				partial.Store(true)
+			}
+			m.MergeFlameGraph(resp.Flamegraph)
 			return nil
 		})
 	}
 
 	if err = g.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, connect.NewError(connect.CodeDeadlineExceeded, errors.New("the query exceeds the query timeout, narrow down the time range or the selector"))
+		}
 		return nil, err
 	}
 
 	t := m.Tree()
 	t.FormatNodeNames(phlaremodel.DropGoTypeParameters)
-	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+	res := connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
 		Flamegraph: phlaremodel.NewFlameGraph(t, c.Msg.GetMaxNodes()),
-	}), nil
+		Partial:    partial.Load(),
+	})
+	st.SetHeader(res.Header())
+	return res, nil
+}
+
+// SelectMergeStacktracesStream streams the flamegraph to the client in
+// chunks. The querier responses are still received whole through the
+// scheduler, but the complete response is never encoded at once.
+func (f *Frontend) SelectMergeStacktracesStream(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
+	stream *connect.ServerStream[querierv1.SelectMergeStacktracesResponse],
+) error {
+	res, err := f.SelectMergeStacktraces(ctx, c)
+	if err != nil {
+		return err
+	}
+	return connectgrpc.SendServerStream(stream, res.Header(), phlaremodel.SplitSelectMergeStacktracesResponse(res.Msg, phlaremodel.FlameGraphChunkNodes))
+}
+
+// explainSelectMergeStacktraces returns the plan of the query without
+// executing it: the sub-queries the query is split into, with their
+// results cache status and the plans of the queriers.
+func (f *Frontend) explainSelectMergeStacktraces(
+	ctx context.Context,
+	tenantIDs []string,
+	reqs []*connect.Request[querierv1.SelectMergeStacktracesRequest],
+	interval time.Duration,
+	shards int,
+) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	plans := make([]string, len(reqs))
+	g, gCtx := errgroup.WithContext(ctx)
+	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
+		g.SetLimit(maxConcurrent)
+	}
+	for i, req := range reqs {
+		i, req := i, req
+		g.Go(func() error {
+			msg := req.Msg.CloneVT()
+			msg.Explain = true
+			resp, err := connectgrpc.RoundTripUnary[
+				querierv1.SelectMergeStacktracesRequest,
+				querierv1.SelectMergeStacktracesResponse](gCtx, f, connectgrpc.CloneRequest(req, msg))
+			if err != nil {
+				return err
+			}
+			var b strings.Builder
+			fmt.Fprintf(&b, "  sub-query %d: %s to %s, results cache: %s\n", i+1,
+				time.UnixMilli(req.Msg.Start).UTC().Format(time.RFC3339),
+				time.UnixMilli(req.Msg.End).UTC().Format(time.RFC3339),
+				f.resultsCacheStatus(gCtx, req.Msg, time.UnixMilli(req.Msg.End)))
+			for _, line := range strings.Split(strings.TrimSuffix(resp.Msg.Plan, "\n"), "\n") {
+				b.WriteString("    " + line + "\n")
+			}
+			plans[i] = b.String()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "query-frontend: %d sub-queries, split by %s interval, %d shards, max parallelism %d, timeout %s\n",
+		len(reqs), model.Duration(interval), shards,
+		validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism),
+		model.Duration(validationutil.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, f.limits.QueryTimeout)))
+	for _, p := range plans {
+		b.WriteString(p)
+	}
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Plan: b.String()}), nil
 }
@@ -12,6 +12,7 @@ import (
 
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
@@ -51,27 +52,29 @@ func (f *Frontend) SelectSeries(ctx context.Context,
 
 	m := phlaremodel.NewSeriesMerger(false)
 	interval := validationutil.MaxDurationOrZeroPerTenant(tenantIDs, f.limits.QuerySplitDuration)
-	intervals := NewTimeIntervalIterator(time.UnixMilli(c.Msg.Start), time.UnixMilli(c.Msg.End), interval,
-		WithAlignment(time.Second*time.Duration(c.Msg.Step)))
+	intervals, _ := iter.Slice[TimeInterval](NewTimeIntervalIterator(time.UnixMilli(c.Msg.Start), time.UnixMilli(c.Msg.End), interval,
+		WithAlignment(time.Second*time.Duration(c.Msg.Step))))
 
-	for intervals.Next() {
-		r := intervals.At()
+	for i, r := range intervals {
+		r := r
+		reqCtx := withNextIntervalHint(ctx, intervals, i)
 		g.Go(func() error {
 			req := connectgrpc.CloneRequest(c, &querierv1.SelectSeriesRequest{
-				ProfileTypeID: c.Msg.ProfileTypeID,
-				LabelSelector: c.Msg.LabelSelector,
-				Start:         r.Start.UnixMilli(),
-				End:           r.End.UnixMilli(),
-				GroupBy:       c.Msg.GroupBy,
-				Step:          c.Msg.Step,
+				ProfileTypeID:  c.Msg.ProfileTypeID,
+				LabelSelector:  c.Msg.LabelSelector,
+				Start:          r.Start.UnixMilli(),
+				End:            r.End.UnixMilli(),
+				GroupBy:        c.Msg.GroupBy,
+				Step:           c.Msg.Step,
+				HeatmapBuckets: c.Msg.HeatmapBuckets,
 			})
-			resp, err := connectgrpc.RoundTripUnary[
+			resp, err := roundTripCached[
 				querierv1.SelectSeriesRequest,
-				querierv1.SelectSeriesResponse](ctx, f, req)
+				querierv1.SelectSeriesResponse](reqCtx, f, req, r.End)
 			if err != nil {
 				return err
 			}
-			m.MergeSeries(resp.Msg.Series)
+			m.MergeSeries(resp.Series)
 			return nil
 		})
 	}
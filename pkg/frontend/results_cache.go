@@ -0,0 +1,153 @@
+package frontend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+)
+
+// resultsCacheTTL is the time the sub-query results are kept in the cache
+// for. The results only cover data that is not expected to change anymore.
+const resultsCacheTTL = 7 * 24 * time.Hour
+
+// ResultsCacheConfig configures the cache of the query results.
+type ResultsCacheConfig struct {
+	Backend   string                      `yaml:"backend"`
+	Memcached cache.MemcachedClientConfig `yaml:"memcached"`
+}
+
+func (cfg *ResultsCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for query-frontend results cache, if not empty. Supported values: %s. Results are cached per time interval the queries are split into, therefore -querier.split-queries-by-interval should be set for the cache to be effective.", cache.BackendMemcached))
+	cfg.Memcached.RegisterFlagsWithPrefix(prefix+"memcached.", f)
+}
+
+func (cfg *ResultsCacheConfig) Validate() error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case cache.BackendMemcached:
+		if len(cfg.Memcached.Addresses) == 0 {
+			return fmt.Errorf("no memcached addresses configured for the results cache")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported results cache backend: %q", cfg.Backend)
+	}
+}
+
+func newResultsCache(cfg ResultsCacheConfig, logger log.Logger, reg prometheus.Registerer) (cache.Cache, error) {
+	if cfg.Backend == "" {
+		return nil, nil
+	}
+	return cache.CreateClient("frontend-results-cache", cache.BackendConfig{
+		Backend:   cfg.Backend,
+		Memcached: cfg.Memcached,
+	}, logger, reg)
+}
+
+type vtMessage[T any] interface {
+	*T
+	MarshalVT() ([]byte, error)
+	UnmarshalVT([]byte) error
+}
+
+// roundTripCached round trips a sub-query, unless its result is found in
+// the results cache. Only results of sub-queries ending before the cache
+// freshness window are cached: the most recent data may still change.
+func roundTripCached[Req, Res any, PReq vtMessage[Req], PRes vtMessage[Res]](
+	ctx context.Context,
+	f *Frontend,
+	req *connect.Request[Req],
+	end time.Time,
+) (*Res, error) {
+	key, ok := f.resultsCacheKey(ctx, PReq(req.Msg), end)
+	if ok {
+		if b, found := f.resultsCache.Fetch(ctx, []string{key})[key]; found {
+			res := new(Res)
+			if err := PRes(res).UnmarshalVT(b); err == nil {
+				f.resultsCacheHits.Inc()
+				stats.FromContext(ctx).AddResultsCacheHits(1)
+				return res, nil
+			}
+		}
+		f.resultsCacheMisses.Inc()
+		stats.FromContext(ctx).AddResultsCacheMisses(1)
+	}
+	resp, err := connectgrpc.RoundTripUnary[Req, Res](ctx, f, req)
+	if err != nil {
+		return nil, err
+	}
+	// Partial results are not cached: they depend on the query budget and
+	// the load at the time of the query.
+	if p, isPartial := any(resp.Msg).(interface{ GetPartial() bool }); isPartial && p.GetPartial() {
+		ok = false
+	}
+	if ok {
+		b, err := PRes(resp.Msg).MarshalVT()
+		if err != nil {
+			level.Warn(f.log).Log("msg", "failed to marshal query result for caching", "err", err)
+			return resp.Msg, nil
+		}
+		f.resultsCache.StoreAsync(map[string][]byte{key: b}, resultsCacheTTL)
+	}
+	return resp.Msg, nil
+}
+
+// resultsCacheStatus describes whether the result of the sub-query is
+// found in the results cache, for the query plan.
+func (f *Frontend) resultsCacheStatus(ctx context.Context, req interface{ MarshalVT() ([]byte, error) }, end time.Time) string {
+	key, ok := f.resultsCacheKey(ctx, req, end)
+	if !ok {
+		return "not cacheable"
+	}
+	if _, found := f.resultsCache.Fetch(ctx, []string{key})[key]; found {
+		return "hit"
+	}
+	return "miss"
+}
+
+// resultsCacheKey returns the key of the sub-query in the results cache.
+// The key is a hash of the procedure, tenants and the request itself.
+// The second return value is false if the result must not be cached.
+func (f *Frontend) resultsCacheKey(ctx context.Context, req interface{ MarshalVT() ([]byte, error) }, end time.Time) (string, bool) {
+	if f.resultsCache == nil {
+		return "", false
+	}
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return "", false
+	}
+	var freshness time.Duration
+	for _, tenantID := range tenantIDs {
+		if v := f.limits.MaxCacheFreshness(tenantID); v > freshness {
+			freshness = v
+		}
+	}
+	if end.After(time.Now().Add(-freshness)) {
+		return "", false
+	}
+	b, err := req.MarshalVT()
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	_, _ = h.Write([]byte(connectgrpc.ProcedureFromContext(ctx)))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(tenant.JoinTenantIDs(tenantIDs)))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(b)
+	return "qfr:" + hex.EncodeToString(h.Sum(nil)), true
+}
@@ -0,0 +1,45 @@
+package frontend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_ResultsCacheKey(t *testing.T) {
+	f := &Frontend{
+		limits:       validation.MockLimits{MaxCacheFreshnessValue: time.Hour},
+		resultsCache: cache.NewMockCache(),
+	}
+	ctx := connectgrpc.WithProcedure(user.InjectOrgID(context.Background(), "a"),
+		querierv1connect.QuerierServiceSelectMergeStacktracesProcedure)
+	req := &querierv1.SelectMergeStacktracesRequest{LabelSelector: `{service_name="foo"}`}
+	old := time.Now().Add(-2 * time.Hour)
+
+	key, ok := f.resultsCacheKey(ctx, req, old)
+	require.True(t, ok)
+	same, _ := f.resultsCacheKey(ctx, req.CloneVT(), old)
+	require.Equal(t, key, same)
+
+	_, ok = f.resultsCacheKey(ctx, req, time.Now().Add(-time.Minute))
+	require.False(t, ok, "recent results must not be cached")
+
+	other, _ := f.resultsCacheKey(user.InjectOrgID(ctx, "b"), req, old)
+	require.NotEqual(t, key, other, "results must not be shared across tenants")
+
+	other, _ = f.resultsCacheKey(connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectSeriesProcedure), req, old)
+	require.NotEqual(t, key, other)
+
+	f.resultsCache = nil
+	_, ok = f.resultsCacheKey(ctx, req, old)
+	require.False(t, ok)
+}
@@ -0,0 +1,303 @@
+package frontend
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+// SlowQueryLogConfig configures the log of the slow queries.
+type SlowQueryLogConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	BytesThreshold   uint64        `yaml:"bytes_threshold"`
+	MaxFingerprints  int           `yaml:"max_fingerprints" category:"advanced"`
+}
+
+func (cfg *SlowQueryLogConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "If enabled, the queries exceeding the latency or bytes threshold are logged with their fingerprint, and aggregated by fingerprint.")
+	f.DurationVar(&cfg.LatencyThreshold, prefix+"latency-threshold", 10*time.Second, "Queries taking longer than this are slow. 0 to disable.")
+	f.Uint64Var(&cfg.BytesThreshold, prefix+"bytes-threshold", 0, "Queries fetching more symbol bytes than this are slow. 0 to disable.")
+	f.IntVar(&cfg.MaxFingerprints, prefix+"max-fingerprints", 1000, "Maximum number of fingerprints the slow queries are aggregated by. The least recently seen are evicted.")
+}
+
+// SlowQueryLog logs the slow queries, and aggregates them by fingerprint:
+// the queries with the same shape, whatever the label values and the time
+// range, share a fingerprint.
+type SlowQueryLog struct {
+	cfg    SlowQueryLogConfig
+	logger log.Logger
+
+	mu           sync.Mutex
+	fingerprints map[string]*list.Element
+	// lru holds the fingerprints, the most recently seen first.
+	lru *list.List
+
+	slowQueries *prometheus.CounterVec
+}
+
+// FingerprintStats are the aggregate stats of the slow queries of a tenant
+// sharing a fingerprint.
+type FingerprintStats struct {
+	Fingerprint          string    `json:"fingerprint"`
+	TenantID             string    `json:"tenant_id"`
+	Procedure            string    `json:"procedure"`
+	Query                string    `json:"query"`
+	Count                int64     `json:"count"`
+	TotalDurationSeconds float64   `json:"total_duration_seconds"`
+	MaxDurationSeconds   float64   `json:"max_duration_seconds"`
+	TotalBytes           uint64    `json:"total_bytes"`
+	MaxBytes             uint64    `json:"max_bytes"`
+	FirstSeen            time.Time `json:"first_seen"`
+	LastSeen             time.Time `json:"last_seen"`
+	// LastQuery is the last slow query, with its label values and time
+	// range.
+	LastQuery string `json:"last_query"`
+}
+
+func NewSlowQueryLog(cfg SlowQueryLogConfig, logger log.Logger, reg prometheus.Registerer) *SlowQueryLog {
+	return &SlowQueryLog{
+		cfg:          cfg,
+		logger:       logger,
+		fingerprints: make(map[string]*list.Element),
+		lru:          list.New(),
+		slowQueries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_query_frontend_slow_queries_total",
+			Help: "The total number of slow queries handled by the frontend.",
+		}, []string{"tenant"}),
+	}
+}
+
+func (l *SlowQueryLog) isSlow(d time.Duration, bytes uint64) bool {
+	return (l.cfg.LatencyThreshold > 0 && d > l.cfg.LatencyThreshold) ||
+		(l.cfg.BytesThreshold > 0 && bytes > l.cfg.BytesThreshold)
+}
+
+// observe records the query if it's slow.
+func (l *SlowQueryLog) observe(ctx context.Context, procedure string, req interface{}, d time.Duration, bytes uint64, now time.Time) {
+	if req == nil || !l.isSlow(d, bytes) {
+		return
+	}
+	tenantID, _ := tenant.ExtractTenantIDFromContext(ctx)
+	query, normalized := describeQuery(req)
+	fingerprint := queryFingerprint(procedure, normalized)
+	l.slowQueries.WithLabelValues(tenantID).Inc()
+	level.Warn(l.logger).Log(
+		"msg", "slow query",
+		"tenant", tenantID,
+		"fingerprint", fingerprint,
+		"procedure", procedure,
+		"query", query,
+		"duration", d,
+		"bytes", bytes,
+	)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := tenantID + "/" + fingerprint
+	e, ok := l.fingerprints[key]
+	if ok {
+		l.lru.MoveToFront(e)
+	} else {
+		e = l.lru.PushFront(&FingerprintStats{
+			Fingerprint: fingerprint,
+			TenantID:    tenantID,
+			Procedure:   procedure,
+			Query:       normalized,
+			FirstSeen:   now,
+		})
+		l.fingerprints[key] = e
+		for l.lru.Len() > l.cfg.MaxFingerprints {
+			oldest := l.lru.Back().Value.(*FingerprintStats)
+			delete(l.fingerprints, oldest.TenantID+"/"+oldest.Fingerprint)
+			l.lru.Remove(l.lru.Back())
+		}
+	}
+	s := e.Value.(*FingerprintStats)
+	s.Count++
+	s.TotalDurationSeconds += d.Seconds()
+	if d.Seconds() > s.MaxDurationSeconds {
+		s.MaxDurationSeconds = d.Seconds()
+	}
+	s.TotalBytes += bytes
+	if bytes > s.MaxBytes {
+		s.MaxBytes = bytes
+	}
+	s.LastSeen = now
+	s.LastQuery = query
+}
+
+// list returns the stats of the fingerprints of the tenant, or of all the
+// tenants if empty, the longest total duration first.
+func (l *SlowQueryLog) list(tenantID string, limit int) []FingerprintStats {
+	l.mu.Lock()
+	res := make([]FingerprintStats, 0, l.lru.Len())
+	for e := l.lru.Front(); e != nil; e = e.Next() {
+		s := e.Value.(*FingerprintStats)
+		if tenantID == "" || s.TenantID == tenantID {
+			res = append(res, *s)
+		}
+	}
+	l.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].TotalDurationSeconds > res[j].TotalDurationSeconds
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// Handler returns the aggregate stats of the slow queries by fingerprint,
+// the longest total duration first. They can be filtered by tenant with the
+// tenant parameter, and limited with the limit parameter.
+func (l *SlowQueryLog) Handler(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	util.WriteJSONResponse(w, struct {
+		Fingerprints []FingerprintStats `json:"fingerprints"`
+	}{Fingerprints: l.list(r.URL.Query().Get("tenant"), limit)})
+}
+
+// describeQuery returns the query of the request, and its normalized form,
+// without the label values and the time range.
+func describeQuery(req interface{}) (query, normalized string) {
+	if diff, ok := req.(*querierv1.DiffRequest); ok {
+		lq, ln := describeQuery(diff.GetLeft())
+		rq, rn := describeQuery(diff.GetRight())
+		return "left: " + lq + " right: " + rq, "left: " + ln + " right: " + rn
+	}
+	var q, n []string
+	if r, ok := req.(interface{ GetProfileTypeID() string }); ok && r.GetProfileTypeID() != "" {
+		q = append(q, r.GetProfileTypeID())
+		n = append(n, r.GetProfileTypeID())
+	}
+	var selectors []string
+	if r, ok := req.(interface{ GetLabelSelector() string }); ok {
+		selectors = []string{r.GetLabelSelector()}
+	} else if r, ok := req.(interface{ GetMatchers() []string }); ok {
+		selectors = r.GetMatchers()
+	}
+	for _, s := range selectors {
+		q = append(q, s)
+		n = append(n, normalizeSelector(s))
+	}
+	if r, ok := req.(interface {
+		GetStart() int64
+		GetEnd() int64
+	}); ok {
+		q = append(q, fmt.Sprintf("[%s, %s]",
+			time.UnixMilli(r.GetStart()).UTC().Format(time.RFC3339),
+			time.UnixMilli(r.GetEnd()).UTC().Format(time.RFC3339)))
+	}
+	return strings.Join(q, " "), strings.Join(n, " ")
+}
+
+// normalizeSelector replaces the values of the matchers of the selector,
+// sorted by label name, with a placeholder.
+func normalizeSelector(s string) string {
+	matchers, err := parser.ParseMetricSelector(s)
+	if err != nil {
+		return s
+	}
+	sort.Slice(matchers, func(i, j int) bool {
+		if matchers[i].Name != matchers[j].Name {
+			return matchers[i].Name < matchers[j].Name
+		}
+		return matchers[i].Type < matchers[j].Type
+	})
+	parts := make([]string, len(matchers))
+	for i, m := range matchers {
+		parts[i] = m.Name + m.Type.String() + `"?"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func queryFingerprint(procedure, normalized string) string {
+	h := sha256.Sum256([]byte(procedure + "\n" + normalized))
+	return hex.EncodeToString(h[:8])
+}
+
+// Interceptor measures the queries handled by the server, and logs the slow
+// ones. It must run after the tenant authentication interceptor, as the
+// queries are aggregated by tenant.
+func (l *SlowQueryLog) Interceptor() connect.Interceptor {
+	return &slowQueryInterceptor{log: l}
+}
+
+type slowQueryInterceptor struct {
+	log *SlowQueryLog
+}
+
+func (i *slowQueryInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		begin := time.Now()
+		parent := stats.FromContext(ctx)
+		st, ctx := stats.ContextWithEmptyStats(ctx)
+		defer parent.Merge(st)
+		resp, err := next(ctx, req)
+		i.log.observe(ctx, req.Spec().Procedure, req.Any(), time.Since(begin), st.LoadFetchedSymbolBytes(), time.Now())
+		return resp, err
+	}
+}
+
+func (i *slowQueryInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *slowQueryInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		begin := time.Now()
+		parent := stats.FromContext(ctx)
+		st, ctx := stats.ContextWithEmptyStats(ctx)
+		defer parent.Merge(st)
+		c := &firstRequestConn{StreamingHandlerConn: conn}
+		err := next(ctx, c)
+		i.log.observe(ctx, conn.Spec().Procedure, c.request, time.Since(begin), st.LoadFetchedSymbolBytes(), time.Now())
+		return err
+	}
+}
+
+// firstRequestConn records the first request received.
+type firstRequestConn struct {
+	connect.StreamingHandlerConn
+	request interface{}
+}
+
+func (c *firstRequestConn) Receive(msg interface{}) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil && c.request == nil {
+		c.request = msg
+	}
+	return err
+}
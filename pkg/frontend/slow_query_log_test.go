@@ -0,0 +1,86 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+func Test_NormalizeSelector(t *testing.T) {
+	for selector, expected := range map[string]string{
+		`{}`: `{}`,
+		`{service_name="checkout", namespace=~"prod-.*"}`: `{namespace=~"?",service_name="?"}`,
+		`{namespace=~"dev", service_name="cart"}`:         `{namespace=~"?",service_name="?"}`,
+		`invalid{`: `invalid{`,
+	} {
+		require.Equal(t, expected, normalizeSelector(selector), selector)
+	}
+}
+
+func Test_SlowQueryLog(t *testing.T) {
+	l := NewSlowQueryLog(SlowQueryLogConfig{
+		Enabled:          true,
+		LatencyThreshold: time.Second,
+		BytesThreshold:   1000,
+		MaxFingerprints:  2,
+	}, log.NewNopLogger(), prometheus.NewRegistry())
+	const procedure = "/querier.v1.QuerierService/SelectMergeStacktraces"
+	query := func(selector string) *querierv1.SelectMergeStacktracesRequest {
+		return &querierv1.SelectMergeStacktracesRequest{
+			ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			LabelSelector: selector,
+			Start:         0,
+			End:           3600 * 1000,
+		}
+	}
+	ctx := tenant.InjectTenantID(context.Background(), "team-a")
+	now := time.Now()
+
+	// Neither slow nor too large.
+	l.observe(ctx, procedure, query(`{service_name="checkout"}`), time.Millisecond, 10, now)
+	require.Empty(t, l.list("", 0))
+
+	// The queries differing by their label values share a fingerprint.
+	l.observe(ctx, procedure, query(`{service_name="checkout"}`), 2*time.Second, 10, now)
+	l.observe(ctx, procedure, query(`{service_name="cart"}`), time.Millisecond, 5000, now.Add(time.Minute))
+	fingerprints := l.list("", 0)
+	require.Len(t, fingerprints, 1)
+	s := fingerprints[0]
+	require.Equal(t, "team-a", s.TenantID)
+	require.Equal(t, `process_cpu:cpu:nanoseconds:cpu:nanoseconds {service_name="?"}`, s.Query)
+	require.Equal(t, int64(2), s.Count)
+	require.InDelta(t, 2.001, s.TotalDurationSeconds, 0.0001)
+	require.Equal(t, uint64(5010), s.TotalBytes)
+	require.Equal(t, uint64(5000), s.MaxBytes)
+	require.Equal(t, now, s.FirstSeen)
+	require.Equal(t, `process_cpu:cpu:nanoseconds:cpu:nanoseconds {service_name="cart"} [1970-01-01T00:00:00Z, 1970-01-01T01:00:00Z]`, s.LastQuery)
+
+	// The least recently seen fingerprints are evicted.
+	l.observe(ctx, procedure, query(`{namespace="prod"}`), 3*time.Second, 0, now)
+	l.observe(tenant.InjectTenantID(context.Background(), "team-b"), procedure, query(`{namespace="prod"}`), 5*time.Second, 0, now)
+	fingerprints = l.list("", 0)
+	require.Len(t, fingerprints, 2)
+	require.Equal(t, "team-b", fingerprints[0].TenantID)
+	require.Equal(t, "team-a", fingerprints[1].TenantID)
+	require.Equal(t, fingerprints[0].Fingerprint, fingerprints[1].Fingerprint)
+
+	w := httptest.NewRecorder()
+	l.Handler(w, httptest.NewRequest(http.MethodGet, "/query-frontend/slow-queries?tenant=team-a", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Fingerprints []FingerprintStats `json:"fingerprints"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Fingerprints, 1)
+	require.Equal(t, `process_cpu:cpu:nanoseconds:cpu:nanoseconds {namespace="?"}`, resp.Fingerprints[0].Query)
+}
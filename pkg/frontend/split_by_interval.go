@@ -1,8 +1,12 @@
 package frontend
 
 import (
+	"context"
 	"time"
 
+	"github.com/prometheus/common/model"
+
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
 	"github.com/grafana/pyroscope/pkg/util/math"
 )
 
@@ -79,3 +83,18 @@ func (i *TimeIntervalIterator) At() TimeInterval {
 func (*TimeIntervalIterator) Err() error { return nil }
 
 func (*TimeIntervalIterator) Close() error { return nil }
+
+// withNextIntervalHint returns a context carrying the hint of the interval
+// following the i-th one, if any. The hint is sent along with the sub-query
+// of the i-th interval, so that store-gateways load the blocks of the next
+// interval while the sub-query is executed.
+func withNextIntervalHint(ctx context.Context, intervals []TimeInterval, i int) context.Context {
+	if i+1 >= len(intervals) {
+		return ctx
+	}
+	next := intervals[i+1]
+	return prefetch.InjectHint(ctx, prefetch.Hint{
+		Start: model.TimeFromUnixNano(next.Start.UnixNano()),
+		End:   model.TimeFromUnixNano(next.End.UnixNano()),
+	})
+}
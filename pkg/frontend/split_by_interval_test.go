@@ -1,12 +1,14 @@
 package frontend
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/pyroscope/pkg/iter"
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
 )
 
 func Test_TimeIntervalIterator(t *testing.T) {
@@ -145,3 +147,18 @@ func Test_TimeIntervalIterator_MillisecondsTruncation(t *testing.T) {
 	require.Equal(t, int64(200), actual[3].Start.UnixMilli())
 	require.Equal(t, int64(211), actual[3].End.UnixMilli())
 }
+
+func Test_withNextIntervalHint(t *testing.T) {
+	intervals, err := iter.Slice[TimeInterval](NewTimeIntervalIterator(
+		time.UnixMilli(0),
+		time.UnixMilli(149),
+		50*time.Millisecond))
+	require.NoError(t, err)
+
+	hint, ok := prefetch.FromContext(withNextIntervalHint(context.Background(), intervals, 0))
+	require.True(t, ok)
+	require.Equal(t, prefetch.Hint{Start: 50, End: 99}, hint)
+
+	_, ok = prefetch.FromContext(withNextIntervalHint(context.Background(), intervals, len(intervals)-1))
+	require.False(t, ok)
+}
@@ -0,0 +1,39 @@
+package frontend
+
+import (
+	"strings"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+)
+
+// SplitSelectorByShard returns the label selector of each of the given
+// number of query shards. The selector is returned as is, if sharding
+// is disabled or the selector already targets a shard.
+func SplitSelectorByShard(selector string, shards int) ([]string, error) {
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	shard, _, err := sharding.ShardFromMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	if shards <= 1 || shard != nil {
+		return []string{selector}, nil
+	}
+	selectors := make([]string, shards)
+	parts := make([]string, len(matchers)+1)
+	for i, m := range matchers {
+		parts[i] = m.String()
+	}
+	for i := range selectors {
+		parts[len(matchers)] = sharding.ShardSelector{
+			ShardIndex: uint64(i),
+			ShardCount: uint64(shards),
+		}.Matcher().String()
+		selectors[i] = "{" + strings.Join(parts, ",") + "}"
+	}
+	return selectors, nil
+}
@@ -0,0 +1,43 @@
+package frontend
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+)
+
+func Test_SplitSelectorByShard(t *testing.T) {
+	selectors, err := SplitSelectorByShard(`{service_name="foo"}`, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{service_name="foo"}`}, selectors)
+
+	selectors, err = SplitSelectorByShard(`{service_name="foo"}`, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{service_name="foo",__query_shard__="1_of_3"}`,
+		`{service_name="foo",__query_shard__="2_of_3"}`,
+		`{service_name="foo",__query_shard__="3_of_3"}`,
+	}, selectors)
+
+	for i, s := range selectors {
+		matchers, err := parser.ParseMetricSelector(s)
+		require.NoError(t, err)
+		shard, _, err := sharding.ShardFromMatchers(matchers)
+		require.NoError(t, err)
+		require.Equal(t, &sharding.ShardSelector{ShardIndex: uint64(i), ShardCount: 3}, shard)
+	}
+
+	selectors, err = SplitSelectorByShard(`{}`, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{__query_shard__="1_of_2"}`, `{__query_shard__="2_of_2"}`}, selectors)
+
+	selectors, err = SplitSelectorByShard(`{__query_shard__="1_of_2"}`, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{__query_shard__="1_of_2"}`}, selectors)
+
+	_, err = SplitSelectorByShard(`{`, 2)
+	require.Error(t, err)
+}
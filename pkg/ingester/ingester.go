@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
@@ -16,14 +17,17 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	ingesterv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/pkg/ingeststorage"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	phlareobjclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/usagestats"
@@ -33,13 +37,23 @@ import (
 
 var activeTenantsStats = usagestats.NewInt("ingester_active_tenants")
 
+var errTenantDeleted = errors.New("the tenant has been marked for deletion")
+
 type Config struct {
 	LifecyclerConfig ring.LifecyclerConfig `yaml:"lifecycler,omitempty"`
+
+	// SharedSymbolsPool makes the heads of all the tenants share the
+	// memory of identical symbol strings.
+	SharedSymbolsPool bool `yaml:"shared_symbols_pool" category:"experimental"`
+
+	// IngestStorage is set from the top-level ingest_storage block.
+	IngestStorage ingeststorage.Config `yaml:"-"`
 }
 
 // RegisterFlags registers the flags.
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	cfg.LifecyclerConfig.RegisterFlags(f, util.Logger)
+	f.BoolVar(&cfg.SharedSymbolsPool, "ingester.shared-symbols-pool", false, "Share the memory of identical symbol strings (function names, file names, etc.) between the heads of all the tenants of the ingester. The blocks of each tenant keep their own symbols.")
 }
 
 func (cfg *Config) Validate() error {
@@ -66,6 +80,8 @@ type Ingester struct {
 
 	limits Limits
 	reg    prometheus.Registerer
+
+	prepareShutdownRequested prometheus.Gauge
 }
 
 type ingesterFlusherCompat struct {
@@ -91,6 +107,22 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 		storageBucket: storageBucket,
 		limits:        limits,
 	}
+	i.prepareShutdownRequested = promauto.With(i.reg).NewGauge(prometheus.GaugeOpts{
+		Name: "pyroscope_ingester_prepare_shutdown_requested",
+		Help: "1 if the ingester has been requested to prepare for shutdown via endpoint or marker file.",
+	})
+	if cfg.SharedSymbolsPool {
+		pool := symdb.NewInternPool()
+		i.dbConfig.SymbolsInternPool = pool
+		promauto.With(i.reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pyroscope_ingester_shared_symbols_pool_strings",
+			Help: "Number of symbol strings in the pool shared by the heads of the tenants.",
+		}, func() float64 { return float64(pool.Len()) })
+		promauto.With(i.reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pyroscope_ingester_shared_symbols_pool_size_bytes",
+			Help: "Size of the symbol strings in the pool shared by the heads of the tenants.",
+		}, func() float64 { return float64(pool.Size()) })
+	}
 
 	// initialise the local bucket client
 	var (
@@ -116,7 +148,11 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 	}
 
 	rpEnforcer := newRetentionPolicyEnforcer(phlarecontext.Logger(phlarectx), i, defaultRetentionPolicy(), dbConfig)
-	i.subservices, err = services.NewManager(i.lifecycler, rpEnforcer)
+	subservices := []services.Service{i.lifecycler, rpEnforcer}
+	if cfg.IngestStorage.Enabled {
+		subservices = append(subservices, ingeststorage.NewReader(cfg.IngestStorage.Kafka, i, i.logger, i.reg))
+	}
+	i.subservices, err = services.NewManager(subservices...)
 	if err != nil {
 		return nil, errors.Wrap(err, "services manager")
 	}
@@ -127,6 +163,9 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 }
 
 func (i *Ingester) starting(ctx context.Context) error {
+	if err := i.restorePrepareShutdown(); err != nil {
+		return errors.Wrap(err, "restoring prepare shutdown")
+	}
 	return services.StartManagerAndAwaitHealthy(ctx, i.subservices)
 }
 
@@ -163,7 +202,11 @@ func (i *Ingester) GetOrCreateInstance(tenantID string) (*instance, error) { //n
 	if !ok {
 		var err error
 
-		inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor))
+		dbConfig := i.dbConfig
+		dbConfig.SplitShards = func() int { return i.limits.IngesterSplitShards(tenantID) }
+		dbConfig.SeriesIdleTimeout = func() time.Duration { return i.limits.IngesterSeriesIdleTimeout(tenantID) }
+		retentionClass := func() string { return i.limits.BlocksRetentionClass(tenantID) }
+		inst, err = newInstance(i.phlarectx, dbConfig, tenantID, i.localBucket, i.storageBucket, retentionClass, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor))
 		if err != nil {
 			return nil, err
 		}
@@ -235,6 +278,9 @@ func (i *Ingester) evictBlock(tenantID string, b ulid.ULID, fn func() error) (er
 func (i *Ingester) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
 	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[pushv1.PushResponse], error) {
 		level.Debug(instance.logger).Log("msg", "message received by ingester push")
+		if instance.deleted.Load() {
+			return nil, connect.NewError(connect.CodePermissionDenied, errTenantDeleted)
+		}
 		for _, series := range req.Msg.Series {
 			for _, sample := range series.Samples {
 				err := pprof.FromBytes(sample.RawProfile, func(p *profilev1.Profile, size int) error {
@@ -248,7 +294,7 @@ func (i *Ingester) Push(ctx context.Context, req *connect.Request[pushv1.PushReq
 							validation.DiscardedProfiles.WithLabelValues(string(reason), instance.tenantID).Add(float64(1))
 							validation.DiscardedBytes.WithLabelValues(string(reason), instance.tenantID).Add(float64(size))
 							switch validation.ReasonOf(err) {
-							case validation.SeriesLimit:
+							case validation.SeriesLimit, validation.SeriesPerProfileTypeLimit:
 								return connect.NewError(connect.CodeResourceExhausted, err)
 							}
 						}
@@ -3,7 +3,10 @@ package ingester
 import (
 	"bytes"
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 	"testing"
 	"time"
@@ -25,6 +28,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
+	phlaredbbucket "github.com/grafana/pyroscope/pkg/phlaredb/bucket"
 	"github.com/grafana/pyroscope/pkg/tenant"
 )
 
@@ -116,3 +120,107 @@ func Test_MultitenantReadWrite(t *testing.T) {
 
 	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
 }
+
+func Test_PrepareShutdown(t *testing.T) {
+	dataPath, storagePath := t.TempDir(), t.TempDir()
+	ctx := phlarecontext.WithLogger(context.Background(), log.NewNopLogger())
+	ctx = phlarecontext.WithRegistry(ctx, prometheus.NewRegistry())
+	bucket, err := client.NewBucket(ctx, client.Config{
+		StorageBackendConfig: client.StorageBackendConfig{
+			Backend:    client.Filesystem,
+			Filesystem: filesystem.Config{Directory: storagePath},
+		},
+	}, "storage")
+	require.NoError(t, err)
+
+	ingCfg := defaultIngesterTestConfig(t)
+	ingCfg.LifecyclerConfig.UnregisterOnShutdown = false
+	dbCfg := phlaredb.Config{DataPath: dataPath, MaxBlockDuration: 30 * time.Hour}
+	ing, err := New(ctx, ingCfg, dbCfg, bucket, &fakeLimits{})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), ing))
+
+	prepareShutdown := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ing.PrepareShutdownHandler(w, httptest.NewRequest(method, "/ingester/prepare-shutdown", nil))
+		return w
+	}
+	require.Equal(t, "unset", prepareShutdown(http.MethodGet).Body.String())
+
+	req := connect.NewRequest(&pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{{
+			Labels:  phlaremodel.LabelsFromStrings("foo", "bar"),
+			Samples: []*pushv1.RawSample{{ID: uuid.NewString(), RawProfile: testProfile(t)}},
+		}},
+	})
+	_, err = ing.Push(tenant.InjectTenantID(context.Background(), "foo"), req)
+	require.NoError(t, err)
+
+	// The head is flushed and the block is uploaded.
+	require.Equal(t, http.StatusNoContent, prepareShutdown(http.MethodPost).Code)
+	require.Equal(t, "set", prepareShutdown(http.MethodGet).Body.String())
+	uploaded, err := filepath.Glob(filepath.Join(storagePath, "foo", "phlaredb", "*", "meta.json"))
+	require.NoError(t, err)
+	require.Len(t, uploaded, 1)
+	require.FileExists(t, filepath.Join(dataPath, shutdownMarkerFilename))
+
+	require.Equal(t, http.StatusNoContent, prepareShutdown(http.MethodDelete).Code)
+	require.Equal(t, "unset", prepareShutdown(http.MethodGet).Body.String())
+	require.NoFileExists(t, filepath.Join(dataPath, shutdownMarkerFilename))
+
+	// The marker is restored on restart.
+	require.Equal(t, http.StatusNoContent, prepareShutdown(http.MethodPost).Code)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	ctx = phlarecontext.WithRegistry(ctx, prometheus.NewRegistry())
+	ing, err = New(ctx, ingCfg, dbCfg, bucket, &fakeLimits{})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), ing))
+	require.Equal(t, "set", prepareShutdown(http.MethodGet).Body.String())
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+}
+
+func Test_TenantDeletion(t *testing.T) {
+	dataPath, storagePath := t.TempDir(), t.TempDir()
+	ctx := phlarecontext.WithLogger(context.Background(), log.NewNopLogger())
+	ctx = phlarecontext.WithRegistry(ctx, prometheus.NewRegistry())
+	bucket, err := client.NewBucket(ctx, client.Config{
+		StorageBackendConfig: client.StorageBackendConfig{
+			Backend:    client.Filesystem,
+			Filesystem: filesystem.Config{Directory: storagePath},
+		},
+	}, "storage")
+	require.NoError(t, err)
+	mark := phlaredbbucket.NewTenantDeletionMark(time.Now())
+	require.NoError(t, phlaredbbucket.WriteTenantDeletionMark(context.Background(), bucket, "foo", nil, mark))
+
+	dbCfg := phlaredb.Config{DataPath: dataPath, MaxBlockDuration: 30 * time.Hour}
+	ing, err := New(ctx, defaultIngesterTestConfig(t), dbCfg, bucket, &fakeLimits{})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), ing))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	}()
+
+	push := func(tenantID string) error {
+		req := connect.NewRequest(&pushv1.PushRequest{
+			Series: []*pushv1.RawProfileSeries{{
+				Labels:  phlaremodel.LabelsFromStrings("foo", "bar"),
+				Samples: []*pushv1.RawSample{{ID: uuid.NewString(), RawProfile: testProfile(t)}},
+			}},
+		})
+		_, err := ing.Push(tenant.InjectTenantID(context.Background(), tenantID), req)
+		return err
+	}
+	require.NoError(t, push("bar"))
+	// The deletion mark is checked once the instance of the tenant is created.
+	require.Eventually(t, func() bool {
+		return connect.CodeOf(push("foo")) == connect.CodePermissionDenied
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The blocks of the deleted tenant are not uploaded.
+	require.NoError(t, ing.flushAndShip(context.Background()))
+	uploaded, err := filepath.Glob(filepath.Join(storagePath, "*", "phlaredb", "*", "meta.json"))
+	require.NoError(t, err)
+	require.Len(t, uploaded, 1)
+	require.Contains(t, uploaded[0], filepath.Join(storagePath, "bar"))
+}
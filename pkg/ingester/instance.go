@@ -2,18 +2,25 @@ package ingester
 
 import (
 	"context"
+	"os"
 	"path"
 	"sync"
 	"time"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
 
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/s3"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
 	"github.com/grafana/pyroscope/pkg/phlaredb/shipper"
 )
 
@@ -24,12 +31,19 @@ type instance struct {
 	logger      log.Logger
 	reg         prometheus.Registerer
 
+	storageBucket phlareobj.Bucket
+	// deleted is set once the tenant is marked for deletion: its profiles
+	// are rejected, and its blocks are not uploaded anymore.
+	deleted atomic.Bool
+
 	cancel   context.CancelFunc
 	wg       sync.WaitGroup
 	tenantID string
+
+	retentionClass func() string
 }
 
-func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string, localBucket, storageBucket phlareobj.Bucket, limiter Limiter) (*instance, error) {
+func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string, localBucket, storageBucket phlareobj.Bucket, retentionClass func() string, limiter Limiter) (*instance, error) {
 	cfg.DataPath = path.Join(cfg.DataPath, tenantID)
 
 	phlarectx = phlarecontext.WrapTenant(phlarectx, tenantID)
@@ -44,6 +58,9 @@ func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string
 		reg:      phlarecontext.Registry(phlarectx),
 		cancel:   cancel,
 		tenantID: tenantID,
+
+		retentionClass: retentionClass,
+		storageBucket:  storageBucket,
 	}
 	// Todo we should not ship when using filesystem storage.
 	if storageBucket != nil {
@@ -64,14 +81,15 @@ func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string
 func (i *instance) loop(ctx context.Context) {
 	i.wg.Add(1)
 	defer func() {
-		i.runShipper(context.Background()) // Run shipper one last time.
+		_ = i.runShipper(context.Background()) // Run shipper one last time.
 		i.wg.Done()
 	}()
 	// run shipper periodically and at start-up
 	shipperTicker := time.NewTicker(5 * time.Minute)
 	defer shipperTicker.Stop()
 	go func() {
-		i.runShipper(ctx)
+		i.checkDeletionMark(ctx)
+		_ = i.runShipper(ctx)
 	}()
 
 	for {
@@ -79,23 +97,70 @@ func (i *instance) loop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-shipperTicker.C: // run shipper loop
-			i.runShipper(ctx)
+			i.checkDeletionMark(ctx)
+			_ = i.runShipper(ctx)
 		}
 	}
 }
 
-func (i *instance) runShipper(ctx context.Context) {
+// checkDeletionMark checks whether the tenant has been marked for deletion.
+func (i *instance) checkDeletionMark(ctx context.Context) {
+	if i.storageBucket == nil || i.deleted.Load() {
+		return
+	}
+	deleted, err := bucket.TenantDeletionMarkExists(ctx, i.storageBucket, i.tenantID)
+	if err != nil {
+		level.Warn(i.logger).Log("msg", "failed to check the tenant deletion mark", "err", err)
+		return
+	}
+	if deleted {
+		level.Info(i.logger).Log("msg", "tenant marked for deletion, rejecting profiles")
+		i.deleted.Store(true)
+	}
+}
+
+func (i *instance) runShipper(ctx context.Context) error {
 	i.shipperLock.Lock()
 	defer i.shipperLock.Unlock()
-	if i.shipper == nil {
-		return
+	if i.shipper == nil || i.deleted.Load() {
+		return nil
 	}
-	uploaded, err := i.shipper.Sync(ctx)
+	// The objects of the blocks are tagged, if the storage supports it, so
+	// that lifecycle rules can be set per tenant and retention class.
+	objectTags := map[string]string{"tenant": i.tenantID}
+	if class := i.retentionClass(); class != "" {
+		objectTags["retention-class"] = class
+	}
+	uploaded, err := i.shipper.Sync(s3.ContextWithObjectTags(ctx, objectTags))
 	if err != nil {
 		level.Error(i.logger).Log("msg", "shipper run failed", "err", err)
-	} else {
-		level.Info(i.logger).Log("msg", "shipper finished", "uploaded_blocks", uploaded)
+		return err
+	}
+	level.Info(i.logger).Log("msg", "shipper finished", "uploaded_blocks", uploaded)
+	return nil
+}
+
+// MergeBlocksStacktraces merges the stacktraces of the blocks cut by the
+// instance that are not uploaded yet, so the queriers don't miss them until
+// the store-gateways serve them. Without a shipper, the blocks are never
+// uploaded and all of them are queried.
+func (i *instance) MergeBlocksStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
+	uploaded := make(map[ulid.ULID]struct{})
+	if i.shipper != nil {
+		meta, err := shipper.ReadMetaFile(i.LocalDataPath())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if meta != nil {
+			for _, id := range meta.Uploaded {
+				uploaded[id] = struct{}{}
+			}
+		}
 	}
+	return i.PhlareDB.MergeBlocksStacktraces(ctx, stream, func(id ulid.ULID) bool {
+		_, ok := uploaded[id]
+		return !ok
+	})
 }
 
 func (i *instance) Stop() error {
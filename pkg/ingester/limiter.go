@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/samber/lo"
 
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
@@ -25,7 +26,11 @@ type RingCount interface {
 type Limits interface {
 	MaxLocalSeriesPerTenant(tenantID string) int
 	MaxGlobalSeriesPerTenant(tenantID string) int
+	MaxGlobalSeriesPerProfileType(tenantID string) map[string]int
 	IngestionTenantShardSize(tenantID string) int
+	IngesterSplitShards(tenantID string) int
+	IngesterSeriesIdleTimeout(tenantID string) time.Duration
+	BlocksRetentionClass(tenantID string) string
 }
 
 type Limiter interface {
@@ -41,7 +46,9 @@ type limiter struct {
 	replicationFactor int
 	tenantID          string
 
-	activeSeries map[model.Fingerprint]int64
+	activeSeries map[model.Fingerprint]activeSeries
+	// seriesPerProfileType is the number of active series by profile name.
+	seriesPerProfileType map[string]int
 
 	mtx sync.Mutex // todo: may be shard the lock to avoid latency spikes.
 
@@ -58,9 +65,11 @@ func NewLimiter(tenantID string, limits Limits, ring RingCount, replicationFacto
 		limits:            limits,
 		ring:              ring,
 		replicationFactor: replicationFactor,
-		activeSeries:      map[model.Fingerprint]int64{},
-		cancel:            cancel,
-		ctx:               ctx,
+		activeSeries:      map[model.Fingerprint]activeSeries{},
+
+		seriesPerProfileType: map[string]int{},
+		cancel:               cancel,
+		ctx:                  ctx,
 	}
 
 	l.wg.Add(1)
@@ -96,9 +105,12 @@ func (l *limiter) cleanup() {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
 
-	for fp, lastUsed := range l.activeSeries {
-		if now-lastUsed > int64(activeSeriesTimeout) {
+	for fp, s := range l.activeSeries {
+		if now-s.lastUsed > int64(activeSeriesTimeout) {
 			delete(l.activeSeries, fp)
+			if l.seriesPerProfileType[s.profileName]--; l.seriesPerProfileType[s.profileName] <= 0 {
+				delete(l.seriesPerProfileType, s.profileName)
+			}
 		}
 	}
 }
@@ -106,24 +118,51 @@ func (l *limiter) cleanup() {
 func (l *limiter) AllowProfile(fp model.Fingerprint, lbs phlaremodel.Labels, tsNano int64) error {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
-	return l.allowNewSeries(fp)
+	return l.allowNewSeries(fp, lbs.Get(labels.MetricName))
+}
+
+type activeSeries struct {
+	lastUsed    int64
+	profileName string
 }
 
-func (l *limiter) allowNewSeries(fp model.Fingerprint) error {
-	_, ok := l.activeSeries[fp]
+func (l *limiter) allowNewSeries(fp model.Fingerprint, profileName string) error {
+	s, ok := l.activeSeries[fp]
 	series := len(l.activeSeries)
 	if !ok {
 		// can this series be added?
 		if err := l.assertMaxSeriesPerUser(l.tenantID, series); err != nil {
 			return err
 		}
+		if err := l.assertMaxSeriesPerProfileType(l.tenantID, profileName); err != nil {
+			return err
+		}
+		s.profileName = profileName
+		l.seriesPerProfileType[profileName]++
 	}
 
 	// update time or add it
-	l.activeSeries[fp] = time.Now().UnixNano()
+	s.lastUsed = time.Now().UnixNano()
+	l.activeSeries[fp] = s
 	return nil
 }
 
+func (l *limiter) assertMaxSeriesPerProfileType(tenantID string, profileName string) error {
+	globalLimit, ok := l.limits.MaxGlobalSeriesPerProfileType(tenantID)[profileName]
+	if !ok {
+		return nil
+	}
+	limit := l.convertGlobalToLocalLimit(tenantID, globalLimit)
+	if limit == 0 {
+		return nil
+	}
+	series := l.seriesPerProfileType[profileName]
+	if series < limit {
+		return nil
+	}
+	return validation.NewErrorf(validation.SeriesPerProfileTypeLimit, validation.SeriesPerProfileTypeLimitErrorMsg, profileName, series, limit)
+}
+
 func (l *limiter) assertMaxSeriesPerUser(tenantID string, series int) error {
 	// Start by setting the local limit either from override or default
 	localLimit := l.limits.MaxLocalSeriesPerTenant(tenantID)
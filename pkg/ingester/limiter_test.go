@@ -11,12 +11,15 @@ import (
 	"github.com/stretchr/testify/require"
 
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type fakeLimits struct {
 	maxLocalSeriesPerTenant  int
 	maxGlobalSeriesPerTenant int
+	maxGlobalSeriesPerType   map[string]int
 	ingestionTenantShardSize int
+	ingesterSplitShards      int
 }
 
 func (f *fakeLimits) MaxLocalSeriesPerTenant(userID string) int {
@@ -27,10 +30,26 @@ func (f *fakeLimits) MaxGlobalSeriesPerTenant(userID string) int {
 	return f.maxGlobalSeriesPerTenant
 }
 
+func (f *fakeLimits) MaxGlobalSeriesPerProfileType(userID string) map[string]int {
+	return f.maxGlobalSeriesPerType
+}
+
 func (f *fakeLimits) IngestionTenantShardSize(userID string) int {
 	return f.ingestionTenantShardSize
 }
 
+func (f *fakeLimits) IngesterSplitShards(userID string) int {
+	return f.ingesterSplitShards
+}
+
+func (f *fakeLimits) IngesterSeriesIdleTimeout(userID string) time.Duration {
+	return 0
+}
+
+func (f *fakeLimits) BlocksRetentionClass(userID string) string {
+	return ""
+}
+
 type fakeRingCount struct {
 	healthyInstancesCount int
 }
@@ -115,3 +134,30 @@ func TestLocalLimit(t *testing.T) {
 		assertMaxSeries(t, limiter, 3)
 	})
 }
+
+func TestMaxSeriesPerProfileType(t *testing.T) {
+	// 2 cpu series per ingester: 2 / 3 * 3.
+	limiter := NewLimiter("foo", &fakeLimits{
+		maxGlobalSeriesPerTenant: 100,
+		maxGlobalSeriesPerType:   map[string]int{"process_cpu": 2},
+	}, &fakeRingCount{3}, 3)
+	defer limiter.Stop()
+
+	cpu := func(i int) phlaremodel.Labels {
+		return phlaremodel.LabelsFromStrings("__name__", "process_cpu", "i", strconv.Itoa(i))
+	}
+	require.NoError(t, limiter.AllowProfile(1, cpu(1), 0))
+	require.NoError(t, limiter.AllowProfile(2, cpu(2), 0))
+	// Active series are still allowed.
+	require.NoError(t, limiter.AllowProfile(1, cpu(1), 0))
+
+	err := limiter.AllowProfile(3, cpu(3), 0)
+	require.Error(t, err)
+	assert.Equal(t, validation.SeriesPerProfileTypeLimit, validation.ReasonOf(err))
+	assert.ErrorContains(t, err, "profile type 'process_cpu' (2/2)")
+
+	// Other profile types are not limited.
+	for i := 4; i < 10; i++ {
+		require.NoError(t, limiter.AllowProfile(model.Fingerprint(i), phlaremodel.LabelsFromStrings("__name__", "memory", "i", strconv.Itoa(i)), 0))
+	}
+}
@@ -0,0 +1,424 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	commonv1 "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/common/v1"
+	otlpprofiles "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/profiles/v1experimental"
+	resourcev1 "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/resource/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+const (
+	// labelSpanID is the pprof sample label used by the Go runtime
+	// and the Pyroscope SDKs to link samples to trace spans.
+	labelSpanID = "span_id"
+
+	profileNameUnknown = "unknown"
+)
+
+// profileNames maps the pprof period type to the profile name
+// used by Pyroscope agents for the same kind of profile.
+var profileNames = map[string]string{
+	"cpu":         "process_cpu",
+	"wall":        "wall",
+	"space":       "memory",
+	"objects":     "memory",
+	"contentions": "mutex",
+	"delay":       "block",
+	"goroutine":   "goroutine",
+}
+
+// seriesLabels returns the series labels for the profile: resource,
+// scope, and profile container attributes, in that order of precedence.
+func seriesLabels(
+	resource *resourcev1.Resource,
+	scope *commonv1.InstrumentationScope,
+	container *otlpprofiles.ProfileContainer,
+	p *profilev1.Profile,
+) []*typesv1.LabelPair {
+	m := make(map[string]string)
+	if resource != nil {
+		appendAttributes(m, resource.Attributes)
+	}
+	if scope != nil {
+		appendAttributes(m, scope.Attributes)
+	}
+	appendAttributes(m, container.Attributes)
+
+	ls := make(phlaremodel.Labels, 0, len(m)+2)
+	for k, v := range m {
+		ls = append(ls, &typesv1.LabelPair{Name: k, Value: v})
+	}
+	ls = append(ls, &typesv1.LabelPair{
+		Name:  phlaremodel.LabelNameProfileName,
+		Value: profileName(p),
+	})
+	if !isCumulative(container.Profile) {
+		ls = append(ls, &typesv1.LabelPair{
+			Name:  phlaremodel.LabelNameDelta,
+			Value: "false",
+		})
+	}
+	return ls
+}
+
+func appendAttributes(m map[string]string, attrs []*commonv1.KeyValue) {
+	for _, a := range attrs {
+		name := labelName(a.Key)
+		if name == "" || !phlaremodel.IsLabelAllowedForIngestion(name) {
+			continue
+		}
+		if v, ok := attributeValue(a.Value); ok {
+			m[name] = v
+		}
+	}
+}
+
+// labelName converts an attribute key (e.g. "service.name") to a label
+// name by replacing all the characters not allowed in label names with
+// underscores.
+func labelName(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// attributeValue returns the string representation of a scalar attribute
+// value. Arrays and key-value lists are not supported.
+func attributeValue(v *commonv1.AnyValue) (string, bool) {
+	switch x := v.GetValue().(type) {
+	case *commonv1.AnyValue_StringValue:
+		return x.StringValue, true
+	case *commonv1.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue), true
+	case *commonv1.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10), true
+	case *commonv1.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'f', -1, 64), true
+	case *commonv1.AnyValue_BytesValue:
+		return hex.EncodeToString(x.BytesValue), true
+	default:
+		return "", false
+	}
+}
+
+func profileName(p *profilev1.Profile) string {
+	if p.PeriodType == nil {
+		return profileNameUnknown
+	}
+	periodType := p.StringTable[p.PeriodType.Type]
+	if name, ok := profileNames[periodType]; ok {
+		return name
+	}
+	if name := labelName(periodType); name != "" {
+		return name
+	}
+	return profileNameUnknown
+}
+
+func isCumulative(p *otlpprofiles.Profile) bool {
+	for _, st := range p.SampleType {
+		if st.AggregationTemporality == otlpprofiles.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return true
+		}
+	}
+	return false
+}
+
+// convertProfile converts the OTLP profile to the pprof format.
+// All indices are validated: the function returns an error if
+// any of them points out of the profile tables.
+func convertProfile(container *otlpprofiles.ProfileContainer) (*profilev1.Profile, error) {
+	src := container.Profile
+	if src == nil {
+		return nil, fmt.Errorf("profile is missing")
+	}
+	c := &converter{
+		src: src,
+		dst: &profilev1.Profile{
+			StringTable:       src.StringTable,
+			DropFrames:        src.DropFrames,
+			KeepFrames:        src.KeepFrames,
+			TimeNanos:         src.TimeNanos,
+			DurationNanos:     src.DurationNanos,
+			Period:            src.Period,
+			Comment:           src.Comment,
+			DefaultSampleType: src.DefaultSampleType,
+		},
+	}
+	switch {
+	case len(c.dst.StringTable) == 0:
+		c.dst.StringTable = []string{""}
+	case c.dst.StringTable[0] != "":
+		return nil, fmt.Errorf("string table must start with an empty string")
+	}
+	if err := c.convert(); err != nil {
+		return nil, err
+	}
+	p := c.dst
+	if p.TimeNanos == 0 {
+		p.TimeNanos = int64(container.StartTimeUnixNano)
+	}
+	if p.TimeNanos == 0 {
+		p.TimeNanos = time.Now().UnixNano()
+	}
+	if p.DurationNanos == 0 && container.EndTimeUnixNano > container.StartTimeUnixNano {
+		p.DurationNanos = int64(container.EndTimeUnixNano - container.StartTimeUnixNano)
+	}
+	return p, nil
+}
+
+type converter struct {
+	src     *otlpprofiles.Profile
+	dst     *profilev1.Profile
+	strings map[string]int64
+}
+
+func (c *converter) convert() (err error) {
+	for _, st := range c.src.SampleType {
+		vt, err := c.valueType(st)
+		if err != nil {
+			return err
+		}
+		c.dst.SampleType = append(c.dst.SampleType, vt)
+	}
+	if c.src.PeriodType != nil {
+		if c.dst.PeriodType, err = c.valueType(c.src.PeriodType); err != nil {
+			return err
+		}
+	}
+	if c.dst.DropFrames, err = c.str(c.dst.DropFrames); err != nil {
+		return err
+	}
+	if c.dst.KeepFrames, err = c.str(c.dst.KeepFrames); err != nil {
+		return err
+	}
+	if c.dst.DefaultSampleType, err = c.str(c.dst.DefaultSampleType); err != nil {
+		return err
+	}
+	for i := range c.dst.Comment {
+		if c.dst.Comment[i], err = c.str(c.dst.Comment[i]); err != nil {
+			return err
+		}
+	}
+	if err = c.convertMappings(); err != nil {
+		return err
+	}
+	if err = c.convertFunctions(); err != nil {
+		return err
+	}
+	if err = c.convertLocations(); err != nil {
+		return err
+	}
+	return c.convertSamples()
+}
+
+func (c *converter) valueType(vt *otlpprofiles.ValueType) (*profilev1.ValueType, error) {
+	t, err := c.str(vt.Type)
+	if err != nil {
+		return nil, err
+	}
+	u, err := c.str(vt.Unit)
+	if err != nil {
+		return nil, err
+	}
+	return &profilev1.ValueType{Type: t, Unit: u}, nil
+}
+
+func (c *converter) convertMappings() (err error) {
+	c.dst.Mapping = make([]*profilev1.Mapping, len(c.src.Mapping))
+	for i, m := range c.src.Mapping {
+		x := &profilev1.Mapping{
+			Id:              uint64(i + 1),
+			MemoryStart:     m.MemoryStart,
+			MemoryLimit:     m.MemoryLimit,
+			FileOffset:      m.FileOffset,
+			HasFunctions:    m.HasFunctions,
+			HasFilenames:    m.HasFilenames,
+			HasLineNumbers:  m.HasLineNumbers,
+			HasInlineFrames: m.HasInlineFrames,
+		}
+		if x.Filename, err = c.str(m.Filename); err != nil {
+			return err
+		}
+		if x.BuildId, err = c.str(m.BuildId); err != nil {
+			return err
+		}
+		c.dst.Mapping[i] = x
+	}
+	return nil
+}
+
+func (c *converter) convertFunctions() (err error) {
+	c.dst.Function = make([]*profilev1.Function, len(c.src.Function))
+	for i, f := range c.src.Function {
+		x := &profilev1.Function{
+			Id:        uint64(i + 1),
+			StartLine: f.StartLine,
+		}
+		if x.Name, err = c.str(f.Name); err != nil {
+			return err
+		}
+		if x.SystemName, err = c.str(f.SystemName); err != nil {
+			return err
+		}
+		if x.Filename, err = c.str(f.Filename); err != nil {
+			return err
+		}
+		c.dst.Function[i] = x
+	}
+	return nil
+}
+
+func (c *converter) convertLocations() error {
+	c.dst.Location = make([]*profilev1.Location, len(c.src.Location))
+	for i, l := range c.src.Location {
+		x := &profilev1.Location{
+			Id:       uint64(i + 1),
+			Address:  l.Address,
+			IsFolded: l.IsFolded,
+			Line:     make([]*profilev1.Line, len(l.Line)),
+		}
+		if len(c.src.Mapping) > 0 {
+			if l.MappingIndex >= uint64(len(c.src.Mapping)) {
+				return fmt.Errorf("location %d: mapping index %d out of range", i, l.MappingIndex)
+			}
+			x.MappingId = l.MappingIndex + 1
+		}
+		for j, line := range l.Line {
+			if line.FunctionIndex >= uint64(len(c.src.Function)) {
+				return fmt.Errorf("location %d: function index %d out of range", i, line.FunctionIndex)
+			}
+			x.Line[j] = &profilev1.Line{
+				FunctionId: line.FunctionIndex + 1,
+				Line:       line.Line,
+			}
+		}
+		c.dst.Location[i] = x
+	}
+	return nil
+}
+
+func (c *converter) convertSamples() (err error) {
+	c.dst.Sample = make([]*profilev1.Sample, len(c.src.Sample))
+	for i, s := range c.src.Sample {
+		x := &profilev1.Sample{Value: s.Value}
+		if x.LocationId, err = c.sampleLocations(s); err != nil {
+			return fmt.Errorf("sample %d: %w", i, err)
+		}
+		if x.Label, err = c.sampleLabels(s); err != nil {
+			return fmt.Errorf("sample %d: %w", i, err)
+		}
+		c.dst.Sample[i] = x
+	}
+	return nil
+}
+
+func (c *converter) sampleLocations(s *otlpprofiles.Sample) ([]uint64, error) {
+	var locations []uint64
+	if s.LocationsLength > 0 {
+		end := s.LocationsStartIndex + s.LocationsLength
+		if end > uint64(len(c.src.LocationIndices)) {
+			return nil, fmt.Errorf("locations range [%d:%d] out of range", s.LocationsStartIndex, end)
+		}
+		locations = make([]uint64, 0, s.LocationsLength)
+		for _, idx := range c.src.LocationIndices[s.LocationsStartIndex:end] {
+			if idx < 0 || idx >= int64(len(c.src.Location)) {
+				return nil, fmt.Errorf("location index %d out of range", idx)
+			}
+			locations = append(locations, uint64(idx)+1)
+		}
+		return locations, nil
+	}
+	locations = make([]uint64, 0, len(s.LocationIndex))
+	for _, idx := range s.LocationIndex {
+		if idx >= uint64(len(c.src.Location)) {
+			return nil, fmt.Errorf("location index %d out of range", idx)
+		}
+		locations = append(locations, idx+1)
+	}
+	return locations, nil
+}
+
+func (c *converter) sampleLabels(s *otlpprofiles.Sample) (_ []*profilev1.Label, err error) {
+	labels := make([]*profilev1.Label, 0, len(s.Label)+len(s.Attributes)+1)
+	for _, l := range s.Label {
+		x := &profilev1.Label{Num: l.Num}
+		if x.Key, err = c.str(l.Key); err != nil {
+			return nil, err
+		}
+		if x.Str, err = c.str(l.Str); err != nil {
+			return nil, err
+		}
+		if x.NumUnit, err = c.str(l.NumUnit); err != nil {
+			return nil, err
+		}
+		labels = append(labels, x)
+	}
+	for _, idx := range s.Attributes {
+		if idx >= uint64(len(c.src.AttributeTable)) {
+			return nil, fmt.Errorf("attribute index %d out of range", idx)
+		}
+		a := c.src.AttributeTable[idx]
+		x := &profilev1.Label{Key: c.addString(a.Key)}
+		if n, ok := a.Value.GetValue().(*commonv1.AnyValue_IntValue); ok {
+			x.Num = n.IntValue
+		} else if v, ok := attributeValue(a.Value); ok {
+			x.Str = c.addString(v)
+		} else {
+			continue
+		}
+		labels = append(labels, x)
+	}
+	if s.Link > 0 && s.Link < uint64(len(c.src.LinkTable)) {
+		if spanID := c.src.LinkTable[s.Link].SpanId; len(spanID) > 0 {
+			labels = append(labels, &profilev1.Label{
+				Key: c.addString(labelSpanID),
+				Str: c.addString(hex.EncodeToString(spanID)),
+			})
+		}
+	}
+	return labels, nil
+}
+
+// str validates the string table reference.
+func (c *converter) str(x int64) (int64, error) {
+	if x < 0 || x >= int64(len(c.dst.StringTable)) {
+		return 0, fmt.Errorf("string index %d out of range", x)
+	}
+	return x, nil
+}
+
+func (c *converter) addString(s string) int64 {
+	if c.strings == nil {
+		c.strings = make(map[string]int64, len(c.dst.StringTable))
+		for i, v := range c.dst.StringTable {
+			if _, ok := c.strings[v]; !ok {
+				c.strings[v] = int64(i)
+			}
+		}
+	}
+	if i, ok := c.strings[s]; ok {
+		return i
+	}
+	i := int64(len(c.dst.StringTable))
+	c.dst.StringTable = append(c.dst.StringTable, s)
+	c.strings[s] = i
+	return i
+}
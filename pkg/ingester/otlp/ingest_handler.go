@@ -0,0 +1,153 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	profilesv1experimental "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/collector/profiles/v1experimental"
+	profilesv1experimentalconnect "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/collector/profiles/v1experimental/v1experimentalconnect"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+type PushService interface {
+	PushParsed(ctx context.Context, req *distributormodel.PushRequest) (*connect.Response[pushv1.PushResponse], error)
+}
+
+// Handler accepts profiles in the OpenTelemetry format, both via OTLP/gRPC
+// (ProfilesService) and OTLP/HTTP, and pushes them to the distributor.
+type Handler struct {
+	profilesv1experimentalconnect.UnimplementedProfilesServiceHandler
+
+	svc PushService
+	log log.Logger
+}
+
+var _ profilesv1experimentalconnect.ProfilesServiceHandler = (*Handler)(nil)
+
+func NewOTLPIngestHandler(svc PushService, logger log.Logger) *Handler {
+	return &Handler{
+		svc: svc,
+		log: logger,
+	}
+}
+
+func (h *Handler) Export(ctx context.Context, req *connect.Request[profilesv1experimental.ExportProfilesServiceRequest]) (*connect.Response[profilesv1experimental.ExportProfilesServiceResponse], error) {
+	if err := h.export(ctx, req.Msg, proto.Size(req.Msg)); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&profilesv1experimental.ExportProfilesServiceResponse{}), nil
+}
+
+// ServeHTTP implements the OTLP/HTTP protocol: the request body is either
+// a binary or JSON encoded ExportProfilesServiceRequest, and the response
+// is encoded the same way.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = contentTypeProtobuf
+	}
+	var (
+		unmarshal func([]byte, proto.Message) error
+		marshal   func(proto.Message) ([]byte, error)
+	)
+	switch contentType {
+	case contentTypeProtobuf:
+		unmarshal, marshal = proto.Unmarshal, proto.Marshal
+	case contentTypeJSON:
+		unmarshal, marshal = protojson.Unmarshal, protojson.Marshal
+	default:
+		httputil.ErrorWithStatus(w, fmt.Errorf("unsupported content type: %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	var req profilesv1experimental.ExportProfilesServiceRequest
+	if err = unmarshal(body, &req); err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+	if err = h.export(r.Context(), &req, len(body)); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	resp, err := marshal(&profilesv1experimental.ExportProfilesServiceResponse{})
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(resp)
+}
+
+func (h *Handler) export(ctx context.Context, req *profilesv1experimental.ExportProfilesServiceRequest, size int) error {
+	p := &distributormodel.PushRequest{
+		RawProfileSize: size,
+		RawProfileType: distributormodel.RawProfileTypeOTLP,
+	}
+	// All allocated pprof.Profile instances must be closed after use.
+	defer func() {
+		for _, s := range p.Series {
+			for _, x := range s.Samples {
+				x.Profile.Close()
+			}
+		}
+	}()
+	for _, rp := range req.ResourceProfiles {
+		for _, sp := range rp.ScopeProfiles {
+			for _, container := range sp.Profiles {
+				profile, err := convertProfile(container)
+				if err != nil {
+					return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("failed to convert otlp profile: %w", err))
+				}
+				p.Series = append(p.Series, &distributormodel.ProfileSeries{
+					Labels: seriesLabels(rp.Resource, sp.Scope, container, profile),
+					Samples: []*distributormodel.ProfileSample{{
+						Profile: pprof.RawFromProto(profile),
+						ID:      profileID(container.ProfileId),
+					}},
+				})
+			}
+		}
+	}
+	if len(p.Series) == 0 {
+		tenantID, _ := tenant.ExtractTenantIDFromContext(ctx)
+		level.Debug(h.log).Log("msg", "empty otlp profiles request", "orgID", tenantID)
+		return nil
+	}
+	if _, err := h.svc.PushParsed(ctx, p); err != nil {
+		level.Debug(h.log).Log("msg", "failed to push otlp profiles", "err", err)
+		return err
+	}
+	return nil
+}
+
+func profileID(b []byte) string {
+	if id, err := uuid.FromBytes(b); err == nil {
+		return id.String()
+	}
+	return uuid.New().String()
+}
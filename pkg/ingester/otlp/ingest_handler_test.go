@@ -0,0 +1,203 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	profilesv1experimental "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/collector/profiles/v1experimental"
+	commonv1 "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/common/v1"
+	otlpprofiles "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/profiles/v1experimental"
+	resourcev1 "github.com/grafana/pyroscope/api/gen/proto/go/opentelemetry/proto/resource/v1"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type mockPushService struct {
+	series   []*distributormodel.ProfileSeries
+	profiles []*profilev1.Profile
+	err      error
+}
+
+func (m *mockPushService) PushParsed(_ context.Context, req *distributormodel.PushRequest) (*connect.Response[pushv1.PushResponse], error) {
+	for _, s := range req.Series {
+		m.series = append(m.series, s)
+		for _, x := range s.Samples {
+			m.profiles = append(m.profiles, x.Profile.CloneVT())
+		}
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), m.err
+}
+
+func labelsMap(ls []*typesv1.LabelPair) map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func stringValue(s string) *commonv1.AnyValue {
+	return &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: s}}
+}
+
+func testRequest() *profilesv1experimental.ExportProfilesServiceRequest {
+	return &profilesv1experimental.ExportProfilesServiceRequest{
+		ResourceProfiles: []*otlpprofiles.ResourceProfiles{{
+			Resource: &resourcev1.Resource{
+				Attributes: []*commonv1.KeyValue{
+					{Key: "service.name", Value: stringValue("my-service")},
+					{Key: "k8s.pod.name", Value: stringValue("my-pod")},
+				},
+			},
+			ScopeProfiles: []*otlpprofiles.ScopeProfiles{{
+				Profiles: []*otlpprofiles.ProfileContainer{{
+					StartTimeUnixNano: 1e9,
+					EndTimeUnixNano:   11e9,
+					Attributes: []*commonv1.KeyValue{
+						{Key: "__private__", Value: stringValue("ignored")},
+					},
+					Profile: &otlpprofiles.Profile{
+						StringTable: []string{"", "samples", "count", "cpu", "nanoseconds", "main", "foo", "main.go", "thread", "worker"},
+						SampleType: []*otlpprofiles.ValueType{
+							{Type: 1, Unit: 2},
+							{Type: 3, Unit: 4},
+						},
+						PeriodType: &otlpprofiles.ValueType{Type: 3, Unit: 4},
+						Period:     10000000,
+						Function: []*otlpprofiles.Function{
+							{Name: 5, Filename: 7},
+							{Name: 6, Filename: 7},
+						},
+						Location: []*otlpprofiles.Location{
+							{Line: []*otlpprofiles.Line{{FunctionIndex: 0, Line: 10}}},
+							{Line: []*otlpprofiles.Line{{FunctionIndex: 1, Line: 20}}},
+						},
+						LocationIndices: []int64{1, 0},
+						AttributeTable: []*commonv1.KeyValue{
+							{Key: "thread.name", Value: stringValue("worker")},
+						},
+						LinkTable: []*otlpprofiles.Link{
+							{},
+							{SpanId: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+						},
+						Sample: []*otlpprofiles.Sample{
+							{
+								LocationsStartIndex: 0,
+								LocationsLength:     2,
+								Value:               []int64{1, 10000000},
+								Attributes:          []uint64{0},
+								Link:                1,
+							},
+							{
+								LocationIndex: []uint64{0},
+								Value:         []int64{2, 20000000},
+							},
+						},
+					},
+				}},
+			}},
+		}},
+	}
+}
+
+func Test_Export(t *testing.T) {
+	svc := new(mockPushService)
+	h := NewOTLPIngestHandler(svc, log.NewNopLogger())
+	_, err := h.Export(context.Background(), connect.NewRequest(testRequest()))
+	require.NoError(t, err)
+
+	require.Len(t, svc.series, 1)
+	assert.Equal(t, map[string]string{
+		"__name__":     "process_cpu",
+		"__delta__":    "false",
+		"service_name": "my-service",
+		"k8s_pod_name": "my-pod",
+	}, labelsMap(svc.series[0].Labels))
+
+	require.Len(t, svc.profiles, 1)
+	p := svc.profiles[0]
+	assert.Equal(t, int64(1e9), p.TimeNanos)
+	assert.Equal(t, int64(10e9), p.DurationNanos)
+	assert.Equal(t, []*profilev1.ValueType{{Type: 1, Unit: 2}, {Type: 3, Unit: 4}}, p.SampleType)
+	require.Len(t, p.Sample, 2)
+	assert.Equal(t, []uint64{2, 1}, p.Sample[0].LocationId)
+	assert.Equal(t, []uint64{1}, p.Sample[1].LocationId)
+	assert.Equal(t, uint64(1), p.Location[0].Line[0].FunctionId)
+	assert.Equal(t, uint64(2), p.Location[1].Line[0].FunctionId)
+
+	labels := make(map[string]string)
+	for _, l := range p.Sample[0].Label {
+		labels[p.StringTable[l.Key]] = p.StringTable[l.Str]
+	}
+	assert.Equal(t, map[string]string{
+		"thread.name": "worker",
+		"span_id":     "0102030405060708",
+	}, labels)
+	assert.Empty(t, p.Sample[1].Label)
+}
+
+func Test_Export_InvalidProfile(t *testing.T) {
+	req := testRequest()
+	req.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].Profile.Sample[1].LocationIndex = []uint64{5}
+	h := NewOTLPIngestHandler(new(mockPushService), log.NewNopLogger())
+	_, err := h.Export(context.Background(), connect.NewRequest(req))
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_ServeHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		marshal     func(proto.Message) ([]byte, error)
+	}{
+		{contentType: "application/x-protobuf", marshal: proto.Marshal},
+		{contentType: "application/json", marshal: protojson.Marshal},
+	} {
+		t.Run(tc.contentType, func(t *testing.T) {
+			svc := new(mockPushService)
+			body, err := tc.marshal(testRequest())
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/otlp/v1/profiles", bytes.NewReader(body))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			NewOTLPIngestHandler(svc, log.NewNopLogger()).ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+			require.Len(t, svc.series, 1)
+			assert.Equal(t, "my-service", phlaremodel.Labels(svc.series[0].Labels).Get("service_name"))
+		})
+	}
+
+	t.Run("push error", func(t *testing.T) {
+		svc := &mockPushService{err: connect.NewError(connect.CodeResourceExhausted, assert.AnError)}
+		body, err := proto.Marshal(testRequest())
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/otlp/v1/profiles", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		w := httptest.NewRecorder()
+		NewOTLPIngestHandler(svc, log.NewNopLogger()).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/otlp/v1/profiles", bytes.NewReader(nil))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		NewOTLPIngestHandler(new(mockPushService), log.NewNopLogger()).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+}
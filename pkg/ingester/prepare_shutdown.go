@@ -0,0 +1,121 @@
+package ingester
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// shutdownMarkerFilename is the file created in the data directory when
+// the shutdown of the ingester is prepared, so that the ingester still
+// leaves the ring if it is restarted before it is scaled down.
+const shutdownMarkerFilename = "shutdown-requested.txt"
+
+func (i *Ingester) shutdownMarkerPath() string {
+	return filepath.Join(i.dbConfig.DataPath, shutdownMarkerFilename)
+}
+
+// PrepareShutdownHandler prepares the ingester to be scaled down: on POST,
+// the heads of all the tenants are flushed, the blocks are uploaded to the
+// object storage, and the ingester is configured to leave the ring when it
+// is shut down. The queriers therefore do not depend on the ingester to
+// serve its profiles once it is gone. DELETE reverts the configuration,
+// and GET reports whether the shutdown is prepared ("set" or "unset").
+func (i *Ingester) PrepareShutdownHandler(w http.ResponseWriter, r *http.Request) {
+	// The shutdown configuration must not change while the ingester is
+	// starting or stopping.
+	if i.State() != services.Running {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if i.lifecycler.ShouldUnregisterOnShutdown() {
+			util.WriteTextResponse(w, "set")
+		} else {
+			util.WriteTextResponse(w, "unset")
+		}
+
+	case http.MethodPost:
+		if err := i.createShutdownMarker(); err != nil {
+			httputil.ErrorWithStatus(w, errors.Wrap(err, "creating shutdown marker"), http.StatusInternalServerError)
+			return
+		}
+		i.setPrepareShutdown()
+		if err := i.flushAndShip(r.Context()); err != nil {
+			httputil.ErrorWithStatus(w, err, http.StatusInternalServerError)
+			return
+		}
+		level.Info(i.logger).Log("msg", "ingester prepared for shutdown")
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := os.Remove(i.shutdownMarkerPath()); err != nil && !os.IsNotExist(err) {
+			httputil.ErrorWithStatus(w, errors.Wrap(err, "removing shutdown marker"), http.StatusInternalServerError)
+			return
+		}
+		i.unsetPrepareShutdown()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (i *Ingester) createShutdownMarker() error {
+	if err := os.MkdirAll(i.dbConfig.DataPath, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(i.shutdownMarkerPath(), []byte(time.Now().UTC().Format(time.RFC3339)), 0o644)
+}
+
+func (i *Ingester) setPrepareShutdown() {
+	i.lifecycler.SetUnregisterOnShutdown(true)
+	i.prepareShutdownRequested.Set(1)
+}
+
+func (i *Ingester) unsetPrepareShutdown() {
+	i.lifecycler.SetUnregisterOnShutdown(i.cfg.LifecyclerConfig.UnregisterOnShutdown)
+	i.prepareShutdownRequested.Set(0)
+}
+
+// restorePrepareShutdown configures the ingester to leave the ring on
+// shutdown, if the shutdown was prepared before the ingester restarted.
+func (i *Ingester) restorePrepareShutdown() error {
+	_, err := os.Stat(i.shutdownMarkerPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	level.Info(i.logger).Log("msg", "shutdown marker found, the ingester will leave the ring on shutdown")
+	i.setPrepareShutdown()
+	return nil
+}
+
+// flushAndShip flushes the heads of all the tenants, and uploads the
+// blocks to the object storage.
+func (i *Ingester) flushAndShip(ctx context.Context) error {
+	i.instancesMtx.RLock()
+	defer i.instancesMtx.RUnlock()
+	for tenantID, inst := range i.instances {
+		if err := inst.Flush(ctx); err != nil {
+			return errors.Wrapf(err, "flushing tenant %s", tenantID)
+		}
+		if err := inst.runShipper(ctx); err != nil {
+			return errors.Wrapf(err, "uploading blocks of tenant %s", tenantID)
+		}
+	}
+	return nil
+}
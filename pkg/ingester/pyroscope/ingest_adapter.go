@@ -23,6 +23,7 @@ import (
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/og/ingestion"
 	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
 	"github.com/grafana/pyroscope/pkg/og/storage/tree"
 )
 
@@ -89,13 +90,16 @@ func (p *pyroscopeIngesterAdapter) Put(ctx context.Context, pi *storage.PutInput
 		mdata.Period = period
 		mdata.PeriodType = stTypeCPU
 		mdata.PeriodUnit = stUnitNanos
-		if metric == metricWall {
-			mdata.Type = stTypeWall
-		} else {
-			mdata.Type = stTypeCPU
+		// Values already measured in nanoseconds are not scaled.
+		if stUnit == stUnitCount {
+			if metric == metricWall {
+				mdata.Type = stTypeWall
+			} else {
+				mdata.Type = stTypeCPU
+			}
+			mdata.Unit = stUnitNanos
+			pi.Val.Scale(uint64(period))
 		}
-		mdata.Unit = stUnitNanos
-		pi.Val.Scale(uint64(period))
 	}
 	pprof := pi.Val.Pprof(mdata)
 	b, err := proto.Marshal(pprof)
@@ -209,9 +213,9 @@ func convertMetadata(pi *storage.PutInput) (metricName, stType, stUnit, app stri
 	}
 	switch stType {
 	case stTypeCPU:
-		metricName = metricProcessCPU
-		stType = stTypeSamples
-		stUnit = stUnitCount
+		// Profiles of the default type (e.g. folded stacks) may specify
+		// the units of the values explicitly.
+		return convertUnits(pi.Units, app)
 	case "wall":
 		metricName = metricWall
 		stType = stTypeSamples
@@ -289,3 +293,39 @@ func convertMetadata(pi *storage.PutInput) (metricName, stType, stUnit, app stri
 
 	return metricName, stType, stUnit, app, err
 }
+
+func convertUnits(units metadata.Units, app string) (metricName, stType, stUnit, _ string, err error) {
+	switch units {
+	case metadata.SamplesUnits, "":
+		metricName = metricProcessCPU
+		stType = stTypeSamples
+		stUnit = stUnitCount
+	case metadata.NanosecondsUnits:
+		metricName = metricProcessCPU
+		stType = stTypeCPU
+		stUnit = stUnitNanos
+	case metadata.BytesUnits:
+		metricName = metricMemory
+		stType = "alloc_space"
+		stUnit = stUnitBytes
+	case metadata.ObjectsUnits:
+		metricName = metricMemory
+		stType = "alloc_objects"
+		stUnit = stUnitCount
+	case metadata.GoroutinesUnits:
+		metricName = "goroutine"
+		stType = "goroutines"
+		stUnit = stUnitCount
+	case metadata.LockSamplesUnits:
+		metricName = metricMutex
+		stType = stTypeContentions
+		stUnit = stUnitCount
+	case metadata.LockNanosecondsUnits:
+		metricName = metricMutex
+		stType = stTypeDelay
+		stUnit = stUnitNanos
+	default:
+		err = fmt.Errorf("unknown units: %s", units)
+	}
+	return metricName, stType, stUnit, app, err
+}
@@ -14,11 +14,15 @@ import (
 
 	"github.com/grafana/pyroscope/pkg/og/convert/speedscope"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	"github.com/grafana/pyroscope/pkg/og/agent/types"
+	"github.com/grafana/pyroscope/pkg/og/convert/cpuprofile"
 	"github.com/grafana/pyroscope/pkg/og/convert/jfr"
+	"github.com/grafana/pyroscope/pkg/og/convert/nettrace"
+	"github.com/grafana/pyroscope/pkg/og/convert/perf"
 	"github.com/grafana/pyroscope/pkg/og/convert/pprof"
 	"github.com/grafana/pyroscope/pkg/og/convert/profile"
 	"github.com/grafana/pyroscope/pkg/og/ingestion"
@@ -55,7 +59,9 @@ func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		_ = h.log.Log("msg", "pyroscope ingest", "err", err, "orgID", tenantID)
 
-		if ingestion.IsIngestionError(err) {
+		// Rate limited requests keep their status code and
+		// the Retry-After header set by the distributor.
+		if ingestion.IsIngestionError(err) || connect.CodeOf(err) == connect.CodeResourceExhausted {
 			httputil.Error(w, err)
 		} else {
 			httputil.ErrorWithStatus(w, err, http.StatusUnprocessableEntity)
@@ -110,8 +116,10 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 	}
 
 	if u := q.Get("units"); u != "" {
-		// TODO(petethepig): add validation for these?
 		input.Metadata.Units = metadata.Units(u)
+		if !input.Metadata.Units.IsValid() {
+			return nil, fmt.Errorf("units: unknown units %q", u)
+		}
 	} else {
 		input.Metadata.Units = metadata.SamplesUnits
 	}
@@ -159,6 +167,24 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 			RawData: b,
 		}
 
+	case format == "cpuprofile":
+		input.Format = ingestion.FormatCPUProfile
+		input.Profile = &cpuprofile.RawProfile{
+			RawData: b,
+		}
+
+	case format == "perf":
+		input.Format = ingestion.FormatPerf
+		input.Profile = &perf.RawProfile{
+			RawData: b,
+		}
+
+	case format == "nettrace":
+		input.Format = ingestion.FormatNettrace
+		input.Profile = &nettrace.RawProfile{
+			RawData: b,
+		}
+
 	case strings.Contains(contentType, "multipart/form-data"):
 		input.Profile = &pprof.RawProfile{
 			FormDataContentType: contentType,
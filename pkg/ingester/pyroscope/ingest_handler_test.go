@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"sort"
@@ -39,6 +41,7 @@ type MockPushService struct {
 	Keep     bool
 	reqPprof []*flatProfileSeries
 	T        testing.TB
+	Err      error
 }
 
 func (m *MockPushService) PushParsed(ctx context.Context, req *model.PushRequest) (*connect.Response[pushv1.PushResponse], error) {
@@ -68,6 +71,9 @@ type Dump struct {
 }
 
 func (m *MockPushService) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
 	for _, series := range req.Msg.Series {
 		for _, sample := range series.Samples {
 			p, err := pprof.RawFromBytes(sample.RawProfile)
@@ -460,3 +466,108 @@ func createPProfRequest(t *testing.T, profile, prevProfile, sampleTypeConfig []b
 
 	return b.Bytes(), w.FormDataContentType()
 }
+
+func TestIngestFoldedUnits(t *testing.T) {
+	testdata := []struct {
+		query string
+
+		expectStatus    int
+		expectMetric    string
+		expectType      string
+		expectUnit      string
+		expectCollapsed []string
+	}{
+		{
+			query:           "sampleRate=100",
+			expectStatus:    200,
+			expectMetric:    "process_cpu",
+			expectType:      "cpu",
+			expectUnit:      "nanoseconds",
+			expectCollapsed: []string{"foo;bar 1000000000", "foo;baz 2000000000"},
+		},
+		{
+			query:           "units=nanoseconds&sampleRate=100",
+			expectStatus:    200,
+			expectMetric:    "process_cpu",
+			expectType:      "cpu",
+			expectUnit:      "nanoseconds",
+			expectCollapsed: []string{"foo;bar 100", "foo;baz 200"},
+		},
+		{
+			query:           "units=bytes",
+			expectStatus:    200,
+			expectMetric:    "memory",
+			expectType:      "alloc_space",
+			expectUnit:      "bytes",
+			expectCollapsed: []string{"foo;bar 100", "foo;baz 200"},
+		},
+		{
+			query:        "units=parsecs",
+			expectStatus: 400,
+		},
+	}
+	for _, td := range testdata {
+		t.Run(td.query, func(t *testing.T) {
+			svc := &MockPushService{T: t}
+			h := NewPyroscopeIngestHandler(svc, log.NewNopLogger())
+
+			res := httptest.NewRecorder()
+			body := strings.NewReader("foo;bar 100\nfoo;baz 200\n")
+			req := httptest.NewRequest("POST", "/ingest?name=app&format=folded&"+td.query, body)
+			h.ServeHTTP(res, req)
+			require.Equal(t, td.expectStatus, res.Code, res.Body.String())
+			if td.expectStatus != 200 {
+				return
+			}
+
+			require.Len(t, svc.reqPprof, 1)
+			p := svc.reqPprof[0].Profile
+			assert.Equal(t, td.expectMetric, phlaremodel.Labels(svc.reqPprof[0].Labels).Get(labels.MetricName))
+			require.Len(t, p.SampleType, 1)
+			assert.Equal(t, td.expectType, p.StringTable[p.SampleType[0].Type])
+			assert.Equal(t, td.expectUnit, p.StringTable[p.SampleType[0].Unit])
+			collapsed := bench.StackCollapseProto(p, 0, 1.0)
+			slices.Sort(collapsed)
+			assert.Equal(t, td.expectCollapsed, collapsed)
+		})
+	}
+}
+
+func TestIngestRateLimited(t *testing.T) {
+	err := connect.NewError(connect.CodeResourceExhausted, errors.New("ingestion quota exceeded"))
+	err.Meta().Set("Retry-After", "12")
+	h := NewPyroscopeIngestHandler(&MockPushService{T: t, Err: err}, log.NewNopLogger())
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ingest?name=app&format=folded", strings.NewReader("foo;bar 100\n"))
+	h.ServeHTTP(res, req)
+	require.Equal(t, http.StatusTooManyRequests, res.Code, res.Body.String())
+	assert.Equal(t, "12", res.Header().Get("Retry-After"))
+}
+
+func TestIngestCPUProfile(t *testing.T) {
+	profile, err := os.ReadFile(repoRoot + "pkg/og/convert/cpuprofile/testdata/simple.cpuprofile")
+	require.NoError(t, err)
+	svc := &MockPushService{T: t}
+	h := NewPyroscopeIngestHandler(svc, log.NewNopLogger())
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ingest?name=nodeapp&format=cpuprofile", bytes.NewReader(profile))
+	h.ServeHTTP(res, req)
+	require.Equal(t, 200, res.Code, res.Body.String())
+
+	require.Len(t, svc.reqPprof, 1)
+	p := svc.reqPprof[0].Profile
+	assert.Equal(t, "process_cpu", phlaremodel.Labels(svc.reqPprof[0].Labels).Get(labels.MetricName))
+	assert.Equal(t, "nodeapp", phlaremodel.Labels(svc.reqPprof[0].Labels).Get("service_name"))
+	require.Len(t, p.SampleType, 1)
+	assert.Equal(t, "cpu", p.StringTable[p.SampleType[0].Type])
+	assert.Equal(t, "nanoseconds", p.StringTable[p.SampleType[0].Unit])
+	collapsed := bench.StackCollapseProto(p, 0, 1.0)
+	slices.Sort(collapsed)
+	assert.Equal(t, []string{
+		"(program) 5000000",
+		"main file:///app/index.js:1;(anonymous) file:///app/index.js:20 15000000",
+		"main file:///app/index.js:1;fib file:///app/index.js:10 30000000",
+	}, collapsed)
+}
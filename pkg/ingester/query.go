@@ -41,6 +41,12 @@ func (i *Ingester) Series(ctx context.Context, req *connect.Request[ingestv1.Ser
 	})
 }
 
+func (i *Ingester) MergeSpanProfile(ctx context.Context, req *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+		return instance.MergeSpanProfile(ctx, req)
+	})
+}
+
 func (i *Ingester) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
 	return i.forInstance(ctx, func(instance *instance) error {
 		return instance.MergeProfilesStacktraces(ctx, stream)
@@ -58,3 +64,9 @@ func (i *Ingester) MergeProfilesPprof(ctx context.Context, stream *connect.BidiS
 		return instance.MergeProfilesPprof(ctx, stream)
 	})
 }
+
+func (i *Ingester) MergeBlocksStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
+	return i.forInstance(ctx, func(instance *instance) error {
+		return instance.MergeBlocksStacktraces(ctx, stream)
+	})
+}
@@ -0,0 +1,92 @@
+package ingester
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+type snapshotResponse struct {
+	Tenants map[string]*phlaredb.HeadSnapshot `json:"tenants"`
+}
+
+// SnapshotHandler takes a snapshot of the heads of all the tenants. Once
+// the snapshots are taken, the heads are not flushed on shutdown, and are
+// restored when the ingester is started with the same data directory:
+// the ingester can be rescheduled without cutting and uploading blocks.
+func (i *Ingester) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	i.instancesMtx.RLock()
+	defer i.instancesMtx.RUnlock()
+	resp := snapshotResponse{Tenants: make(map[string]*phlaredb.HeadSnapshot, len(i.instances))}
+	for tenantID, inst := range i.instances {
+		s, err := inst.Snapshot(r.Context())
+		if errors.Is(err, phlaredb.ErrSnapshotWALDisabled) {
+			httputil.ErrorWithStatus(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			httputil.ErrorWithStatus(w, errors.Wrapf(err, "tenant %s", tenantID), http.StatusInternalServerError)
+			return
+		}
+		if s != nil {
+			resp.Tenants[tenantID] = s
+		}
+	}
+	util.WriteJSONResponse(w, resp)
+}
+
+type restoreResponse struct {
+	Tenants []string `json:"tenants"`
+}
+
+// RestoreHandler restores the snapshots of the heads found in the data
+// directory. The snapshots of a tenant are restored when its instance is
+// opened, which otherwise only happens on the first request of the tenant.
+func (i *Ingester) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(i.dbConfig.DataPath)
+	if err != nil && !os.IsNotExist(err) {
+		httputil.ErrorWithStatus(w, err, http.StatusInternalServerError)
+		return
+	}
+	resp := restoreResponse{Tenants: []string{}}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		tenantID := e.Name()
+		ok, err := phlaredb.HasSnapshots(filepath.Join(i.dbConfig.DataPath, tenantID))
+		if err != nil {
+			httputil.ErrorWithStatus(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err = i.restoreTenant(r, tenantID); err != nil {
+			httputil.ErrorWithStatus(w, errors.Wrapf(err, "tenant %s", tenantID), http.StatusInternalServerError)
+			return
+		}
+		resp.Tenants = append(resp.Tenants, tenantID)
+	}
+	util.WriteJSONResponse(w, resp)
+}
+
+func (i *Ingester) restoreTenant(r *http.Request, tenantID string) error {
+	if inst, ok := i.getInstanceByID(tenantID); ok {
+		restored, err := inst.RestoreSnapshots(r.Context())
+		if err == nil {
+			level.Info(i.logger).Log("msg", "head snapshots restored", "tenant", tenantID, "profiles", restored)
+		}
+		return err
+	}
+	// The snapshots are restored when the instance is opened.
+	_, err := i.GetOrCreateInstance(tenantID)
+	return err
+}
@@ -0,0 +1,54 @@
+// Package ingeststorage implements the Kafka-backed ingestion path:
+// distributors publish write requests to the partitions of a topic, and
+// ingesters consume them, instead of receiving them over RPC.
+package ingeststorage
+
+import (
+	"errors"
+	"flag"
+	"time"
+)
+
+type Config struct {
+	Enabled bool        `yaml:"enabled"`
+	Kafka   KafkaConfig `yaml:"kafka"`
+}
+
+type KafkaConfig struct {
+	Address       string        `yaml:"address"`
+	Topic         string        `yaml:"topic"`
+	ClientID      string        `yaml:"client_id" category:"advanced"`
+	ConsumerGroup string        `yaml:"consumer_group"`
+	DialTimeout   time.Duration `yaml:"dial_timeout" category:"advanced"`
+	WriteTimeout  time.Duration `yaml:"write_timeout" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingest-storage.enabled", false, "Publish write requests to Kafka in the distributor, and consume them in the ingester, instead of pushing them to the ingesters directly.")
+	cfg.Kafka.RegisterFlagsWithPrefix("ingest-storage.kafka.", f)
+}
+
+func (cfg *KafkaConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&cfg.Address, prefix+"address", "localhost:9092", "Comma-separated list of the Kafka seed broker addresses.")
+	f.StringVar(&cfg.Topic, prefix+"topic", "pyroscope-ingest", "The Kafka topic the write requests are published to. Series are assigned to the topic partitions by their labels.")
+	f.StringVar(&cfg.ClientID, prefix+"client-id", "pyroscope", "The Kafka client ID.")
+	f.StringVar(&cfg.ConsumerGroup, prefix+"consumer-group", "pyroscope-ingester", "The Kafka consumer group the ingesters join to share the topic partitions.")
+	f.DurationVar(&cfg.DialTimeout, prefix+"dial-timeout", 2*time.Second, "Timeout for establishing a connection to a Kafka broker.")
+	f.DurationVar(&cfg.WriteTimeout, prefix+"write-timeout", 10*time.Second, "Timeout for a write request to be acknowledged by Kafka.")
+}
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Kafka.Address == "" {
+		return errors.New("the Kafka address is required when ingest storage is enabled")
+	}
+	if cfg.Kafka.Topic == "" {
+		return errors.New("the Kafka topic is required when ingest storage is enabled")
+	}
+	if cfg.Kafka.ConsumerGroup == "" {
+		return errors.New("the Kafka consumer group is required when ingest storage is enabled")
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package ingeststorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+type fakePusher struct {
+	mu       sync.Mutex
+	series   map[string][]string
+	failures int
+}
+
+func (p *fakePusher) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("ingester unavailable")
+	}
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range req.Msg.Series {
+		if s.Labels[0].Value == "invalid" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid series"))
+		}
+		p.series[tenantID] = append(p.series[tenantID], s.Labels[0].Value)
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func (p *fakePusher) received() map[string][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := make(map[string][]string, len(p.series))
+	for k, v := range p.series {
+		r[k] = append([]string(nil), v...)
+	}
+	return r
+}
+
+func newSeries(service string) *pushv1.RawProfileSeries {
+	return &pushv1.RawProfileSeries{
+		Labels:  []*typesv1.LabelPair{{Name: "service_name", Value: service}},
+		Samples: []*pushv1.RawSample{{RawProfile: []byte(service), ID: service}},
+	}
+}
+
+func Test_WriteRead(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "test"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	cfg := KafkaConfig{
+		Address:       strings.Join(cluster.ListenAddrs(), ","),
+		Topic:         "test",
+		ClientID:      "test",
+		ConsumerGroup: "test",
+		DialTimeout:   time.Second,
+		WriteTimeout:  5 * time.Second,
+	}
+	ctx := context.Background()
+
+	w := NewWriter(cfg, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, w))
+	defer func() { require.NoError(t, services.StopAndAwaitTerminated(ctx, w)) }()
+
+	require.NoError(t, w.WriteSync(ctx, "tenant-a", &pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{newSeries("foo"), newSeries("invalid"), newSeries("bar")},
+	}))
+	require.NoError(t, w.WriteSync(ctx, "tenant-b", &pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{newSeries("baz")},
+	}))
+
+	pusher := &fakePusher{series: make(map[string][]string), failures: 1}
+	r := NewReader(cfg, pusher, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, r))
+	defer func() { require.NoError(t, services.StopAndAwaitTerminated(ctx, r)) }()
+
+	require.Eventually(t, func() bool {
+		received := pusher.received()
+		return len(received["tenant-a"]) == 2 && len(received["tenant-b"]) == 1
+	}, 10*time.Second, 50*time.Millisecond)
+
+	received := pusher.received()
+	assert.ElementsMatch(t, []string{"foo", "bar"}, received["tenant-a"])
+	assert.Equal(t, []string{"baz"}, received["tenant-b"])
+}
@@ -0,0 +1,154 @@
+package ingeststorage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+type Pusher interface {
+	Push(context.Context, *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error)
+}
+
+// Reader consumes the write requests published by the distributors and
+// pushes them to the ingester. The reader joins the consumer group, so the
+// partitions are shared by all the ingesters. Offsets are committed only
+// after the records are pushed: in case of a failure, the records are
+// consumed again.
+type Reader struct {
+	services.Service
+
+	cfg    KafkaConfig
+	logger log.Logger
+	pusher Pusher
+	client *kgo.Client
+
+	records *prometheus.CounterVec
+	errors  *prometheus.CounterVec
+}
+
+func NewReader(cfg KafkaConfig, pusher Pusher, logger log.Logger, reg prometheus.Registerer) *Reader {
+	r := &Reader{
+		cfg:    cfg,
+		logger: logger,
+		pusher: pusher,
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "ingest_storage_reader_records_total",
+			Help:      "The number of records consumed from Kafka.",
+		}, []string{"tenant"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "ingest_storage_reader_records_discarded_total",
+			Help:      "The number of records consumed from Kafka that could not be pushed to the ingester.",
+		}, []string{"tenant"}),
+	}
+	if reg != nil {
+		reg.MustRegister(r.records, r.errors)
+	}
+	r.Service = services.NewBasicService(r.starting, r.running, r.stopping)
+	return r
+}
+
+func (r *Reader) starting(ctx context.Context) (err error) {
+	r.client, err = kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(r.cfg.Address, ",")...),
+		kgo.ClientID(r.cfg.ClientID),
+		kgo.DialTimeout(r.cfg.DialTimeout),
+		kgo.ConsumerGroup(r.cfg.ConsumerGroup),
+		kgo.ConsumeTopics(r.cfg.Topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.AutoCommitMarks(),
+		kgo.AllowAutoTopicCreation(),
+	)
+	return err
+}
+
+func (r *Reader) running(ctx context.Context) error {
+	for ctx.Err() == nil {
+		fetches := r.client.PollFetches(ctx)
+		if fetches.IsClientClosed() {
+			return nil
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if ctx.Err() == nil {
+				level.Warn(r.logger).Log("msg", "failed to fetch records", "topic", topic, "partition", partition, "err", err)
+			}
+		})
+		fetches.EachRecord(func(record *kgo.Record) {
+			if r.push(ctx, record) {
+				r.client.MarkCommitRecords(record)
+			}
+		})
+	}
+	return nil
+}
+
+func (r *Reader) stopping(_ error) error {
+	if r.client != nil {
+		if err := r.client.CommitMarkedOffsets(context.Background()); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to commit offsets", "err", err)
+		}
+		r.client.Close()
+	}
+	return nil
+}
+
+// push pushes the record to the ingester, retrying on transient errors.
+// It returns false if the reader is stopped before the record is handled.
+func (r *Reader) push(ctx context.Context, record *kgo.Record) bool {
+	var tenantID string
+	for _, h := range record.Headers {
+		if h.Key == tenantHeader {
+			tenantID = string(h.Value)
+		}
+	}
+	var req pushv1.PushRequest
+	if err := req.UnmarshalVT(record.Value); err != nil || tenantID == "" {
+		level.Error(r.logger).Log("msg", "discarding malformed record", "partition", record.Partition, "offset", record.Offset, "err", err)
+		r.errors.WithLabelValues(tenantID).Inc()
+		return true
+	}
+
+	pushCtx := tenant.InjectTenantID(ctx, tenantID)
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	})
+	for retries.Ongoing() {
+		_, err := r.pusher.Push(pushCtx, connect.NewRequest(&req))
+		if err == nil {
+			r.records.WithLabelValues(tenantID).Inc()
+			return true
+		}
+		if isRejection(err) {
+			// The record would be rejected again.
+			level.Warn(r.logger).Log("msg", "discarding rejected record", "tenant", tenantID, "partition", record.Partition, "offset", record.Offset, "err", err)
+			r.errors.WithLabelValues(tenantID).Inc()
+			return true
+		}
+		level.Warn(r.logger).Log("msg", "failed to push record, retrying", "tenant", tenantID, "partition", record.Partition, "offset", record.Offset, "err", err)
+		retries.Wait()
+	}
+	return false
+}
+
+func isRejection(err error) bool {
+	switch connect.CodeOf(err) {
+	case connect.CodeInvalidArgument, connect.CodeResourceExhausted:
+		return true
+	}
+	return validation.ReasonOf(err) != validation.Unknown
+}
@@ -0,0 +1,104 @@
+package ingeststorage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+// tenantHeader is the record header the tenant ID is stored in.
+const tenantHeader = "tenant"
+
+// Writer publishes write requests to Kafka. Each series is written as a
+// separate record keyed by the tenant and the series labels, so that all
+// the profiles of a series go to the same partition.
+type Writer struct {
+	services.Service
+
+	cfg    KafkaConfig
+	logger log.Logger
+	client *kgo.Client
+
+	records *prometheus.CounterVec
+	bytes   *prometheus.CounterVec
+	errors  *prometheus.CounterVec
+}
+
+func NewWriter(cfg KafkaConfig, logger log.Logger, reg prometheus.Registerer) *Writer {
+	w := &Writer{
+		cfg:    cfg,
+		logger: logger,
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "ingest_storage_writer_records_total",
+			Help:      "The number of records written to Kafka.",
+		}, []string{"tenant"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "ingest_storage_writer_bytes_total",
+			Help:      "The number of bytes written to Kafka.",
+		}, []string{"tenant"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "ingest_storage_writer_errors_total",
+			Help:      "The number of records that could not be written to Kafka.",
+		}, []string{"tenant"}),
+	}
+	if reg != nil {
+		reg.MustRegister(w.records, w.bytes, w.errors)
+	}
+	w.Service = services.NewIdleService(w.starting, w.stopping)
+	return w
+}
+
+func (w *Writer) starting(ctx context.Context) (err error) {
+	w.client, err = kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(w.cfg.Address, ",")...),
+		kgo.ClientID(w.cfg.ClientID),
+		kgo.DialTimeout(w.cfg.DialTimeout),
+		kgo.DefaultProduceTopic(w.cfg.Topic),
+		kgo.RecordDeliveryTimeout(w.cfg.WriteTimeout),
+		kgo.AllowAutoTopicCreation(),
+	)
+	return err
+}
+
+func (w *Writer) stopping(_ error) error {
+	if w.client != nil {
+		w.client.Close()
+	}
+	return nil
+}
+
+// WriteSync writes the request and waits until all the records are
+// acknowledged by Kafka.
+func (w *Writer) WriteSync(ctx context.Context, tenantID string, req *pushv1.PushRequest) error {
+	records := make([]*kgo.Record, 0, len(req.Series))
+	var size int
+	for _, series := range req.Series {
+		value, err := (&pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{series}}).MarshalVT()
+		if err != nil {
+			return err
+		}
+		size += len(value)
+		records = append(records, &kgo.Record{
+			Key:     []byte(tenantID + phlaremodel.LabelPairsString(series.Labels)),
+			Value:   value,
+			Headers: []kgo.RecordHeader{{Key: tenantHeader, Value: []byte(tenantID)}},
+		})
+	}
+	if err := w.client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		w.errors.WithLabelValues(tenantID).Add(float64(len(records)))
+		return err
+	}
+	w.records.WithLabelValues(tenantID).Add(float64(len(records)))
+	w.bytes.WithLabelValues(tenantID).Add(float64(size))
+	return nil
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"sort"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+// NewCallersCallees returns the direct callers and callees of the function
+// in the tree. The value of a stack is accounted once to each distinct
+// caller and callee of the function, recursive calls included.
+func NewCallersCallees(t *Tree, function string) *querierv1.SelectCallersCalleesResponse {
+	res := new(querierv1.SelectCallersCalleesResponse)
+	if t == nil {
+		return res
+	}
+	callers := make(map[string]int64)
+	callees := make(map[string]int64)
+	seen := make(map[string]struct{})
+	t.IterateStacks(func(_ string, self int64, stack []string) {
+		// The stack is passed from the leaf to the root.
+		if len(stack) > 0 && stack[0] == function {
+			res.Self += self
+		}
+		found := false
+		for k := range seen {
+			delete(seen, k)
+		}
+		for i, name := range stack {
+			if name != function {
+				continue
+			}
+			found = true
+			if i+1 < len(stack) {
+				if _, ok := seen[stack[i+1]]; !ok {
+					seen[stack[i+1]] = struct{}{}
+					callers[stack[i+1]] += self
+				}
+			}
+		}
+		if !found {
+			return
+		}
+		res.Total += self
+		for k := range seen {
+			delete(seen, k)
+		}
+		for i := 1; i < len(stack); i++ {
+			if stack[i] != function {
+				continue
+			}
+			if _, ok := seen[stack[i-1]]; !ok {
+				seen[stack[i-1]] = struct{}{}
+				callees[stack[i-1]] += self
+			}
+		}
+	})
+	res.Callers = callEdges(callers)
+	res.Callees = callEdges(callees)
+	return res
+}
+
+// FunctionValues returns the self and total value of the function in the
+// tree. The value of a stack is accounted once to the total.
+func FunctionValues(t *Tree, function string) (self, total int64) {
+	if t == nil {
+		return 0, 0
+	}
+	t.IterateStacks(func(_ string, v int64, stack []string) {
+		for i, name := range stack {
+			if name != function {
+				continue
+			}
+			if i == 0 {
+				self += v
+			}
+			total += v
+			return
+		}
+	})
+	return self, total
+}
+
+func callEdges(m map[string]int64) []*querierv1.CallEdge {
+	edges := make([]*querierv1.CallEdge, 0, len(m))
+	for name, v := range m {
+		edges = append(edges, &querierv1.CallEdge{Name: name, Value: v})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Value != edges[j].Value {
+			return edges[i].Value > edges[j].Value
+		}
+		return edges[i].Name < edges[j].Name
+	})
+	return edges
+}
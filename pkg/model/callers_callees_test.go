@@ -0,0 +1,43 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+func Test_NewCallersCallees(t *testing.T) {
+	tree := newTree([]stacktraces{
+		{locations: []string{"c", "b", "a"}, value: 3},
+		{locations: []string{"b", "a"}, value: 1},
+		{locations: []string{"d", "b", "x"}, value: 2},
+		// Recursive call.
+		{locations: []string{"c", "b", "b", "a"}, value: 4},
+		{locations: []string{"e", "a"}, value: 5},
+	})
+
+	res := NewCallersCallees(tree, "b")
+	require.Equal(t, int64(1), res.Self)
+	require.Equal(t, int64(10), res.Total)
+	require.Equal(t, []*querierv1.CallEdge{
+		{Name: "a", Value: 8},
+		{Name: "b", Value: 4},
+		{Name: "x", Value: 2},
+	}, res.Callers)
+	require.Equal(t, []*querierv1.CallEdge{
+		{Name: "c", Value: 7},
+		{Name: "b", Value: 4},
+		{Name: "d", Value: 2},
+	}, res.Callees)
+
+	self, total := FunctionValues(tree, "b")
+	require.Equal(t, res.Self, self)
+	require.Equal(t, res.Total, total)
+
+	res = NewCallersCallees(tree, "missing")
+	require.Zero(t, res.Total)
+	require.Empty(t, res.Callers)
+	require.Empty(t, res.Callees)
+}
@@ -119,6 +119,59 @@ func NewFlameGraph(t *Tree, maxNodes int64) *querierv1.FlameGraph {
 	}
 }
 
+// FlameGraphChunkNodes is the number of nodes of a flamegraph sent in each
+// message of a stream.
+const FlameGraphChunkNodes = 16 << 10
+
+// SplitSelectMergeStacktracesResponse splits the response into chunks of
+// whole flamegraph levels, of at most maxNodes nodes each, unless a single
+// level is larger. The flamegraph is restored by appending the names and
+// the levels of the chunks in order: each chunk only carries the names its
+// levels refer to first, and the names are renumbered in that order. The
+// other fields of the response are set in the first chunk.
+func SplitSelectMergeStacktracesResponse(res *querierv1.SelectMergeStacktracesResponse, maxNodes int) []*querierv1.SelectMergeStacktracesResponse {
+	first := &querierv1.SelectMergeStacktracesResponse{
+		Partial: res.Partial,
+		Plan:    res.Plan,
+	}
+	fg := res.Flamegraph
+	if fg == nil {
+		return []*querierv1.SelectMergeStacktracesResponse{first}
+	}
+	first.Flamegraph = &querierv1.FlameGraph{
+		Total:   fg.Total,
+		MaxSelf: fg.MaxSelf,
+	}
+	chunks := []*querierv1.SelectMergeStacktracesResponse{first}
+	chunk := first.Flamegraph
+	// The new index of each name, plus one: zero if it is not sent yet.
+	names := make([]int64, len(fg.Names))
+	var sent int64
+	var nodes int
+	for _, level := range fg.Levels {
+		n := len(level.Values) / 4
+		if nodes > 0 && nodes+n > maxNodes {
+			chunk = &querierv1.FlameGraph{}
+			chunks = append(chunks, &querierv1.SelectMergeStacktracesResponse{Flamegraph: chunk})
+			nodes = 0
+		}
+		values := make([]int64, len(level.Values))
+		copy(values, level.Values)
+		for i := 3; i < len(values); i += 4 {
+			name := values[i]
+			if names[name] == 0 {
+				sent++
+				names[name] = sent
+				chunk.Names = append(chunk.Names, fg.Names[name])
+			}
+			values[i] = names[name] - 1
+		}
+		chunk.Levels = append(chunk.Levels, &querierv1.Level{Values: values})
+		nodes += n
+	}
+	return chunks
+}
+
 // ExportToFlamebearer exports the flamegraph to a Flamebearer struct.
 func ExportToFlamebearer(fg *querierv1.FlameGraph, profileType *typesv1.ProfileType) *flamebearer.FlamebearerProfile {
 	if fg == nil {
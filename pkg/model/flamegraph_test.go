@@ -81,6 +81,51 @@ func Test_ExportToFlamebearer(t *testing.T) {
 	})
 }
 
+func Test_SplitSelectMergeStacktracesResponse(t *testing.T) {
+	fg := NewFlameGraph(newTree([]stacktraces{
+		{locations: []string{"e", "b", "a"}, value: 1},
+		{locations: []string{"c", "a"}, value: 2},
+		{locations: []string{"d", "c", "a"}, value: 1},
+	}), -1)
+	require.Equal(t, []string{"total", "a", "c", "d", "b", "e"}, fg.Names)
+
+	chunks := SplitSelectMergeStacktracesResponse(&querierv1.SelectMergeStacktracesResponse{
+		Flamegraph: fg,
+		Partial:    true,
+	}, 2)
+	require.Equal(t, []*querierv1.SelectMergeStacktracesResponse{
+		{
+			Flamegraph: &querierv1.FlameGraph{
+				Names: []string{"total", "a"},
+				Levels: []*querierv1.Level{
+					{Values: []int64{0, 4, 0, 0}},
+					{Values: []int64{0, 4, 0, 1}},
+				},
+				Total:   4,
+				MaxSelf: 2,
+			},
+			Partial: true,
+		},
+		{
+			Flamegraph: &querierv1.FlameGraph{
+				Names:  []string{"b", "c"},
+				Levels: []*querierv1.Level{{Values: []int64{0, 1, 0, 2, 0, 3, 2, 3}}},
+			},
+		},
+		{
+			Flamegraph: &querierv1.FlameGraph{
+				Names:  []string{"e", "d"},
+				Levels: []*querierv1.Level{{Values: []int64{0, 1, 1, 4, 2, 1, 1, 5}}},
+			},
+		},
+	}, chunks)
+
+	t.Run("no flamegraph", func(t *testing.T) {
+		require.Equal(t, []*querierv1.SelectMergeStacktracesResponse{{Plan: "plan"}},
+			SplitSelectMergeStacktracesResponse(&querierv1.SelectMergeStacktracesResponse{Plan: "plan"}, 2))
+	})
+}
+
 var f *querierv1.FlameGraph
 
 func BenchmarkFlamegraph(b *testing.B) {
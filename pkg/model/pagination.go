@@ -0,0 +1,54 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// PaginateStrings returns the page of the sorted values following the
+// page token, at most limit values if limit is positive, and the token of
+// the next page. The token is empty if there are no values left.
+func PaginateStrings(values []string, limit int64, pageToken string) ([]string, string, error) {
+	if pageToken != "" {
+		after, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid page token: %w", err)
+		}
+		values = values[sort.SearchStrings(values, string(after)+"\x00"):]
+	}
+	if limit <= 0 || int64(len(values)) <= limit {
+		return values, "", nil
+	}
+	values = values[:limit]
+	return values, base64.RawURLEncoding.EncodeToString([]byte(values[len(values)-1])), nil
+}
+
+// PaginateLabelsSet is PaginateStrings for label sets sorted with
+// CompareLabelPairs.
+func PaginateLabelsSet(sets []*typesv1.Labels, limit int64, pageToken string) ([]*typesv1.Labels, string, error) {
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid page token: %w", err)
+		}
+		var after typesv1.Labels
+		if err = after.UnmarshalVT(b); err != nil {
+			return nil, "", fmt.Errorf("invalid page token: %w", err)
+		}
+		sets = sets[sort.Search(len(sets), func(i int) bool {
+			return CompareLabelPairs(sets[i].Labels, after.Labels) > 0
+		}):]
+	}
+	if limit <= 0 || int64(len(sets)) <= limit {
+		return sets, "", nil
+	}
+	sets = sets[:limit]
+	b, err := sets[len(sets)-1].MarshalVT()
+	if err != nil {
+		return nil, "", err
+	}
+	return sets, base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_PaginateStrings(t *testing.T) {
+	values := []string{"a", "b", "c", "d", "e"}
+
+	page, token, err := PaginateStrings(values, 2, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, page)
+	require.NotEmpty(t, token)
+
+	page, token, err = PaginateStrings(values, 2, token)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "d"}, page)
+
+	page, token, err = PaginateStrings(values, 2, token)
+	require.NoError(t, err)
+	require.Equal(t, []string{"e"}, page)
+	require.Empty(t, token)
+
+	page, token, err = PaginateStrings(values, 0, "")
+	require.NoError(t, err)
+	require.Equal(t, values, page)
+	require.Empty(t, token)
+
+	_, _, err = PaginateStrings(values, 2, "not a token!")
+	require.Error(t, err)
+}
+
+func Test_PaginateLabelsSet(t *testing.T) {
+	sets := []*typesv1.Labels{
+		{Labels: LabelsFromStrings("app", "a")},
+		{Labels: LabelsFromStrings("app", "b")},
+		{Labels: LabelsFromStrings("app", "b", "pod", "1")},
+	}
+
+	page, token, err := PaginateLabelsSet(sets, 2, "")
+	require.NoError(t, err)
+	require.Equal(t, sets[:2], page)
+	require.NotEmpty(t, token)
+
+	page, token, err = PaginateLabelsSet(sets, 2, token)
+	require.NoError(t, err)
+	require.Equal(t, sets[2:], page)
+	require.Empty(t, token)
+}
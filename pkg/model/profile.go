@@ -24,6 +24,12 @@ func CompareProfile(a, b *ingestv1.Profile) int64 {
 }
 
 // ParseProfileTypeSelector parses the profile selector string.
+//
+// Each component of the selector may be a pattern rather than a value:
+// "*" matches any value, "~re" matches the values matching the regular
+// expression, "!value" and "!~re" match the values that do not match.
+// For example, "~.*_cpu:*:nanoseconds:*:*" selects the CPU profiles of
+// all runtimes.
 func ParseProfileTypeSelector(id string) (*typesv1.ProfileType, error) {
 	parts := strings.Split(id, ":")
 
@@ -31,6 +37,11 @@ func ParseProfileTypeSelector(id string) (*typesv1.ProfileType, error) {
 		return nil, status.Errorf(codes.InvalidArgument, "profile-type selection must be of the form <name>:<sample-type>:<sample-unit>:<period-type>:<period-unit>(:delta), got(%d): %q", len(parts), id)
 	}
 	name, sampleType, sampleUnit, periodType, periodUnit := parts[0], parts[1], parts[2], parts[3], parts[4]
+	for _, part := range parts[:5] {
+		if _, err := profileTypeComponentMatcher("", part); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid profile-type selection %q: %v", id, err)
+		}
+	}
 	return &typesv1.ProfileType{
 		Name:       name,
 		ID:         id,
@@ -50,6 +61,56 @@ func SelectorFromProfileType(profileType *typesv1.ProfileType) *labels.Matcher {
 	}
 }
 
+// SelectorsFromProfileType builds the matchers of the profile type
+// selector. If none of the components is a pattern, the profile type is
+// matched with SelectorFromProfileType, otherwise each component is
+// matched against its label.
+func SelectorsFromProfileType(profileType *typesv1.ProfileType) ([]*labels.Matcher, error) {
+	components := [...]struct{ name, value string }{
+		{LabelNameProfileName, profileType.Name},
+		{LabelNameType, profileType.SampleType},
+		{LabelNameUnit, profileType.SampleUnit},
+		{LabelNamePeriodType, profileType.PeriodType},
+		{LabelNamePeriodUnit, profileType.PeriodUnit},
+	}
+	matchers := make([]*labels.Matcher, 0, len(components))
+	exact := true
+	for _, c := range components {
+		m, err := profileTypeComponentMatcher(c.name, c.value)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			exact = false
+			continue
+		}
+		if m.Type != labels.MatchEqual {
+			exact = false
+		}
+		matchers = append(matchers, m)
+	}
+	if exact {
+		return []*labels.Matcher{SelectorFromProfileType(profileType)}, nil
+	}
+	return matchers, nil
+}
+
+// profileTypeComponentMatcher returns the matcher of a profile type
+// component, or nil if the component matches any value.
+func profileTypeComponentMatcher(name, value string) (*labels.Matcher, error) {
+	switch {
+	case value == "*":
+		return nil, nil
+	case strings.HasPrefix(value, "!~"):
+		return labels.NewMatcher(labels.MatchNotRegexp, name, value[2:])
+	case strings.HasPrefix(value, "~"):
+		return labels.NewMatcher(labels.MatchRegexp, name, value[1:])
+	case strings.HasPrefix(value, "!"):
+		return labels.NewMatcher(labels.MatchNotEqual, name, value[1:])
+	}
+	return labels.NewMatcher(labels.MatchEqual, name, value)
+}
+
 // SetProfileMetadata sets the metadata on the profile.
 func SetProfileMetadata(p *profile.Profile, ty *typesv1.ProfileType) {
 	p.SampleType = []*profile.ValueType{{Type: ty.SampleType, Unit: ty.SampleUnit}}
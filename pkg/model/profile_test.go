@@ -3,7 +3,9 @@ package model
 import (
 	"testing"
 
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_filename_extraction(t *testing.T) {
@@ -34,3 +36,26 @@ func Test_filename_extraction(t *testing.T) {
 	assert.Equal(t, "", extractMappingFilename(`[vsyscall]`))
 	assert.Equal(t, "not a path actually", extractMappingFilename(`not a path actually`))
 }
+
+func Test_SelectorsFromProfileType(t *testing.T) {
+	pt, err := ParseProfileTypeSelector("process_cpu:cpu:nanoseconds:cpu:nanoseconds")
+	require.NoError(t, err)
+	matchers, err := SelectorsFromProfileType(pt)
+	require.NoError(t, err)
+	assert.Equal(t, []*labels.Matcher{SelectorFromProfileType(pt)}, matchers)
+
+	pt, err = ParseProfileTypeSelector("~.*_cpu:*:!~micro.*:cpu:!seconds")
+	require.NoError(t, err)
+	assert.Equal(t, "~.*_cpu", pt.Name)
+	matchers, err = SelectorsFromProfileType(pt)
+	require.NoError(t, err)
+	assert.Equal(t, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, LabelNameProfileName, ".*_cpu"),
+		labels.MustNewMatcher(labels.MatchNotRegexp, LabelNameUnit, "micro.*"),
+		labels.MustNewMatcher(labels.MatchEqual, LabelNamePeriodType, "cpu"),
+		labels.MustNewMatcher(labels.MatchNotEqual, LabelNamePeriodUnit, "seconds"),
+	}, matchers)
+
+	_, err = ParseProfileTypeSelector("~(:cpu:nanoseconds:cpu:nanoseconds")
+	assert.Error(t, err)
+}
@@ -1,9 +1,14 @@
 package model
 
 import (
+	"errors"
+	"math"
 	"sort"
+	"strconv"
 	"sync"
 
+	pmodel "github.com/prometheus/common/model"
+
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 )
 
@@ -82,3 +87,59 @@ func (m *SeriesMerger) mergePoints(points []*typesv1.Point) int {
 	}
 	return j + 1
 }
+
+// ValidateHeatmapBuckets checks that the upper bounds of the heatmap buckets
+// are given in increasing order.
+func ValidateHeatmapBuckets(buckets []float64) error {
+	for i, b := range buckets {
+		if math.IsNaN(b) {
+			return errors.New("heatmap bucket upper bound must be a number")
+		}
+		if i > 0 && b <= buckets[i-1] {
+			return errors.New("heatmap bucket upper bounds must be in increasing order")
+		}
+	}
+	return nil
+}
+
+// HeatmapSeries turns series of profile total values, with a point per
+// profile, into a heatmap. Each profile is counted in the first bucket with
+// an upper bound greater than or equal to its total value; the +Inf bucket
+// is added if the buckets do not end with it. Each series is split into a
+// series per non-empty bucket, with the le label set to its upper bound,
+// and points counting the profiles of each timestamp.
+func HeatmapSeries(series []*typesv1.Series, buckets []float64) []*typesv1.Series {
+	if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = append(buckets[:len(buckets):len(buckets)], math.Inf(1))
+	}
+	result := make([]*typesv1.Series, 0, len(series))
+	for _, s := range series {
+		bucketSeries := make([]*typesv1.Series, len(buckets))
+		for _, p := range s.Points {
+			i := sort.SearchFloat64s(buckets, p.Value)
+			b := bucketSeries[i]
+			if b == nil {
+				b = &typesv1.Series{Labels: heatmapBucketLabels(s.Labels, buckets[i])}
+				bucketSeries[i] = b
+				result = append(result, b)
+			}
+			if n := len(b.Points); n > 0 && b.Points[n-1].Timestamp == p.Timestamp {
+				b.Points[n-1].Value++
+				continue
+			}
+			b.Points = append(b.Points, &typesv1.Point{Timestamp: p.Timestamp, Value: 1})
+		}
+	}
+	return result
+}
+
+func heatmapBucketLabels(ls Labels, upperBound float64) Labels {
+	bucket := make(Labels, 0, len(ls)+1)
+	bucket = append(bucket, ls...)
+	bucket = append(bucket, &typesv1.LabelPair{
+		Name:  pmodel.BucketLabel,
+		Value: strconv.FormatFloat(upperBound, 'f', -1, 64),
+	})
+	sort.Sort(bucket)
+	return bucket
+}
@@ -1,8 +1,11 @@
 package model
 
 import (
+	"math"
 	"testing"
 
+	"github.com/stretchr/testify/require"
+
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/testhelper"
 )
@@ -89,3 +92,49 @@ func Test_SeriesMerger_Overlap(t *testing.T) {
 		})
 	}
 }
+
+func Test_HeatmapSeries(t *testing.T) {
+	in := []*typesv1.Series{
+		{
+			Labels: LabelsFromStrings("service_name", "foo"),
+			Points: []*typesv1.Point{
+				{Timestamp: 1, Value: 10},
+				{Timestamp: 1, Value: 100},
+				{Timestamp: 1, Value: 5},
+				{Timestamp: 2, Value: 1000},
+				{Timestamp: 2, Value: 100},
+			},
+		},
+		{
+			Labels: LabelsFromStrings("service_name", "bar"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 50}},
+		},
+	}
+	expected := []*typesv1.Series{
+		{
+			Labels: LabelsFromStrings("le", "10", "service_name", "foo"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 2}},
+		},
+		{
+			Labels: LabelsFromStrings("le", "100", "service_name", "foo"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 1}},
+		},
+		{
+			Labels: LabelsFromStrings("le", "+Inf", "service_name", "foo"),
+			Points: []*typesv1.Point{{Timestamp: 2, Value: 1}},
+		},
+		{
+			Labels: LabelsFromStrings("le", "100", "service_name", "bar"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 1}},
+		},
+	}
+	testhelper.EqualProto(t, expected, HeatmapSeries(in, []float64{10, 100}))
+}
+
+func Test_ValidateHeatmapBuckets(t *testing.T) {
+	require.NoError(t, ValidateHeatmapBuckets(nil))
+	require.NoError(t, ValidateHeatmapBuckets([]float64{0.5, 10, math.Inf(1)}))
+	require.Error(t, ValidateHeatmapBuckets([]float64{10, 10}))
+	require.Error(t, ValidateHeatmapBuckets([]float64{10, 1}))
+	require.Error(t, ValidateHeatmapBuckets([]float64{math.NaN()}))
+}
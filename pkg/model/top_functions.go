@@ -0,0 +1,106 @@
+package model
+
+import (
+	"sort"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+const DefaultTopFunctionsLimit = 100
+
+type functionKey struct {
+	name, filename string
+	startLine      int64
+}
+
+// NewTopFunctions returns the limit functions of the profile with the
+// largest self or total value. The value of a sample is accounted once to
+// the total of each function of its stack, recursive calls included.
+func NewTopFunctions(p *profilev1.Profile, orderBy querierv1.TopFunctionsOrder, limit int) *querierv1.SelectTopFunctionsResponse {
+	res := new(querierv1.SelectTopFunctionsResponse)
+	if p == nil {
+		return res
+	}
+	functions := make(map[uint64]*profilev1.Function, len(p.Function))
+	for _, fn := range p.Function {
+		functions[fn.Id] = fn
+	}
+	locations := make(map[uint64]*profilev1.Location, len(p.Location))
+	for _, loc := range p.Location {
+		locations[loc.Id] = loc
+	}
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(p.StringTable)) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+
+	byKey := make(map[functionKey]*querierv1.TopFunction)
+	lookup := func(id uint64) *querierv1.TopFunction {
+		fn, ok := functions[id]
+		if !ok {
+			return nil
+		}
+		k := functionKey{name: str(fn.Name), filename: str(fn.Filename), startLine: fn.StartLine}
+		f, ok := byKey[k]
+		if !ok {
+			f = &querierv1.TopFunction{Name: k.name, Filename: k.filename, StartLine: k.startLine}
+			byKey[k] = f
+		}
+		return f
+	}
+
+	seen := make(map[*querierv1.TopFunction]struct{})
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] == 0 {
+			continue
+		}
+		v := s.Value[0]
+		res.Total += v
+		for k := range seen {
+			delete(seen, k)
+		}
+		for i, id := range s.LocationId {
+			loc, ok := locations[id]
+			if !ok {
+				continue
+			}
+			// The first line of the location is the innermost inlined call.
+			for j, line := range loc.Line {
+				f := lookup(line.FunctionId)
+				if f == nil {
+					continue
+				}
+				if i == 0 && j == 0 {
+					f.Self += v
+				}
+				if _, ok := seen[f]; !ok {
+					seen[f] = struct{}{}
+					f.Total += v
+				}
+			}
+		}
+	}
+
+	res.Functions = make([]*querierv1.TopFunction, 0, len(byKey))
+	for _, f := range byKey {
+		res.Functions = append(res.Functions, f)
+	}
+	value := func(f *querierv1.TopFunction) int64 { return f.Self }
+	if orderBy == querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_TOTAL {
+		value = func(f *querierv1.TopFunction) int64 { return f.Total }
+	}
+	sort.Slice(res.Functions, func(i, j int) bool {
+		a, b := res.Functions[i], res.Functions[j]
+		if value(a) != value(b) {
+			return value(a) > value(b)
+		}
+		return a.Name < b.Name
+	})
+	if limit > 0 && len(res.Functions) > limit {
+		res.Functions = res.Functions[:limit]
+	}
+	return res
+}
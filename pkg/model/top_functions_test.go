@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+func Test_NewTopFunctions(t *testing.T) {
+	p := &profilev1.Profile{
+		StringTable: []string{"", "main", "main.go", "work", "work.go", "inlined"},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 1, Filename: 2, StartLine: 10},
+			{Id: 2, Name: 3, Filename: 4, StartLine: 20},
+			{Id: 3, Name: 5, Filename: 4, StartLine: 30},
+		},
+		Location: []*profilev1.Location{
+			{Id: 1, Line: []*profilev1.Line{{FunctionId: 1}}},
+			{Id: 2, Line: []*profilev1.Line{{FunctionId: 2}}},
+			{Id: 3, Line: []*profilev1.Line{{FunctionId: 3}, {FunctionId: 2}}},
+		},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{2, 1}, Value: []int64{5}},
+			// Recursive call.
+			{LocationId: []uint64{2, 2, 1}, Value: []int64{1}},
+			{LocationId: []uint64{3, 1}, Value: []int64{3}},
+			{LocationId: []uint64{1}, Value: []int64{2}},
+		},
+	}
+
+	res := NewTopFunctions(p, querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_SELF, 0)
+	require.Equal(t, int64(11), res.Total)
+	require.Equal(t, []*querierv1.TopFunction{
+		{Name: "work", Filename: "work.go", StartLine: 20, Self: 6, Total: 9},
+		{Name: "inlined", Filename: "work.go", StartLine: 30, Self: 3, Total: 3},
+		{Name: "main", Filename: "main.go", StartLine: 10, Self: 2, Total: 11},
+	}, res.Functions)
+
+	res = NewTopFunctions(p, querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_TOTAL, 2)
+	require.Equal(t, []*querierv1.TopFunction{
+		{Name: "main", Filename: "main.go", StartLine: 10, Self: 2, Total: 11},
+		{Name: "work", Filename: "work.go", StartLine: 20, Self: 6, Total: 9},
+	}, res.Functions)
+}
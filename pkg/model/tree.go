@@ -4,6 +4,7 @@ import (
 	"container/heap"
 	"fmt"
 	"io"
+	"math"
 	"sort"
 	"strings"
 	"sync"
@@ -137,6 +138,29 @@ func (t *Tree) Merge(src *Tree) {
 	t.root = dstRoot.children
 }
 
+// Scale multiplies the values of the tree by the factor. The totals are
+// recomputed from the scaled self values, so that they remain consistent
+// despite rounding.
+func (t *Tree) Scale(f float64) {
+	nodes := make([]*node, 0, defaultDFSSize)
+	stack := append(make([]*node, 0, defaultDFSSize), t.root...)
+	var n *node
+	for len(stack) > 0 {
+		n, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		n.self = int64(math.Round(float64(n.self) * f))
+		nodes = append(nodes, n)
+		stack = append(stack, n.children...)
+	}
+	// Children always follow their parents.
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n = nodes[i]
+		n.total = n.self
+		for _, c := range n.children {
+			n.total += c.total
+		}
+	}
+}
+
 func (t *Tree) FormatNodeNames(fn func(string) string) {
 	nodes := make([]*node, 0, defaultDFSSize)
 	nodes = append(nodes, &node{children: t.root})
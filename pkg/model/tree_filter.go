@@ -0,0 +1,108 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/slices"
+)
+
+// TreeFilter describes the focus, hide, and depth operations applied to
+// the stacks of a tree. The zero value keeps the tree as is.
+type TreeFilter struct {
+	// FocusFunction only keeps the stacks calling the function, re-rooted
+	// at its outermost call.
+	FocusFunction string
+	// HideFunctions removes the matching functions from the stacks. The
+	// stacks made only of hidden functions are dropped.
+	HideFunctions *regexp.Regexp
+	// MaxDepth truncates the stacks deeper than MaxDepth: the value of the
+	// truncated frames is accounted to the self value of their ancestor.
+	MaxDepth int
+	// ExcludeStacks drops the stacks containing a matching function. It is
+	// applied before the other operations.
+	ExcludeStacks *regexp.Regexp
+}
+
+// NewTreeFilter returns the filter of the focus, hide, max depth and
+// exclude stacks options of the request.
+func NewTreeFilter(req *querierv1.SelectMergeStacktracesRequest) (TreeFilter, error) {
+	f := TreeFilter{
+		FocusFunction: req.GetFocusFunction(),
+		MaxDepth:      int(req.GetMaxDepth()),
+	}
+	if req.GetMaxDepth() < 0 {
+		return f, errors.New("max depth must not be negative")
+	}
+	if hide := req.GetHideFunctions(); hide != "" {
+		re, err := regexp.Compile(hide)
+		if err != nil {
+			return f, fmt.Errorf("invalid hide functions regular expression: %w", err)
+		}
+		f.HideFunctions = re
+	}
+	if exclude := req.GetExcludeStacks(); exclude != "" {
+		re, err := regexp.Compile(exclude)
+		if err != nil {
+			return f, fmt.Errorf("invalid exclude stacks regular expression: %w", err)
+		}
+		f.ExcludeStacks = re
+	}
+	return f, nil
+}
+
+func (f TreeFilter) IsEmpty() bool {
+	return f.FocusFunction == "" && f.HideFunctions == nil && f.MaxDepth <= 0 && f.ExcludeStacks == nil
+}
+
+// Apply returns the tree with the filter applied. The tree is returned as
+// is if the filter is empty.
+func (f TreeFilter) Apply(t *Tree) *Tree {
+	if t == nil || f.IsEmpty() {
+		return t
+	}
+	res := new(Tree)
+	stack := make([]string, 0, 64)
+	t.IterateStacks(func(_ string, self int64, s []string) {
+		// The stack is passed from the leaf to the root.
+		stack = append(stack[:0], s...)
+		slices.Reverse(stack)
+		if stack = f.filter(stack); len(stack) > 0 {
+			res.InsertStack(self, stack...)
+		}
+	})
+	return res
+}
+
+func (f TreeFilter) filter(stack []string) []string {
+	if f.ExcludeStacks != nil {
+		for _, name := range stack {
+			if f.ExcludeStacks.MatchString(name) {
+				return nil
+			}
+		}
+	}
+	if f.FocusFunction != "" {
+		i := 0
+		for i < len(stack) && stack[i] != f.FocusFunction {
+			i++
+		}
+		stack = stack[i:]
+	}
+	if f.HideFunctions != nil {
+		j := 0
+		for _, name := range stack {
+			if !f.HideFunctions.MatchString(name) {
+				stack[j] = name
+				j++
+			}
+		}
+		stack = stack[:j]
+	}
+	if f.MaxDepth > 0 && len(stack) > f.MaxDepth {
+		stack = stack[:f.MaxDepth]
+	}
+	return stack
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"fmt"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// TreeFromProfile returns the tree of the values of a pprof profile, for
+// the sample type and unit of the profile type. Inlined functions are
+// part of the stacks, as they are when profiles are ingested.
+func TreeFromProfile(p *profilev1.Profile, profileType *typesv1.ProfileType) (*Tree, error) {
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(p.StringTable)) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+	idx := -1
+	for i, st := range p.SampleType {
+		if str(st.Type) == profileType.SampleType && str(st.Unit) == profileType.SampleUnit {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("the profile has no samples of type %s and unit %s", profileType.SampleType, profileType.SampleUnit)
+	}
+
+	functions := make(map[uint64]string, len(p.Function))
+	for _, fn := range p.Function {
+		functions[fn.Id] = str(fn.Name)
+	}
+	locations := make(map[uint64]*profilev1.Location, len(p.Location))
+	for _, loc := range p.Location {
+		locations[loc.Id] = loc
+	}
+	t := new(Tree)
+	stack := make([]string, 0, 64)
+	for _, s := range p.Sample {
+		if idx >= len(s.Value) {
+			continue
+		}
+		// The stacks are inserted from the root, the locations and their
+		// lines start with the leaf.
+		stack = stack[:0]
+		for i := len(s.LocationId) - 1; i >= 0; i-- {
+			loc, ok := locations[s.LocationId[i]]
+			if !ok {
+				continue
+			}
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				stack = append(stack, functions[loc.Line[j].FunctionId])
+			}
+		}
+		t.InsertStack(s.Value[idx], stack...)
+	}
+	return t, nil
+}
@@ -3,10 +3,14 @@ package model
 import (
 	"bytes"
 	"math"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 )
 
 func Test_Tree(t *testing.T) {
@@ -279,6 +283,33 @@ func Test_Tree_MarshalUnmarshal(t *testing.T) {
 	})
 }
 
+func Test_TreeScale(t *testing.T) {
+	x := newTree([]stacktraces{
+		{locations: []string{"c", "b", "a"}, value: 3},
+		{locations: []string{"d", "b", "a"}, value: 5},
+		{locations: []string{"e", "a"}, value: 10},
+	})
+	x.Scale(0.5)
+	expected := newTree([]stacktraces{
+		{locations: []string{"c", "b", "a"}, value: 2},
+		{locations: []string{"d", "b", "a"}, value: 3},
+		{locations: []string{"e", "a"}, value: 5},
+	})
+	require.Equal(t, expected.String(), x.String())
+	require.Equal(t, int64(10), x.Total())
+}
+
+func Test_UnitConversionFactor(t *testing.T) {
+	f, ok := UnitConversionFactor("microseconds", "nanoseconds")
+	require.True(t, ok)
+	require.Equal(t, 1e3, f)
+	f, ok = UnitConversionFactor("bytes", "kilobytes")
+	require.True(t, ok)
+	require.Equal(t, 1.0/1024, f)
+	_, ok = UnitConversionFactor("count", "nanoseconds")
+	require.False(t, ok)
+}
+
 func Test_FormatNames(t *testing.T) {
 	x := newTree([]stacktraces{
 		{locations: []string{"c0", "b0", "a0"}, value: 3},
@@ -303,6 +334,74 @@ func Test_FormatNames(t *testing.T) {
 	require.Equal(t, expected.String(), x.String())
 }
 
+func Test_TreeFilter(t *testing.T) {
+	stacks := []stacktraces{
+		{locations: []string{"c0", "b0", "a0"}, value: 3},
+		{locations: []string{"c1", "b0", "a0"}, value: 3},
+		{locations: []string{"d0", "b1", "a0"}, value: 2},
+		{locations: []string{"b0", "e1", "a1"}, value: 4},
+		{locations: []string{"x0"}, value: 1},
+	}
+	for _, tc := range []struct {
+		name     string
+		filter   TreeFilter
+		expected []stacktraces
+	}{
+		{
+			name:     "empty",
+			expected: stacks,
+		},
+		{
+			name:   "focus",
+			filter: TreeFilter{FocusFunction: "b0"},
+			expected: []stacktraces{
+				{locations: []string{"c0", "b0"}, value: 3},
+				{locations: []string{"c1", "b0"}, value: 3},
+				{locations: []string{"b0"}, value: 4},
+			},
+		},
+		{
+			name:   "hide",
+			filter: TreeFilter{HideFunctions: regexp.MustCompile("^(b|x)")},
+			expected: []stacktraces{
+				{locations: []string{"c0", "a0"}, value: 3},
+				{locations: []string{"c1", "a0"}, value: 3},
+				{locations: []string{"d0", "a0"}, value: 2},
+				{locations: []string{"e1", "a1"}, value: 4},
+			},
+		},
+		{
+			name:   "exclude stacks",
+			filter: TreeFilter{ExcludeStacks: regexp.MustCompile("^(b0|x)")},
+			expected: []stacktraces{
+				{locations: []string{"d0", "b1", "a0"}, value: 2},
+			},
+		},
+		{
+			name:   "max depth",
+			filter: TreeFilter{MaxDepth: 2},
+			expected: []stacktraces{
+				{locations: []string{"b0", "a0"}, value: 6},
+				{locations: []string{"b1", "a0"}, value: 2},
+				{locations: []string{"e1", "a1"}, value: 4},
+				{locations: []string{"x0"}, value: 1},
+			},
+		},
+		{
+			name:   "focus and max depth",
+			filter: TreeFilter{FocusFunction: "a0", MaxDepth: 1},
+			expected: []stacktraces{
+				{locations: []string{"a0"}, value: 8},
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, newTree(tc.expected).String(), tc.filter.Apply(newTree(stacks)).String())
+		})
+	}
+}
+
 func emptyTree() *Tree {
 	return &Tree{}
 }
@@ -390,3 +489,38 @@ func stackToTree(stack stacktraces) *Tree {
 	t.root = []*node{current}
 	return t
 }
+
+func Test_TreeFromProfile(t *testing.T) {
+	p := &profilev1.Profile{
+		StringTable: []string{"", "samples", "count", "cpu", "nanoseconds", "a", "b", "c"},
+		SampleType: []*profilev1.ValueType{
+			{Type: 1, Unit: 2},
+			{Type: 3, Unit: 4},
+		},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 5},
+			{Id: 2, Name: 6},
+			{Id: 3, Name: 7},
+		},
+		Location: []*profilev1.Location{
+			// c is inlined into b.
+			{Id: 1, Line: []*profilev1.Line{{FunctionId: 3}, {FunctionId: 2}}},
+			{Id: 2, Line: []*profilev1.Line{{FunctionId: 1}}},
+		},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{1, 2}, Value: []int64{1, 10}},
+			{LocationId: []uint64{2}, Value: []int64{2, 20}},
+		},
+	}
+
+	tree, err := TreeFromProfile(p, &typesv1.ProfileType{SampleType: "cpu", SampleUnit: "nanoseconds"})
+	require.NoError(t, err)
+	expected := newTree([]stacktraces{
+		{locations: []string{"c", "b", "a"}, value: 10},
+		{locations: []string{"a"}, value: 20},
+	})
+	require.Equal(t, expected.String(), tree.String())
+
+	_, err = TreeFromProfile(p, &typesv1.ProfileType{SampleType: "alloc_space", SampleUnit: "bytes"})
+	require.Error(t, err)
+}
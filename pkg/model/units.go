@@ -0,0 +1,38 @@
+package model
+
+// sampleUnits maps the known sample units to their dimension, and to
+// their magnitude in the base unit of the dimension.
+var sampleUnits = map[string]struct {
+	dimension string
+	magnitude float64
+}{
+	"nanoseconds":  {"time", 1},
+	"microseconds": {"time", 1e3},
+	"milliseconds": {"time", 1e6},
+	"seconds":      {"time", 1e9},
+	"bytes":        {"memory", 1},
+	"kilobytes":    {"memory", 1 << 10},
+	"megabytes":    {"memory", 1 << 20},
+	"gigabytes":    {"memory", 1 << 30},
+	"count":        {"count", 1},
+}
+
+// UnitConversionFactor returns the factor converting the sample values
+// from one unit to another. The second return value is false if the
+// units are not known, or they measure different dimensions: for
+// example, sample counts cannot be converted to CPU time without
+// knowing the sampling period.
+func UnitConversionFactor(from, to string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	f, ok := sampleUnits[from]
+	if !ok {
+		return 0, false
+	}
+	t, ok := sampleUnits[to]
+	if !ok || f.dimension != t.dimension {
+		return 0, false
+	}
+	return f.magnitude / t.magnitude, true
+}
@@ -0,0 +1,158 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/grafana/dskit/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// cachingBucketTTL is the time the ranges are kept in a remote cache for.
+	// Objects read by ranges are immutable, the TTL only bounds the time
+	// ranges of deleted objects are kept.
+	cachingBucketTTL = 7 * 24 * time.Hour
+	// maxCachedRangeSize is the size of the largest range cached, in bytes.
+	// It matches the default memcached item size limit.
+	maxCachedRangeSize = 1 << 20
+)
+
+// CachingBucket caches the ranges of objects read with GetRange and
+// ReaderAt, keyed by object name, offset and length. It must only be used
+// for objects that never change once written, such as the files of blocks.
+type CachingBucket struct {
+	Bucket
+	cache rangeCache
+
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+// rangeCache stores the ranges of objects cached by a CachingBucket.
+type rangeCache interface {
+	fetch(ctx context.Context, key string) ([]byte, bool)
+	store(key string, data []byte)
+}
+
+// NewCachingBucket returns a bucket caching the ranges of objects read in
+// the cache, such as memcached.
+func NewCachingBucket(bkt Bucket, c cache.Cache, reg prometheus.Registerer) *CachingBucket {
+	return newCachingBucket(bkt, remoteRangeCache{c}, prometheus.WrapRegistererWith(prometheus.Labels{"cache": "remote"}, reg))
+}
+
+// NewDiskCachingBucket returns a bucket caching the ranges of objects read
+// on the local disk.
+func NewDiskCachingBucket(bkt Bucket, c *DiskCache, reg prometheus.Registerer) *CachingBucket {
+	return newCachingBucket(bkt, c, prometheus.WrapRegistererWith(prometheus.Labels{"cache": "disk"}, reg))
+}
+
+func newCachingBucket(bkt Bucket, c rangeCache, reg prometheus.Registerer) *CachingBucket {
+	return &CachingBucket{
+		Bucket: bkt,
+		cache:  c,
+		hits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_caching_bucket_hits_total",
+			Help: "Total number of object ranges found in the cache.",
+		}),
+		misses: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_caching_bucket_misses_total",
+			Help: "Total number of object ranges missing from the cache.",
+		}),
+	}
+}
+
+type remoteRangeCache struct{ cache.Cache }
+
+func (c remoteRangeCache) fetch(ctx context.Context, key string) ([]byte, bool) {
+	data, ok := c.Fetch(ctx, []string{key})[key]
+	return data, ok
+}
+
+func (c remoteRangeCache) store(key string, data []byte) {
+	c.StoreAsync(map[string][]byte{key: data}, cachingBucketTTL)
+}
+
+func (b *CachingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if length <= 0 || length > maxCachedRangeSize {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	}
+	key := rangeCacheKey(name, off, length)
+	if data, ok := b.fetch(ctx, key); ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) == length {
+		b.cache.store(key, data)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *CachingBucket) ReaderAt(ctx context.Context, name string) (ReaderAtCloser, error) {
+	r, err := b.Bucket.ReaderAt(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingReaderAt{ReaderAtCloser: r, bucket: b, ctx: ctx, name: name}, nil
+}
+
+func (b *CachingBucket) fetch(ctx context.Context, key string) ([]byte, bool) {
+	data, ok := b.cache.fetch(ctx, key)
+	if ok {
+		b.hits.Inc()
+	} else {
+		b.misses.Inc()
+	}
+	return data, ok
+}
+
+type cachingReaderAt struct {
+	ReaderAtCloser
+	bucket *CachingBucket
+	ctx    context.Context
+	name   string
+}
+
+func (r *cachingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 || len(p) > maxCachedRangeSize {
+		return r.ReaderAtCloser.ReadAt(p, off)
+	}
+	key := rangeCacheKey(r.name, off, int64(len(p)))
+	if data, ok := r.bucket.fetch(r.ctx, key); ok && len(data) == len(p) {
+		return copy(p, data), nil
+	}
+	n, err := r.ReaderAtCloser.ReadAt(p, off)
+	// Short reads, at the end of the object, are not cached.
+	if err == nil && n == len(p) {
+		data := make([]byte, n)
+		copy(data, p)
+		r.bucket.cache.store(key, data)
+	}
+	return n, err
+}
+
+// rangeCacheKey returns the key of a range of an object in the cache. The
+// name is hashed, so the key is valid regardless of its length.
+func rangeCacheKey(name string, off, length int64) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(name))
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(off))
+	binary.BigEndian.PutUint64(buf[8:], uint64(length))
+	_, _ = h.Write(buf[:])
+	return "cb:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package objstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/grafana/dskit/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+func Test_CachingBucket(t *testing.T) {
+	ctx := context.Background()
+	inMem := objstore.NewInMemBucket()
+	require.NoError(t, inMem.Upload(ctx, "block/data", bytes.NewReader([]byte("0123456789"))))
+	b := phlareobj.NewCachingBucket(phlareobj.NewBucket(inMem), cache.NewMockCache(), prometheus.NewRegistry())
+
+	rc, err := b.GetRange(ctx, "block/data", 2, 3)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "234", string(data))
+
+	ra, err := b.ReaderAt(ctx, "block/data")
+	require.NoError(t, err)
+	p := make([]byte, 4)
+	_, err = ra.ReadAt(p, 5)
+	require.NoError(t, err)
+	require.Equal(t, "5678", string(p))
+
+	// The ranges read are served from the cache once the object is gone.
+	require.NoError(t, inMem.Delete(ctx, "block/data"))
+	rc, err = b.GetRange(ctx, "block/data", 2, 3)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "234", string(data))
+
+	p = make([]byte, 4)
+	_, err = ra.ReadAt(p, 5)
+	require.NoError(t, err)
+	require.Equal(t, "5678", string(p))
+
+	// Other ranges are not.
+	_, err = b.GetRange(ctx, "block/data", 3, 3)
+	require.Error(t, err)
+}
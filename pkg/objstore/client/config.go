@@ -11,10 +11,13 @@ import (
 	"github.com/samber/lo"
 	"github.com/thanos-io/objstore"
 
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/azure"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/bos"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/cos"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/gcs"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/oss"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/s3"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/swift"
 )
@@ -35,6 +38,12 @@ const (
 	// COS is the value for the Tencent Cloud COS storage backend.
 	COS = "cos"
 
+	// OSS is the value for the Alibaba Cloud OSS storage backend.
+	OSS = "oss"
+
+	// BOS is the value for the Baidu Cloud BOS storage backend.
+	BOS = "bos"
+
 	// Filesystem is the value for the filesystem storage backend.
 	Filesystem = "filesystem"
 
@@ -43,7 +52,7 @@ const (
 )
 
 var (
-	SupportedBackends = []string{S3, GCS, Azure, Swift, Filesystem, COS}
+	SupportedBackends = []string{S3, GCS, Azure, Swift, Filesystem, COS, OSS, BOS}
 
 	ErrUnsupportedStorageBackend        = errors.New("unsupported storage backend")
 	ErrInvalidCharactersInStoragePrefix = errors.New("storage prefix contains invalid characters, it may only contain digits and English alphabet letters")
@@ -58,6 +67,8 @@ type StorageBackendConfig struct {
 	Azure      azure.Config      `yaml:"azure"`
 	Swift      swift.Config      `yaml:"swift"`
 	COS        cos.Config        `yaml:"cos"`
+	OSS        oss.Config        `yaml:"oss"`
+	BOS        bos.Config        `yaml:"bos"`
 	Filesystem filesystem.Config `yaml:"filesystem"`
 }
 
@@ -78,6 +89,8 @@ func (cfg *StorageBackendConfig) RegisterFlagsWithPrefixAndDefaultDirectory(pref
 	cfg.Swift.RegisterFlagsWithPrefix(prefix, f)
 	cfg.Filesystem.RegisterFlagsWithPrefixAndDefaultDirectory(prefix, dir, f)
 	cfg.COS.RegisterFlagsWithPrefix(prefix, f)
+	cfg.OSS.RegisterFlagsWithPrefix(prefix, f)
+	cfg.BOS.RegisterFlagsWithPrefix(prefix, f)
 	f.StringVar(&cfg.Backend, prefix+"backend", Filesystem, fmt.Sprintf("Backend storage to use. Supported backends are: %s.", strings.Join(cfg.supportedBackends(), ", ")))
 }
 
@@ -95,6 +108,12 @@ func (cfg *StorageBackendConfig) Validate() error {
 		return cfg.S3.Validate()
 	case COS:
 		return cfg.COS.Validate()
+	case Azure:
+		return cfg.Azure.Validate()
+	case OSS:
+		return cfg.OSS.Validate()
+	case BOS:
+		return cfg.BOS.Validate()
 	default:
 		return nil
 	}
@@ -106,6 +125,9 @@ type Config struct {
 
 	StoragePrefix string `yaml:"storage_prefix" category:"experimental"`
 
+	Hedging phlareobj.HedgingConfig `yaml:"hedging"`
+	Retries phlareobj.RetryConfig   `yaml:"retries"`
+
 	// Not used internally, meant to allow callers to wrap Buckets
 	// created using this config
 	Middlewares []func(objstore.Bucket) (objstore.Bucket, error) `yaml:"-"`
@@ -119,6 +141,8 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 func (cfg *Config) RegisterFlagsWithPrefixAndDefaultDirectory(prefix, dir string, f *flag.FlagSet, logger log.Logger) {
 	cfg.StorageBackendConfig.RegisterFlagsWithPrefixAndDefaultDirectory(prefix, dir, f, logger)
 	f.StringVar(&cfg.StoragePrefix, prefix+"storage-prefix", "", "Prefix for all objects stored in the backend storage. For simplicity, it may only contain digits and English alphabet letters.")
+	cfg.Hedging.RegisterFlagsWithPrefix(f, prefix+"hedging.")
+	cfg.Retries.RegisterFlagsWithPrefix(f, prefix+"retries.")
 }
 
 func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet, logger log.Logger) {
@@ -133,5 +157,11 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if err := cfg.Hedging.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Retries.Validate(); err != nil {
+		return err
+	}
 	return cfg.StorageBackendConfig.Validate()
 }
@@ -3,14 +3,17 @@ package client
 import (
 	"context"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/objstore"
 	objtracing "github.com/thanos-io/objstore/tracing/opentracing"
 
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/azure"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/bos"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/cos"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/gcs"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/oss"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/s3"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/swift"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
@@ -36,6 +39,10 @@ func NewBucket(ctx context.Context, cfg Config, name string) (phlareobj.Bucket,
 		backendClient, err = swift.NewBucketClient(cfg.Swift, name, logger)
 	case COS:
 		backendClient, err = cos.NewBucketClient(cfg.COS, name, logger)
+	case OSS:
+		backendClient, err = oss.NewBucketClient(cfg.OSS, name, logger)
+	case BOS:
+		backendClient, err = bos.NewBucketClient(cfg.BOS, name, logger)
 	case Filesystem:
 		// Filesystem is a special case, as it is not a remote storage backend
 		// We want to use a fileReaderAt to read and seek from the filesystem
@@ -71,7 +78,11 @@ func NewBucket(ctx context.Context, cfg Config, name string) (phlareobj.Bucket,
 			return nil, err
 		}
 	}
-	bkt := phlareobj.NewBucket(objtracing.WrapWithTraces(objstore.WrapWithMetrics(backendClient, reg, name)))
+	backendClient = objstore.WrapWithMetrics(backendClient, reg, name)
+	bucketReg := prometheus.WrapRegistererWith(prometheus.Labels{"bucket": name}, reg)
+	backendClient = phlareobj.NewRetryingBucket(backendClient, cfg.Retries, bucketReg)
+	backendClient = phlareobj.NewHedgedBucket(backendClient, cfg.Hedging, bucketReg)
+	bkt := phlareobj.NewBucket(objtracing.WrapWithTraces(backendClient))
 
 	if cfg.StoragePrefix != "" {
 		bkt = phlareobj.NewPrefixedBucket(bkt, cfg.StoragePrefix)
@@ -0,0 +1,229 @@
+package objstore
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DiskCache is a cache of byte slices stored as files in a local directory,
+// evicting the least recently used entries once the total size of the
+// entries exceeds the configured budget. The entries found in the directory
+// when the cache is created are kept, so the cache is warm after a restart.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	logger  log.Logger
+
+	mtx     sync.Mutex
+	size    int64
+	lru     *list.List // Front is the most recently used.
+	entries map[string]*list.Element
+
+	sizeBytes prometheus.Gauge
+	evictions prometheus.Counter
+}
+
+type diskCacheEntry struct {
+	name string
+	size int64
+}
+
+// NewDiskCache returns a cache storing at most maxSize bytes in dir.
+func NewDiskCache(dir string, maxSize int64, logger log.Logger, reg prometheus.Registerer) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrap(err, "create disk cache dir")
+	}
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		logger:  logger,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		sizeBytes: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "pyroscope_objstore_disk_cache_size_bytes",
+			Help: "Total size of the entries of the disk cache.",
+		}),
+		evictions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_disk_cache_evictions_total",
+			Help: "Total number of entries evicted from the disk cache.",
+		}),
+	}
+	if err := c.warmUp(); err != nil {
+		return nil, errors.Wrap(err, "load disk cache entries")
+	}
+	return c, nil
+}
+
+// warmUp indexes the entries stored in the directory, the most recently
+// modified first, and removes the files of interrupted writes.
+func (c *DiskCache) warmUp() error {
+	type file struct {
+		diskCacheEntry
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(d.Name(), ".tmp") {
+			return os.Remove(path)
+		}
+		if len(d.Name()) != 2*sha256.Size {
+			// Not an entry.
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{
+			diskCacheEntry: diskCacheEntry{name: d.Name(), size: info.Size()},
+			modTime:        info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, f := range files {
+		c.entries[f.name] = c.lru.PushBack(&diskCacheEntry{name: f.name, size: f.size})
+		c.size += f.size
+	}
+	c.evict()
+	level.Info(c.logger).Log("msg", "disk cache loaded", "entries", len(c.entries), "size_bytes", c.size)
+	return nil
+}
+
+func (c *DiskCache) fetch(_ context.Context, key string) ([]byte, bool) {
+	name := diskCacheFilename(key)
+	c.mtx.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mtx.Unlock()
+	if !ok {
+		return nil, false
+	}
+	// The file may be evicted concurrently, in which case this is a miss.
+	data, err := os.ReadFile(c.path(name))
+	if os.IsNotExist(err) {
+		c.forget(name, e)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// forget removes the entry whose file is missing.
+func (c *DiskCache) forget(name string, e *list.Element) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.entries[name] != e {
+		return
+	}
+	c.lru.Remove(e)
+	delete(c.entries, name)
+	c.size -= e.Value.(*diskCacheEntry).size
+	c.sizeBytes.Set(float64(c.size))
+}
+
+func (c *DiskCache) store(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return
+	}
+	name := diskCacheFilename(key)
+	c.mtx.Lock()
+	_, ok := c.entries[name]
+	c.mtx.Unlock()
+	if ok {
+		return
+	}
+	if err := c.write(name, data); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to write disk cache entry", "err", err)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok = c.entries[name]; ok {
+		// Written concurrently.
+		return
+	}
+	c.entries[name] = c.lru.PushFront(&diskCacheEntry{name: name, size: size})
+	c.size += size
+	c.evict()
+}
+
+// write writes the entry to a temporary file renamed once written, so that
+// a partially written entry is never read.
+func (c *DiskCache) write(name string, data []byte) error {
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// evict removes the least recently used entries until the size of the
+// cache is within the budget. It must be called with the mutex held.
+func (c *DiskCache) evict() {
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		entry := e.Value.(*diskCacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+		c.evictions.Inc()
+		if err := os.Remove(c.path(entry.name)); err != nil && !os.IsNotExist(err) {
+			level.Warn(c.logger).Log("msg", "failed to remove disk cache entry", "err", err)
+		}
+	}
+	c.sizeBytes.Set(float64(c.size))
+}
+
+// path returns the path of the file of the entry. The entries are spread
+// over 256 sub-directories.
+func (c *DiskCache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+func diskCacheFilename(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,43 @@
+package objstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DiskCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 10, log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	c.store("a", []byte("0123"))
+	c.store("b", []byte("4567"))
+	data, ok := c.fetch(ctx, "a")
+	require.True(t, ok)
+	require.Equal(t, "0123", string(data))
+
+	// b is the least recently used entry.
+	c.store("c", []byte("89"))
+	c.store("d", []byte("ab"))
+	_, ok = c.fetch(ctx, "b")
+	require.False(t, ok)
+
+	// Entries larger than the cache are not stored.
+	c.store("e", []byte("0123456789a"))
+	_, ok = c.fetch(ctx, "e")
+	require.False(t, ok)
+
+	// The entries are kept across restarts.
+	c, err = NewDiskCache(dir, 10, log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	for key, expected := range map[string]string{"a": "0123", "c": "89", "d": "ab"} {
+		data, ok = c.fetch(ctx, key)
+		require.True(t, ok, key)
+		require.Equal(t, expected, string(data))
+	}
+}
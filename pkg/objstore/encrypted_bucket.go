@@ -0,0 +1,559 @@
+package objstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// encryptionMagic starts the objects encrypted by an EncryptedBucket.
+	encryptionMagic = "PYROENC1"
+	// maxKeyIDLength and maxWrappedKeyLength bound the size of the header
+	// of the encrypted objects, so it's read with a single request.
+	maxKeyIDLength      = 255
+	maxWrappedKeyLength = 512
+	maxEncryptionHeader = len(encryptionMagic) + 2 + maxKeyIDLength + 2 + maxWrappedKeyLength
+	// encryptionFrameSize is the size of the plaintext of the frames the
+	// objects are encrypted in. Each frame is sealed with AES-GCM, and
+	// followed by its tag.
+	encryptionFrameSize = 64 << 10
+	encryptionTagSize   = 16
+	encryptedFrameSize  = encryptionFrameSize + encryptionTagSize
+	// encryptionHeadersCacheSize is the number of object headers, and their
+	// unwrapped data keys, kept in memory.
+	encryptionHeadersCacheSize = 4096
+)
+
+// KeyProvider wraps and unwraps the data keys of the encrypted objects with
+// the master keys referenced by the tenants. Key management services are
+// supported by implementing it.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, keyID string, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// EncryptionConfig configures the master keys of the encryption of the
+// blocks at rest.
+type EncryptionConfig struct {
+	StaticKeysDir string `yaml:"static_keys_dir" category:"experimental"`
+}
+
+func (cfg *EncryptionConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.StaticKeysDir, prefix+"static-keys-dir", "", "Directory of the master keys the tenants reference to encrypt their blocks, with the blocks_encryption_key_id limit. Each file holds a hex-encoded 256-bit AES key, named after the key ID. Required on ingesters and store-gateways if blocks are encrypted.")
+}
+
+// NewKeyProvider returns the key provider configured, or nil if none is.
+func NewKeyProvider(cfg EncryptionConfig) (KeyProvider, error) {
+	if cfg.StaticKeysDir == "" {
+		return nil, nil
+	}
+	return NewStaticKeyProvider(cfg.StaticKeysDir)
+}
+
+// staticKeyProvider wraps the data keys with AES-GCM, using master keys
+// loaded from local files.
+type staticKeyProvider struct {
+	keys map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider loads the master keys of the files of dir, named
+// after the key ID and holding a hex-encoded 256-bit key.
+func NewStaticKeyProvider(dir string) (KeyProvider, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read encryption keys dir")
+	}
+	p := &staticKeyProvider{keys: make(map[string]cipher.AEAD)}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read encryption key %q", e.Name())
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 hex-encoded bytes", e.Name())
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[e.Name()] = aead
+	}
+	return p, nil
+}
+
+func (p *staticKeyProvider) WrapKey(_ context.Context, keyID string, key []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, []byte(keyID)), nil
+}
+
+func (p *staticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
+
+// EncryptedBucket encrypts the files of the blocks of the tenants with a
+// key ID set, with a data key generated for each object and wrapped with the
+// master key of the tenant. The wrapped key is stored in a header in front
+// of the object. The object is encrypted with AES-256-GCM in frames of
+// 64KiB, authenticated with their index and whether they are the last one,
+// so ranges are decrypted without reading the object from the start, and
+// altered, reordered or truncated frames are detected. Objects without the
+// header are read as is, so blocks written before the encryption was enabled
+// are still readable.
+//
+// The metadata of the blocks, the markers and the bucket index are never
+// encrypted, so the blocks are discovered and planned without the keys.
+type EncryptedBucket struct {
+	Bucket
+	keys  KeyProvider
+	keyID func(tenantID string) string
+
+	mtx     sync.Mutex
+	headers *lru.Cache
+}
+
+// NewEncryptedBucket returns a bucket encrypting the blocks of the tenants
+// with the key ID returned by keyID, which may be nil to only decrypt. The
+// bucket is returned as is if there is neither a key provider nor a keyID
+// function.
+func NewEncryptedBucket(bkt Bucket, keys KeyProvider, keyID func(tenantID string) string) Bucket {
+	if keys == nil && keyID == nil {
+		return bkt
+	}
+	return &EncryptedBucket{
+		Bucket:  bkt,
+		keys:    keys,
+		keyID:   keyID,
+		headers: lru.New(encryptionHeadersCacheSize),
+	}
+}
+
+// encryptionHeader is the header of an object. Objects not encrypted have
+// an empty header.
+type encryptionHeader struct {
+	size int64
+	key  []byte
+}
+
+// encryptedObject returns the tenant of the object, and whether it is a file
+// of a block encrypted if the tenant has a key ID.
+func encryptedObject(name string) (string, bool) {
+	// <tenant>/phlaredb/<block>/<file>
+	parts := strings.SplitN(name, objstore.DirDelim, 4)
+	if len(parts) != 4 || parts[1] != "phlaredb" || strings.HasSuffix(parts[3], ".json") {
+		return "", false
+	}
+	if _, err := ulid.Parse(parts[2]); err != nil {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func (b *EncryptedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.forget(name)
+	tenantID, ok := encryptedObject(name)
+	if !ok || b.keyID == nil {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	keyID := b.keyID(tenantID)
+	if keyID == "" {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	if b.keys == nil {
+		return fmt.Errorf("no key provider configured for the encryption key %q of tenant %s", keyID, tenantID)
+	}
+	if len(keyID) > maxKeyIDLength {
+		return fmt.Errorf("encryption key ID of tenant %s exceeds %d characters", tenantID, maxKeyIDLength)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := b.keys.WrapKey(ctx, keyID, key)
+	if err != nil {
+		return errors.Wrapf(err, "wrap data key of %s", name)
+	}
+	if len(wrapped) > maxWrappedKeyLength {
+		return fmt.Errorf("wrapped data key exceeds %d bytes", maxWrappedKeyLength)
+	}
+	var header bytes.Buffer
+	header.WriteString(encryptionMagic)
+	_ = binary.Write(&header, binary.BigEndian, uint16(len(keyID)))
+	header.WriteString(keyID)
+	_ = binary.Write(&header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+
+	aead, err := newFrameAEAD(key)
+	if err != nil {
+		return err
+	}
+	return b.Bucket.Upload(ctx, name, io.MultiReader(&header, &encryptingReader{
+		r:     bufio.NewReaderSize(r, encryptionFrameSize),
+		aead:  aead,
+		frame: make([]byte, encryptionFrameSize),
+	}))
+}
+
+func (b *EncryptedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if _, ok := encryptedObject(name); !ok {
+		return b.Bucket.Get(ctx, name)
+	}
+	h, err := b.header(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h.size == 0 {
+		return b.Bucket.Get(ctx, name)
+	}
+	return h.decrypt(ctx, b.Bucket, name, 0, -1)
+}
+
+func (b *EncryptedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if _, ok := encryptedObject(name); !ok {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	}
+	h, err := b.header(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if h.size == 0 {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	}
+	return h.decrypt(ctx, b.Bucket, name, off, length)
+}
+
+func (b *EncryptedBucket) ReaderAt(ctx context.Context, name string) (ReaderAtCloser, error) {
+	if _, ok := encryptedObject(name); !ok {
+		return b.Bucket.ReaderAt(ctx, name)
+	}
+	return &ReaderAt{
+		GetRangeReader: b,
+		name:           name,
+		ctx:            ctx,
+	}, nil
+}
+
+func (b *EncryptedBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	attrs, err := b.Bucket.Attributes(ctx, name)
+	if err != nil {
+		return attrs, err
+	}
+	if _, ok := encryptedObject(name); !ok {
+		return attrs, nil
+	}
+	h, err := b.header(ctx, name)
+	if err != nil {
+		return attrs, err
+	}
+	if h.size > 0 {
+		// Every frame, including the last one, has a tag.
+		body := attrs.Size - h.size
+		attrs.Size = body - (body+encryptedFrameSize-1)/encryptedFrameSize*encryptionTagSize
+	}
+	return attrs, nil
+}
+
+func (b *EncryptedBucket) Delete(ctx context.Context, name string) error {
+	b.forget(name)
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *EncryptedBucket) forget(name string) {
+	b.mtx.Lock()
+	b.headers.Remove(name)
+	b.mtx.Unlock()
+}
+
+// header reads the header of the object, and unwraps its data key. The
+// headers are cached, objects never change once written.
+func (b *EncryptedBucket) header(ctx context.Context, name string) (*encryptionHeader, error) {
+	b.mtx.Lock()
+	cached, ok := b.headers.Get(name)
+	b.mtx.Unlock()
+	if ok {
+		return cached.(*encryptionHeader), nil
+	}
+
+	rc, err := b.Bucket.GetRange(ctx, name, 0, int64(maxEncryptionHeader))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	h, err := b.parseHeader(ctx, name, data)
+	if err != nil {
+		return nil, err
+	}
+	b.mtx.Lock()
+	b.headers.Add(name, h)
+	b.mtx.Unlock()
+	return h, nil
+}
+
+func (b *EncryptedBucket) parseHeader(ctx context.Context, name string, data []byte) (*encryptionHeader, error) {
+	if !bytes.HasPrefix(data, []byte(encryptionMagic)) {
+		return &encryptionHeader{}, nil
+	}
+	errInvalid := fmt.Errorf("invalid encryption header of %s", name)
+	r := bytes.NewReader(data[len(encryptionMagic):])
+	readField := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, errInvalid
+		}
+		field := make([]byte, n)
+		if _, err := io.ReadFull(r, field); err != nil {
+			return nil, errInvalid
+		}
+		return field, nil
+	}
+	keyID, err := readField()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := readField()
+	if err != nil {
+		return nil, err
+	}
+	if b.keys == nil {
+		return nil, fmt.Errorf("%s is encrypted with the key %q, but no key provider is configured", name, keyID)
+	}
+	key, err := b.keys.UnwrapKey(ctx, string(keyID), wrapped)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unwrap data key of %s", name)
+	}
+	return &encryptionHeader{
+		size: int64(len(data) - r.Len()),
+		key:  key,
+	}, nil
+}
+
+func newFrameAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// frameNonce returns the nonce of the frame of the index. The data keys are
+// never reused across objects, so the index is a unique nonce.
+func frameNonce(nonce []byte, index uint64) []byte {
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+// frameData returns the additional data authenticated with the frame, so
+// that the object can't be truncated after a frame.
+func frameData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptingReader encrypts the frames of the object read from r. An empty
+// object is encrypted as a single empty frame.
+type encryptingReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	frame  []byte
+	sealed []byte
+	buf    []byte
+	index  uint64
+	done   bool
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.r, e.frame)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := err != nil
+		if !last {
+			// The frame is the last one if the object ends with it.
+			if _, err = e.r.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		nonce := frameNonce(make([]byte, e.aead.NonceSize()), e.index)
+		e.sealed = e.aead.Seal(e.sealed[:0], nonce, e.frame[:n], frameData(last))
+		e.buf = e.sealed
+		e.index++
+		e.done = last
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// decrypt returns a reader decrypting length bytes of the object from the
+// offset off, or up to its end if length is negative. Only the frames of the
+// range are read.
+func (h *encryptionHeader) decrypt(ctx context.Context, bkt Bucket, name string, off, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	aead, err := newFrameAEAD(h.key)
+	if err != nil {
+		return nil, err
+	}
+	first := off / encryptionFrameSize
+	fetch := int64(-1)
+	if length > 0 {
+		fetch = ((off+length-1)/encryptionFrameSize - first + 1) * encryptedFrameSize
+	}
+	rc, err := bkt.GetRange(ctx, name, h.size+first*encryptedFrameSize, fetch)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		r:         bufio.NewReaderSize(rc, encryptedFrameSize),
+		closer:    rc,
+		name:      name,
+		aead:      aead,
+		frame:     make([]byte, encryptedFrameSize),
+		index:     uint64(first),
+		skip:      int(off % encryptionFrameSize),
+		remaining: length,
+	}, nil
+}
+
+// decryptingReader decrypts and authenticates the frames of a range of an
+// object.
+type decryptingReader struct {
+	r      *bufio.Reader
+	closer io.Closer
+	name   string
+	aead   cipher.AEAD
+	frame  []byte
+	plain  []byte
+	buf    []byte
+	index  uint64
+	skip   int
+	// remaining is the number of bytes of the range left to read, negative
+	// if the range goes up to the end of the object.
+	remaining int64
+	done      bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.remaining == 0 {
+		return 0, io.EOF
+	}
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if d.remaining >= 0 && int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	if d.remaining > 0 {
+		d.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+func (d *decryptingReader) readFrame() error {
+	n, err := io.ReadFull(d.r, d.frame)
+	switch {
+	case err == io.EOF:
+		if d.remaining < 0 {
+			return fmt.Errorf("%s is truncated", d.name)
+		}
+		// The range goes past the end of the object.
+		d.done = true
+		return nil
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return err
+	}
+	// A frame shorter than the others is the last one. Otherwise, it is the
+	// last one if the object ends with it. For a range ending before the
+	// end of the object, whether its last frame is the last one of the
+	// object is unknown.
+	last, known := err != nil, true
+	if !last {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last, known = true, d.remaining < 0
+		} else if err != nil {
+			return err
+		}
+	}
+	nonce := frameNonce(make([]byte, d.aead.NonceSize()), d.index)
+	d.plain, err = d.aead.Open(d.plain[:0], nonce, d.frame[:n], frameData(last))
+	if err != nil && !known {
+		last = false
+		d.plain, err = d.aead.Open(d.plain[:0], nonce, d.frame[:n], frameData(last))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "decrypt frame %d of %s", d.index, d.name)
+	}
+	if d.skip > len(d.plain) {
+		// The offset is past the end of the object.
+		d.skip = len(d.plain)
+	}
+	d.buf = d.plain[d.skip:]
+	d.skip = 0
+	d.index++
+	d.done = last
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.closer.Close()
+}
@@ -0,0 +1,138 @@
+package objstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+func Test_EncryptedBucket(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tenant-key"), []byte(hex.EncodeToString(bytes.Repeat([]byte{1}, 32))+"\n"), 0o600))
+	keys, err := phlareobj.NewStaticKeyProvider(dir)
+	require.NoError(t, err)
+
+	inmem := objstore.NewInMemBucket()
+	writer := phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), keys, func(tenantID string) string {
+		if tenantID == "tenant" {
+			return "tenant-key"
+		}
+		return ""
+	})
+	reader := phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), keys, nil)
+
+	// The objects are encrypted in frames of 64KiB.
+	const frameSize = 64 << 10
+	content := make([]byte, 3*frameSize+1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	blockID := ulid.MustNew(1, nil).String()
+	name := "tenant/phlaredb/" + blockID + "/profiles.parquet"
+	meta := "tenant/phlaredb/" + blockID + "/meta.json"
+	plain := "other/phlaredb/" + blockID + "/profiles.parquet"
+	require.NoError(t, writer.Upload(ctx, name, bytes.NewReader(content)))
+	require.NoError(t, writer.Upload(ctx, meta, bytes.NewReader(content)))
+	require.NoError(t, writer.Upload(ctx, plain, bytes.NewReader(content)))
+
+	// Only the files of the blocks of the tenants with a key are encrypted.
+	require.False(t, bytes.Contains(readAll(t, inmem, name), content[:32]))
+	require.Equal(t, content, readAll(t, inmem, meta))
+	require.Equal(t, content, readAll(t, inmem, plain))
+
+	for _, n := range []string{name, meta, plain} {
+		require.Equal(t, content, readAll(t, reader, n))
+		attrs, err := reader.Attributes(ctx, n)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(content)), attrs.Size)
+	}
+
+	for _, r := range []struct{ off, length int64 }{
+		{0, 10}, {15, 2}, {16, 100}, {33, 500}, {990, 100},
+		{frameSize - 10, 20}, {frameSize, frameSize}, {frameSize + 1, 2 * frameSize}, {3*frameSize + 990, 100}, {100, -1},
+	} {
+		rc, err := reader.GetRange(ctx, name, r.off, r.length)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		end := r.off + r.length
+		if r.length < 0 || end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		require.Equal(t, content[r.off:end], data)
+	}
+
+	ra, err := reader.ReaderAt(ctx, name)
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	_, err = ra.ReadAt(buf, 100)
+	require.NoError(t, err)
+	require.Equal(t, content[100:164], buf)
+
+	// The frames are authenticated.
+	encrypted := readAll(t, inmem, name)
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-frameSize] ^= 1
+	require.NoError(t, inmem.Upload(ctx, name, bytes.NewReader(tampered)))
+	reader = phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), keys, nil)
+	_, err = io.ReadAll(mustGet(t, reader, name))
+	require.Error(t, err)
+	rc, err := reader.GetRange(ctx, name, 0, frameSize)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, content[:frameSize], data)
+
+	// The truncation of the object after a frame is detected.
+	truncated := encrypted[:len(encrypted)-1000-16]
+	require.NoError(t, inmem.Upload(ctx, name, bytes.NewReader(truncated)))
+	reader = phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), keys, nil)
+	_, err = io.ReadAll(mustGet(t, reader, name))
+	require.Error(t, err)
+
+	// Empty objects and objects of whole frames are encrypted.
+	for _, c := range [][]byte{{}, content[:2*frameSize]} {
+		require.NoError(t, writer.Upload(ctx, name, bytes.NewReader(c)))
+		reader = phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), keys, nil)
+		require.Equal(t, c, readAll(t, reader, name))
+		attrs, err := reader.Attributes(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(c)), attrs.Size)
+	}
+
+	// The blocks can't be written or read without the keys.
+	noKeys := phlareobj.NewEncryptedBucket(phlareobj.NewBucket(inmem), nil, func(string) string { return "tenant-key" })
+	require.Error(t, noKeys.Upload(ctx, name, bytes.NewReader(content)))
+	_, err = noKeys.Get(ctx, name)
+	require.Error(t, err)
+}
+
+func mustGet(t *testing.T, bkt objstore.BucketReader, name string) io.Reader {
+	t.Helper()
+	rc, err := bkt.Get(context.Background(), name)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = rc.Close() })
+	return rc
+}
+
+func readAll(t *testing.T, bkt objstore.BucketReader, name string) []byte {
+	t.Helper()
+	rc, err := bkt.Get(context.Background(), name)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return data
+}
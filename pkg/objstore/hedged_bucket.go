@@ -0,0 +1,255 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// hedgingLatencySamples is the number of the most recent latencies the
+	// hedging delay is computed from.
+	hedgingLatencySamples = 1024
+	// hedgingMinLatencySamples is the number of latencies observed before
+	// the hedging delay is computed from them, instead of the min delay.
+	hedgingMinLatencySamples = 100
+	// hedgingDelayUpdateInterval is the number of latencies observed between
+	// two computations of the hedging delay.
+	hedgingDelayUpdateInterval = 64
+)
+
+// HedgingConfig configures the hedging of the object reads.
+type HedgingConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Percentile float64       `yaml:"percentile" category:"advanced"`
+	MinDelay   time.Duration `yaml:"min_delay" category:"advanced"`
+}
+
+func (cfg *HedgingConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "If enabled, a second request is sent for object reads taking longer than the latency percentile of the recent reads. The response received first is used.")
+	f.Float64Var(&cfg.Percentile, prefix+"percentile", 95, "The percentile of the latency of the recent object reads after which a read is hedged.")
+	f.DurationVar(&cfg.MinDelay, prefix+"min-delay", 100*time.Millisecond, "The minimum time to wait before hedging an object read. It's also the delay used until enough reads have been observed.")
+}
+
+func (cfg *HedgingConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Percentile <= 0 || cfg.Percentile > 100 {
+		return errors.New("hedging percentile must be greater than 0 and less than or equal to 100")
+	}
+	if cfg.MinDelay < 0 {
+		return errors.New("hedging min delay must not be negative")
+	}
+	return nil
+}
+
+// HedgedBucket sends a second request for the object reads which don't
+// complete within the configured percentile of the latency of the recent
+// reads, and uses the response received first. This bounds the tail latency
+// of object reads, at the cost of a few more requests.
+type HedgedBucket struct {
+	objstore.Bucket
+	cfg HedgingConfig
+
+	get      *latencyTracker
+	getRange *latencyTracker
+
+	hedged *prometheus.CounterVec
+	wins   *prometheus.CounterVec
+}
+
+// NewHedgedBucket wraps the bucket with hedged reads. The bucket is returned
+// as is if hedging is disabled.
+func NewHedgedBucket(bkt objstore.Bucket, cfg HedgingConfig, reg prometheus.Registerer) objstore.Bucket {
+	if !cfg.Enabled {
+		return bkt
+	}
+	return &HedgedBucket{
+		Bucket:   bkt,
+		cfg:      cfg,
+		get:      newLatencyTracker(cfg.Percentile),
+		getRange: newLatencyTracker(cfg.Percentile),
+		hedged: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_hedged_requests_total",
+			Help: "Total number of hedged object reads sent.",
+		}, []string{"operation"}),
+		wins: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_hedged_request_wins_total",
+			Help: "Total number of hedged object reads which completed before the original request.",
+		}, []string{"operation"}),
+	}
+}
+
+func (b *HedgedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.hedge(ctx, "get", b.get, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.Bucket.Get(ctx, name)
+	})
+}
+
+func (b *HedgedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.hedge(ctx, "get_range", b.getRange, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.Bucket.GetRange(ctx, name, off, length)
+	})
+}
+
+type hedgedResult struct {
+	rc     io.ReadCloser
+	err    error
+	hedged bool
+}
+
+func (b *HedgedBucket) hedge(ctx context.Context, op string, tracker *latencyTracker, fn func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	// Both requests send their result, so the request losing the race never
+	// blocks and its response can be closed.
+	results := make(chan hedgedResult, 2)
+	cancels := make([]context.CancelFunc, 0, 2)
+	send := func(hedged bool) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			rc, err := fn(reqCtx)
+			if err == nil {
+				tracker.observe(time.Since(start))
+			}
+			results <- hedgedResult{rc: rc, err: err, hedged: hedged}
+		}()
+	}
+
+	send(false)
+	timer := time.NewTimer(tracker.delay(b.cfg.MinDelay))
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) == 1 {
+				b.hedged.WithLabelValues(op).Inc()
+				send(true)
+				pending++
+			}
+
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				if pending > 0 {
+					// Wait for the other request.
+					continue
+				}
+				for _, cancel := range cancels {
+					cancel()
+				}
+				return nil, r.err
+			}
+			winner := 0
+			if r.hedged {
+				winner = 1
+				b.wins.WithLabelValues(op).Inc()
+			}
+			// The request losing the race is canceled, and its response
+			// closed if it was received anyway.
+			for i, cancel := range cancels {
+				if i != winner {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go func() {
+					if lost := <-results; lost.err == nil {
+						_ = lost.rc.Close()
+					}
+				}()
+			}
+			// The context of the winner is canceled once its response is
+			// closed.
+			return &cancelOnCloseReader{ReadCloser: r.rc, cancel: cancels[winner]}, nil
+		}
+	}
+}
+
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// WithExpectedErrs implements objstore.InstrumentedBucket.
+func (b *HedgedBucket) WithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	if ib, ok := b.Bucket.(objstore.InstrumentedBucket); ok {
+		c := *b
+		c.Bucket = ib.WithExpectedErrs(fn)
+		return &c
+	}
+	return b
+}
+
+// ReaderWithExpectedErrs implements objstore.InstrumentedBucket.
+func (b *HedgedBucket) ReaderWithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.WithExpectedErrs(fn)
+}
+
+// latencyTracker keeps the most recent latencies of an operation, and the
+// percentile of them.
+type latencyTracker struct {
+	percentile float64
+
+	mtx             sync.Mutex
+	samples         []time.Duration
+	next            int
+	observed        int
+	percentileValue time.Duration
+}
+
+func newLatencyTracker(percentile float64) *latencyTracker {
+	return &latencyTracker{
+		percentile: percentile,
+		samples:    make([]time.Duration, 0, hedgingLatencySamples),
+	}
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if len(t.samples) < hedgingLatencySamples {
+		t.samples = append(t.samples, d)
+	} else {
+		t.samples[t.next] = d
+		t.next = (t.next + 1) % hedgingLatencySamples
+	}
+	t.observed++
+	if t.observed >= hedgingMinLatencySamples && t.observed%hedgingDelayUpdateInterval == 0 {
+		sorted := make([]time.Duration, len(t.samples))
+		copy(sorted, t.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		idx := int(float64(len(sorted))*t.percentile/100) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		t.percentileValue = sorted[idx]
+	}
+}
+
+// delay returns the time to wait before hedging a request, which is never
+// less than minDelay.
+func (t *latencyTracker) delay(minDelay time.Duration) time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.percentileValue > minDelay {
+		return t.percentileValue
+	}
+	return minDelay
+}
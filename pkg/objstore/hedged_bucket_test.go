@@ -0,0 +1,69 @@
+package objstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+// slowFirstGetBucket blocks the first Get until its context is canceled.
+type slowFirstGetBucket struct {
+	objstore.Bucket
+	calls atomic.Int32
+}
+
+func (b *slowFirstGetBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if b.calls.Inc() == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func Test_HedgedBucket(t *testing.T) {
+	ctx := context.Background()
+	inMem := objstore.NewInMemBucket()
+	require.NoError(t, inMem.Upload(ctx, "block/meta.json", bytes.NewReader([]byte("{}"))))
+	reg := prometheus.NewRegistry()
+	b := phlareobj.NewHedgedBucket(&slowFirstGetBucket{Bucket: inMem}, phlareobj.HedgingConfig{
+		Enabled:    true,
+		Percentile: 95,
+		MinDelay:   10 * time.Millisecond,
+	}, reg)
+
+	rc, err := b.Get(ctx, "block/meta.json")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, "{}", string(data))
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP pyroscope_objstore_hedged_request_wins_total Total number of hedged object reads which completed before the original request.
+		# TYPE pyroscope_objstore_hedged_request_wins_total counter
+		pyroscope_objstore_hedged_request_wins_total{operation="get"} 1
+		# HELP pyroscope_objstore_hedged_requests_total Total number of hedged object reads sent.
+		# TYPE pyroscope_objstore_hedged_requests_total counter
+		pyroscope_objstore_hedged_requests_total{operation="get"} 1
+	`)))
+
+	// Objects not found are not hedged once the original request failed.
+	_, err = b.Get(ctx, "missing")
+	require.True(t, inMem.IsObjNotFoundErr(err))
+}
+
+func Test_HedgedBucket_Disabled(t *testing.T) {
+	inMem := objstore.NewInMemBucket()
+	require.Equal(t, objstore.Bucket(inMem), phlareobj.NewHedgedBucket(inMem, phlareobj.HedgingConfig{}, nil))
+}
@@ -7,7 +7,9 @@ package azure
 
 import (
 	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
 	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/exthttp"
 	"github.com/thanos-io/objstore/providers/azure"
 	"gopkg.in/yaml.v3"
 )
@@ -22,6 +24,16 @@ func NewBucketClient(cfg Config, name string, logger log.Logger) (objstore.Bucke
 	bucketConfig.Endpoint = cfg.Endpoint
 	bucketConfig.MaxRetries = cfg.MaxRetries
 	bucketConfig.UserAssignedID = cfg.UserAssignedID
+	bucketConfig.HTTPConfig = exthttp.HTTPConfig{
+		IdleConnTimeout:       model.Duration(cfg.HTTP.IdleConnTimeout),
+		ResponseHeaderTimeout: model.Duration(cfg.HTTP.ResponseHeaderTimeout),
+		InsecureSkipVerify:    cfg.HTTP.InsecureSkipVerify,
+		TLSHandshakeTimeout:   model.Duration(cfg.HTTP.TLSHandshakeTimeout),
+		ExpectContinueTimeout: model.Duration(cfg.HTTP.ExpectContinueTimeout),
+		MaxIdleConns:          cfg.HTTP.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.HTTP.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.HTTP.MaxConnsPerHost,
+	}
 
 	// Thanos currently doesn't support passing the config as is, but expects a YAML,
 	// so we're going to serialize it.
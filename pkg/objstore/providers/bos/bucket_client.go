@@ -0,0 +1,25 @@
+package bos
+
+import (
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/bos"
+	"gopkg.in/yaml.v3"
+)
+
+// NewBucketClient creates a bucket client for Baidu Cloud BOS
+func NewBucketClient(cfg Config, name string, logger log.Logger) (objstore.Bucket, error) {
+	bucketConfig := bos.Config{
+		Bucket:    cfg.Bucket,
+		Endpoint:  cfg.Endpoint,
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey.String(),
+	}
+
+	serializedConfig, err := yaml.Marshal(bucketConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return bos.NewBucket(logger, serializedConfig, name)
+}
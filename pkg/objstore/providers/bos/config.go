@@ -0,0 +1,37 @@
+package bos
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+// Config holds the config options for a Baidu Cloud BOS backend.
+type Config struct {
+	Bucket    string         `yaml:"bucket"`
+	Endpoint  string         `yaml:"endpoint"`
+	AccessKey string         `yaml:"access_key"`
+	SecretKey flagext.Secret `yaml:"secret_key"`
+}
+
+// Validate validates the BOS config and returns an error on failure.
+func (cfg *Config) Validate() error {
+	if cfg.Bucket == "" || cfg.Endpoint == "" || cfg.AccessKey == "" || cfg.SecretKey.String() == "" {
+		return errors.New("invalid bos configuration, bucket, endpoint, access_key and secret_key must be set")
+	}
+	return nil
+}
+
+// RegisterFlags registers the flags for BOS storage
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", f)
+}
+
+// RegisterFlagsWithPrefix registers the flags for BOS storage with the provided prefix
+func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&cfg.Bucket, prefix+"bos.bucket", "", "BOS bucket name")
+	f.StringVar(&cfg.Endpoint, prefix+"bos.endpoint", "", "BOS endpoint, for example bj.bcebos.com")
+	f.StringVar(&cfg.AccessKey, prefix+"bos.access-key", "", "BOS access key")
+	f.Var(&cfg.SecretKey, prefix+"bos.secret-key", "BOS secret key")
+}
@@ -0,0 +1,179 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+)
+
+const (
+	// locksDir is the directory of the lock files of the metadata objects,
+	// at the root of the bucket.
+	locksDir = ".locks"
+	// tempSuffix ends the names of the files of the uploads in progress.
+	tempSuffix = ".upload-tmp"
+)
+
+// atomicBucket makes the filesystem bucket safe to share between processes,
+// including over NFS, so the components of a microservices deployment can
+// use the same directory:
+//
+//   - Objects are written to a temporary file renamed once complete, so an
+//     object is never read partially written.
+//   - The metadata objects, such as meta.json files and the bucket index,
+//     are overwritten: they are written under an exclusive lock and read
+//     under a shared one. Acquiring the lock also makes NFS clients
+//     revalidate the data they cached.
+type atomicBucket struct {
+	*filesystem.Bucket
+	rootDir string
+}
+
+func newAtomicBucket(rootDir string) (*atomicBucket, error) {
+	b, err := filesystem.NewBucket(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicBucket{Bucket: b, rootDir: absDir}, nil
+}
+
+func (b *atomicBucket) Upload(ctx context.Context, name string, r io.Reader) (err error) {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	file := filepath.Join(b.rootDir, name)
+	dir := filepath.Dir(file)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "create dir %s", dir)
+	}
+	if isMetadataObject(name) {
+		unlock, err := b.lock(name, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".*"+tempSuffix)
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err = tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err != nil {
+		return errors.Wrapf(err, "copy to %s", file)
+	}
+	// The content must be on disk before the file is visible under its name.
+	if err = tmp.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %s", file)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (b *atomicBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	unlock, err := b.rlock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *atomicBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	unlock, err := b.rlock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return b.Bucket.GetRange(ctx, name, off, length)
+}
+
+func (b *atomicBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	unlock, err := b.rlock(name)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	defer unlock()
+	return b.Bucket.Attributes(ctx, name)
+}
+
+func (b *atomicBucket) Exists(ctx context.Context, name string) (bool, error) {
+	unlock, err := b.rlock(name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	return b.Bucket.Exists(ctx, name)
+}
+
+func (b *atomicBucket) Delete(ctx context.Context, name string) error {
+	if isMetadataObject(name) {
+		unlock, err := b.lock(name, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return b.Bucket.Delete(ctx, name)
+}
+
+// rlock acquires the shared lock of the object if it's a metadata object.
+// Opened files keep their content once the lock is released, as objects are
+// replaced by renames.
+func (b *atomicBucket) rlock(name string) (func(), error) {
+	if !isMetadataObject(name) {
+		return func() {}, nil
+	}
+	return b.lock(name, false)
+}
+
+func (b *atomicBucket) lock(name string, exclusive bool) (func(), error) {
+	dir := filepath.Join(b.rootDir, locksDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create locks dir")
+	}
+	h := sha256.Sum256([]byte(name))
+	unlock, err := lockFile(filepath.Join(dir, hex.EncodeToString(h[:])), exclusive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "lock %s", name)
+	}
+	return unlock, nil
+}
+
+// isMetadataObject returns whether the object may be overwritten. The files
+// of the blocks are written once.
+func isMetadataObject(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+// isInternalFile returns whether the entry listed is a lock file or an upload
+// in progress, which are not objects.
+func isInternalFile(name string) bool {
+	if name == locksDir+objstore.DirDelim || strings.HasPrefix(name, locksDir+objstore.DirDelim) {
+		return true
+	}
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempSuffix)
+}
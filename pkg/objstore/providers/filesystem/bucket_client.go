@@ -10,7 +10,6 @@ import (
 	"github.com/grafana/dskit/runutil"
 	"github.com/pkg/errors"
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/providers/filesystem"
 
 	phlareobjstore "github.com/grafana/pyroscope/pkg/objstore"
 )
@@ -26,7 +25,7 @@ func NewBucket(rootDir string, middlewares ...func(objstore.Bucket) (objstore.Bu
 		b   objstore.Bucket
 		err error
 	)
-	b, err = filesystem.NewBucket(rootDir)
+	b, err = newAtomicBucket(rootDir)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +48,14 @@ func (b *Bucket) ReaderAt(ctx context.Context, filename string) (phlareobjstore.
 }
 
 func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	// The lock files and the uploads in progress are not listed.
+	next := f
+	f = func(name string) error {
+		if isInternalFile(name) {
+			return nil
+		}
+		return next(name)
+	}
 	params := objstore.ApplyIterOptions(options...)
 	if !params.WithoutAppendDirDelim || strings.HasSuffix(dir, objstore.DirDelim) {
 		if dir != "" {
@@ -3,7 +3,13 @@ package filesystem
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 	"github.com/thanos-io/objstore"
@@ -117,3 +123,47 @@ func TestIter(t *testing.T) {
 		})
 	}
 }
+
+func TestAtomicUpload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt, err := NewBucket(dir)
+	require.NoError(t, err)
+	defer bkt.Close()
+
+	// A failed upload leaves neither the object nor a temporary file.
+	require.Error(t, bkt.Upload(ctx, "tenant/block/data", iotest.ErrReader(errors.New("failed"))))
+	exists, err := bkt.Exists(ctx, "tenant/block/data")
+	require.NoError(t, err)
+	require.False(t, exists)
+	entries, err := os.ReadDir(filepath.Join(dir, "tenant", "block"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Metadata objects are overwritten concurrently, and read whole.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := bytes.Repeat([]byte{byte('a' + i)}, 1<<16)
+			require.NoError(t, bkt.Upload(ctx, "tenant/block/meta.json", bytes.NewReader(content)))
+		}(i)
+	}
+	wg.Wait()
+	rc, err := bkt.Get(ctx, "tenant/block/meta.json")
+	require.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Len(t, content, 1<<16)
+	require.Equal(t, bytes.Repeat(content[:1], 1<<16), content)
+
+	// The lock files are not listed.
+	var keys []string
+	require.NoError(t, bkt.Iter(ctx, "", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}, objstore.WithRecursiveIter))
+	require.Equal(t, []string{"tenant/block/meta.json"}, keys)
+}
@@ -20,7 +20,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 // RegisterFlagsWithPrefixAndDefaultDirectory registers the flags for filesystem
 // storage with the provided prefix and sets the default directory to dir.
 func (cfg *Config) RegisterFlagsWithPrefixAndDefaultDirectory(prefix, dir string, f *flag.FlagSet) {
-	f.StringVar(&cfg.Directory, prefix+"filesystem.dir", dir, "Local filesystem storage directory.")
+	f.StringVar(&cfg.Directory, prefix+"filesystem.dir", dir, "Local filesystem storage directory. The directory may be shared over NFS by the components of a microservices deployment: objects are written atomically, and metadata objects are read and written under file locks.")
 }
 
 // RegisterFlagsWithPrefix registers the flags for filesystem storage with the provided prefix
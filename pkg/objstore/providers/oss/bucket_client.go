@@ -0,0 +1,25 @@
+package oss
+
+import (
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/oss"
+	"gopkg.in/yaml.v3"
+)
+
+// NewBucketClient creates a bucket client for Alibaba Cloud OSS
+func NewBucketClient(cfg Config, name string, logger log.Logger) (objstore.Bucket, error) {
+	bucketConfig := oss.Config{
+		Endpoint:        cfg.Endpoint,
+		Bucket:          cfg.Bucket,
+		AccessKeyID:     cfg.AccessKeyID,
+		AccessKeySecret: cfg.AccessKeySecret.String(),
+	}
+
+	serializedConfig, err := yaml.Marshal(bucketConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return oss.NewBucket(logger, serializedConfig, name)
+}
@@ -0,0 +1,37 @@
+package oss
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+// Config holds the config options for an Alibaba Cloud OSS backend.
+type Config struct {
+	Endpoint        string         `yaml:"endpoint"`
+	Bucket          string         `yaml:"bucket"`
+	AccessKeyID     string         `yaml:"access_key_id"`
+	AccessKeySecret flagext.Secret `yaml:"access_key_secret"`
+}
+
+// Validate validates the OSS config and returns an error on failure.
+func (cfg *Config) Validate() error {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.AccessKeySecret.String() == "" {
+		return errors.New("invalid oss configuration, endpoint, bucket, access_key_id and access_key_secret must be set")
+	}
+	return nil
+}
+
+// RegisterFlags registers the flags for OSS storage
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", f)
+}
+
+// RegisterFlagsWithPrefix registers the flags for OSS storage with the provided prefix
+func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&cfg.Endpoint, prefix+"oss.endpoint", "", "OSS endpoint, for example https://oss-cn-hangzhou.aliyuncs.com")
+	f.StringVar(&cfg.Bucket, prefix+"oss.bucket", "", "OSS bucket name")
+	f.StringVar(&cfg.AccessKeyID, prefix+"oss.access-key-id", "", "OSS access key ID")
+	f.Var(&cfg.AccessKeySecret, prefix+"oss.access-key-secret", "OSS access key secret")
+}
@@ -19,7 +19,11 @@ func NewBucketClient(cfg Config, name string, logger log.Logger) (objstore.Bucke
 		return nil, err
 	}
 
-	return s3.NewBucketWithConfig(logger, s3Cfg, name)
+	bkt, err := s3.NewBucketWithConfig(logger, s3Cfg, name)
+	if err != nil || !cfg.TagObjects {
+		return bkt, err
+	}
+	return newTaggingBucket(bkt, cfg)
 }
 
 // NewBucketReaderClient creates a new S3 bucket client
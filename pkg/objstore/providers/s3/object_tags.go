@@ -0,0 +1,109 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+type objectTagsKey struct{}
+
+// ContextWithObjectTags returns a context with the tags of the objects
+// uploaded with it, added to the tags already in the context.
+func ContextWithObjectTags(ctx context.Context, objectTags map[string]string) context.Context {
+	merged := make(map[string]string, len(objectTags))
+	for k, v := range ObjectTagsFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range objectTags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, objectTagsKey{}, merged)
+}
+
+// ObjectTagsFromContext returns the tags of the objects uploaded with the
+// context.
+func ObjectTagsFromContext(ctx context.Context) map[string]string {
+	t, _ := ctx.Value(objectTagsKey{}).(map[string]string)
+	return t
+}
+
+// taggingBucket tags the objects uploaded with the tags of the context of
+// the upload. The Thanos client doesn't send tags with the upload, so they
+// are set with a PutObjectTagging request once the object is written.
+type taggingBucket struct {
+	objstore.Bucket
+	client     *minio.Client
+	bucketName string
+}
+
+func newTaggingBucket(bkt objstore.Bucket, cfg Config) (objstore.Bucket, error) {
+	var creds *credentials.Credentials
+	if cfg.AccessKeyID != "" {
+		signerType := credentials.SignatureV4
+		if cfg.SignatureVersion == SignatureVersionV2 {
+			signerType = credentials.SignatureV2
+		}
+		creds = credentials.NewStatic(cfg.AccessKeyID, cfg.SecretAccessKey.String(), "", signerType)
+	} else {
+		// The credentials are looked up the same way as the Thanos client does.
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: cfg.HTTP.Transport,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create object tagging client")
+	}
+	return &taggingBucket{Bucket: bkt, client: client, bucketName: cfg.BucketName}, nil
+}
+
+func (b *taggingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.Bucket.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	objectTags := ObjectTagsFromContext(ctx)
+	if len(objectTags) == 0 {
+		return nil
+	}
+	sanitized := make(map[string]string, len(objectTags))
+	for k, v := range objectTags {
+		sanitized[sanitizeTag(k)] = sanitizeTag(v)
+	}
+	t, err := tags.NewTags(sanitized, true)
+	if err != nil {
+		return errors.Wrapf(err, "invalid tags of %s", name)
+	}
+	if err = b.client.PutObjectTagging(ctx, b.bucketName, name, t, minio.PutObjectTaggingOptions{}); err != nil {
+		return errors.Wrapf(err, "tag %s", name)
+	}
+	return nil
+}
+
+// sanitizeTag replaces the characters S3 doesn't accept in tags.
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune(" +-=._:/@", r):
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,23 @@
+package s3
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithObjectTags(t *testing.T) {
+	ctx := ContextWithObjectTags(context.Background(), map[string]string{"tenant": "a", "retention-class": "short"})
+	ctx = ContextWithObjectTags(ctx, map[string]string{"retention-class": "long", "block-min-time": "2023-01-01T00:00:00Z"})
+	assert.Equal(t, map[string]string{
+		"tenant":          "a",
+		"retention-class": "long",
+		"block-min-time":  "2023-01-01T00:00:00Z",
+	}, ObjectTagsFromContext(ctx))
+	assert.Nil(t, ObjectTagsFromContext(context.Background()))
+}
+
+func TestSanitizeTag(t *testing.T) {
+	assert.Equal(t, "team-a_b:c/d@e.f", sanitizeTag("team-a!b:c/d@e.f"))
+}
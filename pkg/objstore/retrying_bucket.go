@@ -0,0 +1,216 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+)
+
+// RetryPolicy configures how a class of bucket operations is retried.
+type RetryPolicy struct {
+	MaxRetries int           `yaml:"max_retries" category:"advanced"`
+	MinBackoff time.Duration `yaml:"min_backoff" category:"advanced"`
+	MaxBackoff time.Duration `yaml:"max_backoff" category:"advanced"`
+}
+
+func (cfg *RetryPolicy) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix, operations string) {
+	f.IntVar(&cfg.MaxRetries, prefix+"max-retries", 0, fmt.Sprintf("Maximum number of times %s are retried after a failure. 0 to disable the retries.", operations))
+	f.DurationVar(&cfg.MinBackoff, prefix+"min-backoff", 100*time.Millisecond, fmt.Sprintf("Minimum time to wait before retrying %s.", operations))
+	f.DurationVar(&cfg.MaxBackoff, prefix+"max-backoff", 5*time.Second, fmt.Sprintf("Maximum time to wait before retrying %s.", operations))
+}
+
+func (cfg *RetryPolicy) Validate() error {
+	if cfg.MaxRetries < 0 {
+		return errors.New("max retries must not be negative")
+	}
+	if cfg.MaxRetries == 0 {
+		return nil
+	}
+	if cfg.MinBackoff <= 0 {
+		return errors.New("min backoff must be positive")
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		return errors.New("max backoff must be greater than or equal to min backoff")
+	}
+	return nil
+}
+
+// RetryConfig configures the retries of the bucket operations. These are
+// retried on top of the retries of the storage backend client, if any.
+type RetryConfig struct {
+	// Read applies to Get, GetRange, Exists, Attributes and Iter.
+	Read RetryPolicy `yaml:"read"`
+	// Write applies to Upload and Delete.
+	Write RetryPolicy `yaml:"write"`
+}
+
+func (cfg *RetryConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	cfg.Read.RegisterFlagsWithPrefix(f, prefix+"read.", "object reads and listings")
+	cfg.Write.RegisterFlagsWithPrefix(f, prefix+"write.", "object uploads and deletions")
+}
+
+func (cfg *RetryConfig) Validate() error {
+	if err := cfg.Read.Validate(); err != nil {
+		return fmt.Errorf("invalid read retry policy: %w", err)
+	}
+	if err := cfg.Write.Validate(); err != nil {
+		return fmt.Errorf("invalid write retry policy: %w", err)
+	}
+	return nil
+}
+
+// RetryingBucket retries the bucket operations which failed, according to
+// the retry policy of their class. Objects not found are never retried.
+type RetryingBucket struct {
+	objstore.Bucket
+	cfg     RetryConfig
+	retries *prometheus.CounterVec
+}
+
+// NewRetryingBucket wraps the bucket with retries. The bucket is returned
+// as is if no retries are configured.
+func NewRetryingBucket(bkt objstore.Bucket, cfg RetryConfig, reg prometheus.Registerer) objstore.Bucket {
+	if cfg.Read.MaxRetries == 0 && cfg.Write.MaxRetries == 0 {
+		return bkt
+	}
+	return &RetryingBucket{
+		Bucket: bkt,
+		cfg:    cfg,
+		retries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_objstore_request_retries_total",
+			Help: "Total number of bucket operations retried.",
+		}, []string{"operation"}),
+	}
+}
+
+func (b *RetryingBucket) Get(ctx context.Context, name string) (rc io.ReadCloser, err error) {
+	err = b.retry(ctx, "get", b.cfg.Read, func() error {
+		rc, err = b.Bucket.Get(ctx, name)
+		return err
+	})
+	return rc, err
+}
+
+func (b *RetryingBucket) GetRange(ctx context.Context, name string, off, length int64) (rc io.ReadCloser, err error) {
+	err = b.retry(ctx, "get_range", b.cfg.Read, func() error {
+		rc, err = b.Bucket.GetRange(ctx, name, off, length)
+		return err
+	})
+	return rc, err
+}
+
+func (b *RetryingBucket) Exists(ctx context.Context, name string) (exists bool, err error) {
+	err = b.retry(ctx, "exists", b.cfg.Read, func() error {
+		exists, err = b.Bucket.Exists(ctx, name)
+		return err
+	})
+	return exists, err
+}
+
+func (b *RetryingBucket) Attributes(ctx context.Context, name string) (attrs objstore.ObjectAttributes, err error) {
+	err = b.retry(ctx, "attributes", b.cfg.Read, func() error {
+		attrs, err = b.Bucket.Attributes(ctx, name)
+		return err
+	})
+	return attrs, err
+}
+
+func (b *RetryingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	var called bool
+	return b.retry(ctx, "iter", b.cfg.Read, func() error {
+		if called {
+			// The listing can't be resumed, and f must not be called twice
+			// for the same object.
+			return errNotRetryable
+		}
+		return b.Bucket.Iter(ctx, dir, func(name string) error {
+			called = true
+			return f(name)
+		}, options...)
+	})
+}
+
+func (b *RetryingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// The upload can only be retried if the content can be read again.
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	var attempted bool
+	return b.retry(ctx, "upload", b.cfg.Write, func() error {
+		if attempted {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return errNotRetryable
+			}
+		}
+		attempted = true
+		return b.Bucket.Upload(ctx, name, r)
+	})
+}
+
+func (b *RetryingBucket) Delete(ctx context.Context, name string) error {
+	return b.retry(ctx, "delete", b.cfg.Write, func() error {
+		return b.Bucket.Delete(ctx, name)
+	})
+}
+
+// errNotRetryable is returned by an attempt which can't be made. The error of
+// the previous attempt is returned instead.
+var errNotRetryable = errors.New("not retryable")
+
+func (b *RetryingBucket) retry(ctx context.Context, op string, policy RetryPolicy, fn func() error) error {
+	err := fn()
+	if policy.MaxRetries == 0 {
+		return err
+	}
+	// The number of retries is bounded here: a dskit backoff with no
+	// maximum only computes the delays.
+	boff := backoff.New(ctx, backoff.Config{MinBackoff: policy.MinBackoff, MaxBackoff: policy.MaxBackoff})
+	for err != nil && b.isRetryable(ctx, err) && boff.NumRetries() < policy.MaxRetries {
+		boff.Wait()
+		if ctx.Err() != nil {
+			return err
+		}
+		b.retries.WithLabelValues(op).Inc()
+		retryErr := fn()
+		if errors.Is(retryErr, errNotRetryable) {
+			return err
+		}
+		err = retryErr
+	}
+	return err
+}
+
+func (b *RetryingBucket) isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return !b.Bucket.IsObjNotFoundErr(err) && !b.Bucket.IsCustomerManagedKeyError(err)
+}
+
+// WithExpectedErrs implements objstore.InstrumentedBucket.
+func (b *RetryingBucket) WithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	if ib, ok := b.Bucket.(objstore.InstrumentedBucket); ok {
+		c := *b
+		c.Bucket = ib.WithExpectedErrs(fn)
+		return &c
+	}
+	return b
+}
+
+// ReaderWithExpectedErrs implements objstore.InstrumentedBucket.
+func (b *RetryingBucket) ReaderWithExpectedErrs(fn objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.WithExpectedErrs(fn)
+}
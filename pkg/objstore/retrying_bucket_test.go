@@ -0,0 +1,71 @@
+package objstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+// flakyBucket fails the first requests of each operation.
+type flakyBucket struct {
+	objstore.Bucket
+	failures int
+	gets     int
+	uploads  int
+}
+
+func (b *flakyBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.gets++
+	if b.gets <= b.failures {
+		return nil, errors.New("unavailable")
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *flakyBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.uploads++
+	if b.uploads <= b.failures {
+		// Consume the content, as a failed upload would.
+		_, _ = io.Copy(io.Discard, r)
+		return errors.New("unavailable")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func Test_RetryingBucket(t *testing.T) {
+	ctx := context.Background()
+	policy := phlareobj.RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	flaky := &flakyBucket{Bucket: objstore.NewInMemBucket(), failures: 2}
+	b := phlareobj.NewRetryingBucket(flaky, phlareobj.RetryConfig{Read: policy, Write: policy}, prometheus.NewRegistry())
+
+	// The content is uploaded again from the start.
+	require.NoError(t, b.Upload(ctx, "block/meta.json", bytes.NewReader([]byte("{}"))))
+	require.Equal(t, 3, flaky.uploads)
+
+	rc, err := b.Get(ctx, "block/meta.json")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(data))
+	require.Equal(t, 3, flaky.gets)
+
+	// Objects not found are not retried.
+	_, err = b.Get(ctx, "missing")
+	require.True(t, flaky.IsObjNotFoundErr(err))
+	require.Equal(t, 4, flaky.gets)
+
+	// Requests failing more than the max retries fail.
+	flaky.gets, flaky.failures = 0, 3
+	_, err = b.Get(ctx, "block/meta.json")
+	require.EqualError(t, err, "unavailable")
+	require.Equal(t, 3, flaky.gets)
+}
@@ -0,0 +1,176 @@
+package cpuprofile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/pyroscope/pkg/og/ingestion"
+	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+	"github.com/grafana/pyroscope/pkg/og/storage/tree"
+)
+
+// RawProfile implements ingestion.RawProfile for Chrome DevTools CPU
+// profiles (.cpuprofile), as produced by V8 and Node.js --cpu-prof.
+type RawProfile struct {
+	RawData []byte
+}
+
+type cpuProfile struct {
+	Nodes      []node  `json:"nodes"`
+	StartTime  int64   `json:"startTime"`
+	EndTime    int64   `json:"endTime"`
+	Samples    []int64 `json:"samples"`
+	TimeDeltas []int64 `json:"timeDeltas"`
+}
+
+type node struct {
+	ID        int64     `json:"id"`
+	CallFrame callFrame `json:"callFrame"`
+	HitCount  int64     `json:"hitCount"`
+	Children  []int64   `json:"children"`
+}
+
+type callFrame struct {
+	FunctionName string `json:"functionName"`
+	URL          string `json:"url"`
+	LineNumber   int64  `json:"lineNumber"`
+}
+
+func (f callFrame) name() string {
+	name := f.FunctionName
+	if name == "" {
+		name = "(anonymous)"
+	}
+	if f.URL == "" {
+		return name
+	}
+	// Line numbers are zero-based.
+	return fmt.Sprintf("%s %s:%d", name, f.URL, f.LineNumber+1)
+}
+
+// Parse parses a profile
+func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage.MetricsExporter, md ingestion.Metadata) error {
+	input, err := parse(p.RawData, md)
+	if err != nil {
+		return err
+	}
+	return putter.Put(ctx, input)
+}
+
+func parse(rawData []byte, md ingestion.Metadata) (*storage.PutInput, error) {
+	var prof cpuProfile
+	if err := json.Unmarshal(rawData, &prof); err != nil {
+		return nil, err
+	}
+	if len(prof.Nodes) == 0 {
+		return nil, fmt.Errorf("cpuprofile: no nodes found")
+	}
+	stacks, err := nodeStacks(prof.Nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &storage.PutInput{
+		StartTime:       md.StartTime,
+		EndTime:         md.EndTime,
+		SpyName:         md.SpyName,
+		SampleRate:      md.SampleRate,
+		Key:             md.Key,
+		AggregationType: metadata.SumAggregationType,
+	}
+	tr := tree.New()
+	if len(prof.Samples) == 0 {
+		// Older profiles only include the hit count of the nodes.
+		input.Units = metadata.SamplesUnits
+		for _, n := range prof.Nodes {
+			if s := stacks[n.ID]; n.HitCount > 0 && len(s) > 0 {
+				tr.InsertStackString(s, uint64(n.HitCount))
+			}
+		}
+		input.Val = tr
+		return input, nil
+	}
+
+	if len(prof.TimeDeltas) != len(prof.Samples) {
+		return nil, fmt.Errorf("cpuprofile: unequal lengths of samples and time deltas: %d != %d", len(prof.Samples), len(prof.TimeDeltas))
+	}
+	// The time delta of a sample is the time elapsed since the previous
+	// one: the time spent in a stack is the delta of the next sample.
+	// Values are in microseconds.
+	input.Units = metadata.NanosecondsUnits
+	ts := prof.StartTime
+	for i, id := range prof.Samples {
+		ts += prof.TimeDeltas[i]
+		next := prof.EndTime
+		if i+1 < len(prof.Samples) {
+			next = ts + prof.TimeDeltas[i+1]
+		}
+		s, ok := stacks[id]
+		if !ok {
+			return nil, fmt.Errorf("cpuprofile: invalid node %d", id)
+		}
+		if d := next - ts; d > 0 && len(s) > 0 {
+			tr.InsertStackString(s, uint64(d)*1000)
+		}
+	}
+	input.Val = tr
+	return input, nil
+}
+
+// nodeStacks returns the stack trace of every node, root first. The root
+// node and idle nodes have no stacks.
+func nodeStacks(nodes []node) (map[int64][]string, error) {
+	byID := make(map[int64]*node, len(nodes))
+	parents := make(map[int64]int64, len(nodes))
+	for i := range nodes {
+		n := &nodes[i]
+		byID[n.ID] = n
+		for _, c := range n.Children {
+			parents[c] = n.ID
+		}
+	}
+	stacks := make(map[int64][]string, len(nodes))
+	var resolve func(id int64, depth int) ([]string, error)
+	resolve = func(id int64, depth int) ([]string, error) {
+		if s, ok := stacks[id]; ok {
+			return s, nil
+		}
+		if depth > len(nodes) {
+			return nil, fmt.Errorf("cpuprofile: cycle in node tree")
+		}
+		n, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("cpuprofile: invalid node %d", id)
+		}
+		var stack []string
+		if parent, ok := parents[id]; ok {
+			s, err := resolve(parent, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) > 0 || n.CallFrame.FunctionName != "(idle)" {
+				stack = append(append(make([]string, 0, len(s)+1), s...), n.CallFrame.name())
+			}
+		}
+		stacks[id] = stack
+		return stack, nil
+	}
+	for _, n := range nodes {
+		if _, err := resolve(n.ID, 0); err != nil {
+			return nil, err
+		}
+	}
+	return stacks, nil
+}
+
+// Bytes returns the raw bytes of the profile
+func (p *RawProfile) Bytes() ([]byte, error) {
+	return p.RawData, nil
+}
+
+// ContentType returns the HTTP ContentType of the profile
+func (*RawProfile) ContentType() string {
+	return "application/json"
+}
@@ -0,0 +1,69 @@
+package cpuprofile
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/og/ingestion"
+	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
+)
+
+type mockIngester struct{ actual []*storage.PutInput }
+
+func (m *mockIngester) Put(_ context.Context, p *storage.PutInput) error {
+	m.actual = append(m.actual, p)
+	return nil
+}
+
+func Test_Parse(t *testing.T) {
+	data, err := os.ReadFile("testdata/simple.cpuprofile")
+	require.NoError(t, err)
+	key, err := segment.ParseKey("foo")
+	require.NoError(t, err)
+
+	ingester := new(mockIngester)
+	profile := &RawProfile{RawData: data}
+	err = profile.Parse(context.Background(), ingester, nil, ingestion.Metadata{Key: key, SampleRate: 100})
+	require.NoError(t, err)
+
+	require.Len(t, ingester.actual, 1)
+	input := ingester.actual[0]
+	assert.Equal(t, metadata.NanosecondsUnits, input.Units)
+	assert.Equal(t, `(program) 5000000
+main file:///app/index.js:1;(anonymous) file:///app/index.js:20 15000000
+main file:///app/index.js:1;fib file:///app/index.js:10 30000000
+`, input.Val.String())
+}
+
+func Test_Parse_HitCount(t *testing.T) {
+	data := []byte(`{"nodes": [
+		{"id": 1, "callFrame": {"functionName": "(root)"}, "children": [2]},
+		{"id": 2, "callFrame": {"functionName": "main"}, "hitCount": 3, "children": [3]},
+		{"id": 3, "callFrame": {"functionName": "foo"}, "hitCount": 1}
+	]}`)
+	key, err := segment.ParseKey("foo")
+	require.NoError(t, err)
+
+	input, err := parse(data, ingestion.Metadata{Key: key})
+	require.NoError(t, err)
+	assert.Equal(t, metadata.SamplesUnits, input.Units)
+	assert.Equal(t, "main 3\nmain;foo 1\n", input.Val.String())
+}
+
+func Test_Parse_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`{"nodes": [{"id": 1}], "samples": [1], "timeDeltas": []}`,
+		`{"nodes": [{"id": 1}], "samples": [2], "timeDeltas": [0]}`,
+		`{"nodes": [{"id": 1, "children": [2]}, {"id": 2, "children": [1]}]}`,
+	} {
+		_, err := parse([]byte(data), ingestion.Metadata{})
+		assert.Error(t, err, data)
+	}
+}
@@ -1,29 +1,98 @@
 package jfr
 
 import (
+	"encoding/binary"
 	"fmt"
-	phlaremodel "github.com/grafana/pyroscope/pkg/distributor/model"
 	"io"
+	"time"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/distributor/model"
 
 	"github.com/grafana/jfr-parser/parser"
 	"github.com/grafana/pyroscope/pkg/og/storage"
 )
 
+const (
+	chunkHeaderSize = 68
+	chunkMagic      = 0x464c5200
+)
+
+// chunk is a self-contained part of a JFR recording: each chunk has its own
+// metadata and constant pools, and covers its own time range.
+type chunk struct {
+	data          []byte
+	startNanos    int64
+	durationNanos int64
+}
+
+// splitChunks splits the JFR recording into chunks. Long-running recordings
+// (e.g. streamed by async-profiler) consist of many chunks, and each of them
+// may be uploaded as soon as it is complete.
+func splitChunks(body []byte) ([]chunk, error) {
+	var chunks []chunk
+	for pos := 0; pos < len(body); {
+		if len(body)-pos < chunkHeaderSize {
+			return nil, fmt.Errorf("jfr chunk at offset %d: %w", pos, io.ErrUnexpectedEOF)
+		}
+		h := body[pos:]
+		if magic := binary.BigEndian.Uint32(h); magic != chunkMagic {
+			return nil, fmt.Errorf("jfr chunk at offset %d: invalid magic: %x", pos, magic)
+		}
+		size := binary.BigEndian.Uint64(h[8:])
+		if size < chunkHeaderSize || size > uint64(len(h)) {
+			return nil, fmt.Errorf("jfr chunk at offset %d: incomplete chunk of size %d", pos, size)
+		}
+		chunks = append(chunks, chunk{
+			data:          h[:size],
+			startNanos:    int64(binary.BigEndian.Uint64(h[32:])),
+			durationNanos: int64(binary.BigEndian.Uint64(h[40:])),
+		})
+		pos += int(size)
+	}
+	return chunks, nil
+}
+
+// ParseJFR converts the JFR recording to pprof profiles. If the recording
+// consists of multiple chunks, every chunk is converted into separate
+// profiles, timestamped with the chunk start time and duration.
 func ParseJFR(body []byte, pi *storage.PutInput, jfrLabels *LabelsSnapshot) (req *phlaremodel.PushRequest, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("jfr parser panic: %v", r)
 		}
 	}()
-	p := parser.NewParser(body, parser.Options{
+	chunks, err := splitChunks(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) <= 1 {
+		req, _, err = parse(newParser(body), pi, jfrLabels, "")
+		return req, err
+	}
+	req = new(phlaremodel.PushRequest)
+	var event string
+	for _, c := range chunks {
+		chunkInput := *pi
+		chunkInput.StartTime = time.Unix(0, c.startNanos)
+		chunkInput.EndTime = chunkInput.StartTime.Add(time.Duration(c.durationNanos))
+		var chunkReq *phlaremodel.PushRequest
+		// Recording settings are not necessarily repeated in every
+		// chunk, therefore the event is carried over.
+		if chunkReq, event, err = parse(newParser(c.data), &chunkInput, jfrLabels, event); err != nil {
+			return nil, err
+		}
+		req.Series = append(req.Series, chunkReq.Series...)
+	}
+	return req, nil
+}
+
+func newParser(body []byte) *parser.Parser {
+	return parser.NewParser(body, parser.Options{
 		SymbolProcessor: processSymbols,
 	})
-	return parse(p, pi, jfrLabels)
 }
 
-func parse(parser *parser.Parser, piOriginal *storage.PutInput, jfrLabels *LabelsSnapshot) (req *phlaremodel.PushRequest, err error) {
-	var event string
-
+func parse(parser *parser.Parser, piOriginal *storage.PutInput, jfrLabels *LabelsSnapshot, event string) (req *phlaremodel.PushRequest, _ string, err error) {
 	builders := newJfrPprofBuilders(parser, jfrLabels, piOriginal)
 
 	var values = [2]int64{1, 0}
@@ -34,7 +103,7 @@ func parse(parser *parser.Parser, piOriginal *storage.PutInput, jfrLabels *Label
 			if err == io.EOF {
 				break
 			}
-			return nil, fmt.Errorf("jfr parser ParseEvent error: %w", err)
+			return nil, "", fmt.Errorf("jfr parser ParseEvent error: %w", err)
 		}
 
 		switch typ {
@@ -70,5 +139,5 @@ func parse(parser *parser.Parser, piOriginal *storage.PutInput, jfrLabels *Label
 
 	req = builders.build(event)
 
-	return req, err
+	return req, event, err
 }
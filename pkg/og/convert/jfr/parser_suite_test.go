@@ -222,6 +222,48 @@ func compareWithJson(t *testing.T, req *model2.PushRequest, file string) error {
 	return nil
 }
 
+func TestParseChunkedJFR(t *testing.T) {
+	var body []byte
+	for _, f := range []string{
+		"testdata/cortex-dev-01__kafka-0__cpu__0.jfr.gz",
+		"testdata/cortex-dev-01__kafka-0__cpu__1.jfr.gz",
+	} {
+		jfr, err := bench.ReadGzipFile(f)
+		require.NoError(t, err)
+		body = append(body, jfr...)
+	}
+	chunks, err := splitChunks(body)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+
+	k, err := segment.ParseKey("kafka.app")
+	require.NoError(t, err)
+	pi := &storage.PutInput{
+		StartTime:  time.UnixMilli(1000),
+		EndTime:    time.UnixMilli(2000),
+		Key:        k,
+		SpyName:    "java",
+		SampleRate: 100,
+	}
+	req, err := ParseJFR(body, pi, nil)
+	require.NoError(t, err)
+
+	timestamps := make(map[int64]int64)
+	for _, s := range req.Series {
+		for _, sample := range s.Samples {
+			timestamps[sample.Profile.TimeNanos] = sample.Profile.DurationNanos
+		}
+	}
+	assert.Equal(t, map[int64]int64{
+		chunks[0].startNanos: chunks[0].durationNanos,
+		chunks[1].startNanos: chunks[1].durationNanos,
+	}, timestamps)
+	assert.Equal(t, time.UnixMilli(1000), pi.StartTime)
+
+	_, err = ParseJFR(body[:len(body)-1], pi, nil)
+	require.Error(t, err)
+}
+
 func BenchmarkParser(b *testing.B) {
 	tests := []string{
 		"testdata/cortex-dev-01__kafka-0__cpu__0.jfr.gz",
@@ -0,0 +1,613 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// The nettrace format (EventPipe V4 and V5) is described in
+// https://github.com/microsoft/perfview/blob/main/src/TraceEvent/EventPipe/EventPipeFormat.md
+
+const (
+	magic               = "Nettrace"
+	serializationHeader = "!FastSerialization.1"
+
+	tagNullReference      = 1
+	tagBeginPrivateObject = 5
+	tagEndObject          = 6
+
+	maxTraceVersion = 5
+
+	blockFlagCompressedHeaders = 1
+
+	headerFlagMetadataID               = 1 << 0
+	headerFlagCaptureThreadAndSequence = 1 << 1
+	headerFlagThreadID                 = 1 << 2
+	headerFlagStackID                  = 1 << 3
+	headerFlagActivityID               = 1 << 4
+	headerFlagRelatedActivityID        = 1 << 5
+	headerFlagDataLength               = 1 << 7
+
+	providerSampleProfiler = "Microsoft-DotNETCore-SampleProfiler"
+	providerRuntime        = "Microsoft-Windows-DotNETRuntime"
+	providerRundown        = "Microsoft-Windows-DotNETRuntimeRundown"
+
+	eventThreadSample     = 0
+	eventGCAllocationTick = 10
+	// MethodLoadVerbose and MethodUnloadVerbose of the runtime provider,
+	// and MethodDCStartVerbose and MethodDCEndVerbose of the rundown
+	// provider share the same event IDs and payload.
+	eventMethodVerboseStart = 143
+	eventMethodVerboseEnd   = 144
+
+	threadSampleManaged = 2
+
+	defaultSamplingInterval = 1000000 // 1ms
+
+	unknownFrame = "[unknown]"
+)
+
+var errTruncated = errors.New("nettrace: truncated")
+
+type eventMetadata struct {
+	provider string
+	eventID  int32
+	version  int32
+}
+
+type eventHeader struct {
+	metadataID     int32
+	sequenceNumber int32
+	threadID       uint64
+	stackID        int32
+	timestamp      int64
+	payloadSize    int32
+}
+
+type method struct {
+	start uint64
+	size  uint64
+	name  string
+}
+
+// stackSample aggregates the values of the samples sharing a stack.
+type stackSample struct {
+	addrs  []uint64
+	values [2]int64
+}
+
+type samples struct {
+	stacks map[string]*stackSample
+	order  []*stackSample
+}
+
+func (s *samples) add(addrs []uint64, count, value int64) {
+	k := make([]byte, 0, len(addrs)*8)
+	for _, a := range addrs {
+		k = binary.LittleEndian.AppendUint64(k, a)
+	}
+	x, ok := s.stacks[string(k)]
+	if !ok {
+		x = &stackSample{addrs: addrs}
+		s.stacks[string(k)] = x
+		s.order = append(s.order, x)
+	}
+	x.values[0] += count
+	x.values[1] += value
+}
+
+// traceProfile is a profile of a single kind of events found in the trace.
+type traceProfile struct {
+	name    string
+	profile *profilev1.Profile
+}
+
+type parser struct {
+	d decoder
+
+	pointerSize      int
+	samplingInterval int64
+
+	metadata map[int32]*eventMetadata
+	stacks   map[int32][]uint64
+	methods  []method
+
+	cpu    samples
+	allocs samples
+}
+
+// parseTrace converts the nettrace file (dotnet-trace output) to pprof
+// profiles: CPU samples are collected from the SampleProfiler events and
+// allocations from the GCAllocationTick events. Frames are resolved to
+// the managed methods reported by the method load and rundown events;
+// native frames are not symbolized.
+func parseTrace(buf []byte) ([]*traceProfile, error) {
+	p := &parser{
+		d:                decoder{buf: buf},
+		samplingInterval: defaultSamplingInterval,
+		metadata:         make(map[int32]*eventMetadata),
+		stacks:           make(map[int32][]uint64),
+		cpu:              samples{stacks: make(map[string]*stackSample)},
+		allocs:           samples{stacks: make(map[string]*stackSample)},
+	}
+	if err := p.parseHeader(); err != nil {
+		return nil, err
+	}
+	if err := p.parseObjects(); err != nil {
+		return nil, err
+	}
+	sort.Slice(p.methods, func(i, j int) bool { return p.methods[i].start < p.methods[j].start })
+
+	var res []*traceProfile
+	if len(p.cpu.order) > 0 {
+		b := newProfileBuilder(p.methods,
+			[]string{"samples", "count", "cpu", "nanoseconds"},
+			"cpu", "nanoseconds", p.samplingInterval)
+		res = append(res, &traceProfile{name: "process_cpu", profile: b.build(p.cpu.order)})
+	}
+	if len(p.allocs.order) > 0 {
+		b := newProfileBuilder(p.methods,
+			[]string{"alloc_samples", "count", "alloc_space", "bytes"},
+			"space", "bytes", 0)
+		res = append(res, &traceProfile{name: "memory", profile: b.build(p.allocs.order)})
+	}
+	return res, nil
+}
+
+func (p *parser) parseHeader() error {
+	if !bytes.HasPrefix(p.d.buf, []byte(magic)) {
+		return errors.New("nettrace: invalid magic")
+	}
+	p.d.skip(len(magic))
+	if p.d.str(int(p.d.i32())) != serializationHeader {
+		if p.d.err != nil {
+			return p.d.err
+		}
+		return errors.New("nettrace: invalid serialization header")
+	}
+	return p.d.err
+}
+
+func (p *parser) parseObjects() error {
+	for {
+		switch tag := p.d.u8(); {
+		case p.d.err != nil:
+			return p.d.err
+		case tag == tagNullReference:
+			return nil
+		case tag != tagBeginPrivateObject:
+			return fmt.Errorf("nettrace: unexpected tag %d at offset %d", tag, p.d.pos-1)
+		}
+		name, version, err := p.parseType()
+		if err != nil {
+			return err
+		}
+		if name == "Trace" {
+			err = p.parseTraceObject(version)
+		} else {
+			err = p.parseBlock(name)
+		}
+		if err != nil {
+			return err
+		}
+		if p.d.u8() != tagEndObject {
+			if p.d.err != nil {
+				return p.d.err
+			}
+			return fmt.Errorf("nettrace: %s object is not terminated", name)
+		}
+	}
+}
+
+func (p *parser) parseType() (string, int32, error) {
+	if p.d.u8() != tagBeginPrivateObject || p.d.u8() != tagNullReference {
+		if p.d.err != nil {
+			return "", 0, p.d.err
+		}
+		return "", 0, errors.New("nettrace: invalid object type")
+	}
+	version := p.d.i32()
+	_ = p.d.i32() // Minimum reader version.
+	name := p.d.str(int(p.d.i32()))
+	if p.d.u8() != tagEndObject && p.d.err == nil {
+		return "", 0, errors.New("nettrace: invalid object type")
+	}
+	return name, version, p.d.err
+}
+
+func (p *parser) parseTraceObject(version int32) error {
+	if version > maxTraceVersion {
+		return fmt.Errorf("nettrace: unsupported version: %d", version)
+	}
+	p.d.skip(16) // Sync time (SYSTEMTIME).
+	p.d.skip(8)  // Sync time QPC.
+	p.d.skip(8)  // QPC frequency.
+	p.pointerSize = int(p.d.i32())
+	p.d.skip(4) // Process ID.
+	p.d.skip(4) // Number of processors.
+	if interval := p.d.i32(); interval > 0 {
+		p.samplingInterval = int64(interval)
+	}
+	if p.d.err != nil {
+		return p.d.err
+	}
+	if p.pointerSize != 4 && p.pointerSize != 8 {
+		return fmt.Errorf("nettrace: invalid pointer size: %d", p.pointerSize)
+	}
+	return nil
+}
+
+func (p *parser) parseBlock(name string) error {
+	size := int(p.d.i32())
+	// Block contents are aligned to 4 bytes.
+	p.d.align(4)
+	b := p.d.bytes(size)
+	if p.d.err != nil {
+		return p.d.err
+	}
+	if p.pointerSize == 0 {
+		return fmt.Errorf("nettrace: %s precedes the trace object", name)
+	}
+	switch name {
+	case "MetadataBlock":
+		return parseEvents(b, p.handleMetadata)
+	case "EventBlock":
+		return parseEvents(b, p.handleEvent)
+	case "StackBlock":
+		return p.parseStacks(b)
+	}
+	// Sequence points are not needed: stack IDs are unique within the
+	// trace, and unknown blocks are skipped.
+	return nil
+}
+
+func (p *parser) parseStacks(b []byte) error {
+	d := decoder{buf: b}
+	id := d.i32()
+	count := d.i32()
+	for i := int32(0); i < count && d.err == nil; i++ {
+		frames := d.bytes(int(d.i32()))
+		addrs := make([]uint64, 0, len(frames)/p.pointerSize)
+		fd := decoder{buf: frames}
+		for len(fd.buf)-fd.pos >= p.pointerSize {
+			addrs = append(addrs, fd.pointer(p.pointerSize))
+		}
+		p.stacks[id+i] = addrs
+	}
+	return d.err
+}
+
+func parseEvents(b []byte, fn func(*eventHeader, []byte) error) error {
+	d := decoder{buf: b}
+	headerSize := int(d.u16())
+	flags := d.u16()
+	d.skip(headerSize - 4)
+	var h eventHeader
+	for d.err == nil && d.pos < len(d.buf) {
+		var payload []byte
+		if flags&blockFlagCompressedHeaders != 0 {
+			payload = d.compressedEvent(&h)
+		} else {
+			payload = d.event(&h)
+		}
+		if d.err != nil {
+			break
+		}
+		if err := fn(&h, payload); err != nil {
+			return err
+		}
+	}
+	return d.err
+}
+
+func (p *parser) handleMetadata(_ *eventHeader, payload []byte) error {
+	d := decoder{buf: payload}
+	id := d.i32()
+	md := &eventMetadata{provider: d.utf16()}
+	md.eventID = d.i32()
+	_ = d.utf16() // Event name.
+	d.skip(8)     // Keywords.
+	md.version = d.i32()
+	if d.err != nil {
+		return fmt.Errorf("nettrace: invalid metadata: %w", d.err)
+	}
+	p.metadata[id] = md
+	return nil
+}
+
+func (p *parser) handleEvent(h *eventHeader, payload []byte) error {
+	md, ok := p.metadata[h.metadataID]
+	if !ok {
+		return nil
+	}
+	d := decoder{buf: payload}
+	switch {
+	case md.provider == providerSampleProfiler && md.eventID == eventThreadSample:
+		// Threads running native code, which includes the threads waiting
+		// in the runtime, are not accounted.
+		if d.u32() == threadSampleManaged && d.err == nil {
+			p.cpu.add(p.stacks[h.stackID], 1, p.samplingInterval)
+		}
+
+	case md.provider == providerRuntime && md.eventID == eventGCAllocationTick:
+		amount := int64(d.u32())
+		d.skip(4) // Allocation kind.
+		d.skip(2) // CLR instance ID.
+		if md.version >= 2 {
+			amount = int64(d.u64())
+		}
+		if d.err == nil {
+			p.allocs.add(p.stacks[h.stackID], 1, amount)
+		}
+
+	case (md.provider == providerRuntime || md.provider == providerRundown) &&
+		(md.eventID == eventMethodVerboseStart || md.eventID == eventMethodVerboseEnd):
+		d.skip(8) // Method ID.
+		d.skip(8) // Module ID.
+		m := method{start: d.u64(), size: uint64(d.u32())}
+		d.skip(4) // Method token.
+		d.skip(4) // Method flags.
+		namespace := d.utf16()
+		m.name = d.utf16()
+		if namespace != "" {
+			m.name = namespace + "." + m.name
+		}
+		if d.err == nil && m.size > 0 {
+			p.methods = append(p.methods, m)
+		}
+	}
+	return nil
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) skip(n int) {
+	if n < 0 || len(d.buf)-d.pos < n {
+		d.err = errTruncated
+		d.pos = len(d.buf)
+		return
+	}
+	d.pos += n
+}
+
+func (d *decoder) align(n int) {
+	if r := d.pos % n; r != 0 {
+		d.skip(n - r)
+	}
+}
+
+func (d *decoder) bytes(n int) []byte {
+	start := d.pos
+	d.skip(n)
+	if d.err != nil {
+		return nil
+	}
+	return d.buf[start:d.pos]
+}
+
+func (d *decoder) str(n int) string { return string(d.bytes(n)) }
+
+func (d *decoder) u8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) i32() int32 { return int32(d.u32()) }
+
+func (d *decoder) u64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) pointer(size int) uint64 {
+	if size == 4 {
+		return uint64(d.u32())
+	}
+	return d.u64()
+}
+
+func (d *decoder) varint() uint64 {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b := d.u8()
+		if d.err != nil {
+			return 0
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	d.err = errors.New("nettrace: invalid varint")
+	return 0
+}
+
+// utf16 reads a null-terminated UTF-16 string.
+func (d *decoder) utf16() string {
+	var s []uint16
+	for {
+		c := d.u16()
+		if c == 0 || d.err != nil {
+			return string(utf16.Decode(s))
+		}
+		s = append(s, c)
+	}
+}
+
+// event reads an event with an uncompressed header.
+func (d *decoder) event(h *eventHeader) []byte {
+	start := d.pos
+	size := int(d.i32())
+	h.metadataID = d.i32() & 0x7fffffff // The high bit is the sorted flag.
+	h.sequenceNumber = d.i32()
+	h.threadID = d.u64()
+	d.skip(8) // Capture thread ID.
+	d.skip(4) // Processor number.
+	h.stackID = d.i32()
+	h.timestamp = int64(d.u64())
+	d.skip(16) // Activity ID.
+	d.skip(16) // Related activity ID.
+	h.payloadSize = d.i32()
+	payload := d.bytes(int(h.payloadSize))
+	if d.err != nil {
+		return nil
+	}
+	if end := start + 4 + size; end > d.pos {
+		d.skip(end - d.pos)
+	}
+	d.align(4)
+	return payload
+}
+
+// compressedEvent reads an event with a header compressed against the
+// header of the previous event in the block.
+func (d *decoder) compressedEvent(h *eventHeader) []byte {
+	flags := d.u8()
+	if flags&headerFlagMetadataID != 0 {
+		h.metadataID = int32(d.varint())
+	}
+	if flags&headerFlagCaptureThreadAndSequence != 0 {
+		h.sequenceNumber += int32(d.varint()) + 1
+		_ = d.varint() // Capture thread ID.
+		_ = d.varint() // Processor number.
+	} else if h.metadataID != 0 {
+		h.sequenceNumber++
+	}
+	if flags&headerFlagThreadID != 0 {
+		h.threadID = d.varint()
+	}
+	if flags&headerFlagStackID != 0 {
+		h.stackID = int32(d.varint())
+	}
+	h.timestamp += int64(d.varint())
+	if flags&headerFlagActivityID != 0 {
+		d.skip(16)
+	}
+	if flags&headerFlagRelatedActivityID != 0 {
+		d.skip(16)
+	}
+	if flags&headerFlagDataLength != 0 {
+		h.payloadSize = int32(d.varint())
+	}
+	return d.bytes(int(h.payloadSize))
+}
+
+type profileBuilder struct {
+	profile *profilev1.Profile
+	methods []method
+
+	strings   map[string]int64
+	locations map[string]uint64
+}
+
+func newProfileBuilder(methods []method, sampleTypes []string, periodType, periodUnit string, period int64) *profileBuilder {
+	b := &profileBuilder{
+		profile:   profilev1.ProfileFromVTPool(),
+		methods:   methods,
+		strings:   make(map[string]int64),
+		locations: make(map[string]uint64),
+	}
+	b.string("")
+	for i := 0; i+1 < len(sampleTypes); i += 2 {
+		b.profile.SampleType = append(b.profile.SampleType, &profilev1.ValueType{
+			Type: b.string(sampleTypes[i]),
+			Unit: b.string(sampleTypes[i+1]),
+		})
+	}
+	b.profile.PeriodType = &profilev1.ValueType{Type: b.string(periodType), Unit: b.string(periodUnit)}
+	b.profile.Period = period
+	return b
+}
+
+func (b *profileBuilder) build(stacks []*stackSample) *profilev1.Profile {
+	for _, s := range stacks {
+		locations := make([]uint64, 0, len(s.addrs))
+		unknown := false
+		for _, addr := range s.addrs {
+			name := b.resolve(addr)
+			// Consecutive native frames are collapsed.
+			if name == unknownFrame {
+				if unknown {
+					continue
+				}
+				unknown = true
+			} else {
+				unknown = false
+			}
+			locations = append(locations, b.location(name))
+		}
+		if len(locations) == 0 {
+			locations = append(locations, b.location(unknownFrame))
+		}
+		b.profile.Sample = append(b.profile.Sample, &profilev1.Sample{
+			LocationId: locations,
+			Value:      []int64{s.values[0], s.values[1]},
+		})
+	}
+	return b.profile
+}
+
+func (b *profileBuilder) resolve(addr uint64) string {
+	i := sort.Search(len(b.methods), func(i int) bool { return b.methods[i].start+b.methods[i].size > addr })
+	if i < len(b.methods) && b.methods[i].start <= addr {
+		return b.methods[i].name
+	}
+	return unknownFrame
+}
+
+func (b *profileBuilder) string(s string) int64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = int64(len(b.profile.StringTable))
+		b.strings[s] = i
+		b.profile.StringTable = append(b.profile.StringTable, s)
+	}
+	return i
+}
+
+func (b *profileBuilder) location(name string) uint64 {
+	loc, ok := b.locations[name]
+	if !ok {
+		// Frames are not resolved to lines: every function has a single
+		// location, sharing its ID.
+		loc = uint64(len(b.profile.Location) + 1)
+		b.locations[name] = loc
+		b.profile.Function = append(b.profile.Function, &profilev1.Function{
+			Id:   loc,
+			Name: b.string(name),
+		})
+		b.profile.Location = append(b.profile.Location, &profilev1.Location{
+			Id:   loc,
+			Line: []*profilev1.Line{{FunctionId: loc}},
+		})
+	}
+	return loc
+}
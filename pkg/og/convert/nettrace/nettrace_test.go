@@ -0,0 +1,204 @@
+package nettrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/ingestion"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
+)
+
+// traceWriter writes a minimal nettrace file. Metadata events are written
+// with uncompressed headers and the other events with compressed headers.
+type traceWriter struct {
+	buf bytes.Buffer
+
+	metadata bytes.Buffer
+	events   bytes.Buffer
+	stacks   [][]uint64
+}
+
+func put(b *bytes.Buffer, fields ...any) {
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			_ = binary.Write(b, binary.LittleEndian, append(utf16.Encode([]rune(v)), 0))
+		default:
+			_ = binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+}
+
+func varint(b *bytes.Buffer, v uint64) {
+	b.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *traceWriter) object(name string, version int32, body func(*bytes.Buffer)) {
+	w.buf.WriteByte(tagBeginPrivateObject)
+	w.buf.WriteByte(tagBeginPrivateObject)
+	w.buf.WriteByte(tagNullReference)
+	put(&w.buf, version, version, int32(len(name)), []byte(name))
+	w.buf.WriteByte(tagEndObject)
+	body(&w.buf)
+	w.buf.WriteByte(tagEndObject)
+}
+
+func (w *traceWriter) block(name string, content []byte) {
+	w.object(name, 2, func(b *bytes.Buffer) {
+		put(b, int32(len(content)))
+		for b.Len()%4 != 0 {
+			b.WriteByte(0)
+		}
+		b.Write(content)
+	})
+}
+
+func (w *traceWriter) defineEvent(id int32, provider string, eventID, version int32) {
+	var payload bytes.Buffer
+	put(&payload, id, provider, eventID, "", int64(0), version, int32(4))
+	put(&w.metadata, int32(80-4+payload.Len()), int32(0), int32(0), uint64(1), uint64(1),
+		int32(0), int32(0), int64(0), [16]byte{}, [16]byte{}, int32(payload.Len()))
+	w.metadata.Write(payload.Bytes())
+	for w.metadata.Len()%4 != 0 {
+		w.metadata.WriteByte(0)
+	}
+}
+
+func (w *traceWriter) event(metadataID int32, stack []uint64, fields ...any) {
+	var payload bytes.Buffer
+	put(&payload, fields...)
+	var stackID uint64
+	if stack != nil {
+		w.stacks = append(w.stacks, stack)
+		stackID = uint64(len(w.stacks))
+	}
+	w.events.WriteByte(headerFlagMetadataID | headerFlagStackID | headerFlagDataLength)
+	varint(&w.events, uint64(metadataID))
+	varint(&w.events, stackID)
+	varint(&w.events, 10) // Timestamp delta.
+	varint(&w.events, uint64(payload.Len()))
+	w.events.Write(payload.Bytes())
+}
+
+func (w *traceWriter) method(metadataID int32, start uint64, size uint32, namespace, name string) {
+	w.event(metadataID, nil, uint64(1), uint64(1), start, size, uint32(0), uint32(0),
+		namespace, name, "void ()", uint16(0))
+}
+
+func eventBlock(flags uint16, events []byte) []byte {
+	var b bytes.Buffer
+	put(&b, uint16(20), flags, int64(0), int64(0))
+	b.Write(events)
+	return b.Bytes()
+}
+
+func (w *traceWriter) bytes() []byte {
+	w.buf.WriteString(magic)
+	put(&w.buf, int32(len(serializationHeader)), []byte(serializationHeader))
+	w.object("Trace", 4, func(b *bytes.Buffer) {
+		put(b, [16]byte{}, int64(0), int64(1e9), int32(8), int32(1), int32(4), int32(1000000))
+	})
+	w.block("MetadataBlock", eventBlock(0, w.metadata.Bytes()))
+	var stacks bytes.Buffer
+	put(&stacks, int32(1), int32(len(w.stacks)))
+	for _, s := range w.stacks {
+		put(&stacks, int32(len(s)*8), s)
+	}
+	w.block("StackBlock", stacks.Bytes())
+	w.block("EventBlock", eventBlock(blockFlagCompressedHeaders, w.events.Bytes()))
+	w.block("SPBlock", make([]byte, 12))
+	w.buf.WriteByte(tagNullReference)
+	return w.buf.Bytes()
+}
+
+func newTestTrace() *traceWriter {
+	var w traceWriter
+	w.defineEvent(1, providerSampleProfiler, eventThreadSample, 0)
+	w.defineEvent(2, providerRuntime, eventGCAllocationTick, 4)
+	w.defineEvent(3, providerRundown, eventMethodVerboseEnd, 1)
+	w.event(1, []uint64{0x1010, 0x2010}, uint32(threadSampleManaged))
+	w.event(1, []uint64{0x1010, 0x2010}, uint32(threadSampleManaged))
+	w.event(1, []uint64{0x9000, 0x9100, 0x3010, 0x2010}, uint32(threadSampleManaged))
+	w.event(1, []uint64{0x9000}, uint32(1)) // External.
+	w.event(2, []uint64{0x3010, 0x2010}, uint32(100000), uint32(0), uint16(0), uint64(102400))
+	w.method(3, 0x1000, 0x100, "App.Worker", "Compute")
+	w.method(3, 0x2000, 0x100, "App.Program", "Main")
+	w.method(3, 0x3000, 0x100, "", "Allocate")
+	return &w
+}
+
+func Test_ParseTrace(t *testing.T) {
+	profiles, err := parseTrace(newTestTrace().bytes())
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+
+	stacks := func(i int) map[string][]int64 {
+		p := profiles[i].profile
+		m := make(map[string][]int64)
+		for _, s := range p.Sample {
+			var stack string
+			for _, loc := range s.LocationId {
+				fn := p.Function[p.Location[loc-1].Line[0].FunctionId-1]
+				stack += p.StringTable[fn.Name] + ";"
+			}
+			m[stack] = s.Value
+		}
+		return m
+	}
+
+	assert.Equal(t, "process_cpu", profiles[0].name)
+	assert.Equal(t, int64(1000000), profiles[0].profile.Period)
+	assert.Equal(t, map[string][]int64{
+		"App.Worker.Compute;App.Program.Main;": {2, 2000000},
+		"[unknown];Allocate;App.Program.Main;": {1, 1000000},
+	}, stacks(0))
+
+	assert.Equal(t, "memory", profiles[1].name)
+	assert.Equal(t, map[string][]int64{
+		"Allocate;App.Program.Main;": {1, 102400},
+	}, stacks(1))
+}
+
+func Test_ParseTrace_Invalid(t *testing.T) {
+	b := newTestTrace().bytes()
+	_, err := parseTrace(b[:len(b)-20])
+	assert.Error(t, err)
+	_, err = parseTrace([]byte("not a nettrace file"))
+	assert.Error(t, err)
+}
+
+func Test_RawProfile_ParseToPprof(t *testing.T) {
+	key, err := segment.ParseKey("my-app{env=test}")
+	require.NoError(t, err)
+
+	p := &RawProfile{RawData: newTestTrace().bytes()}
+	req, err := p.ParseToPprof(context.Background(), ingestion.Metadata{
+		StartTime: time.Unix(10, 0),
+		EndTime:   time.Unix(20, 0),
+		Key:       key,
+		SpyName:   "dotnetspy",
+	})
+	require.NoError(t, err)
+	require.Len(t, req.Series, 2)
+	names := make([]string, 0, len(req.Series))
+	for _, s := range req.Series {
+		ls := phlaremodel.Labels(s.Labels)
+		names = append(names, ls.Get("__name__"))
+		assert.Equal(t, "my-app", ls.Get("service_name"))
+		assert.Equal(t, "test", ls.Get("env"))
+
+		profile := s.Samples[0].Profile
+		assert.Equal(t, int64(10e9), profile.TimeNanos)
+		assert.Equal(t, int64(10e9), profile.DurationNanos)
+		profile.Close()
+	}
+	assert.Equal(t, []string{"process_cpu", "memory"}, names)
+}
@@ -0,0 +1,664 @@
+package perf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// perf.data file layout is described in
+// https://github.com/torvalds/linux/blob/master/tools/perf/Documentation/perf.data-file-format.txt
+
+const (
+	dataMagic      = 0x32454c4946524550 // "PERFILE2"
+	dataHeaderSize = 104
+
+	recordMmap   = 1
+	recordComm   = 3
+	recordFork   = 7
+	recordSample = 9
+	recordMmap2  = 10
+
+	recordMiscMmapBuildID = 1 << 14
+	recordMiscBuildIDSize = 1 << 15
+
+	sampleIP         = 1 << 0
+	sampleTID        = 1 << 1
+	sampleTime       = 1 << 2
+	sampleAddr       = 1 << 3
+	sampleRead       = 1 << 4
+	sampleCallchain  = 1 << 5
+	sampleID         = 1 << 6
+	sampleCPU        = 1 << 7
+	samplePeriod     = 1 << 8
+	sampleStreamID   = 1 << 9
+	sampleIdentifier = 1 << 16
+
+	formatTotalTimeEnabled = 1 << 0
+	formatTotalTimeRunning = 1 << 1
+	formatID               = 1 << 2
+	formatGroup            = 1 << 3
+	formatLost             = 1 << 4
+
+	attrFlagFreq = 1 << 10
+
+	featureBuildID = 2
+
+	// Callchain entries greater than contextMax are context markers
+	// (PERF_CONTEXT_KERNEL, PERF_CONTEXT_USER, etc.), not addresses.
+	contextMax = ^uint64(0) - 4095 + 1
+
+	kernelPID = ^uint32(0)
+
+	unknownFrame = "[unknown]"
+)
+
+var (
+	errDataTruncated = errors.New("perf.data: truncated")
+	errPipeMode      = errors.New("perf.data: pipe mode is not supported")
+)
+
+type fileSection struct {
+	offset uint64
+	size   uint64
+}
+
+type eventAttr struct {
+	typ          uint32
+	config       uint64
+	samplePeriod uint64
+	sampleType   uint64
+	readFormat   uint64
+	flags        uint64
+}
+
+// name returns the event name for the generic hardware and software events.
+func (a *eventAttr) name() string {
+	names := map[uint32][]string{
+		0: {"cycles", "instructions", "cache-references", "cache-misses", "branch-instructions", "branch-misses", "bus-cycles"},
+		1: {"cpu-clock", "task-clock", "page-faults", "context-switches", "cpu-migrations"},
+	}
+	if n := names[a.typ]; a.config < uint64(len(n)) {
+		return n[a.config]
+	}
+	return strconv.FormatUint(uint64(a.typ), 10) + ":" + strconv.FormatUint(a.config, 10)
+}
+
+// clock reports whether the event period is measured in nanoseconds.
+func (a *eventAttr) clock() bool {
+	return a.typ == 1 && a.config <= 1
+}
+
+type mapping struct {
+	start   uint64
+	limit   uint64
+	pgoff   uint64
+	file    string
+	buildID string
+}
+
+// dataProfile is a profile of a single event found in the perf.data file.
+type dataProfile struct {
+	event   string
+	clock   bool
+	profile *profilev1.Profile
+}
+
+type dataParser struct {
+	order binary.ByteOrder
+	buf   []byte
+
+	attrs    []*eventAttr
+	attrByID map[uint64]int
+	buildIDs map[string]string
+
+	comms    map[uint32]string
+	mappings map[uint32][]*mapping
+
+	profiles []*profileBuilder
+}
+
+// parseData converts the perf.data file (perf record output) to pprof
+// profiles, one per recorded event. perf.data does not include symbol
+// tables, therefore frames are named after the binary they belong to;
+// build IDs of the binaries are preserved in the profile mappings.
+func parseData(buf []byte) ([]*dataProfile, error) {
+	p := &dataParser{
+		buf:      buf,
+		attrByID: make(map[uint64]int),
+		buildIDs: make(map[string]string),
+		comms:    make(map[uint32]string),
+		mappings: make(map[uint32][]*mapping),
+	}
+	if err := p.parseHeader(); err != nil {
+		return nil, err
+	}
+	res := make([]*dataProfile, 0, len(p.profiles))
+	for i, b := range p.profiles {
+		if len(b.profile.Sample) == 0 {
+			continue
+		}
+		res = append(res, &dataProfile{
+			event:   p.attrs[i].name(),
+			clock:   p.attrs[i].clock(),
+			profile: b.profile,
+		})
+	}
+	return res, nil
+}
+
+func (p *dataParser) parseHeader() error {
+	if len(p.buf) < 16 {
+		return errDataTruncated
+	}
+	switch {
+	case binary.LittleEndian.Uint64(p.buf) == dataMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint64(p.buf) == dataMagic:
+		p.order = binary.BigEndian
+	default:
+		return fmt.Errorf("perf.data: invalid magic: %x", p.buf[:8])
+	}
+	if p.order.Uint64(p.buf[8:]) != dataHeaderSize {
+		return errPipeMode
+	}
+	if len(p.buf) < dataHeaderSize {
+		return errDataTruncated
+	}
+	attrSize := p.order.Uint64(p.buf[16:])
+	attrs := p.section(p.buf[24:])
+	data := p.section(p.buf[40:])
+	var features [4]uint64
+	for i := range features {
+		features[i] = p.order.Uint64(p.buf[72+i*8:])
+	}
+
+	if err := p.parseAttrs(attrs, attrSize); err != nil {
+		return err
+	}
+	// Feature sections follow the data section, one per feature bit set.
+	offset := data.offset + data.size
+	for i, word := range features {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			word &^= 1 << bit
+			b, err := p.slice(fileSection{offset: offset, size: 16})
+			if err != nil {
+				return err
+			}
+			if i*64+bit == featureBuildID {
+				if err = p.parseBuildIDs(p.section(b)); err != nil {
+					return err
+				}
+			}
+			offset += 16
+		}
+	}
+	return p.parseRecords(data)
+}
+
+func (p *dataParser) section(b []byte) fileSection {
+	return fileSection{offset: p.order.Uint64(b), size: p.order.Uint64(b[8:])}
+}
+
+func (p *dataParser) slice(s fileSection) ([]byte, error) {
+	if s.offset > uint64(len(p.buf)) || s.size > uint64(len(p.buf))-s.offset {
+		return nil, errDataTruncated
+	}
+	return p.buf[s.offset : s.offset+s.size], nil
+}
+
+func (p *dataParser) parseAttrs(s fileSection, attrSize uint64) error {
+	if attrSize < 64+16 {
+		return fmt.Errorf("perf.data: invalid attr size: %d", attrSize)
+	}
+	b, err := p.slice(s)
+	if err != nil {
+		return err
+	}
+	for ; uint64(len(b)) >= attrSize; b = b[attrSize:] {
+		a := &eventAttr{
+			typ:          p.order.Uint32(b),
+			config:       p.order.Uint64(b[8:]),
+			samplePeriod: p.order.Uint64(b[16:]),
+			sampleType:   p.order.Uint64(b[24:]),
+			readFormat:   p.order.Uint64(b[32:]),
+			flags:        p.order.Uint64(b[40:]),
+		}
+		ids, err := p.slice(p.section(b[attrSize-16:]))
+		if err != nil {
+			return err
+		}
+		for ; len(ids) >= 8; ids = ids[8:] {
+			p.attrByID[p.order.Uint64(ids)] = len(p.attrs)
+		}
+		p.attrs = append(p.attrs, a)
+		p.profiles = append(p.profiles, newProfileBuilder(a))
+	}
+	if len(p.attrs) == 0 {
+		return errors.New("perf.data: no events found")
+	}
+	for _, a := range p.attrs[1:] {
+		if a.sampleType != p.attrs[0].sampleType && a.sampleType&sampleIdentifier == 0 {
+			return errors.New("perf.data: events with different sample types must include sample identifier")
+		}
+	}
+	return nil
+}
+
+func (p *dataParser) parseBuildIDs(s fileSection) error {
+	b, err := p.slice(s)
+	if err != nil {
+		return err
+	}
+	// struct build_id_event {
+	//   struct perf_event_header header;
+	//   pid_t pid;
+	//   u8 build_id[24];
+	//   char filename[];
+	// };
+	for len(b) >= 8 {
+		misc := p.order.Uint16(b[4:])
+		size := int(p.order.Uint16(b[6:]))
+		if size < 36 || size > len(b) {
+			return errDataTruncated
+		}
+		buildID := b[12:32]
+		if misc&recordMiscBuildIDSize != 0 && int(b[32]) <= 20 {
+			buildID = buildID[:b[32]]
+		}
+		p.buildIDs[cString(b[36:size])] = hex.EncodeToString(buildID)
+		b = b[size:]
+	}
+	return nil
+}
+
+func (p *dataParser) parseRecords(s fileSection) error {
+	b, err := p.slice(s)
+	if err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return errDataTruncated
+		}
+		typ := p.order.Uint32(b)
+		misc := p.order.Uint16(b[4:])
+		size := int(p.order.Uint16(b[6:]))
+		if size < 8 || size > len(b) {
+			return errDataTruncated
+		}
+		r := b[8:size]
+		b = b[size:]
+		switch typ {
+		case recordMmap:
+			if len(r) < 32 {
+				return errDataTruncated
+			}
+			p.addMapping(p.order.Uint32(r), &mapping{
+				start: p.order.Uint64(r[8:]),
+				limit: p.order.Uint64(r[8:]) + p.order.Uint64(r[16:]),
+				pgoff: p.order.Uint64(r[24:]),
+				file:  cString(r[32:]),
+			})
+		case recordMmap2:
+			if len(r) < 64 {
+				return errDataTruncated
+			}
+			m := &mapping{
+				start: p.order.Uint64(r[8:]),
+				limit: p.order.Uint64(r[8:]) + p.order.Uint64(r[16:]),
+				pgoff: p.order.Uint64(r[24:]),
+				file:  cString(r[64:]),
+			}
+			if misc&recordMiscMmapBuildID != 0 && int(r[32]) <= 20 {
+				m.buildID = hex.EncodeToString(r[36 : 36+int(r[32])])
+			}
+			p.addMapping(p.order.Uint32(r), m)
+		case recordComm:
+			if len(r) < 8 {
+				return errDataTruncated
+			}
+			p.comms[p.order.Uint32(r[4:])] = cString(r[8:])
+		case recordFork:
+			if len(r) < 8 {
+				return errDataTruncated
+			}
+			pid, ppid := p.order.Uint32(r), p.order.Uint32(r[4:])
+			if _, ok := p.mappings[pid]; !ok && pid != ppid {
+				p.mappings[pid] = append([]*mapping(nil), p.mappings[ppid]...)
+			}
+			if comm, ok := p.comms[ppid]; ok {
+				if _, ok = p.comms[pid]; !ok {
+					p.comms[pid] = comm
+				}
+			}
+		case recordSample:
+			if err = p.parseSample(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *dataParser) addMapping(pid uint32, m *mapping) {
+	if m.buildID == "" {
+		m.buildID = p.buildIDs[m.file]
+	}
+	ms := p.mappings[pid]
+	// Newer mappings replace the overlapping ones.
+	n := 0
+	for _, x := range ms {
+		if x.limit <= m.start || x.start >= m.limit {
+			ms[n] = x
+			n++
+		}
+	}
+	ms = append(ms[:n], m)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].start < ms[j].start })
+	p.mappings[pid] = ms
+}
+
+func (p *dataParser) findMapping(pid uint32, addr uint64) *mapping {
+	for _, ms := range [2][]*mapping{p.mappings[pid], p.mappings[kernelPID]} {
+		i := sort.Search(len(ms), func(i int) bool { return ms[i].limit > addr })
+		if i < len(ms) && ms[i].start <= addr {
+			return ms[i]
+		}
+	}
+	return nil
+}
+
+func (p *dataParser) parseSample(r []byte) error {
+	attr := 0
+	if t := p.attrs[0].sampleType; t&sampleIdentifier != 0 {
+		if len(r) < 8 {
+			return errDataTruncated
+		}
+		var ok bool
+		if attr, ok = p.attrByID[p.order.Uint64(r)]; !ok {
+			return fmt.Errorf("perf.data: unknown sample id: %d", p.order.Uint64(r))
+		}
+	} else if t&sampleID != 0 && len(p.attrs) > 1 {
+		return errors.New("perf.data: events with id must include sample identifier")
+	}
+	a := p.attrs[attr]
+	d := decoder{order: p.order, buf: r}
+	var (
+		ip, period uint64
+		pid, tid   uint32
+		callchain  []uint64
+	)
+	if a.sampleType&sampleIdentifier != 0 {
+		d.skip(8)
+	}
+	if a.sampleType&sampleIP != 0 {
+		ip = d.u64()
+	}
+	if a.sampleType&sampleTID != 0 {
+		pid, tid = d.u32(), d.u32()
+	}
+	for _, f := range []uint64{sampleTime, sampleAddr, sampleID, sampleStreamID, sampleCPU} {
+		if a.sampleType&f != 0 {
+			d.skip(8)
+		}
+	}
+	if a.sampleType&samplePeriod != 0 {
+		period = d.u64()
+	} else if a.flags&attrFlagFreq == 0 {
+		period = a.samplePeriod
+	}
+	if a.sampleType&sampleRead != 0 {
+		d.skipRead(a.readFormat)
+	}
+	if a.sampleType&sampleCallchain != 0 {
+		n := d.u64()
+		if n > uint64(len(r)/8) {
+			return errDataTruncated
+		}
+		callchain = make([]uint64, 0, n)
+		for i := uint64(0); i < n; i++ {
+			callchain = append(callchain, d.u64())
+		}
+	} else if a.sampleType&sampleIP != 0 {
+		callchain = []uint64{ip}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	b := p.profiles[attr]
+	locations := make([]uint64, 0, len(callchain)+1)
+	for _, addr := range callchain {
+		if addr >= contextMax {
+			continue
+		}
+		locations = append(locations, b.location(p.findMapping(pid, addr), addr))
+	}
+	comm, ok := p.comms[tid]
+	if !ok {
+		comm, ok = p.comms[pid]
+	}
+	if ok {
+		locations = append(locations, b.function(comm))
+	}
+	if len(locations) > 0 {
+		b.addSample(locations, period)
+	}
+	return nil
+}
+
+type decoder struct {
+	order binary.ByteOrder
+	buf   []byte
+	err   error
+}
+
+func (d *decoder) skip(n int) {
+	if len(d.buf) < n {
+		d.err = errDataTruncated
+		d.buf = nil
+		return
+	}
+	d.buf = d.buf[n:]
+}
+
+func (d *decoder) u64() uint64 {
+	if len(d.buf) < 8 {
+		d.skip(8)
+		return 0
+	}
+	v := d.order.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) u32() uint32 {
+	if len(d.buf) < 4 {
+		d.skip(4)
+		return 0
+	}
+	v := d.order.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+func (d *decoder) skipRead(format uint64) {
+	values := uint64(1)
+	if format&formatGroup != 0 {
+		values = d.u64()
+	}
+	perValue := 1
+	if format&formatID != 0 {
+		perValue++
+	}
+	if format&formatLost != 0 {
+		perValue++
+	}
+	if format&formatTotalTimeEnabled != 0 {
+		d.skip(8)
+	}
+	if format&formatTotalTimeRunning != 0 {
+		d.skip(8)
+	}
+	if values > uint64(len(d.buf)) {
+		d.err = errDataTruncated
+		return
+	}
+	d.skip(int(values) * perValue * 8)
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+type locationKey struct {
+	mapping uint64
+	addr    uint64
+}
+
+type profileBuilder struct {
+	profile *profilev1.Profile
+	clock   bool
+
+	strings   map[string]int64
+	functions map[string]uint64
+	mappings  map[*mapping]uint64
+	locations map[locationKey]uint64
+	frames    map[string]uint64
+	samples   map[string]*profilev1.Sample
+}
+
+func newProfileBuilder(a *eventAttr) *profileBuilder {
+	b := &profileBuilder{
+		profile:   profilev1.ProfileFromVTPool(),
+		clock:     a.clock(),
+		strings:   make(map[string]int64),
+		functions: make(map[string]uint64),
+		mappings:  make(map[*mapping]uint64),
+		locations: make(map[locationKey]uint64),
+		frames:    make(map[string]uint64),
+		samples:   make(map[string]*profilev1.Sample),
+	}
+	b.string("")
+	b.profile.SampleType = []*profilev1.ValueType{{Type: b.string("samples"), Unit: b.string("count")}}
+	if b.clock {
+		b.profile.SampleType = append(b.profile.SampleType, &profilev1.ValueType{Type: b.string("cpu"), Unit: b.string("nanoseconds")})
+		b.profile.PeriodType = &profilev1.ValueType{Type: b.string("cpu"), Unit: b.string("nanoseconds")}
+	} else {
+		b.profile.PeriodType = &profilev1.ValueType{Type: b.string(a.name()), Unit: b.string("count")}
+	}
+	if a.flags&attrFlagFreq == 0 {
+		b.profile.Period = int64(a.samplePeriod)
+	}
+	return b
+}
+
+func (b *profileBuilder) string(s string) int64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = int64(len(b.profile.StringTable))
+		b.strings[s] = i
+		b.profile.StringTable = append(b.profile.StringTable, s)
+	}
+	return i
+}
+
+func (b *profileBuilder) functionID(name string) uint64 {
+	id, ok := b.functions[name]
+	if !ok {
+		id = uint64(len(b.profile.Function) + 1)
+		b.functions[name] = id
+		b.profile.Function = append(b.profile.Function, &profilev1.Function{
+			Id:   id,
+			Name: b.string(name),
+		})
+	}
+	return id
+}
+
+func (b *profileBuilder) newLocation(mappingID, addr uint64, name string) uint64 {
+	id := uint64(len(b.profile.Location) + 1)
+	b.profile.Location = append(b.profile.Location, &profilev1.Location{
+		Id:        id,
+		MappingId: mappingID,
+		Address:   addr,
+		Line:      []*profilev1.Line{{FunctionId: b.functionID(name)}},
+	})
+	return id
+}
+
+// function returns the location of a synthetic frame with the given name.
+func (b *profileBuilder) function(name string) uint64 {
+	loc, ok := b.frames[name]
+	if !ok {
+		loc = b.newLocation(0, 0, name)
+		b.frames[name] = loc
+	}
+	return loc
+}
+
+func (b *profileBuilder) location(m *mapping, addr uint64) uint64 {
+	name := unknownFrame
+	var mappingID uint64
+	if m != nil {
+		name = filepath.Base(m.file)
+		mappingID = b.mapping(m)
+	}
+	k := locationKey{mapping: mappingID, addr: addr}
+	loc, ok := b.locations[k]
+	if !ok {
+		loc = b.newLocation(mappingID, addr, name)
+		b.locations[k] = loc
+	}
+	return loc
+}
+
+func (b *profileBuilder) mapping(m *mapping) uint64 {
+	id, ok := b.mappings[m]
+	if !ok {
+		id = uint64(len(b.profile.Mapping) + 1)
+		b.mappings[m] = id
+		b.profile.Mapping = append(b.profile.Mapping, &profilev1.Mapping{
+			Id:          id,
+			MemoryStart: m.start,
+			MemoryLimit: m.limit,
+			FileOffset:  m.pgoff,
+			Filename:    b.string(m.file),
+			BuildId:     b.string(m.buildID),
+		})
+	}
+	return id
+}
+
+func (b *profileBuilder) addSample(locations []uint64, period uint64) {
+	k := make([]byte, 0, len(locations)*8)
+	for _, l := range locations {
+		k = binary.LittleEndian.AppendUint64(k, l)
+	}
+	s, ok := b.samples[string(k)]
+	if !ok {
+		s = &profilev1.Sample{
+			LocationId: locations,
+			Value:      make([]int64, len(b.profile.SampleType)),
+		}
+		b.samples[string(k)] = s
+		b.profile.Sample = append(b.profile.Sample, s)
+	}
+	s.Value[0]++
+	if b.clock {
+		s.Value[1] += int64(period)
+	}
+}
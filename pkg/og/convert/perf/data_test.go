@@ -0,0 +1,186 @@
+package perf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/ingestion"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
+)
+
+// perfDataWriter writes a minimal perf.data file with a single cpu-clock
+// event sampling TID, PERIOD, and CALLCHAIN.
+type perfDataWriter struct {
+	records  bytes.Buffer
+	buildIDs bytes.Buffer
+}
+
+func (w *perfDataWriter) record(typ uint32, misc uint16, fields ...any) {
+	var b bytes.Buffer
+	for _, f := range fields {
+		_ = binary.Write(&b, binary.LittleEndian, f)
+	}
+	for b.Len()%8 != 0 {
+		b.WriteByte(0)
+	}
+	_ = binary.Write(&w.records, binary.LittleEndian, typ)
+	_ = binary.Write(&w.records, binary.LittleEndian, misc)
+	_ = binary.Write(&w.records, binary.LittleEndian, uint16(8+b.Len()))
+	w.records.Write(b.Bytes())
+}
+
+func cstr(s string) []byte { return append([]byte(s), 0) }
+
+func (w *perfDataWriter) comm(pid uint32, comm string) {
+	w.record(recordComm, 0, pid, pid, cstr(comm))
+}
+
+func (w *perfDataWriter) mmap2(pid uint32, start, length uint64, file string, buildID []byte) {
+	var id [20]byte
+	copy(id[:], buildID)
+	w.record(recordMmap2, recordMiscMmapBuildID, pid, pid, start, length, uint64(0),
+		uint8(len(buildID)), uint8(0), uint16(0), id, uint32(5), uint32(2), cstr(file))
+}
+
+func (w *perfDataWriter) mmap(pid uint32, start, length uint64, file string) {
+	w.record(recordMmap, 0, pid, pid, start, length, uint64(0), cstr(file))
+}
+
+func (w *perfDataWriter) sample(pid uint32, period uint64, callchain ...uint64) {
+	w.record(recordSample, 0, pid, pid, period, uint64(len(callchain)), callchain)
+}
+
+func (w *perfDataWriter) buildID(file string, buildID []byte) {
+	var id [24]byte
+	copy(id[:], buildID)
+	id[20] = byte(len(buildID))
+	name := cstr(file)
+	for len(name)%8 != 0 {
+		name = append(name, 0)
+	}
+	_ = binary.Write(&w.buildIDs, binary.LittleEndian, uint32(0))
+	_ = binary.Write(&w.buildIDs, binary.LittleEndian, uint16(recordMiscBuildIDSize))
+	_ = binary.Write(&w.buildIDs, binary.LittleEndian, uint16(36+len(name)))
+	_ = binary.Write(&w.buildIDs, binary.LittleEndian, int32(-1))
+	w.buildIDs.Write(id[:])
+	w.buildIDs.Write(name)
+}
+
+func (w *perfDataWriter) bytes() []byte {
+	const attrSize = 64 + 16
+	attrsOffset := uint64(dataHeaderSize)
+	dataOffset := attrsOffset + attrSize
+	dataSize := uint64(w.records.Len())
+	featuresOffset := dataOffset + dataSize
+	buildIDsOffset := featuresOffset + 16
+
+	var b bytes.Buffer
+	put := func(v ...any) {
+		for _, x := range v {
+			_ = binary.Write(&b, binary.LittleEndian, x)
+		}
+	}
+	put(uint64(dataMagic), uint64(dataHeaderSize), uint64(attrSize),
+		attrsOffset, uint64(attrSize), dataOffset, dataSize, uint64(0), uint64(0),
+		uint64(1<<featureBuildID), uint64(0), uint64(0), uint64(0))
+	// perf_event_attr: software cpu-clock event.
+	put(uint32(1), uint32(64), uint64(0), uint64(10000000),
+		uint64(sampleTID|samplePeriod|sampleCallchain), uint64(0), uint64(0),
+		uint32(0), uint32(0), uint64(0))
+	put(uint64(0), uint64(0)) // ids
+	b.Write(w.records.Bytes())
+	put(buildIDsOffset, uint64(w.buildIDs.Len()))
+	b.Write(w.buildIDs.Bytes())
+	return b.Bytes()
+}
+
+func Test_ParseData(t *testing.T) {
+	var w perfDataWriter
+	w.mmap(kernelPID, 0xffff0000, 0x1000, "[kernel.kallsyms]_text")
+	w.comm(42, "app")
+	w.mmap2(42, 0x1000, 0x1000, "/usr/bin/app", []byte{0xca, 0xfe})
+	w.mmap(42, 0x4000, 0x1000, "/lib/libc.so.6")
+	w.buildID("/lib/libc.so.6", []byte{0xbe, 0xef})
+	w.sample(42, 10000000, contextMax+1, 0xffff0010, contextMax+2, 0x4010, 0x1010)
+	w.sample(42, 10000000, 0x4010, 0x1010)
+	w.sample(42, 10000000, 0x4010, 0x1010)
+	w.sample(7, 10000000, 0x9000)
+
+	profiles, err := parseData(w.bytes())
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	assert.Equal(t, "cpu-clock", profiles[0].event)
+	assert.True(t, profiles[0].clock)
+
+	p := profiles[0].profile
+	stacks := make(map[string][]int64)
+	for _, s := range p.Sample {
+		var stack string
+		for _, loc := range s.LocationId {
+			fn := p.Function[p.Location[loc-1].Line[0].FunctionId-1]
+			stack += p.StringTable[fn.Name] + ";"
+		}
+		stacks[stack] = s.Value
+	}
+	assert.Equal(t, map[string][]int64{
+		"[kernel.kallsyms]_text;libc.so.6;app;app;": {1, 10000000},
+		"libc.so.6;app;app;":                        {2, 20000000},
+		"[unknown];":                                {1, 10000000},
+	}, stacks)
+
+	buildIDs := make(map[string]string)
+	for _, m := range p.Mapping {
+		buildIDs[p.StringTable[m.Filename]] = p.StringTable[m.BuildId]
+	}
+	assert.Equal(t, map[string]string{
+		"[kernel.kallsyms]_text": "",
+		"/usr/bin/app":           "cafe",
+		"/lib/libc.so.6":         "beef",
+	}, buildIDs)
+}
+
+func Test_ParseData_Invalid(t *testing.T) {
+	var w perfDataWriter
+	w.sample(1, 1, 0x1000)
+	b := w.bytes()
+
+	_, err := parseData(b[:len(b)-20])
+	assert.Error(t, err)
+	_, err = parseData([]byte("not a perf.data file"))
+	assert.Error(t, err)
+}
+
+func Test_RawProfile_ParseToPprof(t *testing.T) {
+	var w perfDataWriter
+	w.comm(1, "app")
+	w.sample(1, 10000000, 0x1000)
+	key, err := segment.ParseKey("my-app{env=test}")
+	require.NoError(t, err)
+
+	p := &RawProfile{RawData: w.bytes()}
+	req, err := p.ParseToPprof(context.Background(), ingestion.Metadata{
+		StartTime: time.Unix(10, 0),
+		EndTime:   time.Unix(20, 0),
+		Key:       key,
+		SpyName:   "perf",
+	})
+	require.NoError(t, err)
+	require.Len(t, req.Series, 1)
+	ls := phlaremodel.Labels(req.Series[0].Labels)
+	assert.Equal(t, "process_cpu", ls.Get("__name__"))
+	assert.Equal(t, "my-app", ls.Get("service_name"))
+	assert.Equal(t, "test", ls.Get("env"))
+	assert.Equal(t, "cpu-clock", ls.Get("perf_event"))
+
+	profile := req.Series[0].Samples[0].Profile
+	assert.Equal(t, int64(10e9), profile.TimeNanos)
+	assert.Equal(t, int64(10e9), profile.DurationNanos)
+	profile.Close()
+}
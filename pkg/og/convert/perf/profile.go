@@ -0,0 +1,92 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/prometheus/model/labels"
+
+	v1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/ingestion"
+	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/pprof"
+)
+
+// RawProfile implements ingestion.RawProfile for perf.data files
+// produced by perf record.
+type RawProfile struct {
+	RawData []byte
+}
+
+func (p *RawProfile) Bytes() ([]byte, error) { return p.RawData, nil }
+
+func (p *RawProfile) ParseToPprof(_ context.Context, md ingestion.Metadata) (res *distributormodel.PushRequest, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("perf.data parser panic: %v", r)
+		}
+	}()
+	profiles, err := parseData(p.RawData)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	res = &distributormodel.PushRequest{
+		RawProfileSize: len(p.RawData),
+		RawProfileType: distributormodel.RawProfileTypePerf,
+		Series:         make([]*distributormodel.ProfileSeries, 0, len(profiles)),
+	}
+	for _, x := range profiles {
+		x.profile.TimeNanos = md.StartTime.UnixNano()
+		x.profile.DurationNanos = md.EndTime.Sub(md.StartTime).Nanoseconds()
+		res.Series = append(res.Series, &distributormodel.ProfileSeries{
+			Labels: createLabels(x, md),
+			Samples: []*distributormodel.ProfileSample{{
+				Profile: pprof.RawFromProto(x.profile),
+			}},
+		})
+	}
+	return res, nil
+}
+
+func createLabels(p *dataProfile, md ingestion.Metadata) []*v1.LabelPair {
+	metric := "perf"
+	if p.clock {
+		metric = "process_cpu"
+	}
+	ls := make([]*v1.LabelPair, 0, len(md.Key.Labels())+5)
+	ls = append(ls, &v1.LabelPair{
+		Name:  labels.MetricName,
+		Value: metric,
+	}, &v1.LabelPair{
+		Name:  phlaremodel.LabelNameDelta,
+		Value: "false",
+	}, &v1.LabelPair{
+		Name:  "service_name",
+		Value: md.Key.AppName(),
+	}, &v1.LabelPair{
+		Name:  "pyroscope_spy",
+		Value: md.SpyName,
+	}, &v1.LabelPair{
+		Name:  "perf_event",
+		Value: p.event,
+	})
+	for k, v := range md.Key.Labels() {
+		if !phlaremodel.IsLabelAllowedForIngestion(k) {
+			continue
+		}
+		ls = append(ls, &v1.LabelPair{
+			Name:  k,
+			Value: v,
+		})
+	}
+	return ls
+}
+
+func (p *RawProfile) Parse(context.Context, storage.Putter, storage.MetricsExporter, ingestion.Metadata) error {
+	return fmt.Errorf("parsing perf.data to tree/storage.Putter is not supported")
+}
+
+func (p *RawProfile) ContentType() string { return "binary/octet-stream" }
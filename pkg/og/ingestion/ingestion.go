@@ -32,6 +32,9 @@ const (
   FormatLines      Format = "lines"
   FormatGroups     Format = "groups"
   FormatSpeedscope Format = "speedscope"
+  FormatPerf       Format = "perf"
+  FormatCPUProfile Format = "cpuprofile"
+  FormatNettrace   Format = "nettrace"
 )
 
 type RawProfile interface {
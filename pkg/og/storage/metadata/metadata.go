@@ -13,8 +13,19 @@ const (
 	BytesUnits           Units = "bytes"
 	LockNanosecondsUnits Units = "lock_nanoseconds"
 	LockSamplesUnits     Units = "lock_samples"
+	NanosecondsUnits     Units = "nanoseconds"
 )
 
+// IsValid reports whether the units are known.
+func (u Units) IsValid() bool {
+	switch u {
+	case SamplesUnits, ObjectsUnits, GoroutinesUnits, BytesUnits,
+		LockNanosecondsUnits, LockSamplesUnits, NanosecondsUnits:
+		return true
+	}
+	return false
+}
+
 type AggregationType string
 
 const (
@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/dns"
@@ -30,15 +31,21 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 	"gopkg.in/yaml.v3"
 
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	statusv1 "github.com/grafana/pyroscope/api/gen/proto/go/status/v1"
+	"github.com/grafana/pyroscope/pkg/billing"
 	"github.com/grafana/pyroscope/pkg/distributor"
 	"github.com/grafana/pyroscope/pkg/frontend"
 	"github.com/grafana/pyroscope/pkg/ingester"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	objstoreclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/querier"
+	"github.com/grafana/pyroscope/pkg/querier/tenantfederation"
 	"github.com/grafana/pyroscope/pkg/querier/worker"
+	"github.com/grafana/pyroscope/pkg/regression"
+	"github.com/grafana/pyroscope/pkg/ruler"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/storegateway"
 	"github.com/grafana/pyroscope/pkg/usagestats"
@@ -50,23 +57,31 @@ import (
 
 // The various modules that make up Pyroscope.
 const (
-	All               string = "all"
-	API               string = "api"
-	Distributor       string = "distributor"
-	Server            string = "server"
-	Ring              string = "ring"
-	Ingester          string = "ingester"
-	MemberlistKV      string = "memberlist-kv"
-	Querier           string = "querier"
-	StoreGateway      string = "store-gateway"
-	GRPCGateway       string = "grpc-gateway"
-	Storage           string = "storage"
-	UsageReport       string = "usage-stats"
-	QueryFrontend     string = "query-frontend"
-	QueryScheduler    string = "query-scheduler"
-	RuntimeConfig     string = "runtime-config"
-	Overrides         string = "overrides"
-	OverridesExporter string = "overrides-exporter"
+	All                string = "all"
+	API                string = "api"
+	Distributor        string = "distributor"
+	Server             string = "server"
+	Ring               string = "ring"
+	Ingester           string = "ingester"
+	MemberlistKV       string = "memberlist-kv"
+	Querier            string = "querier"
+	StoreGateway       string = "store-gateway"
+	GRPCGateway        string = "grpc-gateway"
+	Storage            string = "storage"
+	UsageReport        string = "usage-stats"
+	QueryFrontend      string = "query-frontend"
+	QueryScheduler     string = "query-scheduler"
+	RuntimeConfig      string = "runtime-config"
+	Overrides          string = "overrides"
+	OverridesExporter  string = "overrides-exporter"
+	Ruler              string = "ruler"
+	RegressionDetector string = "regression-detector"
+	BillingExporter    string = "billing-exporter"
+
+	// Read-write deployment mode targets.
+	Write   string = "write"
+	Read    string = "read"
+	Backend string = "backend"
 
 	// QueryFrontendTripperware string = "query-frontend-tripperware"
 	// Compactor                string = "compactor"
@@ -95,16 +110,26 @@ func (f *Phlare) initQueryFrontend() (services.Service, error) {
 		return nil, err
 	}
 
-	f.API.RegisterPyroscopeHandlers(frontendSvc)
+	var querierSvc querierv1connect.QuerierServiceHandler = frontendSvc
+	if f.Cfg.TenantFederation.Enabled {
+		querierSvc = tenantfederation.NewQuerierService(f.Cfg.TenantFederation, frontendSvc)
+	}
+	f.API.RegisterPyroscopeHandlers(querierSvc)
 	f.API.RegisterQueryFrontend(frontendSvc)
-	f.API.RegisterQuerier(frontendSvc)
+	var opts []connect.HandlerOption
+	if l := frontendSvc.SlowQueryLog(); l != nil {
+		opts = append(opts, connect.WithInterceptors(l.Interceptor()))
+	}
+	f.API.RegisterQuerier(querierSvc, opts...)
 
 	return frontendSvc, nil
 }
 
 func (f *Phlare) initRuntimeConfig() (services.Service, error) {
 	if len(f.Cfg.RuntimeConfig.LoadPath) == 0 {
-		// no need to initialize module if load path is empty
+		// no need to initialize module if load path is empty, but a runtime
+		// config can still be validated before it is deployed.
+		f.registerRuntimeConfig()
 		return nil, nil
 	}
 
@@ -119,14 +144,27 @@ func (f *Phlare) initRuntimeConfig() (services.Service, error) {
 		// anything in the start/stopping phase. Thus we can create it as part of runtime config
 		// setup without any service instance of its own.
 		f.TenantLimits = newTenantLimits(serv)
+		go logOverridesChanges(log.With(f.logger, "component", "runtime-config"), serv.CreateListenerChannel(1), f.Cfg.LimitsConfig)
 	}
 
 	f.RuntimeConfig = serv
-	f.API.RegisterRuntimeConfig(runtimeConfigHandler(f.RuntimeConfig, f.Cfg.LimitsConfig), validation.TenantLimitsHandler(f.Cfg.LimitsConfig, f.TenantLimits))
+	f.registerRuntimeConfig()
 
 	return serv, err
 }
 
+// registerRuntimeConfig registers the endpoints of the runtime config. They
+// serve the default limits if there is no runtime config.
+func (f *Phlare) registerRuntimeConfig() {
+	tenantLimits := newTenantLimits(f.RuntimeConfig)
+	f.API.RegisterRuntimeConfig(
+		runtimeConfigHandler(f.RuntimeConfig, f.Cfg.LimitsConfig),
+		runtimeConfigValidateHandler(f.RuntimeConfig, f.Cfg.LimitsConfig),
+		validation.TenantLimitsHandler(f.Cfg.LimitsConfig, tenantLimits),
+		validation.TenantLimitsInEffectHandler(f.Cfg.LimitsConfig, tenantLimits),
+	)
+}
+
 func (f *Phlare) initOverrides() (serv services.Service, err error) {
 	f.Overrides, err = validation.NewOverrides(f.Cfg.LimitsConfig, f.TenantLimits)
 	// overrides don't have operational state, nor do they need to do anything more in starting/stopping phase,
@@ -154,6 +192,40 @@ func (f *Phlare) initOverridesExporter() (services.Service, error) {
 	return overridesExporter, nil
 }
 
+func (f *Phlare) initRuler() (services.Service, error) {
+	queryURL := f.Cfg.Ruler.QueryURL
+	if queryURL == "" {
+		queryURL = fmt.Sprintf("http://localhost:%d", f.Cfg.Server.HTTPListenPort)
+	}
+	querier := querierv1connect.NewQuerierServiceClient(http.DefaultClient, queryURL)
+	r, err := ruler.New(f.Cfg.Ruler, querier, log.With(f.logger, "component", "ruler"), f.reg)
+	if err != nil {
+		return nil, errors.Wrap(err, "ruler init")
+	}
+	return r, nil
+}
+
+func (f *Phlare) initRegressionDetector() (services.Service, error) {
+	queryURL := f.Cfg.RegressionDetector.QueryURL
+	if queryURL == "" {
+		queryURL = fmt.Sprintf("http://localhost:%d", f.Cfg.Server.HTTPListenPort)
+	}
+	querier := querierv1connect.NewQuerierServiceClient(http.DefaultClient, queryURL)
+	d := regression.New(f.Cfg.RegressionDetector, querier, log.With(f.logger, "component", "regression-detector"), f.reg)
+	f.API.RegisterRegressionDetector(d)
+	return d, nil
+}
+
+func (f *Phlare) initBillingExporter() (services.Service, error) {
+	b, err := f.localOrStorageBucket()
+	if err != nil {
+		return nil, err
+	}
+	e := billing.New(f.Cfg.BillingExporter, b, log.With(f.logger, "component", "billing-exporter"), f.reg)
+	f.API.RegisterBillingExporter(e)
+	return e, nil
+}
+
 func (f *Phlare) initQueryScheduler() (services.Service, error) {
 	f.Cfg.QueryScheduler.ServiceDiscovery.SchedulerRing.ListenPort = f.Cfg.Server.HTTPListenPort
 
@@ -191,19 +263,23 @@ func (f *Phlare) initQuerier() (services.Service, error) {
 
 	// if a storage bucket is configure we need to create a store gateway querier
 	if f.storageBucket != nil {
-		storeGatewayQuerier, err = querier.NewStoreGatewayQuerier(f.Cfg.StoreGateway, nil, f.Overrides, log.With(f.logger, "component", "store-gateway-querier"), f.reg, f.auth)
+		storeGatewayQuerier, err = querier.NewStoreGatewayQuerier(f.Cfg.StoreGateway, nil, f.Overrides, f.Cfg.Querier.PreferAvailabilityZone, log.With(f.logger, "component", "store-gateway-querier"), f.reg, f.auth)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	querierSvc, err := querier.New(f.Cfg.Querier, f.ring, nil, storeGatewayQuerier, f.reg, log.With(f.logger, "component", "querier"), f.auth)
+	querierSvc, err := querier.New(f.Cfg.Querier, f.ring, nil, storeGatewayQuerier, f.storageBucket, f.Overrides, f.reg, log.With(f.logger, "component", "querier"), f.auth)
 	if err != nil {
 		return nil, err
 	}
 	if !f.isModuleActive(QueryFrontend) {
-		f.API.RegisterPyroscopeHandlers(querierSvc)
-		f.API.RegisterQuerier(querierSvc)
+		var svc querierv1connect.QuerierServiceHandler = querierSvc
+		if f.Cfg.TenantFederation.Enabled {
+			svc = tenantfederation.NewQuerierService(f.Cfg.TenantFederation, querierSvc)
+		}
+		f.API.RegisterPyroscopeHandlers(svc)
+		f.API.RegisterQuerier(svc)
 	}
 	worker, err := worker.NewQuerierWorker(f.Cfg.Worker, querier.NewGRPCHandler(querierSvc), log.With(f.logger, "component", "querier-worker"), f.reg)
 	if err != nil {
@@ -253,7 +329,16 @@ func (f *Phlare) initGRPCGateway() (services.Service, error) {
 
 func (f *Phlare) initDistributor() (services.Service, error) {
 	f.Cfg.Distributor.DistributorRing.ListenPort = f.Cfg.Server.HTTPListenPort
-	d, err := distributor.New(f.Cfg.Distributor, f.ring, nil, f.Overrides, f.reg, log.With(f.logger, "component", "distributor"), f.auth)
+	f.Cfg.Distributor.IngestStorage = f.Cfg.IngestStorage
+	var bucket phlareobj.Bucket
+	if f.Cfg.Distributor.DeadLetterQueue.Enabled {
+		b, err := f.localOrStorageBucket()
+		if err != nil {
+			return nil, err
+		}
+		bucket = b
+	}
+	d, err := distributor.New(f.Cfg.Distributor, f.ring, nil, f.Overrides, bucket, f.reg, log.With(f.logger, "component", "distributor"), f.auth)
 	if err != nil {
 		return nil, err
 	}
@@ -306,7 +391,9 @@ func (f *Phlare) initRing() (_ services.Service, err error) {
 
 func (f *Phlare) initStorage() (_ services.Service, err error) {
 	objectStoreTypeStats.Set(f.Cfg.Storage.Bucket.Backend)
-	if cfg := f.Cfg.Storage.Bucket; cfg.Backend != "filesystem" {
+	// Without a directory, the filesystem backend falls back to the data
+	// directory of the single binary.
+	if cfg := f.Cfg.Storage.Bucket; cfg.Backend != "filesystem" || cfg.Filesystem.Directory != "" {
 		b, err := objstoreclient.NewBucket(
 			f.context(),
 			cfg,
@@ -317,6 +404,9 @@ func (f *Phlare) initStorage() (_ services.Service, err error) {
 		}
 		f.storageBucket = b
 	}
+	if f.blockKeys, err = phlareobj.NewKeyProvider(f.Cfg.Storage.Encryption); err != nil {
+		return nil, errors.Wrap(err, "unable to initialise encryption keys")
+	}
 
 	if f.Cfg.Target.String() != All && f.storageBucket == nil {
 		return nil, errors.New("storage bucket configuration is required when running in microservices mode")
@@ -325,6 +415,22 @@ func (f *Phlare) initStorage() (_ services.Service, err error) {
 	return nil, nil
 }
 
+// localOrStorageBucket returns the storage bucket, or a filesystem bucket
+// in the data directory if no storage bucket is configured.
+func (f *Phlare) localOrStorageBucket() (phlareobj.Bucket, error) {
+	if f.storageBucket != nil {
+		return f.storageBucket, nil
+	}
+	if err := os.MkdirAll(f.Cfg.PhlareDB.DataPath, 0o777); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", f.Cfg.PhlareDB.DataPath, err)
+	}
+	fs, err := filesystem.NewBucket(f.Cfg.PhlareDB.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
 // TODO: This should be passed to all other services and could also be used to signal shutdown
 func (f *Phlare) context() context.Context {
 	phlarectx := phlarecontext.WithLogger(context.Background(), f.logger)
@@ -333,8 +439,15 @@ func (f *Phlare) context() context.Context {
 
 func (f *Phlare) initIngester() (_ services.Service, err error) {
 	f.Cfg.Ingester.LifecyclerConfig.ListenPort = f.Cfg.Server.HTTPListenPort
+	f.Cfg.Ingester.IngestStorage = f.Cfg.IngestStorage
 
-	svc, err := ingester.New(f.context(), f.Cfg.Ingester, f.Cfg.PhlareDB, f.storageBucket, f.Overrides)
+	// The blocks of the tenants with an encryption key are encrypted before
+	// they are shipped.
+	storageBucket := f.storageBucket
+	if storageBucket != nil {
+		storageBucket = phlareobj.NewEncryptedBucket(storageBucket, f.blockKeys, f.Overrides.BlocksEncryptionKeyID)
+	}
+	svc, err := ingester.New(f.context(), f.Cfg.Ingester, f.Cfg.PhlareDB, storageBucket, f.Overrides)
 	if err != nil {
 		return nil, err
 	}
@@ -350,7 +463,7 @@ func (f *Phlare) initStoreGateway() (serv services.Service, err error) {
 		return nil, nil
 	}
 
-	svc, err := storegateway.NewStoreGateway(f.Cfg.StoreGateway, f.storageBucket, f.Overrides, f.logger, f.reg)
+	svc, err := storegateway.NewStoreGateway(f.Cfg.StoreGateway, f.storageBucket, f.blockKeys, f.Overrides, f.logger, f.reg)
 	if err != nil {
 		return nil, err
 	}
@@ -442,16 +555,9 @@ func (f *Phlare) initUsageReport() (services.Service, error) {
 
 	usagestats.Target(f.Cfg.Target.String())
 
-	b := f.storageBucket
-	if f.storageBucket == nil {
-		if err := os.MkdirAll(f.Cfg.PhlareDB.DataPath, 0o777); err != nil {
-			return nil, fmt.Errorf("mkdir %s: %w", f.Cfg.PhlareDB.DataPath, err)
-		}
-		fs, err := filesystem.NewBucket(f.Cfg.PhlareDB.DataPath)
-		if err != nil {
-			return nil, err
-		}
-		b = fs
+	b, err := f.localOrStorageBucket()
+	if err != nil {
+		return nil, err
 	}
 
 	if b == nil {
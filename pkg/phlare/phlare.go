@@ -21,6 +21,7 @@ import (
 	"github.com/grafana/dskit/grpcutil"
 	"github.com/grafana/dskit/kv/memberlist"
 	dslog "github.com/grafana/dskit/log"
+	"github.com/grafana/dskit/middleware"
 	"github.com/grafana/dskit/modules"
 	"github.com/grafana/dskit/ring"
 	"github.com/grafana/dskit/runtimeconfig"
@@ -35,16 +36,23 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/grafana/pyroscope/pkg/api"
+	"github.com/grafana/pyroscope/pkg/audit"
+	"github.com/grafana/pyroscope/pkg/billing"
 	"github.com/grafana/pyroscope/pkg/cfg"
 	"github.com/grafana/pyroscope/pkg/distributor"
 	"github.com/grafana/pyroscope/pkg/frontend"
 	"github.com/grafana/pyroscope/pkg/ingester"
+	"github.com/grafana/pyroscope/pkg/ingeststorage"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	objstoreclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
 	"github.com/grafana/pyroscope/pkg/querier"
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
+	"github.com/grafana/pyroscope/pkg/querier/tenantfederation"
 	"github.com/grafana/pyroscope/pkg/querier/worker"
+	"github.com/grafana/pyroscope/pkg/regression"
+	"github.com/grafana/pyroscope/pkg/ruler"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerdiscovery"
 	"github.com/grafana/pyroscope/pkg/storegateway"
@@ -58,28 +66,36 @@ import (
 )
 
 type Config struct {
-	Target            flagext.StringSliceCSV `yaml:"target,omitempty"`
-	API               api.Config             `yaml:"api"`
-	Server            server.Config          `yaml:"server,omitempty"`
-	Distributor       distributor.Config     `yaml:"distributor,omitempty"`
-	Querier           querier.Config         `yaml:"querier,omitempty"`
-	Frontend          frontend.Config        `yaml:"frontend,omitempty"`
-	Worker            worker.Config          `yaml:"frontend_worker"`
-	LimitsConfig      validation.Limits      `yaml:"limits"`
-	QueryScheduler    scheduler.Config       `yaml:"query_scheduler"`
-	Ingester          ingester.Config        `yaml:"ingester,omitempty"`
-	StoreGateway      storegateway.Config    `yaml:"store_gateway,omitempty"`
-	MemberlistKV      memberlist.KVConfig    `yaml:"memberlist"`
-	PhlareDB          phlaredb.Config        `yaml:"pyroscopedb,omitempty"`
-	Tracing           tracing.Config         `yaml:"tracing"`
-	OverridesExporter exporter.Config        `yaml:"overrides_exporter" doc:"hidden"`
-	RuntimeConfig     runtimeconfig.Config   `yaml:"runtime_config"`
+	Target             flagext.StringSliceCSV `yaml:"target,omitempty"`
+	API                api.Config             `yaml:"api"`
+	Server             server.Config          `yaml:"server,omitempty"`
+	Distributor        distributor.Config     `yaml:"distributor,omitempty"`
+	Querier            querier.Config         `yaml:"querier,omitempty"`
+	Frontend           frontend.Config        `yaml:"frontend,omitempty"`
+	Worker             worker.Config          `yaml:"frontend_worker"`
+	LimitsConfig       validation.Limits      `yaml:"limits"`
+	QueryScheduler     scheduler.Config       `yaml:"query_scheduler"`
+	Ingester           ingester.Config        `yaml:"ingester,omitempty"`
+	IngestStorage      ingeststorage.Config   `yaml:"ingest_storage"`
+	StoreGateway       storegateway.Config    `yaml:"store_gateway,omitempty"`
+	MemberlistKV       memberlist.KVConfig    `yaml:"memberlist"`
+	PhlareDB           phlaredb.Config        `yaml:"pyroscopedb,omitempty"`
+	Tracing            tracing.Config         `yaml:"tracing"`
+	OverridesExporter  exporter.Config        `yaml:"overrides_exporter" doc:"hidden"`
+	RuntimeConfig      runtimeconfig.Config   `yaml:"runtime_config"`
+	Ruler              ruler.Config           `yaml:"ruler"`
+	RegressionDetector regression.Config      `yaml:"regression_detector"`
+	BillingExporter    billing.Config         `yaml:"billing_exporter"`
 
 	Storage       StorageConfig       `yaml:"storage"`
 	SelfProfiling SelfProfilingConfig `yaml:"self_profiling,omitempty"`
 
-	MultitenancyEnabled bool              `yaml:"multitenancy_enabled,omitempty"`
-	Analytics           usagestats.Config `yaml:"analytics"`
+	MultitenancyEnabled bool                    `yaml:"multitenancy_enabled,omitempty"`
+	JWTAuth             tenant.JWTConfig        `yaml:"jwt_auth"`
+	APIKeys             tenant.APIKeysConfig    `yaml:"api_keys"`
+	AuditLog            audit.Config            `yaml:"audit_log"`
+	TenantFederation    tenantfederation.Config `yaml:"tenant_federation"`
+	Analytics           usagestats.Config       `yaml:"analytics"`
 
 	ConfigFile      string `yaml:"-"`
 	ConfigExpandEnv bool   `yaml:"-"`
@@ -93,11 +109,13 @@ func newDefaultConfig() *Config {
 }
 
 type StorageConfig struct {
-	Bucket objstoreclient.Config `yaml:",inline"`
+	Bucket     objstoreclient.Config      `yaml:",inline"`
+	Encryption phlareobj.EncryptionConfig `yaml:"encryption"`
 }
 
 func (c *StorageConfig) RegisterFlagsWithContext(ctx context.Context, f *flag.FlagSet) {
 	c.Bucket.RegisterFlagsWithPrefix("storage.", f, phlarecontext.Logger(ctx))
+	c.Encryption.RegisterFlagsWithPrefix(f, "storage.encryption.")
 }
 
 type SelfProfilingConfig struct {
@@ -123,7 +141,8 @@ func (c *Config) RegisterFlagsWithContext(ctx context.Context, f *flag.FlagSet)
 	c.Target = []string{All}
 	f.StringVar(&c.ConfigFile, "config.file", "", "yaml file to load")
 	f.Var(&c.Target, "target", "Comma-separated list of Pyroscope modules to load. "+
-		"The alias 'all' can be used in the list to load a number of core modules and will enable single-binary mode. ")
+		"The alias 'all' can be used in the list to load a number of core modules and will enable single-binary mode. "+
+		"The aliases 'write', 'read' and 'backend' load the modules of the read-write deployment mode.")
 	f.BoolVar(&c.MultitenancyEnabled, "auth.multitenancy-enabled", false, "When set to true, incoming HTTP requests must specify tenant ID in HTTP X-Scope-OrgId header. When set to false, tenant ID anonymous is used instead.")
 	f.BoolVar(&c.ConfigExpandEnv, "config.expand-env", false, "Expands ${var} in config according to the values of the environment variables.")
 
@@ -132,13 +151,21 @@ func (c *Config) RegisterFlagsWithContext(ctx context.Context, f *flag.FlagSet)
 	c.Querier.RegisterFlags(f)
 	c.StoreGateway.RegisterFlags(f, util.Logger)
 	c.PhlareDB.RegisterFlags(f)
+	c.IngestStorage.RegisterFlags(f)
 	c.Tracing.RegisterFlags(f)
 	c.Storage.RegisterFlagsWithContext(ctx, f)
 	c.SelfProfiling.RegisterFlags(f)
+	c.JWTAuth.RegisterFlags(f)
+	c.APIKeys.RegisterFlags(f)
+	c.TenantFederation.RegisterFlags(f)
 	c.RuntimeConfig.RegisterFlags(f)
+	c.Ruler.RegisterFlags(f)
+	c.RegressionDetector.RegisterFlags(f)
+	c.BillingExporter.RegisterFlags(f)
 	c.Analytics.RegisterFlags(f)
 	c.LimitsConfig.RegisterFlags(f)
 	c.API.RegisterFlags(f)
+	c.AuditLog.RegisterFlags(f)
 }
 
 // registerServerFlagsWithChangedDefaultValues registers *Config.Server flags, but overrides some defaults set by the weaveworks package.
@@ -173,6 +200,42 @@ func (c *Config) Validate() error {
 	if len(c.Target) == 0 {
 		return errors.New("no modules specified")
 	}
+	if err := c.JWTAuth.Validate(); err != nil {
+		return err
+	}
+	if c.JWTAuth.Enabled && !c.MultitenancyEnabled {
+		return errors.New("JWT authentication requires multitenancy to be enabled")
+	}
+	if c.APIKeys.File != "" && !c.MultitenancyEnabled {
+		return errors.New("API keys require multitenancy to be enabled")
+	}
+	if err := c.API.Validate(); err != nil {
+		return err
+	}
+	if err := c.IngestStorage.Validate(); err != nil {
+		return err
+	}
+	if err := c.Frontend.ResultsCache.Validate(); err != nil {
+		return err
+	}
+	if err := c.PhlareDB.Validate(); err != nil {
+		return err
+	}
+	if lo.Contains(c.Target, Ruler) {
+		if err := c.Ruler.Validate(); err != nil {
+			return err
+		}
+	}
+	if lo.Contains(c.Target, RegressionDetector) {
+		if err := c.RegressionDetector.Validate(); err != nil {
+			return err
+		}
+	}
+	if lo.Contains(c.Target, BillingExporter) {
+		if err := c.BillingExporter.Validate(); err != nil {
+			return err
+		}
+	}
 	return c.Ingester.Validate()
 }
 
@@ -218,6 +281,7 @@ type Phlare struct {
 	TenantLimits validation.TenantLimits
 
 	storageBucket phlareobj.Bucket
+	blockKeys     phlareobj.KeyProvider
 
 	grpcGatewayMux *grpcgw.ServeMux
 
@@ -253,9 +317,35 @@ func New(cfg Config) (*Phlare, error) {
 		phlare.tracer = trace
 	}
 
-	phlare.auth = connect.WithInterceptors(tenant.NewAuthInterceptor(cfg.MultitenancyEnabled))
+	// The prefetch hints are propagated along with the tenant ID, from the
+	// query-frontend to the store-gateways.
+	phlare.auth = connect.WithInterceptors(tenant.NewAuthInterceptor(cfg.MultitenancyEnabled), prefetch.NewInterceptor())
 	phlare.Cfg.API.HTTPAuthMiddleware = util.AuthenticateUser(cfg.MultitenancyEnabled)
 	phlare.Cfg.API.GrpcAuthMiddleware = phlare.auth
+	// The tenant ID is taken from the token for the requests received from
+	// the clients; internal requests are not authenticated.
+	var auths []tenant.Authenticator
+	if cfg.APIKeys.File != "" {
+		apiKeys, err := tenant.NewAPIKeys(cfg.APIKeys, log.With(logger, "component", "api-keys"))
+		if err != nil {
+			return nil, fmt.Errorf("loading API keys: %w", err)
+		}
+		auths = append(auths, apiKeys)
+	}
+	if cfg.JWTAuth.Enabled {
+		auths = append(auths, tenant.NewJWTAuthenticator(cfg.JWTAuth, log.With(logger, "component", "jwt-auth")))
+	}
+	if len(auths) > 0 {
+		phlare.Cfg.API.HTTPAuthMiddleware = middleware.Merge(tenant.NewAuthMiddleware(auths...), phlare.Cfg.API.HTTPAuthMiddleware)
+		phlare.Cfg.API.GrpcPublicAuthMiddleware = connect.WithInterceptors(tenant.NewAuthTokenInterceptor(auths...))
+	}
+	if cfg.AuditLog.Enabled {
+		auditLogger, err := audit.New(cfg.AuditLog)
+		if err != nil {
+			return nil, fmt.Errorf("opening the audit log: %w", err)
+		}
+		phlare.Cfg.API.AuditLogger = auditLogger
+	}
 
 	return phlare, nil
 }
@@ -279,20 +369,32 @@ func (f *Phlare) setupModuleManager() error {
 	mm.RegisterModule(UsageReport, f.initUsageReport)
 	mm.RegisterModule(QueryFrontend, f.initQueryFrontend)
 	mm.RegisterModule(QueryScheduler, f.initQueryScheduler)
+	mm.RegisterModule(Ruler, f.initRuler)
+	mm.RegisterModule(RegressionDetector, f.initRegressionDetector)
+	mm.RegisterModule(BillingExporter, f.initBillingExporter)
 	mm.RegisterModule(All, nil)
+	mm.RegisterModule(Write, nil)
+	mm.RegisterModule(Read, nil)
+	mm.RegisterModule(Backend, nil)
 
 	// Add dependencies
 	deps := map[string][]string{
-		All: {Ingester, Distributor, QueryScheduler, QueryFrontend, Querier, StoreGateway},
-
-		Server:         {GRPCGateway},
-		API:            {Server},
-		Distributor:    {Overrides, Ring, API, UsageReport},
-		Querier:        {Overrides, API, MemberlistKV, Ring, UsageReport},
-		QueryFrontend:  {OverridesExporter, API, MemberlistKV, UsageReport},
-		QueryScheduler: {Overrides, API, MemberlistKV, UsageReport},
-		Ingester:       {Overrides, API, MemberlistKV, Storage, UsageReport},
-		StoreGateway:   {API, Storage, Overrides, MemberlistKV, UsageReport},
+		All:     {Ingester, Distributor, QueryScheduler, QueryFrontend, Querier, StoreGateway},
+		Write:   {Ingester, Distributor},
+		Read:    {QueryFrontend, Querier},
+		Backend: {QueryScheduler, StoreGateway},
+
+		Server:             {GRPCGateway},
+		API:                {Server},
+		Distributor:        {Overrides, Ring, API, UsageReport},
+		Querier:            {Overrides, API, MemberlistKV, Ring, UsageReport},
+		QueryFrontend:      {OverridesExporter, API, MemberlistKV, UsageReport},
+		QueryScheduler:     {Overrides, API, MemberlistKV, UsageReport},
+		Ingester:           {Overrides, API, MemberlistKV, Storage, UsageReport},
+		StoreGateway:       {API, Storage, Overrides, MemberlistKV, UsageReport},
+		Ruler:              {API},
+		RegressionDetector: {API},
+		BillingExporter:    {API, Storage},
 
 		UsageReport:       {Storage, MemberlistKV},
 		Overrides:         {RuntimeConfig},
@@ -354,7 +456,8 @@ func (f *Phlare) Run() error {
 		}
 
 		// Start profiling when Pyroscope is ready
-		if !f.Cfg.SelfProfiling.DisablePush && f.Cfg.Target.String() == All {
+		// The profiles pushed to itself have no token to authenticate with.
+		if !f.Cfg.SelfProfiling.DisablePush && !f.Cfg.JWTAuth.Enabled && f.Cfg.APIKeys.File == "" && f.Cfg.Target.String() == All {
 			_, err := pyroscope.Start(pyroscope.Config{
 				ApplicationName: "pyroscope",
 				ServerAddress:   fmt.Sprintf("http://%s:%d", "localhost", f.Cfg.Server.HTTPListenPort),
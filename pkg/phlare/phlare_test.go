@@ -8,6 +8,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/go-kit/log"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/require"
 
 	statusv1 "github.com/grafana/pyroscope/api/gen/proto/go/status/v1"
@@ -80,3 +82,19 @@ func TestConfigDiff(t *testing.T) {
 		require.Equal(t, "limits:\n    max_label_name_length: 123\n", string(result.Data))
 	})
 }
+
+func TestReadWriteTargets(t *testing.T) {
+	f := &Phlare{logger: log.NewNopLogger()}
+	require.NoError(t, f.setupModuleManager())
+
+	for target, modules := range map[string][]string{
+		Write:   {Distributor, Ingester},
+		Read:    {QueryFrontend, Querier},
+		Backend: {QueryScheduler, StoreGateway},
+	} {
+		f.Cfg.Target = []string{target}
+		for _, m := range []string{Distributor, Ingester, QueryFrontend, Querier, QueryScheduler, StoreGateway} {
+			require.Equal(t, lo.Contains(modules, m), f.isModuleActive(m), "target %s, module %s", target, m)
+		}
+	}
+}
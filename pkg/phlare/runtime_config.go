@@ -1,10 +1,14 @@
 package phlare
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"sort"
 
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/runtimeconfig"
 	"gopkg.in/yaml.v3"
@@ -32,7 +36,21 @@ func (r runtimeConfigValues) validate() error {
 	return nil
 }
 
-func loadRuntimeConfig(r io.Reader) (interface{}, error) {
+// tenantErrors returns the validation errors of the overrides, by tenant.
+func (r runtimeConfigValues) tenantErrors() map[string]string {
+	errs := make(map[string]string)
+	for t, c := range r.TenantLimits {
+		if c == nil {
+			continue
+		}
+		if err := c.Validate(); err != nil {
+			errs[t] = err.Error()
+		}
+	}
+	return errs
+}
+
+func decodeRuntimeConfig(r io.Reader) (*runtimeConfigValues, error) {
 	overrides := &runtimeConfigValues{}
 
 	decoder := yaml.NewDecoder(r)
@@ -40,6 +58,14 @@ func loadRuntimeConfig(r io.Reader) (interface{}, error) {
 	if err := decoder.Decode(&overrides); err != nil {
 		return nil, err
 	}
+	return overrides, nil
+}
+
+func loadRuntimeConfig(r io.Reader) (interface{}, error) {
+	overrides, err := decodeRuntimeConfig(r)
+	if err != nil {
+		return nil, err
+	}
 	if err := overrides.validate(); err != nil {
 		return nil, err
 	}
@@ -51,12 +77,7 @@ type tenantLimitsFromRuntimeConfig struct {
 }
 
 func (t *tenantLimitsFromRuntimeConfig) AllByTenantID() map[string]*validation.Limits {
-	if t.c == nil {
-		return nil
-	}
-
-	cfg, ok := t.c.GetConfig().(*runtimeConfigValues)
-	if cfg != nil && ok {
+	if cfg := currentRuntimeConfig(t.c); cfg != nil {
 		return cfg.TenantLimits
 	}
 
@@ -76,10 +97,20 @@ func newTenantLimits(c *runtimeconfig.Manager) validation.TenantLimits {
 	return &tenantLimitsFromRuntimeConfig{c: c}
 }
 
+// currentRuntimeConfig returns the runtime config loaded by the manager, or
+// nil if none is loaded. The manager is nil without runtime config file.
+func currentRuntimeConfig(c *runtimeconfig.Manager) *runtimeConfigValues {
+	if c == nil {
+		return nil
+	}
+	cfg, _ := c.GetConfig().(*runtimeConfigValues)
+	return cfg
+}
+
 func runtimeConfigHandler(runtimeCfgManager *runtimeconfig.Manager, defaultLimits validation.Limits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cfg, ok := runtimeCfgManager.GetConfig().(*runtimeConfigValues)
-		if !ok || cfg == nil {
+		cfg := currentRuntimeConfig(runtimeCfgManager)
+		if cfg == nil {
 			util.WriteTextResponse(w, "runtime config file doesn't exist")
 			return
 		}
@@ -121,3 +152,159 @@ func runtimeConfigHandler(runtimeCfgManager *runtimeconfig.Manager, defaultLimit
 		util.WriteYAMLResponse(w, output)
 	}
 }
+
+// maxRuntimeConfigSize is the maximum size of a candidate runtime config.
+const maxRuntimeConfigSize = 16 << 20
+
+type runtimeConfigValidation struct {
+	Valid bool `yaml:"valid"`
+	// Error is set if the runtime config can't be decoded.
+	Error          string            `yaml:"error,omitempty"`
+	InvalidTenants map[string]string `yaml:"invalid_tenants,omitempty"`
+	Changes        []overridesChange `yaml:"changes"`
+}
+
+// overridesChange lists the limits of a tenant that differ between two
+// runtime configs.
+type overridesChange struct {
+	Tenant string        `yaml:"tenant"`
+	Fields []fieldChange `yaml:"fields"`
+}
+
+type fieldChange struct {
+	Field     string      `yaml:"field"`
+	Current   interface{} `yaml:"current"`
+	Candidate interface{} `yaml:"candidate"`
+}
+
+// runtimeConfigValidateHandler validates the candidate runtime config sent in
+// the request body, and reports the changes it would make to the limits of
+// the tenants, compared to the runtime config currently loaded, if any. The
+// response status is 422 if the candidate is invalid.
+func runtimeConfigValidateHandler(runtimeCfgManager *runtimeconfig.Manager, defaultLimits validation.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRuntimeConfigSize))
+		if err != nil {
+			httputil.ErrorWithStatus(w, err, http.StatusBadRequest)
+			return
+		}
+
+		report := runtimeConfigValidation{Valid: true, Changes: []overridesChange{}}
+		candidate, err := decodeRuntimeConfig(bytes.NewReader(body))
+		if err != nil {
+			report.Valid = false
+			report.Error = err.Error()
+			writeRuntimeConfigValidation(w, report)
+			return
+		}
+		if errs := candidate.tenantErrors(); len(errs) > 0 {
+			report.Valid = false
+			report.InvalidTenants = errs
+		}
+
+		var current map[string]*validation.Limits
+		if cfg := currentRuntimeConfig(runtimeCfgManager); cfg != nil {
+			current = cfg.TenantLimits
+		}
+		if report.Changes, err = diffOverrides(current, candidate.TenantLimits, defaultLimits); err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		writeRuntimeConfigValidation(w, report)
+	}
+}
+
+func writeRuntimeConfigValidation(w http.ResponseWriter, report runtimeConfigValidation) {
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !report.Valid {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	_, _ = w.Write(data)
+}
+
+// diffOverrides returns the limits that differ between the overrides of the
+// tenants, sorted by tenant and field. The limits of a tenant without
+// overrides are the default ones.
+func diffOverrides(current, candidate map[string]*validation.Limits, defaultLimits validation.Limits) ([]overridesChange, error) {
+	tenants := make(map[string]struct{}, len(current)+len(candidate))
+	for t := range current {
+		tenants[t] = struct{}{}
+	}
+	for t := range candidate {
+		tenants[t] = struct{}{}
+	}
+	limitsOf := func(overrides map[string]*validation.Limits, tenant string) (map[interface{}]interface{}, error) {
+		if l := overrides[tenant]; l != nil {
+			return util.YAMLMarshalUnmarshal(l)
+		}
+		return util.YAMLMarshalUnmarshal(defaultLimits)
+	}
+
+	changes := make([]overridesChange, 0, len(tenants))
+	for t := range tenants {
+		a, err := limitsOf(current, t)
+		if err != nil {
+			return nil, err
+		}
+		b, err := limitsOf(candidate, t)
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]struct{}, len(a))
+		for k := range a {
+			fields[fmt.Sprint(k)] = struct{}{}
+		}
+		for k := range b {
+			fields[fmt.Sprint(k)] = struct{}{}
+		}
+		var change overridesChange
+		for f := range fields {
+			if !reflect.DeepEqual(a[f], b[f]) {
+				change.Fields = append(change.Fields, fieldChange{Field: f, Current: a[f], Candidate: b[f]})
+			}
+		}
+		if len(change.Fields) == 0 {
+			continue
+		}
+		change.Tenant = t
+		sort.Slice(change.Fields, func(i, j int) bool { return change.Fields[i].Field < change.Fields[j].Field })
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Tenant < changes[j].Tenant })
+	return changes, nil
+}
+
+// logOverridesChanges logs the limits of the tenants changed by every reload
+// of the runtime config, as an audit trail of the changes. It returns once
+// the channel is closed.
+func logOverridesChanges(logger log.Logger, configs <-chan interface{}, defaultLimits validation.Limits) {
+	var (
+		current map[string]*validation.Limits
+		loaded  bool
+	)
+	for c := range configs {
+		cfg, ok := c.(*runtimeConfigValues)
+		if !ok || cfg == nil {
+			continue
+		}
+		if !loaded {
+			level.Info(logger).Log("msg", "runtime config loaded", "tenants", len(cfg.TenantLimits))
+		} else {
+			changes, err := diffOverrides(current, cfg.TenantLimits, defaultLimits)
+			if err != nil {
+				level.Warn(logger).Log("msg", "failed to compare the tenant overrides", "err", err)
+			}
+			for _, change := range changes {
+				for _, f := range change.Fields {
+					level.Info(logger).Log("msg", "tenant override changed", "tenant", change.Tenant, "field", f.Field, "old", fmt.Sprint(f.Current), "new", fmt.Sprint(f.Candidate))
+				}
+			}
+		}
+		current, loaded = cfg.TenantLimits, true
+	}
+}
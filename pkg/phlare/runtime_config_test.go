@@ -0,0 +1,94 @@
+package phlare
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_diffOverrides(t *testing.T) {
+	current, err := decodeRuntimeConfig(strings.NewReader(`
+overrides:
+  tenant-a:
+    max_label_name_length: 10
+  tenant-b:
+    max_label_value_length: 20
+`))
+	require.NoError(t, err)
+	candidate, err := decodeRuntimeConfig(strings.NewReader(`
+overrides:
+  tenant-a:
+    max_label_name_length: 10
+    max_label_names_per_series: 5
+  tenant-c:
+    max_label_value_length: 30
+`))
+	require.NoError(t, err)
+
+	changes, err := diffOverrides(current.TenantLimits, candidate.TenantLimits, validation.Limits{})
+	require.NoError(t, err)
+	require.Equal(t, []overridesChange{
+		{Tenant: "tenant-a", Fields: []fieldChange{
+			{Field: "max_label_names_per_series", Current: 0, Candidate: 5},
+		}},
+		// The limits of tenant-b fall back to the defaults.
+		{Tenant: "tenant-b", Fields: []fieldChange{
+			{Field: "max_label_value_length", Current: 20, Candidate: 0},
+		}},
+		{Tenant: "tenant-c", Fields: []fieldChange{
+			{Field: "max_label_value_length", Current: 0, Candidate: 30},
+		}},
+	}, changes)
+
+	changes, err = diffOverrides(candidate.TenantLimits, candidate.TenantLimits, validation.Limits{})
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func Test_runtimeConfigTenantErrors(t *testing.T) {
+	cfg, err := decodeRuntimeConfig(strings.NewReader(`
+overrides:
+  tenant-a:
+    timestamp_policy: invalid
+  tenant-b:
+    max_label_name_length: 10
+`))
+	require.NoError(t, err)
+	errs := cfg.tenantErrors()
+	require.Len(t, errs, 1)
+	require.Contains(t, errs, "tenant-a")
+	require.Error(t, cfg.validate())
+
+	_, err = decodeRuntimeConfig(strings.NewReader(`
+overrides:
+  tenant-a:
+    unknown_limit: 10
+`))
+	require.Error(t, err)
+}
+
+func Test_runtimeConfigValidateHandler(t *testing.T) {
+	// The runtime config is validated without runtime config file.
+	h := runtimeConfigValidateHandler(nil, validation.Limits{})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/runtime_config/validate", strings.NewReader(`
+overrides:
+  tenant-a:
+    max_label_name_length: 10
+`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), "tenant-a")
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/runtime_config/validate", strings.NewReader(`
+overrides:
+  tenant-a:
+    timestamp_policy: invalid
+`)))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -60,12 +60,22 @@ const (
 	//     memory entirely. Instead, each partition (row range) is read
 	//     from the block on demand at query time.
 	MetaVersion3 = MetaVersion(3)
+
+	// MetaVersion4 indicates the block format version.
+	//  1. Introduction of symdb v3:
+	//     - strings are deduplicated across the partitions: the function
+	//       names and file names are stored once per block.
+	//     - line numbers of the functions and locations are delta-encoded,
+	//       string references are dictionary-encoded.
+	//  2. Blocks of the previous versions are readable, and are upgraded
+	//     to this version when compacted, see phlaredb.UpgradeBlock.
+	MetaVersion4 = MetaVersion(4)
 )
 
 // IsValid returns true if the version is valid.
 func (v MetaVersion) IsValid() bool {
 	switch v {
-	case MetaVersion1, MetaVersion2, MetaVersion3:
+	case MetaVersion1, MetaVersion2, MetaVersion3, MetaVersion4:
 		return true
 	default:
 		return false
@@ -179,7 +189,7 @@ func NewMeta() *Meta {
 		MinTime: math.MaxInt64,
 		MaxTime: 0,
 		Labels:  make(map[string]string),
-		Version: MetaVersion3,
+		Version: MetaVersion4,
 	}
 }
 
@@ -197,6 +207,7 @@ func MetaFromDir(dir string) (*Meta, int64, error) {
 	case MetaVersion1:
 	case MetaVersion2:
 	case MetaVersion3:
+	case MetaVersion4:
 	default:
 		return nil, 0, errors.Errorf("unexpected meta file version %d", m.Version)
 	}
@@ -306,6 +317,7 @@ func Read(rc io.ReadCloser) (_ *Meta, err error) {
 	case MetaVersion1:
 	case MetaVersion2:
 	case MetaVersion3:
+	case MetaVersion4:
 	default:
 		return nil, errors.Errorf("unexpected meta file version %d", m.Version)
 	}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"math"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -41,8 +42,11 @@ import (
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	"github.com/grafana/pyroscope/pkg/phlaredb/query"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sketch"
 	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util"
 )
 
@@ -296,6 +300,17 @@ type singleBlockQuerier struct {
 	index    *index.Reader
 	profiles parquetReader[*schemav1.Profile, *schemav1.ProfilePersister]
 	symbols  symbolsResolver
+
+	// Exemplars are only present in blocks written by the head.
+	hasExemplars bool
+	exemplars    parquetReader[*schemav1.Exemplar, *schemav1.ExemplarPersister]
+	// The inverted index of the exemplars is missing in older blocks.
+	hasExemplarLabels bool
+	exemplarLabels    parquetReader[*schemav1.ExemplarLabel, *schemav1.ExemplarLabelPersister]
+
+	// Sketches are missing in the blocks written by older versions.
+	hasSketches bool
+	sketches    parquetReader[*schemav1.SeriesSketch, *schemav1.SeriesSketchPersister]
 }
 
 func NewSingleBlockQuerierFromMeta(phlarectx context.Context, bucketReader phlareobj.Bucket, meta *block.Meta) *singleBlockQuerier {
@@ -309,11 +324,29 @@ func NewSingleBlockQuerierFromMeta(phlarectx context.Context, bucketReader phlar
 		switch f.RelPath {
 		case q.profiles.relPath():
 			q.profiles.meta = f
+		case q.exemplars.relPath():
+			q.exemplars.meta = f
+			q.hasExemplars = true
+		case q.exemplarLabels.relPath():
+			q.exemplarLabels.meta = f
+			q.hasExemplarLabels = true
+		case q.sketches.relPath():
+			q.sketches.meta = f
+			q.hasSketches = true
 		}
 	}
 	q.tables = []tableReader{
 		&q.profiles,
 	}
+	if q.hasExemplars {
+		q.tables = append(q.tables, &q.exemplars)
+	}
+	if q.hasExemplarLabels {
+		q.tables = append(q.tables, &q.exemplarLabels)
+	}
+	if q.hasSketches {
+		q.tables = append(q.tables, &q.sketches)
+	}
 	return q
 }
 
@@ -388,6 +421,12 @@ type Querier interface {
 	MergeByLabels(ctx context.Context, rows iter.Iterator[Profile], by ...string) ([]*typesv1.Series, error)
 	MergePprof(ctx context.Context, rows iter.Iterator[Profile]) (*profile.Profile, error)
 	Series(ctx context.Context, params *ingestv1.SeriesRequest) ([]*typesv1.Labels, error)
+	// SelectSpanProfiles returns the samples of the matching profiles
+	// attributed to the requested spans.
+	SelectSpanProfiles(ctx context.Context, params *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error)
+	// SeriesSketches returns the sketches of the distribution of the totals
+	// of the profiles of the matching series.
+	SeriesSketches(ctx context.Context, params *ingestv1.SelectProfilesRequest) (map[model.Fingerprint]*sketch.DDSketch, error)
 	Open(ctx context.Context) error
 	// Sorts profiles for retrieval.
 	Sort([]Profile) []Profile
@@ -439,6 +478,76 @@ func (queriers Queriers) ForTimeRange(_ context.Context, start, end model.Time)
 	return result, nil
 }
 
+// WithinBudget returns the most recent queriers whose blocks fit within
+// the given number of blocks and total size in bytes. A non-positive
+// limit means no limit. The second return value reports whether some
+// queriers were left out.
+func (queriers Queriers) WithinBudget(maxBlocks, maxBytes int64) (Queriers, bool) {
+	if maxBlocks <= 0 && maxBytes <= 0 {
+		return queriers, false
+	}
+	byRecency := make([]int, len(queriers))
+	for i := range byRecency {
+		byRecency[i] = i
+	}
+	sort.SliceStable(byRecency, func(i, j int) bool {
+		_, a := queriers[byRecency[i]].Bounds()
+		_, b := queriers[byRecency[j]].Bounds()
+		return a > b
+	})
+	var (
+		blocks     int64
+		totalBytes int64
+		keep       = make([]bool, len(queriers))
+	)
+	for _, i := range byRecency {
+		size := querierSize(queriers[i])
+		if (maxBlocks > 0 && blocks+1 > maxBlocks) || (maxBytes > 0 && totalBytes+size > maxBytes) {
+			break
+		}
+		blocks++
+		totalBytes += size
+		keep[i] = true
+	}
+	if blocks == int64(len(queriers)) {
+		return queriers, false
+	}
+	result := make(Queriers, 0, blocks)
+	for i, q := range queriers {
+		if keep[i] {
+			result = append(result, q)
+		}
+	}
+	return result, true
+}
+
+// querierSize returns the size in bytes of the block files of the
+// querier, or 0 if the querier is not backed by a block.
+func querierSize(q Querier) int64 {
+	m, ok := q.(interface{ Meta() block.Meta })
+	if !ok {
+		return 0
+	}
+	var size int64
+	for _, f := range m.Meta().Files {
+		size += int64(f.SizeBytes)
+	}
+	return size
+}
+
+// BlockIDs returns the IDs of the blocks the queriers are backed by.
+func (queriers Queriers) BlockIDs() []string {
+	ids := make([]string, 0, len(queriers))
+	for _, q := range queriers {
+		if m, ok := q.(interface{ Meta() block.Meta }); ok {
+			if meta := m.Meta(); meta.ULID != (ulid.ULID{}) {
+				ids = append(ids, meta.ULID.String())
+			}
+		}
+	}
+	return ids
+}
+
 type BlockGetter func(ctx context.Context, start, end model.Time) (Queriers, error)
 
 // SelectMatchingProfiles returns a list iterator of profiles matching the given request.
@@ -493,10 +602,33 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		otlog.String("profile_id", request.Type.ID),
 	)
 
+	// The excluded stacks are dropped before the result is sent, so that
+	// their weight never leaves the data node.
+	var filter phlaremodel.TreeFilter
+	if r.ExcludeStacks != "" {
+		if filter.ExcludeStacks, err = regexp.Compile(r.ExcludeStacks); err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, errors.Wrap(err, "invalid exclude stacks regular expression"))
+		}
+	}
+
 	queriers, err := blockGetter(ctx, model.Time(request.Start), model.Time(request.End))
 	if err != nil {
 		return err
 	}
+	queriers, partial := queriers.WithinBudget(r.MaxBlocks, r.MaxBytes)
+	if partial {
+		sp.LogFields(otlog.Int("queriers_within_budget", len(queriers)))
+		if !r.AllowPartialResults {
+			return connect.NewError(connect.CodeResourceExhausted, fmt.Errorf(
+				"the query exceeds the limit of %d blocks or %d bytes read, narrow down the time range or the selector", r.MaxBlocks, r.MaxBytes))
+		}
+	}
+
+	// The statistics are collected in the context, so that the symbols
+	// readers can account for the data they fetch.
+	st, ctx := stats.ContextWithEmptyStats(ctx)
+	st.AddFetchedBlocks(uint64(len(queriers)))
+	selectStart := time.Now()
 
 	iters, err := SelectMatchingProfiles(ctx, request, queriers)
 	if err != nil {
@@ -511,6 +643,8 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 	if err != nil {
 		return err
 	}
+	st.AddSelectProfilesTime(time.Since(selectStart))
+	mergeStart := time.Now()
 
 	var m sync.Mutex
 	t := new(phlaremodel.Tree)
@@ -522,6 +656,7 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		if len(selectedProfiles[i]) == 0 {
 			continue
 		}
+		st.AddFetchedProfiles(uint64(len(selectedProfiles[i])))
 		// Sort profiles for better read locality.
 		// Merge async the result so we can continue streaming profiles.
 		g.Go(util.RecoverPanic(func() error {
@@ -547,10 +682,12 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		return err
 	}
 
+	t = filter.Apply(t)
 	var buf bytes.Buffer
 	if err = t.MarshalTruncate(&buf, r.GetMaxNodes()); err != nil {
 		return err
 	}
+	st.AddMergeStacktracesTime(time.Since(mergeStart))
 
 	// sends the final result to the client.
 	sp.LogFields(otlog.String("msg", "sending the final result to the client"))
@@ -558,6 +695,14 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		Result: &ingestv1.MergeProfilesStacktracesResult{
 			Format:    ingestv1.StacktracesMergeFormat_MERGE_FORMAT_TREE,
 			TreeBytes: buf.Bytes(),
+			Partial:   partial,
+
+			FetchedBlocksCount:   st.LoadFetchedBlocks(),
+			FetchedProfilesCount: st.LoadFetchedProfiles(),
+			FetchedSymbolBytes:   st.LoadFetchedSymbolBytes(),
+			SelectProfilesTime:   int64(st.LoadSelectProfilesTime()),
+			MergeStacktracesTime: int64(st.LoadMergeStacktracesTime()),
+			QueriedBlocks:        queriers.BlockIDs(),
 		},
 	})
 	if err != nil {
@@ -588,6 +733,9 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 	request := r.Request
 	by := r.By
 	sort.Strings(by)
+	if err := phlaremodel.ValidateHeatmapBuckets(r.HeatmapBuckets); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
 	sp.LogFields(
 		otlog.String("start", model.Time(request.Start).Time().String()),
 		otlog.String("end", model.Time(request.End).Time().String()),
@@ -638,6 +786,11 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 			if err != nil {
 				return err
 			}
+			if len(r.HeatmapBuckets) > 0 {
+				// The points are the total values of the individual profiles:
+				// they are counted in their bucket before being summed.
+				merge = phlaremodel.HeatmapSeries(merge, r.HeatmapBuckets)
+			}
 			sync.Do(func() {
 				result = append(result, merge)
 			})
@@ -804,11 +957,17 @@ func Series(ctx context.Context, req *ingestv1.SeriesRequest, blockGetter BlockG
 	sort.Slice(labelsSet, func(i, j int) bool {
 		return phlaremodel.CompareLabelPairs(labelsSet[i].Labels, labelsSet[j].Labels) < 0
 	})
-	return &ingestv1.SeriesResponse{
-		LabelsSet: lo.UniqBy(labelsSet, func(set *typesv1.Labels) uint64 {
+	res := new(ingestv1.SeriesResponse)
+	res.LabelsSet, res.NextPageToken, err = phlaremodel.PaginateLabelsSet(
+		lo.UniqBy(labelsSet, func(set *typesv1.Labels) uint64 {
 			return phlaremodel.Labels(set.Labels).Hash()
 		}),
-	}, nil
+		req.Limit, req.PageToken,
+	)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return res, nil
 }
 
 var maxBlockProfile Profile = BlockProfile{
@@ -862,10 +1021,21 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "failed to parse label selectors: "+err.Error())
 	}
+	shard, matchers, err := sharding.RemoveShardFromMatchers(matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse query shard: "+err.Error())
+	}
+	if shard != nil && !shard.ContainsBlock(b.meta.Labels[sharding.CompactorShardIDLabel]) {
+		return iter.NewEmptyIterator[Profile](), nil
+	}
 	if params.Type == nil {
 		return nil, errors.New("no profileType given")
 	}
-	matchers = append(matchers, phlaremodel.SelectorFromProfileType(params.Type))
+	typeMatchers, err := phlaremodel.SelectorsFromProfileType(params.Type)
+	if err != nil {
+		return nil, err
+	}
+	matchers = append(matchers, typeMatchers...)
 
 	postings, err := PostingsForMatchers(b.index, nil, matchers...)
 	if err != nil {
@@ -884,6 +1054,9 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 		if err != nil {
 			return nil, err
 		}
+		if shard != nil && !shard.Contains(fp) {
+			continue
+		}
 		if lblsExisting, exists := lblsPerRef[int64(chks[0].SeriesIndex)]; exists {
 			// Compare to check if there is a clash
 			if phlaremodel.CompareLabelPairs(lbls, lblsExisting.lbs) != 0 {
@@ -1139,7 +1312,7 @@ func (q *singleBlockQuerier) openFiles(ctx context.Context) error {
 			q.symbols, err = newSymbolsResolverV1(ctx, q.bucket, q.meta)
 		case block.MetaVersion2:
 			q.symbols, err = newSymbolsResolverV2(ctx, q.bucket, q.meta)
-		case block.MetaVersion3:
+		case block.MetaVersion3, block.MetaVersion4:
 			q.symbols, err = symdb.Open(ctx, q.bucket, q.meta)
 		default:
 			panic(fmt.Errorf("unsupported block version %d", q.meta.Version))
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
@@ -688,3 +689,31 @@ func Benchmark_singleBlockQuerier_Series(b *testing.B) {
 		}
 	})
 }
+
+func TestQueriers_WithinBudget(t *testing.T) {
+	newQuerier := func(maxTime int64, size uint64) Querier {
+		return &singleBlockQuerier{meta: &block.Meta{
+			MinTime: model.Time(maxTime - 10),
+			MaxTime: model.Time(maxTime),
+			Files:   []block.File{{RelPath: "profiles.parquet", SizeBytes: size}},
+		}}
+	}
+	queriers := Queriers{newQuerier(10, 100), newQuerier(30, 100), newQuerier(20, 100)}
+
+	result, partial := queriers.WithinBudget(0, 0)
+	assert.False(t, partial)
+	assert.Equal(t, queriers, result)
+
+	result, partial = queriers.WithinBudget(3, 300)
+	assert.False(t, partial)
+	assert.Equal(t, queriers, result)
+
+	// The most recent blocks are kept, in their original order.
+	result, partial = queriers.WithinBudget(2, 0)
+	assert.True(t, partial)
+	assert.Equal(t, Queriers{queriers[1], queriers[2]}, result)
+
+	result, partial = queriers.WithinBudget(0, 150)
+	assert.True(t, partial)
+	assert.Equal(t, Queriers{queriers[1]}, result)
+}
@@ -0,0 +1,118 @@
+package phlaredb
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+var supportedCompressions = []string{CompressionNone, CompressionSnappy, CompressionZstd}
+
+const (
+	defaultPageBufferSize = 3 * 1024 * 1024
+	maxZstdLevel          = int(zstd.SpeedBestCompression)
+)
+
+// BlockWriterConfig configures how the parquet tables of the blocks are
+// written, per section of the block. The stack traces are not stored in
+// parquet tables, and are not affected.
+type BlockWriterConfig struct {
+	Profiles TableWriterConfig `yaml:"profiles"`
+	Symbols  TableWriterConfig `yaml:"symbols"`
+}
+
+func (cfg *BlockWriterConfig) RegisterFlags(f *flag.FlagSet) {
+	cfg.Profiles.RegisterFlagsWithPrefix("pyroscopedb.block-writer.profiles.", "profiles table", f)
+	cfg.Symbols.RegisterFlagsWithPrefix("pyroscopedb.block-writer.symbols.", "symbols tables (locations, functions, mappings and strings)", f)
+}
+
+func (cfg *BlockWriterConfig) Validate() error {
+	if err := cfg.Profiles.Validate(); err != nil {
+		return fmt.Errorf("invalid profiles table writer config: %w", err)
+	}
+	if err := cfg.Symbols.Validate(); err != nil {
+		return fmt.Errorf("invalid symbols tables writer config: %w", err)
+	}
+	return nil
+}
+
+// TableWriterConfig trades CPU for storage and query latency: larger row
+// groups and pages, and stronger compression make the tables smaller, but
+// are more expensive to write and to read selectively. Zero values stand
+// for the defaults.
+type TableWriterConfig struct {
+	MaxRowGroupRows  int    `yaml:"max_row_group_rows" category:"advanced"`
+	PageBufferSize   int    `yaml:"page_buffer_size" category:"advanced"`
+	Compression      string `yaml:"compression" category:"advanced"`
+	CompressionLevel int    `yaml:"compression_level" category:"advanced"`
+}
+
+func (cfg *TableWriterConfig) RegisterFlagsWithPrefix(prefix, table string, f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxRowGroupRows, prefix+"max-row-group-rows", defaultParquetConfig.MaxBufferRowCount, fmt.Sprintf("Maximum number of rows in a row group of the %s.", table))
+	f.IntVar(&cfg.PageBufferSize, prefix+"page-buffer-size", defaultPageBufferSize, fmt.Sprintf("Size in bytes of the pages of the columns of the %s. Larger pages compress better, smaller pages are cheaper to read selectively.", table))
+	f.StringVar(&cfg.Compression, prefix+"compression", CompressionNone, fmt.Sprintf("Compression codec of the pages of the %s. Supported values: %s.", table, strings.Join(supportedCompressions, ", ")))
+	f.IntVar(&cfg.CompressionLevel, prefix+"compression-level", 0, fmt.Sprintf("Level of the zstd compression of the %s, from 1 (fastest) to %d (best compression). 0 uses the default level.", table, maxZstdLevel))
+}
+
+func (cfg *TableWriterConfig) Validate() error {
+	if cfg.MaxRowGroupRows < 0 {
+		return errors.New("max row group rows must not be negative")
+	}
+	if cfg.PageBufferSize < 0 {
+		return errors.New("page buffer size must not be negative")
+	}
+	switch cfg.Compression {
+	case "", CompressionNone, CompressionSnappy:
+		if cfg.CompressionLevel != 0 {
+			return fmt.Errorf("compression level is only supported by %s", CompressionZstd)
+		}
+	case CompressionZstd:
+		if cfg.CompressionLevel < 0 || cfg.CompressionLevel > maxZstdLevel {
+			return fmt.Errorf("zstd compression level must be between 1 and %d", maxZstdLevel)
+		}
+	default:
+		return fmt.Errorf("unsupported compression %q, supported values: %s", cfg.Compression, strings.Join(supportedCompressions, ", "))
+	}
+	return nil
+}
+
+func (cfg *TableWriterConfig) maxRowGroupRows() int {
+	if cfg.MaxRowGroupRows > 0 {
+		return cfg.MaxRowGroupRows
+	}
+	return defaultParquetConfig.MaxBufferRowCount
+}
+
+func (cfg *TableWriterConfig) pageBufferSize() int {
+	if cfg.PageBufferSize > 0 {
+		return cfg.PageBufferSize
+	}
+	return defaultPageBufferSize
+}
+
+// writerOptions returns the options of the parquet writer of the table.
+// The config is expected to be valid.
+func (cfg *TableWriterConfig) writerOptions() []parquet.WriterOption {
+	options := []parquet.WriterOption{parquet.PageBufferSize(cfg.pageBufferSize())}
+	switch cfg.Compression {
+	case CompressionSnappy:
+		options = append(options, parquet.Compression(&parquet.Snappy))
+	case CompressionZstd:
+		level := zstd.DefaultLevel
+		if cfg.CompressionLevel > 0 {
+			level = zstd.Level(cfg.CompressionLevel)
+		}
+		options = append(options, parquet.Compression(&zstd.Codec{Level: level}))
+	}
+	return options
+}
@@ -0,0 +1,77 @@
+package phlaredb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func TestTableWriterConfig_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cfg   TableWriterConfig
+		valid bool
+	}{
+		{name: "defaults", valid: true},
+		{name: "snappy", cfg: TableWriterConfig{Compression: CompressionSnappy}, valid: true},
+		{name: "zstd", cfg: TableWriterConfig{Compression: CompressionZstd, CompressionLevel: 4}, valid: true},
+		{name: "zstd level out of range", cfg: TableWriterConfig{Compression: CompressionZstd, CompressionLevel: 5}},
+		{name: "level without zstd", cfg: TableWriterConfig{Compression: CompressionSnappy, CompressionLevel: 1}},
+		{name: "unknown compression", cfg: TableWriterConfig{Compression: "lz4"}},
+		{name: "negative row group rows", cfg: TableWriterConfig{MaxRowGroupRows: -1}},
+		{name: "negative page buffer size", cfg: TableWriterConfig{PageBufferSize: -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHeadFlush_BlockWriterConfig(t *testing.T) {
+	ctx := testContext(t)
+	head, err := NewHead(ctx, Config{
+		DataPath: t.TempDir(),
+		BlockWriter: BlockWriterConfig{
+			Profiles: TableWriterConfig{MaxRowGroupRows: 2, Compression: CompressionZstd, CompressionLevel: 1},
+			Symbols:  TableWriterConfig{Compression: CompressionSnappy},
+		},
+	}, NoLimit)
+	require.NoError(t, err)
+	for i := int64(1); i <= 5; i++ {
+		p := testhelper.NewProfileBuilder(i*1e9).CPUProfile().
+			ForStacktraceString("foo", "bar").AddSamples(i)
+		require.NoError(t, head.Ingest(context.Background(), p.Profile, p.UUID, p.Labels...))
+	}
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+
+	profiles := openParquetFile(t, filepath.Join(head.localPath, "profiles.parquet"))
+	require.Len(t, profiles.RowGroups(), 3)
+	require.Equal(t, format.Zstd, profiles.Metadata().RowGroups[0].Columns[0].MetaData.Codec)
+	functions := openParquetFile(t, filepath.Join(head.localPath, "symbols", "functions.parquet"))
+	require.Equal(t, format.Snappy, functions.Metadata().RowGroups[0].Columns[0].MetaData.Codec)
+}
+
+func openParquetFile(t *testing.T, path string) *parquet.File {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	pf, err := parquet.OpenFile(f, stat.Size())
+	require.NoError(t, err)
+	return pf
+}
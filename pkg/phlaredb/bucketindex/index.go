@@ -23,6 +23,7 @@ const (
 	IndexCompressedFilename = IndexFilename + ".gz"
 	IndexVersion1           = 1
 	IndexVersion2           = 2 // Added CompactorShardID field.
+	IndexVersion3           = 3 // Added SizeBytes and NumSeries fields.
 )
 
 // Index contains all known blocks and markers of a tenant.
@@ -78,6 +79,12 @@ type Block struct {
 
 	// Block's compactor shard ID, copied from tsdb.CompactorShardIDExternalLabel label.
 	CompactorShardID string `json:"compactor_shard_id,omitempty"`
+
+	// SizeBytes is the total size of the files of the block.
+	SizeBytes uint64 `json:"size_bytes,omitempty"`
+
+	// NumSeries is the number of series of the block.
+	NumSeries uint64 `json:"num_series,omitempty"`
 }
 
 // Within returns whether the block contains samples within the provided range.
@@ -103,11 +110,17 @@ func (m *Block) String() string {
 }
 
 func BlockFromMeta(meta block.Meta) *Block {
+	var size uint64
+	for _, f := range meta.Files {
+		size += f.SizeBytes
+	}
 	return &Block{
 		ID:               meta.ULID,
 		MinTime:          meta.MinTime,
 		MaxTime:          meta.MaxTime,
 		CompactorShardID: meta.Labels[sharding.CompactorShardIDLabel],
+		SizeBytes:        size,
+		NumSeries:        meta.Stats.NumSeries,
 	}
 }
 
@@ -45,12 +45,19 @@ func TestBlockFromMeta(t *testing.T) {
 				Labels: map[string]string{
 					sharding.CompactorShardIDLabel: "1_of_8",
 				},
+				Stats: block.BlockStats{NumSeries: 5},
+				Files: []block.File{
+					{RelPath: "index.tsdb", SizeBytes: 100},
+					{RelPath: "profiles.parquet", SizeBytes: 200},
+				},
 			},
 			expected: Block{
 				ID:               blockID,
 				MinTime:          model.Time(10),
 				MaxTime:          model.Time(20),
 				CompactorShardID: "1_of_8",
+				SizeBytes:        300,
+				NumSeries:        5,
 			},
 		},
 	}
@@ -62,6 +69,25 @@ func TestBlockFromMeta(t *testing.T) {
 	}
 }
 
+func TestIndex_Usage(t *testing.T) {
+	idx := &Index{
+		Blocks: Blocks{
+			{ID: ulid.MustNew(1, nil), MinTime: 20, MaxTime: 30, SizeBytes: 100, NumSeries: 2},
+			{ID: ulid.MustNew(2, nil), MinTime: 10, MaxTime: 20, SizeBytes: 50, NumSeries: 3},
+		},
+		UpdatedAt: 1000,
+	}
+	assert.Equal(t, Usage{
+		Blocks:          2,
+		CompressedBytes: 150,
+		Series:          5,
+		OldestBlockTime: 10,
+		NewestBlockTime: 30,
+		UpdatedAt:       1000,
+	}, idx.Usage())
+	assert.Equal(t, Usage{}, (&Index{}).Usage())
+}
+
 func TestBlock_Within(t *testing.T) {
 	tests := []struct {
 		block    *Block
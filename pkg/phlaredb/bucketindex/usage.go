@@ -0,0 +1,38 @@
+package bucketindex
+
+import (
+	"github.com/prometheus/common/model"
+)
+
+// Usage is the storage used by the blocks of a tenant.
+type Usage struct {
+	Blocks          int        `json:"blocks"`
+	CompressedBytes uint64     `json:"compressed_bytes"`
+	Series          uint64     `json:"series"`
+	OldestBlockTime model.Time `json:"oldest_block_time,omitempty"`
+	NewestBlockTime model.Time `json:"newest_block_time,omitempty"`
+	// UpdatedAt is the time the bucket index the usage is computed from
+	// was updated (seconds precision).
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Usage returns the storage used by the blocks of the index. The blocks
+// marked for deletion are included until they are deleted. The series of the
+// blocks overlapping in time are counted as many times as they appear.
+func (idx *Index) Usage() Usage {
+	u := Usage{
+		Blocks:    len(idx.Blocks),
+		UpdatedAt: idx.UpdatedAt,
+	}
+	for i, b := range idx.Blocks {
+		u.CompressedBytes += b.SizeBytes
+		u.Series += b.NumSeries
+		if i == 0 || b.MinTime < u.OldestBlockTime {
+			u.OldestBlockTime = b.MinTime
+		}
+		if i == 0 || b.MaxTime > u.NewestBlockTime {
+			u.NewestBlockTime = b.MaxTime
+		}
+	}
+	return u
+}
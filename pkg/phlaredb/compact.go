@@ -26,6 +26,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sketch"
 	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
 	"github.com/grafana/pyroscope/pkg/util"
@@ -56,6 +57,37 @@ func CompactWithSplitting(ctx context.Context, src []BlockReader, shardsCount ui
 	if len(src) <= 1 && shardsCount == 1 {
 		return nil, errors.New("not enough blocks to compact")
 	}
+	return compactWithSplitting(ctx, src, shardsCount, dst, BlockWriterConfig{})
+}
+
+// UpgradeBlock rewrites the block in the latest block format version.
+// Compaction always produces blocks of the latest version: the compactor
+// uses UpgradeBlock to migrate the blocks that are not to be compacted
+// anymore, such as the ones of the highest compaction level. The output
+// block keeps the compaction level and the sources of the input block.
+func UpgradeBlock(ctx context.Context, src BlockReader, dst string) (block.Meta, error) {
+	srcMeta := src.Meta()
+	if srcMeta.Version >= block.MetaVersion4 {
+		return block.Meta{}, fmt.Errorf("block %s is already of version %d", srcMeta.ULID, srcMeta.Version)
+	}
+	metas, err := compactWithSplitting(ctx, []BlockReader{src}, 1, dst, BlockWriterConfig{})
+	if err != nil {
+		return block.Meta{}, err
+	}
+	if len(metas) == 0 {
+		return block.Meta{}, fmt.Errorf("block %s has no samples", srcMeta.ULID)
+	}
+	meta := metas[0]
+	meta.Compaction.Level = srcMeta.Compaction.Level
+	if _, err = meta.WriteToFile(util.Logger, filepath.Join(dst, meta.ULID.String())); err != nil {
+		return block.Meta{}, err
+	}
+	return meta, nil
+}
+
+func compactWithSplitting(ctx context.Context, src []BlockReader, shardsCount uint64, dst string, cfg BlockWriterConfig) (
+	[]block.Meta, error,
+) {
 	var (
 		writers  = make([]*blockWriter, shardsCount)
 		shardBy  = shardByFingerprint
@@ -73,7 +105,7 @@ func CompactWithSplitting(ctx context.Context, src []BlockReader, shardsCount ui
 	for i := range writers {
 		meta := outMeta.Clone()
 		meta.ULID = ulid.MustNew(outBlocksTime, rand.Reader)
-		writers[i], err = newBlockWriter(dst, meta)
+		writers[i], err = newBlockWriter(dst, meta, cfg)
 		if err != nil {
 			return nil, fmt.Errorf("create block writer: %w", err)
 		}
@@ -125,28 +157,30 @@ type blockWriter struct {
 	indexRewriter   *indexRewriter
 	symbolsRewriter *symbolsRewriter
 	profilesWriter  *profilesWriter
+	sketches        map[model.Fingerprint]*sketch.DDSketch
 	path            string
 	meta            *block.Meta
 	totalProfiles   uint64
 	min, max        int64
 }
 
-func newBlockWriter(dst string, meta *block.Meta) (*blockWriter, error) {
+func newBlockWriter(dst string, meta *block.Meta, cfg BlockWriterConfig) (*blockWriter, error) {
 	blockPath := filepath.Join(dst, meta.ULID.String())
 
 	if err := os.MkdirAll(blockPath, 0o777); err != nil {
 		return nil, err
 	}
 
-	profileWriter, err := newProfileWriter(blockPath)
+	profileWriter, err := newProfileWriter(blockPath, &cfg.Profiles)
 	if err != nil {
 		return nil, err
 	}
 
 	return &blockWriter{
 		indexRewriter:   newIndexRewriter(blockPath),
-		symbolsRewriter: newSymbolsRewriter(blockPath),
+		symbolsRewriter: newSymbolsRewriter(blockPath, &cfg.Symbols),
 		profilesWriter:  profileWriter,
+		sketches:        make(map[model.Fingerprint]*sketch.DDSketch),
 		path:            blockPath,
 		meta:            meta,
 		min:             math.MaxInt64,
@@ -167,6 +201,14 @@ func (bw *blockWriter) WriteRow(r profileRow) error {
 	if err := bw.profilesWriter.WriteRow(r); err != nil {
 		return err
 	}
+	if v, ok := r.row.TotalValue(); ok {
+		x, ok := bw.sketches[r.fp]
+		if !ok {
+			x = sketch.New(sketch.DefaultRelativeAccuracy)
+			bw.sketches[r.fp] = x
+		}
+		x.Add(float64(v))
+	}
 	bw.totalProfiles++
 	if r.timeNanos < bw.min {
 		bw.min = r.timeNanos
@@ -187,6 +229,11 @@ func (bw *blockWriter) Close(ctx context.Context) error {
 	if err := bw.profilesWriter.Close(); err != nil {
 		return err
 	}
+	if len(bw.sketches) > 0 {
+		if err := writeSeriesSketches(bw.path, bw.sketches); err != nil {
+			return err
+		}
+	}
 	metaFiles, err := metaFilesFromDir(bw.path)
 	if err != nil {
 		return err
@@ -211,14 +258,14 @@ type profilesWriter struct {
 	buf []parquet.Row
 }
 
-func newProfileWriter(path string) (*profilesWriter, error) {
+func newProfileWriter(path string, cfg *TableWriterConfig) (*profilesWriter, error) {
 	profilePath := filepath.Join(path, (&schemav1.ProfilePersister{}).Name()+block.ParquetSuffix)
 	profileFile, err := os.OpenFile(profilePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
 		return nil, err
 	}
 	return &profilesWriter{
-		GenericWriter: newParquetProfileWriter(profileFile, parquet.MaxRowsPerRowGroup(int64(defaultParquetConfig.MaxBufferRowCount))),
+		GenericWriter: newParquetProfileWriter(profileFile, append(cfg.writerOptions(), parquet.MaxRowsPerRowGroup(int64(cfg.maxRowGroupRows())))...),
 		file:          profileFile,
 		buf:           make([]parquet.Row, 1),
 	}, nil
@@ -593,12 +640,13 @@ type symbolsRewriter struct {
 	numSamples uint64
 }
 
-func newSymbolsRewriter(path string) *symbolsRewriter {
+func newSymbolsRewriter(path string, cfg *TableWriterConfig) *symbolsRewriter {
 	return &symbolsRewriter{
 		w: symdb.NewSymDB(symdb.DefaultConfig().
 			WithDirectory(filepath.Join(path, symdb.DefaultDirName)).
 			WithParquetConfig(symdb.ParquetConfig{
-				MaxBufferRowCount: defaultParquetConfig.MaxBufferRowCount,
+				MaxBufferRowCount: cfg.maxRowGroupRows(),
+				WriterOptions:     cfg.writerOptions(),
 			})),
 		rewriters: make(map[BlockReader]*symdb.Rewriter),
 	}
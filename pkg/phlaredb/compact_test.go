@@ -21,6 +21,7 @@ import (
 
 	ingesterv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/objstore/client"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
@@ -507,21 +508,22 @@ func TestFlushMeta(t *testing.T) {
 	require.Equal(t, false, b.Meta().Compaction.Failed)
 	require.Equal(t, []string(nil), b.Meta().Compaction.Hints)
 	require.Equal(t, []tsdb.BlockDesc(nil), b.Meta().Compaction.Parents)
-	require.Equal(t, block.MetaVersion3, b.Meta().Version)
+	require.Equal(t, block.MetaVersion4, b.Meta().Version)
 	require.Equal(t, model.Time(1000), b.Meta().MinTime)
 	require.Equal(t, model.Time(3000), b.Meta().MaxTime)
 	require.Equal(t, uint64(3), b.Meta().Stats.NumSeries)
 	require.Equal(t, uint64(3), b.Meta().Stats.NumSamples)
 	require.Equal(t, uint64(3), b.Meta().Stats.NumProfiles)
-	require.Len(t, b.Meta().Files, 8)
+	require.Len(t, b.Meta().Files, 9)
 	require.Equal(t, "index.tsdb", b.Meta().Files[0].RelPath)
 	require.Equal(t, "profiles.parquet", b.Meta().Files[1].RelPath)
-	require.Equal(t, "symbols/functions.parquet", b.Meta().Files[2].RelPath)
-	require.Equal(t, "symbols/index.symdb", b.Meta().Files[3].RelPath)
-	require.Equal(t, "symbols/locations.parquet", b.Meta().Files[4].RelPath)
-	require.Equal(t, "symbols/mappings.parquet", b.Meta().Files[5].RelPath)
-	require.Equal(t, "symbols/stacktraces.symdb", b.Meta().Files[6].RelPath)
-	require.Equal(t, "symbols/strings.parquet", b.Meta().Files[7].RelPath)
+	require.Equal(t, "sketches.parquet", b.Meta().Files[2].RelPath)
+	require.Equal(t, "symbols/functions.parquet", b.Meta().Files[3].RelPath)
+	require.Equal(t, "symbols/index.symdb", b.Meta().Files[4].RelPath)
+	require.Equal(t, "symbols/locations.parquet", b.Meta().Files[5].RelPath)
+	require.Equal(t, "symbols/mappings.parquet", b.Meta().Files[6].RelPath)
+	require.Equal(t, "symbols/stacktraces.symdb", b.Meta().Files[7].RelPath)
+	require.Equal(t, "symbols/strings.parquet", b.Meta().Files[8].RelPath)
 }
 
 func newBlock(t *testing.T, generator func() []*testhelper.ProfileBuilder) BlockReader {
@@ -587,6 +589,54 @@ func blockQuerierFromMeta(t *testing.T, dir string, m block.Meta) Querier {
 	return blk
 }
 
+func TestUpgradeBlock(t *testing.T) {
+	ctx := context.Background()
+	const blockID = "01HA2V3CPSZ9E0HMQNNHH89WSS"
+	meta, err := block.ReadMetaFromDir(filepath.Join("testdata", blockID))
+	require.NoError(t, err)
+	require.Equal(t, block.MetaVersion3, meta.Version)
+	src := blockQuerierFromMeta(t, "testdata", *meta).(*singleBlockQuerier)
+	require.NoError(t, src.symbols.Load(ctx))
+
+	dst := t.TempDir()
+	upgraded, err := UpgradeBlock(ctx, src, dst)
+	require.NoError(t, err)
+	require.Equal(t, block.MetaVersion4, upgraded.Version)
+	require.Equal(t, meta.Compaction.Level, upgraded.Compaction.Level)
+	require.Equal(t, meta.Compaction.Sources, upgraded.Compaction.Sources)
+	require.Equal(t, meta.Stats.NumProfiles, upgraded.Stats.NumProfiles)
+	require.Equal(t, meta.Stats.NumSeries, upgraded.Stats.NumSeries)
+	written, err := block.ReadMetaFromDir(filepath.Join(dst, upgraded.ULID.String()))
+	require.NoError(t, err)
+	require.Equal(t, upgraded.Compaction.Level, written.Compaction.Level)
+
+	strings := func(m *block.Meta) uint64 {
+		return m.FileByRelPath("symbols/strings.parquet").Parquet.NumRows
+	}
+	require.Less(t, strings(&upgraded), strings(meta))
+
+	req := &ingesterv1.SelectProfilesRequest{
+		LabelSelector: "{}",
+		Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+		Start:         int64(meta.MinTime),
+		End:           int64(meta.MaxTime) + 1,
+	}
+	mergeStacktraces := func(q Querier) string {
+		it, err := q.SelectMatchingProfiles(ctx, req)
+		require.NoError(t, err)
+		profiles, err := iter.Slice(it)
+		require.NoError(t, err)
+		// The profiles are read in the order of the rows.
+		tree, err := q.MergeByStacktraces(ctx, iter.NewSliceIterator(q.Sort(profiles)))
+		require.NoError(t, err)
+		return tree.String()
+	}
+	require.Equal(t, mergeStacktraces(src), mergeStacktraces(blockQuerierFromMeta(t, dst, upgraded)))
+
+	_, err = UpgradeBlock(ctx, blockQuerierFromMeta(t, dst, upgraded).(*singleBlockQuerier), t.TempDir())
+	require.Error(t, err)
+}
+
 func TestCompactMetas(t *testing.T) {
 	actual := compactMetas([]block.Meta{
 		{
@@ -31,6 +31,16 @@ func newDeltaProfiles() *deltaProfiles {
 	}
 }
 
+// evict removes the state of the series: the next profile of the
+// series is used as the base for the delta computation.
+func (d *deltaProfiles) evict(fps []model.Fingerprint) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, fp := range fps {
+		delete(d.highestSamples, fp)
+	}
+}
+
 func newSampleDict(samples schemav1.Samples) map[uint32]uint64 {
 	dict := make(map[uint32]uint64)
 	for i, s := range samples.StacktraceIDs {
@@ -0,0 +1,808 @@
+package phlaredb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/query"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+// The pprof sample labels the exemplars are built from. The span ID is
+// expected to be hex-encoded, as set by the OpenTelemetry integrations.
+const (
+	spanIDLabelName   = "span_id"
+	traceIDLabelName  = "trace_id"
+	endpointLabelName = "endpoint"
+)
+
+// spanSamples are the samples of a profile attributed to a span, or to an
+// endpoint.
+type spanSamples struct {
+	spanID   uint64
+	traceID  string
+	endpoint string
+	samples  []*profilev1.Sample
+}
+
+type spanSamplesKey struct {
+	spanID   uint64
+	endpoint string
+}
+
+// samplesBySpan groups the profile samples by the span ID and endpoint
+// labels. It must be called before the profile symbols are written: sample
+// label references are only valid for the original string table.
+func samplesBySpan(p *profilev1.Profile) []*spanSamples {
+	spanKey, traceKey, endpointKey := int64(-1), int64(-1), int64(-1)
+	for i, s := range p.StringTable {
+		switch s {
+		case spanIDLabelName:
+			spanKey = int64(i)
+		case traceIDLabelName:
+			traceKey = int64(i)
+		case endpointLabelName:
+			endpointKey = int64(i)
+		}
+	}
+	if spanKey < 0 && endpointKey < 0 {
+		return nil
+	}
+	var (
+		spans   []*spanSamples
+		bySpan  = make(map[spanSamplesKey]*spanSamples)
+		strings = p.StringTable
+	)
+	for _, s := range p.Sample {
+		var (
+			spanID   uint64
+			traceID  string
+			endpoint string
+		)
+		for _, l := range s.Label {
+			if l.Str < 0 || l.Str >= int64(len(strings)) {
+				continue
+			}
+			switch l.Key {
+			case spanKey:
+				spanID, _ = parseSpanID(strings[l.Str])
+			case traceKey:
+				traceID = normalizeTraceID(strings[l.Str])
+			case endpointKey:
+				endpoint = strings[l.Str]
+			}
+		}
+		if spanID == 0 && endpoint == "" {
+			continue
+		}
+		k := spanSamplesKey{spanID: spanID, endpoint: endpoint}
+		x, ok := bySpan[k]
+		if !ok {
+			x = &spanSamples{spanID: spanID, traceID: traceID, endpoint: endpoint}
+			bySpan[k] = x
+			spans = append(spans, x)
+		}
+		x.samples = append(x.samples, s)
+	}
+	return spans
+}
+
+func parseSpanID(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+func formatSpanID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func normalizeTraceID(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// The exemplars are written in small row groups and pages: the span IDs
+// are looked up with the page statistics, and the row groups with the
+// dictionaries of the label columns.
+const (
+	exemplarsRowGroupRows = 4 << 10
+	exemplarsPageBytes    = 8 << 10
+)
+
+// exemplarLabel is a label of the inverted index of the exemplars.
+type exemplarLabel struct {
+	name, value string
+}
+
+// exemplarStore keeps the exemplars of the head in memory, and writes
+// them to the block on flush. Exemplars are not carried over by block
+// compaction.
+type exemplarStore struct {
+	path string
+
+	mu        sync.RWMutex
+	exemplars []*schemav1.Exemplar
+	bySpan    map[uint64][]uint32
+	byLabel   map[exemplarLabel][]uint32
+	size      atomic.Uint64
+}
+
+func newExemplarStore() *exemplarStore {
+	return &exemplarStore{
+		bySpan:  make(map[uint64][]uint32),
+		byLabel: make(map[exemplarLabel][]uint32),
+	}
+}
+
+func (s *exemplarStore) Name() string {
+	return new(schemav1.ExemplarPersister).Name()
+}
+
+func (s *exemplarStore) Size() uint64 { return s.size.Load() }
+
+func (s *exemplarStore) MemorySize() uint64 { return s.size.Load() }
+
+func (s *exemplarStore) Init(path string, _ *ParquetConfig, _ *headMetrics) error {
+	s.path = path
+	return nil
+}
+
+func (s *exemplarStore) Flush(context.Context) (numRows uint64, numRowGroups uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.exemplars) == 0 {
+		return 0, 0, nil
+	}
+	exemplars := make([]*schemav1.Exemplar, len(s.exemplars))
+	copy(exemplars, s.exemplars)
+	// The rows of the inverted index are the rows of the file: the
+	// exemplars are sorted before they are written.
+	sort.SliceStable(exemplars, func(i, j int) bool {
+		if exemplars[i].SpanID != exemplars[j].SpanID {
+			return exemplars[i].SpanID < exemplars[j].SpanID
+		}
+		return exemplars[i].TimeNanos < exemplars[j].TimeNanos
+	})
+	if numRowGroups, err = writeExemplars(s.path, exemplars); err != nil {
+		return 0, 0, err
+	}
+	if err = writeExemplarLabels(s.path, exemplars); err != nil {
+		return 0, 0, err
+	}
+	return uint64(len(exemplars)), numRowGroups, nil
+}
+
+func (s *exemplarStore) Close() error { return nil }
+
+// ingest records the samples attributed to spans. The sample location
+// references must be already rewritten by the symbols writer.
+func (s *exemplarStore) ingest(w *symdb.PartitionWriter, spans []*spanSamples, partition uint64, id uuid.UUID, timeNanos int64, types []int, fingerprints []model.Fingerprint) {
+	exemplars := make([]*schemav1.Exemplar, 0, len(spans)*len(types))
+	var size uint64
+	for _, span := range spans {
+		stacktraces := make([]*schemav1.Stacktrace, len(span.samples))
+		for i, sample := range span.samples {
+			stacktraces[i] = &schemav1.Stacktrace{LocationIDs: sample.LocationId}
+		}
+		ids := make([]uint32, len(stacktraces))
+		w.AppendStacktraces(ids, stacktraces)
+		for _, t := range types {
+			samples := schemav1.NewSamples(len(ids))
+			for i, sample := range span.samples {
+				samples.StacktraceIDs = append(samples.StacktraceIDs, ids[i])
+				samples.Values = append(samples.Values, uint64(sample.Value[t]))
+			}
+			if samples = samples.Compact(true); samples.Len() == 0 {
+				continue
+			}
+			exemplars = append(exemplars, &schemav1.Exemplar{
+				SpanID:              span.spanID,
+				TraceID:             span.traceID,
+				Endpoint:            span.endpoint,
+				ProfileID:           id,
+				SeriesFingerprint:   uint64(fingerprints[t]),
+				StacktracePartition: partition,
+				TimeNanos:           timeNanos,
+				StacktraceIDs:       samples.StacktraceIDs,
+				Values:              samples.Values,
+			})
+			size += uint64(len(span.traceID) + len(span.endpoint) + 64 + samples.Len()*(4+8))
+		}
+	}
+	s.mu.Lock()
+	for _, e := range exemplars {
+		row := uint32(len(s.exemplars))
+		s.exemplars = append(s.exemplars, e)
+		if e.SpanID != 0 {
+			s.bySpan[e.SpanID] = append(s.bySpan[e.SpanID], row)
+		}
+		for _, l := range labelsOfExemplar(e) {
+			s.byLabel[l] = append(s.byLabel[l], row)
+		}
+	}
+	s.mu.Unlock()
+	s.size.Add(size)
+}
+
+func (s *exemplarStore) selectExemplars(f *spanFilter) []*schemav1.Exemplar {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sets [][]uint32
+	if len(f.spans) > 0 {
+		var rows []uint32
+		for id := range f.spans {
+			rows = append(rows, s.bySpan[id]...)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+		sets = append(sets, rows)
+	}
+	for _, l := range f.labels() {
+		sets = append(sets, s.byLabel[l])
+	}
+	var selected []*schemav1.Exemplar
+	for _, row := range intersectRows(sets) {
+		if e := s.exemplars[row]; f.matches(e) {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// labelsOfExemplar returns the labels the exemplar is indexed by.
+func labelsOfExemplar(e *schemav1.Exemplar) []exemplarLabel {
+	labels := make([]exemplarLabel, 0, 2)
+	if e.TraceID != "" {
+		labels = append(labels, exemplarLabel{name: traceIDLabelName, value: e.TraceID})
+	}
+	if e.Endpoint != "" {
+		labels = append(labels, exemplarLabel{name: endpointLabelName, value: e.Endpoint})
+	}
+	return labels
+}
+
+// intersectRows returns the rows present in all the sets, which must be
+// sorted in ascending order.
+func intersectRows(sets [][]uint32) []uint32 {
+	if len(sets) == 0 {
+		return nil
+	}
+	rows := sets[0]
+	for _, set := range sets[1:] {
+		var (
+			out  = make([]uint32, 0, len(rows))
+			i, j int
+		)
+		for i < len(rows) && j < len(set) {
+			switch {
+			case rows[i] < set[j]:
+				i++
+			case rows[i] > set[j]:
+				j++
+			default:
+				out = append(out, rows[i])
+				i++
+				j++
+			}
+		}
+		rows = out
+	}
+	return rows
+}
+
+// writeExemplars writes the exemplars file of the block in dir, and returns
+// the number of row groups written.
+func writeExemplars(dir string, exemplars []*schemav1.Exemplar) (uint64, error) {
+	var persister schemav1.ExemplarPersister
+	f, err := os.Create(filepath.Join(dir, persister.Name()+block.ParquetSuffix))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := parquet.NewWriter(f, persister.Schema(),
+		parquet.SortingWriterConfig(persister.SortingColumns()),
+		parquet.PageBufferSize(exemplarsPageBytes),
+	)
+	var (
+		rows         = make([]parquet.Row, 0, exemplarsRowGroupRows)
+		numRowGroups uint64
+	)
+	for len(exemplars) > 0 {
+		n := len(exemplars)
+		if n > exemplarsRowGroupRows {
+			n = exemplarsRowGroupRows
+		}
+		rows = rows[:0]
+		for _, e := range exemplars[:n] {
+			rows = append(rows, persister.Deconstruct(nil, 0, e))
+		}
+		exemplars = exemplars[n:]
+		if _, err = w.WriteRows(rows); err != nil {
+			return 0, errors.Wrap(err, "writing exemplars")
+		}
+		// Each flush writes a row group.
+		numRowGroups++
+		if err = w.Flush(); err != nil {
+			return 0, errors.Wrap(err, "writing exemplars")
+		}
+	}
+	if err = w.Close(); err != nil {
+		return 0, errors.Wrap(err, "writing exemplars")
+	}
+	return numRowGroups, nil
+}
+
+// writeExemplarLabels writes the inverted index of the exemplars, which
+// must be in the order of the exemplars file.
+func writeExemplarLabels(dir string, exemplars []*schemav1.Exemplar) error {
+	rowsByLabel := make(map[exemplarLabel][]uint32)
+	for row, e := range exemplars {
+		for _, l := range labelsOfExemplar(e) {
+			rowsByLabel[l] = append(rowsByLabel[l], uint32(row))
+		}
+	}
+	if len(rowsByLabel) == 0 {
+		return nil
+	}
+	labels := make([]*schemav1.ExemplarLabel, 0, len(rowsByLabel))
+	for l, rows := range rowsByLabel {
+		labels = append(labels, &schemav1.ExemplarLabel{Name: l.name, Value: l.value, Rows: rows})
+	}
+	f, err := os.Create(filepath.Join(dir, new(schemav1.ExemplarLabelPersister).Name()+block.ParquetSuffix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := new(schemav1.ReadWriter[*schemav1.ExemplarLabel, *schemav1.ExemplarLabelPersister])
+	if err = w.WriteParquetFile(f, labels); err != nil {
+		return errors.Wrap(err, "writing exemplar labels")
+	}
+	return nil
+}
+
+// spanFilter selects the exemplars matching a span profile request.
+type spanFilter struct {
+	spans        map[uint64]struct{}
+	traceID      string
+	endpoint     string
+	fingerprints map[model.Fingerprint]struct{}
+	start, end   int64
+}
+
+func newSpanFilter(req *ingestv1.MergeSpanProfileRequest) (*spanFilter, error) {
+	if req.Request == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no profile selector given"))
+	}
+	if len(req.SpanSelector) == 0 && req.TraceId == "" && req.Endpoint == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("either span selector, trace ID or endpoint must be specified"))
+	}
+	f := &spanFilter{
+		spans:    make(map[uint64]struct{}, len(req.SpanSelector)),
+		traceID:  normalizeTraceID(req.TraceId),
+		endpoint: req.Endpoint,
+		start:    model.Time(req.Request.Start).UnixNano(),
+		end:      model.Time(req.Request.End).UnixNano(),
+	}
+	for _, s := range req.SpanSelector {
+		id, err := parseSpanID(s)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid span ID %q: %w", s, err))
+		}
+		f.spans[id] = struct{}{}
+	}
+	return f, nil
+}
+
+// labels returns the labels of the inverted index selected by the filter.
+func (f *spanFilter) labels() []exemplarLabel {
+	return labelsOfExemplar(&schemav1.Exemplar{TraceID: f.traceID, Endpoint: f.endpoint})
+}
+
+func (f *spanFilter) spanIDs() []uint64 {
+	ids := make([]uint64, 0, len(f.spans))
+	for id := range f.spans {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (f *spanFilter) matches(e *schemav1.Exemplar) bool {
+	if e.TimeNanos < f.start || e.TimeNanos > f.end {
+		return false
+	}
+	if _, ok := f.fingerprints[model.Fingerprint(e.SeriesFingerprint)]; !ok {
+		return false
+	}
+	if len(f.spans) > 0 {
+		if _, ok := f.spans[e.SpanID]; !ok {
+			return false
+		}
+	}
+	if f.endpoint != "" && f.endpoint != e.Endpoint {
+		return false
+	}
+	return f.traceID == "" || f.traceID == e.TraceID
+}
+
+// resolveSpanProfiles builds the trees of the exemplar samples.
+func resolveSpanProfiles(ctx context.Context, symbols symdb.SymbolsReader, exemplars []*schemav1.Exemplar) ([]*ingestv1.SpanProfile, error) {
+	profiles := make([]*ingestv1.SpanProfile, 0, len(exemplars))
+	var buf bytes.Buffer
+	for _, e := range exemplars {
+		r := symdb.NewResolver(ctx, symbols)
+		r.AddSamples(e.StacktracePartition, e.Samples())
+		t, err := r.Tree()
+		r.Release()
+		if err != nil {
+			return nil, err
+		}
+		buf.Reset()
+		if err = t.MarshalTruncate(&buf, 0); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, &ingestv1.SpanProfile{
+			ProfileId:         uuid.UUID(e.ProfileID).String(),
+			SeriesFingerprint: e.SeriesFingerprint,
+			SpanId:            formatSpanID(e.SpanID),
+			Endpoint:          e.Endpoint,
+			TreeBytes:         bytes.Clone(buf.Bytes()),
+		})
+	}
+	return profiles, nil
+}
+
+func (q *headOnDiskQuerier) SelectSpanProfiles(context.Context, *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error) {
+	// Exemplars are kept in memory until the head is flushed.
+	return nil, nil
+}
+
+func (q *headInMemoryQuerier) SelectSpanProfiles(ctx context.Context, req *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectSpanProfiles - HeadInMemory")
+	defer sp.Finish()
+	f, err := newSpanFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	fps, err := q.head.profiles.index.selectMatchingFPs(ctx, req.Request)
+	if err != nil {
+		return nil, err
+	}
+	f.fingerprints = make(map[model.Fingerprint]struct{}, len(fps))
+	for _, fp := range fps {
+		f.fingerprints[fp] = struct{}{}
+	}
+	return resolveSpanProfiles(ctx, q.head.symdb, q.head.exemplars.selectExemplars(f))
+}
+
+func (b *singleBlockQuerier) SelectSpanProfiles(ctx context.Context, req *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectSpanProfiles - Block")
+	defer sp.Finish()
+	if !b.hasExemplars {
+		return nil, nil
+	}
+	f, err := newSpanFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.Open(ctx); err != nil {
+		return nil, err
+	}
+	if f.fingerprints, err = b.matchingFingerprints(req.Request); err != nil {
+		return nil, err
+	}
+	exemplars, err := b.selectExemplars(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return resolveSpanProfiles(ctx, b.symbols, exemplars)
+}
+
+func (b *singleBlockQuerier) matchingFingerprints(params *ingestv1.SelectProfilesRequest) (map[model.Fingerprint]struct{}, error) {
+	matchers, err := parser.ParseMetricSelector(params.LabelSelector)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse label selectors: "+err.Error())
+	}
+	shard, matchers, err := sharding.RemoveShardFromMatchers(matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse query shard: "+err.Error())
+	}
+	if shard != nil && !shard.ContainsBlock(b.meta.Labels[sharding.CompactorShardIDLabel]) {
+		return map[model.Fingerprint]struct{}{}, nil
+	}
+	if params.Type == nil {
+		return nil, errors.New("no profileType given")
+	}
+	typeMatchers, err := phlaremodel.SelectorsFromProfileType(params.Type)
+	if err != nil {
+		return nil, err
+	}
+	matchers = append(matchers, typeMatchers...)
+	postings, err := PostingsForMatchers(b.index, nil, matchers...)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lbls = make(phlaremodel.Labels, 0, 6)
+		chks = make([]index.ChunkMeta, 1)
+		fps  = make(map[model.Fingerprint]struct{})
+	)
+	for postings.Next() {
+		fp, err := b.index.Series(postings.At(), &lbls, &chks)
+		if err != nil {
+			return nil, err
+		}
+		if shard != nil && !shard.Contains(fp) {
+			continue
+		}
+		fps[model.Fingerprint(fp)] = struct{}{}
+	}
+	return fps, postings.Err()
+}
+
+func (b *singleBlockQuerier) selectExemplars(ctx context.Context, f *spanFilter) ([]*schemav1.Exemplar, error) {
+	labels := f.labels()
+	if len(labels) > 0 && !b.hasExemplarLabels {
+		// Blocks written before the inverted index are scanned.
+		return b.scanExemplars(f)
+	}
+	var sets [][]uint32
+	if len(f.spans) > 0 {
+		rows, err := b.exemplarRowsOfSpans(ctx, f.spanIDs())
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, rows)
+	}
+	for _, l := range labels {
+		rows, err := b.exemplarRowsOfLabel(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, rows)
+	}
+	var (
+		persister schemav1.ExemplarPersister
+		selected  []*schemav1.Exemplar
+	)
+	err := readRowsByNumber(b.exemplars.file.RowGroups(), intersectRows(sets), func(row parquet.Row) error {
+		_, e, err := persister.Reconstruct(row)
+		if err != nil {
+			return err
+		}
+		if f.matches(e) {
+			selected = append(selected, e)
+		}
+		return nil
+	})
+	return selected, err
+}
+
+// exemplarRowsOfSpans returns the rows of the exemplars of the spans. The
+// exemplars are sorted by span ID: the pages are selected by their bounds.
+func (b *singleBlockQuerier) exemplarRowsOfSpans(ctx context.Context, spanIDs []uint64) ([]uint32, error) {
+	it := b.exemplars.columnIter(ctx, "SpanID", query.NewUint64InPredicate(spanIDs), "SpanID")
+	defer it.Close()
+	var rows []uint32
+	for it.Next() {
+		rows = append(rows, uint32(it.At().RowNumber[0]))
+	}
+	return rows, it.Err()
+}
+
+// exemplarRowsOfLabel returns the rows of the exemplars with the label,
+// from the inverted index.
+func (b *singleBlockQuerier) exemplarRowsOfLabel(ctx context.Context, l exemplarLabel) ([]uint32, error) {
+	it := query.NewBinaryJoinIterator(0,
+		b.exemplarLabels.columnIter(ctx, "Name", query.NewStringInPredicate([]string{l.name}), "Name"),
+		b.exemplarLabels.columnIter(ctx, "Value", query.NewStringInPredicate([]string{l.value}), "Value"),
+	)
+	defer it.Close()
+	var entries []uint32
+	for it.Next() {
+		entries = append(entries, uint32(it.At().RowNumber[0]))
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	var (
+		persister schemav1.ExemplarLabelPersister
+		rows      []uint32
+	)
+	err := readRowsByNumber(b.exemplarLabels.file.RowGroups(), entries, func(row parquet.Row) error {
+		_, x, err := persister.Reconstruct(row)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, x.Rows...)
+		return nil
+	})
+	return rows, err
+}
+
+// scanExemplars reads all the exemplars of the block.
+func (b *singleBlockQuerier) scanExemplars(f *spanFilter) ([]*schemav1.Exemplar, error) {
+	var (
+		persister schemav1.ExemplarPersister
+		selected  []*schemav1.Exemplar
+		buf       = make([]parquet.Row, 256)
+	)
+	for _, rg := range b.exemplars.file.RowGroups() {
+		if err := func() error {
+			rows := rg.Rows()
+			defer rows.Close()
+			for {
+				n, err := rows.ReadRows(buf)
+				for _, row := range buf[:n] {
+					_, e, rErr := persister.Reconstruct(row)
+					if rErr != nil {
+						return rErr
+					}
+					if f.matches(e) {
+						selected = append(selected, e)
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}(); err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// readRowsByNumber calls fn with the rows of the row groups, given their
+// numbers in the file, in ascending order.
+func readRowsByNumber(rowGroups []parquet.RowGroup, rowNums []uint32, fn func(parquet.Row) error) error {
+	var (
+		buf   = make([]parquet.Row, 1)
+		first int64
+	)
+	for _, rg := range rowGroups {
+		if len(rowNums) == 0 {
+			return nil
+		}
+		numRows := rg.NumRows()
+		if int64(rowNums[0]) >= first+numRows {
+			first += numRows
+			continue
+		}
+		if err := func() error {
+			rows := rg.Rows()
+			defer rows.Close()
+			next := int64(-1)
+			for len(rowNums) > 0 && int64(rowNums[0]) < first+numRows {
+				row := int64(rowNums[0]) - first
+				rowNums = rowNums[1:]
+				if row != next {
+					if err := rows.SeekToRow(row); err != nil {
+						return err
+					}
+				}
+				n, err := rows.ReadRows(buf)
+				if n == 0 {
+					if err == nil || errors.Is(err, io.EOF) {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+				if err = fn(buf[0]); err != nil {
+					return err
+				}
+				next = row + 1
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+		first += numRows
+	}
+	return nil
+}
+
+// MergeSpanProfile returns the samples attributed to the requested spans,
+// trace or endpoint. Profiles are identified by their ID and series: the
+// response may be merged with the responses of other replicas.
+func MergeSpanProfile(ctx context.Context, req *ingestv1.MergeSpanProfileRequest, blockGetter BlockGetter) (*ingestv1.MergeSpanProfileResponse, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeSpanProfile")
+	defer sp.Finish()
+	if _, err := newSpanFilter(req); err != nil {
+		return nil, err
+	}
+	queriers, err := blockGetter(ctx, model.Time(req.Request.Start), model.Time(req.Request.End))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		profiles = make(map[spanProfileKey]*ingestv1.SpanProfile)
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(50)
+	for _, q := range queriers {
+		q := q
+		g.Go(util.RecoverPanic(func() error {
+			selected, err := q.SelectSpanProfiles(ctx, req)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			for _, p := range selected {
+				profiles[keyOfSpanProfile(p)] = p
+			}
+			mu.Unlock()
+			return nil
+		}))
+	}
+	if err = g.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := &ingestv1.MergeSpanProfileResponse{Profiles: make([]*ingestv1.SpanProfile, 0, len(profiles))}
+	for _, p := range profiles {
+		res.Profiles = append(res.Profiles, p)
+	}
+	sort.Slice(res.Profiles, func(i, j int) bool {
+		return lessSpanProfileKey(keyOfSpanProfile(res.Profiles[i]), keyOfSpanProfile(res.Profiles[j]))
+	})
+	return res, nil
+}
+
+type spanProfileKey struct {
+	profileID   string
+	fingerprint uint64
+	spanID      string
+	endpoint    string
+}
+
+func keyOfSpanProfile(p *ingestv1.SpanProfile) spanProfileKey {
+	return spanProfileKey{
+		profileID:   p.ProfileId,
+		fingerprint: p.SeriesFingerprint,
+		spanID:      p.SpanId,
+		endpoint:    p.Endpoint,
+	}
+}
+
+func lessSpanProfileKey(a, b spanProfileKey) bool {
+	if a.spanID != b.spanID {
+		return a.spanID < b.spanID
+	}
+	if a.profileID != b.profileID {
+		return a.profileID < b.profileID
+	}
+	if a.fingerprint != b.fingerprint {
+		return a.fingerprint < b.fingerprint
+	}
+	return a.endpoint < b.endpoint
+}
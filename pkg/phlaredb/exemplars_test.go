@@ -0,0 +1,100 @@
+package phlaredb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func newSpanProfile(ts int64) *testhelper.ProfileBuilder {
+	p := testhelper.NewProfileBuilder(ts).CPUProfile().
+		ForStacktraceString("foo", "bar").AddSamples(10).
+		ForStacktraceString("foo", "baz").AddSamples(20).
+		ForStacktraceString("foo", "qux").AddSamples(40)
+	spanKey := int64(len(p.StringTable))
+	traceKey := spanKey + 1
+	endpointKey := spanKey + 2
+	p.StringTable = append(p.StringTable, spanIDLabelName, traceIDLabelName, endpointLabelName, "00000000000000aa", "00000000000000bb", "0123456789ABCDEF", "/a", "/b")
+	p.Sample[0].Label = []*profilev1.Label{{Key: spanKey, Str: spanKey + 3}, {Key: traceKey, Str: spanKey + 5}, {Key: endpointKey, Str: spanKey + 6}}
+	p.Sample[1].Label = []*profilev1.Label{{Key: spanKey, Str: spanKey + 4}, {Key: traceKey, Str: spanKey + 5}}
+	p.Sample[2].Label = []*profilev1.Label{{Key: endpointKey, Str: spanKey + 7}}
+	return p
+}
+
+func Test_SpanProfiles(t *testing.T) {
+	head := newTestHead(t)
+	ctx := context.Background()
+	for _, ts := range []int64{1e9, 2e9} {
+		p := newSpanProfile(ts)
+		require.NoError(t, head.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+	}
+
+	request := func(spans []string, traceID, endpoint string) *ingestv1.MergeSpanProfileRequest {
+		return &ingestv1.MergeSpanProfileRequest{
+			Request: &ingestv1.SelectProfilesRequest{
+				LabelSelector: `{job="foo"}`,
+				Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+				Start:         0,
+				End:           10000,
+			},
+			SpanSelector: spans,
+			TraceId:      traceID,
+			Endpoint:     endpoint,
+		}
+	}
+	assertTotal := func(getter BlockGetter, req *ingestv1.MergeSpanProfileRequest, profiles int, total int64) {
+		t.Helper()
+		res, err := MergeSpanProfile(ctx, req, getter)
+		require.NoError(t, err)
+		require.Len(t, res.Profiles, profiles)
+		merged := new(phlaremodel.Tree)
+		for _, p := range res.Profiles {
+			tree, err := phlaremodel.UnmarshalTree(p.TreeBytes)
+			require.NoError(t, err)
+			merged.Merge(tree)
+		}
+		assert.Equal(t, total, merged.Total())
+	}
+
+	for _, getter := range []BlockGetter{head.Queriers().ForTimeRange, nil} {
+		if getter == nil {
+			require.NoError(t, head.Flush(ctx))
+			require.NoError(t, head.Move())
+			b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+			require.NoError(t, err)
+			q := NewBlockQuerier(ctx, b)
+			require.NoError(t, q.Sync(ctx))
+			getter = q.Queriers().ForTimeRange
+		}
+		assertTotal(getter, request([]string{"00000000000000aa"}, "", ""), 2, 20)
+		assertTotal(getter, request([]string{"00000000000000aa", "bb"}, "", ""), 4, 60)
+		assertTotal(getter, request(nil, "0123456789abcdef", ""), 4, 60)
+		assertTotal(getter, request([]string{"cc"}, "", ""), 0, 0)
+		assertTotal(getter, request(nil, "", "/a"), 2, 20)
+		assertTotal(getter, request(nil, "", "/b"), 2, 80)
+		assertTotal(getter, request(nil, "0123456789abcdef", "/a"), 2, 20)
+		assertTotal(getter, request([]string{"bb"}, "", "/a"), 0, 0)
+		assertTotal(getter, request(nil, "", "/c"), 0, 0)
+	}
+
+	_, err := MergeSpanProfile(ctx, request(nil, "", ""), head.Queriers().ForTimeRange)
+	require.Error(t, err)
+	_, err = MergeSpanProfile(ctx, request([]string{"xyz"}, "", ""), head.Queriers().ForTimeRange)
+	require.Error(t, err)
+}
+
+func Test_intersectRows(t *testing.T) {
+	require.Nil(t, intersectRows(nil))
+	require.Equal(t, []uint32{1, 2, 3}, intersectRows([][]uint32{{1, 2, 3}}))
+	require.Equal(t, []uint32{2, 5}, intersectRows([][]uint32{{1, 2, 3, 5}, {2, 4, 5, 6}, {0, 2, 5}}))
+	require.Empty(t, intersectRows([][]uint32{{1, 3}, {2, 4}}))
+}
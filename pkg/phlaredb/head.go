@@ -14,6 +14,7 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/gogo/status"
 	"github.com/google/uuid"
+	"github.com/grafana/dskit/multierror"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
@@ -33,6 +34,8 @@ import (
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
+	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 var defaultParquetConfig = &ParquetConfig{
@@ -66,19 +69,31 @@ type Head struct {
 	parquetConfig *ParquetConfig
 	symdb         *symdb.SymDB
 	profiles      *profileStore
+	exemplars     *exemplarStore
+	sketches      *sketchStore
 	totalSamples  *atomic.Uint64
 	tables        []Table
 	delta         *deltaProfiles
 
-	limiter TenantLimiter
+	limiter           TenantLimiter
+	outOfOrderWindow  time.Duration
+	seriesIdleTimeout func() time.Duration
+
+	wal             *headWAL // nil if the WAL is disabled.
+	walSyncInterval time.Duration
 }
 
 const (
 	pathHead          = "head"
 	PathLocal         = "local"
+	pathSplit         = "split"
 	defaultFolderMode = 0o755
 )
 
+// seriesEvictionInterval is how often idle series are evicted from
+// the head, if a series idle timeout is configured.
+const seriesEvictionInterval = time.Minute
+
 func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Head, error) {
 	// todo if tenantLimiter is nil ....
 	parquetConfig := *defaultParquetConfig
@@ -91,8 +106,10 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 		meta:         block.NewMeta(),
 		totalSamples: atomic.NewUint64(0),
 
-		parquetConfig: &parquetConfig,
-		limiter:       limiter,
+		parquetConfig:     &parquetConfig,
+		limiter:           limiter,
+		outOfOrderWindow:  cfg.OutOfOrderWindow,
+		seriesIdleTimeout: cfg.SeriesIdleTimeout,
 	}
 	h.headPath = filepath.Join(cfg.DataPath, pathHead, h.meta.ULID.String())
 	h.localPath = filepath.Join(cfg.DataPath, PathLocal, h.meta.ULID.String())
@@ -102,6 +119,10 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 	}
 
 	h.parquetConfig.MaxRowGroupBytes = cfg.RowGroupTargetSize
+	if rows := cfg.BlockWriter.Profiles.MaxRowGroupRows; rows > 0 {
+		h.parquetConfig.MaxBufferRowCount = rows
+	}
+	h.parquetConfig.WriterOptions = cfg.BlockWriter.Profiles.writerOptions()
 
 	// ensure folder is writable
 	err := os.MkdirAll(h.headPath, defaultFolderMode)
@@ -109,11 +130,24 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 		return nil, err
 	}
 
+	if cfg.WAL.Enabled {
+		if h.wal, err = openHeadWAL(h.headPath, cfg.WAL, h.metrics); err != nil {
+			return nil, err
+		}
+		if cfg.WAL.SyncPolicy == WALSyncInterval {
+			h.walSyncInterval = cfg.WAL.SyncInterval
+		}
+	}
+
 	// create profile store
 	h.profiles = newProfileStore(phlarectx)
+	h.exemplars = newExemplarStore()
+	h.sketches = newSketchStore()
 	h.delta = newDeltaProfiles()
 	h.tables = []Table{
 		h.profiles,
+		h.exemplars,
+		h.sketches,
 	}
 	for _, t := range h.tables {
 		if err := t.Init(h.headPath, h.parquetConfig, h.metrics); err != nil {
@@ -121,11 +155,17 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 		}
 	}
 
+	symbolsConfig := symdb.ParquetConfig{
+		MaxBufferRowCount: h.parquetConfig.MaxBufferRowCount,
+		WriterOptions:     cfg.BlockWriter.Symbols.writerOptions(),
+	}
+	if rows := cfg.BlockWriter.Symbols.MaxRowGroupRows; rows > 0 {
+		symbolsConfig.MaxBufferRowCount = rows
+	}
 	h.symdb = symdb.NewSymDB(symdb.DefaultConfig().
 		WithDirectory(filepath.Join(h.headPath, symdb.DefaultDirName)).
-		WithParquetConfig(symdb.ParquetConfig{
-			MaxBufferRowCount: h.parquetConfig.MaxBufferRowCount,
-		}))
+		WithParquetConfig(symbolsConfig).
+		WithInternPool(cfg.SymbolsInternPool))
 
 	h.wg.Add(1)
 	go h.loop()
@@ -135,46 +175,121 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 
 func (h *Head) MemorySize() uint64 {
 	// TODO: TSDB index
-	return h.profiles.MemorySize() + h.symdb.MemorySize()
+	return h.profiles.MemorySize() + h.exemplars.MemorySize() + h.sketches.MemorySize() + h.symdb.MemorySize()
+}
+
+// HeadMemoryStats is the breakdown of the memory held by the head.
+type HeadMemoryStats struct {
+	// Profiles is the size of the profiles (samples) that
+	// have not been written to row groups yet.
+	Profiles  uint64
+	Exemplars uint64
+	Sketches  uint64
+	Symbols   symdb.MemoryStats
+}
+
+func (s *HeadMemoryStats) Total() uint64 {
+	return s.Profiles + s.Exemplars + s.Sketches + s.Symbols.MemorySize()
+}
+
+// MemoryStats returns the up-to-date memory stats of the head. The call is
+// more expensive than MemorySize, which relies on the symbols stats that are
+// refreshed periodically.
+func (h *Head) MemoryStats() HeadMemoryStats {
+	return HeadMemoryStats{
+		Profiles:  h.profiles.MemorySize(),
+		Exemplars: h.exemplars.MemorySize(),
+		Sketches:  h.sketches.MemorySize(),
+		Symbols:   h.symdb.MemoryStats(),
+	}
 }
 
 func (h *Head) Size() uint64 {
 	// TODO: TSDB index
-	return h.profiles.Size() + h.symdb.MemorySize()
+	return h.profiles.Size() + h.exemplars.Size() + h.sketches.Size() + h.symdb.MemorySize()
 }
 
 func (h *Head) loop() {
-	symdbMetricsUpdateTicker := time.NewTicker(5 * time.Second)
+	memUsageUpdateTicker := time.NewTicker(5 * time.Second)
+	var walSync <-chan time.Time
+	if h.walSyncInterval > 0 {
+		walSyncTicker := time.NewTicker(h.walSyncInterval)
+		defer walSyncTicker.Stop()
+		walSync = walSyncTicker.C
+	}
+	var evictIdleSeries <-chan time.Time
+	if h.seriesIdleTimeout != nil {
+		evictIdleSeriesTicker := time.NewTicker(seriesEvictionInterval)
+		defer evictIdleSeriesTicker.Stop()
+		evictIdleSeries = evictIdleSeriesTicker.C
+	}
 	var memStats symdb.MemoryStats
 	defer func() {
-		symdbMetricsUpdateTicker.Stop()
+		memUsageUpdateTicker.Stop()
 		h.wg.Done()
 	}()
 
 	for {
 		select {
-		case <-symdbMetricsUpdateTicker.C:
-			h.updateSymbolsMemUsage(&memStats)
+		case <-memUsageUpdateTicker.C:
+			h.updateMemUsage(&memStats)
+		case <-walSync:
+			if err := h.wal.sync(); err != nil {
+				level.Error(h.logger).Log("msg", "failed to sync head WAL", "err", err)
+			}
+		case now := <-evictIdleSeries:
+			if timeout := h.seriesIdleTimeout(); timeout > 0 {
+				h.evictIdleSeries(now.Add(-timeout))
+			}
 		case <-h.stopCh:
 			return
 		}
 	}
 }
 
+// evictIdleSeries removes the series that have not received profiles since
+// the given time from the inverted index, and drops their delta state. The
+// profiles of the series remain in the head, and are written to the block.
+func (h *Head) evictIdleSeries(before time.Time) {
+	evicted := h.profiles.index.evictIdleSeries(before.UnixNano())
+	if len(evicted) == 0 {
+		return
+	}
+	h.delta.evict(evicted)
+	level.Debug(h.logger).Log("msg", "evicted idle series from the head", "series", len(evicted))
+}
+
 func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, externalLabels ...*typesv1.LabelPair) error {
 	labels, seriesFingerprints := labelsForProfile(p, externalLabels...)
 
+	outOfOrder, err := h.checkOrder(p.TimeNanos, labels)
+	if err != nil {
+		return err
+	}
+
 	for i, fp := range seriesFingerprints {
 		if err := h.limiter.AllowProfile(fp, labels[i], p.TimeNanos); err != nil {
 			return err
 		}
 	}
 
+	// The profile is logged once it is admitted, before it is written
+	// to the head: replay must go through the same delta computation.
+	if h.wal != nil {
+		if err := h.wal.append(p, id, externalLabels); err != nil {
+			return err
+		}
+	}
+
 	// determine the stacktraces partition ID
 	partition := phlaremodel.StacktracePartitionFromProfile(labels, p)
 
 	metricName := phlaremodel.Labels(externalLabels).Get(model.MetricNameLabel)
 
+	// Span labels must be resolved before the symbols are written.
+	spans := samplesBySpan(p)
+	var exemplarTypes []int
+
 	var profileIngested bool
 	for idxType, profile := range h.symdb.WriteProfileSymbols(partition, p) {
 		profile.ID = id
@@ -190,7 +305,11 @@ func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, e
 		if err := h.profiles.ingest(ctx, []schemav1.InMemoryProfile{profile}, labels[idxType], metricName); err != nil {
 			return err
 		}
+		h.sketches.ingest(profile.SeriesFingerprint, profile.TotalValue)
 
+		if len(spans) > 0 && !isDelta(labels[idxType]) {
+			exemplarTypes = append(exemplarTypes, idxType)
+		}
 		profileIngested = true
 		h.totalSamples.Add(uint64(profile.Samples.Len()))
 		h.metrics.sampleValuesIngested.WithLabelValues(metricName).Add(float64(profile.Samples.Len()))
@@ -200,6 +319,14 @@ func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, e
 	if !profileIngested {
 		return nil
 	}
+	if outOfOrder {
+		h.metrics.profilesAppended.WithLabelValues("out_of_order").Inc()
+	} else {
+		h.metrics.profilesAppended.WithLabelValues("in_order").Inc()
+	}
+	if len(exemplarTypes) > 0 {
+		h.exemplars.ingest(h.symdb.PartitionWriter(partition), spans, partition, id, p.TimeNanos, exemplarTypes, seriesFingerprints)
+	}
 
 	h.metaLock.Lock()
 	v := model.TimeFromUnixNano(p.TimeNanos)
@@ -214,6 +341,29 @@ func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, e
 	return nil
 }
 
+// checkOrder reports whether the profile is older than the latest profile
+// of the head, and rejects it if it is out of the out-of-order window.
+func (h *Head) checkOrder(timeNanos int64, lbs []phlaremodel.Labels) (bool, error) {
+	h.metaLock.RLock()
+	maxTime := h.meta.MaxTime
+	h.metaLock.RUnlock()
+	t := model.TimeFromUnixNano(timeNanos)
+	if t >= maxTime {
+		return false, nil
+	}
+	if h.outOfOrderWindow > 0 {
+		if from := maxTime.Add(-h.outOfOrderWindow); t < from {
+			var ls phlaremodel.Labels
+			if len(lbs) > 0 {
+				ls = lbs[0]
+			}
+			return true, validation.NewErrorf(validation.OutOfOrder, validation.OutOfOrderErrorMsg,
+				phlaremodel.LabelPairsString(ls), util.FormatTimeMillis(int64(t)), util.FormatTimeMillis(int64(from)))
+		}
+	}
+	return true, nil
+}
+
 // LabelValues returns the possible label values for a given label name.
 func (h *Head) LabelValues(ctx context.Context, req *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
 	selectors, err := parseSelectors(req.Msg.Matchers)
@@ -221,32 +371,34 @@ func (h *Head) LabelValues(ctx context.Context, req *connect.Request[typesv1.Lab
 		return nil, err
 	}
 
-	// shortcut to index when matcher match all
+	var values []string
 	if selectors.matchesAll() {
-		values, err := h.profiles.index.ix.LabelValues(req.Msg.Name, nil)
+		// shortcut to index when matcher match all
+		values, err = h.profiles.index.labelValues(req.Msg.Name)
 		if err != nil {
 			return nil, err
 		}
-		return connect.NewResponse(&typesv1.LabelValuesResponse{
-			Names: values,
-		}), nil
-	}
-
-	// aggregate all label values from series matching, when matchers are given.
-
-	values := make(map[string]struct{})
-	if err := h.forMatchingSelectors(selectors, func(lbs phlaremodel.Labels, fp model.Fingerprint) error {
-		if v := lbs.Get(req.Msg.Name); v != "" {
-			values[v] = struct{}{}
+	} else {
+		// aggregate all label values from series matching, when matchers are given.
+		unique := make(map[string]struct{})
+		if err = h.forMatchingSelectors(selectors, func(lbs phlaremodel.Labels, fp model.Fingerprint) error {
+			if v := lbs.Get(req.Msg.Name); v != "" {
+				unique[v] = struct{}{}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
+		values = lo.Keys(unique)
 	}
+	sort.Strings(values)
 
-	return connect.NewResponse(&typesv1.LabelValuesResponse{
-		Names: lo.Keys(values),
-	}), nil
+	res := new(typesv1.LabelValuesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(values, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
 }
 
 // LabelNames returns the possible label values for a given label name.
@@ -256,37 +408,39 @@ func (h *Head) LabelNames(ctx context.Context, req *connect.Request[typesv1.Labe
 		return nil, err
 	}
 
-	// shortcut to index when matcher match all
+	var names []string
 	if selectors.matchesAll() {
-		values, err := h.profiles.index.ix.LabelNames(nil)
+		// shortcut to index when matcher match all
+		names, err = h.profiles.index.labelNames()
 		if err != nil {
 			return nil, err
 		}
-		sort.Strings(values)
-		return connect.NewResponse(&typesv1.LabelNamesResponse{
-			Names: values,
-		}), nil
-	}
-
-	// aggregate all label values from series matching, when matchers are given.
-	values := make(map[string]struct{})
-	if err := h.forMatchingSelectors(selectors, func(lbs phlaremodel.Labels, fp model.Fingerprint) error {
-		for _, lbl := range lbs {
-			values[lbl.Name] = struct{}{}
+	} else {
+		// aggregate all label names from series matching, when matchers are given.
+		unique := make(map[string]struct{})
+		if err = h.forMatchingSelectors(selectors, func(lbs phlaremodel.Labels, fp model.Fingerprint) error {
+			for _, lbl := range lbs {
+				unique[lbl.Name] = struct{}{}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
+		names = lo.Keys(unique)
 	}
+	sort.Strings(names)
 
-	return connect.NewResponse(&typesv1.LabelNamesResponse{
-		Names: lo.Keys(values),
-	}), nil
+	res := new(typesv1.LabelNamesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(names, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
 }
 
 // ProfileTypes returns the possible profile types.
 func (h *Head) ProfileTypes(ctx context.Context, req *connect.Request[ingestv1.ProfileTypesRequest]) (*connect.Response[ingestv1.ProfileTypesResponse], error) {
-	values, err := h.profiles.index.ix.LabelValues(phlaremodel.LabelNameProfileType, nil)
+	values, err := h.profiles.index.labelValues(phlaremodel.LabelNameProfileType)
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +615,10 @@ func (h *Head) Series(ctx context.Context, req *connect.Request[ingestv1.SeriesR
 	sort.Slice(response.LabelsSet, func(i, j int) bool {
 		return phlaremodel.CompareLabelPairs(response.LabelsSet[i].Labels, response.LabelsSet[j].Labels) < 0
 	})
+	response.LabelsSet, response.NextPageToken, err = phlaremodel.PaginateLabelsSet(response.LabelsSet, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
 
 	return connect.NewResponse(response), nil
 }
@@ -490,6 +648,9 @@ func (h *Head) flush(ctx context.Context) error {
 	h.inFlightProfiles.Wait()
 	if h.profiles.index.totalProfiles.Load() == 0 {
 		level.Info(h.logger).Log("msg", "head empty - no block written")
+		if h.wal != nil {
+			_ = h.wal.close()
+		}
 		return os.RemoveAll(h.headPath)
 	}
 
@@ -503,6 +664,13 @@ func (h *Head) flush(ctx context.Context) error {
 			return errors.Wrapf(err, "flushing table %s", t.Name())
 		}
 		h.metrics.rowsWritten.WithLabelValues(t.Name()).Add(float64(numRows))
+		if err = t.Close(); err != nil {
+			return errors.Wrapf(err, "closing table %s", t.Name())
+		}
+		if numRows == 0 {
+			// Optional tables are not written if empty.
+			continue
+		}
 		f := block.File{
 			RelPath: t.Name() + block.ParquetSuffix,
 			Parquet: &block.ParquetFile{
@@ -510,9 +678,6 @@ func (h *Head) flush(ctx context.Context) error {
 				NumRows:      numRows,
 			},
 		}
-		if err = t.Close(); err != nil {
-			return errors.Wrapf(err, "closing table %s", t.Name())
-		}
 		if stat, err := os.Stat(filepath.Join(h.headPath, f.RelPath)); err == nil {
 			f.SizeBytes = uint64(stat.Size())
 			blockSize += f.SizeBytes
@@ -565,6 +730,13 @@ func (h *Head) flush(ctx context.Context) error {
 	if _, err := h.meta.WriteToFile(h.logger, h.headPath); err != nil {
 		return err
 	}
+	// The block is complete: the WAL is not needed anymore,
+	// and must not be moved along with the block.
+	if h.wal != nil {
+		if err := h.wal.remove(); err != nil {
+			return errors.Wrap(err, "removing head WAL")
+		}
+	}
 	h.metrics.blockDurationSeconds.Observe(h.meta.MaxTime.Sub(h.meta.MinTime).Seconds())
 	return nil
 }
@@ -593,9 +765,44 @@ func (h *Head) Move() error {
 	return nil
 }
 
-func (h *Head) updateSymbolsMemUsage(memStats *symdb.MemoryStats) {
+// Close stops the head without flushing it: the head directory, and the
+// WAL, are kept on the disk. No ingestion requests should be made
+// concurrently with the call, or after it returns.
+func (h *Head) Close() error {
+	close(h.stopCh)
+	h.wg.Wait()
+	h.inFlightProfiles.Wait()
+	h.symdb.Close()
+	errs := multierror.New()
+	for _, t := range h.tables {
+		errs.Add(t.Close())
+	}
+	if h.wal != nil {
+		errs.Add(h.wal.close())
+	}
+	return errs.Err()
+}
+
+// Remove removes the head directory. The call is not thread-safe:
+// no concurrent reads and writes are allowed.
+//
+// After the call, head in-memory representation is not valid and should not
+// be accessed for querying.
+func (h *Head) Remove() error {
+	if err := h.profiles.DeleteRowGroups(); err != nil {
+		return err
+	}
+	if h.wal != nil {
+		_ = h.wal.close()
+	}
+	return os.RemoveAll(h.headPath)
+}
+
+func (h *Head) updateMemUsage(memStats *symdb.MemoryStats) {
 	h.symdb.WriteMemoryStats(memStats)
 	m := h.metrics.sizeBytes
+	m.WithLabelValues(h.exemplars.Name()).Set(float64(h.exemplars.MemorySize()))
+	m.WithLabelValues(h.sketches.Name()).Set(float64(h.sketches.MemorySize()))
 	m.WithLabelValues("stacktraces").Set(float64(memStats.StacktracesSize))
 	m.WithLabelValues("locations").Set(float64(memStats.LocationsSize))
 	m.WithLabelValues("functions").Set(float64(memStats.FunctionsSize))
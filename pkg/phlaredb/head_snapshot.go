@@ -0,0 +1,221 @@
+package phlaredb
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// A snapshot of the head is a copy of its WAL, taken at a point where no
+// ingestion requests are in flight. It is stored in the snapshot directory
+// as <head ULID>.wal, and is restored into the head of the next PhlareDB
+// instance opened at the same path, instead of being flushed to a block.
+//
+// A snapshot is only valid as long as the head it has been taken from is
+// not flushed: the snapshot is removed once the head is written to a block.
+const (
+	PathSnapshot       = "snapshot"
+	snapshotFileSuffix = ".wal"
+)
+
+var ErrSnapshotWALDisabled = errors.New("head snapshots require the WAL to be enabled")
+
+type HeadSnapshot struct {
+	HeadID    ulid.ULID `json:"head_id"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+func (f *PhlareDB) snapshotPath(id ulid.ULID) string {
+	return filepath.Join(f.cfg.DataPath, PathSnapshot, id.String()+snapshotFileSuffix)
+}
+
+// Snapshot takes a snapshot of the head. If the head is empty, nil is
+// returned. While the snapshot is valid, the head is not flushed when
+// PhlareDB is closed: the profiles ingested after the snapshot are kept
+// in the WAL of the head, and are restored along with the snapshot.
+func (f *PhlareDB) Snapshot(ctx context.Context) (*HeadSnapshot, error) {
+	// The WAL is removed when the head is flushed.
+	f.flushLock.Lock()
+	defer f.flushLock.Unlock()
+	f.headLock.Lock()
+	h := f.head
+	if h == nil {
+		f.headLock.Unlock()
+		return nil, nil
+	}
+	if h.wal == nil {
+		f.headLock.Unlock()
+		return nil, ErrSnapshotWALDisabled
+	}
+	// No new ingestion requests can be started while the lock is held.
+	h.inFlightProfiles.Wait()
+	size, err := h.wal.syncedSize()
+	f.headLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// The WAL is append-only: the records written before the lock was
+	// released are not modified, and can be copied without blocking writes.
+	if err = copySnapshot(h.wal.path, f.snapshotPath(h.meta.ULID), size); err != nil {
+		return nil, errors.Wrap(err, "writing head snapshot")
+	}
+	level.Info(f.logger).Log("msg", "head snapshot taken", "head", h.meta.ULID, "size", size)
+	return &HeadSnapshot{HeadID: h.meta.ULID, SizeBytes: size}, nil
+}
+
+func copySnapshot(src, dst string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(dst), defaultFolderMode); err != nil {
+		return err
+	}
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	tmp := dst + ".tmp"
+	d, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(d, s, size); err == nil {
+		err = d.Sync()
+	}
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return fileutil.Rename(tmp, dst)
+}
+
+func (f *PhlareDB) isHead(id ulid.ULID) bool {
+	f.headLock.RLock()
+	defer f.headLock.RUnlock()
+	return f.head != nil && f.head.meta.ULID == id
+}
+
+func (f *PhlareDB) hasSnapshot(id ulid.ULID) bool {
+	_, err := os.Stat(f.snapshotPath(id))
+	return err == nil
+}
+
+func (f *PhlareDB) removeSnapshot(id ulid.ULID) error {
+	if err := os.Remove(f.snapshotPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *PhlareDB) snapshots() ([]ulid.ULID, error) {
+	return listSnapshots(f.cfg.DataPath)
+}
+
+// HasSnapshots reports whether there are snapshots to be restored at the path.
+func HasSnapshots(dataPath string) (bool, error) {
+	ids, err := listSnapshots(dataPath)
+	return len(ids) > 0, err
+}
+
+// listSnapshots returns the heads the snapshot directory has snapshots of.
+func listSnapshots(dataPath string) ([]ulid.ULID, error) {
+	entries, err := os.ReadDir(filepath.Join(dataPath, PathSnapshot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []ulid.ULID
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, snapshotFileSuffix) {
+			continue
+		}
+		id, err := ulid.Parse(strings.TrimSuffix(name, snapshotFileSuffix))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// takeOverHeadWALs replaces the snapshots with the WAL of the heads they
+// were taken from, if the head has not been flushed: the WAL includes the
+// profiles ingested after the snapshot. The head is removed, and won't be
+// replayed to a block.
+func (f *PhlareDB) takeOverHeadWALs() error {
+	ids, err := f.snapshots()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		headPath := filepath.Join(f.cfg.DataPath, pathHead, id.String())
+		walPath := filepath.Join(headPath, headWALFileName)
+		if _, err = os.Stat(walPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err = fileutil.Rename(walPath, f.snapshotPath(id)); err != nil {
+			return err
+		}
+		if err = os.RemoveAll(headPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreSnapshots ingests the profiles of the snapshots into the head,
+// and removes the snapshots. Snapshots are restored when PhlareDB is
+// opened; the call is only needed if snapshots were added afterwards.
+func (f *PhlareDB) RestoreSnapshots(ctx context.Context) (restored int, err error) {
+	ids, err := f.snapshots()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if f.isHead(id) {
+			// The snapshot is taken from the head itself.
+			continue
+		}
+		var failed int
+		err = replayWAL(f.snapshotPath(id), func(p *profilev1.Profile, pid uuid.UUID, lbs []*typesv1.LabelPair) error {
+			if err := f.Ingest(ctx, p, pid, lbs...); err != nil {
+				level.Warn(f.logger).Log("msg", "failed to restore profile", "profile_id", pid, "err", err)
+				failed++
+				return nil
+			}
+			restored++
+			return nil
+		})
+		if errors.Is(err, errWALCorrupted) {
+			level.Warn(f.logger).Log("msg", "discarding the corrupted tail of the snapshot", "head", id, "err", err)
+			f.metrics.walCorruptions.Inc()
+			err = nil
+		}
+		if err != nil {
+			return restored, errors.Wrapf(err, "restoring snapshot of head %s", id)
+		}
+		level.Info(f.logger).Log("msg", "head snapshot restored", "head", id, "failed", failed)
+		if err = f.removeSnapshot(id); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
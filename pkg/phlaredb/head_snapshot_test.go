@@ -0,0 +1,96 @@
+package phlaredb
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func ingestTestProfiles(t *testing.T, db *PhlareDB, from, to int64) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		p := testhelper.NewProfileBuilder(i*1e9).CPUProfile().
+			ForStacktraceString("foo", "bar").AddSamples(i)
+		require.NoError(t, db.Ingest(context.Background(), p.Profile, p.UUID, p.Labels...))
+	}
+}
+
+func TestHeadSnapshot_Restore(t *testing.T) {
+	ctx := testContext(t)
+	cfg := Config{
+		DataPath: contextDataDir(ctx),
+		WAL:      WALConfig{Enabled: true, SyncPolicy: WALSyncNone},
+	}
+	db, err := New(ctx, cfg, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	ingestTestProfiles(t, db, 1, 3)
+	s, err := db.Snapshot(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	require.NotZero(t, s.SizeBytes)
+	// Profiles ingested after the snapshot are restored from the head WAL.
+	ingestTestProfiles(t, db, 4, 4)
+	require.NoError(t, db.Close())
+
+	// The head is not flushed to a block.
+	entries, err := os.ReadDir(db.LocalDataPath())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	ok, err := HasSnapshots(cfg.DataPath)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	db, err = New(ctx, cfg, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	ok, err = HasSnapshots(cfg.DataPath)
+	require.NoError(t, err)
+	require.False(t, ok)
+	metas, err := db.BlockMetas(ctx)
+	require.NoError(t, err)
+	require.Empty(t, metas)
+	require.NotNil(t, db.head)
+	require.Equal(t, int64(4), db.head.profiles.index.totalProfiles.Load())
+}
+
+func TestHeadSnapshot_RemovedOnFlush(t *testing.T) {
+	ctx := testContext(t)
+	cfg := Config{
+		DataPath: contextDataDir(ctx),
+		WAL:      WALConfig{Enabled: true, SyncPolicy: WALSyncAlways},
+	}
+	db, err := New(ctx, cfg, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	s, err := db.Snapshot(ctx)
+	require.NoError(t, err)
+	require.Nil(t, s, "empty head")
+
+	ingestTestProfiles(t, db, 1, 2)
+	s, err = db.Snapshot(ctx)
+	require.NoError(t, err)
+	require.True(t, db.hasSnapshot(s.HeadID))
+	require.NoError(t, db.Flush(ctx))
+	require.False(t, db.hasSnapshot(s.HeadID))
+}
+
+func TestHeadSnapshot_WALDisabled(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{DataPath: contextDataDir(ctx)}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	ingestTestProfiles(t, db, 1, 1)
+	_, err = db.Snapshot(ctx)
+	require.ErrorIs(t, err, ErrSnapshotWALDisabled)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/stretchr/testify/require"
@@ -24,6 +27,7 @@ import (
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type noLimit struct{}
@@ -250,6 +254,41 @@ func TestHeadProfileTypes(t *testing.T) {
 	}, res.Msg.ProfileTypes)
 }
 
+func TestHeadSelectProfileTypePatterns(t *testing.T) {
+	head := newTestHead(t)
+	require.NoError(t, head.Ingest(context.Background(), newProfileFoo(), uuid.New(), &typesv1.LabelPair{Name: "__name__", Value: "foo_cpu"}, &typesv1.LabelPair{Name: "job", Value: "foo"}))
+	require.NoError(t, head.Ingest(context.Background(), newProfileBar(), uuid.New(), &typesv1.LabelPair{Name: "__name__", Value: "bar_cpu"}, &typesv1.LabelPair{Name: "job", Value: "bar"}))
+	require.NoError(t, head.Ingest(context.Background(), newProfileBar(), uuid.New(), &typesv1.LabelPair{Name: "__name__", Value: "memory"}, &typesv1.LabelPair{Name: "job", Value: "bar"}))
+
+	for selector, expected := range map[string][]string{
+		"foo_cpu:type:unit:type:unit":    {"foo_cpu"},
+		"~.*_cpu:type:unit:type:unit":    {"bar_cpu", "foo_cpu"},
+		"~.*_cpu:*:unit:*:*":             {"bar_cpu", "foo_cpu"},
+		"!memory:type:unit:type:unit":    {"bar_cpu", "foo_cpu"},
+		"!~foo.*:type:unit:type:unit":    {"bar_cpu", "memory"},
+		"*:type:unit:type:unit":          {"bar_cpu", "foo_cpu", "memory"},
+		"~.*_cpu:type:!unit:type:unit":   nil,
+		"~.*_cpu:type:~un.*:type:unit:x": {"bar_cpu", "foo_cpu"},
+	} {
+		t.Run(selector, func(t *testing.T) {
+			it, err := head.Queriers().SelectMatchingProfiles(context.Background(), &ingestv1.SelectProfilesRequest{
+				LabelSelector: "{}",
+				Type:          mustParseProfileSelector(t, selector),
+				Start:         0,
+				End:           time.Now().UnixMilli(),
+			})
+			require.NoError(t, err)
+			var names []string
+			for it.Next() {
+				names = append(names, it.At().Labels().Get("__name__"))
+			}
+			require.NoError(t, it.Err())
+			sort.Strings(names)
+			require.Equal(t, expected, names)
+		})
+	}
+}
+
 func mustParseProfileSelector(t testing.TB, selector string) *typesv1.ProfileType {
 	ps, err := phlaremodel.ParseProfileTypeSelector(selector)
 	require.NoError(t, err)
@@ -307,6 +346,13 @@ func TestHeadFlush(t *testing.T) {
 						NumRows:      11,
 					},
 				},
+				{
+					RelPath: "sketches.parquet",
+					Parquet: &block.ParquetFile{
+						NumRowGroups: 1,
+						NumRows:      8,
+					},
+				},
 				{
 					RelPath: "symbols/functions.parquet",
 					Parquet: &block.ParquetFile{
@@ -340,7 +386,7 @@ func TestHeadFlush(t *testing.T) {
 					RelPath: "symbols/strings.parquet",
 					Parquet: &block.ParquetFile{
 						NumRowGroups: 2,
-						NumRows:      1723,
+						NumRows:      1719,
 					},
 				},
 			},
@@ -350,7 +396,7 @@ func TestHeadFlush(t *testing.T) {
 					head.meta.ULID,
 				},
 			},
-			Version: 3,
+			Version: 4,
 		},
 	}
 
@@ -450,6 +496,33 @@ func TestHead_Concurrent_Ingest_Querying(t *testing.T) {
 	wg.Wait()
 }
 
+func TestHeadOutOfOrderWindow(t *testing.T) {
+	ctx := testContext(t)
+	head, err := NewHead(ctx, Config{DataPath: t.TempDir(), OutOfOrderWindow: 10 * time.Minute}, NoLimit)
+	require.NoError(t, err)
+
+	ingest := func(ts time.Time) error {
+		p := newProfileFoo()
+		p.TimeNanos = ts.UnixNano()
+		return head.Ingest(ctx, p, uuid.New(), &typesv1.LabelPair{Name: "job", Value: "foo"})
+	}
+	now := time.Unix(3600, 0)
+	require.NoError(t, ingest(now))
+	require.NoError(t, ingest(now.Add(time.Minute)))
+	require.NoError(t, ingest(now.Add(-5*time.Minute)))
+	err = ingest(now.Add(-10 * time.Minute))
+	require.Error(t, err)
+	require.Equal(t, validation.OutOfOrder, validation.ReasonOf(err))
+
+	reg := phlarecontext.Registry(ctx).(*prometheus.Registry)
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP pyroscope_head_appended_profiles_total Number of profiles appended to the head, by whether they are newer than the latest profile of the head (in_order) or not (out_of_order).
+# TYPE pyroscope_head_appended_profiles_total counter
+pyroscope_head_appended_profiles_total{order="in_order"} 2
+pyroscope_head_appended_profiles_total{order="out_of_order"} 1
+`), "pyroscope_head_appended_profiles_total"))
+}
+
 func BenchmarkHeadIngestProfiles(t *testing.B) {
 	var (
 		profilePaths = []string{
@@ -472,3 +545,56 @@ func BenchmarkHeadIngestProfiles(t *testing.B) {
 		}
 	}
 }
+
+func TestHead_MemoryStats(t *testing.T) {
+	head := newTestHead(t)
+	require.NoError(t, head.Ingest(context.Background(), newProfileFoo(), uuid.New()))
+	require.NoError(t, head.Ingest(context.Background(), newProfileBar(), uuid.New()))
+
+	stats := head.MemoryStats()
+	require.NotZero(t, stats.Profiles)
+	require.NotZero(t, stats.Sketches)
+	require.NotZero(t, stats.Symbols.StacktracesSize)
+	require.NotZero(t, stats.Symbols.StringsSize)
+	// The symbols stats are refreshed by the call.
+	require.Equal(t, stats.Total(), head.MemorySize())
+}
+
+func TestHead_EvictIdleSeries(t *testing.T) {
+	ctx := context.Background()
+	head := newTestHead(t)
+	require.NoError(t, head.Ingest(ctx, newProfileFoo(), uuid.New(), &typesv1.LabelPair{Name: "job", Value: "foo"}))
+	require.NoError(t, head.Ingest(ctx, newProfileBar(), uuid.New(), &typesv1.LabelPair{Name: "job", Value: "bar"}))
+
+	head.evictIdleSeries(time.Now().Add(time.Second))
+	index := head.profiles.index
+	ids, err := index.ix.Lookup(nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.Len(t, index.idle, 2)
+
+	// Idle series can still be queried.
+	res, err := head.LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{Name: "job"}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar", "foo"}, res.Msg.Names)
+	res, err = head.LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name:     "job",
+		Matchers: []string{`{job="foo"}`},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, res.Msg.Names)
+
+	// A series receiving a profile is added back to the index.
+	p := newProfileFoo()
+	p.TimeNanos++
+	require.NoError(t, head.Ingest(ctx, p, uuid.New(), &typesv1.LabelPair{Name: "job", Value: "foo"}))
+	ids, err = index.ix.Lookup(nil, nil)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	require.Len(t, index.idle, 1)
+
+	// Evicted series are written to the block.
+	require.NoError(t, head.Flush(ctx))
+	require.Equal(t, uint64(2), head.meta.Stats.NumSeries)
+	require.Equal(t, uint64(3), head.meta.Stats.NumProfiles)
+}
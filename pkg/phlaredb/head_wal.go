@@ -0,0 +1,345 @@
+package phlaredb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+const (
+	WALSyncAlways   = "always"
+	WALSyncInterval = "interval"
+	WALSyncNone     = "none"
+)
+
+var supportedWALSyncPolicies = []string{WALSyncAlways, WALSyncInterval, WALSyncNone}
+
+// headWALFileName is the name of the write-ahead log file in the head
+// directory. The file is removed once the head is flushed.
+const headWALFileName = "wal"
+
+// WALConfig configures the write-ahead log of the head. The log records
+// every profile ingested since the last flush, and is replayed on startup
+// if the process did not manage to flush the head.
+//
+// The sync policy determines what the log survives: the OS page cache
+// survives a crash of the process regardless of the policy, whereas
+// a crash of the machine loses everything written since the last fsync.
+type WALConfig struct {
+	Enabled      bool          `yaml:"enabled" category:"advanced"`
+	SyncPolicy   string        `yaml:"sync_policy" category:"advanced"`
+	SyncInterval time.Duration `yaml:"sync_interval" category:"advanced"`
+}
+
+func (cfg *WALConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "pyroscopedb.wal.enabled", true, "Write the profiles ingested into the head to a write-ahead log, to recover them on startup after a crash.")
+	f.StringVar(&cfg.SyncPolicy, "pyroscopedb.wal.sync-policy", WALSyncInterval, fmt.Sprintf("When the write-ahead log is synced to the disk: after every profile (always), periodically (interval), or never, leaving it to the OS (none). Supported values: %s.", strings.Join(supportedWALSyncPolicies, ", ")))
+	f.DurationVar(&cfg.SyncInterval, "pyroscopedb.wal.sync-interval", time.Second, "How often the write-ahead log is synced to the disk, if the sync policy is interval.")
+}
+
+func (cfg *WALConfig) Validate() error {
+	switch cfg.SyncPolicy {
+	case "", WALSyncAlways, WALSyncNone:
+	case WALSyncInterval:
+		if cfg.SyncInterval <= 0 {
+			return errors.New("WAL sync interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unsupported WAL sync policy %q, supported values: %s", cfg.SyncPolicy, strings.Join(supportedWALSyncPolicies, ", "))
+	}
+	return nil
+}
+
+// headWAL is an append-only log of the profiles ingested into the head.
+// Every record is written to the file with a single call and is prefixed
+// with the length and the CRC32 (Castagnoli) checksum of the payload: a
+// record torn by a crash is detected and discarded on replay.
+type headWAL struct {
+	mu     sync.Mutex
+	f      *os.File
+	path   string
+	policy string
+	buf    []byte
+	dirty  bool
+	closed bool
+
+	metrics *headMetrics
+}
+
+const walRecordHeaderSize = 8
+
+var walCastagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func openHeadWAL(dir string, cfg WALConfig, metrics *headMetrics) (*headWAL, error) {
+	path := filepath.Join(dir, headWALFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &headWAL{
+		f:       f,
+		path:    path,
+		policy:  cfg.SyncPolicy,
+		metrics: metrics,
+	}, nil
+}
+
+func (w *headWAL) append(p *profilev1.Profile, id uuid.UUID, externalLabels []*typesv1.LabelPair) error {
+	raw, err := p.MarshalVT()
+	if err != nil {
+		return err
+	}
+	series := &pushv1.RawProfileSeries{
+		Labels:  externalLabels,
+		Samples: []*pushv1.RawSample{{RawProfile: raw, ID: id.String()}},
+	}
+	size := series.SizeVT()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("WAL is closed")
+	}
+	if cap(w.buf) < walRecordHeaderSize+size {
+		w.buf = make([]byte, walRecordHeaderSize+size)
+	}
+	w.buf = w.buf[:walRecordHeaderSize+size]
+	if _, err = series.MarshalToSizedBufferVT(w.buf[walRecordHeaderSize:]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(w.buf[0:4], uint32(size))
+	binary.LittleEndian.PutUint32(w.buf[4:8], crc32.Checksum(w.buf[walRecordHeaderSize:], walCastagnoliTable))
+	if _, err = w.f.Write(w.buf); err != nil {
+		return errors.Wrap(err, "writing WAL record")
+	}
+	w.metrics.walWrittenBytes.Add(float64(len(w.buf)))
+	if w.policy == WALSyncAlways {
+		return w.f.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// sync flushes the records written since the last sync to the disk.
+func (w *headWAL) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+// syncedSize syncs the WAL file, and returns its size.
+func (w *headWAL) syncedSize() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errors.New("WAL is closed")
+	}
+	if err := w.f.Sync(); err != nil {
+		return 0, err
+	}
+	w.dirty = false
+	stat, err := w.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// close syncs and closes the WAL file. The call is idempotent.
+func (w *headWAL) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	if w.policy != WALSyncNone {
+		err = w.f.Sync()
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// remove closes and deletes the WAL file.
+func (w *headWAL) remove() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// replayWAL calls fn for every record of the WAL file in the order they
+// were written. A torn or corrupted tail of the file is reported as
+// errWALCorrupted after all the preceding records were replayed.
+func replayWAL(path string, fn func(*profilev1.Profile, uuid.UUID, []*typesv1.LabelPair) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var (
+		r      = bufio.NewReader(f)
+		header [walRecordHeaderSize]byte
+		buf    []byte
+	)
+	for offset := int64(0); ; {
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrapf(errWALCorrupted, "truncated record header at offset %d", offset)
+		}
+		size := int(binary.LittleEndian.Uint32(header[0:4]))
+		if offset+walRecordHeaderSize+int64(size) > stat.Size() {
+			return errors.Wrapf(errWALCorrupted, "truncated record at offset %d", offset)
+		}
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return errors.Wrapf(errWALCorrupted, "truncated record at offset %d", offset)
+		}
+		if crc32.Checksum(buf, walCastagnoliTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return errors.Wrapf(errWALCorrupted, "checksum mismatch at offset %d", offset)
+		}
+		var series pushv1.RawProfileSeries
+		if err = series.UnmarshalVT(buf); err != nil {
+			return errors.Wrapf(errWALCorrupted, "invalid record at offset %d: %v", offset, err)
+		}
+		for _, s := range series.Samples {
+			var p profilev1.Profile
+			if err = p.UnmarshalVT(s.RawProfile); err != nil {
+				return errors.Wrapf(errWALCorrupted, "invalid profile at offset %d: %v", offset, err)
+			}
+			id, err := uuid.Parse(s.ID)
+			if err != nil {
+				return errors.Wrapf(errWALCorrupted, "invalid profile ID at offset %d: %v", offset, err)
+			}
+			if err = fn(&p, id, series.Labels); err != nil {
+				return err
+			}
+		}
+		offset += int64(walRecordHeaderSize + size)
+	}
+}
+
+var errWALCorrupted = errors.New("WAL corrupted")
+
+// replayHeads recovers the heads that were not flushed before the process
+// stopped: the profiles of the WAL are ingested into a new head, which is
+// then flushed to the local blocks. The directory of the former head is
+// removed once its WAL has been replayed.
+func (f *PhlareDB) replayHeads(ctx context.Context) error {
+	dir := filepath.Join(f.cfg.DataPath, pathHead)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err = ulid.Parse(e.Name()); err != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if _, err = os.Stat(filepath.Join(path, headWALFileName)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err = f.replayHead(ctx, path); err != nil {
+			return errors.Wrapf(err, "replaying head %s", e.Name())
+		}
+	}
+	return nil
+}
+
+func (f *PhlareDB) replayHead(ctx context.Context, path string) error {
+	// The replayed head is not logged: the source WAL is kept
+	// until the head is flushed to a block.
+	cfg := f.cfg
+	cfg.WAL.Enabled = false
+	// The profiles were admitted by the limiter when they were ingested.
+	head, err := NewHead(f.phlarectx, cfg, replayLimiter{})
+	if err != nil {
+		return err
+	}
+	var replayed, failed int
+	err = replayWAL(filepath.Join(path, headWALFileName), func(p *profilev1.Profile, id uuid.UUID, lbs []*typesv1.LabelPair) error {
+		if err := head.Ingest(ctx, p, id, lbs...); err != nil {
+			level.Warn(f.logger).Log("msg", "failed to replay profile", "profile_id", id, "err", err)
+			failed++
+			return nil
+		}
+		replayed++
+		return nil
+	})
+	f.metrics.walReplayedProfiles.WithLabelValues("success").Add(float64(replayed))
+	f.metrics.walReplayedProfiles.WithLabelValues("failed").Add(float64(failed))
+	if errors.Is(err, errWALCorrupted) {
+		level.Warn(f.logger).Log("msg", "discarding the corrupted tail of the WAL", "path", path, "err", err)
+		f.metrics.walCorruptions.Inc()
+		err = nil
+	}
+	if err != nil {
+		_ = head.Flush(ctx)
+		_ = head.Remove()
+		return err
+	}
+	empty := head.profiles.index.totalProfiles.Load() == 0
+	if err = head.Flush(ctx); err != nil {
+		return err
+	}
+	if !empty {
+		if err = head.Move(); err != nil {
+			return err
+		}
+	}
+	level.Info(f.logger).Log("msg", "head replayed from WAL", "path", path, "block", head.meta.ULID, "profiles", replayed, "failed", failed)
+	return os.RemoveAll(path)
+}
+
+type replayLimiter struct{}
+
+func (replayLimiter) AllowProfile(model.Fingerprint, phlaremodel.Labels, int64) error { return nil }
+
+func (replayLimiter) Stop() {}
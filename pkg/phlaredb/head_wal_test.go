@@ -0,0 +1,97 @@
+package phlaredb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func TestWALConfig_Validate(t *testing.T) {
+	require.NoError(t, (&WALConfig{SyncPolicy: WALSyncAlways}).Validate())
+	require.NoError(t, (&WALConfig{SyncPolicy: WALSyncNone}).Validate())
+	require.NoError(t, (&WALConfig{SyncPolicy: WALSyncInterval, SyncInterval: time.Second}).Validate())
+	require.Error(t, (&WALConfig{SyncPolicy: WALSyncInterval}).Validate())
+	require.Error(t, (&WALConfig{SyncPolicy: "never"}).Validate())
+}
+
+// newCrashedHead returns the path of a head that ingested n profiles and
+// was never flushed, as if the process had crashed.
+func newCrashedHead(t *testing.T, ctx testCtx, n int) string {
+	t.Helper()
+	head, err := NewHead(ctx, Config{
+		DataPath: contextDataDir(ctx),
+		WAL:      WALConfig{Enabled: true, SyncPolicy: WALSyncAlways},
+	}, NoLimit)
+	require.NoError(t, err)
+	for i := 1; i <= n; i++ {
+		p := testhelper.NewProfileBuilder(int64(i)*1e9).CPUProfile().
+			ForStacktraceString("foo", "bar").AddSamples(int64(i))
+		require.NoError(t, head.Ingest(context.Background(), p.Profile, p.UUID, p.Labels...))
+	}
+	close(head.stopCh)
+	head.wg.Wait()
+	require.NoError(t, head.wal.close())
+	return head.headPath
+}
+
+func TestHeadWAL_Replay(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		truncate int64
+		profiles uint64
+	}{
+		{name: "complete", profiles: 3},
+		{name: "torn last record", truncate: 5, profiles: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testContext(t)
+			headPath := newCrashedHead(t, ctx, 3)
+			if tc.truncate > 0 {
+				walPath := filepath.Join(headPath, headWALFileName)
+				stat, err := os.Stat(walPath)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(walPath, stat.Size()-tc.truncate))
+			}
+
+			db, err := New(ctx, Config{DataPath: contextDataDir(ctx)}, NoLimit, ctx.localBucketClient)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, db.Close())
+			}()
+
+			_, err = os.Stat(headPath)
+			require.True(t, os.IsNotExist(err))
+			metas, err := db.BlockMetas(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metas, 1)
+			require.Equal(t, tc.profiles, metas[0].Stats.NumProfiles)
+			_, err = os.Stat(filepath.Join(db.LocalDataPath(), metas[0].ULID.String(), headWALFileName))
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestHeadWAL_RemovedOnFlush(t *testing.T) {
+	ctx := testContext(t)
+	head, err := NewHead(ctx, Config{
+		DataPath: contextDataDir(ctx),
+		WAL:      WALConfig{Enabled: true, SyncPolicy: WALSyncInterval, SyncInterval: time.Millisecond},
+	}, NoLimit)
+	require.NoError(t, err)
+	p := testhelper.NewProfileBuilder(1e9).CPUProfile().
+		ForStacktraceString("foo", "bar").AddSamples(1)
+	require.NoError(t, head.Ingest(context.Background(), p.Profile, p.UUID, p.Labels...))
+	_, err = os.Stat(filepath.Join(head.headPath, headWALFileName))
+	require.NoError(t, err)
+
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+	_, err = os.Stat(filepath.Join(head.localPath, headWALFileName))
+	require.True(t, os.IsNotExist(err))
+}
@@ -20,6 +20,7 @@ const (
 type headMetrics struct {
 	series        prometheus.Gauge
 	seriesCreated *prometheus.CounterVec
+	seriesEvicted prometheus.Counter
 
 	profiles        prometheus.Gauge
 	profilesCreated *prometheus.CounterVec
@@ -31,6 +32,8 @@ type headMetrics struct {
 	sampleValuesReceived *prometheus.CounterVec
 	samples              prometheus.Gauge
 
+	profilesAppended *prometheus.CounterVec
+
 	flushedFileSizeBytes        *prometheus.HistogramVec
 	flushedBlockSizeBytes       prometheus.Histogram
 	flushedBlockDurationSeconds prometheus.Histogram
@@ -42,6 +45,10 @@ type headMetrics struct {
 	flushedBlocksReasons        *prometheus.CounterVec
 	writtenProfileSegments      *prometheus.CounterVec
 	writtenProfileSegmentsBytes prometheus.Histogram
+
+	walWrittenBytes     prometheus.Counter
+	walReplayedProfiles *prometheus.CounterVec
+	walCorruptions      prometheus.Counter
 }
 
 func newHeadMetrics(reg prometheus.Registerer) *headMetrics {
@@ -50,6 +57,10 @@ func newHeadMetrics(reg prometheus.Registerer) *headMetrics {
 			Name: "pyroscope_tsdb_head_series_created_total",
 			Help: "Total number of series created in the head",
 		}, []string{"profile_name"}),
+		seriesEvicted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_tsdb_head_series_evicted_total",
+			Help: "Total number of idle series evicted from the inverted index of the head",
+		}),
 		rowsWritten: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "pyroscope_rows_written",
@@ -66,6 +77,12 @@ func newHeadMetrics(reg prometheus.Registerer) *headMetrics {
 				Help: "Number of sample values ingested into the head per profile type.",
 			},
 			[]string{"profile_name"}),
+		profilesAppended: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "pyroscope_head_appended_profiles_total",
+				Help: "Number of profiles appended to the head, by whether they are newer than the latest profile of the head (in_order) or not (out_of_order).",
+			},
+			[]string{"order"}),
 		sampleValuesReceived: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "pyroscope_head_received_sample_values_total",
@@ -150,6 +167,18 @@ func newHeadMetrics(reg prometheus.Registerer) *headMetrics {
 			Name: "pyroscope_head_samples",
 			Help: "Number of samples in the head.",
 		}),
+		walWrittenBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_head_wal_written_bytes_total",
+			Help: "Total number of bytes written to the write-ahead log of the head.",
+		}),
+		walReplayedProfiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_head_wal_replayed_profiles_total",
+			Help: "Total number and status of profiles replayed from the write-ahead log of the head.",
+		}, []string{"status"}),
+		walCorruptions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_head_wal_corruptions_total",
+			Help: "Total number of write-ahead logs of the head found corrupted on replay.",
+		}),
 	}
 
 	m.register(reg)
@@ -162,12 +191,14 @@ func (m *headMetrics) register(reg prometheus.Registerer) {
 	}
 	m.series = util.RegisterOrGet(reg, m.series)
 	m.seriesCreated = util.RegisterOrGet(reg, m.seriesCreated)
+	m.seriesEvicted = util.RegisterOrGet(reg, m.seriesEvicted)
 	m.profiles = util.RegisterOrGet(reg, m.profiles)
 	m.profilesCreated = util.RegisterOrGet(reg, m.profilesCreated)
 	m.sizeBytes = util.RegisterOrGet(reg, m.sizeBytes)
 	m.rowsWritten = util.RegisterOrGet(reg, m.rowsWritten)
 	m.sampleValuesIngested = util.RegisterOrGet(reg, m.sampleValuesIngested)
 	m.sampleValuesReceived = util.RegisterOrGet(reg, m.sampleValuesReceived)
+	m.profilesAppended = util.RegisterOrGet(reg, m.profilesAppended)
 	m.flushedFileSizeBytes = util.RegisterOrGet(reg, m.flushedFileSizeBytes)
 	m.flushedBlockSizeBytes = util.RegisterOrGet(reg, m.flushedBlockSizeBytes)
 	m.flushedBlockDurationSeconds = util.RegisterOrGet(reg, m.flushedBlockDurationSeconds)
@@ -179,6 +210,9 @@ func (m *headMetrics) register(reg prometheus.Registerer) {
 	m.flushedBlocksReasons = util.RegisterOrGet(reg, m.flushedBlocksReasons)
 	m.writtenProfileSegments = util.RegisterOrGet(reg, m.writtenProfileSegments)
 	m.writtenProfileSegmentsBytes = util.RegisterOrGet(reg, m.writtenProfileSegmentsBytes)
+	m.walWrittenBytes = util.RegisterOrGet(reg, m.walWrittenBytes)
+	m.walReplayedProfiles = util.RegisterOrGet(reg, m.walReplayedProfiles)
+	m.walCorruptions = util.RegisterOrGet(reg, m.walCorruptions)
 }
 
 func contextWithHeadMetrics(ctx context.Context, m *headMetrics) context.Context {
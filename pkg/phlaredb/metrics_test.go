@@ -35,7 +35,7 @@ func TestHeadMetrics(t *testing.T) {
 	require.NoError(t, head.Ingest(context.Background(), newProfileFoo(), uuid.New()))
 	require.NoError(t, head.Ingest(context.Background(), newProfileBar(), uuid.New()))
 	require.NoError(t, head.Ingest(context.Background(), newProfileBaz(), uuid.New()))
-	head.updateSymbolsMemUsage(new(symdb.MemoryStats))
+	head.updateMemUsage(new(symdb.MemoryStats))
 	time.Sleep(time.Second)
 	require.NoError(t, testutil.GatherAndCompare(head.reg,
 		strings.NewReader(`
@@ -51,10 +51,12 @@ pyroscope_head_received_sample_values_total{profile_name=""} 3
 
 # HELP pyroscope_head_size_bytes Size of a particular in memory store within the head phlaredb block.
 # TYPE pyroscope_head_size_bytes gauge
+pyroscope_head_size_bytes{type="exemplars"} 0
 pyroscope_head_size_bytes{type="functions"} 120
 pyroscope_head_size_bytes{type="locations"} 152
 pyroscope_head_size_bytes{type="mappings"} 96
 pyroscope_head_size_bytes{type="profiles"} 372
+pyroscope_head_size_bytes{type="sketches"} 512
 pyroscope_head_size_bytes{type="stacktraces"} 112
 pyroscope_head_size_bytes{type="strings"} 72
 
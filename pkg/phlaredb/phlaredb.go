@@ -20,8 +20,10 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/parquet-go/parquet-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
 
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
@@ -29,8 +31,10 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 )
 
 type Config struct {
@@ -41,6 +45,33 @@ type Config struct {
 	// TODO: docs
 	RowGroupTargetSize uint64 `yaml:"row_group_target_size"`
 
+	// OutOfOrderWindow is how far behind the latest profile of the head a
+	// profile may be, to be accepted. Zero accepts profiles in any order.
+	OutOfOrderWindow time.Duration `yaml:"out_of_order_window" category:"advanced"`
+
+	// MaxHeadMemoryBytes is the memory the head of a tenant may hold
+	// before it is flushed, regardless of its duration. Zero disables
+	// the limit.
+	MaxHeadMemoryBytes uint64 `yaml:"max_head_memory_bytes" category:"advanced"`
+
+	// SplitShards returns the number of shards each flushed block is split
+	// into before it becomes visible to the shipper. Values lower than 2
+	// disable splitting.
+	SplitShards func() int `yaml:"-"`
+
+	// SeriesIdleTimeout returns the duration after which the series that
+	// have not received profiles are evicted from the head index. The data
+	// of the series is retained in the block. Zero disables the eviction.
+	SeriesIdleTimeout func() time.Duration `yaml:"-"`
+
+	// SymbolsInternPool, if set, deduplicates the memory of the symbols
+	// of the head with the other instances sharing the pool.
+	SymbolsInternPool *symdb.InternPool `yaml:"-"`
+
+	BlockWriter BlockWriterConfig `yaml:"block_writer"`
+
+	WAL WALConfig `yaml:"wal"`
+
 	Parquet *ParquetConfig `yaml:"-"` // Those configs should not be exposed to the user, rather they should be determined by pyroscope itself. Currently, they are solely used for test cases.
 }
 
@@ -48,12 +79,24 @@ type ParquetConfig struct {
 	MaxBufferRowCount int
 	MaxRowGroupBytes  uint64 // This is the maximum row group size in bytes that the raw data uses in memory.
 	MaxBlockBytes     uint64 // This is the size of all parquet tables in memory after which a new block is cut
+	WriterOptions     []parquet.WriterOption
 }
 
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.DataPath, "pyroscopedb.data-path", "./data", "Directory used for local storage.")
 	f.DurationVar(&cfg.MaxBlockDuration, "pyroscopedb.max-block-duration", 3*time.Hour, "Upper limit to the duration of a Pyroscope block.")
 	f.Uint64Var(&cfg.RowGroupTargetSize, "pyroscopedb.row-group-target-size", 10*128*1024*1024, "How big should a single row group be uncompressed") // This should roughly be 128MiB compressed
+	f.DurationVar(&cfg.OutOfOrderWindow, "pyroscopedb.out-of-order-window", 0, "Profiles older than the latest profile of the head by more than this duration are rejected. 0 to accept profiles in any order.")
+	f.Uint64Var(&cfg.MaxHeadMemoryBytes, "pyroscopedb.max-head-memory-bytes", 0, "Memory in bytes the head of a tenant may hold (profiles, symbols and stack traces) before it is flushed to a block, regardless of the max block duration. 0 to disable.")
+	cfg.BlockWriter.RegisterFlags(f)
+	cfg.WAL.RegisterFlags(f)
+}
+
+func (cfg *Config) Validate() error {
+	if err := cfg.BlockWriter.Validate(); err != nil {
+		return err
+	}
+	return cfg.WAL.Validate()
 }
 
 type TenantLimiter interface {
@@ -88,6 +131,9 @@ type PhlareDB struct {
 	// flushLock serializes flushes. Only one flush at a time
 	// is allowed.
 	flushLock sync.Mutex
+	// memoryFlush is notified when an ingestion request
+	// finds the head over the memory limit.
+	memoryFlush chan struct{}
 
 	blockQuerier *BlockQuerier
 	limiter      TenantLimiter
@@ -103,6 +149,8 @@ func New(phlarectx context.Context, cfg Config, limiter TenantLimiter, fs phlare
 		evictCh: make(chan *blockEviction),
 		metrics: newHeadMetrics(reg),
 		limiter: limiter,
+
+		memoryFlush: make(chan struct{}, 1),
 	}
 
 	f.forceFlush = time.NewTicker(f.maxBlockDuration())
@@ -113,16 +161,30 @@ func New(phlarectx context.Context, cfg Config, limiter TenantLimiter, fs phlare
 	// ensure head metrics are registered early so they are reused for the new head
 	phlarectx = contextWithHeadMetrics(phlarectx, f.metrics)
 	f.phlarectx = phlarectx
+
+	// Recover the heads that were not flushed before the blocks are synced,
+	// so that the replayed blocks are visible to queries from the start.
+	// Heads that have a snapshot are restored to the head instead.
+	ctx := context.Background()
+	if err := f.takeOverHeadWALs(); err != nil {
+		return nil, err
+	}
+	if err := f.replayHeads(ctx); err != nil {
+		return nil, err
+	}
+
 	f.wg.Add(1)
 	go f.loop()
 
 	f.blockQuerier = NewBlockQuerier(phlarectx, phlareobj.NewPrefixedBucket(fs, PathLocal))
 
 	// do an initial querier sync
-	ctx := context.Background()
 	if err := f.blockQuerier.Sync(ctx); err != nil {
 		return nil, err
 	}
+	if _, err := f.RestoreSnapshots(ctx); err != nil {
+		return nil, err
+	}
 	return f, nil
 }
 
@@ -162,6 +224,14 @@ func (f *PhlareDB) loop() {
 		case <-headSizeCheck.C:
 			if f.headSize() > maxBlockBytes {
 				f.flushHead(ctx, flushReasonMaxBlockBytes)
+			} else if f.headMemoryExceeded() {
+				f.flushHead(ctx, flushReasonMaxMemory)
+			}
+		case <-f.memoryFlush:
+			// The estimate the request relied on may be stale:
+			// the head might have been flushed in the meantime.
+			if f.headMemoryExceeded() {
+				f.flushHead(ctx, flushReasonMaxMemory)
 			}
 		case <-f.forceFlush.C:
 			f.flushHead(ctx, flushReasonMaxDuration)
@@ -200,7 +270,12 @@ func (f *PhlareDB) Close() error {
 	f.wg.Wait()
 	errs := multierror.New()
 	if f.head != nil {
-		errs.Add(f.head.Flush(f.phlarectx))
+		if f.hasSnapshot(f.head.meta.ULID) {
+			// The head will be restored from the snapshot and its WAL.
+			errs.Add(f.head.Close())
+		} else {
+			errs.Add(f.head.Flush(f.phlarectx))
+		}
 	}
 	close(f.evictCh)
 	if err := f.blockQuerier.Close(); err != nil {
@@ -229,10 +304,40 @@ func (f *PhlareDB) queriers() Queriers {
 
 func (f *PhlareDB) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, externalLabels ...*typesv1.LabelPair) (err error) {
 	return f.withHeadForIngest(func(head *Head) error {
-		return head.Ingest(ctx, p, id, externalLabels...)
+		if err := head.Ingest(ctx, p, id, externalLabels...); err != nil {
+			return err
+		}
+		f.checkHeadMemory(head)
+		return nil
 	})
 }
 
+// checkHeadMemory notifies the loop if the head is over the memory limit.
+// The check relies on the estimate of the head memory size, which is cheap
+// but may lag behind: the loop checks the precise stats before flushing.
+func (f *PhlareDB) checkHeadMemory(h *Head) {
+	if f.cfg.MaxHeadMemoryBytes == 0 || h.MemorySize() < f.cfg.MaxHeadMemoryBytes {
+		return
+	}
+	select {
+	case f.memoryFlush <- struct{}{}:
+	default:
+	}
+}
+
+func (f *PhlareDB) headMemoryExceeded() bool {
+	if f.cfg.MaxHeadMemoryBytes == 0 {
+		return false
+	}
+	f.headLock.RLock()
+	defer f.headLock.RUnlock()
+	if h := f.head; h != nil {
+		stats := h.MemoryStats()
+		return stats.Total() >= f.cfg.MaxHeadMemoryBytes
+	}
+	return false
+}
+
 func (f *PhlareDB) withHeadForIngest(fn func(*Head) error) (err error) {
 	// We need to keep track of the in-flight ingestion requests to ensure that none
 	// of them will compete with Flush. Lock is acquired to avoid Add after Wait that
@@ -293,6 +398,7 @@ type flushReason string
 const (
 	flushReasonMaxDuration   = "max-duration"
 	flushReasonMaxBlockBytes = "max-block-bytes"
+	flushReasonMaxMemory     = "max-memory"
 )
 
 func (f *PhlareDB) flushHead(ctx context.Context, reason flushReason) {
@@ -353,12 +459,36 @@ func (f *PhlareDB) Series(ctx context.Context, req *connect.Request[ingestv1.Ser
 	return connect.NewResponse(res), nil
 }
 
+func (f *PhlareDB) MergeSpanProfile(ctx context.Context, req *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+	f.headLock.RLock()
+	defer f.headLock.RUnlock()
+
+	res, err := MergeSpanProfile(ctx, req.Msg, f.queriers().ForTimeRange)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
 func (f *PhlareDB) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
 	f.headLock.RLock()
 	defer f.headLock.RUnlock()
 	return MergeProfilesStacktraces(ctx, stream, f.queriers().ForTimeRange)
 }
 
+// MergeBlocksStacktraces merges the stacktraces of the blocks cut from the
+// head for which include returns true. The head is not queried.
+func (f *PhlareDB) MergeBlocksStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse], include func(ulid.ULID) bool) error {
+	queriers := f.blockQuerier.Queriers()
+	blocks := make(Queriers, 0, len(queriers))
+	for _, q := range queriers {
+		if m, ok := q.(interface{ Meta() block.Meta }); ok && include(m.Meta().ULID) {
+			blocks = append(blocks, q)
+		}
+	}
+	return MergeProfilesStacktraces(ctx, stream, blocks.ForTimeRange)
+}
+
 func (f *PhlareDB) MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse]) error {
 	f.headLock.RLock()
 	defer f.headLock.RUnlock()
@@ -534,6 +664,15 @@ func (f *PhlareDB) Flush(ctx context.Context) (err error) {
 	if err = f.oldHead.Flush(ctx); err != nil {
 		return err
 	}
+	// Split the block before it is moved to the local directory: once the
+	// block is there, it may be picked up by the shipper at any time.
+	splitDir, split, splitErr := f.splitHead(ctx, f.oldHead)
+	if splitErr != nil {
+		level.Warn(f.logger).Log("msg", "failed to split head block, keeping it as a single block", "block", f.oldHead.meta.ULID, "err", splitErr)
+		if err = os.RemoveAll(splitDir); err != nil {
+			return err
+		}
+	}
 	// At this point we ensure that the data has been flushed on disk.
 	// Now we need to make it "visible" to queries, and close the old
 	// head once in-flight queries finish.
@@ -542,13 +681,85 @@ func (f *PhlareDB) Flush(ctx context.Context) (err error) {
 	//  queries that target the old head.
 	f.headLock.Lock()
 	// Now that there are no in-flight queries we can move the head.
-	err = f.oldHead.Move()
-	// Propagate the new block to blockQuerier.
-	f.blockQuerier.AddBlockQuerierByMeta(f.oldHead.meta)
+	if splitErr == nil && len(split) > 0 {
+		err = f.moveSplitHead(f.oldHead, splitDir, split)
+	} else {
+		err = f.oldHead.Move()
+		// Propagate the new block to blockQuerier.
+		f.blockQuerier.AddBlockQuerierByMeta(f.oldHead.meta)
+	}
+	flushed := f.oldHead.meta.ULID
 	f.oldHead = nil
 	f.headLock.Unlock()
 	// The old in-memory head is not available to queries from now on.
-	return err
+	if err != nil {
+		return err
+	}
+	// The snapshot of the head must not be restored once it is a block.
+	return f.removeSnapshot(flushed)
+}
+
+func (f *PhlareDB) splitShards() uint64 {
+	if f.cfg.SplitShards == nil {
+		return 0
+	}
+	if n := f.cfg.SplitShards(); n > 1 {
+		return uint64(n)
+	}
+	return 0
+}
+
+// splitHead splits the flushed head block into shards. The resulting blocks
+// are written to a directory within the head block and keep the ingester as
+// their source, as if they were flushed directly from the head.
+func (f *PhlareDB) splitHead(ctx context.Context, h *Head) (string, []block.Meta, error) {
+	shards := f.splitShards()
+	if shards == 0 {
+		return "", nil, nil
+	}
+	dir := filepath.Join(h.headPath, pathSplit)
+	bkt, err := filesystem.NewBucket(filepath.Dir(h.headPath))
+	if err != nil {
+		return dir, nil, err
+	}
+	q := NewSingleBlockQuerierFromMeta(f.phlarectx, bkt, h.meta)
+	if err = q.Open(ctx); err != nil {
+		return dir, nil, err
+	}
+	defer func() {
+		_ = q.Close()
+	}()
+	metas, err := compactWithSplitting(ctx, []BlockReader{q}, shards, dir, f.cfg.BlockWriter)
+	if err != nil {
+		return dir, nil, err
+	}
+	for i := range metas {
+		metas[i].Source = h.meta.Source
+		metas[i].Compaction.Level = h.meta.Compaction.Level
+		metas[i].Compaction.Parents = nil
+		if _, err = metas[i].WriteToFile(f.logger, filepath.Join(dir, metas[i].ULID.String())); err != nil {
+			return dir, nil, err
+		}
+	}
+	return dir, metas, nil
+}
+
+// moveSplitHead moves the blocks produced by splitHead to the local
+// directory and removes the head block. The call is not thread-safe:
+// no concurrent reads and writes are allowed.
+func (f *PhlareDB) moveSplitHead(h *Head, dir string, metas []block.Meta) error {
+	if err := os.MkdirAll(f.LocalDataPath(), defaultFolderMode); err != nil {
+		return err
+	}
+	for i := range metas {
+		id := metas[i].ULID.String()
+		if err := fileutil.Rename(filepath.Join(dir, id), filepath.Join(f.LocalDataPath(), id)); err != nil {
+			return err
+		}
+		f.blockQuerier.AddBlockQuerierByMeta(&metas[i])
+	}
+	level.Info(f.logger).Log("msg", "head successfully written to split blocks", "block", h.meta.ULID, "shards", len(metas))
+	return h.Remove()
 }
 
 type blockEviction struct {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -25,7 +26,9 @@ import (
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
 	"github.com/grafana/pyroscope/pkg/testhelper"
 )
 
@@ -112,6 +115,14 @@ func (i *ingesterHandlerPhlareDB) MergeProfilesPprof(ctx context.Context, stream
 	return MergeProfilesPprof(ctx, stream, i.ForTimeRange)
 }
 
+func (i *ingesterHandlerPhlareDB) MergeBlocksStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
+	return MergeProfilesStacktraces(ctx, stream, i.ForTimeRange)
+}
+
+func (i *ingesterHandlerPhlareDB) MergeSpanProfile(context.Context, *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+	return nil, errors.New("not implemented")
+}
+
 func (i *ingesterHandlerPhlareDB) Push(context.Context, *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
 	return nil, errors.New("not implemented")
 }
@@ -588,3 +599,67 @@ func Test_FlushNotInitializedHead(t *testing.T) {
 	require.NotZero(t, db.headSize())
 	require.NoError(t, db.Flush(ctx))
 }
+
+func Test_FlushSplitHead(t *testing.T) {
+	ctx := testContext(t)
+
+	db, err := New(ctx, Config{
+		DataPath:    contextDataDir(ctx),
+		SplitShards: func() int { return 4 },
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	var (
+		end   = time.Unix(0, int64(time.Hour))
+		start = end.Add(-time.Minute)
+		step  = 5 * time.Second
+	)
+	for _, pod := range []string{"pod-a", "pod-b", "pod-c", "pod-d", "pod-e"} {
+		ingestProfiles(t, db, cpuProfileGenerator, start.UnixNano(), end.UnixNano(), step,
+			&typesv1.LabelPair{Name: "pod", Value: pod},
+		)
+	}
+	require.NoError(t, db.Flush(ctx))
+
+	metas, err := db.BlockMetas(ctx)
+	require.NoError(t, err)
+	require.Greater(t, len(metas), 1)
+	var series uint64
+	for _, m := range metas {
+		require.NotEqual(t, block.CompactorSource, m.Source)
+		require.Equal(t, 1, m.Compaction.Level)
+		require.Contains(t, m.Labels, sharding.CompactorShardIDLabel)
+		series += m.Stats.NumSeries
+	}
+	// Each CPU profile has two sample types.
+	require.Equal(t, uint64(10), series)
+
+	entries, err := os.ReadDir(filepath.Join(contextDataDir(ctx), pathHead))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func Test_FlushOnMaxHeadMemory(t *testing.T) {
+	ctx := testContext(t)
+
+	db, err := New(ctx, Config{
+		DataPath:           contextDataDir(ctx),
+		MaxBlockDuration:   time.Hour,
+		MaxHeadMemoryBytes: 1,
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	end := time.Unix(0, int64(time.Hour))
+	ingestProfiles(t, db, cpuProfileGenerator, end.Add(-time.Minute).UnixNano(), end.UnixNano(), 15*time.Second)
+	require.Eventually(t, func() bool {
+		metas, err := db.BlockMetas(ctx)
+		require.NoError(t, err)
+		return len(metas) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+}
@@ -63,7 +63,8 @@ type profileStore struct {
 }
 
 func newParquetProfileWriter(writer io.Writer, options ...parquet.WriterOption) *parquet.GenericWriter[*schemav1.Profile] {
-	options = append(options, parquet.PageBufferSize(3*1024*1024))
+	// The default options go first, so that they can be overridden.
+	options = append([]parquet.WriterOption{parquet.PageBufferSize(defaultPageBufferSize)}, options...)
 	options = append(options, parquet.CreatedBy("github.com/grafana/pyroscope/", build.Version, build.Revision))
 	options = append(options, parquet.ColumnPageBuffers(parquet.NewFileBufferPool(os.TempDir(), "pyroscopedb-parquet-buffers*")))
 	options = append(options, schemav1.ProfilesSchema)
@@ -107,6 +108,7 @@ func (s *profileStore) Init(path string, cfg *ParquetConfig, metrics *headMetric
 	if err := s.Close(); err != nil {
 		return err
 	}
+	s.writer = newParquetProfileWriter(io.Discard, cfg.WriterOptions...)
 	s.flushQueue = make(chan int)
 	s.closeOnce = sync.Once{}
 	s.flushWg.Add(1)
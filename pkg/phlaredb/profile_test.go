@@ -72,6 +72,18 @@ func TestIndex(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, fps, 20)
 
+	// Query shards select disjoint subsets of the series.
+	var sharded int
+	for _, shard := range []string{"1_of_3", "2_of_3", "3_of_3"} {
+		fps, err := a.selectMatchingFPs(ctx, &ingestv1.SelectProfilesRequest{
+			LabelSelector: `memory{bar=~"[0-9]", __query_shard__="` + shard + `"}`,
+			Type:          &typesv1.ProfileType{},
+		})
+		require.NoError(t, err)
+		sharded += len(fps)
+	}
+	require.Equal(t, 20, sharded)
+
 	names, err := a.ix.LabelNames(nil)
 	require.NoError(t, err)
 	require.Equal(t, []string{"__name__", "__profile_type__", "__sample__type__", "bar"}, names)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/gogo/status"
 	"github.com/opentracing/opentracing-go"
@@ -22,6 +23,7 @@ import (
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/phlaredb/query"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
 )
@@ -127,6 +129,10 @@ type profileSeries struct {
 
 	minTime, maxTime int64
 
+	// lastSeen is the time the latest profile of the series has been
+	// received at, used to evict idle series from the inverted index.
+	lastSeen int64
+
 	// profiles in memory
 	profiles []*schemav1.InMemoryProfile
 
@@ -138,7 +144,10 @@ type profileSeries struct {
 type profilesIndex struct {
 	ix *tsdb.BitPrefixInvertedIndex
 	// todo: like the inverted index we might want to shard fingerprint to avoid contentions.
-	profilesPerFP   map[model.Fingerprint]*profileSeries
+	profilesPerFP map[model.Fingerprint]*profileSeries
+	// idle series are removed from the inverted index, but are kept in
+	// profilesPerFP: they are written to the block and can be queried.
+	idle            map[model.Fingerprint]*profileSeries
 	mutex           sync.RWMutex
 	totalProfiles   *atomic.Int64
 	totalSeries     *atomic.Int64
@@ -155,6 +164,7 @@ func newProfileIndex(totalShards uint32, metrics *headMetrics) (*profilesIndex,
 	return &profilesIndex{
 		ix:            ix,
 		profilesPerFP: make(map[model.Fingerprint]*profileSeries),
+		idle:          make(map[model.Fingerprint]*profileSeries),
 		totalProfiles: atomic.NewInt64(0),
 		totalSeries:   atomic.NewInt64(0),
 		metrics:       metrics,
@@ -179,7 +189,11 @@ func (pi *profilesIndex) Add(ps *schemav1.InMemoryProfile, lbs phlaremodel.Label
 		pi.profilesPerFP[ps.SeriesFingerprint] = profiles
 		pi.metrics.series.Set(float64(pi.totalSeries.Inc()))
 		pi.metrics.seriesCreated.WithLabelValues(profileName).Inc()
+	} else if _, idle := pi.idle[ps.SeriesFingerprint]; idle {
+		profiles.lbs = pi.ix.Add(profiles.lbs, ps.SeriesFingerprint)
+		delete(pi.idle, ps.SeriesFingerprint)
 	}
+	profiles.lastSeen = time.Now().UnixNano()
 
 	// profile is latest in this series, use a shortcut
 	if ps.TimeNanos > profiles.maxTime {
@@ -215,19 +229,26 @@ func (pi *profilesIndex) selectMatchingFPs(ctx context.Context, params *ingestv1
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "failed to parse label selectors: "+err.Error())
 	}
+	shard, selectors, err := sharding.RemoveShardFromMatchers(selectors)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse query shard: "+err.Error())
+	}
 	if params.Type == nil {
 		return nil, errors.New("no profileType given")
 	}
-	selectors = append(selectors, phlaremodel.SelectorFromProfileType(params.Type))
-
-	filters, matchers := SplitFiltersAndMatchers(selectors)
-	ids, err := pi.ix.Lookup(matchers, nil)
+	typeSelectors, err := phlaremodel.SelectorsFromProfileType(params.Type)
 	if err != nil {
 		return nil, err
 	}
+	selectors = append(selectors, typeSelectors...)
 
+	filters, matchers := SplitFiltersAndMatchers(selectors)
 	pi.mutex.RLock()
 	defer pi.mutex.RUnlock()
+	ids, err := pi.lookup(matchers)
+	if err != nil {
+		return nil, err
+	}
 
 	// filter fingerprints that no longer exist or don't match the filters
 	var idx int
@@ -239,6 +260,9 @@ outer:
 			// and is supposed to be picked up from storage by querier
 			continue
 		}
+		if shard != nil && !shard.Contains(uint64(fp)) {
+			continue
+		}
 		for _, filter := range filters {
 			if !filter.Matches(profile.lbs.Get(filter.Name)) {
 				continue outer
@@ -367,14 +391,13 @@ func (pi *profilesIndex) forMatchingLabels(matchers []*labels.Matcher,
 	fn func(lbs phlaremodel.Labels, fp model.Fingerprint) error,
 ) error {
 	filters, matchers := SplitFiltersAndMatchers(matchers)
-	ids, err := pi.ix.Lookup(matchers, nil)
+	pi.mutex.RLock()
+	defer pi.mutex.RUnlock()
+	ids, err := pi.lookup(matchers)
 	if err != nil {
 		return err
 	}
 
-	pi.mutex.RLock()
-	defer pi.mutex.RUnlock()
-
 outer:
 	for _, fp := range ids {
 		profile, ok := pi.profilesPerFP[fp]
@@ -395,6 +418,96 @@ outer:
 	return nil
 }
 
+// lookup returns the fingerprints of the series matching the matchers,
+// including the idle ones. The caller must hold the index lock.
+func (pi *profilesIndex) lookup(matchers []*labels.Matcher) ([]model.Fingerprint, error) {
+	ids, err := pi.ix.Lookup(matchers, nil)
+	if err != nil || len(pi.idle) == 0 {
+		return ids, err
+	}
+	n := len(ids)
+outer:
+	for fp, s := range pi.idle {
+		for _, m := range matchers {
+			if !m.Matches(s.lbs.Get(m.Name)) {
+				continue outer
+			}
+		}
+		ids = append(ids, fp)
+	}
+	if len(ids) > n {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return ids, nil
+}
+
+// labelNames returns the label names of all the series, including the idle ones.
+func (pi *profilesIndex) labelNames() ([]string, error) {
+	pi.mutex.RLock()
+	defer pi.mutex.RUnlock()
+	names, err := pi.ix.LabelNames(nil)
+	if err != nil || len(pi.idle) == 0 {
+		return names, err
+	}
+	unique := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		unique[n] = struct{}{}
+	}
+	for _, s := range pi.idle {
+		for _, l := range s.lbs {
+			unique[l.Name] = struct{}{}
+		}
+	}
+	return lo.Keys(unique), nil
+}
+
+// labelValues returns the values of the label of all the series,
+// including the idle ones.
+func (pi *profilesIndex) labelValues(name string) ([]string, error) {
+	pi.mutex.RLock()
+	defer pi.mutex.RUnlock()
+	values, err := pi.ix.LabelValues(name, nil)
+	if err != nil || len(pi.idle) == 0 {
+		return values, err
+	}
+	unique := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		unique[v] = struct{}{}
+	}
+	for _, s := range pi.idle {
+		if v := s.lbs.Get(name); v != "" {
+			unique[v] = struct{}{}
+		}
+	}
+	return lo.Keys(unique), nil
+}
+
+// evictIdleSeries removes the series that have not received profiles since
+// the given time from the inverted index. The series are retained until the
+// head is flushed, and the fingerprints of the evicted series are returned.
+func (pi *profilesIndex) evictIdleSeries(before int64) []model.Fingerprint {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	var evicted []model.Fingerprint
+	for fp, s := range pi.profilesPerFP {
+		if s.lastSeen >= before {
+			continue
+		}
+		if _, idle := pi.idle[fp]; idle {
+			continue
+		}
+		pi.ix.Delete(s.lbs, fp)
+		pi.idle[fp] = s
+		if len(s.profiles) == 0 {
+			// Release the slice retained after the row group cut.
+			s.profiles = nil
+		}
+		evicted = append(evicted, fp)
+	}
+	pi.metrics.seriesEvicted.Add(float64(len(evicted)))
+	return evicted
+}
+
 // WriteTo writes the profiles tsdb index to the specified filepath.
 func (pi *profilesIndex) writeTo(ctx context.Context, path string) ([][]rowRangeWithSeriesIndex, error) {
 	writer, err := index.NewWriter(ctx, path)
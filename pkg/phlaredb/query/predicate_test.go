@@ -3,6 +3,7 @@ package query
 import (
 	"bytes"
 	"context"
+	"math"
 	"testing"
 
 	"github.com/google/uuid"
@@ -52,6 +53,35 @@ func TestSubstringPredicate(t *testing.T) {
 	})
 }
 
+type Uint64 struct {
+	U uint64
+}
+
+func TestUint64InPredicate(t *testing.T) {
+	// Bounds of the pages are compared as unsigned values.
+	testPredicate(t, predicateTestCase[Uint64]{
+		predicate:  NewUint64InPredicate([]uint64{math.MaxUint64 - 1, 2}),
+		keptChunks: 1,
+		keptPages:  1,
+		keptValues: 2,
+		writeData: func(w *parquet.GenericWriter[Uint64]) {
+			_, err := w.Write([]Uint64{{1}, {2}, {math.MaxUint64 - 1}, {math.MaxUint64}})
+			require.NoError(t, err)
+		},
+	})
+
+	testPredicate(t, predicateTestCase[Uint64]{
+		predicate:  NewUint64InPredicate([]uint64{math.MaxUint64 - 1}),
+		keptChunks: 0,
+		keptPages:  0,
+		keptValues: 0,
+		writeData: func(w *parquet.GenericWriter[Uint64]) {
+			_, err := w.Write([]Uint64{{1}, {2}, {3}})
+			require.NoError(t, err)
+		},
+	})
+}
+
 type predicateTestCase[P any] struct {
 	writeData  func(w *parquet.GenericWriter[P])
 	keptChunks int
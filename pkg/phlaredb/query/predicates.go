@@ -2,6 +2,7 @@ package query
 
 import (
 	"bytes"
+	"sort"
 	"strings"
 
 	pq "github.com/parquet-go/parquet-go"
@@ -211,6 +212,55 @@ func (p EqualInt64Predicate) KeepPage(page pq.Page) bool {
 	return true
 }
 
+// Uint64InPredicate checks for any of the given unsigned integers. Unlike
+// the map predicate, the bounds of the chunks and pages are compared as
+// unsigned values, so it can be used with values above math.MaxInt64, such
+// as span IDs.
+type Uint64InPredicate struct {
+	values []uint64
+}
+
+var _ Predicate = (*Uint64InPredicate)(nil)
+
+func NewUint64InPredicate(values []uint64) *Uint64InPredicate {
+	p := &Uint64InPredicate{values: make([]uint64, len(values))}
+	copy(p.values, values)
+	sort.Slice(p.values, func(i, j int) bool { return p.values[i] < p.values[j] })
+	return p
+}
+
+// any returns whether any of the values is in [min, max].
+func (p *Uint64InPredicate) any(min, max uint64) bool {
+	i := sort.Search(len(p.values), func(i int) bool { return p.values[i] >= min })
+	return i < len(p.values) && p.values[i] <= max
+}
+
+func (p *Uint64InPredicate) KeepColumnChunk(c pq.ColumnChunk) bool {
+	if ci := c.ColumnIndex(); ci != nil {
+		for i := 0; i < ci.NumPages(); i++ {
+			if p.any(ci.MinValue(i).Uint64(), ci.MaxValue(i).Uint64()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (p *Uint64InPredicate) KeepValue(v pq.Value) bool {
+	vv := v.Uint64()
+	i := sort.Search(len(p.values), func(i int) bool { return p.values[i] >= vv })
+	return i < len(p.values) && p.values[i] == vv
+}
+
+func (p *Uint64InPredicate) KeepPage(page pq.Page) bool {
+	if min, max, ok := page.Bounds(); ok {
+		return p.any(min.Uint64(), max.Uint64())
+	}
+	return true
+}
+
 type InstrumentedPredicate struct {
 	pred                  Predicate // Optional, if missing then just keeps metrics with no filtering
 	InspectedColumnChunks atomic.Int64
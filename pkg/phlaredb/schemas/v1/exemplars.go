@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Exemplar references the samples of a profile attributed to a span or to
+// an endpoint. The exemplars are stored separately from the profiles, sorted
+// by span ID.
+type Exemplar struct {
+	SpanID   uint64 `parquet:",delta"`
+	TraceID  string `parquet:",dict"`
+	Endpoint string `parquet:",dict"`
+
+	// ProfileID and SeriesFingerprint identify the profile the samples
+	// belong to. Unlike the series index, the fingerprint is consistent
+	// between blocks.
+	ProfileID         uuid.UUID `parquet:",uuid"`
+	SeriesFingerprint uint64    `parquet:",delta"`
+
+	StacktracePartition uint64   `parquet:",delta"`
+	TimeNanos           int64    `parquet:",delta,timestamp(nanosecond)"`
+	StacktraceIDs       []uint32 `parquet:",list"`
+	Values              []uint64 `parquet:",list"`
+}
+
+func (e *Exemplar) Samples() Samples {
+	return Samples{
+		StacktraceIDs: e.StacktraceIDs,
+		Values:        e.Values,
+	}
+}
+
+var exemplarsSchema = parquet.SchemaOf(new(Exemplar))
+
+type ExemplarPersister struct{}
+
+func (*ExemplarPersister) Name() string { return "exemplars" }
+
+func (*ExemplarPersister) Schema() *parquet.Schema { return exemplarsSchema }
+
+func (*ExemplarPersister) SortingColumns() parquet.SortingOption {
+	return parquet.SortingColumns(
+		parquet.Ascending("SpanID"),
+		parquet.Ascending("TimeNanos"),
+	)
+}
+
+func (*ExemplarPersister) Deconstruct(row parquet.Row, _ uint64, e *Exemplar) parquet.Row {
+	return exemplarsSchema.Deconstruct(row, e)
+}
+
+func (*ExemplarPersister) Reconstruct(row parquet.Row) (uint64, *Exemplar, error) {
+	var e Exemplar
+	if err := exemplarsSchema.Reconstruct(&e, row); err != nil {
+		return 0, nil, err
+	}
+	return 0, &e, nil
+}
+
+// ExemplarLabel is an entry of the inverted index of the exemplars: the
+// rows of the exemplars with the label value.
+type ExemplarLabel struct {
+	Name  string   `parquet:",dict"`
+	Value string   `parquet:",dict"`
+	Rows  []uint32 `parquet:",list"`
+}
+
+var exemplarLabelsSchema = parquet.SchemaOf(new(ExemplarLabel))
+
+type ExemplarLabelPersister struct{}
+
+func (*ExemplarLabelPersister) Name() string { return "exemplar_labels" }
+
+func (*ExemplarLabelPersister) Schema() *parquet.Schema { return exemplarLabelsSchema }
+
+func (*ExemplarLabelPersister) SortingColumns() parquet.SortingOption {
+	return parquet.SortingColumns(
+		parquet.Ascending("Name"),
+		parquet.Ascending("Value"),
+	)
+}
+
+func (*ExemplarLabelPersister) Deconstruct(row parquet.Row, _ uint64, l *ExemplarLabel) parquet.Row {
+	return exemplarLabelsSchema.Deconstruct(row, l)
+}
+
+func (*ExemplarLabelPersister) Reconstruct(row parquet.Row) (uint64, *ExemplarLabel, error) {
+	var l ExemplarLabel
+	if err := exemplarLabelsSchema.Reconstruct(&l, row); err != nil {
+		return 0, nil, err
+	}
+	return 0, &l, nil
+}
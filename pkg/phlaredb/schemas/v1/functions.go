@@ -3,10 +3,20 @@ package v1
 import (
 	"github.com/parquet-go/parquet-go"
 
-	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	phlareparquet "github.com/grafana/pyroscope/pkg/parquet"
 )
 
-var functionsSchema = parquet.SchemaOf(new(profilev1.Function))
+// Functions of a partition are mostly distinct, but many of them are
+// defined in the same files: the string references are dictionary-encoded,
+// and the line numbers are delta-encoded. The schema is compatible with
+// the one of profilev1.Function, which was used before.
+var functionsSchema = parquet.NewSchema("Function", phlareparquet.Group{
+	phlareparquet.NewGroupField("Id", parquet.Encoded(parquet.Uint(64), &parquet.DeltaBinaryPacked)),
+	phlareparquet.NewGroupField("Name", parquet.Encoded(parquet.Uint(32), &parquet.RLEDictionary)),
+	phlareparquet.NewGroupField("SystemName", parquet.Encoded(parquet.Uint(32), &parquet.RLEDictionary)),
+	phlareparquet.NewGroupField("Filename", parquet.Encoded(parquet.Uint(32), &parquet.RLEDictionary)),
+	phlareparquet.NewGroupField("StartLine", parquet.Encoded(parquet.Uint(32), &parquet.DeltaBinaryPacked)),
+})
 
 type FunctionPersister struct{}
 
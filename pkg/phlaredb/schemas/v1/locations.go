@@ -3,10 +3,22 @@ package v1
 import (
 	"github.com/parquet-go/parquet-go"
 
-	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	phlareparquet "github.com/grafana/pyroscope/pkg/parquet"
 )
 
-var locationsSchema = parquet.SchemaOf(new(profilev1.Location))
+// The line table of the locations is delta-encoded: consecutive locations
+// usually refer to nearby lines of the same functions. The schema is
+// compatible with the one of profilev1.Location, which was used before.
+var locationsSchema = parquet.NewSchema("Location", phlareparquet.Group{
+	phlareparquet.NewGroupField("Id", parquet.Encoded(parquet.Uint(64), &parquet.DeltaBinaryPacked)),
+	phlareparquet.NewGroupField("MappingId", parquet.Encoded(parquet.Uint(32), &parquet.RLEDictionary)),
+	phlareparquet.NewGroupField("Address", parquet.Uint(64)),
+	phlareparquet.NewGroupField("Line", parquet.Repeated(phlareparquet.Group{
+		phlareparquet.NewGroupField("FunctionId", parquet.Encoded(parquet.Uint(32), &parquet.DeltaBinaryPacked)),
+		phlareparquet.NewGroupField("Line", parquet.Encoded(parquet.Int(32), &parquet.DeltaBinaryPacked)),
+	})),
+	phlareparquet.NewGroupField("IsFolded", parquet.Leaf(parquet.BooleanType)),
+})
 
 type LocationPersister struct{}
 
@@ -7,6 +7,6 @@ type Models interface {
 		*googlev1.Location | *InMemoryLocation |
 		*googlev1.Function | *InMemoryFunction |
 		*googlev1.Mapping | *InMemoryMapping |
-		*Stacktrace |
+		*Stacktrace | *Exemplar | *ExemplarLabel | *SeriesSketch |
 		string
 }
@@ -48,6 +48,7 @@ var (
 	stacktraceIDColIndex        int
 	timeNanoColIndex            int
 	stacktracePartitionColIndex int
+	totalValueColIndex          int
 )
 
 func init() {
@@ -75,6 +76,11 @@ func init() {
 		panic(fmt.Errorf("StacktracePartition column not found"))
 	}
 	stacktracePartitionColIndex = stacktracePartitionCol.ColumnIndex
+	totalValueCol, ok := ProfilesSchema.Lookup("TotalValue")
+	if !ok {
+		panic(fmt.Errorf("TotalValue column not found"))
+	}
+	totalValueColIndex = totalValueCol.ColumnIndex
 }
 
 type Sample struct {
@@ -550,6 +556,15 @@ func (p ProfileRow) StacktracePartitionID() uint64 {
 	return p[stacktracePartitionColIndex].Uint64()
 }
 
+// TotalValue returns the total value of the profile, if the row has the
+// column: the profiles of the older blocks don't.
+func (p ProfileRow) TotalValue() (uint64, bool) {
+	if len(p) <= totalValueColIndex || p[totalValueColIndex].Column() != totalValueColIndex {
+		return 0, false
+	}
+	return p[totalValueColIndex].Uint64(), true
+}
+
 func (p ProfileRow) TimeNanos() int64 {
 	var ts int64
 	for i := len(p) - 1; i >= 0; i-- {
@@ -164,6 +164,43 @@ func TestProfilesRoundTrip(t *testing.T) {
 	assert.Equal(t, newProfiles(), sRead)
 }
 
+func newExemplars() []*Exemplar {
+	return []*Exemplar{
+		{
+			SpanID:              0xa,
+			TraceID:             "0af7651916cd43dd8448eb211c80319c",
+			ProfileID:           uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+			SeriesFingerprint:   0xaa,
+			StacktracePartition: 0xba,
+			TimeNanos:           1001,
+			StacktraceIDs:       []uint32{1, 2},
+			Values:              []uint64{10, 20},
+		},
+		{
+			SpanID:            0xb,
+			ProfileID:         uuid.MustParse("00000000-0000-0000-0000-000000000002"),
+			SeriesFingerprint: 0xab,
+			TimeNanos:         1002,
+			StacktraceIDs:     []uint32{3},
+			Values:            []uint64{30},
+		},
+	}
+}
+
+func TestExemplarsRoundTrip(t *testing.T) {
+	var (
+		e   = newExemplars()
+		w   = &ReadWriter[*Exemplar, *ExemplarPersister]{}
+		buf bytes.Buffer
+	)
+
+	require.NoError(t, w.WriteParquetFile(&buf, e))
+
+	sRead, err := w.ReadParquetFile(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, newExemplars(), sRead)
+}
+
 func TestLocationsRoundTrip(t *testing.T) {
 	raw := []*profilev1.Location{
 		{
@@ -0,0 +1,45 @@
+package v1
+
+import (
+	"github.com/parquet-go/parquet-go"
+)
+
+// SeriesSketch is the sketch of the distribution of the totals of the
+// profiles of a series in a block. The sketches are stored separately from
+// the profiles, sorted by series fingerprint.
+type SeriesSketch struct {
+	// Unlike the series index, the fingerprint is consistent between blocks.
+	SeriesFingerprint uint64 `parquet:",delta"`
+
+	RelativeAccuracy float64
+	ZeroCount        uint64
+	Sum              float64
+	Min              float64
+	Max              float64
+	BinIndexes       []int32  `parquet:",list"`
+	BinCounts        []uint64 `parquet:",list"`
+}
+
+var seriesSketchesSchema = parquet.SchemaOf(new(SeriesSketch))
+
+type SeriesSketchPersister struct{}
+
+func (*SeriesSketchPersister) Name() string { return "sketches" }
+
+func (*SeriesSketchPersister) Schema() *parquet.Schema { return seriesSketchesSchema }
+
+func (*SeriesSketchPersister) SortingColumns() parquet.SortingOption {
+	return parquet.SortingColumns(parquet.Ascending("SeriesFingerprint"))
+}
+
+func (*SeriesSketchPersister) Deconstruct(row parquet.Row, _ uint64, s *SeriesSketch) parquet.Row {
+	return seriesSketchesSchema.Deconstruct(row, s)
+}
+
+func (*SeriesSketchPersister) Reconstruct(row parquet.Row) (uint64, *SeriesSketch, error) {
+	var s SeriesSketch
+	if err := seriesSketchesSchema.Reconstruct(&s, row); err != nil {
+		return 0, nil, err
+	}
+	return 0, &s, nil
+}
@@ -44,6 +44,27 @@ func (shard ShardSelector) Matcher() *labels.Matcher {
 	return labels.MustNewMatcher(labels.MatchEqual, ShardLabel, shard.LabelValue())
 }
 
+// Contains reports whether the series with the given fingerprint
+// belongs to the shard.
+func (shard ShardSelector) Contains(fp uint64) bool {
+	return fp%shard.ShardCount == shard.ShardIndex
+}
+
+// ContainsBlock reports whether a block produced by the split-and-merge
+// compactor with the given shard ID may hold series of the shard. Blocks
+// that have not been split, or were split into a number of shards that is
+// not a multiple of the query shard count, always may.
+func (shard ShardSelector) ContainsBlock(compactorShardID string) bool {
+	if compactorShardID == "" {
+		return true
+	}
+	index, count, err := ParseShardIDLabelValue(compactorShardID)
+	if err != nil || count%shard.ShardCount != 0 {
+		return true
+	}
+	return index%shard.ShardCount == shard.ShardIndex
+}
+
 // ShardFromMatchers extracts a ShardSelector and the index it was pulled from the matcher list.
 func ShardFromMatchers(matchers []*labels.Matcher) (shard *ShardSelector, idx int, err error) {
 	for i, matcher := range matchers {
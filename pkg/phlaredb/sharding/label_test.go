@@ -206,3 +206,22 @@ func TestFormatAndParseShardId(t *testing.T) {
 		require.Equal(t, uint64(count), ncount)
 	}
 }
+
+func TestShardSelector_ContainsBlock(t *testing.T) {
+	shard := ShardSelector{ShardIndex: 1, ShardCount: 2}
+
+	assert.True(t, shard.ContainsBlock(""))
+	assert.True(t, shard.ContainsBlock("invalid"))
+	assert.True(t, shard.ContainsBlock("1_of_3"))
+	assert.True(t, shard.ContainsBlock("2_of_4"))
+	assert.True(t, shard.ContainsBlock("4_of_4"))
+	assert.False(t, shard.ContainsBlock("1_of_4"))
+	assert.False(t, shard.ContainsBlock("3_of_4"))
+
+	for fp := uint64(0); fp < 16; fp++ {
+		block := FormatShardIDLabelValue(fp%8, 8)
+		if shard.Contains(fp) {
+			assert.True(t, shard.ContainsBlock(block))
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -29,6 +30,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb/fileutil"
 	"github.com/thanos-io/objstore"
 
+	"github.com/grafana/pyroscope/pkg/objstore/providers/s3"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 )
 
@@ -325,6 +327,10 @@ func (s *Shipper) upload(ctx context.Context, meta *block.Meta) error {
 	if _, err := meta.WriteToFile(s.logger, updir); err != nil {
 		return errors.Wrap(err, "write meta file")
 	}
+	ctx = s3.ContextWithObjectTags(ctx, map[string]string{
+		"block-min-time": meta.MinTime.Time().UTC().Format(time.RFC3339),
+		"block-max-time": meta.MaxTime.Time().UTC().Format(time.RFC3339),
+	})
 	return block.Upload(ctx, s.logger, s.bucket, updir)
 }
 
@@ -0,0 +1,165 @@
+// Package sketch implements the quantile sketches of the profile totals
+// stored in the blocks.
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultRelativeAccuracy is the relative accuracy of the quantiles of the
+// sketches of the blocks.
+const DefaultRelativeAccuracy = 0.01
+
+// DDSketch is a quantile sketch with a relative-error guarantee, as
+// described in "DDSketch: A Fast and Fully-Mergeable Quantile Sketch with
+// Relative-Error Guarantees". Values are counted in logarithmic bins, so
+// sketches with the same accuracy can be merged without loss.
+//
+// Only non-negative values are supported: the values are profile totals.
+type DDSketch struct {
+	relativeAccuracy float64
+	gamma            float64
+	logGamma         float64
+
+	bins      map[int32]uint64
+	zeroCount uint64
+	count     uint64
+	sum       float64
+	min, max  float64
+}
+
+// New returns an empty sketch. The quantiles returned are within the
+// relative accuracy of the actual values, which must be in (0, 1).
+func New(relativeAccuracy float64) *DDSketch {
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &DDSketch{
+		relativeAccuracy: relativeAccuracy,
+		gamma:            gamma,
+		logGamma:         math.Log(gamma),
+		bins:             make(map[int32]uint64),
+	}
+}
+
+// FromBins returns the sketch of the bins returned by Bins.
+func FromBins(relativeAccuracy float64, zeroCount uint64, sum, min, max float64, indexes []int32, counts []uint64) (*DDSketch, error) {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		return nil, fmt.Errorf("invalid relative accuracy %v", relativeAccuracy)
+	}
+	if len(indexes) != len(counts) {
+		return nil, fmt.Errorf("%d bin indexes for %d counts", len(indexes), len(counts))
+	}
+	s := New(relativeAccuracy)
+	s.zeroCount = zeroCount
+	s.count = zeroCount
+	for i, idx := range indexes {
+		s.bins[idx] += counts[i]
+		s.count += counts[i]
+	}
+	if s.count > 0 {
+		s.sum, s.min, s.max = sum, min, max
+	}
+	return s, nil
+}
+
+func (s *DDSketch) RelativeAccuracy() float64 { return s.relativeAccuracy }
+
+// Count returns the number of values added.
+func (s *DDSketch) Count() uint64 { return s.count }
+
+func (s *DDSketch) ZeroCount() uint64 { return s.zeroCount }
+
+func (s *DDSketch) Sum() float64 { return s.sum }
+
+func (s *DDSketch) Min() float64 { return s.min }
+
+func (s *DDSketch) Max() float64 { return s.max }
+
+// Add adds a value to the sketch. Negative values are counted as zero.
+func (s *DDSketch) Add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	if v <= 0 {
+		s.zeroCount++
+		return
+	}
+	s.bins[s.index(v)]++
+}
+
+// Merge adds the values of o to the sketch. The sketches must have the same
+// relative accuracy.
+func (s *DDSketch) Merge(o *DDSketch) error {
+	if s.relativeAccuracy != o.relativeAccuracy {
+		return fmt.Errorf("can't merge sketches with relative accuracies %v and %v", s.relativeAccuracy, o.relativeAccuracy)
+	}
+	if o.count == 0 {
+		return nil
+	}
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.zeroCount += o.zeroCount
+	for idx, c := range o.bins {
+		s.bins[idx] += c
+	}
+	return nil
+}
+
+// Quantile returns the estimated value of the quantile q, in [0, 1]. It
+// returns NaN if the sketch is empty.
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	rank := q * float64(s.count-1)
+	if rank < float64(s.zeroCount) {
+		return math.Max(0, s.min)
+	}
+	indexes, counts := s.Bins()
+	n := float64(s.zeroCount)
+	for i, idx := range indexes {
+		n += float64(counts[i])
+		if n > rank {
+			return math.Min(math.Max(s.value(idx), s.min), s.max)
+		}
+	}
+	return s.max
+}
+
+// Bins returns the indexes of the bins of the sketch, in ascending order,
+// and their counts.
+func (s *DDSketch) Bins() ([]int32, []uint64) {
+	indexes := make([]int32, 0, len(s.bins))
+	for idx := range s.bins {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	counts := make([]uint64, len(indexes))
+	for i, idx := range indexes {
+		counts[i] = s.bins[idx]
+	}
+	return indexes, counts
+}
+
+// index returns the bin of v: the values in (gamma^(i-1), gamma^i].
+func (s *DDSketch) index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the value of the bin within the relative accuracy of all
+// the values it counts.
+func (s *DDSketch) value(idx int32) float64 {
+	return 2 * math.Pow(s.gamma, float64(idx)) / (1 + s.gamma)
+}
@@ -0,0 +1,60 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDDSketch_Quantile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := New(DefaultRelativeAccuracy)
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = math.Floor(r.ExpFloat64() * 1e6)
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	require.Equal(t, uint64(len(values)), s.Count())
+	require.Equal(t, values[0], s.Min())
+	require.Equal(t, values[len(values)-1], s.Max())
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1} {
+		expected := values[int(q*float64(len(values)-1))]
+		require.InEpsilon(t, expected, s.Quantile(q), DefaultRelativeAccuracy, "quantile %v", q)
+	}
+	require.True(t, math.IsNaN(New(DefaultRelativeAccuracy).Quantile(0.5)))
+}
+
+func TestDDSketch_Merge(t *testing.T) {
+	a, b, all := New(DefaultRelativeAccuracy), New(DefaultRelativeAccuracy), New(DefaultRelativeAccuracy)
+	for i := 0; i < 1000; i++ {
+		v := float64(i * i)
+		all.Add(v)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	require.NoError(t, a.Merge(b))
+	require.Equal(t, all, a)
+	require.Error(t, a.Merge(New(0.05)))
+}
+
+func TestDDSketch_FromBins(t *testing.T) {
+	s := New(DefaultRelativeAccuracy)
+	for _, v := range []float64{0, 0, 1, 10, 100, 1000} {
+		s.Add(v)
+	}
+	indexes, counts := s.Bins()
+	decoded, err := FromBins(s.RelativeAccuracy(), s.ZeroCount(), s.Sum(), s.Min(), s.Max(), indexes, counts)
+	require.NoError(t, err)
+	require.Equal(t, s, decoded)
+
+	_, err = FromBins(s.RelativeAccuracy(), 0, 0, 0, 0, indexes, counts[1:])
+	require.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package phlaredb
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sketch"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+// seriesSketchSize estimates the size of the sketch of a series, with a
+// few dozens of bins.
+const seriesSketchSize = 512
+
+// sketchStore keeps the sketches of the totals of the profiles of the head
+// series in memory, and writes them to the block on flush.
+type sketchStore struct {
+	path string
+
+	mu       sync.RWMutex
+	sketches map[model.Fingerprint]*sketch.DDSketch
+	size     atomic.Uint64
+}
+
+func newSketchStore() *sketchStore {
+	return &sketchStore{sketches: make(map[model.Fingerprint]*sketch.DDSketch)}
+}
+
+func (s *sketchStore) Name() string {
+	return new(schemav1.SeriesSketchPersister).Name()
+}
+
+func (s *sketchStore) Size() uint64 { return s.size.Load() }
+
+func (s *sketchStore) MemorySize() uint64 { return s.size.Load() }
+
+func (s *sketchStore) Init(path string, _ *ParquetConfig, _ *headMetrics) error {
+	s.path = path
+	return nil
+}
+
+func (s *sketchStore) Flush(context.Context) (numRows uint64, numRowGroups uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.sketches) == 0 {
+		return 0, 0, nil
+	}
+	if err = writeSeriesSketches(s.path, s.sketches); err != nil {
+		return 0, 0, err
+	}
+	return uint64(len(s.sketches)), 1, nil
+}
+
+func (s *sketchStore) Close() error { return nil }
+
+func (s *sketchStore) ingest(fp model.Fingerprint, totalValue uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.sketches[fp]
+	if !ok {
+		x = sketch.New(sketch.DefaultRelativeAccuracy)
+		s.sketches[fp] = x
+		s.size.Add(seriesSketchSize)
+	}
+	x.Add(float64(totalValue))
+}
+
+// selectSketches returns copies of the sketches of the series.
+func (s *sketchStore) selectSketches(fps []model.Fingerprint) (map[model.Fingerprint]*sketch.DDSketch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	selected := make(map[model.Fingerprint]*sketch.DDSketch, len(fps))
+	for _, fp := range fps {
+		x, ok := s.sketches[fp]
+		if !ok {
+			continue
+		}
+		c := sketch.New(x.RelativeAccuracy())
+		if err := c.Merge(x); err != nil {
+			return nil, err
+		}
+		selected[fp] = c
+	}
+	return selected, nil
+}
+
+// writeSeriesSketches writes the sketches file of the block in dir.
+func writeSeriesSketches(dir string, sketches map[model.Fingerprint]*sketch.DDSketch) error {
+	rows := make([]*schemav1.SeriesSketch, 0, len(sketches))
+	for fp, x := range sketches {
+		indexes, counts := x.Bins()
+		rows = append(rows, &schemav1.SeriesSketch{
+			SeriesFingerprint: uint64(fp),
+			RelativeAccuracy:  x.RelativeAccuracy(),
+			ZeroCount:         x.ZeroCount(),
+			Sum:               x.Sum(),
+			Min:               x.Min(),
+			Max:               x.Max(),
+			BinIndexes:        indexes,
+			BinCounts:         counts,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].SeriesFingerprint < rows[j].SeriesFingerprint })
+	f, err := os.Create(filepath.Join(dir, new(schemav1.SeriesSketchPersister).Name()+".parquet"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := new(schemav1.ReadWriter[*schemav1.SeriesSketch, *schemav1.SeriesSketchPersister])
+	if err = w.WriteParquetFile(f, rows); err != nil {
+		return errors.Wrap(err, "writing series sketches")
+	}
+	return nil
+}
+
+func (q *headOnDiskQuerier) SeriesSketches(context.Context, *ingestv1.SelectProfilesRequest) (map[model.Fingerprint]*sketch.DDSketch, error) {
+	// Sketches are kept in memory until the head is flushed.
+	return nil, nil
+}
+
+func (q *headInMemoryQuerier) SeriesSketches(ctx context.Context, params *ingestv1.SelectProfilesRequest) (map[model.Fingerprint]*sketch.DDSketch, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SeriesSketches - HeadInMemory")
+	defer sp.Finish()
+	fps, err := q.head.profiles.index.selectMatchingFPs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return q.head.sketches.selectSketches(fps)
+}
+
+func (b *singleBlockQuerier) SeriesSketches(ctx context.Context, params *ingestv1.SelectProfilesRequest) (map[model.Fingerprint]*sketch.DDSketch, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SeriesSketches - Block")
+	defer sp.Finish()
+	if !b.hasSketches {
+		return nil, nil
+	}
+	if err := b.Open(ctx); err != nil {
+		return nil, err
+	}
+	fps, err := b.matchingFingerprints(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(fps) == 0 {
+		return nil, nil
+	}
+	var (
+		persister schemav1.SeriesSketchPersister
+		selected  = make(map[model.Fingerprint]*sketch.DDSketch, len(fps))
+		buf       = make([]parquet.Row, 256)
+	)
+	for _, rg := range b.sketches.file.RowGroups() {
+		if err = func() error {
+			rows := rg.Rows()
+			defer rows.Close()
+			for {
+				n, err := rows.ReadRows(buf)
+				for _, row := range buf[:n] {
+					_, s, rErr := persister.Reconstruct(row)
+					if rErr != nil {
+						return rErr
+					}
+					fp := model.Fingerprint(s.SeriesFingerprint)
+					if _, ok := fps[fp]; !ok {
+						continue
+					}
+					x, rErr := sketch.FromBins(s.RelativeAccuracy, s.ZeroCount, s.Sum, s.Min, s.Max, s.BinIndexes, s.BinCounts)
+					if rErr != nil {
+						return rErr
+					}
+					selected[fp] = x
+				}
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}(); err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// SeriesSketches returns the sketches of the distribution of the totals of
+// the profiles of the matching series, merged over the blocks. The blocks
+// are not filtered by the time range at the profile level: the sketches
+// cover the profiles of the blocks overlapping the range.
+func SeriesSketches(ctx context.Context, params *ingestv1.SelectProfilesRequest, blockGetter BlockGetter) (map[model.Fingerprint]*sketch.DDSketch, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SeriesSketches")
+	defer sp.Finish()
+	queriers, err := blockGetter(ctx, model.Time(params.Start), model.Time(params.End))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		merged = make(map[model.Fingerprint]*sketch.DDSketch)
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(50)
+	for _, q := range queriers {
+		q := q
+		g.Go(util.RecoverPanic(func() error {
+			selected, err := q.SeriesSketches(ctx, params)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for fp, x := range selected {
+				m, ok := merged[fp]
+				if !ok {
+					merged[fp] = x
+					continue
+				}
+				if err = m.Merge(x); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	if err = g.Wait(); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
@@ -0,0 +1,59 @@
+package phlaredb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/phlaredb/sketch"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func Test_SeriesSketches(t *testing.T) {
+	head := newTestHead(t)
+	ctx := context.Background()
+	for i := int64(1); i <= 100; i++ {
+		p := testhelper.NewProfileBuilder(i*1e9).CPUProfile().
+			ForStacktraceString("foo", "bar").AddSamples(i * 10)
+		require.NoError(t, head.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+	}
+
+	request := func(selector string) *ingestv1.SelectProfilesRequest {
+		return &ingestv1.SelectProfilesRequest{
+			LabelSelector: selector,
+			Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+			Start:         0,
+			End:           1000000,
+		}
+	}
+	assertSketch := func(getter BlockGetter) {
+		t.Helper()
+		sketches, err := SeriesSketches(ctx, request(`{job="foo"}`), getter)
+		require.NoError(t, err)
+		require.Len(t, sketches, 1)
+		for _, s := range sketches {
+			require.Equal(t, uint64(100), s.Count())
+			require.Equal(t, float64(10), s.Min())
+			require.Equal(t, float64(1000), s.Max())
+			require.InEpsilon(t, float64(500), s.Quantile(0.5), sketch.DefaultRelativeAccuracy*2)
+			require.InEpsilon(t, float64(990), s.Quantile(0.99), sketch.DefaultRelativeAccuracy*2)
+		}
+		sketches, err = SeriesSketches(ctx, request(`{job="bar"}`), getter)
+		require.NoError(t, err)
+		require.Empty(t, sketches)
+	}
+
+	assertSketch(head.Queriers().ForTimeRange)
+
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+	b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(ctx))
+	assertSketch(q.Queriers().ForTimeRange)
+}
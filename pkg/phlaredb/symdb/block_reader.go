@@ -21,6 +21,7 @@ import (
 	parquetobj "github.com/grafana/pyroscope/pkg/objstore/parquet"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 )
 
 type Reader struct {
@@ -37,6 +38,9 @@ type Reader struct {
 	mappings  parquetobj.File
 	functions parquetobj.File
 	strings   parquetobj.File
+
+	// Since FormatV3, the strings are shared by all the partitions.
+	blockStrings *parquetTableRange[string, *schemav1.StringPersister]
 }
 
 const defaultChunkFetchBufferSize = 4096
@@ -62,11 +66,19 @@ func (r *Reader) open(ctx context.Context) (err error) {
 	if err = r.openIndexFile(ctx); err != nil {
 		return fmt.Errorf("opening index file: %w", err)
 	}
-	if r.index.Header.Version == FormatV2 {
+	if r.index.Header.Version > FormatV1 {
 		if err = r.openParquetFiles(ctx); err != nil {
 			return err
 		}
 	}
+	if r.index.Header.Version > FormatV2 && len(r.index.PartitionHeaders) > 0 {
+		// Every partition header refers to the whole string table.
+		r.blockStrings = &parquetTableRange[string, *schemav1.StringPersister]{
+			bucket:  r.bucket,
+			headers: r.index.PartitionHeaders[0].Strings,
+			file:    &r.strings,
+		}
+	}
 	r.partitions = make(map[uint64]*partition, len(r.index.PartitionHeaders))
 	for _, h := range r.index.PartitionHeaders {
 		r.partitions[h.Partition] = r.partitionReader(h)
@@ -150,11 +162,14 @@ func (r *Reader) partitionReader(h *PartitionHeader) *partition {
 			headers: h.Functions,
 			file:    &r.functions,
 		},
-		strings: parquetTableRange[string, *schemav1.StringPersister]{
+		strings: r.blockStrings,
+	}
+	if p.strings == nil {
+		p.strings = &parquetTableRange[string, *schemav1.StringPersister]{
 			bucket:  r.bucket,
 			headers: h.Strings,
 			file:    &r.strings,
-		},
+		}
 	}
 	p.setStacktracesChunks(h.StacktraceChunks)
 	return p
@@ -196,7 +211,7 @@ type partition struct {
 	locations        parquetTableRange[*schemav1.InMemoryLocation, *schemav1.LocationPersister]
 	mappings         parquetTableRange[*schemav1.InMemoryMapping, *schemav1.MappingPersister]
 	functions        parquetTableRange[*schemav1.InMemoryFunction, *schemav1.FunctionPersister]
-	strings          parquetTableRange[string, *schemav1.StringPersister]
+	strings          *parquetTableRange[string, *schemav1.StringPersister]
 }
 
 func (p *partition) init(ctx context.Context) error {
@@ -367,6 +382,7 @@ func (c *stacktraceChunkReader) fetch(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	stats.FromContext(ctx).AddFetchedSymbolBytes(uint64(c.header.Size))
 	defer func() {
 		err = multierror.New(err, rc.Close()).Err()
 	}()
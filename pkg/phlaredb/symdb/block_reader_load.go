@@ -82,7 +82,12 @@ func (r *Reader) loadParquetTables(g *errgroup.Group) {
 	g.Go(func() error { return withRowIterator(r.locations, partitions, loadLocations) })
 	g.Go(func() error { return withRowIterator(r.functions, partitions, loadFunctions) })
 	g.Go(func() error { return withRowIterator(r.mappings, partitions, loadMappings) })
-	g.Go(func() error { return withRowIterator(r.strings, partitions, loadStrings) })
+	strings := partitions
+	if r.blockStrings != nil {
+		// The string table is shared: it's only loaded once.
+		strings = partitions[:1]
+	}
+	g.Go(func() error { return withRowIterator(r.strings, strings, loadStrings) })
 }
 
 func loadLocations(p *partition, i iter.Iterator[parquet.Row]) error { return p.locations.loadFrom(i) }
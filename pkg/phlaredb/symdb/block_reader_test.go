@@ -74,6 +74,30 @@ func Test_Reader_Open_v1(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func Test_Reader_shared_strings(t *testing.T) {
+	s := newBlockSuite(t, [][]string{{"testdata/profile.pb.gz"}, {"testdata/profile.pb.gz"}})
+	defer s.teardown()
+	require.Equal(t, uint32(FormatV3), s.reader.index.Header.Version)
+
+	ctx := context.Background()
+	p0, err := s.reader.partition(ctx, 0)
+	require.NoError(t, err)
+	defer p0.Release()
+	p1, err := s.reader.partition(ctx, 1)
+	require.NoError(t, err)
+	defer p1.Release()
+	require.Same(t, p0.strings, p1.strings)
+	require.Len(t, p0.strings.s, len(s.db.PartitionWriter(0).strings.slice))
+
+	expectedFingerprint := pprofFingerprint(s.profiles[1].Profile, 0)
+	r := NewResolver(ctx, s.reader)
+	defer r.Release()
+	r.AddSamples(1, s.indexed[1][0].Samples)
+	resolved, err := r.Tree()
+	require.NoError(t, err)
+	require.Equal(t, expectedFingerprint, treeFingerprint(resolved))
+}
+
 type mockStacktraceInserter struct{ mock.Mock }
 
 func (m *mockStacktraceInserter) InsertStacktrace(stacktraceID uint32, locations []int32) {
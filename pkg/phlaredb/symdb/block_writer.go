@@ -40,13 +40,14 @@ func newWriter(c *Config) *writer {
 		index: IndexFile{
 			Header: Header{
 				Magic:   symdbMagic,
-				Version: FormatV2,
+				Version: FormatV3,
 			},
 		},
 	}
 }
 
 func (w *writer) writePartitions(partitions []*PartitionWriter) error {
+	bs := newBlockStrings(partitions)
 	g, _ := errgroup.WithContext(context.Background())
 	g.Go(func() (err error) {
 		if w.stacktraces, err = w.newFile(StacktracesFileName); err != nil {
@@ -64,10 +65,13 @@ func (w *writer) writePartitions(partitions []*PartitionWriter) error {
 		if err = w.strings.init(w.config.Dir, w.config.Parquet); err != nil {
 			return err
 		}
+		// All the partitions share the block-wide string table.
+		refs, err := w.strings.readFrom(bs.strings)
+		if err != nil {
+			return err
+		}
 		for _, partition := range partitions {
-			if partition.header.Strings, err = w.strings.readFrom(partition.strings.slice); err != nil {
-				return err
-			}
+			partition.header.Strings = refs
 		}
 		return w.strings.Close()
 	})
@@ -76,8 +80,8 @@ func (w *writer) writePartitions(partitions []*PartitionWriter) error {
 		if err = w.functions.init(w.config.Dir, w.config.Parquet); err != nil {
 			return err
 		}
-		for _, partition := range partitions {
-			if partition.header.Functions, err = w.functions.readFrom(partition.functions.slice); err != nil {
+		for i, partition := range partitions {
+			if partition.header.Functions, err = w.functions.readFrom(bs.functions[i]); err != nil {
 				return err
 			}
 		}
@@ -88,8 +92,8 @@ func (w *writer) writePartitions(partitions []*PartitionWriter) error {
 		if err = w.mappings.init(w.config.Dir, w.config.Parquet); err != nil {
 			return err
 		}
-		for _, partition := range partitions {
-			if partition.header.Mappings, err = w.mappings.readFrom(partition.mappings.slice); err != nil {
+		for i, partition := range partitions {
+			if partition.header.Mappings, err = w.mappings.readFrom(bs.mappings[i]); err != nil {
 				return err
 			}
 		}
@@ -119,6 +123,60 @@ func (w *writer) writePartitions(partitions []*PartitionWriter) error {
 	return nil
 }
 
+// blockStrings is the string table shared by all the partitions of the
+// block. Function names and file names are repeated in many partitions
+// (e.g. the standard library and the runtime of a language): each string
+// is only stored once, and the functions and mappings of the partitions
+// are rewritten to refer to the block-wide table.
+type blockStrings struct {
+	strings   []string
+	functions [][]*schemav1.InMemoryFunction
+	mappings  [][]*schemav1.InMemoryMapping
+}
+
+func newBlockStrings(partitions []*PartitionWriter) *blockStrings {
+	b := blockStrings{
+		// The empty string is always the first one.
+		strings:   []string{""},
+		functions: make([][]*schemav1.InMemoryFunction, len(partitions)),
+		mappings:  make([][]*schemav1.InMemoryMapping, len(partitions)),
+	}
+	lookup := map[string]uint32{"": 0}
+	var refs []uint32
+	for i, p := range partitions {
+		// The partition symbols are not modified in place,
+		// as they may still be accessed by readers.
+		refs = refs[:0]
+		for _, s := range p.strings.slice {
+			x, ok := lookup[s]
+			if !ok {
+				x = uint32(len(b.strings))
+				b.strings = append(b.strings, s)
+				lookup[s] = x
+			}
+			refs = append(refs, x)
+		}
+		functions := make([]*schemav1.InMemoryFunction, len(p.functions.slice))
+		for j, f := range p.functions.slice {
+			c := f.Clone()
+			c.Name = refs[f.Name]
+			c.SystemName = refs[f.SystemName]
+			c.Filename = refs[f.Filename]
+			functions[j] = c
+		}
+		b.functions[i] = functions
+		mappings := make([]*schemav1.InMemoryMapping, len(p.mappings.slice))
+		for j, m := range p.mappings.slice {
+			c := m.Clone()
+			c.Filename = refs[m.Filename]
+			c.BuildId = refs[m.BuildId]
+			mappings[j] = c
+		}
+		b.mappings[i] = mappings
+	}
+	return &b
+}
+
 func (w *writer) Flush() (err error) {
 	if err = w.writeIndexFile(); err != nil {
 		return err
@@ -287,11 +345,13 @@ func (s *parquetWriter[M, P]) init(dir string, c ParquetConfig) (err error) {
 	}
 	s.rowsBatch = make([]parquet.Row, 0, 128)
 	s.buffer = parquet.NewBuffer(s.persister.Schema(), parquet.ColumnBufferCapacity(s.config.MaxBufferRowCount))
-	s.writer = parquet.NewGenericWriter[P](s.file, s.persister.Schema(),
+	options := append([]parquet.WriterOption{
+		s.persister.Schema(),
 		parquet.ColumnPageBuffers(parquet.NewFileBufferPool(os.TempDir(), "phlaredb-parquet-buffers*")),
 		parquet.CreatedBy("github.com/grafana/pyroscope/", build.Version, build.Revision),
-		parquet.PageBufferSize(3*1024*1024),
-	)
+		parquet.PageBufferSize(3 * 1024 * 1024),
+	}, s.config.WriterOptions...)
+	s.writer = parquet.NewGenericWriter[P](s.file, options...)
 	return nil
 }
 
@@ -356,7 +356,10 @@ func (t stringConversionTable) rewriteUint32(idx *uint32) {
 	*idx = uint32(newValue)
 }
 
-type stringsHelper struct{}
+type stringsHelper struct {
+	// pool is shared across SymDB instances; optional.
+	pool *InternPool
+}
 
 func (*stringsHelper) key(s string) string {
 	return s
@@ -389,7 +392,10 @@ func (*stringsHelper) setID(oldID, newID uint64, s string) uint64 {
 	return oldID
 }
 
-func (*stringsHelper) clone(s string) string {
+func (h *stringsHelper) clone(s string) string {
+	if h != nil && h.pool != nil {
+		return h.pool.intern(s)
+	}
 	return s
 }
 
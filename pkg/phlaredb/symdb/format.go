@@ -43,6 +43,11 @@ const (
 
 	FormatV1
 	FormatV2
+	// FormatV3 has the same layout as FormatV2, but the strings are
+	// deduplicated across the partitions: all the partitions refer to
+	// the same block-wide string table, and the functions and mappings
+	// store references to it.
+	FormatV3
 
 	unknownVersion
 )
@@ -438,7 +443,7 @@ func ReadIndexFile(b []byte) (f IndexFile, err error) {
 			return f, fmt.Errorf("unmarshal stacktraces: %w", err)
 		}
 
-	case FormatV2:
+	case FormatV2, FormatV3:
 		ph := f.TOC.Entries[tocEntryPartitionHeaders]
 		if err = f.PartitionHeaders.Unmarshal(b[ph.Offset : ph.Offset+ph.Size]); err != nil {
 			return f, fmt.Errorf("reading partition headers: %w", err)
@@ -0,0 +1,94 @@
+package symdb
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+const internPoolShards = 64
+
+// InternPool deduplicates the memory of the symbol strings (function
+// names, file names, build IDs, etc.) across SymDB instances, e.g. the
+// heads of all the tenants of an ingester: runtime and library frames
+// are largely the same across the tenants and series.
+//
+// The pool only shares the backing memory of the strings: every SymDB
+// keeps its own string table, therefore the blocks written by different
+// instances are isolated from each other. An instance releases its
+// strings once they are flushed; a string is removed from the pool when
+// it is not referenced by any instance.
+type InternPool struct {
+	seed   maphash.Seed
+	shards [internPoolShards]internPoolShard
+
+	strings atomic.Int64
+	size    atomic.Int64
+}
+
+type internPoolShard struct {
+	mu      sync.Mutex
+	strings map[string]*internedString
+}
+
+type internedString struct {
+	s    string
+	refs int
+}
+
+func NewInternPool() *InternPool {
+	p := &InternPool{seed: maphash.MakeSeed()}
+	for i := range p.shards {
+		p.shards[i].strings = make(map[string]*internedString)
+	}
+	return p
+}
+
+func (p *InternPool) shard(s string) *internPoolShard {
+	return &p.shards[maphash.String(p.seed, s)%internPoolShards]
+}
+
+// intern returns the pooled copy of the string, and retains it.
+func (p *InternPool) intern(s string) string {
+	if s == "" {
+		return s
+	}
+	x := p.shard(s)
+	x.mu.Lock()
+	e, ok := x.strings[s]
+	if !ok {
+		e = &internedString{s: s}
+		x.strings[s] = e
+		p.strings.Inc()
+		p.size.Add(int64(len(s)))
+	}
+	e.refs++
+	x.mu.Unlock()
+	return e.s
+}
+
+// release releases the strings retained with intern.
+func (p *InternPool) release(strings []string) {
+	for _, s := range strings {
+		if s == "" {
+			continue
+		}
+		x := p.shard(s)
+		x.mu.Lock()
+		if e, ok := x.strings[s]; ok {
+			if e.refs--; e.refs == 0 {
+				delete(x.strings, s)
+				p.strings.Dec()
+				p.size.Sub(int64(len(s)))
+			}
+		}
+		x.mu.Unlock()
+	}
+}
+
+// Len returns the number of strings in the pool.
+func (p *InternPool) Len() int { return int(p.strings.Load()) }
+
+// Size returns the total size of the strings in the pool, in bytes.
+func (p *InternPool) Size() uint64 { return uint64(p.size.Load()) }
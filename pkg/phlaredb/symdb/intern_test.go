@@ -0,0 +1,53 @@
+package symdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+)
+
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func Test_InternPool_shared(t *testing.T) {
+	pool := NewInternPool()
+	newSuite := func() *memSuite {
+		s := &memSuite{t: t, files: [][]string{{"testdata/profile.pb.gz"}}}
+		s.config = DefaultConfig().WithDirectory(t.TempDir()).WithInternPool(pool)
+		s.init()
+		return s
+	}
+	a, b := newSuite(), newSuite()
+
+	as := a.db.PartitionWriter(0).strings.slice
+	bs := b.db.PartitionWriter(0).strings.slice
+	require.Equal(t, as, bs)
+	// The empty string is not pooled.
+	require.Equal(t, len(as)-1, pool.Len())
+	for i := 1; i < len(as); i++ {
+		require.Equal(t, stringData(as[i]), stringData(bs[i]))
+	}
+
+	require.NoError(t, a.db.Flush())
+	require.Equal(t, len(as)-1, pool.Len())
+	require.NoError(t, b.db.Flush())
+	require.Zero(t, pool.Len())
+	require.Zero(t, pool.Size())
+
+	// Blocks written by the instances sharing the pool are self-contained.
+	bucket, err := filesystem.NewBucket(a.config.Dir)
+	require.NoError(t, err)
+	r, err := Open(context.Background(), bucket, testBlockMeta)
+	require.NoError(t, err)
+	defer r.Close()
+	p, err := r.partition(context.Background(), 0)
+	require.NoError(t, err)
+	defer p.Release()
+	require.Equal(t, as, p.strings.s)
+}
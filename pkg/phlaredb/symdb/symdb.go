@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/parquet-go/parquet-go"
+
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
@@ -78,6 +80,8 @@ type Config struct {
 	Dir         string
 	Stacktraces StacktracesConfig
 	Parquet     ParquetConfig
+	// InternPool, if set, is shared with other instances.
+	InternPool *InternPool
 }
 
 type StacktracesConfig struct {
@@ -86,6 +90,9 @@ type StacktracesConfig struct {
 
 type ParquetConfig struct {
 	MaxBufferRowCount int
+	// WriterOptions are applied after the default ones,
+	// e.g. to change the page size or the compression.
+	WriterOptions []parquet.WriterOption
 }
 
 type MemoryStats struct {
@@ -131,6 +138,11 @@ func (c *Config) WithParquetConfig(pc ParquetConfig) *Config {
 	return c
 }
 
+func (c *Config) WithInternPool(p *InternPool) *Config {
+	c.InternPool = p
+	return c
+}
+
 func NewSymDB(c *Config) *SymDB {
 	if c == nil {
 		c = DefaultConfig()
@@ -168,6 +180,7 @@ func (s *SymDB) newPartition(partition uint64) *PartitionWriter {
 		stacktraces: newStacktracesPartition(s.config.Stacktraces.MaxNodesPerChunk),
 	}
 	p.strings.init()
+	p.strings.helper = &stringsHelper{pool: s.config.InternPool}
 	p.mappings.init()
 	p.functions.init()
 	p.locations.init()
@@ -203,6 +216,16 @@ func (s *SymDB) MemorySize() uint64 {
 	return m.MemorySize()
 }
 
+// MemoryStats returns the up-to-date memory stats. Unlike MemorySize,
+// which is refreshed periodically, the call walks all the partitions.
+func (s *SymDB) MemoryStats() MemoryStats {
+	s.m.Lock()
+	s.updateStats()
+	m := s.stats
+	s.m.Unlock()
+	return m
+}
+
 var emptyMemoryStats MemoryStats
 
 func (s *SymDB) WriteMemoryStats(m *MemoryStats) {
@@ -245,6 +268,13 @@ func (s *SymDB) updateStats() {
 	}
 }
 
+// Close stops the instance without flushing it. The call
+// is mutually exclusive with Flush.
+func (s *SymDB) Close() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
 func (s *SymDB) Flush() error {
 	close(s.stop)
 	s.wg.Wait()
@@ -258,6 +288,8 @@ func (s *SymDB) Flush() error {
 	sort.Slice(partitions, func(i, j int) bool {
 		return partitions[i].header.Partition < partitions[j].header.Partition
 	})
+	// The instance is not written to after the call, regardless of the outcome.
+	defer s.releaseStrings(partitions)
 	if err := s.writer.createDir(); err != nil {
 		return err
 	}
@@ -267,6 +299,20 @@ func (s *SymDB) Flush() error {
 	return s.writer.Flush()
 }
 
+// releaseStrings releases the strings of the partitions retained in the
+// intern pool. The strings remain valid, and the partitions can be read
+// till the instance is discarded.
+func (s *SymDB) releaseStrings(partitions []*PartitionWriter) {
+	if s.config.InternPool == nil {
+		return
+	}
+	for _, p := range partitions {
+		p.strings.lock.RLock()
+		s.config.InternPool.release(p.strings.slice)
+		p.strings.lock.RUnlock()
+	}
+}
+
 func (s *SymDB) Files() []block.File {
 	return s.writer.files
 }
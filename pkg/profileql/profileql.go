@@ -0,0 +1,263 @@
+// Package profileql implements ProfileQL, a subset of PromQL evaluated over
+// the sample totals of profiles.
+//
+// The metric name of a selector is the profile type ID, the profile type
+// can alternatively be selected with the __profile_type__ label:
+//
+//	process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="api"}
+//	{__profile_type__="memory:alloc_space:bytes:space:bytes"}
+//
+// A selector evaluates to a single series, the sum of the matching
+// profiles. The supported functions and aggregations are:
+//
+//	rate(<selector>[<range>])   per-second rate of the sample totals.
+//	sum [by (<labels>)] (<expr>) sum of the series, grouped by the labels.
+//	topk(<k>, <expr>)           the k series with the largest sum of values.
+package profileql
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+// SeriesSelector is the API the expressions are evaluated against.
+type SeriesSelector interface {
+	SelectSeries(context.Context, *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error)
+}
+
+type evaluator struct {
+	selector SeriesSelector
+	start    int64
+	end      int64
+	step     float64
+}
+
+// QueryRange evaluates the ProfileQL expression of the request.
+func QueryRange(ctx context.Context, s SeriesSelector, req *querierv1.QueryRangeRequest) (*querierv1.QueryRangeResponse, error) {
+	expr, err := parser.ParseExpr(req.Query)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if req.Step <= 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("step must be positive"))
+	}
+	if req.Start > req.End {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start must be before end"))
+	}
+	e := &evaluator{selector: s, start: req.Start, end: req.End, step: req.Step}
+	series, err := e.eval(ctx, expr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &querierv1.QueryRangeResponse{Series: series}, nil
+}
+
+func unsupported(format string, args ...interface{}) error {
+	return connect.NewError(connect.CodeInvalidArgument, errors.Errorf(format, args...))
+}
+
+// eval evaluates the expression. by are the labels the enclosing
+// aggregations group the series by.
+func (e *evaluator) eval(ctx context.Context, expr parser.Expr, by []string) ([]*typesv1.Series, error) {
+	switch n := expr.(type) {
+	case *parser.ParenExpr:
+		return e.eval(ctx, n.Expr, by)
+
+	case *parser.VectorSelector:
+		return e.selectSeries(ctx, n, by)
+
+	case *parser.Call:
+		if n.Func.Name != "rate" {
+			return nil, unsupported("unsupported function %s", n.Func.Name)
+		}
+		m, ok := n.Args[0].(*parser.MatrixSelector)
+		if !ok {
+			return nil, unsupported("rate expects a range selector")
+		}
+		vs, ok := m.VectorSelector.(*parser.VectorSelector)
+		if !ok {
+			return nil, unsupported("rate expects a range selector")
+		}
+		series, err := e.selectSeries(ctx, vs, by)
+		if err != nil {
+			return nil, err
+		}
+		return e.rate(series, m.Range), nil
+
+	case *parser.AggregateExpr:
+		if n.Without {
+			return nil, unsupported("%s without is not supported", n.Op)
+		}
+		switch n.Op {
+		case parser.SUM:
+			series, err := e.eval(ctx, n.Expr, n.Grouping)
+			if err != nil {
+				return nil, err
+			}
+			return sumBy(series, n.Grouping), nil
+		case parser.TOPK:
+			if len(n.Grouping) > 0 {
+				return nil, unsupported("topk by is not supported")
+			}
+			k, ok := n.Param.(*parser.NumberLiteral)
+			if !ok || k.Val < 1 {
+				return nil, unsupported("topk expects a positive number")
+			}
+			series, err := e.eval(ctx, n.Expr, by)
+			if err != nil {
+				return nil, err
+			}
+			return topK(series, int(k.Val)), nil
+		}
+		return nil, unsupported("unsupported aggregation %s", n.Op)
+	}
+	return nil, unsupported("unsupported expression %s", expr)
+}
+
+func (e *evaluator) selectSeries(ctx context.Context, vs *parser.VectorSelector, by []string) ([]*typesv1.Series, error) {
+	if vs.OriginalOffset != 0 || vs.Timestamp != nil || vs.StartOrEnd != 0 {
+		return nil, unsupported("offset and @ modifiers are not supported")
+	}
+	var profileType string
+	matchers := make([]string, 0, len(vs.LabelMatchers))
+	for _, m := range vs.LabelMatchers {
+		switch m.Name {
+		case labels.MetricName, phlaremodel.LabelNameProfileType:
+			if m.Type != labels.MatchEqual {
+				return nil, unsupported("the profile type must be matched exactly")
+			}
+			if profileType != "" && profileType != m.Value {
+				return nil, unsupported("conflicting profile types %s and %s", profileType, m.Value)
+			}
+			profileType = m.Value
+		default:
+			matchers = append(matchers, m.String())
+		}
+	}
+	if profileType == "" {
+		return nil, unsupported("the profile type of the selector is missing")
+	}
+	resp, err := e.selector.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: profileType,
+		LabelSelector: "{" + strings.Join(matchers, ",") + "}",
+		Start:         e.start,
+		End:           e.end,
+		GroupBy:       by,
+		Step:          e.step,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg.Series, nil
+}
+
+// rate returns the per-second rate of the series over the window ending
+// at each step. The window is at least one step wide, as a point is the
+// sum of the profiles of its step.
+func (e *evaluator) rate(series []*typesv1.Series, window time.Duration) []*typesv1.Series {
+	stepMs := int64(e.step * 1000)
+	windowMs := window.Milliseconds()
+	if windowMs < stepMs {
+		windowMs = stepMs
+	}
+	seconds := float64(windowMs) / 1000
+	res := make([]*typesv1.Series, 0, len(series))
+	for _, s := range series {
+		points := make([]*typesv1.Point, 0, len(s.Points))
+		var (
+			sum   float64
+			first int // First point of the window.
+			next  int // First point after the window.
+		)
+		for ts := e.start; ts <= e.end; ts += stepMs {
+			for next < len(s.Points) && s.Points[next].Timestamp <= ts {
+				sum += s.Points[next].Value
+				next++
+			}
+			for first < next && s.Points[first].Timestamp <= ts-windowMs {
+				sum -= s.Points[first].Value
+				first++
+			}
+			if first < next {
+				points = append(points, &typesv1.Point{Timestamp: ts, Value: sum / seconds})
+			}
+		}
+		res = append(res, &typesv1.Series{Labels: s.Labels, Points: points})
+	}
+	return res
+}
+
+// sumBy sums the series with the same values of the labels.
+func sumBy(series []*typesv1.Series, by []string) []*typesv1.Series {
+	groups := make(map[uint64]map[int64]float64)
+	groupLabels := make(map[uint64][]*typesv1.LabelPair)
+	for _, s := range series {
+		ls := make(phlaremodel.Labels, 0, len(by))
+		for _, l := range s.Labels {
+			for _, name := range by {
+				if l.Name == name {
+					ls = append(ls, l)
+					break
+				}
+			}
+		}
+		sort.Sort(ls)
+		h := ls.Hash()
+		points, ok := groups[h]
+		if !ok {
+			points = make(map[int64]float64)
+			groups[h] = points
+			groupLabels[h] = ls
+		}
+		for _, p := range s.Points {
+			points[p.Timestamp] += p.Value
+		}
+	}
+	res := make([]*typesv1.Series, 0, len(groups))
+	for h, points := range groups {
+		s := &typesv1.Series{
+			Labels: groupLabels[h],
+			Points: make([]*typesv1.Point, 0, len(points)),
+		}
+		for ts, v := range points {
+			s.Points = append(s.Points, &typesv1.Point{Timestamp: ts, Value: v})
+		}
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Timestamp < s.Points[j].Timestamp })
+		res = append(res, s)
+	}
+	sortSeries(res)
+	return res
+}
+
+// topK returns the k series with the largest sum of values.
+func topK(series []*typesv1.Series, k int) []*typesv1.Series {
+	sums := make(map[*typesv1.Series]float64, len(series))
+	for _, s := range series {
+		for _, p := range s.Points {
+			sums[s] += p.Value
+		}
+	}
+	res := append([]*typesv1.Series(nil), series...)
+	sort.SliceStable(res, func(i, j int) bool { return sums[res[i]] > sums[res[j]] })
+	if len(res) > k {
+		res = res[:k]
+	}
+	return res
+}
+
+func sortSeries(series []*typesv1.Series) {
+	sort.Slice(series, func(i, j int) bool {
+		return phlaremodel.CompareLabelPairs(series[i].Labels, series[j].Labels) < 0
+	})
+}
@@ -0,0 +1,82 @@
+package profileql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+type fakeSelector struct {
+	requests []*querierv1.SelectSeriesRequest
+	series   []*typesv1.Series
+}
+
+func (f *fakeSelector) SelectSeries(_ context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	f.requests = append(f.requests, req.Msg)
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: f.series}), nil
+}
+
+func series(service string, values ...float64) *typesv1.Series {
+	s := &typesv1.Series{Labels: []*typesv1.LabelPair{{Name: "service_name", Value: service}}}
+	for i, v := range values {
+		s.Points = append(s.Points, &typesv1.Point{Timestamp: int64(i) * 1000, Value: v})
+	}
+	return s
+}
+
+func Test_QueryRange(t *testing.T) {
+	f := &fakeSelector{series: []*typesv1.Series{
+		series("a", 1, 2, 3),
+		series("b", 10, 20, 30),
+		series("c", 4, 4, 4),
+	}}
+	res, err := QueryRange(context.Background(), f, &querierv1.QueryRangeRequest{
+		Query: `topk(2, sum by (service_name) (rate(process_cpu:cpu:nanoseconds:cpu:nanoseconds{env="prod"}[2s])))`,
+		Start: 0,
+		End:   2000,
+		Step:  1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*querierv1.SelectSeriesRequest{{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{env="prod"}`,
+		End:           2000,
+		GroupBy:       []string{"service_name"},
+		Step:          1,
+	}}, f.requests)
+	require.Equal(t, []*typesv1.Series{series("b", 5, 15, 25), series("c", 2, 4, 4)}, res.Series)
+}
+
+func Test_QueryRange_Selector(t *testing.T) {
+	f := &fakeSelector{series: []*typesv1.Series{{Points: []*typesv1.Point{{Timestamp: 0, Value: 1}}}}}
+	res, err := QueryRange(context.Background(), f, &querierv1.QueryRangeRequest{
+		Query: `{__profile_type__="memory:alloc_space:bytes:space:bytes", service_name=~"a.*"}`,
+		End:   1000,
+		Step:  1,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Series, 1)
+	require.Equal(t, "memory:alloc_space:bytes:space:bytes", f.requests[0].ProfileTypeID)
+	require.Equal(t, `{service_name=~"a.*"}`, f.requests[0].LabelSelector)
+	require.Empty(t, f.requests[0].GroupBy)
+}
+
+func Test_QueryRange_Unsupported(t *testing.T) {
+	for _, q := range []string{
+		`{service_name="a"}`,
+		`avg(process_cpu:cpu:nanoseconds:cpu:nanoseconds)`,
+		`sum without (env) (process_cpu:cpu:nanoseconds:cpu:nanoseconds)`,
+		`irate(process_cpu:cpu:nanoseconds:cpu:nanoseconds[1m])`,
+		`process_cpu:cpu:nanoseconds:cpu:nanoseconds offset 5m`,
+		`process_cpu:cpu:nanoseconds:cpu:nanoseconds * 2`,
+		`sum(`,
+	} {
+		_, err := QueryRange(context.Background(), new(fakeSelector), &querierv1.QueryRangeRequest{Query: q, End: 1000, Step: 1})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), q)
+	}
+}
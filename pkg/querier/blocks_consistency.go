@@ -0,0 +1,188 @@
+package querier
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+)
+
+const (
+	// bucketIndexTTL is how long the bucket index of a tenant is reused
+	// before being read again.
+	bucketIndexTTL = time.Minute
+	// maxMissingBlocksReported is the number of missing blocks listed in the
+	// error of a failed consistency check.
+	maxMissingBlocksReported = 10
+)
+
+// BlocksConsistencyCheckConfig configures the check that the store-gateways
+// queried all the blocks of the time range of a query.
+type BlocksConsistencyCheckConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	UploadGracePeriod time.Duration `yaml:"upload_grace_period" category:"advanced"`
+}
+
+func (cfg *BlocksConsistencyCheckConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "If enabled, queriers check that the store-gateways queried all the blocks of the time range of a stacktraces query, according to the bucket index. If some blocks were not queried, the query fails, unless it allows partial results: then the result is marked as partial.")
+	f.DurationVar(&cfg.UploadGracePeriod, prefix+"upload-grace-period", time.Hour, "Blocks uploaded within this period are not expected to be queried, because the store-gateways may not have discovered them yet. It should be greater than -blocks-storage.bucket-store.sync-interval.")
+}
+
+// blocksConsistencyChecker compares the blocks queried by the store-gateways
+// with the blocks of the bucket index of the tenant.
+type blocksConsistencyChecker struct {
+	cfg    BlocksConsistencyCheckConfig
+	bucket phlareobj.Bucket
+	logger log.Logger
+
+	mtx     sync.Mutex
+	indexes map[string]*cachedBucketIndex
+
+	checks   prometheus.Counter
+	failures prometheus.Counter
+}
+
+type cachedBucketIndex struct {
+	index   *bucketindex.Index
+	fetched time.Time
+}
+
+func newBlocksConsistencyChecker(cfg BlocksConsistencyCheckConfig, bucket phlareobj.Bucket, logger log.Logger, reg prometheus.Registerer) *blocksConsistencyChecker {
+	return &blocksConsistencyChecker{
+		cfg:     cfg,
+		bucket:  bucket,
+		logger:  logger,
+		indexes: make(map[string]*cachedBucketIndex),
+		checks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_querier_blocks_consistency_checks_total",
+			Help: "Total number of checks that the store-gateways queried all the blocks of a query.",
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_querier_blocks_consistency_checks_failed_total",
+			Help: "Total number of checks that found blocks not queried by any store-gateway.",
+		}),
+	}
+}
+
+// check verifies that the blocks of the bucket index within the time range
+// were queried. Missing blocks fail the query, unless partial results are
+// allowed. The check is skipped if the bucket index can't be read: the query
+// is not failed because of the check itself.
+func (c *blocksConsistencyChecker) check(ctx context.Context, tenantID string, start, end model.Time, queried *queriedBlocks, allowPartialResults bool) error {
+	idx, err := c.index(ctx, tenantID)
+	if err != nil {
+		if !errors.Is(err, bucketindex.ErrIndexNotFound) {
+			level.Warn(c.logger).Log("msg", "failed to read bucket index, skipping blocks consistency check", "tenant", tenantID, "err", err)
+		}
+		return nil
+	}
+	c.checks.Inc()
+	missing := c.missingBlocks(idx, start, end, queried, time.Now())
+	if len(missing) == 0 {
+		return nil
+	}
+	c.failures.Inc()
+	level.Warn(c.logger).Log("msg", "blocks not queried by any store-gateway", "tenant", tenantID, "missing", strings.Join(missing, ","))
+	if allowPartialResults {
+		markPartialResults(ctx)
+		return nil
+	}
+	n := len(missing)
+	if n > maxMissingBlocksReported {
+		missing = append(missing[:maxMissingBlocksReported], "...")
+	}
+	return connect.NewError(connect.CodeUnavailable, fmt.Errorf(
+		"%d blocks of the time range were not queried by any store-gateway: %s", n, strings.Join(missing, ", ")))
+}
+
+// missingBlocks returns the IDs of the blocks of the index expected to be
+// queried, but not found in the queried blocks. Blocks marked for deletion,
+// or uploaded within the grace period, are not expected.
+func (c *blocksConsistencyChecker) missingBlocks(idx *bucketindex.Index, start, end model.Time, queried *queriedBlocks, now time.Time) []string {
+	deleted := make(map[string]struct{}, len(idx.BlockDeletionMarks))
+	for _, m := range idx.BlockDeletionMarks {
+		deleted[m.ID.String()] = struct{}{}
+	}
+	var missing []string
+	for _, b := range idx.Blocks {
+		if !b.Within(start, end) || now.Sub(b.GetUploadedAt()) < c.cfg.UploadGracePeriod {
+			continue
+		}
+		id := b.ID.String()
+		if _, ok := deleted[id]; ok {
+			continue
+		}
+		if !queried.contains(id) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// index returns the bucket index of the tenant, read again once it's older
+// than bucketIndexTTL.
+func (c *blocksConsistencyChecker) index(ctx context.Context, tenantID string) (*bucketindex.Index, error) {
+	c.mtx.Lock()
+	cached, ok := c.indexes[tenantID]
+	c.mtx.Unlock()
+	if ok && time.Since(cached.fetched) < bucketIndexTTL {
+		return cached.index, nil
+	}
+	// The bucket index is in the directory of the blocks of the tenant, as
+	// read by the store-gateways.
+	idx, err := bucketindex.ReadIndex(ctx, phlareobj.NewPrefixedBucket(c.bucket, tenantID+"/phlaredb"), "", nil, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	c.indexes[tenantID] = &cachedBucketIndex{index: idx, fetched: time.Now()}
+	c.mtx.Unlock()
+	return idx, nil
+}
+
+type queriedBlocksKey struct{}
+
+// queriedBlocks records the blocks queried by the store-gateways.
+type queriedBlocks struct {
+	mtx sync.Mutex
+	ids map[string]struct{}
+}
+
+// contextWithQueriedBlocks returns a context recording the blocks queried
+// in the merge results.
+func contextWithQueriedBlocks(ctx context.Context) (context.Context, *queriedBlocks) {
+	queried := &queriedBlocks{ids: make(map[string]struct{})}
+	return context.WithValue(ctx, queriedBlocksKey{}, queried), queried
+}
+
+func addQueriedBlocks(ctx context.Context, ids []string) {
+	queried, ok := ctx.Value(queriedBlocksKey{}).(*queriedBlocks)
+	if !ok || len(ids) == 0 {
+		return
+	}
+	queried.mtx.Lock()
+	defer queried.mtx.Unlock()
+	for _, id := range ids {
+		queried.ids[id] = struct{}{}
+	}
+}
+
+func (q *queriedBlocks) contains(id string) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	_, ok := q.ids[id]
+	return ok
+}
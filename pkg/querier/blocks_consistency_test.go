@@ -0,0 +1,64 @@
+package querier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+)
+
+func Test_BlocksConsistencyChecker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	var (
+		queriedID  = ulid.MustNew(1, nil)
+		missingID  = ulid.MustNew(2, nil)
+		recentID   = ulid.MustNew(3, nil)
+		deletedID  = ulid.MustNew(4, nil)
+		outOfRange = ulid.MustNew(5, nil)
+	)
+	uploaded := now.Add(-2 * time.Hour).Unix()
+	idx := &bucketindex.Index{
+		Version: bucketindex.IndexVersion2,
+		Blocks: bucketindex.Blocks{
+			{ID: queriedID, MinTime: 0, MaxTime: 100, UploadedAt: uploaded},
+			{ID: missingID, MinTime: 100, MaxTime: 200, UploadedAt: uploaded},
+			{ID: recentID, MinTime: 100, MaxTime: 200, UploadedAt: now.Unix()},
+			{ID: deletedID, MinTime: 100, MaxTime: 200, UploadedAt: uploaded},
+			{ID: outOfRange, MinTime: 300, MaxTime: 400, UploadedAt: uploaded},
+		},
+		BlockDeletionMarks: bucketindex.BlockDeletionMarks{{ID: deletedID}},
+		UpdatedAt:          now.Unix(),
+	}
+	bucket := phlareobj.NewBucket(objstore.NewInMemBucket())
+	require.NoError(t, bucketindex.WriteIndex(ctx, phlareobj.NewPrefixedBucket(bucket, "tenant/phlaredb"), "", nil, idx))
+
+	c := newBlocksConsistencyChecker(BlocksConsistencyCheckConfig{Enabled: true, UploadGracePeriod: time.Hour}, bucket, log.NewNopLogger(), prometheus.NewRegistry())
+	ctx, queried := contextWithQueriedBlocks(ctx)
+	addQueriedBlocks(ctx, []string{queriedID.String()})
+
+	err := c.check(ctx, "tenant", 0, 250, queried, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), missingID.String())
+	require.NotContains(t, err.Error(), recentID.String())
+	require.NotContains(t, err.Error(), deletedID.String())
+
+	// With partial results allowed, the result is marked as partial.
+	ctx, partial := contextWithPartialResults(ctx)
+	require.NoError(t, c.check(ctx, "tenant", 0, 250, queried, true))
+	require.True(t, partial.Load())
+
+	addQueriedBlocks(ctx, []string{missingID.String()})
+	require.NoError(t, c.check(ctx, "tenant", 0, 250, queried, false))
+
+	// The check is skipped for the tenants without a bucket index.
+	require.NoError(t, c.check(ctx, "other", 0, 250, queried, false))
+}
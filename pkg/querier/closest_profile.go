@@ -0,0 +1,155 @@
+package querier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/pprof/profile"
+	"github.com/grafana/dskit/multierror"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/common/model"
+
+	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+)
+
+// selectClosestProfile returns the profile closest to the requested
+// timestamp, as it was ingested. It takes two passes: the first one streams
+// the timestamps and labels of the matching profiles, without merging any of
+// them, to find the closest one. The second one selects that profile only,
+// by its timestamp and series labels.
+func (q *Querier) selectClosestProfile(ctx context.Context, profileType *typesv1.ProfileType, req *querierv1.SelectMergeProfileRequest) (*googlev1.Profile, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "selectClosestProfile")
+	defer sp.Finish()
+
+	closest, err := q.findClosestProfile(ctx, profileType, req)
+	if err != nil {
+		return nil, err
+	}
+	if closest == nil {
+		empty := &profile.Profile{}
+		phlaremodel.SetProfileMetadata(empty, profileType)
+		return pprof.FromProfile(empty)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses, err := q.mergeProfilesPprof(ctx, &ingestv1.SelectProfilesRequest{
+		LabelSelector: req.LabelSelector,
+		Start:         closest.Timestamp,
+		End:           closest.Timestamp,
+		Type:          profileType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := selectMergePprofProfile(ctx, profileType, responses, func(_ context.Context, its []MergeIterator) error {
+		return keepProfile(its, closest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.TimeNanos = model.Time(closest.Timestamp).UnixNano()
+	// The series labels are not part of the profile, they are kept as a comment.
+	p.Comment = append(p.Comment, int64(len(p.StringTable)))
+	p.StringTable = append(p.StringTable, closest.Labels.ToPrometheusLabels().String())
+	return p, nil
+}
+
+// findClosestProfile returns the timestamp and labels of the profile closest
+// to the requested timestamp, or nil if no profile matches the request.
+func (q *Querier) findClosestProfile(ctx context.Context, profileType *typesv1.ProfileType, req *querierv1.SelectMergeProfileRequest) (*ProfileWithLabels, error) {
+	// The streams are cancelled once the closest profile is found: the
+	// ingesters never merge the profiles.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses, err := q.mergeProfilesPprof(ctx, &ingestv1.SelectProfilesRequest{
+		LabelSelector: req.LabelSelector,
+		Start:         req.Start,
+		End:           req.End,
+		Type:          profileType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	iters := make([]MergeIterator, len(responses))
+	var wg sync.WaitGroup
+	for i, resp := range responses {
+		wg.Add(1)
+		go func(i int, resp ResponseFromReplica[BidiClientMerge[*ingestv1.MergeProfilesPprofRequest, *ingestv1.MergeProfilesPprofResponse]]) {
+			defer wg.Done()
+			iters[i] = NewMergeIterator[[]byte](ctx, resp)
+		}(i, ResponseFromReplica[BidiClientMerge[*ingestv1.MergeProfilesPprofRequest, *ingestv1.MergeProfilesPprofResponse]]{
+			addr:     resp.addr,
+			response: resp.response,
+		})
+	}
+	wg.Wait()
+	return closestProfile(iters, req.ClosestTo)
+}
+
+// closestProfile iterates through the profiles and returns the one closest to
+// the timestamp. Of two profiles equally close, the earlier one is returned.
+func closestProfile(its []MergeIterator, ts int64) (*ProfileWithLabels, error) {
+	var errors multierror.MultiError
+	tree := newProfilesTree(its, &errors)
+	defer tree.Close()
+
+	var closest *ProfileWithLabels
+	for tree.Next() {
+		p := tree.Winner().At()
+		if closest != nil && distance(p.Timestamp, ts) >= distance(closest.Timestamp, ts) {
+			if p.Timestamp > ts {
+				// The profiles are ordered by timestamp: the rest are further.
+				break
+			}
+			continue
+		}
+		closest = &ProfileWithLabels{
+			Timestamp:    p.Timestamp,
+			Labels:       p.Labels,
+			IngesterAddr: p.IngesterAddr,
+		}
+	}
+	if err := tree.Err(); err != nil {
+		errors.Add(err)
+	}
+	if err := errors.Err(); err != nil {
+		return nil, err
+	}
+	return closest, nil
+}
+
+// keepProfile keeps a single profile with the timestamp and labels of p: the
+// replicas of the profile are skipped, as well as the profiles of the other
+// series.
+func keepProfile(its []MergeIterator, p *ProfileWithLabels) error {
+	var errors multierror.MultiError
+	tree := newProfilesTree(its, &errors)
+	defer tree.Close()
+
+	var kept bool
+	for tree.Next() {
+		next := tree.Winner()
+		profile := next.At()
+		if !kept && profile.Timestamp == p.Timestamp && phlaremodel.CompareLabelPairs(profile.Labels, p.Labels) == 0 {
+			next.Keep()
+			kept = true
+		}
+	}
+	if err := tree.Err(); err != nil {
+		errors.Add(err)
+	}
+	return errors.Err()
+}
+
+func distance(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
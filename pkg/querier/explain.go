@@ -0,0 +1,57 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+// explainSelectMergeStacktraces returns the plan of the query without
+// executing it: the data nodes the query is sent to, their time ranges,
+// and the budget each of them enforces.
+func (q *Querier) explainSelectMergeStacktraces(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "querier: merge %s stacktraces of profiles matching %s\n", req.ProfileTypeID, req.LabelSelector)
+	if len(req.MergeProfileTypes) > 0 {
+		fmt.Fprintf(&b, "  merged profile types: %s\n", strings.Join(req.MergeProfileTypes, ", "))
+	}
+
+	start, end := model.Time(req.Start), model.Time(req.End)
+	if q.storeGatewayQuerier == nil {
+		fmt.Fprintf(&b, "  ingesters: %s\n", formatTimeRange(start, end))
+	} else {
+		queries := splitQueryToStores(start, end, model.Now(), q.cfg.QueryStoreAfter)
+		if queries.storeGateway.shouldQuery {
+			fmt.Fprintf(&b, "  store-gateways: %s\n", formatTimeRange(queries.storeGateway.start, queries.storeGateway.end))
+		}
+		if queries.ingester.shouldQuery {
+			fmt.Fprintf(&b, "  ingesters: %s\n", formatTimeRange(queries.ingester.start, queries.ingester.end))
+		}
+		if !queries.storeGateway.shouldQuery && !queries.ingester.shouldQuery {
+			b.WriteString("  no data nodes: the time range is outside of the ingester and store-gateway retention\n")
+		}
+	}
+
+	if r := q.mergeStacktracesRequest(ctx, req, nil); r.MaxBlocks > 0 || r.MaxBytes > 0 {
+		fmt.Fprintf(&b, "  budget per data node: %d blocks, %d bytes (0 is unlimited), partial results allowed: %t\n",
+			r.MaxBlocks, r.MaxBytes, req.AllowPartialResults)
+	}
+	if req.ExcludeStacks != "" {
+		fmt.Fprintf(&b, "  excluded stacks: %q, dropped by the data nodes\n", req.ExcludeStacks)
+	}
+	if req.GetFocusFunction() != "" || req.GetHideFunctions() != "" || req.GetMaxDepth() > 0 {
+		fmt.Fprintf(&b, "  stacks: focus function %q, hide functions %q, max depth %d\n",
+			req.GetFocusFunction(), req.GetHideFunctions(), req.GetMaxDepth())
+	}
+	fmt.Fprintf(&b, "  max nodes: %d\n", req.GetMaxNodes())
+	return b.String()
+}
+
+func formatTimeRange(start, end model.Time) string {
+	return start.Time().UTC().Format(time.RFC3339) + " to " + end.Time().UTC().Format(time.RFC3339)
+}
@@ -1,16 +1,36 @@
 package querier
 
 import (
+	"context"
+
 	"github.com/bufbuild/connect-go"
 
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 )
 
 func NewGRPCRoundTripper(transport connectgrpc.GRPCRoundTripper) querierv1connect.QuerierServiceHandler {
-	return querierv1connect.NewQuerierServiceClient(
-		connectgrpc.NewClient(transport),
-		"http://httpgrpc",
-		connect.WithGRPCWeb(),
-	)
+	return &grpcRoundTripper{
+		QuerierServiceClient: querierv1connect.NewQuerierServiceClient(
+			connectgrpc.NewClient(transport),
+			"http://httpgrpc",
+			connect.WithGRPCWeb(),
+		),
+	}
+}
+
+type grpcRoundTripper struct {
+	querierv1connect.QuerierServiceClient
+}
+
+// SelectMergeStacktracesStream round-trips the unary SelectMergeStacktraces:
+// streams can't be round-tripped.
+func (r *grpcRoundTripper) SelectMergeStacktracesStream(ctx context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest], stream *connect.ServerStream[querierv1.SelectMergeStacktracesResponse]) error {
+	res, err := r.SelectMergeStacktraces(ctx, req)
+	if err != nil {
+		return err
+	}
+	return connectgrpc.SendServerStream(stream, res.Header(), phlaremodel.SplitSelectMergeStacktracesResponse(res.Msg, phlaremodel.FlameGraphChunkNodes))
 }
@@ -26,12 +26,12 @@ import (
 	httputil "github.com/grafana/pyroscope/pkg/util/http"
 )
 
-func NewHTTPHandlers(client querierv1connect.QuerierServiceClient) *QueryHandlers {
+func NewHTTPHandlers(client querierv1connect.QuerierServiceHandler) *QueryHandlers {
 	return &QueryHandlers{client}
 }
 
 type QueryHandlers struct {
-	client querierv1connect.QuerierServiceClient
+	client querierv1connect.QuerierServiceHandler
 }
 
 // LabelValues only returns the label values for the given label name.
@@ -234,6 +234,17 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 	}
 	p.MaxNodes = &mn
 
+	if focus := v.Get("focus"); focus != "" {
+		p.FocusFunction = &focus
+	}
+	if hide := v.Get("hide"); hide != "" {
+		p.HideFunctions = &hide
+	}
+	if v, err := strconv.Atoi(v.Get("maxDepth")); err == nil && v != 0 {
+		md := int64(v)
+		p.MaxDepth = &md
+	}
+
 	return p, ptype, nil
 }
 
@@ -2,6 +2,7 @@ package querier
 
 import (
 	"context"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/grafana/dskit/ring"
@@ -16,6 +17,7 @@ import (
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
 )
 
@@ -24,22 +26,53 @@ type IngesterQueryClient interface {
 	LabelNames(context.Context, *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error)
 	ProfileTypes(context.Context, *connect.Request[ingestv1.ProfileTypesRequest]) (*connect.Response[ingestv1.ProfileTypesResponse], error)
 	Series(ctx context.Context, req *connect.Request[ingestv1.SeriesRequest]) (*connect.Response[ingestv1.SeriesResponse], error)
+	MergeSpanProfile(context.Context, *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error)
 	MergeProfilesStacktraces(context.Context) clientpool.BidiClientMergeProfilesStacktraces
 	MergeProfilesLabels(ctx context.Context) clientpool.BidiClientMergeProfilesLabels
 	MergeProfilesPprof(ctx context.Context) clientpool.BidiClientMergeProfilesPprof
+	MergeBlocksStacktraces(context.Context) clientpool.BidiClientMergeProfilesStacktraces
+}
+
+type IngesterLimits interface {
+	IngestionTenantShardSize(tenantID string) int
 }
 
 // IngesterQuerier helps with querying the ingesters.
 type IngesterQuerier struct {
 	ring ring.ReadRing
 	pool *ring_client.Pool
+
+	limits        IngesterLimits
+	lookback      time.Duration
+	preferredZone string
 }
 
-func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing) *IngesterQuerier {
+func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing, limits IngesterLimits, lookback time.Duration, preferredZone string) *IngesterQuerier {
 	return &IngesterQuerier{
-		ring: ring,
-		pool: pool,
+		ring:          ring,
+		pool:          pool,
+		limits:        limits,
+		lookback:      lookback,
+		preferredZone: preferredZone,
+	}
+}
+
+// tenantRing returns the ingesters which may have received series of the
+// tenant within the lookback period. All the ingesters are returned, if
+// shuffle sharding is not enabled for the tenant.
+func (q *IngesterQuerier) tenantRing(ctx context.Context) ring.ReadRing {
+	if q.limits == nil || q.lookback <= 0 {
+		return q.ring
+	}
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return q.ring
 	}
+	shardSize := q.limits.IngestionTenantShardSize(tenantID)
+	if shardSize <= 0 {
+		return q.ring
+	}
+	return q.ring.ShuffleShardWithLookback(tenantID, shardSize, q.lookback, time.Now())
 }
 
 // readNoExtend is a ring.Operation that only selects instances marked as ring.ACTIVE.
@@ -48,7 +81,7 @@ var readNoExtend = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
 
 // forAllIngesters runs f, in parallel, for all ingesters
 func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerier, f QueryReplicaFn[T, IngesterQueryClient]) ([]ResponseFromReplica[T], error) {
-	replicationSet, err := ingesterQuerier.ring.GetReplicationSetForOperation(readNoExtend)
+	replicationSet, err := ingesterQuerier.tenantRing(ctx).GetReplicationSetForOperation(readNoExtend)
 	if err != nil {
 		return nil, err
 	}
@@ -58,12 +91,29 @@ func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerie
 			return nil, err
 		}
 		return client.(IngesterQueryClient), nil
-	}, replicationSet, f)
+	}, replicationSet, ingesterQuerier.preferredZone, f)
 }
 
 func (q *Querier) selectTreeFromIngesters(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectTree Ingesters")
 	defer sp.Finish()
+	return q.mergeTreeFromIngesters(ctx, req, func(ctx context.Context, ic IngesterQueryClient) (clientpool.BidiClientMergeProfilesStacktraces, error) {
+		return ic.MergeProfilesStacktraces(ctx), nil
+	})
+}
+
+// selectTreeFromIngesterBlocks merges the stacktraces of the blocks cut by
+// the ingesters that are not uploaded yet, which the store-gateways don't
+// have.
+func (q *Querier) selectTreeFromIngesterBlocks(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectTree Ingester Blocks")
+	defer sp.Finish()
+	return q.mergeTreeFromIngesters(ctx, req, func(ctx context.Context, ic IngesterQueryClient) (clientpool.BidiClientMergeProfilesStacktraces, error) {
+		return ic.MergeBlocksStacktraces(ctx), nil
+	})
+}
+
+func (q *Querier) mergeTreeFromIngesters(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest, f QueryReplicaFn[clientpool.BidiClientMergeProfilesStacktraces, IngesterQueryClient]) (*phlaremodel.Tree, error) {
 	profileType, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
@@ -75,27 +125,17 @@ func (q *Querier) selectTreeFromIngesters(ctx context.Context, req *querierv1.Se
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(ctx context.Context, ic IngesterQueryClient) (clientpool.BidiClientMergeProfilesStacktraces, error) {
-		return ic.MergeProfilesStacktraces(ctx), nil
-	})
+	responses, err := forAllIngesters(ctx, q.ingesterQuerier, f)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 	// send the first initial request to all ingesters.
+	mergeReq := q.mergeStacktracesRequest(ctx, req, profileType)
 	g, gCtx := errgroup.WithContext(ctx)
 	for _, r := range responses {
 		r := r
 		g.Go(util.RecoverPanic(func() error {
-			return r.response.Send(&ingestv1.MergeProfilesStacktracesRequest{
-				Request: &ingestv1.SelectProfilesRequest{
-					LabelSelector: req.LabelSelector,
-					Start:         req.Start,
-					End:           req.End,
-					Type:          profileType,
-				},
-				MaxNodes: req.MaxNodes,
-				// TODO(kolesnikovae): Max stacks.
-			})
+			return r.response.Send(mergeReq.CloneVT())
 		}))
 	}
 	if err = g.Wait(); err != nil {
@@ -139,6 +179,8 @@ func (q *Querier) seriesFromIngesters(ctx context.Context, req *ingesterv1.Serie
 			LabelNames: req.LabelNames,
 			Start:      req.Start,
 			End:        req.End,
+			Limit:      req.Limit,
+			PageToken:  req.PageToken,
 		}))
 		if err != nil {
 			return nil, err
@@ -0,0 +1,227 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/tenant"
+	"github.com/pkg/errors"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+const (
+	defaultLabelJoinRefreshInterval = 5 * time.Minute
+	labelJoinFetchTimeout           = time.Minute
+	// maxLabelJoinMappingSize is the maximum size of a mapping, in bytes.
+	maxLabelJoinMappingSize = 64 << 20
+)
+
+// labelJoiner fetches and caches the mappings of the labels joined at query
+// time. A mapping is refetched once its refresh interval has elapsed; if
+// that fails, the previous mapping keeps being used.
+type labelJoiner struct {
+	bucket phlareobj.Bucket
+	client *http.Client
+	logger log.Logger
+
+	mu       sync.Mutex
+	mappings map[labelMappingKey]*labelMapping
+}
+
+type labelMappingKey struct {
+	tenantID   string
+	url        string
+	bucketPath string
+}
+
+type labelMapping struct {
+	mu      sync.Mutex
+	values  map[string]string
+	fetched time.Time
+}
+
+func newLabelJoiner(bucket phlareobj.Bucket, logger log.Logger) *labelJoiner {
+	return &labelJoiner{
+		bucket:   bucket,
+		client:   &http.Client{Timeout: labelJoinFetchTimeout},
+		logger:   logger,
+		mappings: make(map[labelMappingKey]*labelMapping),
+	}
+}
+
+// mapping returns the mapping of the source label values to the target label
+// values of the join.
+func (j *labelJoiner) mapping(ctx context.Context, tenantID string, join *validation.LabelJoin) (map[string]string, error) {
+	key := labelMappingKey{tenantID: tenantID, url: join.URL, bucketPath: join.BucketPath}
+	j.mu.Lock()
+	m, ok := j.mappings[key]
+	if !ok {
+		m = new(labelMapping)
+		j.mappings[key] = m
+	}
+	j.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refreshInterval := time.Duration(join.RefreshInterval)
+	if refreshInterval <= 0 {
+		refreshInterval = defaultLabelJoinRefreshInterval
+	}
+	if m.values != nil && time.Since(m.fetched) < refreshInterval {
+		return m.values, nil
+	}
+	values, err := j.fetch(ctx, tenantID, join)
+	if err != nil {
+		if m.values != nil {
+			level.Warn(j.logger).Log("msg", "failed to refresh label join mapping, using the previous one",
+				"tenant", tenantID, "label", join.TargetLabel, "err", err)
+			return m.values, nil
+		}
+		return nil, errors.Wrapf(err, "fetching the mapping of joined label %q", join.TargetLabel)
+	}
+	m.values, m.fetched = values, time.Now()
+	return m.values, nil
+}
+
+func (j *labelJoiner) fetch(ctx context.Context, tenantID string, join *validation.LabelJoin) (map[string]string, error) {
+	var r io.ReadCloser
+	if join.URL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, join.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := j.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode/100 != 2 {
+			res.Body.Close()
+			return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+		}
+		r = res.Body
+	} else {
+		if j.bucket == nil {
+			return nil, errors.New("no storage bucket is configured")
+		}
+		var err error
+		if r, err = phlareobj.NewPrefixedBucket(j.bucket, tenantID).Get(ctx, join.BucketPath); err != nil {
+			return nil, err
+		}
+	}
+	defer r.Close()
+	var values map[string]string
+	if err := json.NewDecoder(io.LimitReader(r, maxLabelJoinMappingSize)).Decode(&values); err != nil {
+		return nil, errors.Wrap(err, "decoding the mapping")
+	}
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return values, nil
+}
+
+// joinedLabel is a label joined in the results of a query.
+type joinedLabel struct {
+	join   *validation.LabelJoin
+	values map[string]string
+	// keepSource is set if the source label is grouped by as well.
+	keepSource bool
+}
+
+// joinedLabelNames returns the names of the labels joined at query time.
+func (q *Querier) joinedLabelNames(ctx context.Context) []string {
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil || q.limits == nil {
+		return nil
+	}
+	var names []string
+	for _, join := range q.limits.QueryLabelJoins(tenantID) {
+		names = append(names, join.TargetLabel)
+	}
+	return names
+}
+
+// groupByJoinedLabels returns the labels to group by on the data nodes, with
+// the joined labels replaced by their source label, and the joins to apply
+// to the results.
+func (q *Querier) groupByJoinedLabels(ctx context.Context, groupBy []string) ([]string, []joinedLabel, error) {
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil || q.limits == nil {
+		return groupBy, nil, nil
+	}
+	joins := q.limits.QueryLabelJoins(tenantID)
+	if len(joins) == 0 {
+		return groupBy, nil, nil
+	}
+	byTarget := make(map[string]*validation.LabelJoin, len(joins))
+	for _, join := range joins {
+		byTarget[join.TargetLabel] = join
+	}
+	grouped := make(map[string]struct{}, len(groupBy))
+	for _, name := range groupBy {
+		grouped[name] = struct{}{}
+	}
+	var (
+		dataGroupBy = make([]string, 0, len(groupBy))
+		sources     = make(map[string]struct{})
+		joined      []joinedLabel
+	)
+	for _, name := range groupBy {
+		join, ok := byTarget[name]
+		if !ok {
+			dataGroupBy = append(dataGroupBy, name)
+			continue
+		}
+		values, err := q.labelJoiner.mapping(ctx, tenantID, join)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, keepSource := grouped[join.SourceLabel]
+		if _, ok := sources[join.SourceLabel]; !ok && !keepSource {
+			dataGroupBy = append(dataGroupBy, join.SourceLabel)
+			sources[join.SourceLabel] = struct{}{}
+		}
+		joined = append(joined, joinedLabel{join: join, values: values, keepSource: keepSource})
+	}
+	sort.Strings(dataGroupBy)
+	return dataGroupBy, joined, nil
+}
+
+// joinSeriesLabels sets the joined labels of the series, mapped from the
+// values of their source label, and sums the series that end up with the
+// same labels. Series with a source label value missing from the mapping
+// don't have the joined label.
+func joinSeriesLabels(series []*typesv1.Series, joined []joinedLabel) []*typesv1.Series {
+	if len(joined) == 0 {
+		return series
+	}
+	for _, s := range series {
+		ls := phlaremodel.Labels(s.Labels)
+		pairs := make([]*typesv1.LabelPair, 0, len(joined))
+		for _, j := range joined {
+			if value, ok := j.values[ls.Get(j.join.SourceLabel)]; ok {
+				pairs = append(pairs, &typesv1.LabelPair{Name: j.join.TargetLabel, Value: value})
+			}
+		}
+		for _, j := range joined {
+			if !j.keepSource {
+				ls = ls.Delete(j.join.SourceLabel)
+			}
+		}
+		ls = append(ls, pairs...)
+		sort.Sort(ls)
+		s.Labels = ls
+	}
+	return phlaremodel.SumSeries(series)
+}
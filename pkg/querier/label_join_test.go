@@ -0,0 +1,73 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_LabelJoins(t *testing.T) {
+	var unavailable bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"pod-1": "team-a", "pod-2": "team-a", "pod-3": "team-b"}`))
+	}))
+	defer server.Close()
+
+	q := &Querier{
+		labelJoiner: newLabelJoiner(nil, log.NewNopLogger()),
+		limits: validation.MockLimits{QueryLabelJoinsValue: []*validation.LabelJoin{
+			{SourceLabel: "pod", TargetLabel: "team", URL: server.URL},
+		}},
+	}
+	ctx := tenant.InjectTenantID(context.Background(), "user-1")
+	require.Equal(t, []string{"team"}, q.joinedLabelNames(ctx))
+
+	groupBy, joined, err := q.groupByJoinedLabels(ctx, []string{"service_name", "team"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"pod", "service_name"}, groupBy)
+
+	series := []*typesv1.Series{
+		{Labels: phlaremodel.LabelsFromStrings("pod", "pod-1", "service_name", "foo"), Points: []*typesv1.Point{{Timestamp: 1, Value: 1}}},
+		{Labels: phlaremodel.LabelsFromStrings("pod", "pod-2", "service_name", "foo"), Points: []*typesv1.Point{{Timestamp: 1, Value: 2}}},
+		{Labels: phlaremodel.LabelsFromStrings("pod", "pod-3", "service_name", "foo"), Points: []*typesv1.Point{{Timestamp: 1, Value: 4}}},
+		{Labels: phlaremodel.LabelsFromStrings("pod", "pod-4", "service_name", "foo"), Points: []*typesv1.Point{{Timestamp: 1, Value: 8}}},
+	}
+	testhelper.EqualProto(t, []*typesv1.Series{
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "foo"), Points: []*typesv1.Point{{Timestamp: 1, Value: 8}}},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "foo", "team", "team-a"), Points: []*typesv1.Point{{Timestamp: 1, Value: 3}}},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "foo", "team", "team-b"), Points: []*typesv1.Point{{Timestamp: 1, Value: 4}}},
+	}, joinSeriesLabels(series, joined))
+
+	// The source label is kept if it is grouped by as well.
+	groupBy, joined, err = q.groupByJoinedLabels(ctx, []string{"pod", "team"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"pod"}, groupBy)
+	require.True(t, joined[0].keepSource)
+
+	// The previous mapping is used if it cannot be refreshed.
+	unavailable = true
+	q.labelJoiner.mappings[labelMappingKey{tenantID: "user-1", url: server.URL}].fetched = time.Time{}
+	_, joined, err = q.groupByJoinedLabels(ctx, []string{"team"})
+	require.NoError(t, err)
+	require.Equal(t, "team-b", joined[0].values["pod-3"])
+
+	// A mapping that was never fetched fails the query.
+	q.labelJoiner = newLabelJoiner(nil, log.NewNopLogger())
+	_, _, err = q.groupByJoinedLabels(ctx, []string{"team"})
+	require.Error(t, err)
+}
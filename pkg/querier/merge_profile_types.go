@@ -0,0 +1,102 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+// profileTypeMerge is a profile type merged into the requested one.
+type profileTypeMerge struct {
+	profileTypeID string
+	// factor converts the sample values to the unit of the requested
+	// profile type.
+	factor float64
+}
+
+// parseMergeProfileTypes parses the profile types to be merged into the
+// requested one. Each of them is either a profile type ID, then its sample
+// values are converted by the unit names, or <profile type ID>*<factor>.
+func parseMergeProfileTypes(req *querierv1.SelectMergeStacktracesRequest) ([]profileTypeMerge, error) {
+	if len(req.MergeProfileTypes) == 0 {
+		return nil, nil
+	}
+	target, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	seen := map[string]struct{}{req.ProfileTypeID: {}}
+	merges := make([]profileTypeMerge, 0, len(req.MergeProfileTypes))
+	for _, s := range req.MergeProfileTypes {
+		id, factor, hasFactor := strings.Cut(s, "*")
+		profileType, err := phlaremodel.ParseProfileTypeSelector(id)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		if _, ok := seen[id]; ok {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("profile type %q is merged more than once", id))
+		}
+		seen[id] = struct{}{}
+		m := profileTypeMerge{profileTypeID: id}
+		if hasFactor {
+			if m.factor, err = strconv.ParseFloat(factor, 64); err != nil || m.factor <= 0 {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid conversion factor of profile type %q: %q", id, factor))
+			}
+		} else {
+			var ok bool
+			if m.factor, ok = phlaremodel.UnitConversionFactor(profileType.SampleUnit, target.SampleUnit); !ok {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf(
+					"cannot convert %s to %s: the conversion factor of profile type %q must be given as %[3]s*<factor>",
+					profileType.SampleUnit, target.SampleUnit, id))
+			}
+		}
+		merges = append(merges, m)
+	}
+	return merges, nil
+}
+
+// selectMergedTree selects the tree of the requested profile type, and
+// merges into it the trees of the other profile types, converted to its
+// unit.
+func (q *Querier) selectMergedTree(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest, merges []profileTypeMerge) (*phlaremodel.Tree, error) {
+	if len(merges) == 0 {
+		return q.selectTree(ctx, req)
+	}
+	trees := make([]*phlaremodel.Tree, len(merges)+1)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		trees[0], err = q.selectTree(ctx, req)
+		return err
+	})
+	for i, m := range merges {
+		i, m := i, m
+		g.Go(func() error {
+			r := req.CloneVT()
+			r.ProfileTypeID = m.profileTypeID
+			r.MergeProfileTypes = nil
+			t, err := q.selectTree(ctx, r)
+			if err != nil {
+				return err
+			}
+			if m.factor != 1 {
+				t.Scale(m.factor)
+			}
+			trees[i+1] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	for _, t := range trees[1:] {
+		trees[0].Merge(t)
+	}
+	return trees[0], nil
+}
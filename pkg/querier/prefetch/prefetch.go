@@ -0,0 +1,113 @@
+// Package prefetch carries the hints of the query-frontend about the time
+// ranges a query is about to read, from the query-frontend to the queriers,
+// and from the queriers to the store-gateways, which load the blocks of
+// these time ranges ahead of the sub-queries reading them.
+package prefetch
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/common/model"
+)
+
+// HeaderName is the name of the request header carrying the time range to
+// prefetch, formatted as "<start>,<end>" in milliseconds since epoch.
+const HeaderName = "Pyroscope-Prefetch-Hint"
+
+// Hint is a time range about to be read by a query.
+type Hint struct {
+	Start, End model.Time
+}
+
+// String returns the hint formatted as the value of the header.
+func (h Hint) String() string {
+	return strconv.FormatInt(int64(h.Start), 10) + "," + strconv.FormatInt(int64(h.End), 10)
+}
+
+// SetHeader sets the request header to the hint.
+func SetHeader(h http.Header, hint Hint) {
+	h.Set(HeaderName, hint.String())
+}
+
+// FromHeader returns the hint of the request header, if any.
+func FromHeader(h http.Header) (Hint, bool) {
+	v := h.Get(HeaderName)
+	if v == "" {
+		return Hint{}, false
+	}
+	start, end, ok := strings.Cut(v, ",")
+	if !ok {
+		return Hint{}, false
+	}
+	s, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return Hint{}, false
+	}
+	e, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || e < s {
+		return Hint{}, false
+	}
+	return Hint{Start: model.Time(s), End: model.Time(e)}, true
+}
+
+type contextKey int
+
+var ctxKey = contextKey(0)
+
+// InjectHint returns a context carrying the hint.
+func InjectHint(ctx context.Context, hint Hint) context.Context {
+	return context.WithValue(ctx, ctxKey, hint)
+}
+
+// FromContext returns the hint of the context, if any.
+func FromContext(ctx context.Context) (Hint, bool) {
+	hint, ok := ctx.Value(ctxKey).(Hint)
+	return hint, ok
+}
+
+// NewInterceptor returns an interceptor propagating the hints. Server side,
+// the hint of the request header is injected into the context. Client side,
+// the hint of the context is set to the request header.
+func NewInterceptor() connect.Interceptor {
+	return interceptor{}
+}
+
+type interceptor struct{}
+
+func (interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			if hint, ok := FromContext(ctx); ok {
+				SetHeader(req.Header(), hint)
+			}
+			return next(ctx, req)
+		}
+		if hint, ok := FromHeader(req.Header()); ok {
+			ctx = InjectHint(ctx, hint)
+		}
+		return next(ctx, req)
+	}
+}
+
+func (interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, s connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, s)
+		if hint, ok := FromContext(ctx); ok {
+			SetHeader(conn.RequestHeader(), hint)
+		}
+		return conn
+	}
+}
+
+func (interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if hint, ok := FromHeader(conn.RequestHeader()); ok {
+			ctx = InjectHint(ctx, hint)
+		}
+		return next(ctx, conn)
+	}
+}
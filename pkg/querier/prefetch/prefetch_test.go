@@ -0,0 +1,27 @@
+package prefetch
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Header(t *testing.T) {
+	h := make(http.Header)
+	_, ok := FromHeader(h)
+	require.False(t, ok)
+
+	hint := Hint{Start: 1000, End: 2000}
+	SetHeader(h, hint)
+	require.Equal(t, "1000,2000", h.Get(HeaderName))
+	actual, ok := FromHeader(h)
+	require.True(t, ok)
+	require.Equal(t, hint, actual)
+
+	for _, invalid := range []string{"1000", "a,2000", "1000,b", "2000,1000"} {
+		h.Set(HeaderName, invalid)
+		_, ok = FromHeader(h)
+		require.False(t, ok, invalid)
+	}
+}
@@ -31,20 +31,38 @@ import (
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/profileql"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	"github.com/grafana/pyroscope/pkg/util/math"
 	"github.com/grafana/pyroscope/pkg/util/spanlogger"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type Config struct {
 	PoolConfig      clientpool.PoolConfig `yaml:"pool_config,omitempty"`
 	QueryStoreAfter time.Duration         `yaml:"query_store_after" category:"advanced"`
+
+	QueryIngesterBlocks bool `yaml:"query_ingester_blocks" category:"experimental"`
+
+	ShuffleShardingIngestersLookbackPeriod time.Duration `yaml:"shuffle_sharding_ingesters_lookback_period" category:"advanced"`
+
+	PreferAvailabilityZone string `yaml:"prefer_availability_zone" category:"advanced"`
+
+	BlocksConsistencyCheck BlocksConsistencyCheckConfig `yaml:"blocks_consistency_check"`
 }
 
 // RegisterFlags registers distributor-related flags.
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	cfg.PoolConfig.RegisterFlagsWithPrefix("querier", fs)
 	fs.DurationVar(&cfg.QueryStoreAfter, "querier.query-store-after", 4*time.Hour, "The time after which a metric should be queried from storage and not just ingesters. 0 means all queries are sent to store. If this option is enabled, the time range of the query sent to the store-gateway will be manipulated to ensure the query end is not more recent than 'now - query-store-after'.")
+	fs.BoolVar(&cfg.QueryIngesterBlocks, "querier.query-ingester-blocks", false, "If enabled, queriers also query the blocks cut by the ingesters that are not uploaded to the object storage yet, for the time range of the query sent to the store-gateways. This allows lowering -querier.query-store-after down to the time the ingesters keep the profiles in memory, plus the time the store-gateways take to sync the blocks uploaded.")
+	fs.DurationVar(&cfg.ShuffleShardingIngestersLookbackPeriod, "querier.shuffle-sharding-ingesters-lookback-period", 0, "When the ingestion tenant shard size is set and this setting is > 0, queriers only query the ingesters of the tenant shard, including the ingesters which may have received series of the tenant since 'now - lookback period'. The lookback period should be greater than or equal to the time the ingesters keep the profiles in memory, which is bounded by -pyroscopedb.max-block-duration. 0 to query all the ingesters.")
+	fs.StringVar(&cfg.PreferAvailabilityZone, "querier.prefer-availability-zone", "", "Availability zone the querier runs in. When the ingesters or the store-gateways are replicated across availability zones, the instances of this zone are always among the instances queried, to reduce cross-zone traffic. Empty to not prefer any zone.")
+	cfg.BlocksConsistencyCheck.RegisterFlagsWithPrefix(fs, "querier.blocks-consistency-check.")
 }
 
 type Querier struct {
@@ -57,11 +75,22 @@ type Querier struct {
 
 	ingesterQuerier     *IngesterQuerier
 	storeGatewayQuerier *StoreGatewayQuerier
+	labelJoiner         *labelJoiner
+	blocksConsistency   *blocksConsistencyChecker
+	limits              Limits
+}
+
+// Limits are the per-tenant limits enforced by the querier.
+type Limits interface {
+	IngesterLimits
+	MaxQueryBlocks(tenantID string) int
+	MaxQueryBytes(tenantID string) int64
+	QueryLabelJoins(tenantID string) []*validation.LabelJoin
 }
 
 const maxNodesDefault = int64(2048)
 
-func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, storeGatewayQuerier *StoreGatewayQuerier, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Querier, error) {
+func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, storeGatewayQuerier *StoreGatewayQuerier, bucket phlareobj.Bucket, limits Limits, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Querier, error) {
 	// disable gzip compression for querier-ingester communication as most of payload are not benefit from it.
 	clientsOptions = append(clientsOptions, connect.WithAcceptCompression("gzip", nil, nil))
 	clientsMetrics := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
@@ -76,8 +105,16 @@ func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactor
 		ingesterQuerier: NewIngesterQuerier(
 			clientpool.NewIngesterPool(cfg.PoolConfig, ingestersRing, factory, clientsMetrics, logger, clientsOptions...),
 			ingestersRing,
+			limits,
+			cfg.ShuffleShardingIngestersLookbackPeriod,
+			cfg.PreferAvailabilityZone,
 		),
 		storeGatewayQuerier: storeGatewayQuerier,
+		labelJoiner:         newLabelJoiner(bucket, logger),
+		limits:              limits,
+	}
+	if cfg.BlocksConsistencyCheck.Enabled && storeGatewayQuerier != nil && bucket != nil {
+		q.blocksConsistency = newBlocksConsistencyChecker(cfg.BlocksConsistencyCheck, bucket, logger, reg)
 	}
 	var err error
 	svcs := []services.Service{q.ingesterQuerier.pool}
@@ -156,8 +193,10 @@ func (q *Querier) LabelValues(ctx context.Context, req *connect.Request[typesv1.
 	}()
 	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(childCtx context.Context, ic IngesterQueryClient) ([]string, error) {
 		res, err := ic.LabelValues(childCtx, connect.NewRequest(&typesv1.LabelValuesRequest{
-			Name:     req.Msg.Name,
-			Matchers: req.Msg.Matchers,
+			Name:      req.Msg.Name,
+			Matchers:  req.Msg.Matchers,
+			Limit:     replicaLimit(req.Msg.Limit),
+			PageToken: req.Msg.PageToken,
 		}))
 		if err != nil {
 			return nil, err
@@ -168,9 +207,12 @@ func (q *Querier) LabelValues(ctx context.Context, req *connect.Request[typesv1.
 		return nil, err
 	}
 
-	return connect.NewResponse(&typesv1.LabelValuesResponse{
-		Names: uniqueSortedStrings(responses),
-	}), nil
+	res := new(typesv1.LabelValuesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(uniqueSortedStrings(responses), req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
 }
 
 func (q *Querier) LabelNames(ctx context.Context, req *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
@@ -178,7 +220,9 @@ func (q *Querier) LabelNames(ctx context.Context, req *connect.Request[typesv1.L
 	defer sp.Finish()
 	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(childCtx context.Context, ic IngesterQueryClient) ([]string, error) {
 		res, err := ic.LabelNames(childCtx, connect.NewRequest(&typesv1.LabelNamesRequest{
-			Matchers: req.Msg.Matchers,
+			Matchers:  req.Msg.Matchers,
+			Limit:     replicaLimit(req.Msg.Limit),
+			PageToken: req.Msg.PageToken,
 		}))
 		if err != nil {
 			return nil, err
@@ -188,10 +232,14 @@ func (q *Querier) LabelNames(ctx context.Context, req *connect.Request[typesv1.L
 	if err != nil {
 		return nil, err
 	}
+	responses = append(responses, ResponseFromReplica[[]string]{response: q.joinedLabelNames(ctx)})
 
-	return connect.NewResponse(&typesv1.LabelNamesResponse{
-		Names: uniqueSortedStrings(responses),
-	}), nil
+	res := new(typesv1.LabelNamesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(uniqueSortedStrings(responses), req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
 }
 
 func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
@@ -215,20 +263,13 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 			LabelNames: req.Msg.LabelNames,
 			Start:      req.Msg.Start,
 			End:        req.Msg.End,
+			Limit:      replicaLimit(req.Msg.Limit),
+			PageToken:  req.Msg.PageToken,
 		})
 		if err != nil {
 			return nil, err
 		}
-
-		return connect.NewResponse(&querierv1.SeriesResponse{
-			LabelsSet: lo.UniqBy(
-				lo.FlatMap(responses, func(r ResponseFromReplica[[]*typesv1.Labels], _ int) []*typesv1.Labels {
-					return r.response
-				}),
-				func(t *typesv1.Labels) uint64 {
-					return phlaremodel.Labels(t.Labels).Hash()
-				}),
-		}), nil
+		return paginateSeries(responses, req.Msg)
 	}
 
 	storeQueries := splitQueryToStores(model.Time(req.Msg.Start), model.Time(req.Msg.End), model.Now(), q.cfg.QueryStoreAfter)
@@ -248,6 +289,8 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 				LabelNames: req.Msg.LabelNames,
 				Start:      req.Msg.Start,
 				End:        req.Msg.End,
+				Limit:      replicaLimit(req.Msg.Limit),
+				PageToken:  req.Msg.PageToken,
 			})
 			if err != nil {
 				return err
@@ -267,6 +310,8 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 				LabelNames: req.Msg.LabelNames,
 				Start:      req.Msg.Start,
 				End:        req.Msg.End,
+				Limit:      replicaLimit(req.Msg.Limit),
+				PageToken:  req.Msg.PageToken,
 			})
 			if err != nil {
 				return err
@@ -283,17 +328,38 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 	if err != nil {
 		return nil, err
 	}
+	return paginateSeries(responses, req.Msg)
+}
 
-	return connect.NewResponse(&querierv1.SeriesResponse{
-		LabelsSet: lo.UniqBy(
-			lo.FlatMap(responses, func(r ResponseFromReplica[[]*typesv1.Labels], _ int) []*typesv1.Labels {
-				return r.response
-			}),
-			func(t *typesv1.Labels) uint64 {
-				return phlaremodel.Labels(t.Labels).Hash()
-			},
-		),
-	}), nil
+// replicaLimit returns the limit of the requests sent to the ingesters and
+// store-gateways: one more item than requested tells whether there is a
+// next page.
+func replicaLimit(limit int64) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	return limit + 1
+}
+
+func paginateSeries(responses []ResponseFromReplica[[]*typesv1.Labels], req *querierv1.SeriesRequest) (*connect.Response[querierv1.SeriesResponse], error) {
+	labelsSet := lo.UniqBy(
+		lo.FlatMap(responses, func(r ResponseFromReplica[[]*typesv1.Labels], _ int) []*typesv1.Labels {
+			return r.response
+		}),
+		func(t *typesv1.Labels) uint64 {
+			return phlaremodel.Labels(t.Labels).Hash()
+		},
+	)
+	sort.Slice(labelsSet, func(i, j int) bool {
+		return phlaremodel.CompareLabelPairs(labelsSet[i].Labels, labelsSet[j].Labels) < 0
+	})
+	var err error
+	res := new(querierv1.SeriesResponse)
+	res.LabelsSet, res.NextPageToken, err = phlaremodel.PaginateLabelsSet(labelsSet, req.Limit, req.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
 }
 
 func (q *Querier) Diff(ctx context.Context, req *connect.Request[querierv1.DiffRequest]) (*connect.Response[querierv1.DiffResponse], error) {
@@ -313,20 +379,36 @@ func (q *Querier) Diff(ctx context.Context, req *connect.Request[querierv1.DiffR
 	g, gCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
+		filter, err := treeFilter(req.Msg.Left)
+		if err != nil {
+			return err
+		}
 		res, err := q.selectTree(gCtx, req.Msg.Left)
 		if err != nil {
 			return err
 		}
+		res = filter.Apply(res)
 
 		leftTree = res
 		return nil
 	})
 
 	g.Go(func() error {
+		// The left selection may be compared against an uploaded profile.
+		if len(req.Msg.RightProfile) > 0 {
+			res, err := profileTree(req.Msg.RightProfile, req.Msg.Left)
+			rightTree = res
+			return err
+		}
+		filter, err := treeFilter(req.Msg.Right)
+		if err != nil {
+			return err
+		}
 		res, err := q.selectTree(gCtx, req.Msg.Right)
 		if err != nil {
 			return err
 		}
+		res = filter.Apply(res)
 		rightTree = res
 		return nil
 	})
@@ -335,7 +417,11 @@ func (q *Querier) Diff(ctx context.Context, req *connect.Request[querierv1.DiffR
 		return nil, err
 	}
 
-	fd, err := phlaremodel.NewFlamegraphDiff(leftTree, rightTree, phlaremodel.MaxNodes)
+	maxNodes := math.Max(req.Msg.Left.GetMaxNodes(), req.Msg.Right.GetMaxNodes())
+	if maxNodes == 0 {
+		maxNodes = phlaremodel.MaxNodes
+	}
+	fd, err := phlaremodel.NewFlamegraphDiff(leftTree, rightTree, int(maxNodes))
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
@@ -361,17 +447,44 @@ func (q *Querier) SelectMergeStacktraces(ctx context.Context, req *connect.Reque
 		mn := maxNodesDefault
 		req.Msg.MaxNodes = &mn
 	}
+	filter, err := treeFilter(req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	merges, err := parseMergeProfileTypes(req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if req.Msg.Explain {
+		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+			Plan: q.explainSelectMergeStacktraces(ctx, req.Msg),
+		}), nil
+	}
+	defer func(start time.Time) {
+		stats.FromContext(ctx).AddWallTime(time.Since(start))
+	}(time.Now())
 
-	t, err := q.selectTree(ctx, req.Msg)
+	ctx, partial := contextWithPartialResults(ctx)
+	t, err := q.selectMergedTree(ctx, req.Msg, merges)
 	if err != nil {
 		return nil, err
 	}
+	t = filter.Apply(t)
 
 	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
 		Flamegraph: phlaremodel.NewFlameGraph(t, req.Msg.GetMaxNodes()),
+		Partial:    partial.Load(),
 	}), nil
 }
 
+func (q *Querier) SelectMergeStacktracesStream(ctx context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest], stream *connect.ServerStream[querierv1.SelectMergeStacktracesResponse]) error {
+	res, err := q.SelectMergeStacktraces(ctx, req)
+	if err != nil {
+		return err
+	}
+	return connectgrpc.SendServerStream(stream, res.Header(), phlaremodel.SplitSelectMergeStacktracesResponse(res.Msg, phlaremodel.FlameGraphChunkNodes))
+}
+
 func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
 	// no store gateways configured so just query the ingesters
 	if q.storeGatewayQuerier == nil {
@@ -386,7 +499,7 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
 
 	if !storeQueries.ingester.shouldQuery {
-		return q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
+		return q.selectTreeFromStorage(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
 	}
 	if !storeQueries.storeGateway.shouldQuery {
 		return q.selectTreeFromIngesters(ctx, storeQueries.ingester.MergeStacktracesRequest(req))
@@ -404,7 +517,7 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	})
 	g.Go(func() error {
 		var err error
-		storegatewayTree, err = q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
+		storegatewayTree, err = q.selectTreeFromStorage(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
 		if err != nil {
 			return err
 		}
@@ -417,6 +530,65 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	return storegatewayTree, nil
 }
 
+// selectTreeFromStorage queries the store-gateways and, if enabled, the
+// blocks of the ingesters not uploaded yet for the same time range.
+func (q *Querier) selectTreeFromStorage(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	if !q.cfg.QueryIngesterBlocks {
+		return q.selectTreeFromStoreGateway(ctx, req)
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	var blocksTree, storegatewayTree *phlaremodel.Tree
+	g.Go(func() error {
+		var err error
+		blocksTree, err = q.selectTreeFromIngesterBlocks(ctx, req)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		storegatewayTree, err = q.selectTreeFromStoreGateway(ctx, req)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	storegatewayTree.Merge(blocksTree)
+	return storegatewayTree, nil
+}
+
+// treeFilter returns the filter of the stacks requested.
+// profileTree returns the tree of an uploaded pprof profile, with the
+// sample type and the stacks options of the request it is compared to.
+func profileTree(b []byte, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.GetProfileTypeID())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	filter, err := phlaremodel.NewTreeFilter(req)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	var t *phlaremodel.Tree
+	err = pprof.FromBytes(b, func(p *googlev1.Profile, _ int) (err error) {
+		t, err = phlaremodel.TreeFromProfile(p, profileType)
+		return err
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.Wrap(err, "invalid profile"))
+	}
+	return filter.Apply(t), nil
+}
+
+func treeFilter(req *querierv1.SelectMergeStacktracesRequest) (phlaremodel.TreeFilter, error) {
+	f, err := phlaremodel.NewTreeFilter(req)
+	if err != nil {
+		return f, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	// The stacks are excluded by ingesters and store-gateways, the
+	// expression is only validated here.
+	f.ExcludeStacks = nil
+	return f, nil
+}
+
 type storeQuery struct {
 	start, end  model.Time
 	shouldQuery bool
@@ -429,12 +601,16 @@ func (sq storeQuery) MergeStacktracesRequest(req *querierv1.SelectMergeStacktrac
 		LabelSelector: req.LabelSelector,
 		ProfileTypeID: req.ProfileTypeID,
 		MaxNodes:      req.MaxNodes,
+
+		AllowPartialResults: req.AllowPartialResults,
+		ExcludeStacks:       req.ExcludeStacks,
 	}
 }
 
 func (sq storeQuery) MergeSeriesRequest(req *querierv1.SelectSeriesRequest, profileType *typesv1.ProfileType) *ingestv1.MergeProfilesLabelsRequest {
 	return &ingestv1.MergeProfilesLabelsRequest{
-		By: req.GroupBy,
+		By:             req.GroupBy,
+		HeatmapBuckets: req.HeatmapBuckets,
 		Request: &ingestv1.SelectProfilesRequest{
 			Type:          profileType,
 			LabelSelector: req.LabelSelector,
@@ -498,9 +674,39 @@ func (q *Querier) SelectMergeProfile(ctx context.Context, req *connect.Request[q
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	if req.Msg.ClosestTo != 0 {
+		profile, err := q.selectClosestProfile(ctx, profileType, req.Msg)
+		if err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(profile), nil
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	responses, err := q.mergeProfilesPprof(ctx, &ingestv1.SelectProfilesRequest{
+		LabelSelector: req.Msg.LabelSelector,
+		Start:         req.Msg.Start,
+		End:           req.Msg.End,
+		Type:          profileType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// merge all profiles
+	profile, err := selectMergePprofProfile(ctx, profileType, responses, skipDuplicates)
+	if err != nil {
+		return nil, err
+	}
+	profile.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
+	profile.TimeNanos = model.Time(req.Msg.End).UnixNano()
+	return connect.NewResponse(profile), nil
+}
+
+// mergeProfilesPprof opens the merge streams to all ingesters and sends them
+// the selection request.
+func (q *Querier) mergeProfilesPprof(ctx context.Context, req *ingestv1.SelectProfilesRequest) ([]ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof], error) {
 	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(ctx context.Context, ic IngesterQueryClient) (clientpool.BidiClientMergeProfilesPprof, error) {
 		return ic.MergeProfilesPprof(ctx), nil
 	})
@@ -508,32 +714,70 @@ func (q *Querier) SelectMergeProfile(ctx context.Context, req *connect.Request[q
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 	// send the first initial request to all ingesters.
-	g, gCtx := errgroup.WithContext(ctx)
+	var g errgroup.Group
 	for _, r := range responses {
 		r := r
 		g.Go(util.RecoverPanic(func() error {
-			return r.response.Send(&ingestv1.MergeProfilesPprofRequest{
-				Request: &ingestv1.SelectProfilesRequest{
-					LabelSelector: req.Msg.LabelSelector,
-					Start:         req.Msg.Start,
-					End:           req.Msg.End,
-					Type:          profileType,
-				},
-			})
+			return r.response.Send(&ingestv1.MergeProfilesPprofRequest{Request: req})
 		}))
 	}
 	if err := g.Wait(); err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
+	return responses, nil
+}
 
-	// merge all profiles
-	profile, err := selectMergePprofProfile(gCtx, profileType, responses)
+func (q *Querier) SelectTopFunctions(ctx context.Context, req *connect.Request[querierv1.SelectTopFunctionsRequest]) (*connect.Response[querierv1.SelectTopFunctionsResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectTopFunctions")
+	defer sp.Finish()
+
+	if req.Msg.GetLimit() < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("limit must not be negative"))
+	}
+	limit := int(req.Msg.GetLimit())
+	if limit == 0 {
+		limit = phlaremodel.DefaultTopFunctionsLimit
+	}
+	p, err := q.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		ProfileTypeID: req.Msg.ProfileTypeID,
+		LabelSelector: req.Msg.LabelSelector,
+		Start:         req.Msg.Start,
+		End:           req.Msg.End,
+	}))
 	if err != nil {
 		return nil, err
 	}
-	profile.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
-	profile.TimeNanos = model.Time(req.Msg.End).UnixNano()
-	return connect.NewResponse(profile), nil
+	return connect.NewResponse(phlaremodel.NewTopFunctions(p.Msg, req.Msg.OrderBy, limit)), nil
+}
+
+func (q *Querier) SelectCallersCallees(ctx context.Context, req *connect.Request[querierv1.SelectCallersCalleesRequest]) (*connect.Response[querierv1.SelectCallersCalleesResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectCallersCallees")
+	defer sp.Finish()
+
+	if req.Msg.FunctionName == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("function name is required"))
+	}
+	t, err := q.selectTree(ctx, &querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: req.Msg.ProfileTypeID,
+		LabelSelector: req.Msg.LabelSelector,
+		Start:         req.Msg.Start,
+		End:           req.Msg.End,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(phlaremodel.NewCallersCallees(t, req.Msg.FunctionName)), nil
+}
+
+func (q *Querier) QueryRange(ctx context.Context, req *connect.Request[querierv1.QueryRangeRequest]) (*connect.Response[querierv1.QueryRangeResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "QueryRange")
+	defer sp.Finish()
+
+	res, err := profileql.QueryRange(ctx, q, req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
 }
 
 func (q *Querier) SelectSeries(ctx context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
@@ -563,10 +807,25 @@ func (q *Querier) SelectSeries(ctx context.Context, req *connect.Request[querier
 		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("step must be non-zero"))
 	}
 
+	if err := phlaremodel.ValidateHeatmapBuckets(req.Msg.HeatmapBuckets); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
 	stepMs := time.Duration(req.Msg.Step * float64(time.Second)).Milliseconds()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// The joined labels are grouped by their source label on the data nodes.
+	groupBy, joined, err := q.groupByJoinedLabels(ctx, req.Msg.GroupBy)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+	if len(joined) > 0 {
+		msg := req.Msg.CloneVT()
+		msg.GroupBy = groupBy
+		req = connectgrpc.CloneRequest(req, msg)
+	}
+
 	responses, err := q.selectSeries(ctx, req)
 	if err != nil {
 		return nil, err
@@ -581,6 +840,7 @@ func (q *Querier) SelectSeries(ctx context.Context, req *connect.Request[querier
 	if it.Err() != nil {
 		return nil, connect.NewError(connect.CodeInternal, it.Err())
 	}
+	result = joinSeriesLabels(result, joined)
 
 	return connect.NewResponse(&querierv1.SelectSeriesResponse{
 		Series: result,
@@ -608,7 +868,8 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 				End:           req.Msg.End,
 				Type:          profileType,
 			},
-			By: req.Msg.GroupBy,
+			By:             req.Msg.GroupBy,
+			HeatmapBuckets: req.Msg.HeatmapBuckets,
 		})
 	}
 
@@ -3,6 +3,7 @@ package querier
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"sort"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
@@ -25,7 +27,9 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type poolFactory struct {
@@ -72,7 +76,7 @@ func Test_QuerySampleType(t *testing.T) {
 				}), nil)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.ProfileTypes(context.Background(), connect.NewRequest(&querierv1.ProfileTypesRequest{}))
@@ -84,6 +88,37 @@ func Test_QuerySampleType(t *testing.T) {
 	require.Equal(t, []string{"bar", "buzz", "foo"}, ids)
 }
 
+func Test_QueryShuffleShardedIngesters(t *testing.T) {
+	var outOfShard atomic.Bool
+	querier, err := New(Config{
+		PoolConfig:                             clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+		ShuffleShardingIngestersLookbackPeriod: time.Hour,
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+		{Addr: "3"},
+	}, 1), &poolFactory{f: func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		if addr == "3" {
+			outOfShard.Store(true)
+		}
+		q.On("ProfileTypes", mock.Anything, mock.Anything).
+			Return(connect.NewResponse(&ingestv1.ProfileTypesResponse{
+				ProfileTypes: []*typesv1.ProfileType{{ID: addr}},
+			}), nil)
+		return q, nil
+	}}, nil, nil, validation.MockLimits{IngestionTenantShardSizeValue: 2}, nil, log.NewLogfmtLogger(os.Stdout))
+
+	require.NoError(t, err)
+	out, err := querier.ProfileTypes(tenant.InjectTenantID(context.Background(), "foo"), connect.NewRequest(&querierv1.ProfileTypesRequest{}))
+	require.NoError(t, err)
+	require.NotEmpty(t, out.Msg.ProfileTypes)
+	for _, pt := range out.Msg.ProfileTypes {
+		require.Contains(t, []string{"1", "2"}, pt.ID)
+	}
+	require.False(t, outOfShard.Load(), "ingesters out of the tenant shard must not be queried")
+}
+
 func Test_QueryLabelValues(t *testing.T) {
 	req := connect.NewRequest(&typesv1.LabelValuesRequest{Name: "foo"})
 	querier, err := New(Config{
@@ -103,7 +138,7 @@ func Test_QueryLabelValues(t *testing.T) {
 			q.On("LabelValues", mock.Anything, mock.Anything).Return(connect.NewResponse(&typesv1.LabelValuesResponse{Names: []string{"buzz", "foo"}}), nil)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.LabelValues(context.Background(), req)
@@ -130,7 +165,7 @@ func Test_QueryLabelNames(t *testing.T) {
 			q.On("LabelNames", mock.Anything, mock.Anything).Return(connect.NewResponse(&typesv1.LabelNamesResponse{Names: []string{"buzz", "foo"}}), nil)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.LabelNames(context.Background(), req)
@@ -163,7 +198,7 @@ func Test_Series(t *testing.T) {
 			q.On("Series", mock.Anything, mock.Anything).Return(ingesterReponse, nil)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.Series(context.Background(), req)
@@ -249,7 +284,7 @@ func Test_SelectMergeStacktraces(t *testing.T) {
 			q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	flame, err := querier.SelectMergeStacktraces(context.Background(), req)
 	require.NoError(t, err)
@@ -279,6 +314,181 @@ func Test_SelectMergeStacktraces(t *testing.T) {
 		}, selected)
 }
 
+func Test_Diff_MaxNodes(t *testing.T) {
+	// Both sides are the same wide tree: main calls 10 functions of values 1 to 10.
+	newBidi := func() *fakeBidiClientStacktraces {
+		result := &ingestv1.MergeProfilesStacktracesResult{
+			Format:        ingestv1.StacktracesMergeFormat_MERGE_FORMAT_STACKTRACES,
+			FunctionNames: []string{"main"},
+		}
+		for i := 1; i <= 10; i++ {
+			result.FunctionNames = append(result.FunctionNames, fmt.Sprintf("f%d", i))
+			result.Stacktraces = append(result.Stacktraces, &ingestv1.StacktraceSample{
+				FunctionIds: []int32{int32(i), 0},
+				Value:       int64(i),
+			})
+		}
+		bidi := newFakeBidiClientStacktraces([]*ingestv1.ProfileSets{
+			{
+				LabelsSets: []*typesv1.Labels{
+					{
+						Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}},
+					},
+				},
+				Profiles: []*ingestv1.SeriesProfile{
+					{Timestamp: 1, LabelIndex: 0},
+				},
+			},
+		})
+		bidi.result = result
+		return bidi
+	}
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+	}, 2), &poolFactory{func(addr string) (client.PoolClient, error) {
+		// Each ingester is queried once for each side.
+		q := newFakeQuerier()
+		q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(newBidi())
+		q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(newBidi())
+		return q, nil
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	// Only the left side sets max nodes, the limit applies to both sides.
+	maxNodes := int64(4)
+	res, err := querier.Diff(context.Background(), connect.NewRequest(&querierv1.DiffRequest{
+		Left: &querierv1.SelectMergeStacktracesRequest{
+			LabelSelector: `{app="foo"}`,
+			ProfileTypeID: "memory:inuse_space:bytes:space:byte",
+			Start:         0,
+			End:           2,
+			MaxNodes:      &maxNodes,
+		},
+		Right: &querierv1.SelectMergeStacktracesRequest{
+			LabelSelector: `{app="foo"}`,
+			ProfileTypeID: "memory:inuse_space:bytes:space:byte",
+			Start:         0,
+			End:           2,
+		},
+	}))
+	require.NoError(t, err)
+
+	fg := res.Msg.Flamegraph
+	require.Equal(t, int64(55), fg.LeftTicks)
+	require.Equal(t, int64(55), fg.RightTicks)
+	// The smallest functions of both sides are merged into "other".
+	require.Equal(t, []string{"total", "main", "other", "f9", "f10"}, fg.Names)
+	require.Len(t, fg.Levels, 3)
+	// Level values: x offset, total and self of the left side, then of the right side, then the name index.
+	require.Equal(t, []int64{0, 10, 10, 0, 10, 10, 4, 0, 9, 9, 0, 9, 9, 3, 0, 36, 36, 0, 36, 36, 2}, fg.Levels[2].Values)
+}
+
+func Test_selectTreeFromIngesterBlocks(t *testing.T) {
+	// The profile is replicated to both ingesters, it's only kept once.
+	bidis := map[string]*fakeBidiClientStacktraces{}
+	for _, addr := range []string{"1", "2"} {
+		bidis[addr] = newFakeBidiClientStacktraces([]*ingestv1.ProfileSets{
+			{
+				LabelsSets: []*typesv1.Labels{
+					{
+						Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}},
+					},
+				},
+				Profiles: []*ingestv1.SeriesProfile{
+					{Timestamp: 1, LabelIndex: 0},
+				},
+			},
+		})
+	}
+	querier, err := New(Config{
+		PoolConfig:          clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+		QueryIngesterBlocks: true,
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+	}, 2), &poolFactory{func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		q.On("MergeBlocksStacktraces", mock.Anything).Once().Return(bidis[addr])
+		return q, nil
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+	tree, err := querier.selectTreeFromIngesterBlocks(context.Background(), &querierv1.SelectMergeStacktracesRequest{
+		LabelSelector: `{app="foo"}`,
+		ProfileTypeID: "memory:inuse_space:bytes:space:byte",
+		Start:         0,
+		End:           2,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, tree)
+	requireFakeMergeProfilesStacktracesResultTree(t, tree)
+	require.Len(t, append(bidis["1"].kept, bidis["2"].kept...), 1)
+}
+
+func Test_SelectFunctionSeries_Validation(t *testing.T) {
+	q := new(Querier)
+	for _, req := range []*querierv1.SelectFunctionSeriesRequest{
+		{Start: 0, End: 1000, Step: 1},
+		{Start: 0, End: 1000, Step: 0, FunctionName: "foo"},
+		{Start: 1000, End: 1000, Step: 1, FunctionName: "foo"},
+		{Start: 0, End: (maxFunctionSeriesPoints + 1) * 1000, Step: 1, FunctionName: "foo"},
+	} {
+		_, err := q.SelectFunctionSeries(context.Background(), connect.NewRequest(req))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	}
+}
+
+func Test_SelectMergeStacktraces_Explain(t *testing.T) {
+	q := &Querier{
+		cfg:                 Config{QueryStoreAfter: 4 * time.Hour},
+		storeGatewayQuerier: new(StoreGatewayQuerier),
+		limits:              validation.MockLimits{MaxQueryBlocksValue: 10},
+		logger:              log.NewNopLogger(),
+	}
+	now := time.Now()
+	res, err := q.SelectMergeStacktraces(tenant.InjectTenantID(context.Background(), "foo"), connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{service_name="foo"}`,
+		Start:         now.Add(-8 * time.Hour).UnixMilli(),
+		End:           now.UnixMilli(),
+		Explain:       true,
+	}))
+	require.NoError(t, err)
+	require.Nil(t, res.Msg.Flamegraph)
+	require.Contains(t, res.Msg.Plan, "store-gateways: ")
+	require.Contains(t, res.Msg.Plan, "ingesters: ")
+	require.Contains(t, res.Msg.Plan, "budget per data node: 10 blocks")
+	require.Contains(t, res.Msg.Plan, "max nodes: 2048")
+}
+
+func Test_parseMergeProfileTypes(t *testing.T) {
+	const cpu = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	merges, err := parseMergeProfileTypes(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: cpu,
+		MergeProfileTypes: []string{
+			"process_cpu:cpu:microseconds:cpu:microseconds",
+			"process_cpu:samples:count:cpu:nanoseconds*10000000",
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []profileTypeMerge{
+		{profileTypeID: "process_cpu:cpu:microseconds:cpu:microseconds", factor: 1e3},
+		{profileTypeID: "process_cpu:samples:count:cpu:nanoseconds", factor: 1e7},
+	}, merges)
+
+	for _, types := range [][]string{
+		{"process_cpu:samples:count:cpu:nanoseconds"},
+		{"process_cpu:samples:count:cpu:nanoseconds*-1"},
+		{cpu},
+		{"invalid"},
+	} {
+		_, err = parseMergeProfileTypes(&querierv1.SelectMergeStacktracesRequest{ProfileTypeID: cpu, MergeProfileTypes: types})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), types)
+	}
+}
+
 func Test_SelectMergeProfile(t *testing.T) {
 	req := connect.NewRequest(&querierv1.SelectMergeProfileRequest{
 		LabelSelector: `{app="foo"}`,
@@ -354,7 +564,7 @@ func Test_SelectMergeProfile(t *testing.T) {
 			q.On("MergeProfilesPprof", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	res, err := querier.SelectMergeProfile(context.Background(), req)
 	require.NoError(t, err)
@@ -392,6 +602,65 @@ func Test_SelectMergeProfile(t *testing.T) {
 		}, selected)
 }
 
+func Test_closestProfile(t *testing.T) {
+	newIterators := func() ([]MergeIterator, []*fakeBidiClientProfiles) {
+		foo := &typesv1.Labels{Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}}}
+		bar := &typesv1.Labels{Labels: []*typesv1.LabelPair{{Name: "app", Value: "bar"}}}
+		bidis := []*fakeBidiClientProfiles{
+			newFakeBidiClientProfiles([]*ingestv1.ProfileSets{{
+				LabelsSets: []*typesv1.Labels{foo, bar},
+				Profiles: []*ingestv1.SeriesProfile{
+					{Timestamp: 10, LabelIndex: 0},
+					{Timestamp: 20, LabelIndex: 1},
+					{Timestamp: 30, LabelIndex: 0},
+				},
+			}}),
+			newFakeBidiClientProfiles([]*ingestv1.ProfileSets{{
+				LabelsSets: []*typesv1.Labels{foo, bar},
+				Profiles: []*ingestv1.SeriesProfile{
+					{Timestamp: 20, LabelIndex: 1},
+					{Timestamp: 20, LabelIndex: 0},
+					{Timestamp: 40, LabelIndex: 1},
+				},
+			}}),
+		}
+		its := make([]MergeIterator, len(bidis))
+		for i, bidi := range bidis {
+			its[i] = NewMergeIterator[[]byte](context.Background(), ResponseFromReplica[BidiClientMerge[*ingestv1.MergeProfilesPprofRequest, *ingestv1.MergeProfilesPprofResponse]]{
+				addr:     "ingester",
+				response: bidi,
+			})
+		}
+		return its, bidis
+	}
+
+	for _, tc := range []struct {
+		ts        int64
+		timestamp int64
+		labels    string
+	}{
+		{ts: 0, timestamp: 10, labels: `{app="foo"}`},
+		{ts: 19, timestamp: 20, labels: `{app="bar"}`},
+		// Equally close to 20 and 30: the earlier one wins.
+		{ts: 25, timestamp: 20, labels: `{app="bar"}`},
+		{ts: 36, timestamp: 40, labels: `{app="bar"}`},
+		{ts: 100, timestamp: 40, labels: `{app="bar"}`},
+	} {
+		its, _ := newIterators()
+		closest, err := closestProfile(its, tc.ts)
+		require.NoError(t, err)
+		require.Equal(t, tc.timestamp, closest.Timestamp, "closest to %d", tc.ts)
+		require.Equal(t, tc.labels, closest.Labels.ToPrometheusLabels().String(), "closest to %d", tc.ts)
+	}
+
+	its, bidis := newIterators()
+	require.NoError(t, keepProfile(its, &ProfileWithLabels{
+		Timestamp: 20,
+		Labels:    phlaremodel.Labels{{Name: "app", Value: "bar"}},
+	}))
+	require.Len(t, append(bidis[0].kept, bidis[1].kept...), 1)
+}
+
 func TestSelectSeries(t *testing.T) {
 	req := connect.NewRequest(&querierv1.SelectSeriesRequest{
 		LabelSelector: `{app="foo"}`,
@@ -468,7 +737,7 @@ func TestSelectSeries(t *testing.T) {
 			q.On("MergeProfilesLabels", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}}, nil, nil, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	res, err := querier.SelectSeries(context.Background(), req)
 	require.NoError(t, err)
@@ -567,6 +836,22 @@ func (f *fakeQuerierIngester) Series(ctx context.Context, req *connect.Request[i
 	return res, err
 }
 
+func (f *fakeQuerierIngester) MergeSpanProfile(ctx context.Context, req *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+	var (
+		args = f.Called(ctx, req)
+		res  *connect.Response[ingestv1.MergeSpanProfileResponse]
+		err  error
+	)
+	if args[0] != nil {
+		res = args[0].(*connect.Response[ingestv1.MergeSpanProfileResponse])
+	}
+	if args[1] != nil {
+		err = args.Get(1).(error)
+	}
+
+	return res, err
+}
+
 type testProfile struct {
 	Ts     int64
 	Labels *typesv1.Labels
@@ -577,6 +862,8 @@ type fakeBidiClientStacktraces struct {
 	batches  []*ingestv1.ProfileSets
 	kept     []testProfile
 	cur      *ingestv1.ProfileSets
+	// result overrides the merge result returned once all batches are sent.
+	result *ingestv1.MergeProfilesStacktracesResult
 }
 
 func newFakeBidiClientStacktraces(batches []*ingestv1.ProfileSets) *fakeBidiClientStacktraces {
@@ -612,6 +899,9 @@ func (f *fakeBidiClientStacktraces) Send(in *ingestv1.MergeProfilesStacktracesRe
 
 func (f *fakeBidiClientStacktraces) Receive() (*ingestv1.MergeProfilesStacktracesResponse, error) {
 	profiles := <-f.profiles
+	if profiles == nil && f.result != nil {
+		return &ingestv1.MergeProfilesStacktracesResponse{Result: f.result}, nil
+	}
 	if profiles == nil {
 		return &ingestv1.MergeProfilesStacktracesResponse{
 			Result: &ingestv1.MergeProfilesStacktracesResult{
@@ -786,6 +1076,18 @@ func (f *fakeQuerierIngester) MergeProfilesPprof(ctx context.Context) clientpool
 	return res
 }
 
+func (f *fakeQuerierIngester) MergeBlocksStacktraces(ctx context.Context) clientpool.BidiClientMergeProfilesStacktraces {
+	var (
+		args = f.Called(ctx)
+		res  clientpool.BidiClientMergeProfilesStacktraces
+	)
+	if args[0] != nil {
+		res = args[0].(clientpool.BidiClientMergeProfilesStacktraces)
+	}
+
+	return res
+}
+
 func TestRangeSeries(t *testing.T) {
 	for _, tc := range []struct {
 		name string
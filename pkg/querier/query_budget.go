@@ -0,0 +1,50 @@
+package querier
+
+import (
+	"context"
+
+	"github.com/grafana/dskit/tenant"
+	"go.uber.org/atomic"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// mergeStacktracesRequest returns the request starting the merge stream
+// on ingesters and store-gateways. The request carries the query budget of
+// the tenant, which is enforced by every one of them.
+func (q *Querier) mergeStacktracesRequest(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest, profileType *typesv1.ProfileType) *ingestv1.MergeProfilesStacktracesRequest {
+	r := &ingestv1.MergeProfilesStacktracesRequest{
+		Request: &ingestv1.SelectProfilesRequest{
+			LabelSelector: req.LabelSelector,
+			Start:         req.Start,
+			End:           req.End,
+			Type:          profileType,
+		},
+		MaxNodes: req.MaxNodes,
+		// TODO(kolesnikovae): Max stacks.
+		AllowPartialResults: req.AllowPartialResults,
+		ExcludeStacks:       req.ExcludeStacks,
+	}
+	if tenantID, err := tenant.TenantID(ctx); err == nil && q.limits != nil {
+		r.MaxBlocks = int64(q.limits.MaxQueryBlocks(tenantID))
+		r.MaxBytes = q.limits.MaxQueryBytes(tenantID)
+	}
+	return r
+}
+
+type partialResultsKey struct{}
+
+// contextWithPartialResults returns a context recording whether any of
+// the merge results were partial, because they exceeded the query budget.
+func contextWithPartialResults(ctx context.Context) (context.Context, *atomic.Bool) {
+	partial := atomic.NewBool(false)
+	return context.WithValue(ctx, partialResultsKey{}, partial), partial
+}
+
+func markPartialResults(ctx context.Context) {
+	if partial, ok := ctx.Value(partialResultsKey{}).(*atomic.Bool); ok {
+		partial.Store(true)
+	}
+}
@@ -2,9 +2,11 @@ package querier
 
 import (
 	"context"
+	"math/rand"
 
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/ring"
+	"github.com/samber/lo"
 
 	"github.com/grafana/pyroscope/pkg/util"
 )
@@ -25,7 +27,56 @@ type ClientFactory[T any] func(addr string) (T, error)
 
 // forGivenReplicationSet runs f, in parallel, for given replica set.
 // Under the hood it returns only enough responses to satisfy the quorum.
-func forGivenReplicationSet[Result any, Querier any](ctx context.Context, clientFactory func(string) (Querier, error), replicationSet ring.ReplicationSet, f QueryReplicaFn[Result, Querier]) ([]ResponseFromReplica[Result], error) {
+//
+// If the replica set is zone-aware, the preferred zone, if any, is always
+// one of the zones queried. If the quorum can't be reached this way, the
+// query is retried against all the zones.
+func forGivenReplicationSet[Result any, Querier any](ctx context.Context, clientFactory func(string) (Querier, error), replicationSet ring.ReplicationSet, preferredZone string, f QueryReplicaFn[Result, Querier]) ([]ResponseFromReplica[Result], error) {
+	if preferred, ok := preferZone(replicationSet, preferredZone); ok {
+		results, err := doUntilQuorum(ctx, clientFactory, preferred, f)
+		if err == nil || ctx.Err() != nil {
+			return results, err
+		}
+		level.Warn(util.Logger).Log("msg", "failed to query the preferred zone, querying all the zones", "zone", preferredZone, "err", err)
+	}
+	return doUntilQuorum(ctx, clientFactory, replicationSet, f)
+}
+
+// preferZone returns the replica set without the instances of the zones
+// that are not required to reach the quorum, keeping the preferred zone.
+// The zones excluded are picked at random to spread the load. It returns
+// false if the replica set is not zone-aware, or has no instance in the
+// preferred zone.
+func preferZone(replicationSet ring.ReplicationSet, zone string) (ring.ReplicationSet, bool) {
+	if zone == "" || replicationSet.MaxUnavailableZones <= 0 {
+		return replicationSet, false
+	}
+	zones := make(map[string]struct{})
+	for _, instance := range replicationSet.Instances {
+		zones[instance.Zone] = struct{}{}
+	}
+	if _, ok := zones[zone]; !ok {
+		return replicationSet, false
+	}
+	delete(zones, zone)
+	others := lo.Keys(zones)
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	if len(others) > replicationSet.MaxUnavailableZones {
+		others = others[:replicationSet.MaxUnavailableZones]
+	}
+	excluded := lo.SliceToMap(others, func(z string) (string, struct{}) { return z, struct{}{} })
+	instances := make([]ring.InstanceDesc, 0, len(replicationSet.Instances))
+	for _, instance := range replicationSet.Instances {
+		if _, ok := excluded[instance.Zone]; !ok {
+			instances = append(instances, instance)
+		}
+	}
+	replicationSet.Instances = instances
+	replicationSet.MaxUnavailableZones -= len(excluded)
+	return replicationSet, true
+}
+
+func doUntilQuorum[Result any, Querier any](ctx context.Context, clientFactory func(string) (Querier, error), replicationSet ring.ReplicationSet, f QueryReplicaFn[Result, Querier]) ([]ResponseFromReplica[Result], error) {
 	results, err := ring.DoUntilQuorumWithoutSuccessfulContextCancellation(
 		ctx,
 		replicationSet,
@@ -0,0 +1,45 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_preferZone(t *testing.T) {
+	rs := ring.ReplicationSet{
+		Instances: []ring.InstanceDesc{
+			{Addr: "a-1", Zone: "a"},
+			{Addr: "a-2", Zone: "a"},
+			{Addr: "b-1", Zone: "b"},
+			{Addr: "b-2", Zone: "b"},
+			{Addr: "c-1", Zone: "c"},
+			{Addr: "c-2", Zone: "c"},
+		},
+		MaxUnavailableZones: 1,
+	}
+
+	_, ok := preferZone(rs, "")
+	require.False(t, ok)
+	_, ok = preferZone(rs, "d")
+	require.False(t, ok)
+	_, ok = preferZone(ring.ReplicationSet{Instances: rs.Instances, MaxErrors: 1}, "a")
+	require.False(t, ok, "replica set not zone-aware")
+
+	for i := 0; i < 10; i++ {
+		preferred, ok := preferZone(rs, "b")
+		require.True(t, ok)
+		require.Zero(t, preferred.MaxUnavailableZones)
+		require.Len(t, preferred.Instances, 4)
+		zones := make(map[string]int)
+		for _, instance := range preferred.Instances {
+			zones[instance.Zone]++
+		}
+		require.Len(t, zones, 2)
+		require.Equal(t, 2, zones["b"])
+	}
+	// The replica set passed in is not modified.
+	require.Len(t, rs.Instances, 6)
+	require.Equal(t, 1, rs.MaxUnavailableZones)
+}
@@ -0,0 +1,89 @@
+package querier
+
+import (
+	"context"
+	"math"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+const (
+	// maxFunctionSeriesPoints limits the number of points of a function
+	// series: each of them is computed from a merge of the step profiles.
+	maxFunctionSeriesPoints = 500
+	// functionSeriesConcurrency is the number of step merges run at once.
+	functionSeriesConcurrency = 8
+)
+
+func (q *Querier) SelectFunctionSeries(ctx context.Context, req *connect.Request[querierv1.SelectFunctionSeriesRequest]) (*connect.Response[querierv1.SelectFunctionSeriesResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectFunctionSeries")
+	defer sp.Finish()
+
+	if req.Msg.FunctionName == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("function name is required"))
+	}
+	if req.Msg.Step <= 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("step must be positive"))
+	}
+	if req.Msg.End <= req.Msg.Start {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("end must be after start"))
+	}
+	stepMs := int64(math.Ceil(req.Msg.Step * 1000))
+	points := (req.Msg.End - req.Msg.Start + stepMs - 1) / stepMs
+	if points > maxFunctionSeriesPoints {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			errors.Errorf("the query would return %d points, the maximum is %d: increase the step", points, maxFunctionSeriesPoints))
+	}
+
+	self := make([]*typesv1.Point, points)
+	total := make([]*typesv1.Point, points)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(functionSeriesConcurrency)
+	for i := int64(0); i < points; i++ {
+		i := i
+		start := req.Msg.Start + i*stepMs
+		end := start + stepMs
+		if end > req.Msg.End {
+			end = req.Msg.End
+		}
+		g.Go(func() error {
+			t, err := q.selectTree(gCtx, &querierv1.SelectMergeStacktracesRequest{
+				ProfileTypeID: req.Msg.ProfileTypeID,
+				LabelSelector: req.Msg.LabelSelector,
+				Start:         start,
+				End:           end,
+			})
+			if err != nil {
+				return err
+			}
+			s, tot := phlaremodel.FunctionValues(t, req.Msg.FunctionName)
+			self[i] = &typesv1.Point{Timestamp: start, Value: float64(s)}
+			total[i] = &typesv1.Point{Timestamp: start, Value: float64(tot)}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := &querierv1.SelectFunctionSeriesResponse{
+		Self:  make([]*typesv1.Point, 0, points),
+		Total: make([]*typesv1.Point, 0, points),
+	}
+	for i := range total {
+		// Steps without any stack calling the function are omitted.
+		if total[i].Value == 0 {
+			continue
+		}
+		res.Self = append(res.Self, self[i])
+		res.Total = append(res.Total, total[i])
+	}
+	return connect.NewResponse(res), nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"time"
 
 	"github.com/google/pprof/profile"
 	"github.com/grafana/dskit/multierror"
@@ -18,10 +19,12 @@ import (
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/billing"
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/loser"
 )
@@ -218,12 +221,11 @@ func (s *mergeIterator[R, Req, Res]) Close() error {
 	return errs.Err()
 }
 
-// skipDuplicates iterates through the iterator and skip duplicates.
-func skipDuplicates(ctx context.Context, its []MergeIterator) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "skipDuplicates")
-	defer span.Finish()
-	var errors multierror.MultiError
-	tree := loser.New(its,
+// newProfilesTree returns a tree iterating through the profiles of all the
+// iterators, ordered by timestamp and labels. Errors closing the iterators
+// are added to errors.
+func newProfilesTree(its []MergeIterator, errors *multierror.MultiError) *loser.Tree[*ProfileWithLabels, MergeIterator] {
+	return loser.New(its,
 		&ProfileWithLabels{
 			Timestamp: math.MaxInt64,
 		},
@@ -241,7 +243,14 @@ func skipDuplicates(ctx context.Context, its []MergeIterator) error {
 				errors.Add(err)
 			}
 		})
+}
 
+// skipDuplicates iterates through the iterator and skip duplicates.
+func skipDuplicates(ctx context.Context, its []MergeIterator) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "skipDuplicates")
+	defer span.Finish()
+	var errors multierror.MultiError
+	tree := newProfilesTree(its, &errors)
 	defer tree.Close()
 	duplicates := 0
 	total := 0
@@ -308,6 +317,17 @@ func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[client
 			if err != nil || result == nil {
 				return err
 			}
+			if result.Partial {
+				markPartialResults(ctx)
+			}
+			addQueriedBlocks(ctx, result.QueriedBlocks)
+			st := stats.FromContext(ctx)
+			st.AddFetchedBlocks(result.FetchedBlocksCount)
+			st.AddFetchedProfiles(result.FetchedProfilesCount)
+			st.AddFetchedSymbolBytes(result.FetchedSymbolBytes)
+			billing.AddQueriedBytes(ctx, result.FetchedSymbolBytes)
+			st.AddSelectProfilesTime(time.Duration(result.SelectProfilesTime))
+			st.AddMergeStacktracesTime(time.Duration(result.MergeStacktracesTime))
 			switch result.Format {
 			default:
 				return fmt.Errorf("unknown merge result format")
@@ -334,8 +354,14 @@ func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[client
 	return m.Tree(), nil
 }
 
-// selectMergePprofProfile selects the  profile from each ingester by deduping them and request merges of stacktraces in the pprof format.
-func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof]) (*googlev1.Profile, error) {
+// selectMergePprofProfile selects the  profile from each ingester with selectProfiles, usually by deduping them
+// with skipDuplicates, and request merges of stacktraces in the pprof format.
+func selectMergePprofProfile(
+	ctx context.Context,
+	ty *typesv1.ProfileType,
+	responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof],
+	selectProfiles func(context.Context, []MergeIterator) error,
+) (*googlev1.Profile, error) {
 	mergeResults := make([]MergeResult[[]byte], len(responses))
 	iters := make([]MergeIterator, len(responses))
 	var wg sync.WaitGroup
@@ -354,7 +380,7 @@ func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, respo
 	}
 	wg.Wait()
 
-	if err := skipDuplicates(ctx, iters); err != nil {
+	if err := selectProfiles(ctx, iters); err != nil {
 		return nil, err
 	}
 
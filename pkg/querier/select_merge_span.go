@@ -0,0 +1,188 @@
+package querier
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util/spanlogger"
+)
+
+// SelectMergeSpanProfile returns the flame graph of the samples attributed
+// to the given spans, to all the spans of the given trace, or to the given
+// endpoint.
+func (q *Querier) SelectMergeSpanProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeSpanProfileRequest]) (*connect.Response[querierv1.SelectMergeSpanProfileResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectMergeSpanProfile")
+	level.Info(spanlogger.FromContext(ctx, q.logger)).Log(
+		"start", model.Time(req.Msg.Start).Time().String(),
+		"end", model.Time(req.Msg.End).Time().String(),
+		"selector", req.Msg.LabelSelector,
+		"profile_id", req.Msg.ProfileTypeID,
+		"spans", len(req.Msg.SpanSelector),
+		"trace_id", req.Msg.TraceId,
+		"endpoint", req.Msg.Endpoint,
+	)
+	defer sp.Finish()
+
+	if len(req.Msg.SpanSelector) == 0 && req.Msg.TraceId == "" && req.Msg.Endpoint == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("either span selector, trace ID or endpoint must be specified"))
+	}
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if _, err = parser.ParseMetricSelector(req.Msg.LabelSelector); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if req.Msg.MaxNodes == nil || *req.Msg.MaxNodes == 0 {
+		mn := maxNodesDefault
+		req.Msg.MaxNodes = &mn
+	}
+
+	profiles, err := q.selectSpanProfiles(ctx, req.Msg, profileType)
+	if err != nil {
+		return nil, err
+	}
+	t, err := mergeSpanProfiles(profiles)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&querierv1.SelectMergeSpanProfileResponse{
+		Flamegraph: phlaremodel.NewFlameGraph(t, req.Msg.GetMaxNodes()),
+	}), nil
+}
+
+func (q *Querier) selectSpanProfiles(ctx context.Context, req *querierv1.SelectMergeSpanProfileRequest, profileType *typesv1.ProfileType) ([]*ingestv1.SpanProfile, error) {
+	if q.storeGatewayQuerier == nil {
+		return q.selectSpanProfilesFromIngesters(ctx, spanProfileRequest(req, profileType, model.Time(req.Start), model.Time(req.End)))
+	}
+
+	storeQueries := splitQueryToStores(model.Time(req.Start), model.Time(req.End), model.Now(), q.cfg.QueryStoreAfter)
+	if !storeQueries.ingester.shouldQuery && !storeQueries.storeGateway.shouldQuery {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start and end time are outside of the ingester and store gateway retention"))
+	}
+	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
+
+	var ingesterProfiles, storeGatewayProfiles []*ingestv1.SpanProfile
+	g, ctx := errgroup.WithContext(ctx)
+	if storeQueries.ingester.shouldQuery {
+		g.Go(func() error {
+			var err error
+			ingesterProfiles, err = q.selectSpanProfilesFromIngesters(ctx, storeQueries.ingester.MergeSpanProfileRequest(req, profileType))
+			return err
+		})
+	}
+	if storeQueries.storeGateway.shouldQuery {
+		g.Go(func() error {
+			var err error
+			storeGatewayProfiles, err = q.selectSpanProfilesFromStoreGateway(ctx, storeQueries.storeGateway.MergeSpanProfileRequest(req, profileType))
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return append(ingesterProfiles, storeGatewayProfiles...), nil
+}
+
+func (sq storeQuery) MergeSpanProfileRequest(req *querierv1.SelectMergeSpanProfileRequest, profileType *typesv1.ProfileType) *ingestv1.MergeSpanProfileRequest {
+	return spanProfileRequest(req, profileType, sq.start, sq.end)
+}
+
+func spanProfileRequest(req *querierv1.SelectMergeSpanProfileRequest, profileType *typesv1.ProfileType, start, end model.Time) *ingestv1.MergeSpanProfileRequest {
+	return &ingestv1.MergeSpanProfileRequest{
+		Request: &ingestv1.SelectProfilesRequest{
+			Type:          profileType,
+			LabelSelector: req.LabelSelector,
+			Start:         int64(start),
+			End:           int64(end),
+		},
+		SpanSelector: req.SpanSelector,
+		TraceId:      req.TraceId,
+		Endpoint:     req.Endpoint,
+	}
+}
+
+func (q *Querier) selectSpanProfilesFromIngesters(ctx context.Context, req *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectSpanProfiles Ingesters")
+	defer sp.Finish()
+
+	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(ctx context.Context, ic IngesterQueryClient) ([]*ingestv1.SpanProfile, error) {
+		res, err := ic.MergeSpanProfile(ctx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg.Profiles, nil
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return flattenSpanProfiles(responses), nil
+}
+
+func (q *Querier) selectSpanProfilesFromStoreGateway(ctx context.Context, req *ingestv1.MergeSpanProfileRequest) ([]*ingestv1.SpanProfile, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectSpanProfiles StoreGateway")
+	defer sp.Finish()
+
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	responses, err := forAllStoreGateways(ctx, tenantID, q.storeGatewayQuerier, func(ctx context.Context, ic StoreGatewayQueryClient) ([]*ingestv1.SpanProfile, error) {
+		res, err := ic.MergeSpanProfile(ctx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg.Profiles, nil
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return flattenSpanProfiles(responses), nil
+}
+
+func flattenSpanProfiles(responses []ResponseFromReplica[[]*ingestv1.SpanProfile]) []*ingestv1.SpanProfile {
+	var profiles []*ingestv1.SpanProfile
+	for _, r := range responses {
+		profiles = append(profiles, r.response...)
+	}
+	return profiles
+}
+
+type spanProfileKey struct {
+	profileID   string
+	fingerprint uint64
+	spanID      string
+	endpoint    string
+}
+
+// mergeSpanProfiles merges the span profiles into a single tree. Profiles
+// returned by multiple replicas are only accounted once.
+func mergeSpanProfiles(profiles []*ingestv1.SpanProfile) (*phlaremodel.Tree, error) {
+	seen := make(map[spanProfileKey]struct{}, len(profiles))
+	t := new(phlaremodel.Tree)
+	for _, p := range profiles {
+		k := spanProfileKey{profileID: p.ProfileId, fingerprint: p.SeriesFingerprint, spanID: p.SpanId, endpoint: p.Endpoint}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		pt, err := phlaremodel.UnmarshalTree(p.TreeBytes)
+		if err != nil {
+			return nil, err
+		}
+		t.Merge(pt)
+	}
+	return t, nil
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderName is the name of the response header carrying the statistics of
+// the query execution.
+const HeaderName = "Pyroscope-Query-Stats"
+
+// SetHeader sets the response header to the statistics, formatted as a list
+// of comma-separated key=value pairs. Durations are the sums over all the
+// partial queries and data nodes, therefore they may exceed the query time.
+func (s *Stats) SetHeader(h http.Header) {
+	if s == nil {
+		return
+	}
+	h.Set(HeaderName, s.headerValue())
+}
+
+func (s *Stats) headerValue() string {
+	var b strings.Builder
+	add := func(k, v string) {
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(v)
+	}
+	add("blocks", strconv.FormatUint(s.LoadFetchedBlocks(), 10))
+	add("profiles", strconv.FormatUint(s.LoadFetchedProfiles(), 10))
+	add("symbol_bytes", strconv.FormatUint(s.LoadFetchedSymbolBytes(), 10))
+	add("split_queries", strconv.FormatUint(uint64(s.LoadSplitQueries()), 10))
+	add("sharded_queries", strconv.FormatUint(uint64(s.LoadShardedQueries()), 10))
+	hits, misses := s.LoadResultsCacheHits(), s.LoadResultsCacheMisses()
+	if lookups := hits + misses; lookups > 0 {
+		add("results_cache_hit_ratio", fmt.Sprintf("%.2f", float64(hits)/float64(lookups)))
+	}
+	add("querier_time", formatDuration(s.LoadWallTime()))
+	add("select_profiles_time", formatDuration(s.LoadSelectProfilesTime()))
+	add("merge_stacktraces_time", formatDuration(s.LoadMergeStacktracesTime()))
+	return b.String()
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
@@ -155,6 +155,118 @@ func (s *Stats) LoadSplitQueries() uint32 {
 	return atomic.LoadUint32(&s.SplitQueries)
 }
 
+func (s *Stats) AddFetchedBlocks(n uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.FetchedBlocksCount, n)
+}
+
+func (s *Stats) LoadFetchedBlocks() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.FetchedBlocksCount)
+}
+
+func (s *Stats) AddFetchedProfiles(n uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.FetchedProfilesCount, n)
+}
+
+func (s *Stats) LoadFetchedProfiles() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.FetchedProfilesCount)
+}
+
+func (s *Stats) AddFetchedSymbolBytes(n uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.FetchedSymbolBytes, n)
+}
+
+func (s *Stats) LoadFetchedSymbolBytes() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.FetchedSymbolBytes)
+}
+
+func (s *Stats) AddResultsCacheHits(n uint32) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint32(&s.ResultsCacheHits, n)
+}
+
+func (s *Stats) LoadResultsCacheHits() uint32 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint32(&s.ResultsCacheHits)
+}
+
+func (s *Stats) AddResultsCacheMisses(n uint32) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint32(&s.ResultsCacheMisses, n)
+}
+
+func (s *Stats) LoadResultsCacheMisses() uint32 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint32(&s.ResultsCacheMisses)
+}
+
+func (s *Stats) AddSelectProfilesTime(t time.Duration) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddInt64(&s.SelectProfilesTime, int64(t))
+}
+
+func (s *Stats) LoadSelectProfilesTime() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadInt64(&s.SelectProfilesTime))
+}
+
+func (s *Stats) AddMergeStacktracesTime(t time.Duration) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddInt64(&s.MergeStacktracesTime, int64(t))
+}
+
+func (s *Stats) LoadMergeStacktracesTime() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadInt64(&s.MergeStacktracesTime))
+}
+
 // Merge the provided Stats into this one.
 func (s *Stats) Merge(other *Stats) {
 	if s == nil || other == nil {
@@ -168,6 +280,13 @@ func (s *Stats) Merge(other *Stats) {
 	s.AddShardedQueries(other.LoadShardedQueries())
 	s.AddSplitQueries(other.LoadSplitQueries())
 	s.AddFetchedIndexBytes(other.LoadFetchedIndexBytes())
+	s.AddFetchedBlocks(other.LoadFetchedBlocks())
+	s.AddFetchedProfiles(other.LoadFetchedProfiles())
+	s.AddFetchedSymbolBytes(other.LoadFetchedSymbolBytes())
+	s.AddResultsCacheHits(other.LoadResultsCacheHits())
+	s.AddResultsCacheMisses(other.LoadResultsCacheMisses())
+	s.AddSelectProfilesTime(other.LoadSelectProfilesTime())
+	s.AddMergeStacktracesTime(other.LoadMergeStacktracesTime())
 }
 
 func ShouldTrackHTTPGRPCResponse(r *httpgrpc.HTTPResponse) bool {
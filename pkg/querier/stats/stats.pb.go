@@ -44,6 +44,20 @@ type Stats struct {
 	SplitQueries uint32 `protobuf:"varint,6,opt,name=split_queries,json=splitQueries,proto3" json:"split_queries,omitempty"`
 	// The number of index bytes fetched on the store-gateway for the query
 	FetchedIndexBytes uint64 `protobuf:"varint,7,opt,name=fetched_index_bytes,json=fetchedIndexBytes,proto3" json:"fetched_index_bytes,omitempty"`
+	// The number of blocks queried on ingesters and store-gateways
+	FetchedBlocksCount uint64 `protobuf:"varint,8,opt,name=fetched_blocks_count,json=fetchedBlocksCount,proto3" json:"fetched_blocks_count,omitempty"`
+	// The number of profiles read from the blocks
+	FetchedProfilesCount uint64 `protobuf:"varint,9,opt,name=fetched_profiles_count,json=fetchedProfilesCount,proto3" json:"fetched_profiles_count,omitempty"`
+	// The number of bytes of stack traces fetched from the symbols storage
+	FetchedSymbolBytes uint64 `protobuf:"varint,10,opt,name=fetched_symbol_bytes,json=fetchedSymbolBytes,proto3" json:"fetched_symbol_bytes,omitempty"`
+	// The number of partial queries served from the query-frontend results cache
+	ResultsCacheHits uint32 `protobuf:"varint,11,opt,name=results_cache_hits,json=resultsCacheHits,proto3" json:"results_cache_hits,omitempty"`
+	// The number of cacheable partial queries not found in the results cache
+	ResultsCacheMisses uint32 `protobuf:"varint,12,opt,name=results_cache_misses,json=resultsCacheMisses,proto3" json:"results_cache_misses,omitempty"`
+	// The sum of time spent selecting profiles on ingesters and store-gateways
+	SelectProfilesTime int64 `protobuf:"varint,13,opt,name=select_profiles_time,json=selectProfilesTime,proto3" json:"select_profiles_time,omitempty"`
+	// The sum of time spent merging stack traces on ingesters and store-gateways
+	MergeStacktracesTime int64 `protobuf:"varint,14,opt,name=merge_stacktraces_time,json=mergeStacktracesTime,proto3" json:"merge_stacktraces_time,omitempty"`
 }
 
 func (x *Stats) Reset() {
@@ -127,12 +141,61 @@ func (x *Stats) GetFetchedIndexBytes() uint64 {
 	return 0
 }
 
+func (x *Stats) GetFetchedBlocksCount() uint64 {
+	if x != nil {
+		return x.FetchedBlocksCount
+	}
+	return 0
+}
+
+func (x *Stats) GetFetchedProfilesCount() uint64 {
+	if x != nil {
+		return x.FetchedProfilesCount
+	}
+	return 0
+}
+
+func (x *Stats) GetFetchedSymbolBytes() uint64 {
+	if x != nil {
+		return x.FetchedSymbolBytes
+	}
+	return 0
+}
+
+func (x *Stats) GetResultsCacheHits() uint32 {
+	if x != nil {
+		return x.ResultsCacheHits
+	}
+	return 0
+}
+
+func (x *Stats) GetResultsCacheMisses() uint32 {
+	if x != nil {
+		return x.ResultsCacheMisses
+	}
+	return 0
+}
+
+func (x *Stats) GetSelectProfilesTime() int64 {
+	if x != nil {
+		return x.SelectProfilesTime
+	}
+	return 0
+}
+
+func (x *Stats) GetMergeStacktracesTime() int64 {
+	if x != nil {
+		return x.MergeStacktracesTime
+	}
+	return 0
+}
+
 var File_querier_stats_stats_proto protoreflect.FileDescriptor
 
 var file_querier_stats_stats_proto_rawDesc = []byte{
 	0x0a, 0x19, 0x71, 0x75, 0x65, 0x72, 0x69, 0x65, 0x72, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2f,
 	0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x73, 0x74, 0x61,
-	0x74, 0x73, 0x22, 0xb6, 0x02, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x1b, 0x0a, 0x09,
+	0x74, 0x73, 0x22, 0x98, 0x05, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x1b, 0x0a, 0x09,
 	0x77, 0x61, 0x6c, 0x6c, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
 	0x08, 0x77, 0x61, 0x6c, 0x6c, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x30, 0x0a, 0x14, 0x66, 0x65, 0x74,
 	0x63, 0x68, 0x65, 0x64, 0x5f, 0x73, 0x65, 0x72, 0x69, 0x65, 0x73, 0x5f, 0x63, 0x6f, 0x75, 0x6e,
@@ -151,15 +214,38 @@ var file_querier_stats_stats_proto_rawDesc = []byte{
 	0x70, 0x6c, 0x69, 0x74, 0x51, 0x75, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x2e, 0x0a, 0x13, 0x66,
 	0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x5f, 0x62, 0x79, 0x74,
 	0x65, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x04, 0x52, 0x11, 0x66, 0x65, 0x74, 0x63, 0x68, 0x65,
-	0x64, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x42, 0x79, 0x74, 0x65, 0x73, 0x42, 0x7b, 0x0a, 0x09, 0x63,
-	0x6f, 0x6d, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x42, 0x0a, 0x53, 0x74, 0x61, 0x74, 0x73, 0x50,
-	0x72, 0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
-	0x6f, 0x6d, 0x2f, 0x67, 0x72, 0x61, 0x66, 0x61, 0x6e, 0x61, 0x2f, 0x70, 0x79, 0x72, 0x6f, 0x73,
-	0x63, 0x6f, 0x70, 0x65, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x71, 0x75, 0x65, 0x72, 0x69, 0x65, 0x72,
-	0x2f, 0x73, 0x74, 0x61, 0x74, 0x73, 0xa2, 0x02, 0x03, 0x53, 0x58, 0x58, 0xaa, 0x02, 0x05, 0x53,
-	0x74, 0x61, 0x74, 0x73, 0xca, 0x02, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0xe2, 0x02, 0x11, 0x53,
-	0x74, 0x61, 0x74, 0x73, 0x5c, 0x47, 0x50, 0x42, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61,
-	0xea, 0x02, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x64, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x30, 0x0a, 0x14, 0x66,
+	0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x5f, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x12, 0x66, 0x65, 0x74, 0x63, 0x68,
+	0x65, 0x64, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x34, 0x0a,
+	0x16, 0x66, 0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x70, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65,
+	0x73, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x04, 0x52, 0x14, 0x66,
+	0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x12, 0x30, 0x0a, 0x14, 0x66, 0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x73,
+	0x79, 0x6d, 0x62, 0x6f, 0x6c, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x12, 0x66, 0x65, 0x74, 0x63, 0x68, 0x65, 0x64, 0x53, 0x79, 0x6d, 0x62, 0x6f, 0x6c,
+	0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x2c, 0x0a, 0x12, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73,
+	0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x68, 0x69, 0x74, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x10, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x43, 0x61, 0x63, 0x68, 0x65, 0x48,
+	0x69, 0x74, 0x73, 0x12, 0x30, 0x0a, 0x14, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x5f, 0x63,
+	0x61, 0x63, 0x68, 0x65, 0x5f, 0x6d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x18, 0x0c, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x12, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x43, 0x61, 0x63, 0x68, 0x65, 0x4d,
+	0x69, 0x73, 0x73, 0x65, 0x73, 0x12, 0x30, 0x0a, 0x14, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x5f,
+	0x70, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x0d, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x12, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x66, 0x69,
+	0x6c, 0x65, 0x73, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x34, 0x0a, 0x16, 0x6d, 0x65, 0x72, 0x67, 0x65,
+	0x5f, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x74, 0x72, 0x61, 0x63, 0x65, 0x73, 0x5f, 0x74, 0x69, 0x6d,
+	0x65, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x14, 0x6d, 0x65, 0x72, 0x67, 0x65, 0x53, 0x74,
+	0x61, 0x63, 0x6b, 0x74, 0x72, 0x61, 0x63, 0x65, 0x73, 0x54, 0x69, 0x6d, 0x65, 0x42, 0x7b, 0x0a,
+	0x09, 0x63, 0x6f, 0x6d, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x73, 0x42, 0x0a, 0x53, 0x74, 0x61, 0x74,
+	0x73, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x67, 0x72, 0x61, 0x66, 0x61, 0x6e, 0x61, 0x2f, 0x70, 0x79, 0x72,
+	0x6f, 0x73, 0x63, 0x6f, 0x70, 0x65, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x71, 0x75, 0x65, 0x72, 0x69,
+	0x65, 0x72, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x73, 0xa2, 0x02, 0x03, 0x53, 0x58, 0x58, 0xaa, 0x02,
+	0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0xca, 0x02, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0xe2, 0x02,
+	0x11, 0x53, 0x74, 0x61, 0x74, 0x73, 0x5c, 0x47, 0x50, 0x42, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0xea, 0x02, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
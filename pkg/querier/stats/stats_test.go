@@ -7,6 +7,7 @@ package stats
 
 import (
 	"context"
+	"net/http"
 	"testing"
 	"time"
 
@@ -124,6 +125,9 @@ func TestStats_Merge(t *testing.T) {
 		stats1.AddFetchedChunks(10)
 		stats1.AddShardedQueries(20)
 		stats1.AddSplitQueries(10)
+		stats1.AddFetchedBlocks(2)
+		stats1.AddResultsCacheHits(1)
+		stats1.AddSelectProfilesTime(time.Millisecond)
 
 		stats2 := &Stats{}
 		stats2.AddWallTime(time.Second)
@@ -132,6 +136,9 @@ func TestStats_Merge(t *testing.T) {
 		stats2.AddFetchedChunks(11)
 		stats2.AddShardedQueries(21)
 		stats2.AddSplitQueries(11)
+		stats2.AddFetchedBlocks(3)
+		stats2.AddResultsCacheHits(2)
+		stats2.AddSelectProfilesTime(time.Second)
 
 		stats1.Merge(stats2)
 
@@ -141,6 +148,9 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint64(21), stats1.LoadFetchedChunks())
 		assert.Equal(t, uint32(41), stats1.LoadShardedQueries())
 		assert.Equal(t, uint32(21), stats1.LoadSplitQueries())
+		assert.Equal(t, uint64(5), stats1.LoadFetchedBlocks())
+		assert.Equal(t, uint32(3), stats1.LoadResultsCacheHits())
+		assert.Equal(t, 1001*time.Millisecond, stats1.LoadSelectProfilesTime())
 	})
 
 	t.Run("merge two nil stats objects", func(t *testing.T) {
@@ -157,3 +167,27 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint32(0), stats1.LoadSplitQueries())
 	})
 }
+
+func TestStats_SetHeader(t *testing.T) {
+	stats := &Stats{}
+	stats.AddFetchedBlocks(3)
+	stats.AddFetchedProfiles(100)
+	stats.AddFetchedSymbolBytes(4096)
+	stats.AddSplitQueries(2)
+	stats.AddResultsCacheHits(1)
+	stats.AddResultsCacheMisses(3)
+	stats.AddWallTime(1500 * time.Millisecond)
+	stats.AddSelectProfilesTime(time.Second)
+	stats.AddMergeStacktracesTime(250 * time.Millisecond)
+
+	h := make(http.Header)
+	stats.SetHeader(h)
+	assert.Equal(t, "blocks=3, profiles=100, symbol_bytes=4096, split_queries=2, sharded_queries=0, "+
+		"results_cache_hit_ratio=0.25, querier_time=1.5s, select_profiles_time=1s, merge_stacktraces_time=250ms",
+		h.Get(HeaderName))
+
+	var nilStats *Stats
+	h = make(http.Header)
+	nilStats.SetHeader(h)
+	assert.Empty(t, h)
+}
@@ -48,6 +48,41 @@ func (m *Stats) MarshalToSizedBufferVT(dAtA []byte) (int, error) {
 		i -= len(m.unknownFields)
 		copy(dAtA[i:], m.unknownFields)
 	}
+	if m.MergeStacktracesTime != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.MergeStacktracesTime))
+		i--
+		dAtA[i] = 0x70
+	}
+	if m.SelectProfilesTime != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.SelectProfilesTime))
+		i--
+		dAtA[i] = 0x68
+	}
+	if m.ResultsCacheMisses != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.ResultsCacheMisses))
+		i--
+		dAtA[i] = 0x60
+	}
+	if m.ResultsCacheHits != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.ResultsCacheHits))
+		i--
+		dAtA[i] = 0x58
+	}
+	if m.FetchedSymbolBytes != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.FetchedSymbolBytes))
+		i--
+		dAtA[i] = 0x50
+	}
+	if m.FetchedProfilesCount != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.FetchedProfilesCount))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.FetchedBlocksCount != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.FetchedBlocksCount))
+		i--
+		dAtA[i] = 0x40
+	}
 	if m.FetchedIndexBytes != 0 {
 		i = encodeVarint(dAtA, i, uint64(m.FetchedIndexBytes))
 		i--
@@ -124,6 +159,27 @@ func (m *Stats) SizeVT() (n int) {
 	if m.FetchedIndexBytes != 0 {
 		n += 1 + sov(uint64(m.FetchedIndexBytes))
 	}
+	if m.FetchedBlocksCount != 0 {
+		n += 1 + sov(uint64(m.FetchedBlocksCount))
+	}
+	if m.FetchedProfilesCount != 0 {
+		n += 1 + sov(uint64(m.FetchedProfilesCount))
+	}
+	if m.FetchedSymbolBytes != 0 {
+		n += 1 + sov(uint64(m.FetchedSymbolBytes))
+	}
+	if m.ResultsCacheHits != 0 {
+		n += 1 + sov(uint64(m.ResultsCacheHits))
+	}
+	if m.ResultsCacheMisses != 0 {
+		n += 1 + sov(uint64(m.ResultsCacheMisses))
+	}
+	if m.SelectProfilesTime != 0 {
+		n += 1 + sov(uint64(m.SelectProfilesTime))
+	}
+	if m.MergeStacktracesTime != 0 {
+		n += 1 + sov(uint64(m.MergeStacktracesTime))
+	}
 	n += len(m.unknownFields)
 	return n
 }
@@ -296,6 +352,139 @@ func (m *Stats) UnmarshalVT(dAtA []byte) error {
 					break
 				}
 			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedBlocksCount", wireType)
+			}
+			m.FetchedBlocksCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedBlocksCount |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedProfilesCount", wireType)
+			}
+			m.FetchedProfilesCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedProfilesCount |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedSymbolBytes", wireType)
+			}
+			m.FetchedSymbolBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedSymbolBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultsCacheHits", wireType)
+			}
+			m.ResultsCacheHits = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ResultsCacheHits |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultsCacheMisses", wireType)
+			}
+			m.ResultsCacheMisses = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ResultsCacheMisses |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SelectProfilesTime", wireType)
+			}
+			m.SelectProfilesTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SelectProfilesTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MergeStacktracesTime", wireType)
+			}
+			m.MergeStacktracesTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MergeStacktracesTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skip(dAtA[iNdEx:])
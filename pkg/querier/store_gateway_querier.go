@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/promql/parser"
 	"golang.org/x/sync/errgroup"
 
@@ -32,6 +33,7 @@ type StoreGatewayQueryClient interface {
 	MergeProfilesLabels(ctx context.Context) clientpool.BidiClientMergeProfilesLabels
 	MergeProfilesPprof(ctx context.Context) clientpool.BidiClientMergeProfilesPprof
 	Series(context.Context, *connect.Request[ingestv1.SeriesRequest]) (*connect.Response[ingestv1.SeriesResponse], error)
+	MergeSpanProfile(context.Context, *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error)
 }
 
 type StoreGatewayLimits interface {
@@ -39,9 +41,10 @@ type StoreGatewayLimits interface {
 }
 
 type StoreGatewayQuerier struct {
-	ring   ring.ReadRing
-	pool   *ring_client.Pool
-	limits StoreGatewayLimits
+	ring          ring.ReadRing
+	pool          *ring_client.Pool
+	limits        StoreGatewayLimits
+	preferredZone string
 
 	services.Service
 	// Subservices manager.
@@ -53,6 +56,7 @@ func NewStoreGatewayQuerier(
 	gatewayCfg storegateway.Config,
 	factory ring_client.PoolFactory,
 	limits StoreGatewayLimits,
+	preferredZone string,
 	logger log.Logger,
 	reg prometheus.Registerer,
 	clientsOptions ...connect.ClientOption,
@@ -85,6 +89,7 @@ func NewStoreGatewayQuerier(
 		ring:               storesRing,
 		pool:               pool,
 		limits:             limits,
+		preferredZone:      preferredZone,
 		subservicesWatcher: services.NewFailureWatcher(),
 	}
 	s.subservices, err = services.NewManager(storesRing, pool)
@@ -135,7 +140,7 @@ func forAllStoreGateways[T any](ctx context.Context, tenantID string, storegatew
 			return nil, err
 		}
 		return client.(StoreGatewayQueryClient), nil
-	}, replicationSet, f)
+	}, replicationSet, storegatewayQuerier.preferredZone, f)
 }
 
 // GetShuffleShardingSubring returns the subring to be used for a given user. This function
@@ -169,6 +174,10 @@ func (q *Querier) selectTreeFromStoreGateway(ctx context.Context, req *querierv1
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	var queried *queriedBlocks
+	if q.blocksConsistency != nil {
+		ctx, queried = contextWithQueriedBlocks(ctx)
+	}
 
 	responses, err := forAllStoreGateways(ctx, tenantID, q.storeGatewayQuerier, func(ctx context.Context, ic StoreGatewayQueryClient) (clientpool.BidiClientMergeProfilesStacktraces, error) {
 		return ic.MergeProfilesStacktraces(ctx), nil
@@ -177,20 +186,12 @@ func (q *Querier) selectTreeFromStoreGateway(ctx context.Context, req *querierv1
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 	// send the first initial request to all ingesters.
+	mergeReq := q.mergeStacktracesRequest(ctx, req, profileType)
 	g, gCtx := errgroup.WithContext(ctx)
 	for _, r := range responses {
 		r := r
 		g.Go(util.RecoverPanic(func() error {
-			return r.response.Send(&ingestv1.MergeProfilesStacktracesRequest{
-				Request: &ingestv1.SelectProfilesRequest{
-					LabelSelector: req.LabelSelector,
-					Start:         req.Start,
-					End:           req.End,
-					Type:          profileType,
-				},
-				MaxNodes: req.MaxNodes,
-				// TODO(kolesnikovae): Max stacks.
-			})
+			return r.response.Send(mergeReq.CloneVT())
 		}))
 	}
 	if err = g.Wait(); err != nil {
@@ -198,7 +199,16 @@ func (q *Querier) selectTreeFromStoreGateway(ctx context.Context, req *querierv1
 	}
 
 	// merge all profiles
-	return selectMergeTree(gCtx, responses)
+	t, err := selectMergeTree(gCtx, responses)
+	if err != nil {
+		return nil, err
+	}
+	if queried != nil {
+		if err = q.blocksConsistency.check(ctx, tenantID, model.Time(req.Start), model.Time(req.End), queried, req.AllowPartialResults); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
 }
 
 func (q *Querier) selectSeriesFromStoreGateway(ctx context.Context, req *ingesterv1.MergeProfilesLabelsRequest) ([]ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels], error) {
@@ -0,0 +1,573 @@
+package tenantfederation
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/google/pprof/profile"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/common/model"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/util/math"
+)
+
+type Config struct {
+	Enabled           bool `yaml:"enabled"`
+	InjectTenantLabel bool `yaml:"inject_tenant_label"`
+	MaxConcurrent     int  `yaml:"max_concurrent"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "tenant-federation.enabled", false, "If enabled, queries can target multiple tenants, separated by '|' in the X-Scope-OrgID header, for example 'a|b|c'. The query runs for each tenant and the results are merged.")
+	f.BoolVar(&cfg.InjectTenantLabel, "tenant-federation.inject-tenant-label", false, "If enabled, the series of multi-tenant queries have a "+phlaremodel.LabelNameTenantID+" label set to the tenant they belong to, and the label is returned by the label names and values queries.")
+	f.IntVar(&cfg.MaxConcurrent, "tenant-federation.max-concurrent", 16, "Maximum number of tenants queried concurrently by a multi-tenant query.")
+}
+
+// NewQuerierService returns a querier service running the queries of
+// multiple tenants against svc for each tenant, and merging their results.
+// Single-tenant queries are passed through.
+func NewQuerierService(cfg Config, svc querierv1connect.QuerierServiceHandler) querierv1connect.QuerierServiceHandler {
+	return &querierService{cfg: cfg, svc: svc}
+}
+
+type querierService struct {
+	cfg Config
+	svc querierv1connect.QuerierServiceHandler
+}
+
+// forTenants calls fn for each tenant, with the tenant injected in the
+// context. The results are in the order of the tenants.
+func forTenants[T any](ctx context.Context, s *querierService, tenantIDs []string, fn func(context.Context) (*connect.Response[T], error)) ([]*T, error) {
+	results := make([]*T, len(tenantIDs))
+	g, ctx := errgroup.WithContext(ctx)
+	if s.cfg.MaxConcurrent > 0 {
+		g.SetLimit(s.cfg.MaxConcurrent)
+	}
+	for i, tenantID := range tenantIDs {
+		i, tenantID := i, tenantID
+		g.Go(func() error {
+			res, err := fn(user.InjectOrgID(ctx, tenantID))
+			if err != nil {
+				return err
+			}
+			results[i] = res.Msg
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// tenantResolver splits the tenant ID of the requests into the tenants
+// they target. The default resolver of dskit only supports single tenants.
+var tenantResolver = tenant.NewMultiResolver()
+
+// tenantIDs returns the tenants of the request, or nil if the request
+// does not target multiple tenants.
+func tenantIDs(ctx context.Context) []string {
+	ids, err := tenantResolver.TenantIDs(ctx)
+	if err != nil || len(ids) < 2 {
+		return nil
+	}
+	return ids
+}
+
+// tenantLimit returns the limit of the paginated requests sent to each
+// tenant: one more item than requested tells whether there is a next page.
+func tenantLimit(limit int64) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	return limit + 1
+}
+
+func (s *querierService) ProfileTypes(ctx context.Context, req *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.ProfileTypes(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.ProfileTypesResponse], error) {
+		return s.svc.ProfileTypes(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	profileTypes := lo.UniqBy(
+		lo.FlatMap(results, func(r *querierv1.ProfileTypesResponse, _ int) []*typesv1.ProfileType {
+			return r.ProfileTypes
+		}),
+		func(t *typesv1.ProfileType) string { return t.ID },
+	)
+	sort.Slice(profileTypes, func(i, j int) bool {
+		return profileTypes[i].ID < profileTypes[j].ID
+	})
+	return connect.NewResponse(&querierv1.ProfileTypesResponse{ProfileTypes: profileTypes}), nil
+}
+
+func (s *querierService) LabelValues(ctx context.Context, req *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.LabelValues(ctx, req)
+	}
+	var values []string
+	if s.cfg.InjectTenantLabel && req.Msg.Name == phlaremodel.LabelNameTenantID {
+		values = append(values, ids...)
+	} else {
+		results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[typesv1.LabelValuesResponse], error) {
+			r := req.Msg.CloneVT()
+			r.Limit = tenantLimit(req.Msg.Limit)
+			return s.svc.LabelValues(ctx, connectgrpc.CloneRequest(req, r))
+		})
+		if err != nil {
+			return nil, err
+		}
+		values = lo.Uniq(lo.FlatMap(results, func(r *typesv1.LabelValuesResponse, _ int) []string {
+			return r.Names
+		}))
+	}
+	sort.Strings(values)
+
+	var err error
+	res := new(typesv1.LabelValuesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(values, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *querierService) LabelNames(ctx context.Context, req *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.LabelNames(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[typesv1.LabelNamesResponse], error) {
+		r := req.Msg.CloneVT()
+		r.Limit = tenantLimit(req.Msg.Limit)
+		return s.svc.LabelNames(ctx, connectgrpc.CloneRequest(req, r))
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := lo.FlatMap(results, func(r *typesv1.LabelNamesResponse, _ int) []string {
+		return r.Names
+	})
+	if s.cfg.InjectTenantLabel {
+		names = append(names, phlaremodel.LabelNameTenantID)
+	}
+	names = lo.Uniq(names)
+	sort.Strings(names)
+
+	res := new(typesv1.LabelNamesResponse)
+	res.Names, res.NextPageToken, err = phlaremodel.PaginateStrings(names, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *querierService) Series(ctx context.Context, req *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.Series(ctx, req)
+	}
+	// The tenant label changes the order of the series, the pages are
+	// therefore cut after merging the series of all the tenants.
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SeriesResponse], error) {
+		r := req.Msg.CloneVT()
+		r.Limit, r.PageToken = 0, ""
+		return s.svc.Series(ctx, connectgrpc.CloneRequest(req, r))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var labelsSet []*typesv1.Labels
+	for i, r := range results {
+		for _, ls := range r.LabelsSet {
+			labelsSet = append(labelsSet, &typesv1.Labels{Labels: s.withTenantLabel(ls.Labels, ids[i])})
+		}
+	}
+	labelsSet = lo.UniqBy(labelsSet, func(t *typesv1.Labels) uint64 {
+		return phlaremodel.Labels(t.Labels).Hash()
+	})
+	sort.Slice(labelsSet, func(i, j int) bool {
+		return phlaremodel.CompareLabelPairs(labelsSet[i].Labels, labelsSet[j].Labels) < 0
+	})
+
+	res := new(querierv1.SeriesResponse)
+	res.LabelsSet, res.NextPageToken, err = phlaremodel.PaginateLabelsSet(labelsSet, req.Msg.Limit, req.Msg.PageToken)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *querierService) withTenantLabel(ls []*typesv1.LabelPair, tenantID string) []*typesv1.LabelPair {
+	if !s.cfg.InjectTenantLabel {
+		return ls
+	}
+	return phlaremodel.NewLabelsBuilder(ls).Set(phlaremodel.LabelNameTenantID, tenantID).Labels()
+}
+
+func (s *querierService) SelectMergeStacktraces(ctx context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectMergeStacktraces(ctx, req)
+	}
+	if req.Msg.Explain {
+		return s.explainSelectMergeStacktraces(ctx, ids, req)
+	}
+	// The statistics are merged into the enclosing ones, if any, e.g. the
+	// ones of the slow query log.
+	parent := stats.FromContext(ctx)
+	st, ctx := stats.ContextWithEmptyStats(ctx)
+	defer parent.Merge(st)
+	t, partial, err := s.selectTree(ctx, ids, req)
+	if err != nil {
+		return nil, err
+	}
+	res := connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+		Flamegraph: phlaremodel.NewFlameGraph(t, req.Msg.GetMaxNodes()),
+		Partial:    partial,
+	})
+	st.SetHeader(res.Header())
+	return res, nil
+}
+
+func (s *querierService) SelectMergeStacktracesStream(ctx context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest], stream *connect.ServerStream[querierv1.SelectMergeStacktracesResponse]) error {
+	res, err := s.SelectMergeStacktraces(ctx, req)
+	if err != nil {
+		return err
+	}
+	return connectgrpc.SendServerStream(stream, res.Header(), phlaremodel.SplitSelectMergeStacktracesResponse(res.Msg, phlaremodel.FlameGraphChunkNodes))
+}
+
+// explainSelectMergeStacktraces returns the plans of the query for each
+// of the tenants.
+func (s *querierService) explainSelectMergeStacktraces(ctx context.Context, ids []string, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+		return s.svc.SelectMergeStacktraces(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&b, "tenant %s:\n%s", ids[i], r.Plan)
+	}
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Plan: b.String()}), nil
+}
+
+// selectTree returns the tree merged across the tenants, and whether it
+// is partial for any of them.
+func (s *querierService) selectTree(ctx context.Context, ids []string, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*phlaremodel.Tree, bool, error) {
+	m := phlaremodel.NewFlameGraphMerger()
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+		res, err := s.svc.SelectMergeStacktraces(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+		if err == nil {
+			m.MergeFlameGraph(res.Msg.Flamegraph)
+		}
+		return res, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	var partial bool
+	for _, r := range results {
+		partial = partial || r.Partial
+	}
+	return m.Tree(), partial, nil
+}
+
+func (s *querierService) SelectMergeSpanProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeSpanProfileRequest]) (*connect.Response[querierv1.SelectMergeSpanProfileResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectMergeSpanProfile(ctx, req)
+	}
+	m := phlaremodel.NewFlameGraphMerger()
+	_, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectMergeSpanProfileResponse], error) {
+		res, err := s.svc.SelectMergeSpanProfile(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+		if err == nil {
+			m.MergeFlameGraph(res.Msg.Flamegraph)
+		}
+		return res, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&querierv1.SelectMergeSpanProfileResponse{
+		Flamegraph: m.FlameGraph(req.Msg.GetMaxNodes()),
+	}), nil
+}
+
+func (s *querierService) SelectMergeProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeProfileRequest]) (*connect.Response[profilev1.Profile], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectMergeProfile(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[profilev1.Profile], error) {
+		return s.svc.SelectMergeProfile(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.Msg.ClosestTo != 0 {
+		return connect.NewResponse(closestProfile(results, req.Msg.ClosestTo)), nil
+	}
+	profiles := make([]*profile.Profile, 0, len(results))
+	for _, r := range results {
+		if len(r.SampleType) == 0 {
+			// Empty result.
+			continue
+		}
+		b, err := r.MarshalVT()
+		if err != nil {
+			return nil, err
+		}
+		p, err := profile.ParseUncompressed(b)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	if len(profiles) == 0 {
+		return connect.NewResponse(&profilev1.Profile{}), nil
+	}
+	p, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	merged, err := pprof.FromProfile(p)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(merged), nil
+}
+
+// closestProfile returns the profile closest to the timestamp, in
+// milliseconds, of the profiles found in each tenant.
+func closestProfile(results []*profilev1.Profile, ts int64) *profilev1.Profile {
+	closest := &profilev1.Profile{}
+	for _, r := range results {
+		if len(r.Sample) == 0 {
+			continue
+		}
+		if len(closest.Sample) == 0 || distance(r.TimeNanos, ts) < distance(closest.TimeNanos, ts) {
+			closest = r
+		}
+	}
+	return closest
+}
+
+func distance(timeNanos, ts int64) int64 {
+	d := model.TimeFromUnixNano(timeNanos) - model.Time(ts)
+	if d < 0 {
+		return int64(-d)
+	}
+	return int64(d)
+}
+
+func (s *querierService) SelectSeries(ctx context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectSeries(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+		return s.svc.SelectSeries(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Without the tenant label, the series of the same labels are summed.
+	m := phlaremodel.NewSeriesMerger(true)
+	for i, r := range results {
+		for _, series := range r.Series {
+			series.Labels = s.withTenantLabel(series.Labels, ids[i])
+		}
+		m.MergeSeries(r.Series)
+	}
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: m.Series()}), nil
+}
+
+func (s *querierService) Diff(ctx context.Context, req *connect.Request[querierv1.DiffRequest]) (*connect.Response[querierv1.DiffResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.Diff(ctx, req)
+	}
+	if req.Msg.Left == nil || (req.Msg.Right == nil && len(req.Msg.RightProfile) == 0) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("left and right queries are required"))
+	}
+	var left, right *phlaremodel.Tree
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		left, _, err = s.selectTree(gCtx, ids, connect.NewRequest(req.Msg.Left))
+		return err
+	})
+	g.Go(func() (err error) {
+		if len(req.Msg.RightProfile) > 0 {
+			right, err = profileTree(req.Msg.RightProfile, req.Msg.Left)
+			return err
+		}
+		right, _, err = s.selectTree(gCtx, ids, connect.NewRequest(req.Msg.Right))
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	maxNodes := math.Max(req.Msg.Left.GetMaxNodes(), req.Msg.Right.GetMaxNodes())
+	if maxNodes == 0 {
+		maxNodes = phlaremodel.MaxNodes
+	}
+	fd, err := phlaremodel.NewFlamegraphDiff(left, right, int(maxNodes))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(&querierv1.DiffResponse{Flamegraph: fd}), nil
+}
+
+// profileTree returns the tree of an uploaded pprof profile, with the
+// sample type and the stacks options of the request it is compared to.
+func profileTree(b []byte, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	filter, err := phlaremodel.NewTreeFilter(req)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	var t *phlaremodel.Tree
+	err = pprof.FromBytes(b, func(p *profilev1.Profile, _ int) (err error) {
+		t, err = phlaremodel.TreeFromProfile(p, profileType)
+		return err
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid profile: %w", err))
+	}
+	return filter.Apply(t), nil
+}
+
+func (s *querierService) SelectTopFunctions(ctx context.Context, req *connect.Request[querierv1.SelectTopFunctionsRequest]) (*connect.Response[querierv1.SelectTopFunctionsResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectTopFunctions(ctx, req)
+	}
+	if req.Msg.GetLimit() < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("limit must not be negative"))
+	}
+	limit := int(req.Msg.GetLimit())
+	if limit == 0 {
+		limit = phlaremodel.DefaultTopFunctionsLimit
+	}
+	// The top functions of the tenants can't be merged: the functions are
+	// ranked on the merged profile instead.
+	p, err := s.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		ProfileTypeID: req.Msg.ProfileTypeID,
+		LabelSelector: req.Msg.LabelSelector,
+		Start:         req.Msg.Start,
+		End:           req.Msg.End,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(phlaremodel.NewTopFunctions(p.Msg, req.Msg.OrderBy, limit)), nil
+}
+
+func (s *querierService) SelectCallersCallees(ctx context.Context, req *connect.Request[querierv1.SelectCallersCalleesRequest]) (*connect.Response[querierv1.SelectCallersCalleesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectCallersCallees(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectCallersCalleesResponse], error) {
+		return s.svc.SelectCallersCallees(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := new(querierv1.SelectCallersCalleesResponse)
+	callers := make(map[string]int64)
+	callees := make(map[string]int64)
+	for _, r := range results {
+		res.Self += r.Self
+		res.Total += r.Total
+		for _, e := range r.Callers {
+			callers[e.Name] += e.Value
+		}
+		for _, e := range r.Callees {
+			callees[e.Name] += e.Value
+		}
+	}
+	res.Callers = callEdges(callers)
+	res.Callees = callEdges(callees)
+	return connect.NewResponse(res), nil
+}
+
+func callEdges(values map[string]int64) []*querierv1.CallEdge {
+	edges := make([]*querierv1.CallEdge, 0, len(values))
+	for name, value := range values {
+		edges = append(edges, &querierv1.CallEdge{Name: name, Value: value})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Value != edges[j].Value {
+			return edges[i].Value > edges[j].Value
+		}
+		return edges[i].Name < edges[j].Name
+	})
+	return edges
+}
+
+func (s *querierService) SelectFunctionSeries(ctx context.Context, req *connect.Request[querierv1.SelectFunctionSeriesRequest]) (*connect.Response[querierv1.SelectFunctionSeriesResponse], error) {
+	ids := tenantIDs(ctx)
+	if ids == nil {
+		return s.svc.SelectFunctionSeries(ctx, req)
+	}
+	results, err := forTenants(ctx, s, ids, func(ctx context.Context) (*connect.Response[querierv1.SelectFunctionSeriesResponse], error) {
+		return s.svc.SelectFunctionSeries(ctx, connectgrpc.CloneRequest(req, req.Msg.CloneVT()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	self := phlaremodel.NewSeriesMerger(true)
+	total := phlaremodel.NewSeriesMerger(true)
+	for _, r := range results {
+		self.MergeSeries([]*typesv1.Series{{Points: r.Self}})
+		total.MergeSeries([]*typesv1.Series{{Points: r.Total}})
+	}
+	return connect.NewResponse(&querierv1.SelectFunctionSeriesResponse{
+		Self:  points(self.Series()),
+		Total: points(total.Series()),
+	}), nil
+}
+
+func points(series []*typesv1.Series) []*typesv1.Point {
+	if len(series) == 0 {
+		return nil
+	}
+	return series[0].Points
+}
+
+func (s *querierService) QueryRange(ctx context.Context, req *connect.Request[querierv1.QueryRangeRequest]) (*connect.Response[querierv1.QueryRangeResponse], error) {
+	if ids := tenantIDs(ctx); ids != nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("ProfileQL queries do not support multiple tenants"))
+	}
+	return s.svc.QueryRange(ctx, req)
+}
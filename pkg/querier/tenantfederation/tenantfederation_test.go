@@ -0,0 +1,71 @@
+package tenantfederation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type fakeQuerier struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+}
+
+func (fakeQuerier) LabelNames(ctx context.Context, _ *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.LabelNamesResponse{Names: []string{"service_name", tenantID}}), nil
+}
+
+func (fakeQuerier) SelectSeries(ctx context.Context, _ *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	if _, err := tenant.TenantID(ctx); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{
+		Series: []*typesv1.Series{{
+			Labels: phlaremodel.LabelsFromStrings("service_name", "foo"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+		}},
+	}), nil
+}
+
+func Test_QuerierService_LabelNames(t *testing.T) {
+	svc := NewQuerierService(Config{InjectTenantLabel: true}, fakeQuerier{})
+
+	res, err := svc.LabelNames(user.InjectOrgID(context.Background(), "a|b"), connect.NewRequest(&typesv1.LabelNamesRequest{}))
+	require.NoError(t, err)
+	require.Equal(t, []string{phlaremodel.LabelNameTenantID, "a", "b", "service_name"}, res.Msg.Names)
+
+	res, err = svc.LabelNames(user.InjectOrgID(context.Background(), "a"), connect.NewRequest(&typesv1.LabelNamesRequest{}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"service_name", "a"}, res.Msg.Names)
+}
+
+func Test_QuerierService_SelectSeries(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "a|b")
+
+	svc := NewQuerierService(Config{}, fakeQuerier{})
+	res, err := svc.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{}))
+	require.NoError(t, err)
+	require.Equal(t, []*typesv1.Series{{
+		Labels: phlaremodel.LabelsFromStrings("service_name", "foo"),
+		Points: []*typesv1.Point{{Timestamp: 1, Value: 2}, {Timestamp: 2, Value: 4}},
+	}}, res.Msg.Series)
+
+	svc = NewQuerierService(Config{InjectTenantLabel: true}, fakeQuerier{})
+	res, err = svc.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{}))
+	require.NoError(t, err)
+	require.Len(t, res.Msg.Series, 2)
+	require.Equal(t, "a", phlaremodel.Labels(res.Msg.Series[0].Labels).Get(phlaremodel.LabelNameTenantID))
+	require.Equal(t, "b", phlaremodel.Labels(res.Msg.Series[1].Labels).Get(phlaremodel.LabelNameTenantID))
+}
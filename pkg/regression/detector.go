@@ -0,0 +1,323 @@
+// Package regression detects the regressions of the services, by comparing
+// their recent profiles to a baseline.
+package regression
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// maxFunctions is the number of top functions of the profiles compared.
+const maxFunctions = 500
+
+type Config struct {
+	Tenants         flagext.StringSliceCSV `yaml:"tenants"`
+	ProfileTypes    flagext.StringSliceCSV `yaml:"profile_types"`
+	Interval        time.Duration          `yaml:"interval"`
+	BaselineWindow  time.Duration          `yaml:"baseline_window"`
+	GrowthThreshold float64                `yaml:"growth_threshold"`
+	MinShare        float64                `yaml:"min_share"`
+	QueryURL        string                 `yaml:"query_url"`
+	WebhookURL      string                 `yaml:"webhook_url"`
+	MaxFindings     int                    `yaml:"max_findings" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.Tenants = []string{tenant.DefaultTenantID}
+	cfg.ProfileTypes = []string{"process_cpu:cpu:nanoseconds:cpu:nanoseconds"}
+	f.Var(&cfg.Tenants, "regression-detector.tenants", "Comma-separated list of the tenants whose services are analyzed.")
+	f.Var(&cfg.ProfileTypes, "regression-detector.profile-types", "Comma-separated list of the profile types analyzed.")
+	f.DurationVar(&cfg.Interval, "regression-detector.interval", 15*time.Minute, "How often the services are analyzed. The profiles of the last interval are compared to the baseline.")
+	f.DurationVar(&cfg.BaselineWindow, "regression-detector.baseline-window", 24*time.Hour, "Duration of the baseline the profiles are compared to, preceding the last interval.")
+	f.Float64Var(&cfg.GrowthThreshold, "regression-detector.growth-threshold", 20, "Minimum growth in percent of the share of a function in the profiles of a service, compared to the baseline, to report it.")
+	f.Float64Var(&cfg.MinShare, "regression-detector.min-share", 1, "Minimum share in percent of a function in the profiles of a service to report it.")
+	f.StringVar(&cfg.QueryURL, "regression-detector.query-url", "", "URL of the Pyroscope query API the profiles are queried with. If empty, the HTTP server of this instance is used.")
+	f.StringVar(&cfg.WebhookURL, "regression-detector.webhook-url", "", "If set, the new findings are posted as JSON to this URL.")
+	f.IntVar(&cfg.MaxFindings, "regression-detector.max-findings", 1000, "Maximum number of findings kept and served by the findings API.")
+}
+
+func (cfg *Config) Validate() error {
+	if len(cfg.Tenants) == 0 {
+		return errors.New("the regression detector requires at least one tenant")
+	}
+	if len(cfg.ProfileTypes) == 0 {
+		return errors.New("the regression detector requires at least one profile type")
+	}
+	for _, t := range cfg.ProfileTypes {
+		if _, err := phlaremodel.ParseProfileTypeSelector(t); err != nil {
+			return fmt.Errorf("invalid profile type %q: %w", t, err)
+		}
+	}
+	if cfg.Interval <= 0 || cfg.BaselineWindow <= 0 {
+		return errors.New("the regression detector interval and baseline window must be positive")
+	}
+	return nil
+}
+
+// Kind is the kind of a regression.
+type Kind string
+
+const (
+	// KindNewHotFunction is a function above the minimum share that was not
+	// in the baseline.
+	KindNewHotFunction Kind = "new_hot_function"
+	// KindFunctionGrew is a function whose share grew above the threshold.
+	KindFunctionGrew Kind = "function_grew"
+)
+
+// Finding is a regression of a service.
+type Finding struct {
+	Time          time.Time `json:"time"`
+	TenantID      string    `json:"tenant_id"`
+	ServiceName   string    `json:"service_name"`
+	ProfileType   string    `json:"profile_type"`
+	Kind          Kind      `json:"kind"`
+	Function      string    `json:"function"`
+	BaselineShare float64   `json:"baseline_share"`
+	CurrentShare  float64   `json:"current_share"`
+	GrowthPercent float64   `json:"growth_percent,omitempty"`
+}
+
+func (f Finding) key() string {
+	return f.TenantID + "/" + f.ServiceName + "/" + f.ProfileType + "/" + string(f.Kind) + "/" + f.Function
+}
+
+// Detector periodically compares the profiles of each service to a rolling
+// baseline, and reports the regressions as findings.
+type Detector struct {
+	services.Service
+
+	cfg     Config
+	logger  log.Logger
+	querier querierv1connect.QuerierServiceClient
+	client  *http.Client
+	store   *findingsStore
+	// active holds the keys of the findings of the last analysis, so the
+	// ongoing regressions are only reported once.
+	active map[string]struct{}
+
+	findings         *prometheus.CounterVec
+	analysisFailures prometheus.Counter
+	webhookFailures  prometheus.Counter
+}
+
+func New(cfg Config, querier querierv1connect.QuerierServiceClient, logger log.Logger, reg prometheus.Registerer) *Detector {
+	d := &Detector{
+		cfg:     cfg,
+		logger:  logger,
+		querier: querier,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		store:   newFindingsStore(cfg.MaxFindings),
+		active:  make(map[string]struct{}),
+		findings: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_regression_detector_findings_total",
+			Help: "The total number of regressions found.",
+		}, []string{"kind"}),
+		analysisFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_regression_detector_analysis_failures_total",
+			Help: "The total number of services whose analysis failed.",
+		}),
+		webhookFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_regression_detector_webhook_failures_total",
+			Help: "The total number of findings webhook requests that failed.",
+		}),
+	}
+	d.Service = services.NewTimerService(cfg.Interval, nil, d.iteration, nil).WithName("regression detector")
+	return d
+}
+
+func (d *Detector) iteration(ctx context.Context) error {
+	now := time.Now()
+	active := make(map[string]struct{})
+	var found []Finding
+	for _, tenantID := range d.cfg.Tenants {
+		serviceNames, err := d.serviceNames(ctx, tenantID)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "failed to list the services", "tenant", tenantID, "err", err)
+			d.analysisFailures.Inc()
+			continue
+		}
+		for _, serviceName := range serviceNames {
+			for _, profileType := range d.cfg.ProfileTypes {
+				findings, err := d.analyze(ctx, tenantID, serviceName, profileType, now)
+				if err != nil {
+					level.Warn(d.logger).Log("msg", "failed to analyze the service", "tenant", tenantID, "service", serviceName, "profile_type", profileType, "err", err)
+					d.analysisFailures.Inc()
+					continue
+				}
+				for _, f := range findings {
+					active[f.key()] = struct{}{}
+					if _, ok := d.active[f.key()]; !ok {
+						found = append(found, f)
+					}
+				}
+			}
+		}
+	}
+	d.active = active
+	if len(found) == 0 {
+		return nil
+	}
+	for _, f := range found {
+		d.findings.WithLabelValues(string(f.Kind)).Inc()
+	}
+	d.store.add(found)
+	if d.cfg.WebhookURL != "" {
+		if err := d.notify(ctx, found); err != nil {
+			level.Warn(d.logger).Log("msg", "failed to post the findings to the webhook", "err", err)
+			d.webhookFailures.Inc()
+		}
+	}
+	// The failures are retried at the next analysis.
+	return nil
+}
+
+func (d *Detector) serviceNames(ctx context.Context, tenantID string) ([]string, error) {
+	req := connect.NewRequest(&typesv1.LabelValuesRequest{Name: phlaremodel.LabelNameServiceName})
+	req.Header().Set(user.OrgIDHeaderName, tenantID)
+	resp, err := d.querier.LabelValues(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg.Names, nil
+}
+
+// analyze compares the share of the functions in the profiles of the last
+// interval to their share in the baseline.
+func (d *Detector) analyze(ctx context.Context, tenantID, serviceName, profileType string, now time.Time) ([]Finding, error) {
+	selector := fmt.Sprintf(`{%s=%q}`, phlaremodel.LabelNameServiceName, serviceName)
+	start := now.Add(-d.cfg.Interval)
+	current, err := d.topFunctions(ctx, tenantID, profileType, selector, start, now)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := d.topFunctions(ctx, tenantID, profileType, selector, start.Add(-d.cfg.BaselineWindow), start)
+	if err != nil {
+		return nil, err
+	}
+	if current.Total == 0 || baseline.Total == 0 {
+		// The service has no profiles to compare.
+		return nil, nil
+	}
+	baselineShares := make(map[string]float64, len(baseline.Functions))
+	for _, fn := range baseline.Functions {
+		baselineShares[fn.Name] = share(fn.Self, baseline.Total)
+	}
+	var findings []Finding
+	for _, fn := range current.Functions {
+		currentShare := share(fn.Self, current.Total)
+		if currentShare < d.cfg.MinShare {
+			continue
+		}
+		f := Finding{
+			Time:         now,
+			TenantID:     tenantID,
+			ServiceName:  serviceName,
+			ProfileType:  profileType,
+			Function:     fn.Name,
+			CurrentShare: currentShare,
+		}
+		baselineShare, ok := baselineShares[fn.Name]
+		switch {
+		case !ok:
+			f.Kind = KindNewHotFunction
+		case baselineShare > 0 && (currentShare-baselineShare)/baselineShare*100 >= d.cfg.GrowthThreshold:
+			f.Kind = KindFunctionGrew
+			f.BaselineShare = baselineShare
+			f.GrowthPercent = (currentShare - baselineShare) / baselineShare * 100
+		default:
+			continue
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+func (d *Detector) topFunctions(ctx context.Context, tenantID, profileType, selector string, start, end time.Time) (*querierv1.SelectTopFunctionsResponse, error) {
+	limit := int64(maxFunctions)
+	req := connect.NewRequest(&querierv1.SelectTopFunctionsRequest{
+		ProfileTypeID: profileType,
+		LabelSelector: selector,
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		Limit:         &limit,
+		OrderBy:       querierv1.TopFunctionsOrder_TOP_FUNCTIONS_ORDER_SELF,
+	})
+	req.Header().Set(user.OrgIDHeaderName, tenantID)
+	resp, err := d.querier.SelectTopFunctions(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg, nil
+}
+
+// share returns the share of the value in the total, in percent.
+func share(value, total int64) float64 {
+	return float64(value) / float64(total) * 100
+}
+
+func (d *Detector) notify(ctx context.Context, findings []Finding) error {
+	body, err := json.Marshal(findings)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FindingsHandler returns the findings of the tenant of the request, the
+// most recent first. They can be filtered by service with the service_name
+// parameter, and limited with the limit parameter.
+func (d *Detector) FindingsHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	limit := d.cfg.MaxFindings
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	findings := d.store.list(tenantID, r.URL.Query().Get("service_name"), limit)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Findings []Finding `json:"findings"`
+	}{Findings: findings})
+}
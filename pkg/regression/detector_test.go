@@ -0,0 +1,110 @@
+package regression
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// fakeQuerier returns the baseline functions for the queries ending before
+// the current interval.
+type fakeQuerier struct {
+	querierv1connect.QuerierServiceClient
+	currentStart int64
+	current      *querierv1.SelectTopFunctionsResponse
+	baseline     *querierv1.SelectTopFunctionsResponse
+}
+
+func (q *fakeQuerier) LabelValues(context.Context, *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
+	return connect.NewResponse(&typesv1.LabelValuesResponse{Names: []string{"checkout"}}), nil
+}
+
+func (q *fakeQuerier) SelectTopFunctions(_ context.Context, req *connect.Request[querierv1.SelectTopFunctionsRequest]) (*connect.Response[querierv1.SelectTopFunctionsResponse], error) {
+	if req.Msg.End <= q.currentStart {
+		return connect.NewResponse(q.baseline), nil
+	}
+	return connect.NewResponse(q.current), nil
+}
+
+func Test_Detector(t *testing.T) {
+	var notified []Finding
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var findings []Finding
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&findings))
+		notified = append(notified, findings...)
+	}))
+	defer webhook.Close()
+
+	q := &fakeQuerier{
+		currentStart: time.Now().Add(-10 * time.Minute).UnixMilli(),
+		baseline: &querierv1.SelectTopFunctionsResponse{Total: 1000, Functions: []*querierv1.TopFunction{
+			{Name: "main.handler", Self: 500},
+			{Name: "json.Marshal", Self: 100},
+			{Name: "runtime.mallocgc", Self: 100},
+		}},
+		current: &querierv1.SelectTopFunctionsResponse{Total: 100, Functions: []*querierv1.TopFunction{
+			{Name: "main.handler", Self: 50},    // Unchanged share.
+			{Name: "json.Marshal", Self: 20},    // Grew from 10% to 20%.
+			{Name: "regexp.Compile", Self: 5},   // New.
+			{Name: "runtime.mallocgc", Self: 1}, // Below the minimum share.
+			{Name: "strings.Builder", Self: 0},  // Below the minimum share.
+		}},
+	}
+	d := New(Config{
+		Tenants:         []string{"team-a"},
+		ProfileTypes:    []string{"process_cpu:cpu:nanoseconds:cpu:nanoseconds"},
+		Interval:        30 * time.Minute,
+		BaselineWindow:  24 * time.Hour,
+		GrowthThreshold: 20,
+		MinShare:        2,
+		WebhookURL:      webhook.URL,
+		MaxFindings:     10,
+	}, q, log.NewNopLogger(), prometheus.NewRegistry())
+
+	require.NoError(t, d.iteration(context.Background()))
+	require.Len(t, notified, 2)
+	require.Equal(t, "json.Marshal", notified[0].Function)
+	require.Equal(t, KindFunctionGrew, notified[0].Kind)
+	require.InDelta(t, 100, notified[0].GrowthPercent, 0.001)
+	require.Equal(t, "regexp.Compile", notified[1].Function)
+	require.Equal(t, KindNewHotFunction, notified[1].Kind)
+
+	// The ongoing regressions are only reported once.
+	require.NoError(t, d.iteration(context.Background()))
+	require.Len(t, notified, 2)
+
+	// The findings are served to their tenant.
+	for tenantID, expected := range map[string]int{"team-a": 2, "team-b": 0} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/regression-detector/findings?service_name=checkout", nil)
+		d.FindingsHandler(w, r.WithContext(tenant.InjectTenantID(r.Context(), tenantID)))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Findings []Finding `json:"findings"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Len(t, resp.Findings, expected, tenantID)
+	}
+}
+
+func Test_FindingsStore(t *testing.T) {
+	s := newFindingsStore(2)
+	s.add([]Finding{{TenantID: "a", Function: "1"}, {TenantID: "a", Function: "2"}})
+	s.add([]Finding{{TenantID: "a", Function: "3"}})
+	require.Equal(t, []Finding{{TenantID: "a", Function: "3"}, {TenantID: "a", Function: "2"}}, s.list("a", "", 10))
+	require.Equal(t, []Finding{{TenantID: "a", Function: "3"}}, s.list("a", "", 1))
+	require.Empty(t, s.list("b", "", 10))
+}
@@ -0,0 +1,39 @@
+package regression
+
+import "sync"
+
+// findingsStore keeps the most recent findings.
+type findingsStore struct {
+	mtx      sync.RWMutex
+	max      int
+	findings []Finding
+}
+
+func newFindingsStore(max int) *findingsStore {
+	return &findingsStore{max: max}
+}
+
+func (s *findingsStore) add(findings []Finding) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.findings = append(s.findings, findings...)
+	if n := len(s.findings) - s.max; n > 0 {
+		s.findings = append(s.findings[:0:0], s.findings[n:]...)
+	}
+}
+
+// list returns the findings of the tenant, the most recent first. All the
+// services are listed if serviceName is empty.
+func (s *findingsStore) list(tenantID, serviceName string, limit int) []Finding {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	findings := make([]Finding, 0)
+	for i := len(s.findings) - 1; i >= 0 && len(findings) < limit; i-- {
+		f := s.findings[i]
+		if f.TenantID != tenantID || (serviceName != "" && f.ServiceName != serviceName) {
+			continue
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
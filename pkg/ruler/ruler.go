@@ -0,0 +1,252 @@
+package ruler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+type Config struct {
+	RulesFile          string            `yaml:"rules_file"`
+	EvaluationInterval time.Duration     `yaml:"evaluation_interval"`
+	QueryURL           string            `yaml:"query_url"`
+	RemoteWrite        RemoteWriteConfig `yaml:"remote_write"`
+}
+
+type RemoteWriteConfig struct {
+	URL               string         `yaml:"url"`
+	BasicAuthUsername string         `yaml:"basic_auth_username"`
+	BasicAuthPassword flagext.Secret `yaml:"basic_auth_password"`
+	// SendTenantID sets the X-Scope-OrgID header of the requests to the
+	// tenant of the rules.
+	SendTenantID bool          `yaml:"send_tenant_id" category:"advanced"`
+	Timeout      time.Duration `yaml:"timeout" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.RulesFile, "ruler.rules-file", "", "Path to the file of the recording rules. Each rule periodically queries the profiles, and records the result as a Prometheus metric.")
+	f.DurationVar(&cfg.EvaluationInterval, "ruler.evaluation-interval", time.Minute, "How often the rules are evaluated. Each evaluation records the total of the profiles over the last interval.")
+	f.StringVar(&cfg.QueryURL, "ruler.query-url", "", "URL of the Pyroscope query API the rules are evaluated with. If empty, the HTTP server of this instance is used.")
+	cfg.RemoteWrite.RegisterFlags(f)
+}
+
+func (cfg *RemoteWriteConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.URL, "ruler.remote-write.url", "", "URL of the Prometheus remote write endpoint the metrics of the rules are written to.")
+	f.StringVar(&cfg.BasicAuthUsername, "ruler.remote-write.basic-auth-username", "", "Username of the basic authentication of the remote write requests.")
+	f.Var(&cfg.BasicAuthPassword, "ruler.remote-write.basic-auth-password", "Password of the basic authentication of the remote write requests.")
+	f.BoolVar(&cfg.SendTenantID, "ruler.remote-write.send-tenant-id", false, "If enabled, the metrics of the rules are written with the X-Scope-OrgID header set to the tenant of the rules.")
+	f.DurationVar(&cfg.Timeout, "ruler.remote-write.timeout", 30*time.Second, "Timeout of the remote write requests.")
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.RulesFile == "" {
+		return errors.New("the ruler requires a rules file")
+	}
+	if cfg.RemoteWrite.URL == "" {
+		return errors.New("the ruler requires a remote write URL")
+	}
+	if cfg.EvaluationInterval <= 0 {
+		return errors.New("the ruler evaluation interval must be positive")
+	}
+	return nil
+}
+
+// Ruler evaluates the recording rules, and remote writes their results as
+// Prometheus metrics.
+type Ruler struct {
+	services.Service
+
+	cfg     Config
+	logger  log.Logger
+	rules   []Rule
+	querier querierv1connect.QuerierServiceClient
+	client  *http.Client
+
+	evaluationFailures  *prometheus.CounterVec
+	remoteWriteFailures prometheus.Counter
+	samplesWritten      prometheus.Counter
+}
+
+func New(cfg Config, querier querierv1connect.QuerierServiceClient, logger log.Logger, reg prometheus.Registerer) (*Ruler, error) {
+	rules, err := loadRules(cfg.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &Ruler{
+		cfg:     cfg,
+		logger:  logger,
+		rules:   rules,
+		querier: querier,
+		client:  &http.Client{Timeout: cfg.RemoteWrite.Timeout},
+		evaluationFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_ruler_evaluation_failures_total",
+			Help: "The total number of rule evaluations that failed.",
+		}, []string{"rule"}),
+		remoteWriteFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_ruler_remote_write_failures_total",
+			Help: "The total number of remote write requests of the ruler that failed.",
+		}),
+		samplesWritten: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_ruler_samples_written_total",
+			Help: "The total number of samples of the rules remote written.",
+		}),
+	}
+	r.Service = services.NewTimerService(cfg.EvaluationInterval, nil, r.iteration, nil).WithName("ruler")
+	return r, nil
+}
+
+func (r *Ruler) iteration(ctx context.Context) error {
+	end := time.Now().Truncate(r.cfg.EvaluationInterval)
+	start := end.Add(-r.cfg.EvaluationInterval)
+	series := make(map[string][]prompb.TimeSeries)
+	for _, rule := range r.rules {
+		s, err := r.evaluate(ctx, rule, start, end)
+		if err != nil {
+			level.Warn(r.logger).Log("msg", "failed to evaluate rule", "rule", rule.Record, "tenant", rule.TenantID, "err", err)
+			r.evaluationFailures.WithLabelValues(rule.Record).Inc()
+			continue
+		}
+		series[rule.TenantID] = append(series[rule.TenantID], s...)
+	}
+	if !r.cfg.RemoteWrite.SendTenantID {
+		var all []prompb.TimeSeries
+		for _, s := range series {
+			all = append(all, s...)
+		}
+		series = map[string][]prompb.TimeSeries{"": all}
+	}
+	for tenantID, s := range series {
+		if len(s) == 0 {
+			continue
+		}
+		if err := r.remoteWrite(ctx, tenantID, s); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to remote write the rules results", "tenant", tenantID, "err", err)
+			r.remoteWriteFailures.Inc()
+			continue
+		}
+		r.samplesWritten.Add(float64(len(s)))
+	}
+	// The failures are retried at the next evaluation.
+	return nil
+}
+
+// evaluate returns the series recorded by the rule, with the total of the
+// profiles between start and end.
+func (r *Ruler) evaluate(ctx context.Context, rule Rule, start, end time.Time) ([]prompb.TimeSeries, error) {
+	step := end.Sub(start).Seconds()
+	ts := end.UnixMilli()
+	if rule.Function != "" {
+		req := connect.NewRequest(&querierv1.SelectFunctionSeriesRequest{
+			ProfileTypeID: rule.ProfileType,
+			LabelSelector: rule.Selector,
+			FunctionName:  rule.Function,
+			Start:         start.UnixMilli(),
+			End:           end.UnixMilli(),
+			Step:          step,
+		})
+		setTenantID(req.Header(), rule.TenantID)
+		resp, err := r.querier.SelectFunctionSeries(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return []prompb.TimeSeries{newTimeSeries(rule, nil, sumPoints(resp.Msg.Total), ts)}, nil
+	}
+	req := connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: rule.ProfileType,
+		LabelSelector: rule.Selector,
+		GroupBy:       rule.GroupBy,
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		Step:          step,
+	})
+	setTenantID(req.Header(), rule.TenantID)
+	resp, err := r.querier.SelectSeries(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	series := make([]prompb.TimeSeries, 0, len(resp.Msg.Series))
+	for _, s := range resp.Msg.Series {
+		series = append(series, newTimeSeries(rule, s.Labels, sumPoints(s.Points), ts))
+	}
+	return series, nil
+}
+
+func setTenantID(h http.Header, tenantID string) {
+	if tenantID != "" {
+		h.Set(user.OrgIDHeaderName, tenantID)
+	}
+}
+
+func sumPoints(points []*typesv1.Point) float64 {
+	var sum float64
+	for _, p := range points {
+		sum += p.Value
+	}
+	return sum
+}
+
+func newTimeSeries(rule Rule, groupLabels []*typesv1.LabelPair, value float64, ts int64) prompb.TimeSeries {
+	labels := make([]prompb.Label, 0, len(groupLabels)+len(rule.Labels)+1)
+	labels = append(labels, prompb.Label{Name: model.MetricNameLabel, Value: rule.Record})
+	for _, l := range groupLabels {
+		labels = append(labels, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	for name, value := range rule.Labels {
+		labels = append(labels, prompb.Label{Name: name, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return prompb.TimeSeries{
+		Labels:  labels,
+		Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+	}
+}
+
+func (r *Ruler) remoteWrite(ctx context.Context, tenantID string, series []prompb.TimeSeries) error {
+	data, err := (&prompb.WriteRequest{Timeseries: series}).Marshal()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.RemoteWrite.URL, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.cfg.RemoteWrite.BasicAuthUsername != "" {
+		req.SetBasicAuth(r.cfg.RemoteWrite.BasicAuthUsername, r.cfg.RemoteWrite.BasicAuthPassword.String())
+	}
+	setTenantID(req.Header, tenantID)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
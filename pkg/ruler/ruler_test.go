@@ -0,0 +1,124 @@
+package ruler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+type fakeQuerier struct {
+	querierv1connect.QuerierServiceClient
+}
+
+func (fakeQuerier) SelectSeries(_ context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	if req.Header().Get("X-Scope-OrgID") != "team-a" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
+	}
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: []*typesv1.Series{
+		{
+			Labels: []*typesv1.LabelPair{{Name: "service_name", Value: "checkout"}},
+			Points: []*typesv1.Point{{Value: 1}, {Value: 2}},
+		},
+		{
+			Labels: []*typesv1.LabelPair{{Name: "service_name", Value: "cart"}},
+			Points: []*typesv1.Point{{Value: 5}},
+		},
+	}}), nil
+}
+
+func (fakeQuerier) SelectFunctionSeries(_ context.Context, req *connect.Request[querierv1.SelectFunctionSeriesRequest]) (*connect.Response[querierv1.SelectFunctionSeriesResponse], error) {
+	if req.Msg.FunctionName != "main.handler" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, nil)
+	}
+	return connect.NewResponse(&querierv1.SelectFunctionSeriesResponse{
+		Self:  []*typesv1.Point{{Value: 1}},
+		Total: []*typesv1.Point{{Value: 10}, {Value: 20}},
+	}), nil
+}
+
+func writeRules(t *testing.T, rules string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0o644))
+	return path
+}
+
+func Test_Ruler(t *testing.T) {
+	written := make(chan *prompb.WriteRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(data))
+		require.Equal(t, "team-a", r.Header.Get("X-Scope-OrgID"))
+		written <- &req
+	}))
+	defer srv.Close()
+
+	r, err := New(Config{
+		RulesFile: writeRules(t, `
+rules:
+  - record: alloc_space_bytes
+    tenant_id: team-a
+    profile_type: memory:alloc_space:bytes:space:bytes
+    group_by: [service_name]
+  - record: handler_cpu_nanoseconds
+    tenant_id: team-a
+    profile_type: process_cpu:cpu:nanoseconds:cpu:nanoseconds
+    selector: '{service_name="checkout"}'
+    function: main.handler
+    labels:
+      team: checkout
+`),
+		EvaluationInterval: time.Minute,
+		RemoteWrite:        RemoteWriteConfig{URL: srv.URL, SendTenantID: true, Timeout: time.Second},
+	}, fakeQuerier{}, log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	require.NoError(t, r.iteration(context.Background()))
+
+	req := <-written
+	require.Len(t, req.Timeseries, 3)
+	values := make(map[string]float64)
+	for _, s := range req.Timeseries {
+		require.Len(t, s.Samples, 1)
+		var key string
+		for _, l := range s.Labels {
+			key += l.Name + "=" + l.Value + ","
+		}
+		values[key] = s.Samples[0].Value
+	}
+	require.Equal(t, map[string]float64{
+		"__name__=alloc_space_bytes,service_name=checkout,": 3,
+		"__name__=alloc_space_bytes,service_name=cart,":     5,
+		"__name__=handler_cpu_nanoseconds,team=checkout,":   30,
+	}, values)
+}
+
+func Test_LoadRules_Invalid(t *testing.T) {
+	for name, rules := range map[string]string{
+		"invalid name":       "rules: [{record: 'a-b', profile_type: cpu}]",
+		"no profile type":    "rules: [{record: a}]",
+		"function and group": "rules: [{record: a, profile_type: cpu, function: main, group_by: [pod]}]",
+		"invalid label":      "rules: [{record: a, profile_type: cpu, labels: {'a-b': c}}]",
+	} {
+		_, err := loadRules(writeRules(t, rules))
+		require.Error(t, err, name)
+	}
+}
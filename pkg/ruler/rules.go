@@ -0,0 +1,83 @@
+package ruler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// The rules file lists the recording rules:
+//
+//	rules:
+//	  - record: checkout_handler_cpu_nanoseconds
+//	    tenant_id: team-a
+//	    profile_type: process_cpu:cpu:nanoseconds:cpu:nanoseconds
+//	    selector: '{service_name="checkout"}'
+//	    function: main.(*Handler).ServeHTTP
+//	    labels:
+//	      team: checkout
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule records the result of a profile query as a metric.
+type Rule struct {
+	// Record is the name of the metric recorded.
+	Record      string `yaml:"record"`
+	TenantID    string `yaml:"tenant_id"`
+	ProfileType string `yaml:"profile_type"`
+	Selector    string `yaml:"selector"`
+	// Function restricts the value to the total of the function, if set.
+	Function string `yaml:"function"`
+	// GroupBy records a series per value of the labels. It can't be used
+	// with Function.
+	GroupBy []string `yaml:"group_by"`
+	// Labels are added to the recorded series.
+	Labels map[string]string `yaml:"labels"`
+}
+
+func (r *Rule) validate() error {
+	if !model.IsValidMetricName(model.LabelValue(r.Record)) {
+		return fmt.Errorf("invalid metric name %q", r.Record)
+	}
+	if r.ProfileType == "" {
+		return errors.New("the profile type is required")
+	}
+	if r.Function != "" && len(r.GroupBy) > 0 {
+		return errors.New("the series of a function can't be grouped by labels")
+	}
+	for _, name := range r.GroupBy {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid group by label %q", name)
+		}
+	}
+	for name := range r.Labels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+			return fmt.Errorf("invalid label %q", name)
+		}
+	}
+	return nil
+}
+
+func loadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f rulesFile
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid rules file: %w", err)
+	}
+	for i := range f.Rules {
+		if f.Rules[i].Selector == "" {
+			f.Rules[i].Selector = "{}"
+		}
+		if err = f.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", f.Rules[i].Record, err)
+		}
+	}
+	return f.Rules, nil
+}
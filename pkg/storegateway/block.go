@@ -4,6 +4,8 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/pkg/errors"
@@ -21,6 +23,74 @@ type Block struct {
 	BlockCloser
 	meta   *block.Meta
 	logger log.Logger
+
+	mtx      sync.Mutex
+	loaded   bool
+	inflight int
+	lastUsed time.Time
+}
+
+// Open loads the block, if it isn't loaded yet.
+func (b *Block) Open(ctx context.Context) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.lastUsed = time.Now()
+	if err := b.BlockCloser.Open(ctx); err != nil {
+		return err
+	}
+	b.loaded = true
+	return nil
+}
+
+// Close unloads the block, if it is loaded.
+func (b *Block) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !b.loaded {
+		return nil
+	}
+	b.loaded = false
+	return b.BlockCloser.Close()
+}
+
+// Meta returns the meta of the block, which is known before it is loaded.
+func (b *Block) Meta() block.Meta {
+	return *b.meta
+}
+
+// acquire marks the block as read by a query, until it is released. A block
+// read by a query is never unloaded.
+func (b *Block) acquire() {
+	b.mtx.Lock()
+	b.inflight++
+	b.mtx.Unlock()
+}
+
+func (b *Block) release() {
+	b.mtx.Lock()
+	b.inflight--
+	b.lastUsed = time.Now()
+	b.mtx.Unlock()
+}
+
+// closeIfIdle unloads the block if no query has read it for the idle
+// timeout. It returns whether the block was unloaded.
+func (b *Block) closeIfIdle(idleTimeout time.Duration) (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !b.loaded || b.inflight > 0 || time.Since(b.lastUsed) < idleTimeout {
+		return false, nil
+	}
+	b.loaded = false
+	return true, b.BlockCloser.Close()
+}
+
+// lastUsedTime returns when the block was last used, and whether it is
+// loaded.
+func (b *Block) lastUsedTime() (time.Time, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.lastUsed, b.loaded
 }
 
 func (bs *BucketStore) createBlock(ctx context.Context, meta *block.Meta) (*Block, error) {
@@ -50,6 +120,6 @@ func (bs *BucketStore) createBlock(ctx context.Context, meta *block.Meta) (*Bloc
 	return &Block{
 		meta:        meta,
 		logger:      bs.logger,
-		BlockCloser: phlaredb.NewSingleBlockQuerierFromMeta(ctx, bs.bucket, meta),
+		BlockCloser: phlaredb.NewSingleBlockQuerierFromMeta(ctx, &indexHeaderBucket{Bucket: bs.bucket, dir: bs.syncDir}, meta),
 	}, nil
 }
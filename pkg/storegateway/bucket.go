@@ -21,9 +21,6 @@ import (
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 )
 
-// TODO move this to a config.
-const blockSyncConcurrency = 100
-
 type BucketStoreStats struct {
 	// BlocksLoaded is the number of blocks currently loaded in the bucket store.
 	BlocksLoaded int
@@ -42,18 +39,27 @@ type BucketStore struct {
 	filters []BlockMetaFilter
 	metrics *Metrics
 	stats   BucketStoreStats
+
+	lazyLoadingEnabled     bool
+	lazyLoadingIdleTimeout time.Duration
+	bucketIndex            BucketIndexConfig
+	blockSyncConcurrency   int
 }
 
-func NewBucketStore(bucket phlareobj.Bucket, tenantID string, syncDir string, filters []BlockMetaFilter, logger log.Logger, Metrics *Metrics) (*BucketStore, error) {
+func NewBucketStore(bucket phlareobj.Bucket, tenantID string, syncDir string, lazyLoadingEnabled bool, lazyLoadingIdleTimeout time.Duration, bucketIndex BucketIndexConfig, blockSyncConcurrency int, filters []BlockMetaFilter, logger log.Logger, Metrics *Metrics) (*BucketStore, error) {
 	s := &BucketStore{
-		bucket:   phlareobj.NewPrefixedBucket(bucket, tenantID+"/phlaredb"),
-		tenantID: tenantID,
-		syncDir:  syncDir,
-		logger:   logger,
-		filters:  filters,
-		blockSet: newBucketBlockSet(),
-		blocks:   map[ulid.ULID]*Block{},
-		metrics:  Metrics,
+		bucket:                 phlareobj.NewPrefixedBucket(bucket, tenantID+"/phlaredb"),
+		tenantID:               tenantID,
+		syncDir:                syncDir,
+		logger:                 logger,
+		filters:                filters,
+		blockSet:               newBucketBlockSet(),
+		blocks:                 map[ulid.ULID]*Block{},
+		metrics:                Metrics,
+		lazyLoadingEnabled:     lazyLoadingEnabled,
+		lazyLoadingIdleTimeout: lazyLoadingIdleTimeout,
+		bucketIndex:            bucketIndex,
+		blockSyncConcurrency:   blockSyncConcurrency,
 	}
 
 	if err := os.MkdirAll(syncDir, 0o750); err != nil {
@@ -90,6 +96,12 @@ func (b *BucketStore) InitialSync(ctx context.Context) error {
 			level.Warn(b.logger).Log("msg", "failed to remove block which is not needed", "err", err)
 		}
 	}
+
+	if b.lazyLoadingEnabled {
+		if err := b.loadPersistedBlocks(ctx); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to load blocks loaded before restart", "err", err)
+		}
+	}
 	return nil
 }
 
@@ -106,15 +118,28 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		return metaFetchErr
 	}
 
+	progress := syncProgressFromContext(ctx)
+	if progress != nil {
+		var synced int
+		for id := range metas {
+			if s.getBlock(id) != nil {
+				synced++
+			}
+		}
+		progress.listed(s.tenantID, len(metas), synced)
+	}
+
 	var wg sync.WaitGroup
 	blockc := make(chan *block.Meta)
 
-	for i := 0; i < blockSyncConcurrency; i++ {
+	for i := 0; i < s.blockSyncConcurrency; i++ {
 		wg.Add(1)
 		go func() {
 			for meta := range blockc {
-				if err := s.addBlock(ctx, meta); err != nil {
-					continue
+				// Blocks failing to load are retried at the next sync.
+				_ = s.addBlock(ctx, meta)
+				if progress != nil {
+					progress.blockSynced(s.tenantID)
 				}
 			}
 			wg.Done()
@@ -186,9 +211,10 @@ func (bs *BucketStore) addBlock(ctx context.Context, meta *block.Meta) (err erro
 	if err != nil {
 		return err
 	}
-	// Load the block into memory if it's within the last 24 hours.
+	// Unless blocks are loaded lazily, load the block into memory if it's
+	// within the last 24 hours.
 	// Todo make this configurable
-	if phlaredb.InRange(b, model.Now().Add(-24*time.Hour), model.Now()) {
+	if !bs.lazyLoadingEnabled && phlaredb.InRange(b, model.Now().Add(-24*time.Hour), model.Now()) {
 		level.Debug(bs.logger).Log("msg", "opening block",
 			"id", meta.ULID.String(),
 			"min", b.meta.MinTime.Time().Format(time.RFC3339),
@@ -270,12 +296,22 @@ func (s *BucketStore) fetchBlocksMeta(ctx context.Context) (map[ulid.ULID]*block
 	defer func() {
 		level.Debug(s.logger).Log("msg", "fetched blocks meta", "total", len(metas), "elapsed", time.Since(start))
 	}()
-	if err := block.IterBlockMetas(ctx, s.bucket, from, to, func(m *block.Meta) {
+	fn := func(m *block.Meta) {
 		mtx.Lock()
 		defer mtx.Unlock()
 		metas = append(metas, m)
-	}); err != nil {
-		return nil, errors.Wrap(err, "iter block metas")
+	}
+	var fromIndex bool
+	if s.bucketIndex.Enabled {
+		var err error
+		if fromIndex, err = s.iterBlockMetasFromIndex(ctx, from, to, fn); err != nil {
+			return nil, errors.Wrap(err, "iter bucket index block metas")
+		}
+	}
+	if !fromIndex {
+		if err := block.IterBlockMetas(ctx, s.bucket, from, to, fn); err != nil {
+			return nil, errors.Wrap(err, "iter block metas")
+		}
 	}
 
 	metaMap := lo.SliceToMap(metas, func(item *block.Meta) (ulid.ULID, *block.Meta) {
@@ -0,0 +1,74 @@
+package storegateway
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+)
+
+// BucketIndexConfig configures the discovery of the blocks with the bucket
+// index.
+type BucketIndexConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	MaxStalePeriod time.Duration `yaml:"max_stale_period" category:"advanced"`
+}
+
+func (cfg *BucketIndexConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "If enabled, store-gateways discover blocks by reading the bucket index of the tenants instead of periodically scanning the bucket. The bucket index is not written by Pyroscope and must be kept up to date by an external process. If the bucket index is not found or is too old, the bucket is scanned.")
+	f.DurationVar(&cfg.MaxStalePeriod, prefix+"max-stale-period", time.Hour, "The maximum allowed age of a bucket index (last updated) before store-gateways scan the bucket instead. 0 to disable the check.")
+}
+
+// iterBlockMetasFromIndex calls fn for the metas of the blocks of the bucket
+// index within the time range. The metas of the blocks already loaded are
+// reused, only the metas of the new blocks are fetched. It returns false if
+// the bucket index can't be used, in which case the blocks must be discovered
+// by scanning the bucket.
+func (s *BucketStore) iterBlockMetasFromIndex(ctx context.Context, from, to time.Time, fn func(*block.Meta)) (bool, error) {
+	// The bucket of the store is already prefixed with the path of the blocks
+	// of the tenant, where the bucket index is.
+	idx, err := bucketindex.ReadIndex(ctx, s.bucket, "", nil, s.logger)
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "failed to read bucket index, scanning the bucket instead", "err", err)
+		return false, nil
+	}
+	if s.bucketIndex.MaxStalePeriod > 0 && time.Since(idx.GetUpdatedAt()) > s.bucketIndex.MaxStalePeriod {
+		level.Warn(s.logger).Log("msg", "bucket index is too old, scanning the bucket instead", "updated_at", idx.GetUpdatedAt())
+		return false, nil
+	}
+	s.metrics.setTenantUsage(s.tenantID, idx.Usage())
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(128)
+	minT, maxT := model.TimeFromUnixNano(from.UnixNano()), model.TimeFromUnixNano(to.UnixNano())
+	for _, b := range idx.Blocks {
+		if !b.Within(minT, maxT) {
+			continue
+		}
+		if loaded := s.getBlock(b.ID); loaded != nil {
+			fn(loaded.meta)
+			continue
+		}
+		id := b.ID
+		g.Go(func() error {
+			meta, err := block.DownloadMeta(ctx, s.logger, s.bucket, id)
+			if err != nil {
+				if s.bucket.IsObjNotFoundErr(errors.Cause(err)) {
+					// The block was deleted after the bucket index was updated.
+					return nil
+				}
+				return err
+			}
+			fn(&meta)
+			return nil
+		})
+	}
+	return true, g.Wait()
+}
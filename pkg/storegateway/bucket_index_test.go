@@ -0,0 +1,115 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+)
+
+func newTestBucketStore(t *testing.T, cfg BucketIndexConfig) *BucketStore {
+	t.Helper()
+	s, err := NewBucketStore(phlareobj.NewBucket(objstore.NewInMemBucket()), "tenant", t.TempDir(), false, 0, cfg, 1, nil, log.NewNopLogger(), NewMetrics(prometheus.NewRegistry()))
+	require.NoError(t, err)
+	return s
+}
+
+func newTestMeta(minT, maxT model.Time) *block.Meta {
+	m := block.NewMeta()
+	m.MinTime, m.MaxTime = minT, maxT
+	return m
+}
+
+func uploadTestMeta(t *testing.T, s *BucketStore, m *block.Meta) {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, s.bucket.Upload(context.Background(), path.Join(m.ULID.String(), block.MetaFilename), &buf))
+}
+
+func collectBlockMetasFromIndex(t *testing.T, s *BucketStore, from, to time.Time) ([]ulid.ULID, bool) {
+	t.Helper()
+	var (
+		mtx sync.Mutex
+		ids []ulid.ULID
+	)
+	ok, err := s.iterBlockMetasFromIndex(context.Background(), from, to, func(m *block.Meta) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		ids = append(ids, m.ULID)
+	})
+	require.NoError(t, err)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+	return ids, ok
+}
+
+func Test_IterBlockMetasFromIndex(t *testing.T) {
+	ctx := context.Background()
+	from, to := time.Unix(1000, 0), time.Unix(2000, 0)
+
+	t.Run("missing bucket index", func(t *testing.T) {
+		s := newTestBucketStore(t, BucketIndexConfig{Enabled: true, MaxStalePeriod: time.Hour})
+		_, ok := collectBlockMetasFromIndex(t, s, from, to)
+		require.False(t, ok)
+	})
+
+	t.Run("stale bucket index", func(t *testing.T) {
+		s := newTestBucketStore(t, BucketIndexConfig{Enabled: true, MaxStalePeriod: time.Hour})
+		require.NoError(t, bucketindex.WriteIndex(ctx, s.bucket, "", nil, &bucketindex.Index{
+			Version:   bucketindex.IndexVersion3,
+			UpdatedAt: time.Now().Add(-2 * time.Hour).Unix(),
+		}))
+		_, ok := collectBlockMetasFromIndex(t, s, from, to)
+		require.False(t, ok)
+
+		// The age of the bucket index is not checked without max stale period.
+		s.bucketIndex.MaxStalePeriod = 0
+		_, ok = collectBlockMetasFromIndex(t, s, from, to)
+		require.True(t, ok)
+	})
+
+	t.Run("blocks of the bucket index", func(t *testing.T) {
+		s := newTestBucketStore(t, BucketIndexConfig{Enabled: true, MaxStalePeriod: time.Hour})
+
+		var (
+			uploaded   = newTestMeta(1000_000, 1500_000)
+			loaded     = newTestMeta(1500_000, 2000_000)
+			deleted    = newTestMeta(1200_000, 1300_000)
+			outOfRange = newTestMeta(3000_000, 4000_000)
+		)
+		uploadTestMeta(t, s, uploaded)
+		uploadTestMeta(t, s, outOfRange)
+		// The meta of a loaded block is not fetched from the bucket.
+		s.blocks[loaded.ULID] = &Block{meta: loaded}
+
+		idx := &bucketindex.Index{
+			Version:   bucketindex.IndexVersion3,
+			UpdatedAt: time.Now().Unix(),
+		}
+		for _, m := range []*block.Meta{uploaded, loaded, deleted, outOfRange} {
+			idx.Blocks = append(idx.Blocks, bucketindex.BlockFromMeta(*m))
+		}
+		require.NoError(t, bucketindex.WriteIndex(ctx, s.bucket, "", nil, idx))
+
+		ids, ok := collectBlockMetasFromIndex(t, s, from, to)
+		require.True(t, ok)
+		expected := []ulid.ULID{uploaded.ULID, loaded.ULID}
+		sort.Slice(expected, func(i, j int) bool { return expected[i].Compare(expected[j]) < 0 })
+		require.Equal(t, expected, ids)
+	})
+}
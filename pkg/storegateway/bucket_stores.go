@@ -3,6 +3,7 @@ package storegateway
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/cache"
 	"github.com/grafana/dskit/multierror"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,24 +25,98 @@ import (
 
 var errBucketStoreNotFound = errors.New("bucket store not found")
 
+// initialSyncProgressCheckInterval is how often the progress of the initial
+// sync is checked, when the store-gateway may be ready before it completes.
+const initialSyncProgressCheckInterval = time.Second
+
 type BucketStoreConfig struct {
-	SyncDir               string        `yaml:"sync_dir"`
-	SyncInterval          time.Duration `yaml:"sync_interval" category:"advanced"`
-	TenantSyncConcurrency int           `yaml:"tenant_sync_concurrency" category:"advanced"`
-	IgnoreBlocksWithin    time.Duration `yaml:"ignore_blocks_within" category:"advanced"`
+	SyncDir                           string            `yaml:"sync_dir"`
+	SyncInterval                      time.Duration     `yaml:"sync_interval" category:"advanced"`
+	TenantSyncConcurrency             int               `yaml:"tenant_sync_concurrency" category:"advanced"`
+	BlockSyncConcurrency              int               `yaml:"block_sync_concurrency" category:"advanced"`
+	InitialSyncMaxBytesPerSecond      int64             `yaml:"initial_sync_max_bytes_per_second" category:"advanced"`
+	InitialSyncReadyPercentage        int               `yaml:"initial_sync_ready_percentage" category:"advanced"`
+	IgnoreBlocksWithin                time.Duration     `yaml:"ignore_blocks_within" category:"advanced"`
+	IndexHeaderLazyLoadingEnabled     bool              `yaml:"index_header_lazy_loading_enabled" category:"advanced"`
+	IndexHeaderLazyLoadingIdleTimeout time.Duration     `yaml:"index_header_lazy_loading_idle_timeout" category:"advanced"`
+	PrefetchConcurrency               int               `yaml:"prefetch_concurrency" category:"advanced"`
+	ChunksCache                       ChunksCacheConfig `yaml:"chunks_cache"`
+	DiskCache                         DiskCacheConfig   `yaml:"disk_cache"`
+	BucketIndex                       BucketIndexConfig `yaml:"bucket_index"`
+}
+
+// ChunksCacheConfig configures the cache of the ranges of the block files
+// read by the store-gateway, such as parquet pages and symbols.
+type ChunksCacheConfig struct {
+	Backend   string                      `yaml:"backend"`
+	Memcached cache.MemcachedClientConfig `yaml:"memcached"`
+}
+
+func (cfg *ChunksCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.Backend, prefix+"backend", "", fmt.Sprintf("Backend for the store-gateway chunks cache, if not empty. Supported values: %s.", cache.BackendMemcached))
+	cfg.Memcached.RegisterFlagsWithPrefix(prefix+"memcached.", f)
+}
+
+func (cfg *ChunksCacheConfig) Validate() error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case cache.BackendMemcached:
+		if len(cfg.Memcached.Addresses) == 0 {
+			return fmt.Errorf("no memcached addresses configured for the chunks cache")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported chunks cache backend: %q", cfg.Backend)
+	}
+}
+
+// DiskCacheConfig configures the cache of the ranges of the block files read
+// by the store-gateway on the local disk. The cache is checked before the
+// chunks cache, if any.
+type DiskCacheConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Dir          string `yaml:"dir"`
+	MaxSizeBytes int64  `yaml:"max_size_bytes"`
+}
+
+func (cfg *DiskCacheConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "If enabled, the ranges of the block files read by the store-gateway are cached on the local disk. The entries cached are kept across restarts.")
+	f.StringVar(&cfg.Dir, prefix+"dir", "./data/pyroscope-disk-cache/", "Directory to store the disk cache entries in.")
+	f.Int64Var(&cfg.MaxSizeBytes, prefix+"max-size-bytes", 10<<30, "Maximum size in bytes of the disk cache. The least recently used entries are evicted once the size is exceeded.")
+}
+
+func (cfg *DiskCacheConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Dir == "" {
+		return errors.New("no disk cache directory configured")
+	}
+	if cfg.MaxSizeBytes <= 0 {
+		return errors.New("the disk cache max size must be positive")
+	}
+	return nil
 }
 
 // RegisterFlags registers the BucketStore flags
 func (cfg *BucketStoreConfig) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	// cfg.IndexCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.index-cache.")
-	// cfg.ChunksCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.chunks-cache.", logger)
+	cfg.ChunksCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.chunks-cache.")
+	cfg.DiskCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.disk-cache.")
 	// cfg.MetadataCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.metadata-cache.")
-	// cfg.BucketIndex.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.bucket-index.")
+	cfg.BucketIndex.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.bucket-index.")
 	// cfg.IndexHeader.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.index-header.")
 
 	f.StringVar(&cfg.SyncDir, "blocks-storage.bucket-store.sync-dir", "./data/pyroscope-sync/", "Directory to store synchronized pyroscope block headers. This directory is not required to be persisted between restarts, but it's highly recommended in order to improve the store-gateway startup time.")
 	f.DurationVar(&cfg.SyncInterval, "blocks-storage.bucket-store.sync-interval", 15*time.Minute, "How frequently to scan the bucket, or to refresh the bucket index (if enabled), in order to look for changes (new blocks shipped by ingesters and blocks deleted by retention or compaction).")
 	f.IntVar(&cfg.TenantSyncConcurrency, "blocks-storage.bucket-store.tenant-sync-concurrency", 10, "Maximum number of concurrent tenants synching blocks.")
+	f.IntVar(&cfg.BlockSyncConcurrency, "blocks-storage.bucket-store.block-sync-concurrency", 100, "Maximum number of concurrent blocks synching per tenant.")
+	f.Int64Var(&cfg.InitialSyncMaxBytesPerSecond, "blocks-storage.bucket-store.initial-sync-max-bytes-per-second", 0, "Maximum number of bytes per second read from the object storage while the store-gateway runs its initial sync. 0 to disable the limit.")
+	f.IntVar(&cfg.InitialSyncReadyPercentage, "blocks-storage.bucket-store.initial-sync-ready-percentage", 100, "Percentage of the blocks that must be synced for the store-gateway to become ready at startup. The remaining blocks are synced in the background.")
+	f.BoolVar(&cfg.IndexHeaderLazyLoadingEnabled, "blocks-storage.bucket-store.index-header-lazy-loading-enabled", true, "If enabled, store-gateway will lazy load an index-header only once required by a query, and load again after a restart the index-headers loaded before it. Otherwise, the index-headers of the blocks of the last 24h are loaded when the blocks are synced.")
+	f.DurationVar(&cfg.IndexHeaderLazyLoadingIdleTimeout, "blocks-storage.bucket-store.index-header-lazy-loading-idle-timeout", 60*time.Minute, "If index-header lazy loading is enabled and this setting is > 0, the store-gateway will offload unused index-headers after 'idle timeout' inactivity.")
+	f.IntVar(&cfg.PrefetchConcurrency, "blocks-storage.bucket-store.prefetch-concurrency", 4, "Maximum number of blocks loaded concurrently ahead of the queries, on the hints of the query-frontend about the time ranges queried next. 0 to disable prefetching.")
 	f.DurationVar(&cfg.IgnoreBlocksWithin, "blocks-storage.bucket-store.ignore-blocks-within", 2*time.Hour, "Blocks with minimum time within this duration are ignored, and not loaded by store-gateway. Useful when used together with -querier.query-store-after to prevent loading young blocks, because there are usually many of them (depending on number of ingesters) and they are not yet compacted. Negative values or 0 disable the filter.")
 
 	// f.Uint64Var(&cfg.MaxChunkPoolBytes, "blocks-storage.bucket-store.max-chunk-pool-bytes", uint64(2*units.Gibibyte), "Max size - in bytes - of a chunks pool, used to reduce memory allocations. The pool is shared across all tenants. 0 to disable the limit.")
@@ -48,14 +124,11 @@ func (cfg *BucketStoreConfig) RegisterFlags(f *flag.FlagSet, logger log.Logger)
 	// f.IntVar(&cfg.ChunkPoolMaxBucketSizeBytes, "blocks-storage.bucket-store.chunk-pool-max-bucket-size-bytes", ChunkPoolDefaultMaxBucketSize, "Size - in bytes - of the largest chunks pool bucket.")
 	// f.Uint64Var(&cfg.SeriesHashCacheMaxBytes, "blocks-storage.bucket-store.series-hash-cache-max-size-bytes", uint64(1*units.Gibibyte), "Max size - in bytes - of the in-memory series hash cache. The cache is shared across all tenants and it's used only when query sharding is enabled.")
 	// f.IntVar(&cfg.MaxConcurrent, "blocks-storage.bucket-store.max-concurrent", 100, "Max number of concurrent queries to execute against the long-term storage. The limit is shared across all tenants.")
-	// f.IntVar(&cfg.BlockSyncConcurrency, "blocks-storage.bucket-store.block-sync-concurrency", 20, "Maximum number of concurrent blocks synching per tenant.")
 	// f.IntVar(&cfg.MetaSyncConcurrency, "blocks-storage.bucket-store.meta-sync-concurrency", 20, "Number of Go routines to use when syncing block meta files from object storage per tenant.")
 	// f.DurationVar(&cfg.DeprecatedConsistencyDelay, consistencyDelayFlag, 0, "Minimum age of a block before it's being read. Set it to safe value (e.g 30m) if your object storage is eventually consistent. GCS and S3 are (roughly) strongly consistent.")
 	// f.DurationVar(&cfg.IgnoreDeletionMarksDelay, "blocks-storage.bucket-store.ignore-deletion-marks-delay", time.Hour*1, "Duration after which the blocks marked for deletion will be filtered out while fetching blocks. "+
 	// 	"The idea of ignore-deletion-marks-delay is to ignore blocks that are marked for deletion with some delay. This ensures store can still serve blocks that are meant to be deleted but do not have a replacement yet.")
 	// f.IntVar(&cfg.PostingOffsetsInMemSampling, "blocks-storage.bucket-store.posting-offsets-in-mem-sampling", DefaultPostingOffsetInMemorySampling, "Controls what is the ratio of postings offsets that the store will hold in memory.")
-	// f.BoolVar(&cfg.IndexHeaderLazyLoadingEnabled, "blocks-storage.bucket-store.index-header-lazy-loading-enabled", true, "If enabled, store-gateway will lazy load an index-header only once required by a query.")
-	// f.DurationVar(&cfg.IndexHeaderLazyLoadingIdleTimeout, "blocks-storage.bucket-store.index-header-lazy-loading-idle-timeout", 60*time.Minute, "If index-header lazy loading is enabled and this setting is > 0, the store-gateway will offload unused index-headers after 'idle timeout' inactivity.")
 	// f.Uint64Var(&cfg.PartitionerMaxGapBytes, "blocks-storage.bucket-store.partitioner-max-gap-bytes", DefaultPartitionerMaxGapSize, "Max size - in bytes - of a gap for which the partitioner aggregates together two bucket GET object requests.")
 	// f.IntVar(&cfg.StreamingBatchSize, "blocks-storage.bucket-store.batch-series-size", 5000, "This option controls how many series to fetch per batch. The batch size must be greater than 0.")
 	// f.IntVar(&cfg.ChunkRangesPerSeries, "blocks-storage.bucket-store.fine-grained-chunks-caching-ranges-per-series", 1, "This option controls into how many ranges the chunks of each series from each block are split. This value is effectively the number of chunks cache items per series per block when -blocks-storage.bucket-store.chunks-cache.fine-grained-chunks-caching-enabled is enabled.")
@@ -70,9 +143,21 @@ func (cfg *BucketStoreConfig) Validate(logger log.Logger) error {
 	// if err := cfg.IndexCache.Validate(); err != nil {
 	// 	return errors.Wrap(err, "index-cache configuration")
 	// }
-	// if err := cfg.ChunksCache.Validate(); err != nil {
-	// 	return errors.Wrap(err, "chunks-cache configuration")
-	// }
+	if err := cfg.ChunksCache.Validate(); err != nil {
+		return errors.Wrap(err, "chunks-cache configuration")
+	}
+	if err := cfg.DiskCache.Validate(); err != nil {
+		return errors.Wrap(err, "disk-cache configuration")
+	}
+	if cfg.BlockSyncConcurrency <= 0 {
+		return errors.New("the block sync concurrency must be positive")
+	}
+	if cfg.InitialSyncMaxBytesPerSecond < 0 {
+		return errors.New("the initial sync max bytes per second must not be negative")
+	}
+	if cfg.InitialSyncReadyPercentage < 0 || cfg.InitialSyncReadyPercentage > 100 {
+		return errors.New("the initial sync ready percentage must be between 0 and 100")
+	}
 	// if err := cfg.MetadataCache.Validate(); err != nil {
 	// 	return errors.Wrap(err, "metadata-cache configuration")
 	// }
@@ -93,6 +178,14 @@ type BucketStores struct {
 	shardingStrategy  ShardingStrategy
 	limits            Limits
 	reg               prometheus.Registerer
+	// Limits the number of blocks prefetched concurrently, nil if
+	// prefetching is disabled.
+	prefetchSem chan struct{}
+	// Limits the reads of the initial sync, nil if there is no limit.
+	throttled *throttledBucket
+	// Serializes the syncs, as the initial sync may complete in the
+	// background.
+	syncMtx sync.Mutex
 	// Keeps a bucket store for each tenant.
 	storesMu sync.RWMutex
 	stores   map[string]*BucketStore
@@ -106,7 +199,34 @@ type BucketStores struct {
 	metrics           *Metrics
 }
 
-func NewBucketStores(cfg BucketStoreConfig, shardingStrategy ShardingStrategy, storageBucket phlareobj.Bucket, limits Limits, logger log.Logger, reg prometheus.Registerer) (*BucketStores, error) {
+func NewBucketStores(cfg BucketStoreConfig, shardingStrategy ShardingStrategy, storageBucket phlareobj.Bucket, keys phlareobj.KeyProvider, limits Limits, logger log.Logger, reg prometheus.Registerer) (*BucketStores, error) {
+	// The reads are limited below the caches, so that the blocks cached are
+	// loaded at full speed.
+	var throttled *throttledBucket
+	if cfg.InitialSyncMaxBytesPerSecond > 0 {
+		throttled = newThrottledBucket(storageBucket)
+		storageBucket = throttled
+	}
+	if cfg.ChunksCache.Backend != "" {
+		chunksCache, err := cache.CreateClient("store-gateway-chunks-cache", cache.BackendConfig{
+			Backend:   cfg.ChunksCache.Backend,
+			Memcached: cfg.ChunksCache.Memcached,
+		}, logger, reg)
+		if err != nil {
+			return nil, errors.Wrap(err, "create chunks cache")
+		}
+		storageBucket = phlareobj.NewCachingBucket(storageBucket, chunksCache, reg)
+	}
+	if cfg.DiskCache.Enabled {
+		diskCache, err := phlareobj.NewDiskCache(cfg.DiskCache.Dir, cfg.DiskCache.MaxSizeBytes, logger, reg)
+		if err != nil {
+			return nil, errors.Wrap(err, "create disk cache")
+		}
+		storageBucket = phlareobj.NewDiskCachingBucket(storageBucket, diskCache, reg)
+	}
+	// The blocks are decrypted above the caches, so that they only hold
+	// encrypted data.
+	storageBucket = phlareobj.NewEncryptedBucket(storageBucket, keys, nil)
 	bs := &BucketStores{
 		storageBucket: storageBucket,
 		logger:        logger,
@@ -118,10 +238,14 @@ func NewBucketStores(cfg BucketStoreConfig, shardingStrategy ShardingStrategy, s
 		},
 		stores:           map[string]*BucketStore{},
 		shardingStrategy: shardingStrategy,
+		throttled:        throttled,
 		reg:              reg,
 		limits:           limits,
 		metrics:          NewMetrics(reg),
 	}
+	if cfg.PrefetchConcurrency > 0 {
+		bs.prefetchSem = make(chan struct{}, cfg.PrefetchConcurrency)
+	}
 	// Register metrics.
 	bs.syncTimes = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 		Name:    "pyroscope_bucket_stores_blocks_sync_seconds",
@@ -154,21 +278,68 @@ func (bs *BucketStores) SyncBlocks(ctx context.Context) error {
 	})
 }
 
+// CloseIdleBlocks unloads the blocks idle for longer than the lazy loading
+// idle timeout, and records the blocks loaded for every user.
+func (bs *BucketStores) CloseIdleBlocks() {
+	bs.storesMu.RLock()
+	stores := make([]*BucketStore, 0, len(bs.stores))
+	for _, s := range bs.stores {
+		stores = append(stores, s)
+	}
+	bs.storesMu.RUnlock()
+
+	for _, s := range stores {
+		s.closeIdleBlocks()
+	}
+}
+
+// InitialSync synchronizes the blocks of every user, and returns once the
+// configured percentage of the blocks is synced. The remaining blocks are
+// synced in the background.
 func (bs *BucketStores) InitialSync(ctx context.Context) error {
 	level.Info(bs.logger).Log("msg", "synchronizing Pyroscope blocks for all users")
 
-	if err := bs.syncUsersBlocksWithRetries(ctx, func(ctx context.Context, s *BucketStore) error {
-		return s.InitialSync(ctx)
-	}); err != nil {
-		level.Warn(bs.logger).Log("msg", "failed to synchronize Pyroscope blocks", "err", err)
-		return err
+	if bs.throttled != nil {
+		bs.throttled.setLimit(bs.cfg.InitialSyncMaxBytesPerSecond)
 	}
+	progress := newSyncProgress()
+	done := make(chan error, 1)
+	go func() {
+		err := bs.syncUsersBlocksWithRetries(contextWithSyncProgress(ctx, progress), func(ctx context.Context, s *BucketStore) error {
+			return s.InitialSync(ctx)
+		})
+		if bs.throttled != nil {
+			bs.throttled.setLimit(0)
+		}
+		if err != nil {
+			level.Warn(bs.logger).Log("msg", "failed to synchronize Pyroscope blocks", "err", err)
+		} else {
+			level.Info(bs.logger).Log("msg", "successfully synchronized Pyroscope blocks for all users")
+		}
+		done <- err
+	}()
 
-	level.Info(bs.logger).Log("msg", "successfully synchronized Pyroscope blocks for all users")
-	return nil
+	if bs.cfg.InitialSyncReadyPercentage >= 100 {
+		return <-done
+	}
+	ticker := time.NewTicker(initialSyncProgressCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if pct, ok := progress.percentage(); ok && pct >= float64(bs.cfg.InitialSyncReadyPercentage) {
+				level.Info(bs.logger).Log("msg", "synchronized enough Pyroscope blocks to be ready, synchronizing the remaining blocks in the background", "percentage", pct)
+				return nil
+			}
+		}
+	}
 }
 
 func (bs *BucketStores) syncUsersBlocksWithRetries(ctx context.Context, f func(context.Context, *BucketStore) error) error {
+	bs.syncMtx.Lock()
+	defer bs.syncMtx.Unlock()
 	retries := backoff.New(ctx, bs.syncBackoffConfig)
 
 	var lastErr error
@@ -226,6 +397,9 @@ func (bs *BucketStores) syncUsersBlocks(ctx context.Context, f func(context.Cont
 
 	bs.tenantsDiscovered.Set(float64(len(userIDs)))
 	bs.tenantsSynced.Set(float64(len(includeUserIDs)))
+	if progress := syncProgressFromContext(ctx); progress != nil {
+		progress.expectTenants(len(includeUserIDs))
+	}
 
 	// Create a pool of workers which will synchronize blocks. The pool size
 	// is limited in order to avoid to concurrently sync a lot of tenants in
@@ -317,6 +491,10 @@ func (bs *BucketStores) getOrCreateStore(userID string) (*BucketStore, error) {
 		bs.storageBucket,
 		userID,
 		bs.syncDirForUser(userID),
+		bs.cfg.IndexHeaderLazyLoadingEnabled,
+		bs.cfg.IndexHeaderLazyLoadingIdleTimeout,
+		bs.cfg.BucketIndex,
+		bs.cfg.BlockSyncConcurrency,
 		filters,
 		userLogger,
 		bs.metrics,
@@ -394,6 +572,7 @@ func (bs *BucketStores) closeBucketStore(userID string) error {
 	delete(bs.stores, userID)
 	unlockInDefer = false
 	bs.storesMu.Unlock()
+	bs.metrics.deleteTenantUsage(userID)
 
 	return s.RemoveBlocksAndClose()
 }
@@ -27,6 +27,10 @@ const (
 	// ringAutoForgetUnhealthyPeriods is how many consecutive timeout periods an unhealthy instance
 	// in the ring will be automatically removed.
 	ringAutoForgetUnhealthyPeriods = 10
+
+	// idleBlocksCheckInterval is how often blocks are checked for being
+	// idle, when they are loaded lazily.
+	idleBlocksCheckInterval = time.Minute
 )
 
 // Validation errors.
@@ -63,12 +67,15 @@ type StoreGateway struct {
 type Config struct {
 	ShardingRing      RingConfig        `yaml:"sharding_ring" doc:"description=The hash ring configuration."`
 	BucketStoreConfig BucketStoreConfig `yaml:"bucket_store,omitempty"`
+
+	TenantDeletionCleanupInterval time.Duration `yaml:"tenant_deletion_cleanup_interval" category:"advanced"`
 }
 
 // RegisterFlags registers the Config flags.
 func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	cfg.ShardingRing.RegisterFlags(f, logger)
 	cfg.BucketStoreConfig.RegisterFlags(f, logger)
+	f.DurationVar(&cfg.TenantDeletionCleanupInterval, "store-gateway.tenant-deletion-cleanup-interval", 15*time.Minute, "How frequently the data of the tenants marked for deletion is deleted from the bucket. The data of each tenant is deleted by a single store-gateway. 0 to disable.")
 }
 
 func (c *Config) Validate(limits validation.Limits) error {
@@ -82,7 +89,7 @@ func (c *Config) Validate(limits validation.Limits) error {
 	return nil
 }
 
-func NewStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, limits Limits, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
+func NewStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, keys phlareobj.KeyProvider, limits Limits, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
 	ringStore, err := kv.NewClient(
 		gatewayCfg.ShardingRing.Ring.KVStore,
 		ring.GetCodec(),
@@ -93,10 +100,10 @@ func NewStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, limits L
 		return nil, errors.Wrap(err, "create KV store client")
 	}
 
-	return newStoreGateway(gatewayCfg, storageBucket, ringStore, limits, logger, reg)
+	return newStoreGateway(gatewayCfg, storageBucket, keys, ringStore, limits, logger, reg)
 }
 
-func newStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, ringStore kv.Client, limits Limits, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
+func newStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, keys phlareobj.KeyProvider, ringStore kv.Client, limits Limits, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
 	var err error
 
 	g := &StoreGateway{
@@ -141,7 +148,7 @@ func newStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, ringStor
 
 	shardingStrategy = NewShuffleShardingStrategy(g.ring, lifecyclerCfg.ID, lifecyclerCfg.Addr, limits, logger)
 
-	g.stores, err = NewBucketStores(gatewayCfg.BucketStoreConfig, shardingStrategy, storageBucket, limits, logger, prometheus.WrapRegistererWith(prometheus.Labels{"component": "store-gateway"}, reg))
+	g.stores, err = NewBucketStores(gatewayCfg.BucketStoreConfig, shardingStrategy, storageBucket, keys, limits, logger, prometheus.WrapRegistererWith(prometheus.Labels{"component": "store-gateway"}, reg))
 	if err != nil {
 		return nil, errors.Wrap(err, "create bucket stores")
 	}
@@ -211,8 +218,9 @@ func (g *StoreGateway) starting(ctx context.Context) (err error) {
 	}
 
 	// Now that the initial sync is done, we should have loaded all blocks
-	// assigned to our shard, so we can switch to ACTIVE and start serving
-	// requests.
+	// assigned to our shard (or the configured percentage of them, the rest
+	// being loaded in the background), so we can switch to ACTIVE and start
+	// serving requests.
 	if err = g.ringLifecycler.ChangeState(ctx, ring.ACTIVE); err != nil {
 		return errors.Wrapf(err, "switch instance to %s in the ring", ring.ACTIVE)
 	}
@@ -239,10 +247,30 @@ func (g *StoreGateway) running(ctx context.Context) error {
 	ringTicker := time.NewTicker(util.DurationWithJitter(g.gatewayCfg.ShardingRing.RingCheckPeriod, 0.2))
 	defer ringTicker.Stop()
 
+	// Check for idle blocks to unload, and record the blocks loaded so they
+	// are loaded again after a restart.
+	var idleBlocksC <-chan time.Time
+	if g.gatewayCfg.BucketStoreConfig.IndexHeaderLazyLoadingEnabled {
+		idleBlocksTicker := time.NewTicker(idleBlocksCheckInterval)
+		defer idleBlocksTicker.Stop()
+		idleBlocksC = idleBlocksTicker.C
+	}
+
+	var tenantDeletionC <-chan time.Time
+	if g.gatewayCfg.TenantDeletionCleanupInterval > 0 {
+		tenantDeletionTicker := time.NewTicker(util.DurationWithJitter(g.gatewayCfg.TenantDeletionCleanupInterval, 0.2))
+		defer tenantDeletionTicker.Stop()
+		tenantDeletionC = tenantDeletionTicker.C
+	}
+
 	for {
 		select {
 		case <-syncTicker.C:
 			g.syncStores(ctx, syncReasonPeriodic)
+		case <-idleBlocksC:
+			g.stores.CloseIdleBlocks()
+		case <-tenantDeletionC:
+			g.cleanupDeletedTenants(ctx)
 		case <-ringTicker.C:
 			// We ignore the error because in case of error it will return an empty
 			// replication set which we use to compare with the previous state.
@@ -261,6 +289,9 @@ func (g *StoreGateway) running(ctx context.Context) error {
 }
 
 func (g *StoreGateway) stopping(_ error) error {
+	if g.gatewayCfg.BucketStoreConfig.IndexHeaderLazyLoadingEnabled {
+		g.stores.CloseIdleBlocks()
+	}
 	if g.subservices != nil {
 		if err := services.StopManagerAndAwaitStopped(context.Background(), g.subservices); err != nil {
 			level.Warn(g.logger).Log("msg", "failed to stop store-gateway subservices", "err", err)
@@ -8,14 +8,18 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
 )
 
 //go:embed blocks.gohtml
@@ -145,6 +149,75 @@ func (s *StoreGateway) BlocksHandler(w http.ResponseWriter, req *http.Request) {
 	}, blocksPageTemplate, req)
 }
 
+// loadedBlock is a block of a tenant the store-gateway holds.
+type loadedBlock struct {
+	ID              string    `json:"id"`
+	MinTime         time.Time `json:"min_time"`
+	MaxTime         time.Time `json:"max_time"`
+	SizeBytes       uint64    `json:"size_bytes"`
+	NumSeries       uint64    `json:"num_series"`
+	NumProfiles     uint64    `json:"num_profiles"`
+	CompactionLevel int       `json:"compaction_level"`
+	// Open is false when the block is lazy loaded, and not read recently.
+	Open bool `json:"open"`
+}
+
+// LoadedBlocksHandler returns the blocks of the tenant the store-gateway
+// holds, with their size, ordered by min time. The list is empty if the
+// tenant is not sharded to the store-gateway. A tenant can only request its
+// own blocks.
+func (s *StoreGateway) LoadedBlocksHandler(w http.ResponseWriter, req *http.Request) {
+	tenantID := mux.Vars(req)["tenant"]
+	if tenantID == "" {
+		httputil.ErrorWithStatus(w, errors.New("tenant ID can't be empty"), http.StatusBadRequest)
+		return
+	}
+	if err := tenant.CheckTenant(req.Context(), tenantID); err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusForbidden)
+		return
+	}
+	blocks := make([]loadedBlock, 0)
+	if store := s.stores.getStore(tenantID); store != nil {
+		blocks = store.loadedBlocks()
+	}
+	util.WriteJSONResponse(w, struct {
+		TenantID string        `json:"tenant_id"`
+		Blocks   []loadedBlock `json:"blocks"`
+	}{TenantID: tenantID, Blocks: blocks})
+}
+
+func (s *BucketStore) loadedBlocks() []loadedBlock {
+	s.blocksMx.RLock()
+	res := make([]loadedBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		var size uint64
+		for _, f := range b.meta.Files {
+			size += f.SizeBytes
+		}
+		b.mtx.Lock()
+		open := b.loaded
+		b.mtx.Unlock()
+		res = append(res, loadedBlock{
+			ID:              b.meta.ULID.String(),
+			MinTime:         util.TimeFromMillis(int64(b.meta.MinTime)).UTC(),
+			MaxTime:         util.TimeFromMillis(int64(b.meta.MaxTime)).UTC(),
+			SizeBytes:       size,
+			NumSeries:       b.meta.Stats.NumSeries,
+			NumProfiles:     b.meta.Stats.NumProfiles,
+			CompactionLevel: b.meta.Compaction.Level,
+			Open:            open,
+		})
+	}
+	s.blocksMx.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].MinTime.Equal(res[j].MinTime) {
+			return res[i].MinTime.Before(res[j].MinTime)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
 // func formatTimeIfNotZero(t time.Time, format string) string {
 // 	if t.IsZero() {
 // 		return ""
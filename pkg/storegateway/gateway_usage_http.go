@@ -0,0 +1,35 @@
+package storegateway
+
+import (
+	"net/http"
+
+	"github.com/grafana/dskit/tenant"
+	"github.com/pkg/errors"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+	"github.com/grafana/pyroscope/pkg/util"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// TenantStorageUsageHandler reports the storage used by the blocks of the
+// tenant, as recorded in its bucket index. The bucket is not scanned: the
+// usage is not available until the bucket index is written.
+func (s *StoreGateway) TenantStorageUsageHandler(w http.ResponseWriter, req *http.Request) {
+	tenantID, err := tenant.TenantID(req.Context())
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusUnauthorized)
+		return
+	}
+	bkt := phlareobj.NewPrefixedBucket(s.stores.storageBucket, tenantID+"/phlaredb")
+	idx, err := bucketindex.ReadIndex(req.Context(), bkt, "", nil, s.logger)
+	if errors.Is(err, bucketindex.ErrIndexNotFound) {
+		httputil.ErrorWithStatus(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSONResponse(w, idx.Usage())
+}
@@ -0,0 +1,171 @@
+package storegateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+// lazyLoadedBlocksFilename is the file of the tenant sync directory
+// recording the blocks loaded, so they are loaded again after a restart.
+const lazyLoadedBlocksFilename = "lazy-loaded.json"
+
+// indexHeaderBucket serves the TSDB index of the blocks from the sync
+// directory, where it is downloaded the first time it is read. This way,
+// the index is not downloaded again when a block is loaded after it has been
+// unloaded, or after a restart.
+type indexHeaderBucket struct {
+	phlareobj.Bucket
+	dir string
+}
+
+func (b *indexHeaderBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if path.Base(name) != block.IndexFilename {
+		return b.Bucket.Get(ctx, name)
+	}
+	localPath := filepath.Join(b.dir, filepath.FromSlash(name))
+	f, err := os.Open(localPath)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err = b.download(ctx, name, localPath); err != nil {
+		return nil, errors.Wrap(err, "download index header")
+	}
+	return os.Open(localPath)
+}
+
+func (b *indexHeaderBucket) download(ctx context.Context, name, localPath string) error {
+	r, err := b.Bucket.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// The index is renamed once written, so that a partially written file is
+	// never read.
+	tmpPath := localPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, localPath)
+}
+
+type lazyLoadedBlocks struct {
+	// Blocks maps the blocks loaded to the time they were last used, in
+	// milliseconds since epoch.
+	Blocks map[ulid.ULID]int64 `json:"blocks"`
+}
+
+// closeIdleBlocks unloads the blocks not read by any query for the idle
+// timeout, and records the blocks still loaded.
+func (s *BucketStore) closeIdleBlocks() {
+	s.blocksMx.RLock()
+	blocks := make([]*Block, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+	s.blocksMx.RUnlock()
+
+	if s.lazyLoadingIdleTimeout > 0 {
+		for _, b := range blocks {
+			closed, err := b.closeIfIdle(s.lazyLoadingIdleTimeout)
+			if err != nil {
+				level.Warn(s.logger).Log("msg", "failed to unload idle block", "block", b.meta.ULID, "err", err)
+			}
+			if closed {
+				s.metrics.blockUnloads.Inc()
+			}
+		}
+	}
+	if err := s.persistLoadedBlocks(blocks); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to record loaded blocks", "err", err)
+	}
+}
+
+func (s *BucketStore) persistLoadedBlocks(blocks []*Block) error {
+	state := lazyLoadedBlocks{Blocks: make(map[ulid.ULID]int64)}
+	for _, b := range blocks {
+		if lastUsed, loaded := b.lastUsedTime(); loaded {
+			state.Blocks[b.meta.ULID] = lastUsed.UnixMilli()
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(s.syncDir, lazyLoadedBlocksFilename)
+	tmpFilename := filename + ".tmp"
+	if err = os.WriteFile(tmpFilename, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// loadPersistedBlocks loads the blocks which were loaded before a restart,
+// and were used within the idle timeout. Their index header is read from the
+// sync directory, if it was kept.
+func (s *BucketStore) loadPersistedBlocks(ctx context.Context) error {
+	data, err := os.ReadFile(filepath.Join(s.syncDir, lazyLoadedBlocksFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state lazyLoadedBlocks
+	if err = json.Unmarshal(data, &state); err != nil {
+		return errors.Wrap(err, "decode loaded blocks")
+	}
+
+	var (
+		queriers = make(phlaredb.Queriers, 0, len(state.Blocks))
+		blocks   = make([]*Block, 0, len(state.Blocks))
+	)
+	for id, lastUsed := range state.Blocks {
+		if s.lazyLoadingIdleTimeout > 0 && time.Since(time.UnixMilli(lastUsed)) >= s.lazyLoadingIdleTimeout {
+			continue
+		}
+		if b := s.getBlock(id); b != nil {
+			queriers = append(queriers, b)
+			blocks = append(blocks, b)
+		}
+	}
+	if len(queriers) == 0 {
+		return nil
+	}
+	start := time.Now()
+	if err = queriers.Open(ctx); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		b.mtx.Lock()
+		b.lastUsed = time.UnixMilli(state.Blocks[b.meta.ULID])
+		b.mtx.Unlock()
+	}
+	level.Info(s.logger).Log("msg", "loaded blocks loaded before restart", "blocks", len(blocks), "elapsed", time.Since(start))
+	return nil
+}
@@ -0,0 +1,109 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+func readObject(t *testing.T, b *indexHeaderBucket, name string) string {
+	t.Helper()
+	rc, err := b.Get(context.Background(), name)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func Test_indexHeaderBucket(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBucketStore(t, BucketIndexConfig{})
+	id := block.NewMeta().ULID
+	require.NoError(t, os.MkdirAll(s.localPath(id.String()), 0o750))
+	var (
+		indexName = path.Join(id.String(), block.IndexFilename)
+		metaName  = path.Join(id.String(), block.MetaFilename)
+	)
+	require.NoError(t, s.bucket.Upload(ctx, indexName, bytes.NewBufferString("index")))
+	require.NoError(t, s.bucket.Upload(ctx, metaName, bytes.NewBufferString("meta")))
+
+	b := &indexHeaderBucket{Bucket: s.bucket, dir: s.syncDir}
+	require.Equal(t, "index", readObject(t, b, indexName))
+	require.Equal(t, "meta", readObject(t, b, metaName))
+
+	// The index is downloaded to the sync directory, the other files are
+	// not.
+	data, err := os.ReadFile(filepath.Join(s.syncDir, id.String(), block.IndexFilename))
+	require.NoError(t, err)
+	require.Equal(t, "index", string(data))
+	_, err = os.Stat(filepath.Join(s.syncDir, id.String(), block.MetaFilename))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// The index is read from the sync directory once downloaded.
+	require.NoError(t, s.bucket.Delete(ctx, indexName))
+	require.Equal(t, "index", readObject(t, b, indexName))
+	_, err = b.Get(ctx, path.Join(block.NewMeta().ULID.String(), block.IndexFilename))
+	require.Error(t, err)
+}
+
+type fakeBlockCloser struct {
+	phlaredb.Querier
+	closed bool
+}
+
+func (b *fakeBlockCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func Test_closeIdleBlocks(t *testing.T) {
+	s := newTestBucketStore(t, BucketIndexConfig{})
+	s.lazyLoadingIdleTimeout = time.Minute
+
+	newBlock := func(loaded bool, lastUsed time.Time) *Block {
+		b := &Block{
+			BlockCloser: new(fakeBlockCloser),
+			meta:        block.NewMeta(),
+			loaded:      loaded,
+			lastUsed:    lastUsed,
+		}
+		s.blocks[b.meta.ULID] = b
+		return b
+	}
+	var (
+		idle     = newBlock(true, time.Now().Add(-2*time.Minute))
+		inflight = newBlock(true, time.Now().Add(-2*time.Minute))
+		used     = newBlock(true, time.Now())
+		unloaded = newBlock(false, time.Now())
+	)
+	inflight.acquire()
+
+	s.closeIdleBlocks()
+	require.True(t, idle.BlockCloser.(*fakeBlockCloser).closed)
+	require.False(t, inflight.BlockCloser.(*fakeBlockCloser).closed)
+	require.False(t, used.BlockCloser.(*fakeBlockCloser).closed)
+	require.False(t, unloaded.BlockCloser.(*fakeBlockCloser).closed)
+
+	// Only the blocks still loaded are loaded again after a restart.
+	data, err := os.ReadFile(filepath.Join(s.syncDir, lazyLoadedBlocksFilename))
+	require.NoError(t, err)
+	var state lazyLoadedBlocks
+	require.NoError(t, json.Unmarshal(data, &state))
+	require.Equal(t, map[ulid.ULID]int64{
+		inflight.meta.ULID: inflight.lastUsed.UnixMilli(),
+		used.meta.ULID:     used.lastUsed.UnixMilli(),
+	}, state.Blocks)
+}
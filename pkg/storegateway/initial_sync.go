@@ -0,0 +1,187 @@
+package storegateway
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+// throttledBucket limits the rate at which the block files are read from the
+// bucket, while a limit is set. The limit is set for the duration of the
+// initial sync, so that the store-gateways starting at the same time don't
+// saturate the bandwidth of the object storage.
+type throttledBucket struct {
+	phlareobj.Bucket
+
+	mtx     sync.RWMutex
+	limiter *rate.Limiter
+}
+
+func newThrottledBucket(bkt phlareobj.Bucket) *throttledBucket {
+	return &throttledBucket{Bucket: bkt}
+}
+
+// setLimit sets the maximum number of bytes read per second, 0 to remove
+// the limit.
+func (b *throttledBucket) setLimit(bytesPerSecond int64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if bytesPerSecond <= 0 {
+		b.limiter = nil
+		return
+	}
+	b.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+func (b *throttledBucket) getLimiter() *rate.Limiter {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return b.limiter
+}
+
+func (b *throttledBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, bucket: b}, nil
+}
+
+func (b *throttledBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, bucket: b}, nil
+}
+
+func (b *throttledBucket) ReaderAt(ctx context.Context, name string) (phlareobj.ReaderAtCloser, error) {
+	r, err := b.Bucket.ReaderAt(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReaderAt{ReaderAtCloser: r, ctx: ctx, bucket: b}, nil
+}
+
+// wait blocks until n bytes may be read. The limiter is checked at every
+// read, so the reads started during the initial sync are no longer limited
+// once it completes.
+func (b *throttledBucket) wait(ctx context.Context, n int) error {
+	limiter := b.getLimiter()
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		// A single wait can't exceed the burst, which is the limit per second.
+		c := n
+		if burst := limiter.Burst(); c > burst {
+			c = burst
+		}
+		if err := limiter.WaitN(ctx, c); err != nil {
+			return err
+		}
+		n -= c
+	}
+	return nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	bucket *throttledBucket
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if waitErr := r.bucket.wait(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+type throttledReaderAt struct {
+	phlareobj.ReaderAtCloser
+	ctx    context.Context
+	bucket *throttledBucket
+}
+
+func (r *throttledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAtCloser.ReadAt(p, off)
+	if waitErr := r.bucket.wait(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+type syncProgressKey struct{}
+
+// syncProgress tracks the blocks synced by the initial sync of all the
+// tenants, so the store-gateway can be ready before all of them are.
+type syncProgress struct {
+	mtx     sync.Mutex
+	tenants int
+	total   map[string]int
+	synced  map[string]int
+}
+
+func newSyncProgress() *syncProgress {
+	return &syncProgress{
+		total:  make(map[string]int),
+		synced: make(map[string]int),
+	}
+}
+
+func contextWithSyncProgress(ctx context.Context, p *syncProgress) context.Context {
+	return context.WithValue(ctx, syncProgressKey{}, p)
+}
+
+func syncProgressFromContext(ctx context.Context) *syncProgress {
+	p, _ := ctx.Value(syncProgressKey{}).(*syncProgress)
+	return p
+}
+
+// expectTenants sets the number of tenants synced.
+func (p *syncProgress) expectTenants(n int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.tenants = n
+}
+
+// listed records the number of blocks of the tenant, and how many of them
+// are already synced.
+func (p *syncProgress) listed(tenantID string, total, synced int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.total[tenantID] = total
+	p.synced[tenantID] = synced
+}
+
+// blockSynced records a block synced, whether it could be loaded or not.
+func (p *syncProgress) blockSynced(tenantID string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.synced[tenantID]++
+}
+
+// percentage returns the percentage of the blocks synced, once the blocks of
+// all the tenants are listed.
+func (p *syncProgress) percentage() (float64, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.total) < p.tenants {
+		return 0, false
+	}
+	var total, synced int
+	for tenantID, n := range p.total {
+		total += n
+		synced += p.synced[tenantID]
+	}
+	if total == 0 {
+		return 100, true
+	}
+	return 100 * float64(synced) / float64(total), true
+}
@@ -0,0 +1,116 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+)
+
+func newTestThrottledBucket(t *testing.T, objects map[string]int) *throttledBucket {
+	t.Helper()
+	bkt := phlareobj.NewBucket(objstore.NewInMemBucket())
+	for name, size := range objects {
+		require.NoError(t, bkt.Upload(context.Background(), name, bytes.NewReader(make([]byte, size))))
+	}
+	return newThrottledBucket(bkt)
+}
+
+func Test_throttledBucket(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reads larger than the limit are chunked", func(t *testing.T) {
+		b := newTestThrottledBucket(t, map[string]int{"object": 11_000})
+		b.setLimit(10_000)
+
+		// The limiter can't wait for more bytes than its burst at once.
+		start := time.Now()
+		r, err := b.ReaderAt(ctx, "object")
+		require.NoError(t, err)
+		n, err := r.ReadAt(make([]byte, 11_000), 0)
+		require.NoError(t, err)
+		require.Equal(t, 11_000, n)
+		require.NoError(t, r.Close())
+		// The burst covers the first 10000 bytes, the remaining ones take
+		// 100ms at the limit.
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		start = time.Now()
+		rc, err := b.Get(ctx, "object")
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Len(t, data, 11_000)
+		require.NoError(t, rc.Close())
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("the limit is removed after the initial sync", func(t *testing.T) {
+		b := newTestThrottledBucket(t, map[string]int{"object": 100_000})
+		b.setLimit(1_000)
+		rc, err := b.GetRange(ctx, "object", 0, 100_000)
+		require.NoError(t, err)
+
+		// The readers opened while the limit was set are no longer limited.
+		b.setLimit(0)
+		require.Nil(t, b.getLimiter())
+		start := time.Now()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Len(t, data, 100_000)
+		require.NoError(t, rc.Close())
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("the wait is canceled with the context", func(t *testing.T) {
+		b := newTestThrottledBucket(t, map[string]int{"object": 2_000})
+		b.setLimit(1_000)
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		rc, err := b.Get(ctx, "object")
+		require.NoError(t, err)
+		_, err = io.ReadAll(rc)
+		require.ErrorIs(t, err, context.Canceled)
+		require.NoError(t, rc.Close())
+	})
+}
+
+func Test_syncProgress(t *testing.T) {
+	p := newSyncProgress()
+	ctx := contextWithSyncProgress(context.Background(), p)
+	require.Same(t, p, syncProgressFromContext(ctx))
+	require.Nil(t, syncProgressFromContext(context.Background()))
+
+	p.expectTenants(2)
+	p.listed("tenant-a", 4, 1)
+	// The percentage is unknown until the blocks of all the tenants are
+	// listed.
+	_, ok := p.percentage()
+	require.False(t, ok)
+
+	p.listed("tenant-b", 4, 0)
+	pct, ok := p.percentage()
+	require.True(t, ok)
+	require.Equal(t, 12.5, pct)
+
+	p.blockSynced("tenant-a")
+	p.blockSynced("tenant-b")
+	p.blockSynced("tenant-b")
+	pct, ok = p.percentage()
+	require.True(t, ok)
+	require.Equal(t, 50.0, pct)
+
+	// Without blocks, the store-gateway is ready.
+	p = newSyncProgress()
+	p.expectTenants(1)
+	p.listed("tenant-a", 0, 0)
+	pct, ok = p.percentage()
+	require.True(t, ok)
+	require.Equal(t, 100.0, pct)
+}
@@ -2,6 +2,8 @@ package storegateway
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
 )
 
 type Metrics struct {
@@ -11,6 +13,14 @@ type Metrics struct {
 	blockLoadFailures prometheus.Counter
 	blockDrops        prometheus.Counter
 	blockDropFailures prometheus.Counter
+	blockUnloads      prometheus.Counter
+	blockPrefetches   prometheus.Counter
+
+	tenantBlocks          *prometheus.GaugeVec
+	tenantBlocksBytes     *prometheus.GaugeVec
+	tenantSeries          *prometheus.GaugeVec
+	tenantOldestBlockTime *prometheus.GaugeVec
+	tenantNewestBlockTime *prometheus.GaugeVec
 }
 
 func NewMetrics(reg prometheus.Registerer) *Metrics {
@@ -39,6 +49,54 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 		Name: "pyroscope_bucket_store_block_drop_failures_total",
 		Help: "Total number of local blocks that failed to be dropped.",
 	})
-	reg.MustRegister(m.Synced, m.blockDropFailures, m.blockDrops, m.blockLoadFailures, m.blockLoads)
+	m.blockUnloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pyroscope_bucket_store_block_lazy_unloads_total",
+		Help: "Total number of blocks unloaded after being idle.",
+	})
+	m.blockPrefetches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pyroscope_bucket_store_block_prefetches_total",
+		Help: "Total number of blocks loaded ahead of the queries, on the hints of the query-frontend.",
+	})
+	m.tenantBlocks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_index_blocks",
+		Help: "Number of blocks of the tenant in the bucket index.",
+	}, []string{"tenant"})
+	m.tenantBlocksBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_index_blocks_compressed_bytes",
+		Help: "Total size of the blocks of the tenant in the bucket index.",
+	}, []string{"tenant"})
+	m.tenantSeries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_index_series",
+		Help: "Total number of series of the blocks of the tenant in the bucket index.",
+	}, []string{"tenant"})
+	m.tenantOldestBlockTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_index_oldest_block_timestamp_seconds",
+		Help: "Minimum time of the oldest block of the tenant in the bucket index.",
+	}, []string{"tenant"})
+	m.tenantNewestBlockTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_index_newest_block_timestamp_seconds",
+		Help: "Maximum time of the newest block of the tenant in the bucket index.",
+	}, []string{"tenant"})
+	reg.MustRegister(m.Synced, m.blockDropFailures, m.blockDrops, m.blockLoadFailures, m.blockLoads, m.blockUnloads, m.blockPrefetches,
+		m.tenantBlocks, m.tenantBlocksBytes, m.tenantSeries, m.tenantOldestBlockTime, m.tenantNewestBlockTime)
 	return &m
 }
+
+// setTenantUsage exposes the storage usage of the tenant read from its
+// bucket index.
+func (m *Metrics) setTenantUsage(tenantID string, u bucketindex.Usage) {
+	m.tenantBlocks.WithLabelValues(tenantID).Set(float64(u.Blocks))
+	m.tenantBlocksBytes.WithLabelValues(tenantID).Set(float64(u.CompressedBytes))
+	m.tenantSeries.WithLabelValues(tenantID).Set(float64(u.Series))
+	m.tenantOldestBlockTime.WithLabelValues(tenantID).Set(float64(u.OldestBlockTime.Unix()))
+	m.tenantNewestBlockTime.WithLabelValues(tenantID).Set(float64(u.NewestBlockTime.Unix()))
+}
+
+// deleteTenantUsage removes the storage usage of a tenant no longer synced.
+func (m *Metrics) deleteTenantUsage(tenantID string) {
+	m.tenantBlocks.DeleteLabelValues(tenantID)
+	m.tenantBlocksBytes.DeleteLabelValues(tenantID)
+	m.tenantSeries.DeleteLabelValues(tenantID)
+	m.tenantOldestBlockTime.DeleteLabelValues(tenantID)
+	m.tenantNewestBlockTime.DeleteLabelValues(tenantID)
+}
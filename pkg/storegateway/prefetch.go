@@ -0,0 +1,43 @@
+package storegateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
+)
+
+// prefetchTimeout is the maximum time a block prefetch may take.
+const prefetchTimeout = time.Minute
+
+// prefetch loads, in the background, the blocks of the store within the time
+// range hinted by the query-frontend, which is about to be queried. This is
+// best effort: blocks are not prefetched once the prefetch concurrency is
+// reached, they are loaded by the query reading them instead.
+func (bs *BucketStores) prefetch(store *BucketStore, hint prefetch.Hint) {
+	if bs.prefetchSem == nil {
+		return
+	}
+	for _, b := range store.blockSet.getFor(hint.Start, hint.End) {
+		if _, loaded := b.lastUsedTime(); loaded {
+			continue
+		}
+		select {
+		case bs.prefetchSem <- struct{}{}:
+		default:
+			return
+		}
+		go func(b *Block) {
+			defer func() { <-bs.prefetchSem }()
+			ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+			defer cancel()
+			if err := b.Open(ctx); err != nil {
+				level.Warn(store.logger).Log("msg", "failed to prefetch block", "block", b.meta.ULID, "err", err)
+				return
+			}
+			bs.metrics.blockPrefetches.Inc()
+		}(b)
+	}
+}
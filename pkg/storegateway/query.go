@@ -3,6 +3,7 @@ package storegateway
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/pkg/errors"
@@ -10,6 +11,7 @@ import (
 
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/querier/prefetch"
 	"github.com/grafana/pyroscope/pkg/tenant"
 )
 
@@ -47,7 +49,9 @@ func (s *StoreGateway) Series(ctx context.Context, req *connect.Request[ingestv1
 	var res *ingestv1.SeriesResponse
 	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
 		var err error
-		res, err = phlaredb.Series(ctx, req.Msg, bs.openBlocksForReading)
+		open, release := bs.blocksForReading()
+		defer release()
+		res, err = phlaredb.Series(ctx, req.Msg, open)
 		if err != nil {
 			return err
 		}
@@ -60,6 +64,24 @@ func (s *StoreGateway) Series(ctx context.Context, req *connect.Request[ingestv1
 	return connect.NewResponse(res), nil
 }
 
+func (s *StoreGateway) MergeSpanProfile(ctx context.Context, req *connect.Request[ingestv1.MergeSpanProfileRequest]) (*connect.Response[ingestv1.MergeSpanProfileResponse], error) {
+	var res *ingestv1.MergeSpanProfileResponse
+	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		var err error
+		open, release := bs.blocksForReading()
+		defer release()
+		res, err = phlaredb.MergeSpanProfile(ctx, req.Msg, open)
+		return err
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeOf(err), err)
+	}
+	if res == nil {
+		res = new(ingestv1.MergeSpanProfileResponse)
+	}
+	return connect.NewResponse(res), nil
+}
+
 func terminateStream[Req, Resp any](stream *connect.BidiStream[Req, Resp]) (err error) {
 	if _, err = stream.Receive(); err != nil {
 		if errors.Is(err, io.EOF) {
@@ -81,31 +103,62 @@ func (s *StoreGateway) forBucketStore(ctx context.Context, f func(*BucketStore)
 	}
 	store := s.stores.getStore(tenantID)
 	if store != nil {
+		if hint, ok := prefetch.FromContext(ctx); ok {
+			s.stores.prefetch(store, hint)
+		}
 		return true, f(store)
 	}
 	return false, nil
 }
 
-func (s *BucketStore) openBlocksForReading(ctx context.Context, minT, maxT model.Time) (phlaredb.Queriers, error) {
-	blks := s.blockSet.getFor(minT, maxT)
-	querier := make(phlaredb.Queriers, 0, len(blks))
-	for _, b := range blks {
-		querier = append(querier, b)
+// blocksForReading returns the function opening the blocks read by a query,
+// and the function releasing them once the query is done, so they are not
+// unloaded while they are read.
+func (s *BucketStore) blocksForReading() (func(context.Context, model.Time, model.Time) (phlaredb.Queriers, error), func()) {
+	var (
+		mtx      sync.Mutex
+		acquired []*Block
+	)
+	open := func(ctx context.Context, minT, maxT model.Time) (phlaredb.Queriers, error) {
+		blks := s.blockSet.getFor(minT, maxT)
+		querier := make(phlaredb.Queriers, 0, len(blks))
+		mtx.Lock()
+		for _, b := range blks {
+			b.acquire()
+			acquired = append(acquired, b)
+			querier = append(querier, b)
+		}
+		mtx.Unlock()
+		if err := querier.Open(ctx); err != nil {
+			return nil, err
+		}
+		return querier, nil
 	}
-	if err := querier.Open(ctx); err != nil {
-		return nil, err
+	release := func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, b := range acquired {
+			b.release()
+		}
+		acquired = nil
 	}
-	return querier, nil
+	return open, release
 }
 
 func (store *BucketStore) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
-	return phlaredb.MergeProfilesStacktraces(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesStacktraces(ctx, stream, open)
 }
 
 func (store *BucketStore) MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse]) error {
-	return phlaredb.MergeProfilesLabels(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesLabels(ctx, stream, open)
 }
 
 func (store *BucketStore) MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse]) error {
-	return phlaredb.MergeProfilesPprof(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesPprof(ctx, stream, open)
 }
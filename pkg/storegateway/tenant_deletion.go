@@ -0,0 +1,195 @@
+package storegateway
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/grafana/dskit/ring"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/fnv32"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// The data of a tenant is deleted in two steps: the tenant is marked for
+// deletion, which makes the ingesters reject its profiles and stop shipping
+// its blocks, then the store-gateway owning the tenant in the ring deletes
+// the bucket index and all the objects of the tenant, except the deletion
+// mark. The mark is kept so that the tenant keeps being rejected.
+
+type tenantDeletionStatus struct {
+	TenantID        string     `json:"tenant_id"`
+	DeletionTime    *time.Time `json:"deletion_time,omitempty"`
+	FinishedTime    *time.Time `json:"finished_time,omitempty"`
+	BlocksRemaining int        `json:"blocks_remaining"`
+}
+
+// DeleteTenantHandler marks the tenant for deletion, and reports the status
+// of the deletion. Its data is deleted asynchronously. A tenant can only
+// delete itself.
+func (g *StoreGateway) DeleteTenantHandler(w http.ResponseWriter, req *http.Request) {
+	tenantID := mux.Vars(req)["tenant"]
+	if tenantID == "" {
+		http.Error(w, "Tenant ID can't be empty", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	if err := tenant.CheckTenant(ctx, tenantID); err != nil {
+		httputil.ErrorWithStatus(w, err, http.StatusForbidden)
+		return
+	}
+	exists, err := bucket.TenantDeletionMarkExists(ctx, g.stores.storageBucket, tenantID)
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	if !exists {
+		if err = bucket.WriteTenantDeletionMark(ctx, g.stores.storageBucket, tenantID, nil, bucket.NewTenantDeletionMark(time.Now())); err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		level.Info(g.logger).Log("msg", "tenant marked for deletion", "tenant", tenantID)
+	}
+	status, err := g.tenantDeletionStatus(ctx, tenantID)
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	util.WriteJSONResponse(w, status)
+}
+
+// DeleteTenantStatusHandler reports whether the tenant is marked for
+// deletion, when its data was deleted, and the number of its blocks left
+// in the bucket.
+func (g *StoreGateway) DeleteTenantStatusHandler(w http.ResponseWriter, req *http.Request) {
+	tenantID := mux.Vars(req)["tenant"]
+	if tenantID == "" {
+		http.Error(w, "Tenant ID can't be empty", http.StatusBadRequest)
+		return
+	}
+	status, err := g.tenantDeletionStatus(req.Context(), tenantID)
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	util.WriteJSONResponse(w, status)
+}
+
+func (g *StoreGateway) tenantDeletionStatus(ctx context.Context, tenantID string) (*tenantDeletionStatus, error) {
+	mark, err := bucket.ReadTenantDeletionMark(ctx, g.stores.storageBucket, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	status := &tenantDeletionStatus{TenantID: tenantID}
+	if mark != nil {
+		t := time.Unix(mark.DeletionTime, 0)
+		status.DeletionTime = &t
+		if mark.FinishedTime > 0 {
+			t := time.Unix(mark.FinishedTime, 0)
+			status.FinishedTime = &t
+		}
+	}
+	err = g.stores.storageBucket.Iter(ctx, path.Join(tenantID, "phlaredb")+"/", func(name string) error {
+		if _, err := ulid.Parse(path.Base(strings.TrimSuffix(name, "/"))); err == nil {
+			status.BlocksRemaining++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// cleanupDeletedTenants deletes the data of the tenants marked for deletion
+// that this store-gateway owns.
+func (g *StoreGateway) cleanupDeletedTenants(ctx context.Context) {
+	tenantIDs, err := g.stores.scanUsers(ctx)
+	if err != nil {
+		level.Warn(g.logger).Log("msg", "failed to scan tenants for deletion", "err", err)
+		return
+	}
+	for _, tenantID := range tenantIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		mark, err := bucket.ReadTenantDeletionMark(ctx, g.stores.storageBucket, tenantID)
+		if err != nil {
+			level.Warn(g.logger).Log("msg", "failed to read tenant deletion mark", "tenant", tenantID, "err", err)
+			continue
+		}
+		if mark == nil {
+			continue
+		}
+		if owned, err := g.ownsTenantDeletion(tenantID); err != nil || !owned {
+			continue
+		}
+		if err = g.deleteTenant(ctx, tenantID, mark); err != nil {
+			level.Warn(g.logger).Log("msg", "failed to delete tenant data", "tenant", tenantID, "err", err)
+		}
+	}
+}
+
+// ownsTenantDeletion returns true if this store-gateway is the one that
+// deletes the data of the tenant: only one of them does it.
+func (g *StoreGateway) ownsTenantDeletion(tenantID string) (bool, error) {
+	h := fnv32.New()
+	for i := 0; i < len(tenantID); i++ {
+		h = fnv32.AddByte32(h, tenantID[i])
+	}
+	bufDescs, bufHosts, bufZones := ring.MakeBuffersForGet()
+	set, err := g.ring.Get(h, BlocksOwnerSync, bufDescs, bufHosts, bufZones)
+	if err != nil {
+		return false, err
+	}
+	if len(set.Instances) == 0 {
+		return false, nil
+	}
+	return set.Instances[0].Addr == g.ringLifecycler.GetInstanceAddr(), nil
+}
+
+// deleteTenant deletes the bucket index first, so the blocks are no longer
+// discovered from it, then all the other objects of the tenant. Objects
+// uploaded after the deletion are deleted by the next cleanups.
+func (g *StoreGateway) deleteTenant(ctx context.Context, tenantID string, mark *bucket.TenantDeletionMark) error {
+	bkt := g.stores.storageBucket
+	if err := bucketindex.DeleteIndex(ctx, phlareobj.NewPrefixedBucket(bkt, tenantID), "phlaredb", nil); err != nil {
+		return err
+	}
+	markPath := path.Join(tenantID, bucket.TenantDeletionMarkPath)
+	var deleted int
+	err := bkt.Iter(ctx, tenantID+"/", func(name string) error {
+		if name == markPath {
+			return nil
+		}
+		if err := bkt.Delete(ctx, name); err != nil && !bkt.IsObjNotFoundErr(err) {
+			return errors.Wrapf(err, "deleting %s", name)
+		}
+		deleted++
+		return nil
+	}, objstore.WithRecursiveIter)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		level.Info(g.logger).Log("msg", "tenant data deleted", "tenant", tenantID, "objects", deleted)
+	}
+	if mark.FinishedTime == 0 {
+		mark.FinishedTime = time.Now().Unix()
+		return bucket.WriteTenantDeletionMark(ctx, bkt, tenantID, nil, mark)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package storegateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+func Test_DeleteTenantHandler(t *testing.T) {
+	bkt := phlareobj.NewBucket(objstore.NewInMemBucket())
+	g := &StoreGateway{logger: log.NewNopLogger(), stores: &BucketStores{storageBucket: bkt}}
+	deleteTenant := func(tenantID, pathTenant string) int {
+		req := httptest.NewRequest("POST", "/store-gateway/tenant/"+pathTenant+"/delete", nil)
+		req = mux.SetURLVars(req, map[string]string{"tenant": pathTenant})
+		req = req.WithContext(tenant.InjectTenantID(req.Context(), tenantID))
+		w := httptest.NewRecorder()
+		g.DeleteTenantHandler(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusForbidden, deleteTenant("team-a", "team-b"))
+	exists, err := bucket.TenantDeletionMarkExists(context.Background(), bkt, "team-b")
+	require.NoError(t, err)
+	require.False(t, exists, "another tenant must not be marked for deletion")
+
+	require.Equal(t, http.StatusAccepted, deleteTenant("team-a", "team-a"))
+	exists, err = bucket.TenantDeletionMarkExists(context.Background(), bkt, "team-a")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
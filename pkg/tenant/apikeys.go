@@ -0,0 +1,154 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// apiKeysReloadInterval is how often the API keys file is checked for
+// changes.
+const apiKeysReloadInterval = 10 * time.Second
+
+type APIKeysConfig struct {
+	File string `yaml:"file"`
+}
+
+func (cfg *APIKeysConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.File, "auth.api-keys.file", "", "Path to the file of the API keys. If set, incoming requests can be authenticated with an API key as bearer token: the request is made for the tenant of the key, and is limited to the scopes of the key (ingest, query or admin). The file is reloaded when it changes. Requires multitenancy to be enabled.")
+}
+
+// The API keys file lists the keys, by the SHA-256 hash of the key, so the
+// file does not hold the credentials:
+//
+//	keys:
+//	  - name: agents
+//	    tenant_id: team-a
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    scopes: [ingest]
+type apiKeysFile struct {
+	Keys []apiKey `yaml:"keys"`
+}
+
+type apiKey struct {
+	Name     string  `yaml:"name"`
+	TenantID string  `yaml:"tenant_id"`
+	SHA256   string  `yaml:"sha256"`
+	Scopes   []Scope `yaml:"scopes"`
+}
+
+// APIKeys authenticates the requests with the API keys of a file.
+type APIKeys struct {
+	path   string
+	logger log.Logger
+
+	mtx       sync.Mutex
+	keys      map[string]apiKey
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewAPIKeys loads the API keys of the file.
+func NewAPIKeys(cfg APIKeysConfig, logger log.Logger) (*APIKeys, error) {
+	a := &APIKeys{path: cfg.File, logger: logger}
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return nil, err
+	}
+	if a.keys, err = loadAPIKeys(a.path); err != nil {
+		return nil, err
+	}
+	a.modTime, a.checkedAt = info.ModTime(), time.Now()
+	return a, nil
+}
+
+// Authenticate returns the identity of the API key of the request: the
+// tenant and the scopes of the key.
+func (a *APIKeys) Authenticate(_ context.Context, headers http.Header) (Identity, error) {
+	token, err := bearerToken(headers)
+	if err != nil {
+		return Identity{}, err
+	}
+	sum := sha256.Sum256([]byte(token))
+	key, ok := a.lookup(hex.EncodeToString(sum[:]))
+	if !ok {
+		return Identity{}, errUnknownAPIKey
+	}
+	return Identity{TenantID: key.TenantID, Subject: "api-key:" + key.Name, Scopes: key.Scopes}, nil
+}
+
+func (a *APIKeys) lookup(hash string) (apiKey, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if time.Since(a.checkedAt) > apiKeysReloadInterval {
+		a.checkedAt = time.Now()
+		a.reload()
+	}
+	key, ok := a.keys[hash]
+	return key, ok
+}
+
+// reload loads the file again if it has changed. The keys loaded before are
+// kept if it can't be loaded.
+func (a *APIKeys) reload() {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		level.Warn(a.logger).Log("msg", "failed to check the API keys file", "err", err)
+		return
+	}
+	if info.ModTime().Equal(a.modTime) {
+		return
+	}
+	keys, err := loadAPIKeys(a.path)
+	if err != nil {
+		level.Warn(a.logger).Log("msg", "failed to reload the API keys file", "err", err)
+		return
+	}
+	a.keys, a.modTime = keys, info.ModTime()
+	level.Info(a.logger).Log("msg", "API keys reloaded", "keys", len(keys))
+}
+
+func loadAPIKeys(path string) (map[string]apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f apiKeysFile
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid API keys file: %w", err)
+	}
+	keys := make(map[string]apiKey, len(f.Keys))
+	for _, k := range f.Keys {
+		if k.TenantID == "" {
+			return nil, fmt.Errorf("API key %q has no tenant", k.Name)
+		}
+		if len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("API key %q has no scopes", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if err = s.validate(); err != nil {
+				return nil, fmt.Errorf("API key %q: %w", k.Name, err)
+			}
+		}
+		if b, err := hex.DecodeString(k.SHA256); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: the sha256 hash must be 64 hexadecimal characters", k.Name)
+		}
+		k.SHA256 = strings.ToLower(k.SHA256)
+		keys[k.SHA256] = k
+	}
+	return keys, nil
+}
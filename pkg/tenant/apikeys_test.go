@@ -0,0 +1,95 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_APIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+keys:
+  - name: agents
+    tenant_id: foo
+    sha256: `+hashAPIKey("ingest-key")+`
+    scopes: [ingest]
+  - name: dashboards
+    tenant_id: foo
+    sha256: `+hashAPIKey("query-key")+`
+    scopes: [query]
+`), 0o644))
+	keys, err := NewAPIKeys(APIKeysConfig{File: path}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	id, err := keys.Authenticate(context.Background(), http.Header{"Authorization": []string{"Bearer ingest-key"}})
+	require.NoError(t, err)
+	require.Equal(t, Identity{TenantID: "foo", Subject: "api-key:agents", Scopes: []Scope{ScopeIngest}}, id)
+	_, err = keys.Authenticate(context.Background(), http.Header{"Authorization": []string{"Bearer unknown-key"}})
+	require.ErrorIs(t, err, errUnknownAPIKey)
+	_, err = keys.Authenticate(context.Background(), http.Header{})
+	require.ErrorIs(t, err, errNoBearerToken)
+
+	// The scopes of the key are enforced.
+	handler := NewAuthMiddleware(keys).Wrap(NewScopeMiddleware(ScopeIngest).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "foo", r.Header.Get("X-Scope-OrgID"))
+	})))
+	for key, code := range map[string]int{
+		"ingest-key":  http.StatusOK,
+		"query-key":   http.StatusForbidden,
+		"unknown-key": http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		handler.ServeHTTP(w, r)
+		require.Equal(t, code, w.Code, key)
+	}
+}
+
+func Test_APIKeys_Invalid(t *testing.T) {
+	for name, file := range map[string]string{
+		"no tenant":     "keys: [{name: a, sha256: " + hashAPIKey("a") + ", scopes: [query]}]",
+		"no scopes":     "keys: [{name: a, tenant_id: foo, sha256: " + hashAPIKey("a") + "}]",
+		"invalid scope": "keys: [{name: a, tenant_id: foo, sha256: " + hashAPIKey("a") + ", scopes: [write]}]",
+		"invalid hash":  "keys: [{name: a, tenant_id: foo, sha256: a, scopes: [query]}]",
+	} {
+		path := filepath.Join(t.TempDir(), "keys.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(file), 0o644))
+		_, err := NewAPIKeys(APIKeysConfig{File: path}, log.NewNopLogger())
+		require.Error(t, err, name)
+	}
+}
+
+func Test_HasScope(t *testing.T) {
+	ctx := context.Background()
+	require.True(t, HasScope(ctx, ScopeIngest), "requests without scopes are allowed")
+	ctx = injectIdentity(ctx, Identity{TenantID: "foo"})
+	require.True(t, HasScope(ctx, ScopeIngest), "requests without scopes are allowed")
+	ctx = injectIdentity(ctx, Identity{TenantID: "foo", Scopes: []Scope{ScopeQuery}})
+	require.True(t, HasScope(ctx, ScopeQuery))
+	require.False(t, HasScope(ctx, ScopeIngest))
+	ctx = injectIdentity(ctx, Identity{TenantID: "foo", Scopes: []Scope{ScopeAdmin}})
+	require.True(t, HasScope(ctx, ScopeIngest))
+}
+
+func Test_CheckTenant(t *testing.T) {
+	ctx := context.Background()
+	require.Error(t, CheckTenant(ctx, "foo"), "requests without tenant are not allowed")
+	ctx = InjectTenantID(ctx, "foo")
+	require.NoError(t, CheckTenant(ctx, "foo"))
+	require.ErrorIs(t, CheckTenant(ctx, "bar"), ErrTenantNotAllowed)
+}
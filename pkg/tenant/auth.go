@@ -0,0 +1,218 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/middleware"
+	"github.com/grafana/dskit/user"
+)
+
+var errNoBearerToken = errors.New("no bearer token")
+
+// ErrTenantNotAllowed is returned for the requests about another tenant
+// than the one they have been authenticated as.
+var ErrTenantNotAllowed = errors.New("the request is not allowed for the tenant")
+
+// Scope is the kind of requests a credential is allowed to make.
+type Scope string
+
+const (
+	ScopeIngest Scope = "ingest"
+	ScopeQuery  Scope = "query"
+	// ScopeAdmin allows all the requests of the tenant.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) validate() error {
+	switch s {
+	case ScopeIngest, ScopeQuery, ScopeAdmin:
+		return nil
+	}
+	return fmt.Errorf("invalid scope %q, must be one of: %s, %s, %s", s, ScopeIngest, ScopeQuery, ScopeAdmin)
+}
+
+// Identity is the client a request has been authenticated as.
+type Identity struct {
+	TenantID string
+	// Subject identifies the credentials of the client.
+	Subject string
+	// Scopes the client is allowed. All the scopes are allowed if nil.
+	Scopes []Scope
+}
+
+// Authenticator authenticates the requests of the clients.
+type Authenticator interface {
+	Authenticate(ctx context.Context, headers http.Header) (Identity, error)
+}
+
+func bearerToken(headers http.Header) (string, error) {
+	auth := headers.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errNoBearerToken
+	}
+	return strings.TrimPrefix(auth, "Bearer "), nil
+}
+
+// authenticate returns the identity of the first authenticator accepting
+// the request.
+func authenticate(ctx context.Context, auths []Authenticator, headers http.Header) (Identity, error) {
+	err := errNoBearerToken
+	for _, a := range auths {
+		var id Identity
+		if id, err = a.Authenticate(ctx, headers); err == nil {
+			return id, nil
+		}
+	}
+	return Identity{}, err
+}
+
+type identityKey struct{}
+
+func injectIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity the request has been
+// authenticated as, if it has been authenticated with credentials.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// HasScope returns true if the request is allowed the scope. The requests
+// authenticated without scopes are allowed all of them.
+func HasScope(ctx context.Context, scope Scope) bool {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Scopes == nil {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTenant returns ErrTenantNotAllowed if the request has not been
+// authenticated as the tenant.
+func CheckTenant(ctx context.Context, tenantID string) error {
+	id, err := ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if id != tenantID {
+		return ErrTenantNotAllowed
+	}
+	return nil
+}
+
+// NewAuthMiddleware authenticates the requests with the authenticators, and
+// sets the X-Scope-OrgID header of the requests to their tenant.
+func NewAuthMiddleware(auths ...Authenticator) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := authenticate(r.Context(), auths, r.Header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r.Header.Set(user.OrgIDHeaderName, id.TenantID)
+			next.ServeHTTP(w, r.WithContext(injectIdentity(r.Context(), id)))
+		})
+	})
+}
+
+// NewScopeMiddleware rejects the requests not allowed the scope.
+func NewScopeMiddleware(scope Scope) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r.Context(), scope) {
+				http.Error(w, fmt.Sprintf("the %s scope is required", scope), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// NewAuthTokenInterceptor authenticates the requests handled by the server
+// with the authenticators. It must wrap the tenant authentication
+// interceptor, as it sets the X-Scope-OrgID header of the requests.
+func NewAuthTokenInterceptor(auths ...Authenticator) connect.Interceptor {
+	return &authTokenInterceptor{auths: auths}
+}
+
+type authTokenInterceptor struct {
+	auths []Authenticator
+}
+
+func (i *authTokenInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		id, err := authenticate(ctx, i.auths, req.Header())
+		if err != nil {
+			return nil, connect.NewError(connect.CodeUnauthenticated, err)
+		}
+		req.Header().Set(user.OrgIDHeaderName, id.TenantID)
+		return next(injectIdentity(ctx, id), req)
+	}
+}
+
+func (i *authTokenInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *authTokenInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		id, err := authenticate(ctx, i.auths, conn.RequestHeader())
+		if err != nil {
+			return connect.NewError(connect.CodeUnauthenticated, err)
+		}
+		conn.RequestHeader().Set(user.OrgIDHeaderName, id.TenantID)
+		return next(injectIdentity(ctx, id), conn)
+	}
+}
+
+// NewScopeInterceptor rejects the requests handled by the server that are
+// not allowed the scope.
+func NewScopeInterceptor(scope Scope) connect.Interceptor {
+	return &scopeInterceptor{scope: scope}
+}
+
+type scopeInterceptor struct {
+	scope Scope
+}
+
+func (i *scopeInterceptor) err() error {
+	return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("the %s scope is required", i.scope))
+}
+
+func (i *scopeInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if !req.Spec().IsClient && !HasScope(ctx, i.scope) {
+			return nil, i.err()
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *scopeInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *scopeInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if !HasScope(ctx, i.scope) {
+			return i.err()
+		}
+		return next(ctx, conn)
+	}
+}
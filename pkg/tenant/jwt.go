@@ -0,0 +1,317 @@
+package tenant
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/groupcache/singleflight"
+)
+
+var (
+	errNoTenantClaim   = errors.New("the token has no tenant claim")
+	errNoExpiration    = errors.New("the token has no expiration time")
+	errInvalidIssuer   = errors.New("invalid token issuer")
+	errInvalidAudience = errors.New("invalid token audience")
+)
+
+const (
+	// minKeysRefreshInterval limits how often the signing keys are fetched
+	// when a token is signed with an unknown key.
+	minKeysRefreshInterval = time.Minute
+	// keysFetchTimeout bounds the fetch of the signing keys, which is shared
+	// by the concurrent requests and does not depend on any of them.
+	keysFetchTimeout = 10 * time.Second
+)
+
+// defaultJWTScopes are the scopes of the tokens that have none of them in
+// their scopes claim. The admin scope must be granted explicitly.
+var defaultJWTScopes = []Scope{ScopeIngest, ScopeQuery}
+
+type JWTConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	IssuerURL           string        `yaml:"issuer_url"`
+	Audience            string        `yaml:"audience"`
+	TenantClaim         string        `yaml:"tenant_claim"`
+	ScopesClaim         string        `yaml:"scopes_claim"`
+	KeysRefreshInterval time.Duration `yaml:"keys_refresh_interval" category:"advanced"`
+}
+
+func (cfg *JWTConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "auth.jwt.enabled", false, "If enabled, incoming requests must have a bearer JWT signed by the OIDC issuer, and the tenant ID is taken from the tenant claim of the token, instead of the X-Scope-OrgID header. Requires multitenancy to be enabled.")
+	f.StringVar(&cfg.IssuerURL, "auth.jwt.issuer-url", "", "URL of the OIDC issuer. The signing keys are discovered from its /.well-known/openid-configuration document, and the tokens must have it as issuer.")
+	f.StringVar(&cfg.Audience, "auth.jwt.audience", "", "If set, the tokens must have it as audience.")
+	f.StringVar(&cfg.TenantClaim, "auth.jwt.tenant-claim", "tenant_id", "Name of the claim holding the tenant ID.")
+	f.StringVar(&cfg.ScopesClaim, "auth.jwt.scopes-claim", "scope", "Name of the claim holding the scopes of the token (ingest, query or admin), as a space-separated string or a list. The tokens without any of them are allowed the ingest and query scopes.")
+	f.DurationVar(&cfg.KeysRefreshInterval, "auth.jwt.keys-refresh-interval", time.Hour, "How often the signing keys of the OIDC issuer are fetched.")
+}
+
+func (cfg *JWTConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.IssuerURL == "" {
+		return errors.New("the OIDC issuer URL is required for JWT authentication")
+	}
+	if cfg.TenantClaim == "" {
+		return errors.New("the tenant claim is required for JWT authentication")
+	}
+	return nil
+}
+
+// JWTAuthenticator authenticates the requests with the bearer JWTs signed
+// by an OIDC issuer.
+type JWTAuthenticator struct {
+	cfg    JWTConfig
+	logger log.Logger
+	client *http.Client
+	parser *jwt.Parser
+
+	mtx       sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	fetch     singleflight.Group
+}
+
+func NewJWTAuthenticator(cfg JWTConfig, logger log.Logger) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		cfg:    cfg,
+		logger: logger,
+		client: &http.Client{Timeout: 10 * time.Second},
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{
+			"RS256", "RS384", "RS512",
+			"PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512",
+		})),
+	}
+}
+
+// Authenticate returns the identity of the bearer token of the request: the
+// tenant of the token, its subject, and its scopes.
+func (a *JWTAuthenticator) Authenticate(_ context.Context, headers http.Header) (Identity, error) {
+	raw, err := bearerToken(headers)
+	if err != nil {
+		return Identity{}, err
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.key(kid)
+	}); err != nil {
+		return Identity{}, err
+	}
+	// The expiration time is only verified by the parser if it's set.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return Identity{}, errNoExpiration
+	}
+	if !claims.VerifyIssuer(a.cfg.IssuerURL, true) {
+		return Identity{}, errInvalidIssuer
+	}
+	if a.cfg.Audience != "" && !claims.VerifyAudience(a.cfg.Audience, true) {
+		return Identity{}, errInvalidAudience
+	}
+	tenantID, _ := claims[a.cfg.TenantClaim].(string)
+	if tenantID == "" {
+		return Identity{}, errNoTenantClaim
+	}
+	subject, _ := claims["sub"].(string)
+	return Identity{TenantID: tenantID, Subject: "jwt:" + subject, Scopes: a.scopes(claims)}, nil
+}
+
+// scopes returns the scopes of the token found in its scopes claim. The
+// other values of the claim, such as the OIDC scopes, are ignored.
+func (a *JWTAuthenticator) scopes(claims jwt.MapClaims) []Scope {
+	var values []string
+	switch v := claims[a.cfg.ScopesClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var scopes []Scope
+	for _, v := range values {
+		if s := Scope(v); s.validate() == nil {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return defaultJWTScopes
+	}
+	return scopes
+}
+
+// key returns the signing key with the given ID. The keys are fetched if
+// they are stale, or if the key is unknown and they have not been fetched
+// recently: the issuer may have rotated its keys.
+func (a *JWTAuthenticator) key(kid string) (interface{}, error) {
+	key, ok, fresh := a.cachedKey(kid)
+	if fresh {
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+	// The lock is not held while the keys are fetched, so that the requests
+	// signed with the known keys are not blocked by the issuer. Concurrent
+	// fetches are deduplicated, so the fetch is not canceled with the
+	// request that started it.
+	v, err := a.fetch.Do("", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), keysFetchTimeout)
+		defer cancel()
+		keys, err := a.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.mtx.Lock()
+		a.keys, a.fetchedAt = keys, time.Now()
+		a.mtx.Unlock()
+		return keys, nil
+	})
+	if err != nil {
+		level.Warn(a.logger).Log("msg", "failed to fetch the OIDC signing keys", "err", err)
+		if ok {
+			// The previous keys are used until they can be fetched.
+			return key, nil
+		}
+		return nil, err
+	}
+	if key, ok = v.(map[string]interface{})[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// cachedKey returns the signing key with the given ID, if known, and whether
+// the keys are recent enough to be used without fetching them.
+func (a *JWTAuthenticator) cachedKey(kid string) (key interface{}, ok, fresh bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	key, ok = a.keys[kid]
+	since := time.Since(a.fetchedAt)
+	fresh = (ok && since < a.cfg.KeysRefreshInterval) || (!ok && since < minKeysRefreshInterval)
+	return key, ok, fresh
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *JWTAuthenticator) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(a.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := a.getJSON(ctx, discoveryURL, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != a.cfg.IssuerURL {
+		return nil, fmt.Errorf("the OIDC discovery document is for issuer %q", discovery.Issuer)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			level.Warn(a.logger).Log("msg", "skipping invalid OIDC signing key", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (a *JWTAuthenticator) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
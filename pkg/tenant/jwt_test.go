@@ -0,0 +1,154 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_JWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var (
+		issuer  string
+		blocked = make(chan struct{})
+		block   atomic.Bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+		case "/keys":
+			if block.Load() {
+				<-blocked
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+				Kid: "key-1",
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+
+	a := NewJWTAuthenticator(JWTConfig{
+		Enabled:             true,
+		IssuerURL:           issuer,
+		Audience:            "pyroscope",
+		TenantClaim:         "tenant_id",
+		ScopesClaim:         "scope",
+		KeysRefreshInterval: time.Hour,
+	}, log.NewNopLogger())
+
+	sign := func(kid string, claims jwt.MapClaims) http.Header {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return http.Header{"Authorization": []string{"Bearer " + signed}}
+	}
+	claims := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":       issuer,
+			"aud":       "pyroscope",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"sub":       "user",
+			"tenant_id": "foo",
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	id, err := a.Authenticate(context.Background(), sign("key-1", claims(nil)))
+	require.NoError(t, err)
+	require.Equal(t, Identity{TenantID: "foo", Subject: "jwt:user", Scopes: []Scope{ScopeIngest, ScopeQuery}}, id)
+
+	// The admin scope must be granted by the scopes claim.
+	for _, tc := range []struct {
+		claim    interface{}
+		expected []Scope
+	}{
+		{claim: "openid profile", expected: []Scope{ScopeIngest, ScopeQuery}},
+		{claim: "openid query", expected: []Scope{ScopeQuery}},
+		{claim: "ingest admin", expected: []Scope{ScopeIngest, ScopeAdmin}},
+		{claim: []string{"admin"}, expected: []Scope{ScopeAdmin}},
+		{claim: []string{"unknown"}, expected: []Scope{ScopeIngest, ScopeQuery}},
+	} {
+		tc := tc
+		id, err = a.Authenticate(context.Background(), sign("key-1", claims(func(c jwt.MapClaims) { c["scope"] = tc.claim })))
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, id.Scopes, tc.claim)
+	}
+
+	for name, headers := range map[string]http.Header{
+		"no token":       {},
+		"unknown key":    sign("key-2", claims(nil)),
+		"expired":        sign("key-1", claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+		"no expiration":  sign("key-1", claims(func(c jwt.MapClaims) { delete(c, "exp") })),
+		"wrong issuer":   sign("key-1", claims(func(c jwt.MapClaims) { c["iss"] = "https://example.com" })),
+		"wrong audience": sign("key-1", claims(func(c jwt.MapClaims) { c["aud"] = "other" })),
+		"no tenant":      sign("key-1", claims(func(c jwt.MapClaims) { delete(c, "tenant_id") })),
+	} {
+		_, err = a.Authenticate(context.Background(), headers)
+		require.Error(t, err, name)
+	}
+
+	// The tenant of the token overrides the tenant of the request.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header = sign("key-1", claims(nil))
+	r.Header.Set("X-Scope-OrgID", "bar")
+	NewAuthMiddleware(a).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "foo", r.Header.Get("X-Scope-OrgID"))
+	})).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The requests signed with the known keys are not blocked while the
+	// keys are fetched for an unknown one.
+	block.Store(true)
+	a.mtx.Lock()
+	a.fetchedAt = time.Now().Add(-2 * minKeysRefreshInterval)
+	a.mtx.Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := a.Authenticate(context.Background(), sign("key-3", claims(nil)))
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		_, err := a.Authenticate(context.Background(), sign("key-1", claims(nil)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	close(blocked)
+	require.Error(t, <-done)
+
+	// The keys are fetched even if the request that needs them is canceled,
+	// as the fetch is shared with the other requests.
+	block.Store(false)
+	a.mtx.Lock()
+	a.keys, a.fetchedAt = nil, time.Time{}
+	a.mtx.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.Authenticate(ctx, sign("key-1", claims(nil)))
+	require.NoError(t, err)
+}
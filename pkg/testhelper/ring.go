@@ -80,7 +80,7 @@ func (r MockRing) ShuffleShard(identifier string, size int) ring.ReadRing {
 }
 
 func (r MockRing) ShuffleShardWithLookback(identifier string, size int, lookbackPeriod time.Duration, now time.Time) ring.ReadRing {
-	return r
+	return r.ShuffleShard(identifier, size)
 }
 
 func (r MockRing) CleanupShuffleShardCache(identifier string) {}
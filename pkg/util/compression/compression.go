@@ -0,0 +1,150 @@
+// Package compression provides the zstd and lz4 codecs for the push and
+// ingest APIs, in addition to gzip supported by connect and net/http.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+	LZ4  = "lz4"
+)
+
+// HandlerOption registers the zstd and lz4 compressors with a connect
+// handler. gzip is registered by default.
+func HandlerOption() connect.HandlerOption {
+	return connect.WithHandlerOptions(
+		connect.WithCompression(Zstd, newZstdDecompressor, newZstdCompressor),
+		connect.WithCompression(LZ4, newLZ4Decompressor, newLZ4Compressor),
+	)
+}
+
+// ClientOption makes a connect client compress requests with the given
+// algorithm, and advertise all the supported algorithms for responses.
+func ClientOption(name string) (connect.ClientOption, error) {
+	opts := []connect.ClientOption{
+		connect.WithAcceptCompression(Zstd, newZstdDecompressor, newZstdCompressor),
+		connect.WithAcceptCompression(LZ4, newLZ4Decompressor, newLZ4Compressor),
+	}
+	switch name {
+	case None, "":
+	case Gzip, Zstd, LZ4:
+		opts = append(opts, connect.WithSendCompression(name))
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", name)
+	}
+	return connect.WithClientOptions(opts...), nil
+}
+
+var errBodyTooLarge = errors.New("the decompressed request body is too large")
+
+// DecodeRequestBody decompresses request bodies according to the
+// Content-Encoding header. Requests with an unsupported encoding are
+// rejected with 415 Unsupported Media Type, and the ones larger than
+// maxSize once decompressed with 413 Request Entity Too Large. The size
+// is not limited if maxSize is 0.
+func DecodeRequestBody(h http.Handler, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		var body connect.Decompressor
+		switch encoding {
+		case "", "identity":
+			h.ServeHTTP(w, r)
+			return
+		case Gzip:
+			body = new(gzip.Reader)
+		case Zstd:
+			body = newZstdDecompressor()
+		case LZ4:
+			body = newLZ4Decompressor()
+		default:
+			httputil.ErrorWithStatus(w, fmt.Errorf("unsupported content encoding: %q", encoding), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err := body.Reset(r.Body); err != nil {
+			httputil.ErrorWithStatus(w, fmt.Errorf("invalid %s request body: %w", encoding, err), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Body = io.NopCloser(body)
+		if maxSize > 0 {
+			// The body is read before the handler, so that the requests
+			// too large are rejected with the right status. The handlers
+			// read it whole anyway.
+			b, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+			if err != nil {
+				httputil.ErrorWithStatus(w, fmt.Errorf("invalid %s request body: %w", encoding, err), http.StatusBadRequest)
+				return
+			}
+			if int64(len(b)) > maxSize {
+				httputil.ErrorWithStatus(w, errBodyTooLarge, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.ContentLength = int64(len(b))
+			r.Body = io.NopCloser(bytes.NewReader(b))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type zstdDecompressor struct{ *zstd.Decoder }
+
+func newZstdDecompressor() connect.Decompressor {
+	// The decoder runs synchronously and does not have to be closed.
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	return &zstdDecompressor{Decoder: d}
+}
+
+// Close does not release the decoder: connect reuses it after Reset.
+func (d *zstdDecompressor) Close() error { return nil }
+
+func newZstdCompressor() connect.Compressor {
+	w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return w
+}
+
+type lz4Decompressor struct{ *lz4.Reader }
+
+func newLZ4Decompressor() connect.Decompressor {
+	return &lz4Decompressor{Reader: lz4.NewReader(nil)}
+}
+
+func (d *lz4Decompressor) Close() error { return nil }
+
+func (d *lz4Decompressor) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+type lz4Compressor struct{ *lz4.Writer }
+
+func newLZ4Compressor() connect.Compressor {
+	return &lz4Compressor{Writer: lz4.NewWriter(nil)}
+}
+
+// Close makes sure the frame header is written even if the message is
+// empty: the writer only writes it on the first call to Write.
+func (c *lz4Compressor) Close() error {
+	if _, err := c.Writer.Write(nil); err != nil {
+		return err
+	}
+	return c.Writer.Close()
+}
@@ -0,0 +1,150 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var (
+		b bytes.Buffer
+		w io.WriteCloser
+	)
+	switch encoding {
+	case Gzip:
+		w = gzip.NewWriter(&b)
+	case Zstd:
+		var err error
+		w, err = zstd.NewWriter(&b)
+		require.NoError(t, err)
+	case LZ4:
+		w = lz4.NewWriter(&b)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func Test_DecodeRequestBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("foo;bar 1\n"), 100)
+	h := DecodeRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		assert.Equal(t, payload, b)
+	}), 0)
+
+	for _, encoding := range []string{Gzip, Zstd, LZ4} {
+		t.Run(encoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(compress(t, encoding, payload)))
+			req.Header.Set("Content-Encoding", encoding)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		})
+	}
+
+	t.Run("identity", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(payload)))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(payload))
+		req.Header.Set("Content-Encoding", "br")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(payload))
+		req.Header.Set("Content-Encoding", Gzip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func Test_DecodeRequestBody_MaxSize(t *testing.T) {
+	var received int
+	h := DecodeRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		received = len(b)
+	}), 1<<20)
+
+	for _, encoding := range []string{Gzip, Zstd, LZ4} {
+		t.Run(encoding, func(t *testing.T) {
+			// A few KB decompressing to 64MB are rejected before they are
+			// decompressed whole.
+			bomb := compress(t, encoding, make([]byte, 64<<20))
+			req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(bomb))
+			req.Header.Set("Content-Encoding", encoding)
+			w := httptest.NewRecorder()
+			received = 0
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+			assert.Zero(t, received)
+
+			req = httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(compress(t, encoding, make([]byte, 1<<20))))
+			req.Header.Set("Content-Encoding", encoding)
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, 1<<20, received)
+		})
+	}
+}
+
+type pusher struct {
+	pushv1connect.UnimplementedPusherServiceHandler
+	series int
+}
+
+func (p *pusher) Push(_ context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	p.series = len(req.Msg.Series)
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func Test_ConnectCompression(t *testing.T) {
+	svc := new(pusher)
+	mux := http.NewServeMux()
+	mux.Handle(pushv1connect.NewPusherServiceHandler(svc, HandlerOption()))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	for _, name := range []string{None, Gzip, Zstd, LZ4} {
+		t.Run(name, func(t *testing.T) {
+			opt, err := ClientOption(name)
+			require.NoError(t, err)
+			client := pushv1connect.NewPusherServiceClient(http.DefaultClient, s.URL, opt)
+			_, err = client.Push(context.Background(), connect.NewRequest(&pushv1.PushRequest{
+				Series: []*pushv1.RawProfileSeries{{
+					Samples: []*pushv1.RawSample{{RawProfile: bytes.Repeat([]byte{1}, 4<<10)}},
+				}},
+			}))
+			require.NoError(t, err)
+			assert.Equal(t, 1, svc.series)
+		})
+	}
+
+	_, err := ClientOption("br")
+	assert.Error(t, err)
+}
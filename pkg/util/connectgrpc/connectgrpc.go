@@ -72,6 +72,22 @@ func CloneRequest[Req any](base *connect.Request[Req], msg *Req) *connect.Reques
 	return &r
 }
 
+// SendServerStream sends the messages a unary response is split into to the
+// stream, along with the headers of the response. The messages can't be
+// round-tripped through the scheduler as a stream: they are split once the
+// unary response is complete.
+func SendServerStream[Res any](stream *connect.ServerStream[Res], header http.Header, msgs []*Res) error {
+	for k, v := range header {
+		stream.ResponseHeader()[k] = v
+	}
+	for _, msg := range msgs {
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func encodeResponse[Req any](resp *connect.Response[Req]) (*httpgrpc.HTTPResponse, error) {
 	out := &httpgrpc.HTTPResponse{
 		Headers: connectHeaderToHTTPGRPCHeader(resp.Header()),
@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// LabelJoin is a label joined at query time: the values of the source label
+// of the series are mapped to values of the target label by an external
+// mapping, fetched either from an HTTP URL or from the storage bucket.
+type LabelJoin struct {
+	SourceLabel string `yaml:"source_label" json:"source_label"`
+	TargetLabel string `yaml:"target_label" json:"target_label"`
+	// URL the mapping is fetched from with a GET request.
+	URL string `yaml:"url" json:"url"`
+	// BucketPath is the path of the mapping object, relative to the tenant
+	// directory of the storage bucket.
+	BucketPath      string         `yaml:"bucket_path" json:"bucket_path"`
+	RefreshInterval model.Duration `yaml:"refresh_interval" json:"refresh_interval"`
+}
+
+func (j *LabelJoin) Validate() error {
+	if !model.LabelName(j.SourceLabel).IsValid() {
+		return errors.Errorf("invalid query label join: invalid source label %q", j.SourceLabel)
+	}
+	if !model.LabelName(j.TargetLabel).IsValid() {
+		return errors.Errorf("invalid query label join: invalid target label %q", j.TargetLabel)
+	}
+	if j.SourceLabel == j.TargetLabel {
+		return errors.Errorf("invalid query label join of %q: the source and target labels must differ", j.TargetLabel)
+	}
+	if (j.URL == "") == (j.BucketPath == "") {
+		return errors.Errorf("invalid query label join of %q: exactly one of url and bucket_path must be set", j.TargetLabel)
+	}
+	if j.RefreshInterval < 0 {
+		return errors.Errorf("invalid query label join of %q: the refresh interval must not be negative", j.TargetLabel)
+	}
+	return nil
+}
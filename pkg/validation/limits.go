@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v3"
 )
 
@@ -27,6 +28,14 @@ type Limits struct {
 	MaxLabelNamesPerSeries int     `yaml:"max_label_names_per_series" json:"max_label_names_per_series"`
 	MaxSessionsPerSeries   int     `yaml:"max_sessions_per_series" json:"max_sessions_per_series"`
 
+	IngestionQuotaBytesPerMinute    float64 `yaml:"ingestion_quota_bytes_per_minute" json:"ingestion_quota_bytes_per_minute"`
+	IngestionQuotaProfilesPerMinute float64 `yaml:"ingestion_quota_profiles_per_minute" json:"ingestion_quota_profiles_per_minute"`
+
+	DistributorAggregationWindow model.Duration `yaml:"distributor_aggregation_window" json:"distributor_aggregation_window"`
+
+	IngestionSampleRatio     map[string]float64 `yaml:"ingestion_sample_ratio" json:"ingestion_sample_ratio" doc:"nocli|description=Fraction of profiles kept at ingestion per profile name (for example, wall: 0.25). Values of the kept profiles are scaled accordingly. Profiles of names not listed, and cumulative profiles, are not sampled."`
+	IngestionRelabelingRules []*relabel.Config  `yaml:"ingestion_relabeling_rules" json:"ingestion_relabeling_rules" doc:"nocli|description=List of relabel configurations applied to the series labels in the distributor, before series are distributed to ingesters. Series dropped by the rules are discarded."`
+
 	MaxProfileSizeBytes              int `yaml:"max_profile_size_bytes" json:"max_profile_size_bytes"`
 	MaxProfileStacktraceSamples      int `yaml:"max_profile_stacktrace_samples" json:"max_profile_stacktrace_samples"`
 	MaxProfileStacktraceSampleLabels int `yaml:"max_profile_stacktrace_sample_labels" json:"max_profile_stacktrace_sample_labels"`
@@ -42,21 +51,41 @@ type Limits struct {
 	// Ingester enforced limits.
 	MaxLocalSeriesPerTenant  int `yaml:"max_local_series_per_tenant" json:"max_local_series_per_tenant"`
 	MaxGlobalSeriesPerTenant int `yaml:"max_global_series_per_tenant" json:"max_global_series_per_tenant"`
+	IngesterSplitShards      int `yaml:"ingester_split_shards" json:"ingester_split_shards"`
+
+	IngesterSeriesIdleTimeout model.Duration `yaml:"ingester_series_idle_timeout" json:"ingester_series_idle_timeout"`
+
+	MaxGlobalSeriesPerProfileType map[string]int `yaml:"max_global_series_per_profile_type" json:"max_global_series_per_profile_type" doc:"nocli|description=Maximum number of active series of profiles per tenant and profile name (for example, process_cpu: 1000), across the cluster. The limit is converted to a per-ingester limit the same way as the global series limit. Profile names not listed are only subject to the series limits of the tenant."`
 
 	// Querier enforced limits.
 	MaxQueryLookback    model.Duration `yaml:"max_query_lookback" json:"max_query_lookback"`
 	MaxQueryLength      model.Duration `yaml:"max_query_length" json:"max_query_length"`
 	MaxQueryParallelism int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
+	MaxQueryBlocks      int            `yaml:"max_query_blocks" json:"max_query_blocks"`
+	MaxQueryBytes       int64          `yaml:"max_query_bytes" json:"max_query_bytes"`
+	QueryTimeout        model.Duration `yaml:"query_timeout" json:"query_timeout"`
+
+	QueryLabelJoins []*LabelJoin `yaml:"query_label_joins" json:"query_label_joins" doc:"nocli|description=List of labels joined at query time against an external mapping of the values of a source label of the series, so that queries can group by labels the profiles are not labeled with (for example, pod to team). The mapping is a JSON object of source label values to target label values, fetched from an HTTP URL or from a path in the tenant directory of the storage bucket, and refreshed periodically. Each join has the fields source_label, target_label, url or bucket_path, and refresh_interval (5m by default)."`
 
 	// Store-gateway.
 	StoreGatewayTenantShardSize int `yaml:"store_gateway_tenant_shard_size" json:"store_gateway_tenant_shard_size"`
 
+	// Storage.
+	BlocksEncryptionKeyID string `yaml:"blocks_encryption_key_id" json:"blocks_encryption_key_id" doc:"nocli|description=ID of the master key the blocks of the tenant are encrypted with, before they are uploaded to the object storage. The key must be known to the key provider configured with -storage.encryption.static-keys-dir. Blocks uploaded before the key is set are not encrypted, and remain readable. Empty to disable the encryption."`
+	BlocksRetentionClass  string `yaml:"blocks_retention_class" json:"blocks_retention_class" doc:"nocli|description=Value of the retention-class tag of the objects of the blocks of the tenant, when -storage.s3.tag-objects is enabled. S3 lifecycle rules can filter on it to move the blocks to cheaper storage classes, or to expire them. Empty to not set the tag."`
+
 	// Query frontend.
 	QuerySplitDuration model.Duration `yaml:"split_queries_by_interval" json:"split_queries_by_interval"`
+	QueryShards        int            `yaml:"query_shards" json:"query_shards"`
+	MaxCacheFreshness  model.Duration `yaml:"max_cache_freshness" json:"max_cache_freshness"`
 
 	// Ensure profiles are dated within the IngestionWindow of the distributor.
 	RejectOlderThan model.Duration `yaml:"reject_older_than" json:"reject_older_than"`
 	RejectNewerThan model.Duration `yaml:"reject_newer_than" json:"reject_newer_than"`
+
+	// Whether the profile timestamps set by the agents are trusted.
+	TimestampPolicy  string         `yaml:"timestamp_policy" json:"timestamp_policy"`
+	TimestampMaxSkew model.Duration `yaml:"timestamp_max_skew" json:"timestamp_max_skew"`
 }
 
 // LimitError are errors that do not comply with the limits specified.
@@ -70,6 +99,10 @@ func (e LimitError) Error() string {
 func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Float64Var(&l.IngestionRateMB, "distributor.ingestion-rate-limit-mb", 4, "Per-tenant ingestion rate limit in sample size per second. Units in MB.")
 	f.Float64Var(&l.IngestionBurstSizeMB, "distributor.ingestion-burst-size-mb", 2, "Per-tenant allowed ingestion burst size (in sample size). Units in MB. The burst size refers to the per-distributor local rate limiter, and should be set at least to the maximum profile size expected in a single push request.")
+	f.Float64Var(&l.IngestionQuotaBytesPerMinute, "distributor.ingestion-quota-bytes-per-minute", 0, "Per-tenant usage quota in bytes of uncompressed profiles per minute, enforced across all distributors. Requests exceeding the quota are rejected with 429 and a Retry-After header. 0 to disable.")
+	f.Float64Var(&l.IngestionQuotaProfilesPerMinute, "distributor.ingestion-quota-profiles-per-minute", 0, "Per-tenant usage quota in number of profiles per minute, enforced across all distributors. Requests exceeding the quota are rejected with 429 and a Retry-After header. 0 to disable.")
+
+	f.Var(&l.DistributorAggregationWindow, "distributor.aggregation-window", "Duration of the window within which the delta profiles of the same series are merged by the distributor before they are sent to ingesters. The pushes of a series wait for the window to close and the merged profile to be sent. 0 to disable.")
 
 	f.IntVar(&l.IngestionTenantShardSize, "distributor.ingestion-tenant-shard-size", 0, "The tenant's shard size used by shuffle-sharding. Must be set both on ingesters and distributors. 0 disables shuffle sharding.")
 
@@ -81,6 +114,9 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxLocalSeriesPerTenant, "ingester.max-local-series-per-tenant", 0, "Maximum number of active series of profiles per tenant, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxGlobalSeriesPerTenant, "ingester.max-global-series-per-tenant", 5000, "Maximum number of active series of profiles per tenant, across the cluster. 0 to disable. When the global limit is enabled, each ingester is configured with a dynamic local limit based on the replication factor and the current number of healthy ingesters, and is kept updated whenever the number of ingesters change.")
 
+	f.IntVar(&l.IngesterSplitShards, "ingester.split-shards", 0, "Number of shards each block flushed by the ingester is split into before it is uploaded to the object storage. 0 or 1 to disable.")
+	f.Var(&l.IngesterSeriesIdleTimeout, "ingester.series-idle-timeout", "Duration after which the series of the head that have not received profiles are evicted from the in-memory index of the ingester. The profiles of evicted series are still written to the block and can be queried. The delta state of evicted series is dropped: the next profile of a delta profile type is only used as the base for the following ones. 0 to disable.")
+
 	_ = l.MaxQueryLength.Set("24h")
 	f.Var(&l.MaxQueryLength, "querier.max-query-length", "The limit to length of queries. 0 to disable.")
 
@@ -92,8 +128,17 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	_ = l.QuerySplitDuration.Set("0s")
 	f.Var(&l.QuerySplitDuration, "querier.split-queries-by-interval", "Split queries by a time interval and execute in parallel. The value 0 disables splitting by time")
 
+	f.IntVar(&l.QueryShards, "querier.query-shards", 0, "Number of shards each stacktraces query is split into by the query frontend, on top of the split by time interval. Series are assigned to shards by fingerprint, so a shard count that divides the split-and-merge compactor shard count lets queriers skip the blocks of other shards. 0 or 1 to disable.")
+
+	_ = l.MaxCacheFreshness.Set("1h")
+	f.Var(&l.MaxCacheFreshness, "querier.max-cache-freshness", "Most recent allowed cacheable result per-tenant, to prevent caching very recent results that might still be in flux. The default matches the default of -validation.reject-older-than.")
+
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 0, "Maximum number of queries that will be scheduled in parallel by the frontend.")
 
+	f.IntVar(&l.MaxQueryBlocks, "querier.max-query-blocks", 0, "Maximum number of blocks a stacktraces query may touch on each ingester and store-gateway. Queries exceeding the limit fail, unless they allow partial results: then only the most recent blocks within the limit are merged. 0 to disable.")
+	f.Int64Var(&l.MaxQueryBytes, "querier.max-query-bytes", 0, "Maximum total size in bytes of the blocks a stacktraces query may touch on each ingester and store-gateway. Queries exceeding the limit fail, unless they allow partial results: then only the most recent blocks within the limit are merged. 0 to disable.")
+	f.Var(&l.QueryTimeout, "querier.query-timeout", "Maximum duration of a stacktraces query, enforced in the query frontend. Queries exceeding the limit fail, unless they allow partial results: then the results of the sub-queries completed in time are returned. 0 to disable.")
+
 	f.IntVar(&l.MaxProfileSizeBytes, "validation.max-profile-size-bytes", 4*1024*1024, "Maximum size of a profile in bytes. This is based off the uncompressed size. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceSamples, "validation.max-profile-stacktrace-samples", 16000, "Maximum number of samples in a profile. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceSampleLabels, "validation.max-profile-stacktrace-sample-labels", 100, "Maximum number of labels in a profile sample. 0 to disable.")
@@ -106,6 +151,10 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	_ = l.RejectOlderThan.Set("1h")
 	f.Var(&l.RejectOlderThan, "validation.reject-older-than", "This limits how far into the past profiling data can be ingested. This limit is enforced in the distributor. 0 to disable, defaults to 1h.")
 
+	f.StringVar(&l.TimestampPolicy, "validation.timestamp-policy", TimestampPolicyTrust, "How the distributor handles the timestamps of the profiles set by the agents: 'trust' keeps them, 'clamp' limits their skew from the receive time to the timestamp max skew, 'override' replaces them with the receive time.")
+	_ = l.TimestampMaxSkew.Set("5m")
+	f.Var(&l.TimestampMaxSkew, "validation.timestamp-max-skew", "The maximum skew between the profile timestamp and the receive time, when the timestamp policy is 'clamp'.")
+
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
@@ -130,6 +179,35 @@ func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // Validate validates that this limits config is valid.
 func (l *Limits) Validate() error {
+	switch l.TimestampPolicy {
+	case "", TimestampPolicyTrust, TimestampPolicyClamp, TimestampPolicyOverride:
+	default:
+		return errors.Errorf("invalid timestamp policy %q, must be one of: %s, %s, %s",
+			l.TimestampPolicy, TimestampPolicyTrust, TimestampPolicyClamp, TimestampPolicyOverride)
+	}
+	for name, ratio := range l.IngestionSampleRatio {
+		if ratio < 0 || ratio > 1 {
+			return errors.Errorf("invalid ingestion sample ratio for %q: %v, must be between 0 and 1", name, ratio)
+		}
+	}
+	for name, limit := range l.MaxGlobalSeriesPerProfileType {
+		if limit < 0 {
+			return errors.Errorf("invalid max global series for profile type %q: %d, must not be negative", name, limit)
+		}
+	}
+	if l.StoreGatewayTenantShardSize < 0 {
+		return errors.Errorf("invalid store-gateway tenant shard size: %d, must not be negative", l.StoreGatewayTenantShardSize)
+	}
+	targets := make(map[string]struct{}, len(l.QueryLabelJoins))
+	for _, j := range l.QueryLabelJoins {
+		if err := j.Validate(); err != nil {
+			return err
+		}
+		if _, ok := targets[j.TargetLabel]; ok {
+			return errors.Errorf("invalid query label joins: label %q is joined more than once", j.TargetLabel)
+		}
+		targets[j.TargetLabel] = struct{}{}
+	}
 	return nil
 }
 
@@ -186,6 +264,22 @@ func (o *Overrides) IngestionBurstSizeBytes(tenantID string) int {
 	return int(o.getOverridesForTenant(tenantID).IngestionBurstSizeMB * bytesInMB)
 }
 
+// IngestionQuotaBytesPerMinute returns the usage quota in bytes per minute.
+func (o *Overrides) IngestionQuotaBytesPerMinute(tenantID string) float64 {
+	return o.getOverridesForTenant(tenantID).IngestionQuotaBytesPerMinute
+}
+
+// IngestionQuotaProfilesPerMinute returns the usage quota in profiles per minute.
+func (o *Overrides) IngestionQuotaProfilesPerMinute(tenantID string) float64 {
+	return o.getOverridesForTenant(tenantID).IngestionQuotaProfilesPerMinute
+}
+
+// DistributorAggregationWindow returns the duration of the window within
+// which the distributor merges profiles of the same series.
+func (o *Overrides) DistributorAggregationWindow(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).DistributorAggregationWindow)
+}
+
 // IngestionTenantShardSize returns the ingesters shard size for a given user.
 func (o *Overrides) IngestionTenantShardSize(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).IngestionTenantShardSize
@@ -237,6 +331,16 @@ func (o *Overrides) MaxSessionsPerSeries(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxSessionsPerSeries
 }
 
+// IngestionSampleRatio returns the fraction of profiles kept at ingestion, by profile name.
+func (o *Overrides) IngestionSampleRatio(tenantID string) map[string]float64 {
+	return o.getOverridesForTenant(tenantID).IngestionSampleRatio
+}
+
+// IngestionRelabelingRules returns the relabeling rules applied to the series labels at ingestion.
+func (o *Overrides) IngestionRelabelingRules(tenantID string) []*relabel.Config {
+	return o.getOverridesForTenant(tenantID).IngestionRelabelingRules
+}
+
 // MaxLocalSeriesPerTenant returns the maximum number of series a tenant is allowed to store
 // in a single ingester.
 func (o *Overrides) MaxLocalSeriesPerTenant(tenantID string) int {
@@ -249,6 +353,23 @@ func (o *Overrides) MaxGlobalSeriesPerTenant(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxGlobalSeriesPerTenant
 }
 
+// MaxGlobalSeriesPerProfileType returns the maximum number of active series
+// across the cluster, by profile name.
+func (o *Overrides) MaxGlobalSeriesPerProfileType(tenantID string) map[string]int {
+	return o.getOverridesForTenant(tenantID).MaxGlobalSeriesPerProfileType
+}
+
+// IngesterSplitShards returns the number of shards the ingester splits flushed blocks into.
+func (o *Overrides) IngesterSplitShards(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).IngesterSplitShards
+}
+
+// IngesterSeriesIdleTimeout returns the duration after which idle series
+// are evicted from the head index of the ingester.
+func (o *Overrides) IngesterSeriesIdleTimeout(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).IngesterSeriesIdleTimeout)
+}
+
 // MaxQueryLength returns the limit of the length (in time) of a query.
 func (o *Overrides) MaxQueryLength(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).MaxQueryLength)
@@ -260,6 +381,28 @@ func (o *Overrides) MaxQueryParallelism(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxQueryParallelism
 }
 
+// MaxQueryBlocks returns the limit to the number of blocks a query may
+// touch on a single ingester or store-gateway.
+func (o *Overrides) MaxQueryBlocks(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQueryBlocks
+}
+
+// MaxQueryBytes returns the limit to the total size of the blocks a query
+// may touch on a single ingester or store-gateway.
+func (o *Overrides) MaxQueryBytes(tenantID string) int64 {
+	return o.getOverridesForTenant(tenantID).MaxQueryBytes
+}
+
+// QueryTimeout returns the limit to the duration of a query.
+func (o *Overrides) QueryTimeout(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).QueryTimeout)
+}
+
+// QueryLabelJoins returns the labels joined at query time against external mappings.
+func (o *Overrides) QueryLabelJoins(tenantID string) []*LabelJoin {
+	return o.getOverridesForTenant(tenantID).QueryLabelJoins
+}
+
 // MaxQueryLookback returns the max lookback period of queries.
 func (o *Overrides) MaxQueryLookback(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).MaxQueryLookback)
@@ -270,11 +413,35 @@ func (o *Overrides) StoreGatewayTenantShardSize(userID string) int {
 	return o.getOverridesForTenant(userID).StoreGatewayTenantShardSize
 }
 
+// BlocksEncryptionKeyID returns the ID of the master key the blocks of the
+// tenant are encrypted with, or an empty string if they aren't encrypted.
+func (o *Overrides) BlocksEncryptionKeyID(tenantID string) string {
+	return o.getOverridesForTenant(tenantID).BlocksEncryptionKeyID
+}
+
+// BlocksRetentionClass returns the retention class the objects of the blocks
+// of the tenant are tagged with.
+func (o *Overrides) BlocksRetentionClass(tenantID string) string {
+	return o.getOverridesForTenant(tenantID).BlocksRetentionClass
+}
+
 // QuerySplitDuration returns the tenant specific split by interval applied in the query frontend.
 func (o *Overrides) QuerySplitDuration(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).QuerySplitDuration)
 }
 
+// QueryShards returns the tenant specific number of shards stacktraces
+// queries are split into in the query frontend.
+func (o *Overrides) QueryShards(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).QueryShards
+}
+
+// MaxCacheFreshness returns the period after which results are cacheable,
+// to prevent caching of very recent results.
+func (o *Overrides) MaxCacheFreshness(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).MaxCacheFreshness)
+}
+
 // MaxQueriersPerTenant returns the limit to the number of queriers that can be used
 // Shuffle sharding will be used to distribute queries across queriers.
 // 0 means no limit. Currently disabled.
@@ -290,6 +457,16 @@ func (o *Overrides) RejectOlderThan(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).RejectOlderThan)
 }
 
+// TimestampPolicy returns how the profile timestamps set by the agents are handled.
+func (o *Overrides) TimestampPolicy(tenantID string) string {
+	return o.getOverridesForTenant(tenantID).TimestampPolicy
+}
+
+// TimestampMaxSkew returns the maximum skew of the profile timestamps, when they are clamped.
+func (o *Overrides) TimestampMaxSkew(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).TimestampMaxSkew)
+}
+
 func (o *Overrides) DefaultLimits() *Limits {
 	return o.defaultLimits
 }
@@ -158,3 +158,56 @@ func TestOverwriteMarshalingStringMapYAML(t *testing.T) {
 	require.Nil(t, yaml.Unmarshal(out, &back))
 	require.Equal(t, m, back)
 }
+
+func TestLimitsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		limits Limits
+		err    string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:   "store-gateway tenant shard size",
+			limits: Limits{StoreGatewayTenantShardSize: 3},
+		},
+		{
+			name:   "negative store-gateway tenant shard size",
+			limits: Limits{StoreGatewayTenantShardSize: -1},
+			err:    "invalid store-gateway tenant shard size: -1, must not be negative",
+		},
+		{
+			name:   "invalid timestamp policy",
+			limits: Limits{TimestampPolicy: "foo"},
+			err:    `invalid timestamp policy "foo"`,
+		},
+		{
+			name:   "invalid ingestion sample ratio",
+			limits: Limits{IngestionSampleRatio: map[string]float64{"wall": 1.5}},
+			err:    `invalid ingestion sample ratio for "wall": 1.5`,
+		},
+		{
+			name:   "negative max global series per profile type",
+			limits: Limits{MaxGlobalSeriesPerProfileType: map[string]int{"process_cpu": -1}},
+			err:    `invalid max global series for profile type "process_cpu": -1`,
+		},
+		{
+			name: "label joined twice",
+			limits: Limits{QueryLabelJoins: []*LabelJoin{
+				{SourceLabel: "pod", TargetLabel: "team", URL: "http://a"},
+				{SourceLabel: "namespace", TargetLabel: "team", URL: "http://b"},
+			}},
+			err: `invalid query label joins: label "team" is joined more than once`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.limits.Validate()
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
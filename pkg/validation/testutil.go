@@ -4,16 +4,27 @@ import "time"
 
 type MockLimits struct {
 	QuerySplitDurationValue     time.Duration
+	QueryShardsValue            int
 	MaxQueryParallelismValue    int
 	MaxQueryLengthValue         time.Duration
 	MaxQueryLookbackValue       time.Duration
+	MaxCacheFreshnessValue      time.Duration
+	MaxQueryBlocksValue         int
+	MaxQueryBytesValue          int64
+	QueryTimeoutValue           time.Duration
+	QueryLabelJoinsValue        []*LabelJoin
 	MaxLabelNameLengthValue     int
 	MaxLabelValueLengthValue    int
 	MaxLabelNamesPerSeriesValue int
 
+	IngestionTenantShardSizeValue int
+
 	RejectOlderThanValue time.Duration
 	RejectNewerThanValue time.Duration
 
+	TimestampPolicyValue  string
+	TimestampMaxSkewValue time.Duration
+
 	MaxProfileSizeBytesValue              int
 	MaxProfileStacktraceSamplesValue      int
 	MaxProfileStacktraceDepthValue        int
@@ -22,13 +33,25 @@ type MockLimits struct {
 }
 
 func (m MockLimits) QuerySplitDuration(string) time.Duration        { return m.QuerySplitDurationValue }
+func (m MockLimits) QueryShards(string) int                         { return m.QueryShardsValue }
 func (m MockLimits) MaxQueryParallelism(string) int                 { return m.MaxQueryParallelismValue }
 func (m MockLimits) MaxQueryLength(tenantID string) time.Duration   { return m.MaxQueryLengthValue }
 func (m MockLimits) MaxQueryLookback(tenantID string) time.Duration { return m.MaxQueryLookbackValue }
-func (m MockLimits) MaxLabelNameLength(userID string) int           { return m.MaxLabelNameLengthValue }
-func (m MockLimits) MaxLabelValueLength(userID string) int          { return m.MaxLabelValueLengthValue }
-func (m MockLimits) MaxLabelNamesPerSeries(userID string) int       { return m.MaxLabelNamesPerSeriesValue }
-func (m MockLimits) MaxProfileSizeBytes(userID string) int          { return m.MaxProfileSizeBytesValue }
+func (m MockLimits) MaxCacheFreshness(tenantID string) time.Duration {
+	return m.MaxCacheFreshnessValue
+}
+
+func (m MockLimits) MaxQueryBlocks(tenantID string) int         { return m.MaxQueryBlocksValue }
+func (m MockLimits) MaxQueryBytes(tenantID string) int64        { return m.MaxQueryBytesValue }
+func (m MockLimits) QueryTimeout(tenantID string) time.Duration { return m.QueryTimeoutValue }
+func (m MockLimits) QueryLabelJoins(tenantID string) []*LabelJoin {
+	return m.QueryLabelJoinsValue
+}
+
+func (m MockLimits) MaxLabelNameLength(userID string) int     { return m.MaxLabelNameLengthValue }
+func (m MockLimits) MaxLabelValueLength(userID string) int    { return m.MaxLabelValueLengthValue }
+func (m MockLimits) MaxLabelNamesPerSeries(userID string) int { return m.MaxLabelNamesPerSeriesValue }
+func (m MockLimits) MaxProfileSizeBytes(userID string) int    { return m.MaxProfileSizeBytesValue }
 func (m MockLimits) MaxProfileStacktraceSamples(userID string) int {
 	return m.MaxProfileStacktraceSamplesValue
 }
@@ -52,3 +75,15 @@ func (m MockLimits) RejectOlderThan(userID string) time.Duration {
 func (m MockLimits) RejectNewerThan(userID string) time.Duration {
 	return m.RejectNewerThanValue
 }
+
+func (m MockLimits) TimestampPolicy(userID string) string {
+	return m.TimestampPolicyValue
+}
+
+func (m MockLimits) TimestampMaxSkew(userID string) time.Duration {
+	return m.TimestampMaxSkewValue
+}
+
+func (m MockLimits) IngestionTenantShardSize(userID string) int {
+	return m.IngestionTenantShardSizeValue
+}
@@ -3,10 +3,14 @@
 package validation
 
 import (
+	"errors"
 	"net/http"
 
-	"github.com/grafana/dskit/tenant"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
 	httputil "github.com/grafana/pyroscope/pkg/util/http"
 )
@@ -17,13 +21,18 @@ type TenantLimitsResponse struct {
 	IngestionBurstSize       int     `json:"ingestion_burst_size"`
 	MaxGlobalSeriesPerTenant int     `json:"max_global_series_per_user"`
 
+	MaxGlobalSeriesPerProfileType map[string]int `json:"max_global_series_per_profile_type,omitempty"`
+	MaxLabelNamesPerSeries        int            `json:"max_label_names_per_series"`
+	MaxLabelNameLength            int            `json:"max_label_name_length"`
+	MaxLabelValueLength           int            `json:"max_label_value_length"`
+
 	// todo
 }
 
 // TenantLimitsHandler handles user limits.
 func TenantLimitsHandler(defaultLimits Limits, tenantLimits TenantLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID, err := tenant.TenantID(r.Context())
+		userID, err := tenant.ExtractTenantIDFromContext(r.Context())
 		if err != nil {
 			httputil.ErrorWithStatus(w, err, http.StatusUnauthorized)
 			return
@@ -39,8 +48,109 @@ func TenantLimitsHandler(defaultLimits Limits, tenantLimits TenantLimits) http.H
 			IngestionRate:            userLimits.IngestionRateMB,
 			IngestionBurstSize:       int(userLimits.IngestionBurstSizeMB),
 			MaxGlobalSeriesPerTenant: userLimits.MaxGlobalSeriesPerTenant,
+
+			MaxGlobalSeriesPerProfileType: userLimits.MaxGlobalSeriesPerProfileType,
+			MaxLabelNamesPerSeries:        userLimits.MaxLabelNamesPerSeries,
+			MaxLabelNameLength:            userLimits.MaxLabelNameLength,
+			MaxLabelValueLength:           userLimits.MaxLabelValueLength,
 		}
 
 		util.WriteJSONResponse(w, limits)
 	}
 }
+
+type TenantLimitsInEffectResponse struct {
+	TenantID string `json:"tenant_id"`
+	// Overridden is true if the tenant has overrides in the runtime config.
+	// Otherwise the limits are the defaults.
+	Overridden bool   `json:"overridden"`
+	Limits     Limits `json:"limits"`
+}
+
+// TenantLimitsInEffectHandler returns all the limits in effect for the
+// tenant of the tenant path variable, which must be the tenant of the
+// request. Unlike TenantLimitsHandler, it's meant for the operators.
+func TenantLimitsInEffectHandler(defaultLimits Limits, tenantLimits TenantLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := mux.Vars(r)["tenant"]
+		if tenantID == "" {
+			httputil.ErrorWithStatus(w, errors.New("tenant ID can't be empty"), http.StatusBadRequest)
+			return
+		}
+		if err := tenant.CheckTenant(r.Context(), tenantID); err != nil {
+			httputil.ErrorWithStatus(w, err, http.StatusForbidden)
+			return
+		}
+		resp := TenantLimitsInEffectResponse{TenantID: tenantID, Limits: defaultLimits}
+		if l := tenantLimits.TenantLimits(tenantID); l != nil {
+			resp.Overridden = true
+			resp.Limits = *l
+		}
+		util.WriteJSONResponse(w, resp)
+	}
+}
+
+type TenantUsageResponse struct {
+	// Discarded profiles and bytes by reason, since the process started.
+	DiscardedSamples map[string]float64 `json:"discarded_samples"`
+	DiscardedBytes   map[string]float64 `json:"discarded_bytes"`
+}
+
+// TenantUsageHandler reports the profiles of the tenant the process
+// discarded, by reason. The distributors and the ingesters discard
+// profiles, the usage of each of them is reported separately.
+func TenantUsageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := tenant.ExtractTenantIDFromContext(r.Context())
+		if err != nil {
+			httputil.ErrorWithStatus(w, err, http.StatusUnauthorized)
+			return
+		}
+		util.WriteJSONResponse(w, TenantUsageResponse{
+			DiscardedSamples: DiscardedProfilesByReason(userID),
+			DiscardedBytes:   DiscardedBytesByReason(userID),
+		})
+	}
+}
+
+// DiscardedProfilesByReason returns the number of profiles of the tenant
+// the process discarded, by reason.
+func DiscardedProfilesByReason(tenantID string) map[string]float64 {
+	return discardedByReason(DiscardedProfiles, tenantID)
+}
+
+// DiscardedBytesByReason returns the number of bytes of the tenant the
+// process discarded, by reason.
+func DiscardedBytesByReason(tenantID string) map[string]float64 {
+	return discardedByReason(DiscardedBytes, tenantID)
+}
+
+// discardedByReason returns the values of the discarded counter of the
+// tenant, by reason.
+func discardedByReason(c *prometheus.CounterVec, tenantID string) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	res := make(map[string]float64)
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			continue
+		}
+		var reason, metricTenant string
+		for _, l := range metric.GetLabel() {
+			switch l.GetName() {
+			case ReasonLabel:
+				reason = l.GetValue()
+			case "tenant":
+				metricTenant = l.GetValue()
+			}
+		}
+		if metricTenant == tenantID {
+			res[reason] += metric.GetCounter().GetValue()
+		}
+	}
+	return res
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/grafana/dskit/user"
 	"github.com/stretchr/testify/require"
 )
@@ -76,3 +77,72 @@ func TestTenantLimitsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestTenantUsageHandler(t *testing.T) {
+	DiscardedProfiles.WithLabelValues(string(SeriesPerProfileTypeLimit), "usage-test").Add(3)
+	DiscardedProfiles.WithLabelValues(string(LabelValueTooLong), "usage-test").Add(1)
+	DiscardedBytes.WithLabelValues(string(SeriesPerProfileTypeLimit), "usage-test").Add(300)
+	DiscardedProfiles.WithLabelValues(string(SeriesPerProfileTypeLimit), "usage-other").Add(5)
+
+	request := httptest.NewRequest("GET", "/api/v1/tenant_usage", nil)
+	request = request.WithContext(user.InjectOrgID(context.Background(), "usage-test"))
+	recorder := httptest.NewRecorder()
+	TenantUsageHandler().ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+
+	var response TenantUsageResponse
+	require.NoError(t, json.NewDecoder(recorder.Result().Body).Decode(&response))
+	require.Equal(t, TenantUsageResponse{
+		DiscardedSamples: map[string]float64{
+			string(SeriesPerProfileTypeLimit): 3,
+			string(LabelValueTooLong):         1,
+		},
+		DiscardedBytes: map[string]float64{
+			string(SeriesPerProfileTypeLimit): 300,
+		},
+	}, response)
+
+	recorder = httptest.NewRecorder()
+	TenantUsageHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v1/tenant_usage", nil))
+	require.Equal(t, http.StatusUnauthorized, recorder.Result().StatusCode)
+}
+
+func TestTenantLimitsInEffectHandler(t *testing.T) {
+	defaults := Limits{IngestionRateMB: 100, MaxQueryParallelism: 32}
+	overrides := defaults
+	overrides.IngestionRateMB = 200
+	handler := TenantLimitsInEffectHandler(defaults, NewMockTenantLimits(map[string]*Limits{
+		"test-with-override": &overrides,
+	}))
+
+	for _, tc := range []struct {
+		tenantID           string
+		expectedOverridden bool
+		expectedRate       float64
+	}{
+		{tenantID: "test-with-override", expectedOverridden: true, expectedRate: 200},
+		{tenantID: "test-no-override", expectedOverridden: false, expectedRate: 100},
+	} {
+		t.Run(tc.tenantID, func(t *testing.T) {
+			request := mux.SetURLVars(httptest.NewRequest("GET", "/admin/api/v1/tenants/"+tc.tenantID+"/limits", nil), map[string]string{"tenant": tc.tenantID})
+			request = request.WithContext(user.InjectOrgID(request.Context(), tc.tenantID))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			require.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+
+			var response TenantLimitsInEffectResponse
+			require.NoError(t, json.NewDecoder(recorder.Result().Body).Decode(&response))
+			require.Equal(t, tc.tenantID, response.TenantID)
+			require.Equal(t, tc.expectedOverridden, response.Overridden)
+			require.Equal(t, tc.expectedRate, response.Limits.IngestionRateMB)
+			require.Equal(t, 32, response.Limits.MaxQueryParallelism)
+		})
+	}
+
+	// The limits of the other tenants are not returned.
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/admin/api/v1/tenants/test-with-override/limits", nil), map[string]string{"tenant": "test-with-override"})
+	request = request.WithContext(user.InjectOrgID(request.Context(), "test-no-override"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+}
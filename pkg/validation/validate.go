@@ -2,6 +2,7 @@ package validation
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"time"
@@ -51,6 +52,14 @@ const (
 	ProfileSizeLimit  Reason = "profile_size_limit"
 	SampleLabelsLimit Reason = "sample_labels_limit"
 	MalformedProfile  Reason = "malformed_profile"
+	// DroppedByRelabelRules is a reason for discarding profiles which series were dropped by the relabeling rules.
+	DroppedByRelabelRules Reason = "dropped_by_relabel_rules"
+	// OutOfOrder is a reason for discarding profiles which are older than the
+	// latest profile of the ingester head by more than the out-of-order window.
+	OutOfOrder Reason = "out_of_order"
+	// SeriesPerProfileTypeLimit is a reason for discarding profiles when the limit
+	// of active series of the profile type has been reached.
+	SeriesPerProfileTypeLimit Reason = "series_per_profile_type_limit"
 
 	SeriesLimitErrorMsg                = "Maximum active series limit exceeded (%d/%d), reduce the number of active streams (reduce labels or reduce label values), or contact your administrator to see if the limit can be increased"
 	MissingLabelsErrorMsg              = "error at least one label pair is required per profile"
@@ -64,6 +73,8 @@ const (
 	ProfileTooManySamplesErrorMsg      = "the profile with labels '%s' exceeds the samples count limit (max_profile_stacktrace_samples, actual: %d, limit: %d)"
 	ProfileTooManySampleLabelsErrorMsg = "the profile with labels '%s' exceeds the sample labels limit (max_profile_stacktrace_sample_labels, actual: %d, limit: %d)"
 	NotInIngestionWindowErrorMsg       = "profile with labels '%s' is outside of ingestion window (profile timestamp: %s, %s)"
+	OutOfOrderErrorMsg                 = "profile with labels '%s' is out of order (profile timestamp: %s, the out-of-order window starts at %s)"
+	SeriesPerProfileTypeLimitErrorMsg  = "Maximum active series limit exceeded for profile type '%s' (%d/%d), reduce the number of active streams (reduce labels or reduce label values), or contact your administrator to see if the limit can be increased"
 )
 
 var (
@@ -86,6 +97,29 @@ var (
 		},
 		[]string{ReasonLabel, "tenant"},
 	)
+
+	// TimestampSkew is a metric of the skew between the profile timestamps
+	// set by the agents and the receive time, by service.
+	TimestampSkew = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "pyroscope",
+			Name:      "profile_timestamp_skew_seconds",
+			Help:      "The absolute difference between the profile timestamp and the time the profile is received.",
+			Buckets:   []float64{1, 10, 60, 300, 600, 1800, 3600, 6 * 3600, 24 * 3600},
+		},
+		[]string{"tenant", "service_name"},
+	)
+
+	// CorrectedTimestamps is a metric of the number of profile timestamps
+	// changed by the timestamp policy.
+	CorrectedTimestamps = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "corrected_profile_timestamps_total",
+			Help:      "The total number of profiles which timestamp was changed by the timestamp policy.",
+		},
+		[]string{"policy", "tenant"},
+	)
 )
 
 type LabelValidationLimits interface {
@@ -141,6 +175,43 @@ type ProfileValidationLimits interface {
 	MaxProfileSymbolValueLength(tenantID string) int
 	RejectNewerThan(tenantID string) time.Duration
 	RejectOlderThan(tenantID string) time.Duration
+	TimestampPolicy(tenantID string) string
+	TimestampMaxSkew(tenantID string) time.Duration
+}
+
+const (
+	TimestampPolicyTrust    = "trust"
+	TimestampPolicyClamp    = "clamp"
+	TimestampPolicyOverride = "override"
+)
+
+// correctTimestamp applies the timestamp policy of the tenant to the
+// profile timestamp, and reports the skew from the receive time.
+func correctTimestamp(limits ProfileValidationLimits, tenantID string, timeNanos int64, ls phlaremodel.Labels, now model.Time) int64 {
+	policy := limits.TimestampPolicy(tenantID)
+	skew := time.Duration(timeNanos - now.UnixNano())
+	TimestampSkew.WithLabelValues(tenantID, ls.Get(phlaremodel.LabelNameServiceName)).Observe(math.Abs(skew.Seconds()))
+	switch policy {
+	case TimestampPolicyOverride:
+		if skew != 0 {
+			CorrectedTimestamps.WithLabelValues(policy, tenantID).Inc()
+		}
+		return now.UnixNano()
+	case TimestampPolicyClamp:
+		maxSkew := limits.TimestampMaxSkew(tenantID)
+		if maxSkew <= 0 {
+			break
+		}
+		switch {
+		case skew > maxSkew:
+			CorrectedTimestamps.WithLabelValues(policy, tenantID).Inc()
+			return now.UnixNano() + int64(maxSkew)
+		case skew < -maxSkew:
+			CorrectedTimestamps.WithLabelValues(policy, tenantID).Inc()
+			return now.UnixNano() - int64(maxSkew)
+		}
+	}
+	return timeNanos
 }
 
 type ingestionWindow struct {
@@ -183,6 +254,7 @@ func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *goog
 	}
 
 	if prof.TimeNanos > 0 {
+		prof.TimeNanos = correctTimestamp(limits, tenantID, prof.TimeNanos, ls, now)
 		// check profile timestamp within ingestion window
 		if err := newIngestionWindow(limits, tenantID, now).valid(model.TimeFromUnixNano(prof.TimeNanos), ls); err != nil {
 			return err
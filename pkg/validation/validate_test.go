@@ -315,6 +315,49 @@ func TestValidateProfile(t *testing.T) {
 				RejectNewerThanValue: 10 * time.Minute,
 			},
 		},
+		{
+			name: "clamp timestamp skew",
+			profile: &googlev1.Profile{
+				TimeNanos: now.Add(1 * time.Hour).UnixNano(),
+			},
+			limits: MockLimits{
+				RejectNewerThanValue:  10 * time.Minute,
+				TimestampPolicyValue:  TimestampPolicyClamp,
+				TimestampMaxSkewValue: 5 * time.Minute,
+			},
+			assert: func(t *testing.T, profile *googlev1.Profile) {
+				t.Helper()
+				require.Equal(t, now.Add(5*time.Minute).UnixNano(), profile.TimeNanos)
+			},
+		},
+		{
+			name: "clamp timestamp within skew",
+			profile: &googlev1.Profile{
+				TimeNanos: now.Add(-1 * time.Minute).UnixNano(),
+			},
+			limits: MockLimits{
+				TimestampPolicyValue:  TimestampPolicyClamp,
+				TimestampMaxSkewValue: 5 * time.Minute,
+			},
+			assert: func(t *testing.T, profile *googlev1.Profile) {
+				t.Helper()
+				require.Equal(t, now.Add(-1*time.Minute).UnixNano(), profile.TimeNanos)
+			},
+		},
+		{
+			name: "override timestamp",
+			profile: &googlev1.Profile{
+				TimeNanos: now.Add(-2 * time.Hour).UnixNano(),
+			},
+			limits: MockLimits{
+				RejectOlderThanValue: time.Hour,
+				TimestampPolicyValue: TimestampPolicyOverride,
+			},
+			assert: func(t *testing.T, profile *googlev1.Profile) {
+				t.Helper()
+				require.Equal(t, now.UnixNano(), profile.TimeNanos)
+			},
+		},
 		{
 			name:    "without timestamp",
 			profile: &googlev1.Profile{},
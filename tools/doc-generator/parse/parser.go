@@ -21,6 +21,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/relabel"
+
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 const (
@@ -28,6 +30,7 @@ const (
 	typeString        = "string"
 	typeDuration      = "duration"
 	typeRelabelConfig = "relabel_config..."
+	typeLabelJoin     = "label_join..."
 )
 
 var (
@@ -342,6 +345,8 @@ func getFieldCustomType(t reflect.Type) (string, bool) {
 		return typeString, true
 	case reflect.TypeOf([]*relabel.Config{}).String():
 		return typeRelabelConfig, true
+	case reflect.TypeOf([]*validation.LabelJoin{}).String():
+		return typeLabelJoin, true
 	default:
 		return "", false
 	}
@@ -420,6 +425,8 @@ func getCustomFieldType(t reflect.Type) (string, bool) {
 		return typeString, true
 	case reflect.TypeOf([]*relabel.Config{}).String():
 		return typeRelabelConfig, true
+	case reflect.TypeOf([]*validation.LabelJoin{}).String():
+		return typeLabelJoin, true
 	default:
 		return "", false
 	}
@@ -449,6 +456,8 @@ func ReflectType(typ string) reflect.Type {
 		return reflect.TypeOf(map[string]string{})
 	case typeRelabelConfig:
 		return reflect.TypeOf([]*relabel.Config{})
+	case typeLabelJoin:
+		return reflect.TypeOf([]*validation.LabelJoin{})
 	case "map of string to float64":
 		return reflect.TypeOf(map[string]float64{})
 	default: